@@ -0,0 +1,1098 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/google/uuid"
+	"github.com/opencord/voltha/protos/go/ponsim"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	default_server  = "localhost:50060"
+	default_timeout = 10
+	default_token   = ""
+)
+
+var (
+	server  string = default_server
+	timeout int    = default_timeout
+	token   string = default_token
+)
+
+/*
+command is a subcommand of the CLI, which sends a request to the simulator and returns the reply
+*/
+type command struct {
+	Usage string
+	Help  string
+	Run   func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error)
+}
+
+var commands = map[string]command{
+	"info": {
+		Usage: "info",
+		Help:  "Show the device and its registered ONUs",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			return voltha.NewPonSimClient(conn).GetDeviceInfo(ctx, &empty.Empty{})
+		},
+	},
+	"version": {
+		Usage: "version",
+		Help:  "Show the version, commit and Go version of the simulator and its active features",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			return ponsim.NewPonSimAdminClient(conn).GetVersion(ctx, &empty.Empty{})
+		},
+	},
+	"stats": {
+		Usage: "stats",
+		Help:  "Show the statistics of the device",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			return voltha.NewPonSimClient(conn).GetStats(ctx, &empty.Empty{})
+		},
+	},
+	"flows": {
+		Usage: "flows [port]",
+		Help:  "Dump the flows of the device, or of the ONU registered on a port of the OLT",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			port, err := intArg(args, 0, 0)
+			if err != nil {
+				return nil, err
+			}
+			return voltha.NewPonSimClient(conn).GetFlowStats(ctx, &voltha.PonSimPort{Port: int32(port)})
+		},
+	},
+	"add-onu": {
+		Usage: "add-onu address port serial_number [registration_id]",
+		Help:  "Register the ONU serving GRPC requests on an address and port with the OLT",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			if len(args) < 3 {
+				return nil, fmt.Errorf("missing arguments")
+			}
+			port, err := intArg(args, 1, 0)
+			if err != nil {
+				return nil, err
+			}
+			request := &ponsim.RegistrationRequest{
+				Id:           uuid.New().String(),
+				Address:      args[0],
+				Port:         int32(port),
+				SerialNumber: args[2],
+			}
+			if len(args) > 3 {
+				request.RegistrationId = args[3]
+			}
+			return ponsim.NewPonSimOltClient(conn).Register(ctx, request)
+		},
+	},
+	"remove-onu": {
+		Usage: "remove-onu port",
+		Help:  "Remove the ONU registered on a port of the OLT",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			port, err := intArg(args, 0, -1)
+			if err != nil {
+				return nil, err
+			}
+			return ponsim.NewPonSimAdminClient(conn).RemoveOnu(ctx, &ponsim.OnuRequest{Port: int32(port)})
+		},
+	},
+	"discovered": {
+		Usage: "discovered",
+		Help:  "List the ONUs which announced their serial number to the OLT",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			return ponsim.NewPonSimAdminClient(conn).ListDiscoveredOnus(ctx, &empty.Empty{})
+		},
+	},
+	"activate": {
+		Usage: "activate serial_number",
+		Help:  "Activate a discovered ONU so that it completes its registration with the OLT",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			if len(args) < 1 {
+				return nil, fmt.Errorf("missing argument 1")
+			}
+			return ponsim.NewPonSimAdminClient(conn).ActivateOnu(ctx, &ponsim.OnuActivationRequest{SerialNumber: args[0]})
+		},
+	},
+	"alarm": {
+		Usage: "alarm [-severity name] [-type name] [-category name] [-duration ms]",
+		Help:  "Raise an alarm on the OLT and clear it after a duration, random attributes are used if not set",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			request := &ponsim.AlarmRequest{}
+
+			flags := flag.NewFlagSet("alarm", flag.ContinueOnError)
+			flags.StringVar(&request.Severity, "severity", "", "Severity of the alarm, e.g. MAJOR")
+			flags.StringVar(&request.Type, "type", "", "Type of the alarm, e.g. EQUIPMENT")
+			flags.StringVar(&request.Category, "category", "", "Category of the alarm, e.g. PON")
+			duration := flags.Uint("duration", 0, "Time before the alarm is cleared (in milliseconds)")
+			if err := flags.Parse(args); err != nil {
+				return nil, err
+			}
+			request.DurationMs = uint32(*duration)
+
+			return ponsim.NewPonSimAdminClient(conn).TriggerAlarm(ctx, request)
+		},
+	},
+	"flap": {
+		Usage: "flap port [duration_ms]",
+		Help:  "Take a port (1: PON, 2: NNI or UNI) down and bring it back up after a duration",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			port, err := intArg(args, 0, -1)
+			if err != nil {
+				return nil, err
+			}
+			duration, err := intArg(args, 1, 1000)
+			if err != nil {
+				return nil, err
+			}
+			return ponsim.NewPonSimAdminClient(conn).FlapPort(ctx, &ponsim.PortFlap{
+				Port:       int32(port),
+				DurationMs: uint32(duration),
+			})
+		},
+	},
+	"traffic": {
+		Usage: "traffic [-port n] [-rate fps] [-count n] [-size bytes] [-vlans outer,inner] [-pcp n] [-src_mac mac] [-dst_mac mac] [-src_ip ip] [-dst_ip ip] [-protocol udp|tcp] [-src_port n] [-dst_port n]",
+		Help:  "Originate synthetic frames on the NNI of the OLT, or on the UNI of the ONU on a port, as a job",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			request := &ponsim.TrafficRequest{}
+
+			flags := flag.NewFlagSet("traffic", flag.ContinueOnError)
+			port := flags.Int("port", 0, "Port of the ONU whose UNI originates the frames, 0 for the NNI of the OLT")
+			rate := flags.Uint("rate", 0, "Frames per second (100 if not set)")
+			count := flags.Uint("count", 0, "Frames to send, until cancelled if not set")
+			size := flags.Uint("size", 0, "Frame size in bytes (64 if not set)")
+			vlans := flags.String("vlans", "", "VLANs separated by commas from the outer one, untagged if not set")
+			pcp := flags.Uint("pcp", 0, "Priority of the VLAN tags")
+			flags.StringVar(&request.SrcMac, "src_mac", "", "Source MAC address")
+			flags.StringVar(&request.DstMac, "dst_mac", "", "Destination MAC address")
+			flags.StringVar(&request.SrcIp, "src_ip", "", "Source IPv4 or IPv6 address")
+			flags.StringVar(&request.DstIp, "dst_ip", "", "Destination IPv4 or IPv6 address")
+			flags.StringVar(&request.Protocol, "protocol", "", "Transport protocol, udp or tcp")
+			srcPort := flags.Uint("src_port", 0, "Source UDP or TCP port")
+			dstPort := flags.Uint("dst_port", 0, "Destination UDP or TCP port")
+			if err := flags.Parse(args); err != nil {
+				return nil, err
+			}
+
+			request.Port = int32(*port)
+			request.Rate = uint32(*rate)
+			request.Count = uint32(*count)
+			request.FrameSize = uint32(*size)
+			request.Pcp = uint32(*pcp)
+			request.SrcPort = uint32(*srcPort)
+			request.DstPort = uint32(*dstPort)
+			for _, vlan := range strings.Split(*vlans, ",") {
+				if vlan == "" {
+					continue
+				}
+				vid, err := strconv.ParseUint(vlan, 10, 16)
+				if err != nil {
+					return nil, fmt.Errorf("invalid VLAN: %s", vlan)
+				}
+				request.Vlans = append(request.Vlans, uint32(vid))
+			}
+
+			return ponsim.NewPonSimAdminClient(conn).StartTraffic(ctx, request)
+		},
+	},
+	"pcap-replay": {
+		Usage: "pcap-replay [-port n] [-speed factor] [-top_speed] [-loop] file",
+		Help:  "Replay a capture file of the capture directory on the NNI of the OLT, or on the UNI of the ONU on a port, as a job",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			request := &ponsim.PcapReplayRequest{}
+
+			flags := flag.NewFlagSet("pcap-replay", flag.ContinueOnError)
+			port := flags.Int("port", 0, "Port of the ONU whose UNI replays the frames, 0 for the NNI of the OLT")
+			flags.Float64Var(&request.Speed, "speed", 0, "Factor accelerating the original timing (1 if not set)")
+			flags.BoolVar(&request.TopSpeed, "top_speed", false, "Send the frames as fast as possible")
+			flags.BoolVar(&request.Loop, "loop", false, "Replay the file until cancelled")
+			if err := flags.Parse(args); err != nil {
+				return nil, err
+			}
+			if flags.NArg() < 1 {
+				return nil, fmt.Errorf("missing capture file")
+			}
+
+			request.Port = int32(*port)
+			request.File = flags.Arg(0)
+
+			return ponsim.NewPonSimAdminClient(conn).StartPcapReplay(ctx, request)
+		},
+	},
+	"job": {
+		Usage: "job id [port]",
+		Help:  "Show the progress and outcome of a job, run by the ONU on a port for the traffic originated or replayed on its UNI",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			if len(args) < 1 {
+				return nil, fmt.Errorf("missing argument 1")
+			}
+			port, err := intArg(args, 1, 0)
+			if err != nil {
+				return nil, err
+			}
+			return ponsim.NewPonSimAdminClient(conn).GetJob(ctx, &ponsim.JobRequest{Id: args[0], Port: int32(port)})
+		},
+	},
+	"cancel-job": {
+		Usage: "cancel-job id [port]",
+		Help:  "Cancel a running job, run by the ONU on a port for the traffic originated or replayed on its UNI",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			if len(args) < 1 {
+				return nil, fmt.Errorf("missing argument 1")
+			}
+			port, err := intArg(args, 1, 0)
+			if err != nil {
+				return nil, err
+			}
+			return ponsim.NewPonSimAdminClient(conn).CancelJob(ctx, &ponsim.JobRequest{Id: args[0], Port: int32(port)})
+		},
+	},
+	"lag": {
+		Usage: "lag [member up|down]",
+		Help:  "Show the status of the NNI link aggregation group, or fail or restore one of its members",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			client := ponsim.NewPonSimAdminClient(conn)
+			if len(args) == 0 {
+				return client.GetLag(ctx, &empty.Empty{})
+			}
+
+			member, err := intArg(args, 0, -1)
+			if err != nil {
+				return nil, err
+			}
+			if len(args) < 2 || (args[1] != "up" && args[1] != "down") {
+				return nil, fmt.Errorf("expected the status of the member, up or down")
+			}
+			return client.SetLagMember(ctx, &ponsim.LagMemberRequest{
+				Member: uint32(member),
+				Up:     args[1] == "up",
+			})
+		},
+	},
+	"lldp": {
+		Usage: "lldp [[-chassis id] [-port id] interval]",
+		Help:  "Show the LLDP agent of the NNI and its neighbour, or change the chassis and port IDs and the interval in seconds of its frames (0 to stop them)",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			client := ponsim.NewPonSimAdminClient(conn)
+			if len(args) == 0 {
+				return client.GetLldp(ctx, &empty.Empty{})
+			}
+
+			request := &ponsim.LldpRequest{}
+
+			flags := flag.NewFlagSet("lldp", flag.ContinueOnError)
+			flags.StringVar(&request.ChassisId, "chassis", "", "Chassis ID announced, unchanged if not set")
+			flags.StringVar(&request.PortId, "port", "", "Port ID announced, unchanged if not set")
+			if err := flags.Parse(args); err != nil {
+				return nil, err
+			}
+			interval, err := intArg(flags.Args(), 0, -1)
+			if err != nil {
+				return nil, err
+			}
+			request.Interval = uint32(interval)
+
+			return client.SetLldp(ctx, request)
+		},
+	},
+	"meps": {
+		Usage: "meps [port]",
+		Help:  "Show the maintenance end points of the device, or of the UNI of the ONU on a port",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			port, err := intArg(args, 0, 0)
+			if err != nil {
+				return nil, err
+			}
+			return ponsim.NewPonSimAdminClient(conn).GetMeps(ctx, &ponsim.OnuRequest{Port: int32(port)})
+		},
+	},
+	"mep": {
+		Usage: "mep [-level n] [-vlan n] [-direction up|down] [-md name] -ma name [-ccm ms] [-mac address] [-off] port mep_id",
+		Help:  "Add or change a maintenance end point of the device (port 0) or of the UNI of the ONU on a port, or remove it",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			request := &ponsim.MepRequest{}
+
+			flags := flag.NewFlagSet("mep", flag.ContinueOnError)
+			level := flags.Uint("level", 0, "Maintenance domain level, 0 to 7")
+			vlan := flags.Uint("vlan", 0, "VLAN of the CFM frames, untagged if not set")
+			flags.StringVar(&request.Direction, "direction", "", "Direction of the MEP, up or down")
+			flags.StringVar(&request.MdName, "md", "", "Maintenance domain name")
+			flags.StringVar(&request.MaName, "ma", "", "Maintenance association name")
+			ccm := flags.Uint("ccm", 1000, "Interval between the CCMs in milliseconds, 0 to send none")
+			flags.StringVar(&request.Mac, "mac", "", "MAC address of the MEP, derived from its MEP ID if not set")
+			off := flags.Bool("off", false, "Remove the MEP")
+			if err := flags.Parse(args); err != nil {
+				return nil, err
+			}
+			port, err := intArg(flags.Args(), 0, -1)
+			if err != nil {
+				return nil, err
+			}
+			mepId, err := intArg(flags.Args(), 1, -1)
+			if err != nil {
+				return nil, err
+			}
+
+			request.Port = int32(port)
+			request.MepId = uint32(mepId)
+			request.Enabled = !*off
+			request.Level = uint32(*level)
+			request.Vlan = uint32(*vlan)
+			request.CcmIntervalMs = uint32(*ccm)
+
+			return ponsim.NewPonSimAdminClient(conn).SetMep(ctx, request)
+		},
+	},
+	"cfm-loopback": {
+		Usage: "cfm-loopback [-mac address] [-count n] [-timeout ms] port mep_id [remote_mep_id]",
+		Help:  "Send loopback messages from a maintenance end point to a MAC address or to a remote MEP and report the replies",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			request := &ponsim.CfmLoopbackRequest{}
+
+			flags := flag.NewFlagSet("cfm-loopback", flag.ContinueOnError)
+			flags.StringVar(&request.TargetMac, "mac", "", "MAC address to which the messages are sent")
+			count := flags.Uint("count", 1, "Messages to send")
+			timeout := flags.Uint("timeout", 1000, "Wait for each reply in milliseconds")
+			if err := flags.Parse(args); err != nil {
+				return nil, err
+			}
+			port, err := intArg(flags.Args(), 0, -1)
+			if err != nil {
+				return nil, err
+			}
+			mepId, err := intArg(flags.Args(), 1, -1)
+			if err != nil {
+				return nil, err
+			}
+			remoteMepId, err := intArg(flags.Args(), 2, 0)
+			if err != nil {
+				return nil, err
+			}
+
+			request.Port = int32(port)
+			request.MepId = uint32(mepId)
+			request.RemoteMepId = uint32(remoteMepId)
+			request.Count = uint32(*count)
+			request.TimeoutMs = uint32(*timeout)
+
+			return ponsim.NewPonSimAdminClient(conn).CfmLoopback(ctx, request)
+		},
+	},
+	"cfm-delay": {
+		Usage: "cfm-delay [-mac address] [-count n] [-interval ms] [-timeout ms] port mep_id [remote_mep_id]",
+		Help:  "Measure the two-way frame delay from a maintenance end point to a MAC address or to a remote MEP with DMM and DMR",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			request, err := cfmMeasurementRequest("cfm-delay", args)
+			if err != nil {
+				return nil, err
+			}
+			return ponsim.NewPonSimAdminClient(conn).CfmDelayMeasurement(ctx, request)
+		},
+	},
+	"cfm-loss": {
+		Usage: "cfm-loss [-mac address] [-count n] [-interval ms] [-timeout ms] port mep_id [remote_mep_id]",
+		Help:  "Measure the service frames lost between a maintenance end point and a MAC address or a remote MEP with LMM and LMR",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			request, err := cfmMeasurementRequest("cfm-loss", args)
+			if err != nil {
+				return nil, err
+			}
+			return ponsim.NewPonSimAdminClient(conn).CfmLossMeasurement(ctx, request)
+		},
+	},
+	"encrypt": {
+		Usage: "encrypt port gem_port [on|off]",
+		Help:  "Enable or disable the encryption of a GEM port of the ONU registered on a port of the OLT",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			port, err := intArg(args, 0, -1)
+			if err != nil {
+				return nil, err
+			}
+			gemPort, err := intArg(args, 1, -1)
+			if err != nil {
+				return nil, err
+			}
+			if len(args) > 2 && args[2] != "on" && args[2] != "off" {
+				return nil, fmt.Errorf("expected the encryption of the GEM port, on or off")
+			}
+			return ponsim.NewPonSimAdminClient(conn).SetGemEncryption(ctx, &ponsim.GemEncryptionRequest{
+				Port:      int32(port),
+				GemPort:   uint32(gemPort),
+				Encrypted: len(args) < 3 || args[2] == "on",
+			})
+		},
+	},
+	"rekey": {
+		Usage: "rekey port gem_port",
+		Help:  "Exchange a new key for an encrypted GEM port of the ONU registered on a port of the OLT",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			port, err := intArg(args, 0, -1)
+			if err != nil {
+				return nil, err
+			}
+			gemPort, err := intArg(args, 1, -1)
+			if err != nil {
+				return nil, err
+			}
+			return ponsim.NewPonSimAdminClient(conn).SwitchGemKey(ctx, &ponsim.GemKeyRequest{
+				Port:    int32(port),
+				GemPort: uint32(gemPort),
+			})
+		},
+	},
+	"key-faults": {
+		Usage: "key-faults port count",
+		Help:  "Make the next key requests sent to the ONU registered on a port of the OLT fail",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			port, err := intArg(args, 0, -1)
+			if err != nil {
+				return nil, err
+			}
+			failures, err := intArg(args, 1, -1)
+			if err != nil {
+				return nil, err
+			}
+			return ponsim.NewPonSimAdminClient(conn).SetKeyExchangeFaults(ctx, &ponsim.KeyExchangeFaults{
+				Port:     int32(port),
+				Failures: uint32(failures),
+			})
+		},
+	},
+	"fec": {
+		Usage: "fec port on|off [bit_error_rate]",
+		Help:  "Enable or disable the FEC on the link of the ONU registered on a port of the OLT and set its pre-FEC bit error rate",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			port, err := intArg(args, 0, -1)
+			if err != nil {
+				return nil, err
+			}
+			if len(args) < 2 || (args[1] != "on" && args[1] != "off") {
+				return nil, fmt.Errorf("expected the status of the FEC, on or off")
+			}
+			request := &ponsim.OnuFecRequest{Port: int32(port), Enabled: args[1] == "on"}
+			if len(args) > 2 {
+				if request.BitErrorRate, err = strconv.ParseFloat(args[2], 64); err != nil {
+					return nil, fmt.Errorf("invalid argument %s: %s", args[2], err.Error())
+				}
+			}
+			return ponsim.NewPonSimAdminClient(conn).SetOnuFec(ctx, request)
+		},
+	},
+	"optics": {
+		Usage: "optics port degradation_db [tx_power_dbm]",
+		Help:  "Degrade the optical link of an ONU (0 restores it) and change its launch power",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			port, err := intArg(args, 0, -1)
+			if err != nil {
+				return nil, err
+			}
+			if len(args) < 2 {
+				return nil, fmt.Errorf("missing argument 2")
+			}
+
+			request := &ponsim.OnuOpticsRequest{Port: int32(port)}
+			if request.DegradationDb, err = strconv.ParseFloat(args[1], 64); err != nil {
+				return nil, fmt.Errorf("invalid degradation %s: %s", args[1], err.Error())
+			}
+			if len(args) > 2 {
+				request.SetTxPower = true
+				if request.TxPowerDbm, err = strconv.ParseFloat(args[2], 64); err != nil {
+					return nil, fmt.Errorf("invalid launch power %s: %s", args[2], err.Error())
+				}
+			}
+
+			return ponsim.NewPonSimAdminClient(conn).SetOnuOptics(ctx, request)
+		},
+	},
+	"pm": {
+		Usage: "pm [history] [port_name]",
+		Help:  "Show the counters of the current PM interval, or the completed intervals, for a port or all ports",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			client := ponsim.NewPonSimAdminClient(conn)
+			if len(args) > 0 && args[0] == "history" {
+				request := &ponsim.PmRequest{}
+				if len(args) > 1 {
+					request.PortName = args[1]
+				}
+				return client.GetPmHistory(ctx, request)
+			}
+
+			request := &ponsim.PmRequest{}
+			if len(args) > 0 {
+				request.PortName = args[0]
+			}
+			return client.GetCurrentPm(ctx, request)
+		},
+	},
+	"threshold": {
+		Usage: "threshold [port_name counter value]",
+		Help:  "List the thresholds of the PM counters, or set one raising a TCA (0 removes it)",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			client := ponsim.NewPonSimAdminClient(conn)
+			if len(args) == 0 {
+				return client.ListPmThresholds(ctx, &empty.Empty{})
+			}
+			if len(args) != 3 {
+				return nil, fmt.Errorf("expected a port name, a counter and a value")
+			}
+
+			value, err := strconv.ParseInt(args[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %s: %s", args[2], err.Error())
+			}
+
+			return client.SetPmThreshold(ctx, &ponsim.PmThreshold{PortName: args[0], Counter: args[1], Value: value})
+		},
+	},
+	"frame-logs": {
+		Usage: "frame-logs [rate [limit]]",
+		Help:  "Show how many of the entries logged for every frame are emitted, or log one frame entry in rate and at most limit per second (0 for no limit)",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			client := ponsim.NewPonSimAdminClient(conn)
+			if len(args) == 0 {
+				return client.GetFrameLogSampling(ctx, &empty.Empty{})
+			}
+
+			rate, err := intArg(args, 0, -1)
+			if err != nil {
+				return nil, err
+			}
+			limit, err := intArg(args, 1, 0)
+			if err != nil {
+				return nil, err
+			}
+			if rate < 1 || limit < 0 {
+				return nil, fmt.Errorf("expected a rate of at least 1 and a positive limit")
+			}
+
+			return client.SetFrameLogSampling(ctx, &ponsim.FrameLogSampling{Rate: uint32(rate), Limit: uint32(limit)})
+		},
+	},
+	"chaos": {
+		Usage: "chaos [on|off [catalog]]",
+		Help:  "Show the faults randomly injected into the device, or enable or disable their injection, e.g. chaos on link_flap=0.1:3s,flow_failure=0.05",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			client := ponsim.NewPonSimAdminClient(conn)
+			if len(args) == 0 {
+				return client.GetChaos(ctx, &empty.Empty{})
+			}
+			if len(args) > 2 || (args[0] != "on" && args[0] != "off") {
+				return nil, fmt.Errorf("expected on or off and an optional catalog")
+			}
+
+			request := &ponsim.ChaosRequest{Enabled: args[0] == "on"}
+			if len(args) == 2 {
+				request.Catalog = args[1]
+			}
+
+			return client.SetChaos(ctx, request)
+		},
+	},
+	"mirror": {
+		Usage: "mirror [[-direction rx|tx|both] [-filter expression] [-to_port n] [-file name] [-off] port]",
+		Help:  "Show the port mirrors, or mirror the frames of a port, optionally selected by a tcpdump filter expression, to another port or to a capture file of the capture directory, or remove its mirror",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			client := ponsim.NewPonSimAdminClient(conn)
+			if len(args) == 0 {
+				return client.GetMirrors(ctx, &empty.Empty{})
+			}
+
+			request := &ponsim.MirrorRequest{}
+
+			flags := flag.NewFlagSet("mirror", flag.ContinueOnError)
+			flags.StringVar(&request.Direction, "direction", "", "Direction of the frames mirrored, rx, tx or both")
+			flags.StringVar(&request.Filter, "filter", "", "tcpdump expression selecting the frames mirrored")
+			toPort := flags.Int("to_port", 0, "Port out of which the frames are sent")
+			flags.StringVar(&request.File, "file", "", "pcap file to which the frames are written")
+			off := flags.Bool("off", false, "Remove the mirror of the port")
+			if err := flags.Parse(args); err != nil {
+				return nil, err
+			}
+			port, err := intArg(flags.Args(), 0, -1)
+			if err != nil {
+				return nil, err
+			}
+
+			request.Port = int32(port)
+			request.DestinationPort = int32(*toPort)
+			request.Enabled = !*off
+
+			return client.SetMirror(ctx, request)
+		},
+	},
+	"mirror-stream": {
+		Usage: "mirror-stream [-direction rx|tx|both] [-filter expression] [-count n] port file",
+		Help:  "Write the frames of a port, optionally selected by a tcpdump filter expression, to a local pcap file, until the count of frames or the timeout is reached",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			request := &ponsim.MirrorRequest{}
+
+			flags := flag.NewFlagSet("mirror-stream", flag.ContinueOnError)
+			flags.StringVar(&request.Direction, "direction", "", "Direction of the frames mirrored, rx, tx or both")
+			flags.StringVar(&request.Filter, "filter", "", "tcpdump expression selecting the frames mirrored")
+			count := flags.Uint64("count", 0, "Frames to write, until the timeout if not set")
+			if err := flags.Parse(args); err != nil {
+				return nil, err
+			}
+			port, err := intArg(flags.Args(), 0, -1)
+			if err != nil {
+				return nil, err
+			}
+			if flags.NArg() < 2 {
+				return nil, fmt.Errorf("missing argument 2")
+			}
+			request.Port = int32(port)
+
+			file, err := os.Create(flags.Arg(1))
+			if err != nil {
+				return nil, err
+			}
+			defer file.Close()
+
+			writer := pcapgo.NewWriter(file)
+			if err := writer.WriteFileHeader(65535, layers.LinkTypeEthernet); err != nil {
+				return nil, err
+			}
+
+			stream, err := ponsim.NewPonSimAdminClient(conn).StreamMirror(ctx, request)
+			if err != nil {
+				return nil, err
+			}
+
+			session := &ponsim.MirrorSession{
+				Port:      request.Port,
+				Direction: request.Direction,
+				File:      flags.Arg(1),
+				Filter:    request.Filter,
+				Stream:    true,
+			}
+			if session.Direction == "" {
+				session.Direction = "both"
+			}
+			for *count == 0 || session.Frames < *count {
+				frame, err := stream.Recv()
+				if ctx.Err() != nil {
+					break
+				} else if err != nil {
+					return nil, err
+				}
+
+				info := gopacket.CaptureInfo{
+					Timestamp:     time.Unix(0, frame.Timestamp),
+					CaptureLength: len(frame.Payload),
+					Length:        len(frame.Payload),
+				}
+				if err := writer.WritePacket(info, frame.Payload); err != nil {
+					return nil, err
+				}
+				session.Frames++
+			}
+
+			return session, nil
+		},
+	},
+	"arp": {
+		Usage: "arp port [ip mac | ip remove]",
+		Help:  "Show the addresses for which the ONU on a port answers ARP requests, or bind or unbind an address (port 0 when addressing an ONU directly)",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			port, err := intArg(args, 0, -1)
+			if err != nil {
+				return nil, err
+			}
+
+			client := ponsim.NewPonSimAdminClient(conn)
+			if len(args) == 1 {
+				return client.GetArpBindings(ctx, &ponsim.OnuRequest{Port: int32(port)})
+			}
+			if len(args) != 3 {
+				return nil, fmt.Errorf("expected an address and a MAC address or remove")
+			}
+
+			request := &ponsim.ArpBindingRequest{Port: int32(port), Ip: args[1], Mac: args[2]}
+			if args[2] == "remove" {
+				request.Mac = ""
+			}
+
+			return client.SetArpBinding(ctx, request)
+		},
+	},
+	"echo": {
+		Usage: "echo port [loss_percent [latency_ms]]",
+		Help:  "Show the ICMP echo replies of the bound addresses of the ONU on a port, or change the percentage of requests left unanswered and the latency of the replies",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			port, err := intArg(args, 0, -1)
+			if err != nil {
+				return nil, err
+			}
+
+			client := ponsim.NewPonSimAdminClient(conn)
+			if len(args) == 1 {
+				return client.GetEchoResponder(ctx, &ponsim.OnuRequest{Port: int32(port)})
+			}
+
+			loss, err := strconv.ParseFloat(args[1], 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid argument %s: %s", args[1], err.Error())
+			}
+			latency, err := intArg(args, 2, 0)
+			if err != nil {
+				return nil, err
+			}
+
+			return client.SetEchoResponder(ctx, &ponsim.EchoResponderRequest{
+				Port:        int32(port),
+				LossPercent: float32(loss),
+				LatencyMs:   uint32(latency),
+			})
+		},
+	},
+	"table-miss": {
+		Usage: "table-miss [port [drop|flood|controller]]",
+		Help:  "Show what the flows of the device (port 0) or of the ONU on a port do with the frames matching none of them, or change it",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			port, err := intArg(args, 0, 0)
+			if err != nil {
+				return nil, err
+			}
+
+			client := ponsim.NewPonSimAdminClient(conn)
+			if len(args) < 2 {
+				return client.GetTableMiss(ctx, &ponsim.OnuRequest{Port: int32(port)})
+			}
+
+			return client.SetTableMiss(ctx, &ponsim.TableMissRequest{Port: int32(port), Mode: args[1]})
+		},
+	},
+	"log-level": {
+		Usage: "log-level [component level]",
+		Help:  "Show the log level of each component, or change the level of a component (nbi, sbi, forwarding, alarm or default)",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			client := ponsim.NewPonSimAdminClient(conn)
+			if len(args) == 0 {
+				return client.GetLogLevels(ctx, &empty.Empty{})
+			}
+			if len(args) != 2 {
+				return nil, fmt.Errorf("expected a component and a level")
+			}
+
+			return client.SetLogLevel(ctx, &ponsim.ComponentLogLevel{Component: args[0], Level: args[1]})
+		},
+	},
+	"diagnostics": {
+		Usage: "diagnostics [port]",
+		Help:  "Show the transceiver diagnostics of the device, or of the ONU on a port",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			port, err := intArg(args, 0, 0)
+			if err != nil {
+				return nil, err
+			}
+			return voltha.NewPonSimClient(conn).GetDiagnostics(ctx, &voltha.PonSimPort{Port: int32(port)})
+		},
+	},
+	"self-test": {
+		Usage: "self-test [port]",
+		Help:  "Run the consistency checks of the device, or of the ONU on a port",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			port, err := intArg(args, 0, 0)
+			if err != nil {
+				return nil, err
+			}
+			return voltha.NewPonSimClient(conn).SelfTest(ctx, &voltha.PonSimPort{Port: int32(port)})
+		},
+	},
+	"set-diagnostics": {
+		Usage: "set-diagnostics port temperature_c voltage_v bias_current_ma noise",
+		Help:  "Change the baseline transceiver diagnostics of the device (port 0) or of the ONU on a port",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			port, err := intArg(args, 0, -1)
+			if err != nil {
+				return nil, err
+			}
+			if len(args) != 5 {
+				return nil, fmt.Errorf("expected a port, a temperature, a voltage, a bias current and a noise")
+			}
+
+			var values [4]float64
+			for i := range values {
+				if values[i], err = strconv.ParseFloat(args[i+1], 64); err != nil {
+					return nil, fmt.Errorf("invalid argument %s: %s", args[i+1], err.Error())
+				}
+			}
+
+			return ponsim.NewPonSimAdminClient(conn).SetDiagnostics(ctx, &ponsim.DiagnosticsRequest{
+				Port:          int32(port),
+				TemperatureC:  values[0],
+				VoltageV:      values[1],
+				BiasCurrentMa: values[2],
+				Noise:         values[3],
+			})
+		},
+	},
+	"image": {
+		Usage: "image port [download version size [crc] [rate] | cancel | activate | commit | fault name]",
+		Help:  "Show the software images of the ONU on a port, or download, activate or commit an image",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			port, err := intArg(args, 0, -1)
+			if err != nil {
+				return nil, err
+			}
+
+			client := ponsim.NewPonSimAdminClient(conn)
+			request := &ponsim.ImageRequest{Port: int32(port)}
+			if len(args) < 2 {
+				return client.GetImageStatus(ctx, request)
+			}
+
+			switch args[1] {
+			case "download":
+				if len(args) < 4 {
+					return nil, fmt.Errorf("expected a version and a size")
+				}
+				size, err := intArg(args, 3, -1)
+				if err != nil {
+					return nil, err
+				}
+				download := &ponsim.ImageDownloadRequest{Port: int32(port), Version: args[2], Size: uint32(size)}
+				if len(args) > 4 {
+					crc, err := strconv.ParseUint(args[4], 16, 32)
+					if err != nil {
+						return nil, fmt.Errorf("invalid CRC %s: %s", args[4], err.Error())
+					}
+					download.Crc = uint32(crc)
+				}
+				rate, err := intArg(args, 5, 0)
+				if err != nil {
+					return nil, err
+				}
+				download.Rate = uint32(rate)
+				return client.StartImageDownload(ctx, download)
+			case "cancel":
+				return client.CancelImageDownload(ctx, request)
+			case "activate":
+				return client.ActivateImage(ctx, request)
+			case "commit":
+				return client.CommitImage(ctx, request)
+			case "fault":
+				if len(args) < 3 {
+					return nil, fmt.Errorf("expected a fault")
+				}
+				return client.SetImageFault(ctx, &ponsim.ImageFaultRequest{Port: int32(port), Fault: args[2]})
+			default:
+				return nil, fmt.Errorf("unknown image operation: %s", args[1])
+			}
+		},
+	},
+	"omci": {
+		Usage: "omci port hex_message",
+		Help:  "Send an OMCI message to the ONU on a port; the response is reported in base64",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			port, err := intArg(args, 0, -1)
+			if err != nil {
+				return nil, err
+			}
+			if len(args) != 2 {
+				return nil, fmt.Errorf("expected a port and a message")
+			}
+			message, err := hex.DecodeString(args[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid message %s: %s", args[1], err.Error())
+			}
+
+			return voltha.NewPonSimClient(conn).SendOmci(ctx, &voltha.PonSimOmciMessage{
+				Port:    int32(port),
+				Message: message,
+			})
+		},
+	},
+	"enable-onu": {
+		Usage: "enable-onu port",
+		Help:  "Enable the disabled ONU registered on a port of the OLT",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			port, err := intArg(args, 0, -1)
+			if err != nil {
+				return nil, err
+			}
+			return ponsim.NewPonSimAdminClient(conn).EnableOnu(ctx, &ponsim.OnuRequest{Port: int32(port)})
+		},
+	},
+	"disable-onu": {
+		Usage: "disable-onu port",
+		Help:  "Administratively disable the ONU registered on a port of the OLT",
+		Run: func(ctx context.Context, conn *grpc.ClientConn, args []string) (proto.Message, error) {
+			port, err := intArg(args, 0, -1)
+			if err != nil {
+				return nil, err
+			}
+			return ponsim.NewPonSimAdminClient(conn).DisableOnu(ctx, &ponsim.OnuRequest{Port: int32(port)})
+		},
+	},
+}
+
+/*
+intArg parses the positional argument at an index, the default value being used when the
+argument is absent; a negative default makes the argument mandatory
+*/
+/*
+cfmMeasurementRequest parses the arguments of a delay or loss measurement
+*/
+func cfmMeasurementRequest(name string, args []string) (*ponsim.CfmMeasurementRequest, error) {
+	request := &ponsim.CfmMeasurementRequest{}
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&request.TargetMac, "mac", "", "MAC address to which the messages are sent")
+	count := flags.Uint("count", 0, "Messages to send, 10 if not set")
+	interval := flags.Uint("interval", 0, "Interval between the messages in milliseconds, 100 if not set")
+	timeout := flags.Uint("timeout", 0, "Wait for each reply in milliseconds, 1000 if not set")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	port, err := intArg(flags.Args(), 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	mepId, err := intArg(flags.Args(), 1, -1)
+	if err != nil {
+		return nil, err
+	}
+	remoteMepId, err := intArg(flags.Args(), 2, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Port = int32(port)
+	request.MepId = uint32(mepId)
+	request.RemoteMepId = uint32(remoteMepId)
+	request.Count = uint32(*count)
+	request.IntervalMs = uint32(*interval)
+	request.TimeoutMs = uint32(*timeout)
+
+	return request, nil
+}
+
+func intArg(args []string, index int, defaultValue int) (int, error) {
+	if index >= len(args) {
+		if defaultValue < 0 {
+			return 0, fmt.Errorf("missing argument %d", index+1)
+		}
+		return defaultValue, nil
+	}
+
+	value, err := strconv.Atoi(args[index])
+	if err != nil {
+		return 0, fmt.Errorf("invalid argument %s: %s", args[index], err.Error())
+	}
+
+	return value, nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: ponsimctl [options] command [arguments]\n\nOptions:\n")
+	flag.PrintDefaults()
+
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "\nCommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n    \t%s\n", commands[name].Usage, commands[name].Help)
+	}
+}
+
+func init() {
+	help := fmt.Sprintf("Address of the GRPC server of the simulator")
+	flag.StringVar(&server, "server", default_server, help)
+
+	help = fmt.Sprintf("Time to wait for a reply (in seconds)")
+	flag.IntVar(&timeout, "timeout", default_timeout, help)
+
+	help = fmt.Sprintf("API token or JSON Web Token presented to the simulator (defaults to $PONSIM_TOKEN)")
+	flag.StringVar(&token, "token", default_token, help)
+
+	flag.Usage = usage
+}
+
+func main() {
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	// TODO: make it secure
+	ta := credentials.NewTLS(&tls.Config{
+		InsecureSkipVerify: true,
+	})
+
+	conn, err := grpc.DialContext(ctx, server, grpc.WithTransportCredentials(ta))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to connect to %s: %s\n", server, err.Error())
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	if token == "" {
+		token = os.Getenv("PONSIM_TOKEN")
+	}
+	if token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
+
+	reply, err := cmd.Run(ctx, conn, flag.Args()[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %s\n", flag.Arg(0), err.Error())
+		os.Exit(1)
+	}
+
+	// Fields are named as in the proto definitions
+	marshaler := jsonpb.Marshaler{OrigName: true, EmitDefaults: true, Indent: "  "}
+	if err := marshaler.Marshal(os.Stdout, reply); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to print the reply: %s\n", err.Error())
+		os.Exit(1)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package common
+
+import (
+	"fmt"
+	"github.com/golang/snappy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	COMPRESSION_NONE   = "none"
+	COMPRESSION_GZIP   = "gzip"
+	COMPRESSION_SNAPPY = "snappy"
+)
+
+/*
+Both compressors are registered so that the GRPC servers accept, and reply with, the
+compression requested by their clients
+*/
+func init() {
+	encoding.RegisterCompressor(snappyCompressor{})
+}
+
+/*
+snappyCompressor compresses GRPC messages with the framing format of snappy.  Writers and
+readers are reused across messages, as each of them holds buffers of 64KB.
+*/
+type snappyCompressor struct{}
+
+var snappyWriters, snappyReaders sync.Pool
+
+func (snappyCompressor) Name() string {
+	return COMPRESSION_SNAPPY
+}
+
+func (snappyCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	writer, ok := snappyWriters.Get().(*snappyWriter)
+	if !ok {
+		return &snappyWriter{snappy.NewBufferedWriter(w)}, nil
+	}
+	writer.Reset(w)
+	return writer, nil
+}
+
+func (snappyCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	reader, ok := snappyReaders.Get().(*snappyReader)
+	if !ok {
+		return &snappyReader{snappy.NewReader(r)}, nil
+	}
+	reader.Reset(r)
+	return reader, nil
+}
+
+/*
+snappyWriter returns to the pool once the message is written
+*/
+type snappyWriter struct {
+	*snappy.Writer
+}
+
+func (w *snappyWriter) Close() error {
+	defer snappyWriters.Put(w)
+	return w.Writer.Close()
+}
+
+/*
+snappyReader returns to the pool once the message is read
+*/
+type snappyReader struct {
+	*snappy.Reader
+}
+
+func (r *snappyReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		snappyReaders.Put(r)
+	}
+	return n, err
+}
+
+/*
+ParseCompression validates the name of the compression of the frame streams (none, gzip or snappy)
+*/
+func ParseCompression(name string) (string, error) {
+	switch name = strings.ToLower(strings.TrimSpace(name)); name {
+	case "", COMPRESSION_NONE:
+		return COMPRESSION_NONE, nil
+	case COMPRESSION_GZIP, COMPRESSION_SNAPPY:
+		return name, nil
+	}
+
+	return "", fmt.Errorf("unknown compression: %s", name)
+}
+
+/*
+CompressionCallOptions returns the options of the calls whose requests are compressed
+*/
+func CompressionCallOptions(name string) []grpc.CallOption {
+	if name == "" || name == COMPRESSION_NONE {
+		return nil
+	}
+
+	return []grpc.CallOption{grpc.UseCompressor(name)}
+}
@@ -19,6 +19,10 @@ import (
 	"github.com/opencord/voltha/protos/go/openflow_13"
 )
 
+/*
+SortByPriority orders flows from the highest to the lowest priority.  It must be used with
+sort.Stable so that flows of equal priority remain in their order of installation.
+*/
 type SortByPriority []*openflow_13.OfpFlowStats
 
 func (s SortByPriority) Len() int {
@@ -28,5 +32,5 @@ func (s SortByPriority) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
 func (s SortByPriority) Less(i, j int) bool {
-	return s[i].Priority < s[j].Priority
+	return s[i].Priority > s[j].Priority
 }
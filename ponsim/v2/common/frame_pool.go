@@ -0,0 +1,72 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package common
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"sync"
+)
+
+/*
+FrameDecodeOptions decode frames on demand and without copying their content.  The decoder
+takes ownership of the data, which must not be modified afterwards.
+*/
+var FrameDecodeOptions = gopacket.DecodeOptions{Lazy: true, NoCopy: true}
+
+var serializeBuffers = sync.Pool{
+	New: func() interface{} {
+		return gopacket.NewSerializeBuffer()
+	},
+}
+
+/*
+NewFrame decodes an ethernet frame from data owned by the caller, e.g. a received GRPC payload
+
+Layers are only decoded when first accessed so that frames dropped early are never fully parsed.
+A lazily decoded frame is not safe for concurrent use until it is completely decoded.
+*/
+func NewFrame(data []byte) gopacket.Packet {
+	return gopacket.NewPacket(data, layers.LayerTypeEthernet, FrameDecodeOptions)
+}
+
+/*
+SerializeFrame builds a new ethernet frame from layers, using a pooled serialization buffer
+so that the content of the frame is only allocated once
+*/
+func SerializeFrame(options gopacket.SerializeOptions, frameLayers ...gopacket.SerializableLayer) (gopacket.Packet, error) {
+	buffer := serializeBuffers.Get().(gopacket.SerializeBuffer)
+	defer serializeBuffers.Put(buffer)
+
+	if err := buffer.Clear(); err != nil {
+		return nil, err
+	}
+	if err := gopacket.SerializeLayers(buffer, options, frameLayers...); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, len(buffer.Bytes()))
+	copy(data, buffer.Bytes())
+
+	return NewFrame(data), nil
+}
+
+/*
+DecodeFrame completes the decoding of a lazily decoded frame so that it can be shared safely
+*/
+func DecodeFrame(frame gopacket.Packet) {
+	frame.Layers()
+}
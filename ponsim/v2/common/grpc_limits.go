@@ -0,0 +1,116 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package common
+
+import (
+	"fmt"
+	"google.golang.org/grpc"
+)
+
+const (
+	// Smallest window size accepted by GRPC, smaller sizes are ignored
+	MIN_GRPC_WINDOW_SIZE = 64 * 1024
+)
+
+/*
+GrpcLimits overrides the default message size limits and flow control windows of the GRPC
+servers and clients of the simulator, e.g. so that large flow tables and jumbo frames are
+not rejected.  Zero values leave the GRPC defaults in place.
+*/
+type GrpcLimits struct {
+	MaxRecvMsgSize        int   `json:"max_recv_msg_size"`
+	MaxSendMsgSize        int   `json:"max_send_msg_size"`
+	InitialWindowSize     int32 `json:"initial_window_size"`
+	InitialConnWindowSize int32 `json:"initial_conn_window_size"`
+}
+
+var grpcLimits GrpcLimits
+
+/*
+NewGrpcLimits validates the message size limits (in bytes) and the window sizes (in bytes)
+*/
+func NewGrpcLimits(maxRecvMsgSize int, maxSendMsgSize int, windowSize int, connWindowSize int) (GrpcLimits, error) {
+	if maxRecvMsgSize < 0 || maxSendMsgSize < 0 {
+		return GrpcLimits{}, fmt.Errorf("invalid message size limits: %d/%d", maxRecvMsgSize, maxSendMsgSize)
+	}
+	for _, size := range []int{windowSize, connWindowSize} {
+		if size != 0 && (size < MIN_GRPC_WINDOW_SIZE || size > 1<<31-1) {
+			return GrpcLimits{}, fmt.Errorf("invalid window size %d, expected at least %d", size, MIN_GRPC_WINDOW_SIZE)
+		}
+	}
+
+	return GrpcLimits{
+		MaxRecvMsgSize:        maxRecvMsgSize,
+		MaxSendMsgSize:        maxSendMsgSize,
+		InitialWindowSize:     int32(windowSize),
+		InitialConnWindowSize: int32(connWindowSize),
+	}, nil
+}
+
+/*
+SetGrpcLimits applies limits to the GRPC servers and clients created from then on
+*/
+func SetGrpcLimits(limits GrpcLimits) {
+	grpcLimits = limits
+}
+
+/*
+GrpcServerOptions returns the options of a GRPC server enforcing the configured limits
+*/
+func GrpcServerOptions() []grpc.ServerOption {
+	var options []grpc.ServerOption
+
+	if grpcLimits.MaxRecvMsgSize > 0 {
+		options = append(options, grpc.MaxRecvMsgSize(grpcLimits.MaxRecvMsgSize))
+	}
+	if grpcLimits.MaxSendMsgSize > 0 {
+		options = append(options, grpc.MaxSendMsgSize(grpcLimits.MaxSendMsgSize))
+	}
+	if grpcLimits.InitialWindowSize > 0 {
+		options = append(options, grpc.InitialWindowSize(grpcLimits.InitialWindowSize))
+	}
+	if grpcLimits.InitialConnWindowSize > 0 {
+		options = append(options, grpc.InitialConnWindowSize(grpcLimits.InitialConnWindowSize))
+	}
+
+	return options
+}
+
+/*
+GrpcDialOptions returns the options of a GRPC client connection enforcing the configured limits
+*/
+func GrpcDialOptions() []grpc.DialOption {
+	var options []grpc.DialOption
+	var callOptions []grpc.CallOption
+
+	if grpcLimits.MaxRecvMsgSize > 0 {
+		callOptions = append(callOptions, grpc.MaxCallRecvMsgSize(grpcLimits.MaxRecvMsgSize))
+	}
+	if grpcLimits.MaxSendMsgSize > 0 {
+		callOptions = append(callOptions, grpc.MaxCallSendMsgSize(grpcLimits.MaxSendMsgSize))
+	}
+	if len(callOptions) > 0 {
+		options = append(options, grpc.WithDefaultCallOptions(callOptions...))
+	}
+	if grpcLimits.InitialWindowSize > 0 {
+		options = append(options, grpc.WithInitialWindowSize(grpcLimits.InitialWindowSize))
+	}
+	if grpcLimits.InitialConnWindowSize > 0 {
+		options = append(options, grpc.WithInitialConnWindowSize(grpcLimits.InitialConnWindowSize))
+	}
+
+	return options
+}
@@ -33,6 +33,8 @@ type IntervalHandler struct {
 	function func()
 	// Channel listening to execution events
 	execute chan _ExecutionState
+	// Channel acknowledging the processing of an execution event
+	processed chan struct{}
 	// Channel listening for a termination event
 	terminate chan struct{}
 	// Current execution state of the handler
@@ -72,6 +74,7 @@ func NewIntervalHandler(interval int, function func()) *IntervalHandler {
 	}
 
 	handler.execute = make(chan _ExecutionState)
+	handler.processed = make(chan struct{})
 	handler.terminate = make(chan struct{})
 	handler.state = STOPPED
 
@@ -84,6 +87,13 @@ _Execute is a routine running concurrently and listening to execution events
 func (h *IntervalHandler) _Execute() {
 	defer h.wg.Done()
 	for {
+		// Execution events are processed without waiting for the end of the interval
+		wait := time.Second
+		if h.state == STARTED {
+			h.function()
+			wait = time.Duration(h.Interval) * time.Second
+		}
+
 		select {
 		case h.state = <-h.execute:
 			Logger().WithFields(logrus.Fields{
@@ -97,24 +107,28 @@ func (h *IntervalHandler) _Execute() {
 			case STOPPED:
 				fallthrough
 			default:
-				h.terminate <- struct{}{}
+				h.state = STOPPED
+				h.processed <- struct{}{}
+				return
 			}
+			h.processed <- struct{}{}
 
 		case <-h.terminate:
 			return
 
-		default:
-			if h.state == STARTED {
-				h.function()
-				time.Sleep(time.Duration(h.Interval) * time.Second)
-			} else {
-				// TODO: replace hardcoded delay with a configurable parameter
-				time.Sleep(1 * time.Second)
-			}
+		case <-time.After(wait):
 		}
 	}
 }
 
+/*
+_Send passes an execution event to the running routine and waits until it is processed
+*/
+func (h *IntervalHandler) _Send(state _ExecutionState) {
+	h.execute <- state
+	<-h.processed
+}
+
 /*
 Start initiates the interval based function execution
 */
@@ -127,8 +141,9 @@ func (h *IntervalHandler) Start() {
 		return
 	}
 	if h.state == STOPPED {
+		h.wg.Add(1)
 		go h._Execute()
-		h.execute <- STARTED
+		h._Send(STARTED)
 	}
 }
 
@@ -144,7 +159,7 @@ func (h *IntervalHandler) Pause() {
 		return
 	}
 	if h.state == STARTED {
-		h.execute <- PAUSED
+		h._Send(PAUSED)
 	}
 }
 
@@ -160,7 +175,7 @@ func (h *IntervalHandler) Resume() {
 		return
 	}
 	if h.state == PAUSED {
-		h.execute <- RESUMED
+		h._Send(RESUMED)
 	}
 }
 
@@ -175,5 +190,5 @@ func (h *IntervalHandler) Stop() {
 	if h.execute == nil || h.state == STOPPED {
 		return
 	}
-	h.execute <- STOPPED
+	h._Send(STOPPED)
 }
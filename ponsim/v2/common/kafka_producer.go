@@ -0,0 +1,389 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	KAFKA_CLIENT_ID    = "ponsim"
+	KAFKA_DIAL_TIMEOUT = 5 * time.Second
+	KAFKA_IO_TIMEOUT   = 10 * time.Second
+	KAFKA_PRODUCE_ACKS = 1
+	KAFKA_MAX_RESPONSE = 16 * 1024 * 1024
+)
+
+const (
+	kafkaApiProduce     = 0
+	kafkaApiMetadata    = 3
+	kafkaPartition      = 0
+	kafkaNoError        = 0
+	kafkaMessageVersion = 0
+)
+
+/*
+KafkaProducer publishes messages on partition 0 of a Kafka topic.
+
+It implements the subset of the Kafka protocol needed to produce messages (metadata and
+produce requests, version 0) so that the simulator does not depend on a Kafka client.
+The connection to the leader of the partition is established on the first message and
+re-established on the next message after a failure.
+*/
+type KafkaProducer struct {
+	Brokers []string `json:"brokers"`
+	Topic   string   `json:"topic"`
+
+	mutex         sync.Mutex
+	conn          net.Conn
+	correlationId int32
+}
+
+/*
+NewKafkaProducer instantiates a producer for a topic, bootstrapped from a list of brokers in
+the format host:port
+*/
+func NewKafkaProducer(brokers []string, topic string) (*KafkaProducer, error) {
+	if len(brokers) == 0 {
+		return nil, errors.New("no kafka broker specified")
+	}
+	if topic == "" {
+		return nil, errors.New("no kafka topic specified")
+	}
+
+	return &KafkaProducer{Brokers: brokers, Topic: topic}, nil
+}
+
+/*
+ParseKafkaBrokers splits a comma separated list of brokers in the format host:port
+*/
+func ParseKafkaBrokers(spec string) ([]string, error) {
+	var brokers []string
+	for _, broker := range strings.Split(spec, ",") {
+		if broker = strings.TrimSpace(broker); broker == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(broker); err != nil {
+			return nil, fmt.Errorf("invalid kafka broker %s: %s", broker, err.Error())
+		}
+		brokers = append(brokers, broker)
+	}
+
+	return brokers, nil
+}
+
+/*
+Send publishes a message, with an optional key, and waits for its acknowledgement by the leader
+*/
+func (p *KafkaProducer) Send(key []byte, value []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.conn == nil {
+		conn, err := p.connect()
+		if err != nil {
+			return err
+		}
+		p.conn = conn
+	}
+
+	if err := p.produce(key, value); err != nil {
+		p.conn.Close()
+		p.conn = nil
+		return err
+	}
+
+	return nil
+}
+
+/*
+Close releases the connection to the leader
+*/
+func (p *KafkaProducer) Close() {
+	if p == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+/*
+connect finds the leader of the partition through the first available broker and connects to it
+*/
+func (p *KafkaProducer) connect() (net.Conn, error) {
+	var lastErr error
+
+	for _, broker := range p.Brokers {
+		conn, err := net.DialTimeout("tcp", broker, KAFKA_DIAL_TIMEOUT)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		leader, err := p.findLeader(conn)
+		if err != nil {
+			conn.Close()
+			lastErr = err
+			continue
+		}
+
+		if leader == broker {
+			return conn, nil
+		}
+		conn.Close()
+
+		Logger().WithFields(logrus.Fields{
+			"broker": broker,
+			"leader": leader,
+			"topic":  p.Topic,
+		}).Debug("Connecting to the leader of the kafka topic")
+
+		return net.DialTimeout("tcp", leader, KAFKA_DIAL_TIMEOUT)
+	}
+
+	return nil, fmt.Errorf("unable to reach kafka brokers %v: %v", p.Brokers, lastErr)
+}
+
+/*
+findLeader requests the metadata of the topic and returns the address of the leader of the partition
+*/
+func (p *KafkaProducer) findLeader(conn net.Conn) (string, error) {
+	body := &kafkaEncoder{}
+	body.putInt32(1)
+	body.putString(p.Topic)
+
+	response, err := p.roundTrip(conn, kafkaApiMetadata, body.Bytes())
+	if err != nil {
+		return "", err
+	}
+
+	brokers := make(map[int32]string)
+	for i, count := int32(0), response.getInt32(); i < count && response.err == nil; i++ {
+		id := response.getInt32()
+		host := response.getString()
+		port := response.getInt32()
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+
+	for i, count := int32(0), response.getInt32(); i < count && response.err == nil; i++ {
+		topicError := response.getInt16()
+		topic := response.getString()
+
+		for j, partitions := int32(0), response.getInt32(); j < partitions && response.err == nil; j++ {
+			partitionError := response.getInt16()
+			partition := response.getInt32()
+			leader := response.getInt32()
+			response.skipInt32Array() // Replicas
+			response.skipInt32Array() // In-sync replicas
+
+			if topic != p.Topic || partition != kafkaPartition {
+				continue
+			}
+			if response.err != nil {
+				return "", response.err
+			}
+			if topicError != kafkaNoError {
+				return "", fmt.Errorf("kafka topic %s is unavailable: error %d", topic, topicError)
+			}
+			if partitionError != kafkaNoError {
+				return "", fmt.Errorf("kafka partition %s/%d is unavailable: error %d", topic, partition, partitionError)
+			}
+			if address, ok := brokers[leader]; ok {
+				return address, nil
+			}
+			return "", fmt.Errorf("unknown leader %d for kafka topic %s", leader, topic)
+		}
+	}
+
+	if response.err != nil {
+		return "", response.err
+	}
+	return "", fmt.Errorf("kafka topic %s has no partition %d", p.Topic, kafkaPartition)
+}
+
+/*
+produce sends a message set holding a single message and checks the acknowledgement
+*/
+func (p *KafkaProducer) produce(key []byte, value []byte) error {
+	message := &kafkaEncoder{}
+	message.putInt8(kafkaMessageVersion)
+	message.putInt8(0) // No compression
+	message.putBytes(key)
+	message.putBytes(value)
+
+	set := &kafkaEncoder{}
+	set.putInt64(0) // Offset, assigned by the broker
+	set.putInt32(int32(4 + message.Len()))
+	set.putInt32(int32(crc32.ChecksumIEEE(message.Bytes())))
+	set.Write(message.Bytes())
+
+	body := &kafkaEncoder{}
+	body.putInt16(KAFKA_PRODUCE_ACKS)
+	body.putInt32(int32(KAFKA_IO_TIMEOUT / time.Millisecond))
+	body.putInt32(1)
+	body.putString(p.Topic)
+	body.putInt32(1)
+	body.putInt32(kafkaPartition)
+	body.putInt32(int32(set.Len()))
+	body.Write(set.Bytes())
+
+	response, err := p.roundTrip(p.conn, kafkaApiProduce, body.Bytes())
+	if err != nil {
+		return err
+	}
+
+	for i, count := int32(0), response.getInt32(); i < count && response.err == nil; i++ {
+		response.getString()
+		for j, partitions := int32(0), response.getInt32(); j < partitions && response.err == nil; j++ {
+			response.getInt32()
+			code := response.getInt16()
+			response.getInt64()
+
+			if response.err == nil && code != kafkaNoError {
+				return fmt.Errorf("kafka rejected message for topic %s: error %d", p.Topic, code)
+			}
+		}
+	}
+
+	return response.err
+}
+
+/*
+roundTrip sends a request and reads its response, returning a decoder positioned after the
+correlation identifier
+*/
+func (p *KafkaProducer) roundTrip(conn net.Conn, api int16, body []byte) (*kafkaDecoder, error) {
+	p.correlationId++
+
+	request := &kafkaEncoder{}
+	request.putInt16(api)
+	request.putInt16(0) // Version
+	request.putInt32(p.correlationId)
+	request.putString(KAFKA_CLIENT_ID)
+	request.Write(body)
+
+	frame := &kafkaEncoder{}
+	frame.putInt32(int32(request.Len()))
+	frame.Write(request.Bytes())
+
+	conn.SetDeadline(time.Now().Add(KAFKA_IO_TIMEOUT))
+	if _, err := conn.Write(frame.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var size int32
+	if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 4 || size > KAFKA_MAX_RESPONSE {
+		return nil, fmt.Errorf("invalid kafka response size: %d", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, err
+	}
+
+	response := &kafkaDecoder{data: data}
+	if id := response.getInt32(); id != p.correlationId {
+		return nil, fmt.Errorf("unexpected kafka correlation id %d, expected %d", id, p.correlationId)
+	}
+
+	return response, nil
+}
+
+/*
+kafkaEncoder serializes the big-endian primitives of the Kafka protocol
+*/
+type kafkaEncoder struct {
+	bytes.Buffer
+}
+
+func (e *kafkaEncoder) putInt8(v int8)   { e.WriteByte(byte(v)) }
+func (e *kafkaEncoder) putInt16(v int16) { binary.Write(e, binary.BigEndian, v) }
+func (e *kafkaEncoder) putInt32(v int32) { binary.Write(e, binary.BigEndian, v) }
+func (e *kafkaEncoder) putInt64(v int64) { binary.Write(e, binary.BigEndian, v) }
+
+func (e *kafkaEncoder) putString(v string) {
+	e.putInt16(int16(len(v)))
+	e.WriteString(v)
+}
+
+func (e *kafkaEncoder) putBytes(v []byte) {
+	if v == nil {
+		e.putInt32(-1)
+		return
+	}
+	e.putInt32(int32(len(v)))
+	e.Write(v)
+}
+
+/*
+kafkaDecoder parses the big-endian primitives of the Kafka protocol.  Once the data is
+exhausted, zero values are returned and the error is recorded.
+*/
+type kafkaDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *kafkaDecoder) next(size int) []byte {
+	if d.err != nil || len(d.data) < size {
+		d.err = errors.New("truncated kafka response")
+		return make([]byte, size)
+	}
+
+	v := d.data[:size]
+	d.data = d.data[size:]
+	return v
+}
+
+func (d *kafkaDecoder) getInt16() int16 { return int16(binary.BigEndian.Uint16(d.next(2))) }
+func (d *kafkaDecoder) getInt32() int32 { return int32(binary.BigEndian.Uint32(d.next(4))) }
+func (d *kafkaDecoder) getInt64() int64 { return int64(binary.BigEndian.Uint64(d.next(8))) }
+
+func (d *kafkaDecoder) getString() string {
+	size := d.getInt16()
+	if size < 0 {
+		return ""
+	}
+	return string(d.next(int(size)))
+}
+
+func (d *kafkaDecoder) skipInt32Array() {
+	if count := d.getInt32(); count > 0 && int(count) <= len(d.data)/4 {
+		d.next(4 * int(count))
+	} else if count > 0 {
+		d.err = errors.New("truncated kafka response")
+	}
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	KV_STORE_TIMEOUT = 5 * time.Second
+)
+
+/*
+KVStore is a key/value store in which the simulator persists its state
+*/
+type KVStore interface {
+	// Get returns the value of a key, or nil when the key does not exist
+	Get(key string) ([]byte, error)
+
+	// Put sets the value of a key
+	Put(key string, value []byte) error
+}
+
+/*
+NewKVStore instantiates the client of a key/value store designated by a URL in the format
+consul://host:port or etcd://host:port.  Both stores are reached through their HTTP API.
+*/
+func NewKVStore(address string) (KVStore, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in key/value store address: %s", address)
+	}
+
+	client := &http.Client{Timeout: KV_STORE_TIMEOUT}
+
+	switch u.Scheme {
+	case "consul":
+		return &consulStore{endpoint: "http://" + u.Host + "/v1/kv/", client: client}, nil
+	case "etcd":
+		return &etcdStore{endpoint: "http://" + u.Host + "/v3/kv/", client: client}, nil
+	}
+
+	return nil, fmt.Errorf("unknown key/value store: %s", u.Scheme)
+}
+
+/*
+consulStore uses the KV endpoints of the Consul HTTP API
+*/
+type consulStore struct {
+	endpoint string
+	client   *http.Client
+}
+
+func (s *consulStore) Get(key string) ([]byte, error) {
+	response, err := s.client.Get(s.endpoint + key + "?raw")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul returned %s for key %s", response.Status, key)
+	}
+
+	return ioutil.ReadAll(response.Body)
+}
+
+func (s *consulStore) Put(key string, value []byte) error {
+	request, err := http.NewRequest(http.MethodPut, s.endpoint+key, bytes.NewReader(value))
+	if err != nil {
+		return err
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("consul returned %s for key %s", response.Status, key)
+	}
+
+	return nil
+}
+
+/*
+etcdStore uses the JSON gateway of the etcd v3 API, in which keys and values are base64 encoded
+*/
+type etcdStore struct {
+	endpoint string
+	client   *http.Client
+}
+
+func (s *etcdStore) call(method string, request interface{}, reply interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	response, err := s.client.Post(s.endpoint+method, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd returned %s for %s", response.Status, method)
+	}
+
+	return json.NewDecoder(response.Body).Decode(reply)
+}
+
+func (s *etcdStore) Get(key string) ([]byte, error) {
+	var reply struct {
+		Kvs []struct {
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+
+	if err := s.call("range", map[string][]byte{"key": []byte(key)}, &reply); err != nil {
+		return nil, err
+	}
+	if len(reply.Kvs) == 0 {
+		return nil, nil
+	}
+
+	return reply.Kvs[0].Value, nil
+}
+
+func (s *etcdStore) Put(key string, value []byte) error {
+	var reply struct{}
+
+	return s.call("put", map[string][]byte{"key": []byte(key), "value": value}, &reply)
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LOG_FILE_MAX_SIZE = 100 // MB
+
+	// Suffix of the backups of a log file, recording when it was rotated
+	logFileBackupFormat = "2006-01-02T15-04-05.000"
+)
+
+/*
+RotatingFile is a log file which is rotated once it exceeds a maximum size.
+
+A rotated file is renamed after the time of its rotation (e.g. ponsim.log.2017-11-02T10-04-05.000)
+and the oldest backups are removed once there are more than MaxBackups of them, or once they are
+older than MaxAge days; a limit of 0 retains every backup.
+*/
+type RotatingFile struct {
+	Path       string `json:"path"`
+	MaxSize    int    `json:"max_size"`
+	MaxBackups int    `json:"max_backups"`
+	MaxAge     int    `json:"max_age"`
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+/*
+NewRotatingFile opens a log file, appending to its current content, rotated once it exceeds
+maxSize MB
+*/
+func NewRotatingFile(path string, maxSize int, maxBackups int, maxAge int) (*RotatingFile, error) {
+	if path == "" {
+		return nil, errors.New("no log file specified")
+	}
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("invalid maximum log file size: %d", maxSize)
+	}
+	if maxBackups < 0 || maxAge < 0 {
+		return nil, errors.New("invalid log file retention")
+	}
+
+	f := &RotatingFile{
+		Path:       path,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+		MaxAge:     maxAge,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+/*
+Write appends to the log file, rotating it first if the write would exceed its maximum size
+*/
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return 0, errors.New("log file closed")
+	}
+
+	if f.size > 0 && f.size+int64(len(p)) > int64(f.MaxSize)*1024*1024 {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+/*
+Close closes the log file
+*/
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+
+	return err
+}
+
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+
+	return nil
+}
+
+/*
+rotate renames the current log file after the current time, opens a new one and removes the
+backups exceeding the retention
+*/
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	backup := f.Path + "." + time.Now().Format(logFileBackupFormat)
+	if err := os.Rename(f.Path, backup); err != nil {
+		return err
+	}
+
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	f.prune()
+
+	return nil
+}
+
+/*
+prune removes the oldest backups beyond MaxBackups and those older than MaxAge days
+*/
+func (f *RotatingFile) prune() {
+	if f.MaxBackups == 0 && f.MaxAge == 0 {
+		return
+	}
+
+	backups, err := filepath.Glob(f.Path + ".*")
+	if err != nil {
+		return
+	}
+
+	// The backups sort in the order of their rotation, the newest last
+	var names []string
+	for _, backup := range backups {
+		suffix := strings.TrimPrefix(backup, f.Path+".")
+		if _, err := time.Parse(logFileBackupFormat, suffix); err == nil {
+			names = append(names, backup)
+		}
+	}
+	sort.Strings(names)
+
+	cutoff := time.Now().Add(-time.Duration(f.MaxAge) * 24 * time.Hour)
+	for i, name := range names {
+		if f.MaxBackups > 0 && len(names)-i > f.MaxBackups {
+			os.Remove(name)
+			continue
+		}
+		if info, err := os.Stat(name); err == nil && f.MaxAge > 0 && info.ModTime().Before(cutoff) {
+			os.Remove(name)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package common
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+/*
+LogSampler thins out the log entries emitted for every frame, which would otherwise dominate
+the logs and the cost of forwarding under load.
+
+An entry is emitted for one frame entry in Rate (1 emits them all), and at most Limit entries
+are emitted per second (0 for no limit).  The entries which are not emitted are counted, the
+first emitted entry after them reporting how many were suppressed.
+*/
+type LogSampler struct {
+	mutex       sync.Mutex
+	rate        int
+	limit       int
+	seen        uint64
+	windowStart time.Time
+	windowCount int
+	suppressed  uint64
+	pending     uint64
+}
+
+/*
+NewLogSampler instantiates a sampler emitting one entry in rate, and at most limit per second
+*/
+func NewLogSampler(rate int, limit int) (*LogSampler, error) {
+	s := &LogSampler{}
+	if err := s.Set(rate, limit); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+/*
+Set changes the sampling rate and the limit of entries per second
+*/
+func (s *LogSampler) Set(rate int, limit int) error {
+	if rate < 1 {
+		return errors.New("frame log sampling rate must be at least 1")
+	}
+	if limit < 0 {
+		return errors.New("frame log limit must not be negative")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.rate = rate
+	s.limit = limit
+	s.seen = 0
+
+	return nil
+}
+
+/*
+Get returns the sampling rate, the limit of entries per second and the number of entries
+suppressed so far
+*/
+func (s *LogSampler) Get() (int, int, uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.rate, s.limit, s.suppressed
+}
+
+/*
+Allow reports whether an entry is emitted, along with the number of entries suppressed since
+the last emitted one
+*/
+func (s *LogSampler) Allow() (bool, uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.seen++
+	allowed := s.rate == 1 || s.seen%uint64(s.rate) == 1
+
+	if allowed && s.limit > 0 {
+		now := time.Now()
+		if now.Sub(s.windowStart) >= time.Second {
+			s.windowStart = now
+			s.windowCount = 0
+		}
+		if s.windowCount >= s.limit {
+			allowed = false
+		} else {
+			s.windowCount++
+		}
+	}
+
+	if !allowed {
+		s.suppressed++
+		s.pending++
+		return false, 0
+	}
+
+	pending := s.pending
+	s.pending = 0
+
+	return true, pending
+}
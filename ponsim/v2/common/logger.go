@@ -16,15 +16,54 @@
 package common
 
 import (
+	"context"
+	"fmt"
 	"github.com/evalphobia/logrus_fluent"
 	"github.com/sirupsen/logrus"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
 )
 
+// Components whose log level is set independently of the rest of the simulator
+const (
+	LOG_COMPONENT_NBI        = "nbi"
+	LOG_COMPONENT_SBI        = "sbi"
+	LOG_COMPONENT_FORWARDING = "forwarding"
+	LOG_COMPONENT_ALARM      = "alarm"
+
+	// Name under which the level of the other entries is set
+	LOG_COMPONENT_DEFAULT = "default"
+)
+
+var LOG_COMPONENTS = []string{
+	LOG_COMPONENT_NBI,
+	LOG_COMPONENT_SBI,
+	LOG_COMPONENT_FORWARDING,
+	LOG_COMPONENT_ALARM,
+}
+
 type logManager struct {
 	*logrus.Logger
+
+	// Sampler of the entries logged for every frame
+	frames *LogSampler
+
+	// Loggers of the components, sharing the output, formatter and hooks of this one
+	components map[string]*logManager
+}
+
+/*
+logOutput writes the entries of a component to the output of the main logger, which may be
+replaced after the component was created
+*/
+type logOutput struct {
+	logger *logrus.Logger
+}
+
+func (o logOutput) Write(p []byte) (int, error) {
+	return o.logger.Out.Write(p)
 }
 
 // Singleton instance
@@ -76,6 +115,166 @@ func (mgr *logManager) SetFluentd(fluentdHost string) {
 	}).Info("Added fluentd hook")
 }
 
+/*
+SetSyslog ships the log entries to a syslog collector, in the format [udp://|tcp://]host[:port],
+with a facility and an optional static structured data element (see ParseSyslogStructuredData)
+*/
+func (mgr *logManager) SetSyslog(address string, facility string, structuredData string) error {
+	code, err := ParseSyslogFacility(facility)
+	if err != nil {
+		return err
+	}
+
+	sd, err := ParseSyslogStructuredData(structuredData)
+	if err != nil {
+		return err
+	}
+
+	hook, err := NewSyslogHook(address, code, sd)
+	if err != nil {
+		return err
+	}
+
+	mgr.AddHook(hook)
+
+	mgr.WithFields(logrus.Fields{
+		"network":  hook.Network,
+		"address":  hook.Address,
+		"facility": hook.Facility,
+	}).Info("Added syslog hook")
+
+	return nil
+}
+
+/*
+SetLogFile writes the log entries to a file instead of the standard output, rotated once it
+exceeds maxSize MB and retaining at most maxBackups rotated files of at most maxAge days
+*/
+func (mgr *logManager) SetLogFile(path string, maxSize int, maxBackups int, maxAge int) error {
+	file, err := NewRotatingFile(path, maxSize, maxBackups, maxAge)
+	if err != nil {
+		return err
+	}
+
+	mgr.Out = file
+
+	mgr.WithFields(logrus.Fields{
+		"path":        file.Path,
+		"max_size":    file.MaxSize,
+		"max_backups": file.MaxBackups,
+		"max_age":     file.MaxAge,
+	}).Info("Logging to file")
+
+	return nil
+}
+
+/*
+FrameSampler returns the sampler of the entries logged for every frame
+*/
+func (mgr *logManager) FrameSampler() *LogSampler {
+	return mgr.frames
+}
+
+/*
+ForFrame returns a log entry for a frame at a level, or nil when the level is disabled or the
+entry is suppressed by the frame sampler; the entry reports the number of frame entries
+suppressed since the previous one
+*/
+func (mgr *logManager) ForFrame(level logrus.Level) *logrus.Entry {
+	if mgr.GetLevel() < level {
+		return nil
+	}
+
+	allowed, suppressed := mgr.frames.Allow()
+	if !allowed {
+		return nil
+	}
+	if suppressed > 0 {
+		return mgr.WithField("suppressedFrames", suppressed)
+	}
+
+	return logrus.NewEntry(mgr.Logger)
+}
+
+/*
+Component returns the logger of a component, whose level is set independently of the other
+entries; the main logger is returned for an unknown component
+*/
+func (mgr *logManager) Component(name string) *logManager {
+	if component, ok := mgr.components[name]; ok {
+		return component
+	}
+
+	return mgr
+}
+
+/*
+SetComponentLevel changes the level of the entries of a component, or of those of no component when
+the name is default
+*/
+func (mgr *logManager) SetComponentLevel(name string, level string) error {
+	lvl, err := logrus.ParseLevel(strings.TrimSpace(level))
+	if err != nil {
+		return err
+	}
+
+	if name != LOG_COMPONENT_DEFAULT {
+		if _, ok := mgr.components[name]; !ok {
+			return fmt.Errorf("unknown log component: %s", name)
+		}
+	}
+
+	mgr.Component(name).SetLevel(lvl)
+
+	return nil
+}
+
+/*
+ComponentLevels returns the level of the entries of each component, and of those of no
+component under default
+*/
+func (mgr *logManager) ComponentLevels() map[string]string {
+	levels := map[string]string{LOG_COMPONENT_DEFAULT: mgr.GetLevel().String()}
+	for name, component := range mgr.components {
+		levels[name] = component.GetLevel().String()
+	}
+
+	return levels
+}
+
+/*
+SetComponentLevels changes the levels of components given as component=level entries separated
+by commas, e.g. forwarding=warn,nbi=debug
+*/
+func (mgr *logManager) SetComponentLevels(spec string) error {
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		nv := strings.SplitN(entry, "=", 2)
+		if len(nv) != 2 {
+			return fmt.Errorf("invalid log level %s: expected component=level", entry)
+		}
+		if err := mgr.SetComponentLevel(strings.TrimSpace(nv[0]), nv[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ForContext returns a log entry holding the correlation ID of the request being processed, if any
+*/
+func (mgr *logManager) ForContext(ctx context.Context) *logrus.Entry {
+	if id := RequestIdFromContext(ctx); id != "" {
+		return mgr.WithField("requestId", id)
+	}
+
+	return logrus.NewEntry(mgr.Logger)
+}
+
 /**
  * Get instance
  *
@@ -89,7 +288,26 @@ func Logger() *logManager {
 		_logger.Level = logrus.DebugLevel
 		//_logger.Out =
 
-		mgrInstance = &logManager{_logger}
+		frames, _ := NewLogSampler(1, 0)
+
+		mgrInstance = &logManager{
+			Logger:     _logger,
+			frames:     frames,
+			components: make(map[string]*logManager),
+		}
+
+		for _, name := range LOG_COMPONENTS {
+			component := logrus.New()
+			component.Out = logOutput{_logger}
+			component.Formatter = _logger.Formatter
+			component.Hooks = _logger.Hooks
+			component.Level = _logger.Level
+
+			mgrInstance.components[name] = &logManager{
+				Logger: component,
+				frames: frames,
+			}
+		}
 	})
 
 	return mgrInstance
@@ -16,6 +16,7 @@
 package common
 
 import (
+	"crypto/sha256"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/sirupsen/logrus"
@@ -120,3 +121,116 @@ func GetUdpLayer(frame gopacket.Packet) *layers.UDP {
 	}
 	return udp
 }
+func GetIpv6Layer(frame gopacket.Packet) *layers.IPv6 {
+	ip := &layers.IPv6{}
+	if ipLayer := frame.Layer(layers.LayerTypeIPv6); ipLayer != nil {
+		ip, _ = ipLayer.(*layers.IPv6)
+	}
+	return ip
+}
+func GetTcpLayer(frame gopacket.Packet) *layers.TCP {
+	tcp := &layers.TCP{}
+	if tcpLayer := frame.Layer(layers.LayerTypeTCP); tcpLayer != nil {
+		tcp, _ = tcpLayer.(*layers.TCP)
+	}
+	return tcp
+}
+func GetIcmpv6Layer(frame gopacket.Packet) *layers.ICMPv6 {
+	var icmp *layers.ICMPv6
+	if icmpLayer := frame.Layer(layers.LayerTypeICMPv6); icmpLayer != nil {
+		icmp, _ = icmpLayer.(*layers.ICMPv6)
+	}
+	return icmp
+}
+
+/*
+GetIpProtocol returns the protocol carried by the IPv4 or IPv6 header of a frame, skipping
+the IPv6 hop-by-hop options header (e.g. of MLD reports)
+*/
+func GetIpProtocol(frame gopacket.Packet) (layers.IPProtocol, bool) {
+	if ipLayer := frame.Layer(layers.LayerTypeIPv4); ipLayer != nil {
+		return ipLayer.(*layers.IPv4).Protocol, true
+	}
+	if ipLayer := frame.Layer(layers.LayerTypeIPv6); ipLayer != nil {
+		ip := ipLayer.(*layers.IPv6)
+		if ip.HopByHop != nil {
+			return ip.HopByHop.NextHeader, true
+		}
+		return ip.NextHeader, true
+	}
+
+	return 0, false
+}
+
+/*
+IsControlFrame reports whether a frame carries control traffic (EAPOL, DHCP, IGMP, DHCPv6,
+or the ICMPv6 messages of neighbor discovery and MLD)
+*/
+func IsControlFrame(frame gopacket.Packet) bool {
+	if frame.Layer(layers.LayerTypeEAPOL) != nil {
+		return true
+	}
+	if frame.Layer(layers.LayerTypeIPv4) != nil && GetIpLayer(frame).Protocol == layers.IPProtocolIGMP {
+		return true
+	}
+	if frame.Layer(layers.LayerTypeUDP) != nil {
+		udp := GetUdpLayer(frame)
+		if (udp.SrcPort == 67 || udp.SrcPort == 68) && (udp.DstPort == 67 || udp.DstPort == 68) {
+			return true
+		}
+		if (udp.SrcPort == 546 || udp.SrcPort == 547) && (udp.DstPort == 546 || udp.DstPort == 547) {
+			return true
+		}
+	}
+	if icmp := GetIcmpv6Layer(frame); icmp != nil {
+		switch icmp.TypeCode.Type() {
+		case layers.ICMPv6TypeRouterSolicitation, layers.ICMPv6TypeRouterAdvertisement,
+			layers.ICMPv6TypeNeighborSolicitation, layers.ICMPv6TypeNeighborAdvertisement,
+			layers.ICMPv6TypeMLDv1MulticastListenerQueryMessage, layers.ICMPv6TypeMLDv1MulticastListenerReportMessage,
+			layers.ICMPv6TypeMLDv1MulticastListenerDoneMessage, layers.ICMPv6TypeMLDv2MulticastListenerReportMessageV2:
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+frameHash is the integrity hash attached to the metadata of a frame
+*/
+type frameHash []byte
+
+/*
+HashFrame computes the integrity hash of the content of a frame
+*/
+func HashFrame(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+/*
+SetFrameHash attaches an integrity hash to the metadata of a frame
+*/
+func SetFrameHash(frame gopacket.Packet, hash []byte) {
+	metadata := frame.Metadata()
+	for i, data := range metadata.AncillaryData {
+		if _, ok := data.(frameHash); ok {
+			metadata.AncillaryData[i] = frameHash(hash)
+			return
+		}
+	}
+	metadata.AncillaryData = append(metadata.AncillaryData, frameHash(hash))
+}
+
+/*
+GetFrameHash returns the integrity hash attached to a frame, if any
+*/
+func GetFrameHash(frame gopacket.Packet) []byte {
+	for _, data := range frame.Metadata().AncillaryData {
+		if hash, ok := data.(frameHash); ok {
+			return hash
+		}
+	}
+
+	return nil
+}
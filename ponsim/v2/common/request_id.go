@@ -0,0 +1,159 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package common
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// Metadata in which the correlation ID of a request is carried across the simulated devices
+	REQUEST_ID_METADATA = "x-request-id"
+)
+
+type requestIdKey struct{}
+
+/*
+WithRequestId attaches a correlation ID to a context
+*/
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+/*
+RequestIdFromContext returns the correlation ID attached to a context, or an empty string
+*/
+func RequestIdFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+/*
+incomingRequestId returns the correlation ID received from the caller, or generates one
+*/
+func incomingRequestId(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md[REQUEST_ID_METADATA]; len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
+	}
+
+	return uuid.New().String()
+}
+
+/*
+outgoingRequestId adds the correlation ID attached to a context to the metadata of the
+outgoing requests
+*/
+func outgoingRequestId(ctx context.Context) context.Context {
+	id := RequestIdFromContext(ctx)
+	if id == "" {
+		return ctx
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	md.Set(REQUEST_ID_METADATA, id)
+
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+/*
+RequestIdUnaryServerInterceptor attaches the correlation ID of a request to its context,
+and returns it to the caller in the response header
+*/
+func RequestIdUnaryServerInterceptor(
+	ctx context.Context,
+	request interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	id := incomingRequestId(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs(REQUEST_ID_METADATA, id))
+
+	return handler(WithRequestId(ctx, id), request)
+}
+
+/*
+requestIdServerStream overrides the context of a stream with one holding its correlation ID
+*/
+type requestIdServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *requestIdServerStream) Context() context.Context {
+	return s.ctx
+}
+
+/*
+RequestIdStreamServerInterceptor attaches the correlation ID of a stream to its context,
+and returns it to the caller in the response header
+*/
+func RequestIdStreamServerInterceptor(
+	server interface{},
+	stream grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	id := incomingRequestId(stream.Context())
+	stream.SetHeader(metadata.Pairs(REQUEST_ID_METADATA, id))
+
+	return handler(server, &requestIdServerStream{stream, WithRequestId(stream.Context(), id)})
+}
+
+/*
+RequestIdUnaryClientInterceptor propagates the correlation ID of the context to the callee
+*/
+func RequestIdUnaryClientInterceptor(
+	ctx context.Context,
+	method string,
+	request interface{},
+	reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	return invoker(outgoingRequestId(ctx), method, request, reply, cc, opts...)
+}
+
+/*
+RequestIdStreamClientInterceptor propagates the correlation ID of the context to the callee
+*/
+func RequestIdStreamClientInterceptor(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	return streamer(outgoingRequestId(ctx), desc, cc, method, opts...)
+}
+
+/*
+RequestIdDialOptions returns the options of the connections on which the correlation ID of
+the requests is propagated
+*/
+func RequestIdDialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithUnaryInterceptor(RequestIdUnaryClientInterceptor),
+		grpc.WithStreamInterceptor(RequestIdStreamClientInterceptor),
+	}
+}
@@ -0,0 +1,364 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package common
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SYSLOG_APP_NAME         = "ponsim"
+	SYSLOG_DEFAULT_FACILITY = "local0"
+	SYSLOG_DEFAULT_PORT     = "514"
+	SYSLOG_DIAL_TIMEOUT     = 5 * time.Second
+	SYSLOG_WRITE_TIMEOUT    = time.Second
+
+	// SD-ID of the element carrying the fields of the log entries, under the private enterprise
+	// number reserved for documentation (RFC 5612)
+	SYSLOG_FIELDS_SD_ID = "fields@32473"
+
+	// Longest message sent in a UDP datagram, as every receiver must accept (RFC 5426)
+	SYSLOG_MAX_UDP_MESSAGE = 2048
+)
+
+// Codes of the syslog facilities, by name (RFC 5424)
+var syslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"ntp":      12,
+	"security": 13,
+	"console":  14,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+/*
+SyslogHook is a logrus hook shipping the log entries to a syslog collector as RFC 5424 messages,
+over UDP (RFC 5426) or TCP with octet counting framing (RFC 6587).
+
+The fields of an entry are carried as the parameters of a structured data element, following
+the static element configured for the simulator, if any.  The connection is re-established on
+the next entry after a failure; entries which cannot be delivered are dropped, since a logging
+failure must not disturb the simulation.
+*/
+type SyslogHook struct {
+	Network        string `json:"network"`
+	Address        string `json:"address"`
+	Facility       int    `json:"facility"`
+	StructuredData string `json:"structured_data"`
+
+	hostname string
+	procId   string
+	mutex    sync.Mutex
+	conn     net.Conn
+}
+
+/*
+NewSyslogHook instantiates a hook shipping the entries to a collector, whose address is in the
+format [udp://|tcp://]host[:port]
+*/
+func NewSyslogHook(address string, facility int, structuredData string) (*SyslogHook, error) {
+	network := "udp"
+	if i := strings.Index(address, "://"); i >= 0 {
+		network, address = strings.ToLower(address[:i]), address[i+3:]
+	}
+	if network != "udp" && network != "tcp" {
+		return nil, fmt.Errorf("unsupported syslog transport: %s", network)
+	}
+	if address == "" {
+		return nil, errors.New("no syslog collector specified")
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, SYSLOG_DEFAULT_PORT)
+	}
+	if facility < 0 || facility > 23 {
+		return nil, fmt.Errorf("invalid syslog facility: %d", facility)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	hook := &SyslogHook{
+		Network:        network,
+		Address:        address,
+		Facility:       facility,
+		StructuredData: structuredData,
+		hostname:       syslogHeaderField(hostname, 255),
+		procId:         strconv.Itoa(os.Getpid()),
+	}
+
+	// Fail early on an unreachable collector rather than silently dropping every entry
+	conn, err := hook.connect()
+	if err != nil {
+		return nil, err
+	}
+	hook.conn = conn
+
+	return hook, nil
+}
+
+/*
+ParseSyslogFacility returns the code of a syslog facility, given by name (e.g. local0) or code
+*/
+func ParseSyslogFacility(name string) (int, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if facility, ok := syslogFacilities[name]; ok {
+		return facility, nil
+	}
+	if facility, err := strconv.Atoi(name); err == nil && facility >= 0 && facility <= 23 {
+		return facility, nil
+	}
+
+	return 0, fmt.Errorf("unknown syslog facility: %s", name)
+}
+
+/*
+ParseSyslogStructuredData formats a structured data element given as its SD-ID followed by
+name=value parameters separated by commas, e.g. olt@32473,site=lab,rack=3
+*/
+func ParseSyslogStructuredData(spec string) (string, error) {
+	if spec = strings.TrimSpace(spec); spec == "" {
+		return "", nil
+	}
+
+	entries := strings.Split(spec, ",")
+	id := strings.TrimSpace(entries[0])
+	if !isSyslogSdName(id) {
+		return "", fmt.Errorf("invalid syslog structured data id: %s", id)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("[" + id)
+	for _, entry := range entries[1:] {
+		nv := strings.SplitN(entry, "=", 2)
+		name := strings.TrimSpace(nv[0])
+		if len(nv) != 2 || !isSyslogSdName(name) {
+			return "", fmt.Errorf("invalid syslog structured data parameter: %s", entry)
+		}
+		writeSyslogParam(&b, name, strings.TrimSpace(nv[1]))
+	}
+	b.WriteString("]")
+
+	return b.String(), nil
+}
+
+/*
+Levels returns the levels of the entries shipped by the hook, which are all those logged
+*/
+func (h *SyslogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+/*
+Fire ships an entry to the collector
+*/
+func (h *SyslogHook) Fire(entry *logrus.Entry) error {
+	message := h.format(entry)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.conn == nil {
+		conn, err := h.connect()
+		if err != nil {
+			return err
+		}
+		h.conn = conn
+	}
+
+	h.conn.SetWriteDeadline(time.Now().Add(SYSLOG_WRITE_TIMEOUT))
+	if _, err := h.conn.Write(message); err != nil {
+		h.conn.Close()
+		h.conn = nil
+		return err
+	}
+
+	return nil
+}
+
+/*
+Close releases the connection to the collector
+*/
+func (h *SyslogHook) Close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.conn != nil {
+		h.conn.Close()
+		h.conn = nil
+	}
+}
+
+func (h *SyslogHook) connect() (net.Conn, error) {
+	conn, err := net.DialTimeout(h.Network, h.Address, SYSLOG_DIAL_TIMEOUT)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog collector %s: %s", h.Address, err.Error())
+	}
+
+	return conn, nil
+}
+
+/*
+format encodes an entry as an RFC 5424 message, framed for the transport of the hook
+*/
+func (h *SyslogHook) format(entry *logrus.Entry) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s - ",
+		h.Facility*8+syslogSeverity(entry.Level),
+		entry.Time.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		h.hostname, SYSLOG_APP_NAME, h.procId)
+
+	b.WriteString(h.StructuredData)
+	if len(entry.Data) > 0 {
+		names := make([]string, 0, len(entry.Data))
+		for name := range entry.Data {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		b.WriteString("[" + SYSLOG_FIELDS_SD_ID)
+		for _, name := range names {
+			value := entry.Data[name]
+			if err, ok := value.(error); ok {
+				value = err.Error()
+			}
+			writeSyslogParam(&b, syslogSdName(name), fmt.Sprint(value))
+		}
+		b.WriteString("]")
+	} else if h.StructuredData == "" {
+		b.WriteString("-")
+	}
+
+	if entry.Message != "" {
+		b.WriteString(" " + entry.Message)
+	}
+
+	if h.Network == "tcp" {
+		return append([]byte(strconv.Itoa(b.Len())+" "), b.Bytes()...)
+	}
+	if b.Len() > SYSLOG_MAX_UDP_MESSAGE {
+		b.Truncate(SYSLOG_MAX_UDP_MESSAGE)
+	}
+	return b.Bytes()
+}
+
+/*
+syslogSeverity maps the level of an entry to a syslog severity
+*/
+func syslogSeverity(level logrus.Level) int {
+	switch level {
+	case logrus.PanicLevel:
+		return 0
+	case logrus.FatalLevel:
+		return 2
+	case logrus.ErrorLevel:
+		return 3
+	case logrus.WarnLevel:
+		return 4
+	case logrus.InfoLevel:
+		return 6
+	}
+	return 7
+}
+
+/*
+writeSyslogParam appends a structured data parameter, escaping its value
+*/
+func writeSyslogParam(b *bytes.Buffer, name string, value string) {
+	b.WriteString(" " + name + "=\"")
+	for _, c := range value {
+		if c == '"' || c == '\\' || c == ']' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	b.WriteString("\"")
+}
+
+/*
+isSyslogSdName reports whether a name is a valid SD-ID or parameter name: 1 to 32 printable
+US-ASCII characters other than '=', space, ']' and '"'
+*/
+func isSyslogSdName(name string) bool {
+	return name != "" && syslogSdName(name) == name
+}
+
+/*
+syslogSdName replaces the characters of a field name which are not allowed in a parameter name
+*/
+func syslogSdName(name string) string {
+	if len(name) > 32 {
+		name = name[:32]
+	}
+
+	b := []byte(name)
+	for i, c := range b {
+		if c <= ' ' || c >= 127 || c == '=' || c == ']' || c == '"' {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
+
+/*
+syslogHeaderField replaces the characters of a header field which are not printable US-ASCII
+and bounds its length
+*/
+func syslogHeaderField(value string, length int) string {
+	if len(value) > length {
+		value = value[:length]
+	}
+
+	b := []byte(value)
+	for i, c := range b {
+		if c <= ' ' || c >= 127 {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
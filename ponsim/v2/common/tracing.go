@@ -0,0 +1,505 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TRACE_SERVICE_NAME    = "ponsim"
+	TRACE_PARENT_HEADER   = "traceparent"
+	TRACE_QUEUE_SIZE      = 4096
+	TRACE_BATCH_SIZE      = 512
+	TRACE_EXPORT_INTERVAL = time.Second
+	TRACE_EXPORT_TIMEOUT  = 5 * time.Second
+)
+
+// Kinds of spans, as defined by OpenTelemetry
+const (
+	SPAN_KIND_INTERNAL = 1
+	SPAN_KIND_SERVER   = 2
+	SPAN_KIND_CLIENT   = 3
+	SPAN_KIND_PRODUCER = 4
+	SPAN_KIND_CONSUMER = 5
+)
+
+/*
+SpanContext identifies a span within its trace, as carried by a W3C traceparent header
+*/
+type SpanContext struct {
+	TraceId [16]byte
+	SpanId  [8]byte
+	Sampled bool
+}
+
+/*
+IsValid reports whether the context identifies a span
+*/
+func (c SpanContext) IsValid() bool {
+	return c.TraceId != [16]byte{} && c.SpanId != [8]byte{}
+}
+
+/*
+Traceparent formats the context as a W3C traceparent header, e.g.
+00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+*/
+func (c SpanContext) Traceparent() string {
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+
+	return "00-" + hex.EncodeToString(c.TraceId[:]) + "-" + hex.EncodeToString(c.SpanId[:]) + "-" + flags
+}
+
+/*
+ParseTraceparent parses a W3C traceparent header; it returns false if the header is malformed
+*/
+func ParseTraceparent(header string) (SpanContext, bool) {
+	var c SpanContext
+
+	fields := strings.Split(strings.TrimSpace(header), "-")
+	if len(fields) < 4 || len(fields[0]) != 2 || fields[0] == "ff" ||
+		len(fields[1]) != 32 || len(fields[2]) != 16 || len(fields[3]) != 2 {
+		return c, false
+	}
+
+	if _, err := hex.Decode(c.TraceId[:], []byte(fields[1])); err != nil {
+		return c, false
+	}
+	if _, err := hex.Decode(c.SpanId[:], []byte(fields[2])); err != nil {
+		return c, false
+	}
+	flags, err := strconv.ParseUint(fields[3], 16, 8)
+	if err != nil {
+		return c, false
+	}
+	c.Sampled = flags&1 == 1
+
+	return c, c.IsValid()
+}
+
+type spanContextKey struct{}
+
+/*
+WithSpanContext attaches the context of a span to a context, so that the spans started from
+it become its children
+*/
+func WithSpanContext(ctx context.Context, c SpanContext) context.Context {
+	if !c.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey{}, c)
+}
+
+/*
+SpanContextFromContext returns the context of the span attached to a context, if any
+*/
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	c, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return c
+}
+
+/*
+InjectTraceMetadata adds the traceparent of the span attached to a context to the metadata
+of the outgoing GRPC requests
+*/
+func InjectTraceMetadata(ctx context.Context) context.Context {
+	c := SpanContextFromContext(ctx)
+	if !c.IsValid() {
+		return ctx
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	md.Set(TRACE_PARENT_HEADER, c.Traceparent())
+
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+/*
+ExtractTraceMetadata returns the span context carried by the traceparent of an incoming
+GRPC request, if any
+*/
+func ExtractTraceMetadata(ctx context.Context) SpanContext {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md[TRACE_PARENT_HEADER]; len(values) > 0 {
+			c, _ := ParseTraceparent(values[0])
+			return c
+		}
+	}
+
+	return SpanContext{}
+}
+
+/*
+frameTrace is the span context attached to the metadata of a frame
+*/
+type frameTrace SpanContext
+
+/*
+SetFrameTrace attaches the context of the span which processed a frame to its metadata, so
+that the trace can be continued by the next hop
+*/
+func SetFrameTrace(frame gopacket.Packet, c SpanContext) {
+	frameMetadata := frame.Metadata()
+	for i, data := range frameMetadata.AncillaryData {
+		if _, ok := data.(frameTrace); ok {
+			frameMetadata.AncillaryData[i] = frameTrace(c)
+			return
+		}
+	}
+	frameMetadata.AncillaryData = append(frameMetadata.AncillaryData, frameTrace(c))
+}
+
+/*
+GetFrameTrace returns the span context attached to a frame, if any
+*/
+func GetFrameTrace(frame gopacket.Packet) SpanContext {
+	for _, data := range frame.Metadata().AncillaryData {
+		if c, ok := data.(frameTrace); ok {
+			return SpanContext(c)
+		}
+	}
+
+	return SpanContext{}
+}
+
+/*
+StartFrameSpan starts a span continuing the trace attached to a frame
+*/
+func StartFrameSpan(frame gopacket.Packet, name string, kind int) *Span {
+	_, span := StartSpan(WithSpanContext(context.Background(), GetFrameTrace(frame)), name, kind)
+	return span
+}
+
+/*
+Span is a timed operation of a trace.  All the methods of a nil span are no-ops, so that
+the code being traced does not depend on whether tracing is enabled.
+*/
+type Span struct {
+	Name       string
+	Kind       int
+	Context    SpanContext
+	Parent     [8]byte
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Error      string
+
+	tracer *Tracer
+}
+
+/*
+SpanContext returns the context identifying the span
+*/
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.Context
+}
+
+/*
+SetAttribute records a string, boolean or numeric attribute of the span
+*/
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.Attributes[key] = value
+}
+
+/*
+SetError marks the span as failed
+*/
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Error = err.Error()
+}
+
+/*
+Finish ends the span and queues it for export
+*/
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.End = time.Now()
+	s.tracer.queue(s)
+}
+
+/*
+Tracer exports the spans of the simulator to an OpenTelemetry collector, or to Jaeger,
+using the JSON encoding of OTLP over HTTP.
+
+Like the Kafka producer, it implements the subset of the protocol it needs so that the
+simulator does not depend on the OpenTelemetry SDK.  Spans are exported in batches by a
+background routine and dropped when the export does not keep up with the traffic.
+*/
+type Tracer struct {
+	Endpoint string  `json:"endpoint"`
+	Ratio    float64 `json:"ratio"`
+	Service  string  `json:"service"`
+
+	spans   chan *Span
+	client  *http.Client
+	dropped uint64
+	mutex   sync.Mutex
+}
+
+var tracer *Tracer
+
+/*
+NewTracer instantiates a tracer exporting to an OTLP/HTTP endpoint, e.g.
+http://jaeger:4318/v1/traces, which samples a ratio (between 0 and 1) of the new traces
+*/
+func NewTracer(endpoint string, ratio float64) (*Tracer, error) {
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("invalid trace endpoint: %s", endpoint)
+	}
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("invalid trace sampling ratio: %g", ratio)
+	}
+
+	return &Tracer{
+		Endpoint: endpoint,
+		Ratio:    ratio,
+		Service:  TRACE_SERVICE_NAME,
+		spans:    make(chan *Span, TRACE_QUEUE_SIZE),
+		client:   &http.Client{Timeout: TRACE_EXPORT_TIMEOUT},
+	}, nil
+}
+
+/*
+SetTracer enables the tracing of the simulator
+*/
+func SetTracer(t *Tracer) {
+	tracer = t
+}
+
+/*
+StartSpan starts a span, child of the span attached to the context if any.  It returns a
+context to which the new span is attached, and a nil span if tracing is disabled or the
+trace is not sampled.
+*/
+func StartSpan(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	parent := SpanContextFromContext(ctx)
+	span := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: make(map[string]interface{}),
+		tracer:     tracer,
+	}
+
+	if parent.IsValid() {
+		if !parent.Sampled {
+			return ctx, nil
+		}
+		span.Context.TraceId = parent.TraceId
+		span.Parent = parent.SpanId
+	} else {
+		rand.Read(span.Context.TraceId[:])
+		if !tracer.sample(span.Context.TraceId) {
+			return ctx, nil
+		}
+	}
+	rand.Read(span.Context.SpanId[:])
+	span.Context.Sampled = true
+
+	return WithSpanContext(ctx, span.Context), span
+}
+
+/*
+sample decides whether a new trace is recorded, from the random part of its identifier so
+that the decision is consistent across the simulated devices
+*/
+func (t *Tracer) sample(traceId [16]byte) bool {
+	if t.Ratio >= 1 {
+		return true
+	}
+	return float64(binary.BigEndian.Uint64(traceId[8:])>>11)/float64(1<<53) < t.Ratio
+}
+
+func (t *Tracer) queue(span *Span) {
+	select {
+	case t.spans <- span:
+	default:
+		t.mutex.Lock()
+		t.dropped++
+		t.mutex.Unlock()
+	}
+}
+
+/*
+Start exports the finished spans until the context is cancelled
+*/
+func (t *Tracer) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(TRACE_EXPORT_INTERVAL)
+		defer ticker.Stop()
+
+		var batch []*Span
+		for {
+			select {
+			case span := <-t.spans:
+				if batch = append(batch, span); len(batch) < TRACE_BATCH_SIZE {
+					continue
+				}
+			case <-ticker.C:
+			case <-ctx.Done():
+				t.export(batch)
+				return
+			}
+
+			t.export(batch)
+			batch = nil
+		}
+	}()
+}
+
+/*
+export sends a batch of spans to the collector.  Failures are logged and the spans are lost.
+*/
+func (t *Tracer) export(batch []*Span) {
+	t.mutex.Lock()
+	dropped := t.dropped
+	t.dropped = 0
+	t.mutex.Unlock()
+
+	if dropped > 0 {
+		Logger().WithFields(logrus.Fields{
+			"endpoint": t.Endpoint,
+			"dropped":  dropped,
+		}).Warn("Dropped spans which could not be exported in time")
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(t.encode(batch))
+	if err == nil {
+		var response *http.Response
+		if response, err = t.client.Post(t.Endpoint, "application/json", bytes.NewReader(body)); err == nil {
+			response.Body.Close()
+			if response.StatusCode != http.StatusOK {
+				err = fmt.Errorf("collector returned %s", response.Status)
+			}
+		}
+	}
+
+	if err != nil {
+		Logger().WithFields(logrus.Fields{
+			"endpoint": t.Endpoint,
+			"spans":    len(batch),
+			"error":    err.Error(),
+		}).Error("Failed to export spans")
+	}
+}
+
+/*
+otlpAttribute is a key/value pair in the JSON encoding of OTLP
+*/
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func newOtlpAttribute(key string, value interface{}) otlpAttribute {
+	switch v := value.(type) {
+	case bool:
+		return otlpAttribute{key, map[string]interface{}{"boolValue": v}}
+	case int:
+		return otlpAttribute{key, map[string]interface{}{"intValue": strconv.Itoa(v)}}
+	case int32:
+		return otlpAttribute{key, map[string]interface{}{"intValue": strconv.Itoa(int(v))}}
+	case uint32:
+		return otlpAttribute{key, map[string]interface{}{"intValue": strconv.Itoa(int(v))}}
+	case float64:
+		return otlpAttribute{key, map[string]interface{}{"doubleValue": v}}
+	}
+	return otlpAttribute{key, map[string]interface{}{"stringValue": fmt.Sprint(value)}}
+}
+
+/*
+encode converts a batch of spans to an OTLP trace export request
+*/
+func (t *Tracer) encode(batch []*Span) map[string]interface{} {
+	var spans []map[string]interface{}
+
+	for _, span := range batch {
+		attributes := []otlpAttribute{}
+		for key, value := range span.Attributes {
+			attributes = append(attributes, newOtlpAttribute(key, value))
+		}
+
+		encoded := map[string]interface{}{
+			"traceId":           hex.EncodeToString(span.Context.TraceId[:]),
+			"spanId":            hex.EncodeToString(span.Context.SpanId[:]),
+			"name":              span.Name,
+			"kind":              span.Kind,
+			"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+			"attributes":        attributes,
+		}
+		if span.Parent != [8]byte{} {
+			encoded["parentSpanId"] = hex.EncodeToString(span.Parent[:])
+		}
+		if span.Error != "" {
+			encoded["status"] = map[string]interface{}{"code": 2, "message": span.Error}
+		}
+
+		spans = append(spans, encoded)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttribute{newOtlpAttribute("service.name", t.Service)},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": TRACE_SERVICE_NAME},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+}
@@ -17,6 +17,7 @@ package core
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
@@ -25,9 +26,13 @@ import (
 	"github.com/sirupsen/logrus"
 	"math/rand"
 	"net"
+	"strings"
 	"time"
 )
 
+// Logger of the alarm simulation, whose level is set independently
+var alarmLogger = common.Logger().Component(common.LOG_COMPONENT_ALARM)
+
 // TODO: user-defined values? min/max intervals, vlan?
 
 const (
@@ -39,6 +44,10 @@ const (
 	ipVersion   = 4
 )
 
+const (
+	DEFAULT_ALARM_TOPIC = "voltha.alarms"
+)
+
 type Alarm struct {
 	Severity    int    `json:"severity"`
 	Type        int    `json:"type"`
@@ -46,6 +55,33 @@ type Alarm struct {
 	State       int    `json:"state"`
 	TimeStamp   int    `json:"ts"`
 	Description string `json:"description"`
+
+	raisedAt time.Time
+}
+
+/*
+MakeProto converts the alarm raised by a device to a VOLTHA alarm event
+*/
+func (a *Alarm) MakeProto(device string, reportedAt time.Time) *voltha.AlarmEvent {
+	event := &voltha.AlarmEvent{
+		Id:          fmt.Sprintf("%s.%s.%d", KPI_PREFIX, device, a.raisedAt.UnixNano()),
+		Type:        voltha.AlarmEventType_AlarmEventType(a.Type),
+		Category:    voltha.AlarmEventCategory_AlarmEventCategory(a.Category),
+		State:       voltha.AlarmEventState_AlarmEventState(a.State),
+		Severity:    voltha.AlarmEventSeverity_AlarmEventSeverity(a.Severity),
+		RaisedTs:    epochSeconds(a.raisedAt),
+		ReportedTs:  epochSeconds(reportedAt),
+		ChangedTs:   epochSeconds(reportedAt),
+		ResourceId:  device,
+		Description: a.Description,
+		Context:     map[string]string{"simulated": "true"},
+	}
+
+	return event
+}
+
+func epochSeconds(t time.Time) float32 {
+	return float32(float64(t.UnixNano()) / float64(time.Second))
 }
 
 /*
@@ -55,6 +91,10 @@ type PonSimAlarm struct {
 	forwardFunction func(int, gopacket.Packet)
 	dstInterface    string
 	dstEndpoint     string
+	clock           *PonSimClock
+	counter         *PonSimMetricCounter
+	device          string
+	sink            *common.KafkaProducer
 }
 
 /*
@@ -73,7 +113,8 @@ func (a *PonSimAlarm) prepareAlarm() *Alarm {
 	alarm_type := rand.Intn(len(voltha.AlarmEventType_AlarmEventType_value))
 	alarm_category := rand.Intn(len(voltha.AlarmEventCategory_AlarmEventCategory_value))
 	alarm_state := int(voltha.AlarmEventState_RAISED)
-	alarm_ts := time.Now().UTC().Second()
+	alarm_raised := a.clock.Now()
+	alarm_ts := alarm_raised.UTC().Second()
 	alarm_description := fmt.Sprintf("%s.%s alarm",
 		voltha.AlarmEventType_AlarmEventType_name[int32(alarm_type)],
 		voltha.AlarmEventCategory_AlarmEventCategory_name[int32(alarm_category)],
@@ -86,6 +127,7 @@ func (a *PonSimAlarm) prepareAlarm() *Alarm {
 		State:       alarm_state,
 		TimeStamp:   alarm_ts,
 		Description: alarm_description,
+		raisedAt:    alarm_raised,
 	}
 
 	return alarm
@@ -108,7 +150,7 @@ func (a *PonSimAlarm) sendAlarm(alarm *Alarm) {
 		VLANIdentifier: vlandId,
 	}
 
-	common.Logger().WithFields(logrus.Fields{
+	alarmLogger.WithFields(logrus.Fields{
 		"Alarm": a,
 		"srcIp": common.GetInterfaceIP(a.dstInterface),
 		"dstIp": common.GetHostIP(a.dstEndpoint),
@@ -157,18 +199,45 @@ func (a *PonSimAlarm) sendAlarm(alarm *Alarm) {
 	// Forward the packetized alarm to the network
 	a.forwardFunction(0, frame)
 
-	common.Logger().WithFields(logrus.Fields{
+	alarmLogger.WithFields(logrus.Fields{
 		"Alarm": alarm,
 		"Frame": frame.Dump(),
 	}).Debug("Sent alarm")
 }
 
+/*
+publishAlarm sends the alarm as a VOLTHA alarm event to the Kafka sink, if any
+*/
+func (a *PonSimAlarm) publishAlarm(alarm *Alarm) {
+	if a.sink == nil {
+		return
+	}
+
+	message, err := kafkaMarshaler.MarshalToString(alarm.MakeProto(a.device, a.clock.Now()))
+	if err == nil {
+		err = a.sink.Send([]byte(a.device), []byte(message))
+	}
+
+	if err != nil {
+		alarmLogger.WithFields(logrus.Fields{
+			"device": a.device,
+			"topic":  a.sink.Topic,
+			"error":  err.Error(),
+		}).Error("Failed to publish alarm event")
+	}
+}
+
 /*
 raiseAlarm submits an alarm object with a RAISED state
 */
 func (a *PonSimAlarm) raiseAlarm(alarm *Alarm) {
 	alarm.State = int(voltha.AlarmEventState_RAISED)
 	a.sendAlarm(alarm)
+	a.publishAlarm(alarm)
+
+	if a.counter != nil {
+		a.counter.CountAlarm(true)
+	}
 }
 
 /*
@@ -177,6 +246,11 @@ clearAlarm submits an alarm object with a CLEARED state
 func (a *PonSimAlarm) clearAlarm(alarm *Alarm) {
 	alarm.State = int(voltha.AlarmEventState_CLEARED)
 	a.sendAlarm(alarm)
+	a.publishAlarm(alarm)
+
+	if a.counter != nil {
+		a.counter.CountAlarm(false)
+	}
 }
 
 /*
@@ -185,6 +259,66 @@ GenerateAlarm simulates RAISE and CLEAR alarm events with a random delay in betw
 func (a *PonSimAlarm) GenerateAlarm() {
 	alarm := a.prepareAlarm()
 	a.raiseAlarm(alarm)
-	time.Sleep(time.Duration(rand.Intn(maxInterval-minInterval)+minInterval) * time.Second)
+	time.Sleep(randomAlarmDuration())
 	a.clearAlarm(alarm)
 }
+
+/*
+randomAlarmDuration returns how long a simulated alarm remains raised
+*/
+func randomAlarmDuration() time.Duration {
+	return time.Duration(rand.Intn(maxInterval-minInterval)+minInterval) * time.Second
+}
+
+/*
+TriggerAlarm raises an alarm with the named severity, type and category, e.g. MAJOR, EQUIPMENT
+and PON, and clears it once the duration has elapsed.  The attributes which are not named and
+the duration, when not specified, are picked at random.
+*/
+func (o *PonSimOltDevice) TriggerAlarm(severity string, alarmType string, category string, duration time.Duration) error {
+	if o.alarms == nil {
+		return errors.New("device is not started")
+	}
+
+	alarm := o.alarms.prepareAlarm()
+	if severity != "" {
+		value, ok := voltha.AlarmEventSeverity_AlarmEventSeverity_value[strings.ToUpper(severity)]
+		if !ok {
+			return fmt.Errorf("unknown alarm severity: %s", severity)
+		}
+		alarm.Severity = int(value)
+	}
+	if alarmType != "" {
+		value, ok := voltha.AlarmEventType_AlarmEventType_value[strings.ToUpper(alarmType)]
+		if !ok {
+			return fmt.Errorf("unknown alarm type: %s", alarmType)
+		}
+		alarm.Type = int(value)
+	}
+	if category != "" {
+		value, ok := voltha.AlarmEventCategory_AlarmEventCategory_value[strings.ToUpper(category)]
+		if !ok {
+			return fmt.Errorf("unknown alarm category: %s", category)
+		}
+		alarm.Category = int(value)
+	}
+	alarm.Description = fmt.Sprintf("%s.%s alarm",
+		voltha.AlarmEventType_AlarmEventType_name[int32(alarm.Type)],
+		voltha.AlarmEventCategory_AlarmEventCategory_name[int32(alarm.Category)],
+	)
+
+	if duration <= 0 {
+		duration = randomAlarmDuration()
+	}
+
+	alarmLogger.WithFields(logrus.Fields{
+		"device":   o,
+		"alarm":    alarm,
+		"duration": duration,
+	}).Info("Triggering alarm")
+
+	o.alarms.raiseAlarm(alarm)
+	time.AfterFunc(duration, func() { o.alarms.clearAlarm(alarm) })
+
+	return nil
+}
@@ -0,0 +1,224 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"strings"
+	"time"
+)
+
+const (
+	// Metadata holding the credentials of the caller, as "Bearer <token>"
+	API_AUTH_METADATA = "authorization"
+)
+
+/*
+PonSimApiAuth restricts the calls of the RPCs changing the state of the simulator, e.g. pushing
+flows or injecting faults, to the callers presenting a shared API token or a JSON Web Token
+signed with a shared secret (HS256)
+*/
+type PonSimApiAuth struct {
+	Token     string `json:"-"`
+	JwtSecret string `json:"-"`
+}
+
+/*
+NewPonSimApiAuth instantiates the authentication of the callers; it returns nil, i.e. no
+authentication, when neither a token nor a secret is specified
+*/
+func NewPonSimApiAuth(token string, jwtSecret string) *PonSimApiAuth {
+	if token == "" && jwtSecret == "" {
+		return nil
+	}
+
+	return &PonSimApiAuth{Token: token, JwtSecret: jwtSecret}
+}
+
+/*
+IsRestricted tells whether the calls of an RPC, designated by its name without the service,
+require authentication
+*/
+func (a *PonSimApiAuth) IsRestricted(method string) bool {
+	if a == nil {
+		return false
+	}
+
+	_, err := parseEnum(AUDITABLE_METHODS, method)
+	return err == nil
+}
+
+/*
+Authorize checks the credentials presented for a call of an RPC and returns the identity of
+the caller: the subject of a JSON Web Token, or an empty string for the API token.
+*/
+func (a *PonSimApiAuth) Authorize(method string, authorization string) (string, error) {
+	if !a.IsRestricted(method) {
+		return "", nil
+	}
+
+	token := strings.TrimSpace(authorization)
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+	if token == "" {
+		return "", errors.New("missing credentials")
+	}
+
+	if a.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) == 1 {
+		return "", nil
+	}
+
+	if a.JwtSecret != "" && strings.Count(token, ".") == 2 {
+		return a.authorizeJwt(method, token)
+	}
+
+	return "", errors.New("invalid credentials")
+}
+
+/*
+Credentials adds the credentials presented to the ONUs to the metadata of an outgoing request:
+the API token of the device if any, otherwise the credentials of the caller being served
+*/
+func (a *PonSimApiAuth) Credentials(ctx context.Context) context.Context {
+	if a == nil {
+		return ctx
+	}
+
+	authorization := ""
+	if a.Token != "" {
+		authorization = "Bearer " + a.Token
+	} else if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md[API_AUTH_METADATA]; len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+	if authorization == "" {
+		return ctx
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	md.Set(API_AUTH_METADATA, authorization)
+
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+/*
+DialOptions returns the options of the connections to the ONUs, on which credentials are
+presented along with the correlation ID of the requests
+*/
+func (a *PonSimApiAuth) DialOptions() []grpc.DialOption {
+	// A connection accepts a single interceptor
+	return []grpc.DialOption{
+		grpc.WithUnaryInterceptor(func(
+			ctx context.Context,
+			method string,
+			request interface{},
+			reply interface{},
+			cc *grpc.ClientConn,
+			invoker grpc.UnaryInvoker,
+			opts ...grpc.CallOption,
+		) error {
+			return common.RequestIdUnaryClientInterceptor(a.Credentials(ctx), method, request, reply, cc, invoker, opts...)
+		}),
+		grpc.WithStreamInterceptor(common.RequestIdStreamClientInterceptor),
+	}
+}
+
+/*
+jwtClaims are the claims of a JSON Web Token checked by the simulator.  The scope, if any,
+lists the RPCs the bearer may call, separated by spaces.
+*/
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp"`
+	NotBefore int64  `json:"nbf"`
+	Scope     string `json:"scope"`
+}
+
+/*
+authorizeJwt verifies the signature, validity period and scope of a JSON Web Token
+*/
+func (a *PonSimApiAuth) authorizeJwt(method string, token string) (string, error) {
+	parts := strings.Split(token, ".")
+
+	var header struct {
+		Algorithm string `json:"alg"`
+	}
+	if err := decodeJwtPart(parts[0], &header); err != nil {
+		return "", err
+	}
+	if header.Algorithm != "HS256" {
+		return "", fmt.Errorf("unsupported token algorithm: %s", header.Algorithm)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.New("malformed token signature")
+	}
+	mac := hmac.New(sha256.New, []byte(a.JwtSecret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return "", errors.New("invalid token signature")
+	}
+
+	var claims jwtClaims
+	if err := decodeJwtPart(parts[1], &claims); err != nil {
+		return "", err
+	}
+
+	now := time.Now().Unix()
+	if claims.ExpiresAt != 0 && now >= claims.ExpiresAt {
+		return claims.Subject, errors.New("token has expired")
+	}
+	if claims.NotBefore != 0 && now < claims.NotBefore {
+		return claims.Subject, errors.New("token is not valid yet")
+	}
+
+	if claims.Scope != "" {
+		for _, allowed := range strings.Fields(claims.Scope) {
+			if allowed == "all" || strings.EqualFold(allowed, method) {
+				return claims.Subject, nil
+			}
+		}
+		return claims.Subject, fmt.Errorf("token does not grant %s", method)
+	}
+
+	return claims.Subject, nil
+}
+
+func decodeJwtPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("malformed token")
+	}
+
+	return nil
+}
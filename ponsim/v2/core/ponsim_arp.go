@@ -0,0 +1,244 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/sirupsen/logrus"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+PonSimArpBinding is an IPv4 address of a simulated subscriber host, resolved to its hardware
+address by the ARP responder
+*/
+type PonSimArpBinding struct {
+	Ip      net.IP           `json:"ip"`
+	Mac     net.HardwareAddr `json:"mac"`
+	Replies uint64           `json:"replies"`
+}
+
+/*
+NewPonSimArpBinding validates the IPv4 and hardware addresses of a binding
+*/
+func NewPonSimArpBinding(ip string, mac string) (*PonSimArpBinding, error) {
+	address := net.ParseIP(strings.TrimSpace(ip)).To4()
+	if address == nil {
+		return nil, fmt.Errorf("invalid IPv4 address: %s", ip)
+	}
+	hwAddr, err := net.ParseMAC(strings.TrimSpace(mac))
+	if err != nil || len(hwAddr) != 6 || hwAddr[0]&0x01 != 0 {
+		return nil, fmt.Errorf("invalid MAC address: %s", mac)
+	}
+
+	return &PonSimArpBinding{Ip: address, Mac: hwAddr}, nil
+}
+
+/*
+ParseArpBindings parses the bindings of the ARP responder, specified as ip=mac entries
+separated by commas
+*/
+func ParseArpBindings(spec string) ([]*PonSimArpBinding, error) {
+	var bindings []*PonSimArpBinding
+	seen := make(map[string]bool)
+
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		fields := strings.SplitN(entry, "=", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid ARP binding specification: %s", entry)
+		}
+		binding, err := NewPonSimArpBinding(fields[0], fields[1])
+		if err != nil {
+			return nil, err
+		}
+		if seen[binding.Ip.String()] {
+			return nil, fmt.Errorf("IPv4 address %s is bound more than once", binding.Ip)
+		}
+		seen[binding.Ip.String()] = true
+
+		bindings = append(bindings, binding)
+	}
+
+	return bindings, nil
+}
+
+/*
+PonSimArpResponder answers the ARP requests received on the UNI of an ONU for the addresses
+of the subscriber hosts it simulates, so that they are reachable without real hosts
+*/
+type PonSimArpResponder struct {
+	mutex    sync.RWMutex
+	bindings map[string]*PonSimArpBinding
+}
+
+/*
+NewPonSimArpResponder instantiates an ARP responder without any bindings
+*/
+func NewPonSimArpResponder() *PonSimArpResponder {
+	return &PonSimArpResponder{bindings: make(map[string]*PonSimArpBinding)}
+}
+
+/*
+Set adds a binding, replacing the binding of the same address
+*/
+func (r *PonSimArpResponder) Set(binding *PonSimArpBinding) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.bindings[binding.Ip.String()] = binding
+}
+
+/*
+Remove removes the binding of an address and reports whether it existed
+*/
+func (r *PonSimArpResponder) Remove(ip net.IP) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	key := ip.To4().String()
+	_, ok := r.bindings[key]
+	delete(r.bindings, key)
+
+	return ok
+}
+
+/*
+Lookup returns the binding of an address, or nil when it is not bound
+*/
+func (r *PonSimArpResponder) Lookup(ip net.IP) *PonSimArpBinding {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.bindings[ip.To4().String()]
+}
+
+/*
+List returns a copy of the bindings, ordered by address
+*/
+func (r *PonSimArpResponder) List() []PonSimArpBinding {
+	r.mutex.RLock()
+	bindings := make([]PonSimArpBinding, 0, len(r.bindings))
+	for _, binding := range r.bindings {
+		bindings = append(bindings, *binding)
+	}
+	r.mutex.RUnlock()
+
+	sort.Slice(bindings, func(i, j int) bool {
+		return bytes.Compare(bindings[i].Ip, bindings[j].Ip) < 0
+	})
+	return bindings
+}
+
+/*
+HandleFrame answers an ARP request for a bound address, replying through the inject function
+with the VLAN tags of the request, and reports whether the frame was consumed
+*/
+func (r *PonSimArpResponder) HandleFrame(frame gopacket.Packet, inject func(gopacket.Packet)) bool {
+	arpLayer := frame.Layer(layers.LayerTypeARP)
+	if arpLayer == nil {
+		return false
+	}
+	arp := arpLayer.(*layers.ARP)
+	if arp.Operation != layers.ARPRequest || arp.Protocol != layers.EthernetTypeIPv4 {
+		return false
+	}
+
+	r.mutex.Lock()
+	binding, ok := r.bindings[net.IP(arp.DstProtAddress).String()]
+	if ok {
+		binding.Replies++
+	}
+	r.mutex.Unlock()
+	if !ok {
+		return false
+	}
+
+	reply := &layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPReply,
+		SourceHwAddress:   binding.Mac,
+		SourceProtAddress: binding.Ip,
+		DstHwAddress:      arp.SourceHwAddress,
+		DstProtAddress:    arp.SourceProtAddress,
+	}
+
+	frameLayers := newReplyLayers(frame, binding.Mac, net.HardwareAddr(arp.SourceHwAddress), layers.EthernetTypeARP)
+	frameLayers = append(frameLayers, reply)
+
+	replyFrame, err := common.SerializeFrame(gopacket.SerializeOptions{FixLengths: true}, frameLayers...)
+	if err != nil {
+		common.Logger().WithFields(logrus.Fields{
+			"ip":    binding.Ip,
+			"error": err.Error(),
+		}).Error("Problem serializing ARP reply")
+		return true
+	}
+
+	if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+		entry.WithFields(logrus.Fields{
+			"ip":     binding.Ip,
+			"mac":    binding.Mac.String(),
+			"sender": net.IP(arp.SourceProtAddress),
+		}).Debug("Answering ARP request")
+	}
+
+	// Replies are injected outside of the caller so that they are forwarded once the request
+	// has been delivered, as for the simulated subscribers
+	go inject(replyFrame)
+
+	return true
+}
+
+/*
+newReplyLayers builds the ethernet header of a reply of a simulated subscriber host to a frame,
+carrying the VLAN tags of the frame
+*/
+func newReplyLayers(
+	frame gopacket.Packet,
+	srcMac net.HardwareAddr,
+	dstMac net.HardwareAddr,
+	etherType layers.EthernetType,
+) []gopacket.SerializableLayer {
+	eth := &layers.Ethernet{SrcMAC: srcMac, DstMAC: dstMac, EthernetType: etherType}
+	frameLayers := []gopacket.SerializableLayer{eth}
+	for _, layer := range frame.Layers() {
+		if dot1q, ok := layer.(*layers.Dot1Q); ok {
+			if len(frameLayers) == 1 {
+				// The decoded ethernet type does not tell an 802.1ad outer tag from an 802.1Q one
+				eth.EthernetType = layers.EthernetType(binary.BigEndian.Uint16(frame.Data()[12:14]))
+			}
+			tag := *dot1q
+			frameLayers = append(frameLayers, &tag)
+		}
+	}
+
+	return frameLayers
+}
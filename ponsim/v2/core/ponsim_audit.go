@@ -0,0 +1,184 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+)
+
+/*
+AUDITABLE_METHODS lists the RPCs which change the state of the simulator
+*/
+var AUDITABLE_METHODS = []string{
+	// PonSim service
+	"UpdateFlowTable",
+	"UpdateFlowTables",
+	"UpdateGroupTable",
+	"UpdateMeterTable",
+	"Reboot",
+	"EnablePort",
+	"DisablePort",
+	"SendFrame",
+	"SendOmci",
+
+	// PonSimAdmin service
+	"SetPortDelay",
+	"SetPortFault",
+	"FlapPort",
+	"StartIpv6Subscriber",
+	"RunConformance",
+	"StartConformance",
+	"StartTraffic",
+	"StartPcapReplay",
+	"CancelJob",
+	"SetClockDrift",
+	"ResyncClock",
+	"StopAllTraffic",
+	"TriggerAlarm",
+	"RemoveOnu",
+	"SetLagMember",
+	"ActivateOnu",
+	"SetGemEncryption",
+	"SwitchGemKey",
+	"SetKeyExchangeFaults",
+	"SetOnuFec",
+	"SetOnuOptics",
+	"SetDiagnostics",
+	"StartImageDownload",
+	"CancelImageDownload",
+	"ActivateImage",
+	"CommitImage",
+	"SetImageFault",
+	"SetPmThreshold",
+	"EnableOnu",
+	"DisableOnu",
+	"SetFrameLogSampling",
+	"SetLogLevel",
+	"SetChaos",
+	"SetMirror",
+	"SetArpBinding",
+	"SetEchoResponder",
+	"SetLldp",
+	"SetMep",
+	"CfmLoopback",
+	"CfmDelayMeasurement",
+	"CfmLossMeasurement",
+	"SetTableMiss",
+}
+
+/*
+PonSimAudit holds the RPCs whose calls are published as audit events
+*/
+type PonSimAudit struct {
+	Methods []string `json:"methods"`
+
+	methods map[string]bool
+}
+
+/*
+ParseAudit parses a comma separated list of the RPCs to audit, e.g. UpdateFlowTable,Reboot,
+where all stands for every RPC changing the state of the simulator.  An empty specification
+disables auditing.
+*/
+func ParseAudit(spec string) (*PonSimAudit, error) {
+	audit := &PonSimAudit{methods: make(map[string]bool)}
+
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		if strings.ToLower(entry) == "all" {
+			for _, method := range AUDITABLE_METHODS {
+				audit.methods[method] = true
+			}
+			continue
+		}
+
+		i, err := parseEnum(AUDITABLE_METHODS, entry)
+		if err != nil {
+			return nil, fmt.Errorf("unknown auditable RPC: %s", entry)
+		}
+		audit.methods[AUDITABLE_METHODS[i]] = true
+	}
+
+	if len(audit.methods) == 0 {
+		return nil, nil
+	}
+
+	for method := range audit.methods {
+		audit.Methods = append(audit.Methods, method)
+	}
+	sort.Strings(audit.Methods)
+
+	return audit, nil
+}
+
+/*
+IsAudited tells whether the calls of an RPC, designated by its name without the service, are audited
+*/
+func (a *PonSimAudit) IsAudited(method string) bool {
+	if a == nil {
+		return false
+	}
+
+	return a.methods[method]
+}
+
+/*
+newAuditEvent creates an event recording who called an RPC and its result
+*/
+func newAuditEvent(device string, method string, peer string, user string, err error) *voltha.PonSimEvent {
+	audit := &voltha.PonSimAudit{
+		Method:  method,
+		Peer:    peer,
+		User:    user,
+		Success: err == nil,
+	}
+	if err != nil {
+		audit.Error = err.Error()
+	}
+
+	return &voltha.PonSimEvent{
+		Device: device,
+		Event:  &voltha.PonSimEvent_Audit{Audit: audit},
+	}
+}
+
+/*
+AuditCall publishes an audit event on the event bus of the device when the RPC is audited.
+The peer is the address of the caller and the user the identity it declared, if any.
+*/
+func (o *PonSimDevice) AuditCall(method string, peer string, user string, err error) {
+	if !o.Audit.IsAudited(method) {
+		return
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"device": o.Name,
+		"method": method,
+		"peer":   peer,
+		"user":   user,
+		"error":  err,
+	}).Info("Auditing call")
+
+	o.publishEvent(newAuditEvent(o.Name, method, peer, user, err))
+}
@@ -0,0 +1,805 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/ponsim"
+	"github.com/sirupsen/logrus"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Directions of the maintenance end points: a down MEP faces the link of its port, an up MEP
+// faces the device and exchanges its frames through the flows
+const (
+	CFM_DOWN = "down"
+	CFM_UP   = "up"
+)
+
+var CFM_DIRECTIONS = []string{
+	CFM_DOWN,
+	CFM_UP,
+}
+
+// Operation codes of the CFM PDUs
+const (
+	CFM_OPCODE_CCM = 1
+	CFM_OPCODE_LBR = 2
+	CFM_OPCODE_LBM = 3
+)
+
+const (
+	CFM_ETHER_TYPE = layers.EthernetType(0x8902)
+
+	// The MEPs are hosted by the NNI of an OLT and by the UNI of an ONU
+	CFM_MEP_PORT = 2
+
+	CFM_MAX_MEP_ID = 8191
+	CFM_MAX_LEVEL  = 7
+	CFM_MAX_VLAN   = 4094
+
+	CFM_CCM_TLV_OFFSET = 70
+	CFM_LBM_TLV_OFFSET = 4
+	CFM_MAID_LENGTH    = 48
+
+	// A remote MEP is lost when none of its CCMs was received for 3.5 intervals
+	CFM_LOC_INTERVALS = 3.5
+
+	DEFAULT_CFM_REPLY_TIMEOUT = time.Second
+)
+
+// Intervals between the CCMs, by value of the interval field of their flags
+var CFM_CCM_INTERVALS = []time.Duration{
+	0,
+	3333 * time.Microsecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+	time.Minute,
+	10 * time.Minute,
+}
+
+/*
+PonSimRemoteMep is a MEP of the maintenance association known from its CCMs
+*/
+type PonSimRemoteMep struct {
+	MepId    uint16           `json:"mep_id"`
+	Mac      net.HardwareAddr `json:"mac"`
+	Rdi      bool             `json:"rdi"`
+	Interval time.Duration    `json:"interval"`
+	Ccms     uint64           `json:"ccms"`
+	LastCcm  time.Time        `json:"last_ccm"`
+}
+
+/*
+IsUp tells whether CCMs of the remote MEP were received recently enough
+*/
+func (r *PonSimRemoteMep) IsUp() bool {
+	return r.Interval > 0 && time.Since(r.LastCcm) < time.Duration(float64(r.Interval)*CFM_LOC_INTERVALS)
+}
+
+/*
+PonSimMep is a maintenance end point of 802.1ag, which sends CCMs periodically, tracks the CCMs of
+the remote MEPs of its maintenance association and answers loopback messages
+*/
+type PonSimMep struct {
+	MepId     uint16           `json:"mep_id"`
+	Port      int              `json:"port"`
+	Direction string           `json:"direction"`
+	Level     uint8            `json:"level"`
+	Vlan      uint16           `json:"vlan"`
+	MdName    string           `json:"md_name"`
+	MaName    string           `json:"ma_name"`
+	Interval  time.Duration    `json:"interval"`
+	Mac       net.HardwareAddr `json:"mac"`
+
+	mutex        sync.Mutex
+	maid         []byte
+	sequence     uint32
+	transaction  uint32
+	remotes      map[uint16]*PonSimRemoteMep
+	loopbacks    map[uint32]chan struct{}
+	ccmsSent     uint64
+	ccmsReceived uint64
+	ccmErrors    uint64
+	lbmsSent     uint64
+	lbmsReceived uint64
+	lbrsSent     uint64
+	lbrsReceived uint64
+	stop         chan struct{}
+
+	// Service frames counted for the loss measurements, and state of the measurements of Y.1731
+	txFrames     uint64
+	rxFrames     uint64
+	dmmsReceived uint64
+	lmmsReceived uint64
+	replies      map[uint8]chan cfmReply
+	lastDelay    *ponsim.CfmDelayResult
+	lastLoss     *ponsim.CfmLossResult
+}
+
+/*
+NewPonSimMep validates the configuration of a MEP, whose MAC address is derived from its MEP ID
+when not set
+*/
+func NewPonSimMep(request *ponsim.MepRequest) (*PonSimMep, error) {
+	if request.MepId == 0 || request.MepId > CFM_MAX_MEP_ID {
+		return nil, fmt.Errorf("invalid MEP ID: %d", request.MepId)
+	}
+	if request.Level > CFM_MAX_LEVEL {
+		return nil, fmt.Errorf("invalid MD level: %d", request.Level)
+	}
+	if request.Vlan > CFM_MAX_VLAN {
+		return nil, fmt.Errorf("invalid VLAN: %d", request.Vlan)
+	}
+	i, err := parseEnum(CFM_DIRECTIONS, withDefault(request.Direction, CFM_DOWN))
+	if err != nil {
+		return nil, fmt.Errorf("unknown direction: %s", request.Direction)
+	}
+
+	m := &PonSimMep{
+		MepId:     uint16(request.MepId),
+		Port:      CFM_MEP_PORT,
+		Direction: CFM_DIRECTIONS[i],
+		Level:     uint8(request.Level),
+		Vlan:      uint16(request.Vlan),
+		MdName:    request.MdName,
+		MaName:    request.MaName,
+		remotes:   make(map[uint16]*PonSimRemoteMep),
+		loopbacks: make(map[uint32]chan struct{}),
+		replies:   make(map[uint8]chan cfmReply),
+	}
+
+	if request.CcmIntervalMs > 0 {
+		if m.Interval = ccmInterval(request.CcmIntervalMs); m.Interval == 0 {
+			return nil, fmt.Errorf("invalid CCM interval: %d", request.CcmIntervalMs)
+		}
+	}
+
+	if request.Mac == "" {
+		m.Mac = net.HardwareAddr{0x02, 0xcf, 0x00, 0x00, byte(m.MepId >> 8), byte(m.MepId)}
+	} else if m.Mac, err = net.ParseMAC(request.Mac); err != nil || len(m.Mac) != 6 {
+		return nil, fmt.Errorf("invalid MAC address: %s", request.Mac)
+	}
+
+	if m.maid, err = newMaid(m.MdName, m.MaName); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+/*
+ccmInterval returns the standard CCM interval matching a number of milliseconds, 3 standing
+for 3.33ms, or 0 if there is none
+*/
+func ccmInterval(ms uint32) time.Duration {
+	for _, interval := range CFM_CCM_INTERVALS[1:] {
+		if uint32(interval/time.Millisecond) == ms {
+			return interval
+		}
+	}
+
+	return 0
+}
+
+/*
+newMaid encodes the maintenance association identifier carried by the CCMs, made of an optional
+character string MD name and of a character string short MA name
+*/
+func newMaid(mdName string, maName string) ([]byte, error) {
+	maid := make([]byte, 0, CFM_MAID_LENGTH)
+	if mdName == "" {
+		maid = append(maid, 1)
+	} else {
+		maid = append(maid, 4, byte(len(mdName)))
+		maid = append(maid, mdName...)
+	}
+	if maName == "" {
+		return nil, fmt.Errorf("missing MA name")
+	}
+	maid = append(maid, 2, byte(len(maName)))
+	maid = append(maid, maName...)
+
+	if len(maid) > CFM_MAID_LENGTH {
+		return nil, fmt.Errorf("MD and MA names are too long: %s/%s", mdName, maName)
+	}
+
+	return maid[:CFM_MAID_LENGTH], nil
+}
+
+/*
+multicastMac returns the class 1 multicast address of the level of the MEP, to which the CCMs
+are sent
+*/
+func (m *PonSimMep) multicastMac() net.HardwareAddr {
+	return net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x30 | m.Level}
+}
+
+/*
+isAddressed tells whether a CFM frame is sent to the MEP, directly or through multicast
+*/
+func (m *PonSimMep) isAddressed(frame gopacket.Packet) bool {
+	dstMac := common.GetEthernetLayer(frame).DstMAC
+
+	return bytes.Equal(dstMac, m.Mac) || bytes.Equal(dstMac, m.multicastMac())
+}
+
+/*
+makeFrame builds a CFM frame sent by the MEP, on its VLAN if it has one
+*/
+func (m *PonSimMep) makeFrame(dstMac net.HardwareAddr, pdu []byte) gopacket.Packet {
+	eth := &layers.Ethernet{SrcMAC: m.Mac, DstMAC: dstMac, EthernetType: CFM_ETHER_TYPE}
+	frameLayers := []gopacket.SerializableLayer{eth}
+	if m.Vlan != 0 {
+		eth.EthernetType = layers.EthernetTypeDot1Q
+		frameLayers = append(frameLayers, &layers.Dot1Q{VLANIdentifier: m.Vlan, Type: CFM_ETHER_TYPE})
+	}
+	frameLayers = append(frameLayers, gopacket.Payload(pdu))
+
+	frame, err := common.SerializeFrame(gopacket.SerializeOptions{}, frameLayers...)
+	if err != nil {
+		common.Logger().WithFields(logrus.Fields{
+			"mepId": m.MepId,
+			"error": err.Error(),
+		}).Error("Problem serializing CFM frame")
+		return nil
+	}
+
+	return frame
+}
+
+/*
+newCfmHeader builds the common header of a CFM PDU
+*/
+func newCfmHeader(level uint8, opcode uint8, flags uint8, tlvOffset uint8) []byte {
+	return []byte{level << 5, opcode, flags, tlvOffset}
+}
+
+/*
+makeCcm builds the next CCM of the MEP, which signals a remote defect when one of the remote
+MEPs it knows was lost
+*/
+func (m *PonSimMep) makeCcm() gopacket.Packet {
+	m.mutex.Lock()
+	flags := uint8(0)
+	for i, interval := range CFM_CCM_INTERVALS {
+		if interval == m.Interval {
+			flags = uint8(i)
+		}
+	}
+	for _, remote := range m.remotes {
+		if !remote.IsUp() {
+			flags |= 0x80
+		}
+	}
+	m.sequence++
+	m.ccmsSent++
+	sequence := m.sequence
+	m.mutex.Unlock()
+
+	pdu := newCfmHeader(m.Level, CFM_OPCODE_CCM, flags, CFM_CCM_TLV_OFFSET)
+	pdu = append(pdu, make([]byte, CFM_CCM_TLV_OFFSET+1)...)
+	binary.BigEndian.PutUint32(pdu[4:], sequence)
+	binary.BigEndian.PutUint16(pdu[8:], m.MepId)
+	copy(pdu[10:], m.maid)
+
+	return m.makeFrame(m.multicastMac(), pdu)
+}
+
+/*
+makeLbm builds a loopback message sent to a MAC address
+*/
+func (m *PonSimMep) makeLbm(dstMac net.HardwareAddr, transaction uint32) gopacket.Packet {
+	pdu := newCfmHeader(m.Level, CFM_OPCODE_LBM, 0, CFM_LBM_TLV_OFFSET)
+	pdu = append(pdu, make([]byte, CFM_LBM_TLV_OFFSET+1)...)
+	binary.BigEndian.PutUint32(pdu[4:], transaction)
+
+	return m.makeFrame(dstMac, pdu)
+}
+
+/*
+receive processes a CFM PDU of the level of the MEP, returning the reply to send if any
+*/
+func (m *PonSimMep) receive(frame gopacket.Packet, pdu []byte) gopacket.Packet {
+	srcMac := common.GetEthernetLayer(frame).SrcMAC
+	length := cfmPduLength(pdu)
+	if length < 0 || !m.isAddressed(frame) {
+		return nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	switch pdu[1] {
+	case CFM_OPCODE_CCM:
+		if length < 4+CFM_CCM_TLV_OFFSET {
+			return nil
+		}
+		mepId := binary.BigEndian.Uint16(pdu[8:]) & CFM_MAX_MEP_ID
+
+		// CCMs of another maintenance association, or looped back, reveal a misconfiguration
+		if !bytes.Equal(pdu[10:10+CFM_MAID_LENGTH], m.maid) || mepId == m.MepId {
+			m.ccmErrors++
+			return nil
+		}
+		m.ccmsReceived++
+
+		remote, ok := m.remotes[mepId]
+		if !ok {
+			remote = &PonSimRemoteMep{MepId: mepId}
+			m.remotes[mepId] = remote
+		}
+		remote.Mac = append(net.HardwareAddr{}, srcMac...)
+		remote.Rdi = pdu[2]&0x80 != 0
+		remote.Interval = CFM_CCM_INTERVALS[pdu[2]&0x07]
+		remote.Ccms++
+		remote.LastCcm = time.Now()
+
+	case CFM_OPCODE_LBM:
+		m.lbmsReceived++
+		m.lbrsSent++
+
+		// The reply carries the transaction ID and the TLVs of the message
+		reply := append([]byte{}, pdu[:length]...)
+		reply[1] = CFM_OPCODE_LBR
+		return m.makeFrame(srcMac, reply)
+
+	case CFM_OPCODE_LBR:
+		if length < 8 {
+			return nil
+		}
+		m.lbrsReceived++
+		if done, ok := m.loopbacks[binary.BigEndian.Uint32(pdu[4:])]; ok {
+			close(done)
+			delete(m.loopbacks, binary.BigEndian.Uint32(pdu[4:]))
+		}
+
+	default:
+		return m.receiveMeasurement(srcMac, pdu[:length])
+	}
+
+	return nil
+}
+
+/*
+cfmPduLength returns the length of a CFM PDU up to its end TLV, ignoring the padding of the
+frame, or -1 when the PDU is malformed
+*/
+func cfmPduLength(pdu []byte) int {
+	if len(pdu) < 4 {
+		return -1
+	}
+
+	offset := 4 + int(pdu[3])
+	for offset < len(pdu) {
+		if pdu[offset] == 0 {
+			return offset + 1
+		}
+		if offset+3 > len(pdu) {
+			return -1
+		}
+		offset += 3 + int(binary.BigEndian.Uint16(pdu[offset+1:]))
+	}
+
+	return -1
+}
+
+/*
+parseCfmFrame returns the outer VLAN of a frame, 0 when untagged, along with its PDU if it is a
+CFM frame
+*/
+func parseCfmFrame(frame gopacket.Packet) (uint16, []byte, bool) {
+	data := frame.Data()
+	vlan, tagged := uint16(0), false
+
+	for offset := 12; offset+2 <= len(data); offset += 4 {
+		switch layers.EthernetType(binary.BigEndian.Uint16(data[offset:])) {
+		case layers.EthernetTypeDot1Q, layers.EthernetTypeQinQ:
+			if offset+6 > len(data) {
+				return vlan, nil, false
+			}
+			if !tagged {
+				vlan, tagged = binary.BigEndian.Uint16(data[offset+2:])&0x0fff, true
+			}
+		case CFM_ETHER_TYPE:
+			return vlan, data[offset+2:], true
+		default:
+			return vlan, nil, false
+		}
+	}
+
+	return vlan, nil, false
+}
+
+/*
+MakeProto returns the configuration, the counters and the remote MEPs of a MEP as a GRPC message
+*/
+func (m *PonSimMep) MakeProto() *ponsim.Mep {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	mep := &ponsim.Mep{
+		MepId:         uint32(m.MepId),
+		Level:         uint32(m.Level),
+		Vlan:          uint32(m.Vlan),
+		Direction:     m.Direction,
+		MdName:        m.MdName,
+		MaName:        m.MaName,
+		CcmIntervalMs: uint32(m.Interval / time.Millisecond),
+		Mac:           m.Mac.String(),
+		CcmsSent:      m.ccmsSent,
+		CcmsReceived:  m.ccmsReceived,
+		CcmErrors:     m.ccmErrors,
+		LbmsSent:      m.lbmsSent,
+		LbmsReceived:  m.lbmsReceived,
+		LbrsSent:      m.lbrsSent,
+		LbrsReceived:  m.lbrsReceived,
+		DmmsReceived:  m.dmmsReceived,
+		LmmsReceived:  m.lmmsReceived,
+		TxFrames:      atomic.LoadUint64(&m.txFrames),
+		RxFrames:      atomic.LoadUint64(&m.rxFrames),
+		LastDelay:     m.lastDelay,
+		LastLoss:      m.lastLoss,
+	}
+
+	var mepIds []int
+	for mepId := range m.remotes {
+		mepIds = append(mepIds, int(mepId))
+	}
+	sort.Ints(mepIds)
+	for _, mepId := range mepIds {
+		remote := m.remotes[uint16(mepId)]
+		mep.Rdi = mep.Rdi || !remote.IsUp()
+		mep.RemoteMeps = append(mep.RemoteMeps, &ponsim.RemoteMep{
+			MepId:         uint32(remote.MepId),
+			Mac:           remote.Mac.String(),
+			Up:            remote.IsUp(),
+			Rdi:           remote.Rdi,
+			CcmIntervalMs: uint32(remote.Interval / time.Millisecond),
+			Ccms:          remote.Ccms,
+			AgeMs:         uint32(time.Since(remote.LastCcm) / time.Millisecond),
+		})
+	}
+
+	return mep
+}
+
+/*
+PonSimCfm holds the maintenance end points of a device, by MEP ID
+*/
+type PonSimCfm struct {
+	mutex    sync.RWMutex
+	meps     map[uint16]*PonSimMep
+	transmit func(int, string, gopacket.Packet)
+}
+
+/*
+NewPonSimCfm instantiates a device without maintenance end points
+*/
+func NewPonSimCfm() *PonSimCfm {
+	return &PonSimCfm{meps: make(map[uint16]*PonSimMep)}
+}
+
+/*
+Start sends the CCMs of the MEPs through the transmit function, which sends a frame out of a port
+for a down MEP and through the flows for an up MEP
+*/
+func (c *PonSimCfm) Start(transmit func(port int, direction string, frame gopacket.Packet)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.transmit = transmit
+	for _, mep := range c.meps {
+		c.startCcms(mep)
+	}
+}
+
+/*
+Stop halts the CCMs of the MEPs
+*/
+func (c *PonSimCfm) Stop() {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.transmit = nil
+	for _, mep := range c.meps {
+		c.stopCcms(mep)
+	}
+}
+
+/*
+startCcms sends the CCMs of a MEP at its interval
+*/
+func (c *PonSimCfm) startCcms(mep *PonSimMep) {
+	if c.transmit == nil || mep.Interval == 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	mep.stop = stop
+	transmit := c.transmit
+
+	go func() {
+		ticker := time.NewTicker(mep.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if frame := mep.makeCcm(); frame != nil {
+					transmit(mep.Port, mep.Direction, frame)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+/*
+stopCcms halts the CCMs of a MEP
+*/
+func (c *PonSimCfm) stopCcms(mep *PonSimMep) {
+	if mep.stop != nil {
+		close(mep.stop)
+		mep.stop = nil
+	}
+}
+
+/*
+Set adds a MEP, replacing the MEP with the same MEP ID
+*/
+func (c *PonSimCfm) Set(mep *PonSimMep) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, other := range c.meps {
+		if other.MepId != mep.MepId && other.Port == mep.Port && other.Level == mep.Level &&
+			other.Vlan == mep.Vlan && other.Direction == mep.Direction {
+			return fmt.Errorf("MEP %d is already configured on the level and VLAN", other.MepId)
+		}
+	}
+
+	if previous, ok := c.meps[mep.MepId]; ok {
+		c.stopCcms(previous)
+	}
+	c.meps[mep.MepId] = mep
+	c.startCcms(mep)
+
+	return nil
+}
+
+/*
+Remove deletes a MEP
+*/
+func (c *PonSimCfm) Remove(mepId uint16) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	mep, ok := c.meps[mepId]
+	if !ok {
+		return fmt.Errorf("unknown MEP: %d", mepId)
+	}
+	c.stopCcms(mep)
+	delete(c.meps, mepId)
+
+	return nil
+}
+
+/*
+Get returns a MEP, or nil if there is none with the MEP ID
+*/
+func (c *PonSimCfm) Get(mepId uint16) *PonSimMep {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.meps[mepId]
+}
+
+/*
+List returns the MEPs sorted by MEP ID
+*/
+func (c *PonSimCfm) List() []*PonSimMep {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	var meps []*PonSimMep
+	for _, mep := range c.meps {
+		meps = append(meps, mep)
+	}
+	sort.Slice(meps, func(i, j int) bool { return meps[i].MepId < meps[j].MepId })
+
+	return meps
+}
+
+/*
+Receive processes a CFM frame received by the down MEPs of a port or sent to the up MEPs of a
+port, and reports whether the frame was consumed.  As with 802.1ag, the frames of the level of a
+MEP are handled by the MEP, the frames of a lower level are dropped and the frames of a higher
+level pass through.  The other frames of the VLAN of the MEPs are counted as service frames,
+received by the MEPs of the direction and sent by the MEPs of the other direction.
+*/
+func (c *PonSimCfm) Receive(port int, direction string, frame gopacket.Packet) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mutex.RLock()
+	if len(c.meps) == 0 {
+		c.mutex.RUnlock()
+		return false
+	}
+	vlan, pdu, ok := parseCfmFrame(frame)
+	if !ok {
+		for _, mep := range c.meps {
+			if mep.Port == port && mep.Vlan == vlan {
+				mep.countFrame(mep.Direction == direction)
+			}
+		}
+		c.mutex.RUnlock()
+		return false
+	}
+	if len(pdu) < 4 {
+		c.mutex.RUnlock()
+		return false
+	}
+	level := pdu[0] >> 5
+
+	var target *PonSimMep
+	consumed := false
+	for _, mep := range c.meps {
+		if mep.Port != port || mep.Direction != direction || mep.Vlan != vlan || mep.Level < level {
+			continue
+		}
+		consumed = true
+		if mep.Level == level {
+			target = mep
+		}
+	}
+	transmit := c.transmit
+	c.mutex.RUnlock()
+
+	if target != nil {
+		if reply := target.receive(frame, pdu); reply != nil && transmit != nil {
+			transmit(target.Port, target.Direction, reply)
+		}
+	}
+
+	return consumed
+}
+
+/*
+Loopback sends loopback messages from a MEP to a MAC address, or to a remote MEP when no address
+is given, one after the other, and returns the round trip times of the replies received in time
+*/
+func (c *PonSimCfm) Loopback(
+	mepId uint16,
+	target net.HardwareAddr,
+	remoteMepId uint16,
+	count int,
+	timeout time.Duration,
+) (net.HardwareAddr, []time.Duration, error) {
+	mep, target, transmit, err := c.resolve(mepId, target, remoteMepId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rtts []time.Duration
+	for i := 0; i < count; i++ {
+		mep.mutex.Lock()
+		mep.transaction++
+		mep.lbmsSent++
+		transaction := mep.transaction
+		done := make(chan struct{})
+		mep.loopbacks[transaction] = done
+		mep.mutex.Unlock()
+
+		start := time.Now()
+		if frame := mep.makeLbm(target, transaction); frame != nil {
+			transmit(mep.Port, mep.Direction, frame)
+		}
+
+		select {
+		case <-done:
+			rtts = append(rtts, time.Since(start))
+		case <-time.After(timeout):
+			mep.mutex.Lock()
+			delete(mep.loopbacks, transaction)
+			mep.mutex.Unlock()
+		}
+	}
+
+	return target, rtts, nil
+}
+
+/*
+resolve returns a MEP along with the MAC address to which it sends messages, the address of a
+remote MEP when none is given, and the function transmitting its frames
+*/
+func (c *PonSimCfm) resolve(
+	mepId uint16,
+	target net.HardwareAddr,
+	remoteMepId uint16,
+) (*PonSimMep, net.HardwareAddr, func(int, string, gopacket.Packet), error) {
+	c.mutex.RLock()
+	mep, ok := c.meps[mepId]
+	transmit := c.transmit
+	c.mutex.RUnlock()
+
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("unknown MEP: %d", mepId)
+	}
+	if transmit == nil {
+		return nil, nil, nil, fmt.Errorf("the device is not started")
+	}
+	if target == nil {
+		mep.mutex.Lock()
+		if remote, ok := mep.remotes[remoteMepId]; ok {
+			target = remote.Mac
+		}
+		mep.mutex.Unlock()
+		if target == nil {
+			return nil, nil, nil, fmt.Errorf("unknown remote MEP: %d", remoteMepId)
+		}
+	}
+
+	return mep, target, transmit, nil
+}
+
+/*
+transmitCfm sends the frames of the MEPs, out of their port for the down MEPs and through the
+flows, as if received on their port, for the up MEPs
+*/
+func (o *PonSimDevice) transmitCfm(ctx context.Context) func(int, string, gopacket.Packet) {
+	return func(port int, direction string, frame gopacket.Packet) {
+		if direction == CFM_UP {
+			o.Forward(ctx, port, frame)
+		} else {
+			o.sendFrame(port, port, frame)
+		}
+	}
+}
+
+/*
+transmitCfm sends the frames of the down MEPs of the NNI to its neighbour
+*/
+func (o *PonSimOltDevice) transmitCfm(ctx context.Context) func(int, string, gopacket.Packet) {
+	transmit := o.PonSimDevice.transmitCfm(ctx)
+
+	return func(port int, direction string, frame gopacket.Packet) {
+		if port == 2 && direction == CFM_DOWN {
+			o.sendToNni(frame)
+		} else {
+			transmit(port, direction, frame)
+		}
+	}
+}
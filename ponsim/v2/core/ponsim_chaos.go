@@ -0,0 +1,413 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Faults of the chaos catalog
+const (
+	// An ONU is removed from the OLT, or an ONU drops its connection to the OLT
+	CHAOS_ONU_DROP = "onu_drop"
+
+	// The PON or NNI/UNI port is taken down for the duration of the fault
+	CHAOS_LINK_FLAP = "link_flap"
+
+	// The next management RPC is delayed by the duration of the fault
+	CHAOS_RPC_DELAY = "rpc_delay"
+
+	// The next flow table update fails
+	CHAOS_FLOW_FAILURE = "flow_failure"
+)
+
+var CHAOS_FAULTS = []string{
+	CHAOS_ONU_DROP,
+	CHAOS_LINK_FLAP,
+	CHAOS_RPC_DELAY,
+	CHAOS_FLOW_FAILURE,
+}
+
+const (
+	DEFAULT_CHAOS_INTERVAL = 10 * time.Second
+	DEFAULT_CHAOS_DURATION = 5 * time.Second
+)
+
+/*
+PonSimChaosFault is the probability with which a fault of the catalog is injected at every
+interval, and the duration of the link flaps and RPC delays
+*/
+type PonSimChaosFault struct {
+	Probability float64       `json:"probability"`
+	Duration    time.Duration `json:"duration"`
+}
+
+/*
+PonSimChaos randomly injects the faults of a catalog into a device.  At every interval, each
+fault of the catalog is injected with its probability; the RPC delays and flow failures are
+armed and injected into the next management RPC or flow table update.
+*/
+type PonSimChaos struct {
+	Interval time.Duration `json:"interval"`
+	Injected int64         `json:"injected"`
+
+	mutex       sync.Mutex
+	enabled     bool
+	catalog     map[string]PonSimChaosFault
+	rpcDelay    time.Duration
+	flowFailure bool
+}
+
+/*
+NewPonSimChaos instantiates a disabled chaos controller with an empty catalog, rolling the
+faults at every interval (10s if not set)
+*/
+func NewPonSimChaos(interval time.Duration) *PonSimChaos {
+	if interval <= 0 {
+		interval = DEFAULT_CHAOS_INTERVAL
+	}
+
+	return &PonSimChaos{
+		Interval: interval,
+		catalog:  make(map[string]PonSimChaosFault),
+	}
+}
+
+/*
+ParseChaosCatalog parses a comma separated list of faults in the format
+fault=probability[:duration], e.g. link_flap=0.1:3s,flow_failure=0.05, where the probability
+is between 0 and 1 and the duration of a link flap or RPC delay defaults to 5s
+*/
+func ParseChaosCatalog(spec string) (map[string]PonSimChaosFault, error) {
+	catalog := make(map[string]PonSimChaosFault)
+
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		fields := strings.SplitN(entry, "=", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid chaos fault specification: %s", entry)
+		}
+
+		i, err := parseEnum(CHAOS_FAULTS, strings.TrimSpace(fields[0]))
+		if err != nil {
+			return nil, fmt.Errorf("unknown chaos fault: %s", fields[0])
+		}
+		name := CHAOS_FAULTS[i]
+
+		// Only the link flaps and RPC delays last for a duration
+		values := strings.SplitN(fields[1], ":", 2)
+		fault := PonSimChaosFault{}
+		if name == CHAOS_LINK_FLAP || name == CHAOS_RPC_DELAY {
+			fault.Duration = DEFAULT_CHAOS_DURATION
+		}
+		if fault.Probability, err = strconv.ParseFloat(strings.TrimSpace(values[0]), 64); err != nil ||
+			fault.Probability < 0 || fault.Probability > 1 {
+			return nil, fmt.Errorf("invalid probability for %s: %s", name, values[0])
+		}
+		if len(values) == 2 {
+			if fault.Duration == 0 {
+				return nil, fmt.Errorf("%s has no duration", name)
+			}
+			if fault.Duration, err = time.ParseDuration(strings.TrimSpace(values[1])); err != nil || fault.Duration <= 0 {
+				return nil, fmt.Errorf("invalid duration for %s: %s", name, values[1])
+			}
+		}
+
+		if _, ok := catalog[name]; ok {
+			return nil, fmt.Errorf("duplicate chaos fault: %s", name)
+		}
+		catalog[name] = fault
+	}
+
+	return catalog, nil
+}
+
+/*
+Set replaces the catalog of faults and enables or disables their injection; disabling it also
+disarms the pending RPC delay and flow failure
+*/
+func (c *PonSimChaos) Set(enabled bool, catalog map[string]PonSimChaosFault) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.enabled = enabled
+	c.catalog = catalog
+	if !enabled {
+		c.rpcDelay = 0
+		c.flowFailure = false
+	}
+}
+
+/*
+Get returns whether faults are injected and the catalog of faults
+*/
+func (c *PonSimChaos) Get() (bool, map[string]PonSimChaosFault) {
+	if c == nil {
+		return false, nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	catalog := make(map[string]PonSimChaosFault, len(c.catalog))
+	for name, fault := range c.catalog {
+		catalog[name] = fault
+	}
+
+	return c.enabled, catalog
+}
+
+/*
+roll returns the faults of the catalog to inject at this interval, in the order of the catalog
+*/
+func (c *PonSimChaos) roll() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var faults []string
+	if !c.enabled {
+		return faults
+	}
+
+	for _, name := range CHAOS_FAULTS {
+		if fault, ok := c.catalog[name]; ok && rand.Float64() < fault.Probability {
+			faults = append(faults, name)
+		}
+	}
+
+	return faults
+}
+
+/*
+arm prepares a fault injected into the next management RPC or flow table update
+*/
+func (c *PonSimChaos) arm(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch name {
+	case CHAOS_RPC_DELAY:
+		c.rpcDelay = c.catalog[name].Duration
+	case CHAOS_FLOW_FAILURE:
+		c.flowFailure = true
+	}
+}
+
+/*
+takeRpcDelay returns and disarms the delay to inject into a management RPC, if any
+*/
+func (c *PonSimChaos) takeRpcDelay() time.Duration {
+	if c == nil {
+		return 0
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delay := c.rpcDelay
+	c.rpcDelay = 0
+
+	return delay
+}
+
+/*
+takeFlowFailure returns and disarms the failure to inject into a flow table update, if any
+*/
+func (c *PonSimChaos) takeFlowFailure() bool {
+	if c == nil {
+		return false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	failure := c.flowFailure
+	c.flowFailure = false
+
+	return failure
+}
+
+/*
+duration returns the duration of a fault of the catalog
+*/
+func (c *PonSimChaos) duration(name string) time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.catalog[name].Duration
+}
+
+/*
+Start rolls the faults of the catalog at every interval, passing those to inject to the inject
+function, until the context is cancelled
+*/
+func (c *PonSimChaos) Start(ctx context.Context, inject func(ctx context.Context, fault string)) {
+	go func() {
+		ticker := time.NewTicker(c.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				for _, fault := range c.roll() {
+					inject(ctx, fault)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+/*
+newChaosEvent creates an event describing a fault injected by the chaos controller
+*/
+func newChaosEvent(device string, fault string, target string, duration time.Duration) *voltha.PonSimEvent {
+	return &voltha.PonSimEvent{
+		Device: device,
+		Event: &voltha.PonSimEvent_Chaos{
+			Chaos: &voltha.PonSimChaosInjection{
+				Fault:      fault,
+				Target:     target,
+				DurationMs: uint32(duration / time.Millisecond),
+			},
+		},
+	}
+}
+
+/*
+chaosInjected logs and publishes a fault injected into the device
+*/
+func (o *PonSimDevice) chaosInjected(fault string, target string, duration time.Duration) {
+	atomic.AddInt64(&o.Chaos.Injected, 1)
+
+	common.Logger().WithFields(logrus.Fields{
+		"device":   o,
+		"fault":    fault,
+		"target":   target,
+		"duration": duration,
+	}).Warn("Injected chaos fault")
+
+	o.publishEvent(newChaosEvent(o.Name, fault, target, duration))
+}
+
+/*
+injectChaos injects a fault common to all the devices, returning false for the faults specific
+to the type of device
+*/
+func (o *PonSimDevice) injectChaos(fault string) bool {
+	switch fault {
+	case CHAOS_LINK_FLAP:
+		port := 1 + rand.Intn(2)
+		duration := o.Chaos.duration(fault)
+		if err := o.FlapPort(port, duration); err != nil {
+			common.Logger().WithFields(logrus.Fields{
+				"device": o,
+				"port":   port,
+				"error":  err.Error(),
+			}).Debug("Skipping chaos link flap")
+		} else {
+			o.chaosInjected(fault, fmt.Sprintf("port %d", port), duration)
+		}
+	case CHAOS_RPC_DELAY, CHAOS_FLOW_FAILURE:
+		o.Chaos.arm(fault)
+	default:
+		return false
+	}
+
+	return true
+}
+
+/*
+ChaosRpcDelay returns the delay injected into a management RPC by the chaos controller, if
+any, and reports it
+*/
+func (o *PonSimDevice) ChaosRpcDelay(method string) time.Duration {
+	delay := o.Chaos.takeRpcDelay()
+	if delay > 0 {
+		o.chaosInjected(CHAOS_RPC_DELAY, method, delay)
+	}
+
+	return delay
+}
+
+/*
+chaosFlowFailure returns the failure injected into a flow table update by the chaos
+controller, if any, and reports it
+*/
+func (o *PonSimDevice) chaosFlowFailure() error {
+	if !o.Chaos.takeFlowFailure() {
+		return nil
+	}
+
+	o.chaosInjected(CHAOS_FLOW_FAILURE, "flow table", 0)
+
+	return fmt.Errorf("flow table update failed (injected fault)")
+}
+
+/*
+injectChaos injects a fault into the OLT, dropping a random registered ONU for an ONU drop
+*/
+func (o *PonSimOltDevice) injectChaos(ctx context.Context, fault string) {
+	if o.PonSimDevice.injectChaos(fault) || fault != CHAOS_ONU_DROP {
+		return
+	}
+
+	onus := o.GetOnus()
+	if len(onus) == 0 {
+		return
+	}
+
+	ports := make([]int, 0, len(onus))
+	for port := range onus {
+		ports = append(ports, int(port))
+	}
+	sort.Ints(ports)
+
+	port := int32(ports[rand.Intn(len(ports))])
+	if err := o.RemoveOnu(ctx, port); err == nil {
+		o.chaosInjected(fault, fmt.Sprintf("ONU on port %d", port), 0)
+	}
+}
+
+/*
+injectChaos injects a fault into the ONU, dropping its connection to the OLT for an ONU drop,
+which makes it register again
+*/
+func (o *PonSimOnuDevice) injectChaos(ctx context.Context, fault string) {
+	if o.PonSimDevice.injectChaos(fault) || fault != CHAOS_ONU_DROP {
+		return
+	}
+
+	if conn := o.Conn; conn != nil {
+		conn.Close()
+		o.chaosInjected(fault, "connection to the OLT", 0)
+	}
+}
@@ -0,0 +1,262 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_CHECKPOINT_INTERVAL = 30 * time.Second
+)
+
+/*
+PonSimOnuCheckpoint records the registration of an ONU with the OLT
+*/
+type PonSimOnuCheckpoint struct {
+	Port         int32  `json:"port"`
+	SerialNumber string `json:"serial_number"`
+	Address      string `json:"address"`
+	GrpcPort     int32  `json:"grpc_port"`
+}
+
+/*
+PonSimDeviceCheckpoint is the state of a device which survives a restart of the simulator
+*/
+type PonSimDeviceCheckpoint struct {
+	Device    string                `json:"device"`
+	Timestamp time.Time             `json:"timestamp"`
+	Onus      []PonSimOnuCheckpoint `json:"onus,omitempty"`
+	PortsDown []int                 `json:"ports_down,omitempty"`
+	Disabled  []int                 `json:"ports_disabled,omitempty"`
+	Counters  *PonSimMetricCounter  `json:"counters"`
+}
+
+/*
+PonSimCheckpoint periodically saves the state of a device to a file so that it can be restored
+when the simulator restarts, e.g. during soak tests
+*/
+type PonSimCheckpoint struct {
+	Path     string        `json:"path"`
+	Interval time.Duration `json:"interval"`
+
+	mutex sync.Mutex
+}
+
+/*
+NewPonSimCheckpoint instantiates checkpoints saved to the specified file at every interval
+*/
+func NewPonSimCheckpoint(path string, interval time.Duration) *PonSimCheckpoint {
+	if interval <= 0 {
+		interval = DEFAULT_CHECKPOINT_INTERVAL
+	}
+	return &PonSimCheckpoint{Path: path, Interval: interval}
+}
+
+/*
+Load reads the last saved state, or returns nil when no state was saved
+*/
+func (c *PonSimCheckpoint) Load() (*PonSimDeviceCheckpoint, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	data, err := ioutil.ReadFile(c.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	state := &PonSimDeviceCheckpoint{Counters: NewPonSimMetricCounter("")}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+/*
+Save replaces the saved state.  The state is written to a temporary file first so that
+a crash never leaves a partial checkpoint behind.
+*/
+func (c *PonSimCheckpoint) Save(state *PonSimDeviceCheckpoint) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := c.Path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, c.Path)
+}
+
+/*
+Start saves the state returned by the collect function at every interval until the context
+is cancelled, and a last time then
+*/
+func (c *PonSimCheckpoint) Start(ctx context.Context, collect func() *PonSimDeviceCheckpoint) {
+	go func() {
+		ticker := time.NewTicker(c.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				c.save(collect())
+			case <-ctx.Done():
+				c.save(collect())
+				return
+			}
+		}
+	}()
+}
+
+/*
+save writes a checkpoint, logging failures which are retried at the next interval
+*/
+func (c *PonSimCheckpoint) save(state *PonSimDeviceCheckpoint) {
+	if err := c.Save(state); err != nil {
+		common.Logger().WithFields(logrus.Fields{
+			"device":     state.Device,
+			"checkpoint": c.Path,
+			"error":      err.Error(),
+		}).Error("Failed to save checkpoint")
+	}
+}
+
+/*
+makeCheckpoint captures the port states and counters of a device
+*/
+func (o *PonSimDevice) makeCheckpoint() *PonSimDeviceCheckpoint {
+	state := &PonSimDeviceCheckpoint{
+		Device:    o.Name,
+		Timestamp: o.Clock.Now(),
+		Counters:  o.Counter,
+	}
+	if o.PortStates != nil {
+		state.PortsDown, state.Disabled = o.PortStates.Snapshot()
+	}
+
+	return state
+}
+
+/*
+restoreCheckpoint loads the last saved state of a device and restores its port states and
+counters; the state is returned so that the specific state of the device can be restored
+*/
+func (o *PonSimDevice) restoreCheckpoint() *PonSimDeviceCheckpoint {
+	state, err := o.Checkpoint.Load()
+	if err != nil {
+		common.Logger().WithFields(logrus.Fields{
+			"device":     o,
+			"checkpoint": o.Checkpoint.Path,
+			"error":      err.Error(),
+		}).Error("Failed to load checkpoint")
+		return nil
+	} else if state == nil {
+		return nil
+	}
+
+	if o.PortStates != nil {
+		o.PortStates.Restore(state.PortsDown, state.Disabled)
+	}
+	if o.Counter != nil {
+		o.Counter.Restore(state.Counters)
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"device":     o,
+		"checkpoint": o.Checkpoint.Path,
+		"timestamp":  state.Timestamp,
+	}).Info("Restored checkpoint")
+
+	return state
+}
+
+/*
+makeCheckpoint captures the state of the OLT along with the registrations of its ONUs
+*/
+func (o *PonSimOltDevice) makeCheckpoint() *PonSimDeviceCheckpoint {
+	state := o.PonSimDevice.makeCheckpoint()
+
+	for port, onu := range o.GetOnus() {
+		state.Onus = append(state.Onus, PonSimOnuCheckpoint{
+			Port:         port,
+			SerialNumber: onu.Device.SerialNumber,
+			Address:      onu.Device.Address,
+			GrpcPort:     onu.Device.Port,
+		})
+	}
+
+	return state
+}
+
+/*
+restoreCheckpoint restores the state of the OLT.  The ports of the ONUs which were registered
+are reserved so that each ONU recovers its port when it registers again.
+*/
+func (o *PonSimOltDevice) restoreCheckpoint() {
+	state := o.PonSimDevice.restoreCheckpoint()
+	if state == nil {
+		return
+	}
+
+	o.restoredOnus = make(map[string]int32)
+	for _, onu := range state.Onus {
+		if onu.SerialNumber != "" {
+			o.restoredOnus[onu.SerialNumber] = onu.Port
+		}
+	}
+}
+
+/*
+restoredPort returns the port which an ONU registered on before the restart, or -1.
+It is called with the ONU lock held.
+*/
+func (o *PonSimOltDevice) restoredPort(serialNumber string) int32 {
+	port, ok := o.restoredOnus[serialNumber]
+	if !ok || o.Onus[port] != nil {
+		return -1
+	}
+	delete(o.restoredOnus, serialNumber)
+
+	return port
+}
+
+/*
+isRestoredPort reports whether a port is reserved for an ONU which has yet to register again
+*/
+func (o *PonSimOltDevice) isRestoredPort(port int32) bool {
+	for _, restored := range o.restoredOnus {
+		if restored == port {
+			return true
+		}
+	}
+	return false
+}
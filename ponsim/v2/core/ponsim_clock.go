@@ -0,0 +1,180 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/sirupsen/logrus"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// Largest drift accepted for the clock of a device, in parts per million
+	MAX_CLOCK_DRIFT = 500000
+)
+
+/*
+PonSimClock is the clock from which a device reports its timestamps.  It drifts away from
+the real time at a configurable rate until it is resynchronized, as a device would between
+two NTP synchronizations.
+*/
+type PonSimClock struct {
+	mutex    sync.RWMutex
+	drift    float64
+	offset   time.Duration
+	realBase time.Time
+}
+
+/*
+NewPonSimClock instantiates a clock in sync with the real time and drifting at the specified
+rate (in parts per million)
+*/
+func NewPonSimClock(drift float64) (*PonSimClock, error) {
+	c := &PonSimClock{realBase: time.Now()}
+	if err := c.SetDrift(drift); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+/*
+offsetAt returns the difference between the device time and the real time at a given instant
+*/
+func (c *PonSimClock) offsetAt(now time.Time) time.Duration {
+	elapsed := float64(now.Sub(c.realBase))
+	return c.offset + time.Duration(elapsed*c.drift/1e6)
+}
+
+/*
+Now returns the current device time, or the real time for devices without a clock
+*/
+func (c *PonSimClock) Now() time.Time {
+	now := time.Now()
+	if c == nil {
+		return now
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return now.Add(c.offsetAt(now))
+}
+
+/*
+Offset returns the current difference between the device time and the real time
+*/
+func (c *PonSimClock) Offset() time.Duration {
+	if c == nil {
+		return 0
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.offsetAt(time.Now())
+}
+
+/*
+Drift returns the rate at which the device time drifts, in parts per million
+*/
+func (c *PonSimClock) Drift() float64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.drift
+}
+
+/*
+SetDrift changes the rate at which the device time drifts from now on, without altering
+the offset accumulated so far
+*/
+func (c *PonSimClock) SetDrift(drift float64) error {
+	if math.IsNaN(drift) || math.Abs(drift) > MAX_CLOCK_DRIFT {
+		return fmt.Errorf("clock drift %g is not between -%d and %d ppm", drift, MAX_CLOCK_DRIFT, MAX_CLOCK_DRIFT)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	c.offset = c.offsetAt(now)
+	c.realBase = now
+	c.drift = drift
+
+	return nil
+}
+
+/*
+Resync brings the device time back to the real time, as an NTP synchronization would,
+and returns the correction which was applied
+*/
+func (c *PonSimClock) Resync() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	correction := -c.offsetAt(now)
+	c.offset = 0
+	c.realBase = now
+
+	return correction
+}
+
+/*
+SetClockDrift changes the rate at which the time of a device drifts
+*/
+func (o *PonSimDevice) SetClockDrift(drift float64) error {
+	if o.Clock == nil {
+		return errors.New("device does not have a clock")
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"device": o,
+		"drift":  drift,
+	}).Info("Setting clock drift")
+
+	return o.Clock.SetDrift(drift)
+}
+
+/*
+ResyncClock brings the time of a device back to the real time and raises an event
+reporting the correction, which consumers observe as a jump of the device time
+*/
+func (o *PonSimDevice) ResyncClock() error {
+	if o.Clock == nil {
+		return errors.New("device does not have a clock")
+	}
+
+	correction := o.Clock.Resync()
+
+	common.Logger().WithFields(logrus.Fields{
+		"device":     o,
+		"correction": correction,
+	}).Info("Resynchronized clock")
+
+	o.publishEvent(newClockSyncEvent(o.Name, correction))
+
+	return nil
+}
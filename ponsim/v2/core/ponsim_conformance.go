@@ -0,0 +1,428 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/openflow_13"
+	"github.com/opencord/voltha/protos/go/ponsim"
+	"github.com/sirupsen/logrus"
+	"net"
+)
+
+const (
+	conformanceUntagged = -1
+	conformanceDropped  = -2
+	conformanceTrapVlan = 4000
+)
+
+/*
+conformanceCheck describes a frame injected in a scratch device along with
+the forwarding result expected from the flow engine
+*/
+type conformanceCheck struct {
+	Name         string
+	Flows        []*openflow_13.OfpFlowStats
+	InPort       int
+	Frame        func() gopacket.Packet
+	ExpectedPort uint32
+	ExpectedVlan int
+}
+
+/*
+conformanceChecks is the bundled expectation set exercised by the conformance runner
+*/
+var conformanceChecks = []conformanceCheck{
+	{
+		Name:         "flow-in-port-output",
+		Flows:        []*openflow_13.OfpFlowStats{newFlow(1000, matchFields(matchInPort(2)), actionOutput(1))},
+		InPort:       2,
+		Frame:        func() gopacket.Packet { return newDhcpFrame(conformanceUntagged) },
+		ExpectedPort: 1,
+		ExpectedVlan: conformanceUntagged,
+	},
+	{
+		Name: "flow-eth-type-output",
+		Flows: []*openflow_13.OfpFlowStats{
+			newFlow(1000, matchFields(matchInPort(1), matchEthType(uint32(layers.EthernetTypeIPv4))), actionOutput(2)),
+		},
+		InPort:       1,
+		Frame:        func() gopacket.Packet { return newDhcpFrame(conformanceUntagged) },
+		ExpectedPort: 2,
+		ExpectedVlan: conformanceUntagged,
+	},
+	{
+		Name:         "flow-no-match-drop",
+		Flows:        []*openflow_13.OfpFlowStats{newFlow(1000, matchFields(matchInPort(2)), actionOutput(1))},
+		InPort:       1,
+		Frame:        func() gopacket.Packet { return newDhcpFrame(conformanceUntagged) },
+		ExpectedVlan: conformanceDropped,
+	},
+	{
+		Name: "tag-push-vlan",
+		Flows: []*openflow_13.OfpFlowStats{
+			newFlow(1000, matchFields(matchInPort(1)),
+				actionPushVlan(uint32(layers.EthernetTypeDot1Q)),
+				actionSetField(matchVlanVid(4096|100)),
+				actionOutput(2),
+			),
+		},
+		InPort:       1,
+		Frame:        func() gopacket.Packet { return newDhcpFrame(conformanceUntagged) },
+		ExpectedPort: 2,
+		ExpectedVlan: 100,
+	},
+	{
+		Name: "tag-pop-vlan",
+		Flows: []*openflow_13.OfpFlowStats{
+			newFlow(1000, matchFields(matchInPort(2), matchVlanVid(4096|100)), actionPopVlan(), actionOutput(1)),
+		},
+		InPort:       2,
+		Frame:        func() gopacket.Packet { return newDhcpFrame(100) },
+		ExpectedPort: 1,
+		ExpectedVlan: conformanceUntagged,
+	},
+	{
+		Name: "tag-set-vlan-vid",
+		Flows: []*openflow_13.OfpFlowStats{
+			newFlow(1000, matchFields(matchInPort(1), matchVlanVid(4096|100)),
+				actionSetField(matchVlanVid(4096|200)),
+				actionOutput(2),
+			),
+		},
+		InPort:       1,
+		Frame:        func() gopacket.Packet { return newDhcpFrame(100) },
+		ExpectedPort: 2,
+		ExpectedVlan: 200,
+	},
+	{
+		Name: "tag-vlan-mismatch-drop",
+		Flows: []*openflow_13.OfpFlowStats{
+			newFlow(1000, matchFields(matchInPort(1), matchVlanVid(4096|100)), actionOutput(2)),
+		},
+		InPort:       1,
+		Frame:        func() gopacket.Packet { return newDhcpFrame(200) },
+		ExpectedVlan: conformanceDropped,
+	},
+	{
+		Name: "priority-highest-wins",
+		Flows: []*openflow_13.OfpFlowStats{
+			newFlow(1000, matchFields(matchInPort(1), matchEthType(uint32(layers.EthernetTypeIPv4))),
+				actionPushVlan(uint32(layers.EthernetTypeDot1Q)),
+				actionSetField(matchVlanVid(4096|100)),
+				actionOutput(2),
+			),
+			newFlow(2000, matchFields(matchInPort(1)),
+				actionPushVlan(uint32(layers.EthernetTypeDot1Q)),
+				actionSetField(matchVlanVid(4096|200)),
+				actionOutput(2),
+			),
+		},
+		InPort:       1,
+		Frame:        func() gopacket.Packet { return newDhcpFrame(conformanceUntagged) },
+		ExpectedPort: 2,
+		ExpectedVlan: 200,
+	},
+	{
+		Name: "priority-tie-most-specific",
+		Flows: []*openflow_13.OfpFlowStats{
+			newFlow(1000, matchFields(matchInPort(1)),
+				actionPushVlan(uint32(layers.EthernetTypeDot1Q)),
+				actionSetField(matchVlanVid(4096|100)),
+				actionOutput(2),
+			),
+			newFlow(1000, matchFields(matchInPort(1), matchEthType(uint32(layers.EthernetTypeIPv4))),
+				actionPushVlan(uint32(layers.EthernetTypeDot1Q)),
+				actionSetField(matchVlanVid(4096|200)),
+				actionOutput(2),
+			),
+		},
+		InPort:       1,
+		Frame:        func() gopacket.Packet { return newDhcpFrame(conformanceUntagged) },
+		ExpectedPort: 2,
+		ExpectedVlan: 200,
+	},
+	{
+		Name: "priority-wildcard-fallback",
+		Flows: []*openflow_13.OfpFlowStats{
+			newFlow(2000, matchFields(matchInPort(2)), actionOutput(1)),
+			newFlow(0, matchFields(), actionOutput(2)),
+		},
+		InPort:       1,
+		Frame:        func() gopacket.Packet { return newDhcpFrame(conformanceUntagged) },
+		ExpectedPort: 2,
+		ExpectedVlan: conformanceUntagged,
+	},
+	{
+		Name: "trap-eapol",
+		Flows: []*openflow_13.OfpFlowStats{
+			newFlow(2000, matchFields(matchInPort(1), matchEthType(uint32(layers.EthernetTypeEAPOL))),
+				actionPushVlan(uint32(layers.EthernetTypeDot1Q)),
+				actionSetField(matchVlanVid(4096|conformanceTrapVlan)),
+				actionOutput(2),
+			),
+		},
+		InPort:       1,
+		Frame:        func() gopacket.Packet { return newEapolFrame() },
+		ExpectedPort: 2,
+		ExpectedVlan: conformanceTrapVlan,
+	},
+	{
+		Name: "trap-dhcp",
+		Flows: []*openflow_13.OfpFlowStats{
+			newFlow(2000, matchFields(
+				matchInPort(1),
+				matchEthType(uint32(layers.EthernetTypeIPv4)),
+				matchIpProto(uint32(layers.IPProtocolUDP)),
+				matchUdpSrc(68),
+				matchUdpDst(67),
+			),
+				actionPushVlan(uint32(layers.EthernetTypeDot1Q)),
+				actionSetField(matchVlanVid(4096|conformanceTrapVlan)),
+				actionOutput(2),
+			),
+		},
+		InPort:       1,
+		Frame:        func() gopacket.Packet { return newDhcpFrame(conformanceUntagged) },
+		ExpectedPort: 2,
+		ExpectedVlan: conformanceTrapVlan,
+	},
+	{
+		Name: "flow-ipv6-dst-prefix",
+		Flows: []*openflow_13.OfpFlowStats{
+			newFlow(1000, matchFields(
+				matchInPort(2),
+				matchEthType(uint32(layers.EthernetTypeIPv6)),
+				matchIpv6Dst(net.ParseIP("2001:db8:1::"), 64),
+			), actionOutput(1)),
+		},
+		InPort:       2,
+		Frame:        func() gopacket.Packet { return newDhcpv6Frame(net.ParseIP("2001:db8:1::2"), 547, 546) },
+		ExpectedPort: 1,
+		ExpectedVlan: conformanceUntagged,
+	},
+	{
+		Name: "trap-dhcpv6",
+		Flows: []*openflow_13.OfpFlowStats{
+			newFlow(2000, matchFields(
+				matchInPort(1),
+				matchEthType(uint32(layers.EthernetTypeIPv6)),
+				matchIpProto(uint32(layers.IPProtocolUDP)),
+				matchUdpSrc(546),
+				matchUdpDst(547),
+			),
+				actionPushVlan(uint32(layers.EthernetTypeDot1Q)),
+				actionSetField(matchVlanVid(4096|conformanceTrapVlan)),
+				actionOutput(2),
+			),
+		},
+		InPort:       1,
+		Frame:        func() gopacket.Packet { return newDhcpv6Frame(net.ParseIP("ff02::1:2"), 546, 547) },
+		ExpectedPort: 2,
+		ExpectedVlan: conformanceTrapVlan,
+	},
+	{
+		Name: "trap-igmp",
+		Flows: []*openflow_13.OfpFlowStats{
+			newFlow(2000, matchFields(
+				matchInPort(1),
+				matchEthType(uint32(layers.EthernetTypeIPv4)),
+				matchIpProto(uint32(layers.IPProtocolIGMP)),
+			),
+				actionPushVlan(uint32(layers.EthernetTypeDot1Q)),
+				actionSetField(matchVlanVid(4096|conformanceTrapVlan)),
+				actionOutput(2),
+			),
+		},
+		InPort:       1,
+		Frame:        func() gopacket.Packet { return newIgmpFrame() },
+		ExpectedPort: 2,
+		ExpectedVlan: conformanceTrapVlan,
+	},
+}
+
+/*
+RunConformanceSuite exercises the flow engine against the bundled expectation set
+and reports the outcome of each check
+*/
+func RunConformanceSuite(ctx context.Context) *ponsim.ConformanceReport {
+	return runConformanceSuite(ctx, func(int, int) {})
+}
+
+/*
+RunConformanceJob executes the conformance suite as a background job reporting its progress
+*/
+func RunConformanceJob(job *PonSimJob) error {
+	report := runConformanceSuite(job.Context(), func(done int, total int) {
+		job.SetProgress(float32(done*100)/float32(total), fmt.Sprintf("%d of %d checks run", done, total))
+	})
+	job.SetResult(report)
+
+	return nil
+}
+
+func runConformanceSuite(ctx context.Context, progress func(int, int)) *ponsim.ConformanceReport {
+	report := &ponsim.ConformanceReport{}
+
+	for i, check := range conformanceChecks {
+		if ctx.Err() != nil {
+			break
+		}
+
+		result := check.run(ctx)
+		if result.Passed {
+			report.Passed += 1
+		} else {
+			report.Failed += 1
+		}
+		report.Results = append(report.Results, result)
+
+		progress(i+1, len(conformanceChecks))
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"passed": report.Passed,
+		"failed": report.Failed,
+	}).Info("Completed conformance suite")
+
+	return report
+}
+
+/*
+run injects the check frame into a scratch device and compares the outcome with the expectation
+*/
+func (c conformanceCheck) run(ctx context.Context) *ponsim.ConformanceResult {
+	result := &ponsim.ConformanceResult{Name: c.Name}
+
+	device := &PonSimDevice{Name: "conformance." + c.Name}
+	device.InstallFlows(ctx, c.Flows)
+
+	var egressPort uint32
+	var egressFrame gopacket.Packet
+	if outputs := device.processFrame(ctx, c.InPort, c.Frame()); len(outputs) > 0 {
+		egressPort, egressFrame = outputs[0].Port, outputs[0].Frame
+	}
+
+	switch {
+	case egressFrame == nil && c.ExpectedVlan == conformanceDropped:
+		result.Passed = true
+		result.Detail = "frame was dropped"
+	case egressFrame == nil:
+		result.Detail = "frame was dropped unexpectedly"
+	case c.ExpectedVlan == conformanceDropped:
+		result.Detail = fmt.Sprintf("frame was forwarded to port %d instead of being dropped", egressPort)
+	case egressPort != c.ExpectedPort:
+		result.Detail = fmt.Sprintf("expected egress port %d, got %d", c.ExpectedPort, egressPort)
+	default:
+		actualVlan := conformanceUntagged
+		if dot1q := common.GetDot1QLayer(egressFrame); dot1q != nil {
+			actualVlan = int(dot1q.VLANIdentifier)
+		}
+		if actualVlan != c.ExpectedVlan {
+			result.Detail = fmt.Sprintf("expected vlan %d, got %d", c.ExpectedVlan, actualVlan)
+		} else {
+			result.Passed = true
+			result.Detail = fmt.Sprintf("frame was forwarded to port %d", egressPort)
+		}
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"check":  c.Name,
+		"result": result,
+	}).Debug("Ran conformance check")
+
+	return result
+}
+
+/*
+newConformanceFrame serializes the provided layers behind an ethernet header, tagged with the vlan if specified
+*/
+func newConformanceFrame(vlan int, etherType layers.EthernetType, upper ...gopacket.SerializableLayer) gopacket.Packet {
+	ethLayer := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x01},
+		DstMAC:       layers.EthernetBroadcast,
+		EthernetType: etherType,
+	}
+	frameLayers := []gopacket.SerializableLayer{ethLayer}
+
+	if vlan != conformanceUntagged {
+		ethLayer.EthernetType = layers.EthernetTypeDot1Q
+		frameLayers = append(frameLayers, &layers.Dot1Q{
+			Type:           etherType,
+			VLANIdentifier: uint16(vlan),
+		})
+	}
+	frameLayers = append(frameLayers, upper...)
+
+	buffer := gopacket.NewSerializeBuffer()
+	gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		frameLayers...,
+	)
+
+	return gopacket.NewPacket(buffer.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+}
+
+func newDhcpFrame(vlan int) gopacket.Packet {
+	ipLayer := &layers.IPv4{
+		Version:  ipVersion,
+		TTL:      ttl,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.IPv4zero,
+		DstIP:    net.IPv4bcast,
+	}
+	udpLayer := &layers.UDP{SrcPort: 68, DstPort: 67}
+	udpLayer.SetNetworkLayerForChecksum(ipLayer)
+
+	return newConformanceFrame(vlan, layers.EthernetTypeIPv4, ipLayer, udpLayer, gopacket.Payload(make([]byte, 32)))
+}
+
+func newDhcpv6Frame(dstIp net.IP, srcPort layers.UDPPort, dstPort layers.UDPPort) gopacket.Packet {
+	ipLayer := &layers.IPv6{
+		Version:    6,
+		HopLimit:   1,
+		NextHeader: layers.IPProtocolUDP,
+		SrcIP:      net.ParseIP("fe80::200:5eff:fe00:5301"),
+		DstIP:      dstIp,
+	}
+	udpLayer := &layers.UDP{SrcPort: srcPort, DstPort: dstPort}
+	udpLayer.SetNetworkLayerForChecksum(ipLayer)
+
+	return newConformanceFrame(conformanceUntagged, layers.EthernetTypeIPv6, ipLayer, udpLayer, gopacket.Payload(make([]byte, 32)))
+}
+
+func newEapolFrame() gopacket.Packet {
+	// EAPOL version 1, start message
+	return newConformanceFrame(conformanceUntagged, layers.EthernetTypeEAPOL, gopacket.Payload([]byte{0x01, 0x01, 0x00, 0x00}))
+}
+
+func newIgmpFrame() gopacket.Packet {
+	ipLayer := &layers.IPv4{
+		Version:  ipVersion,
+		TTL:      1,
+		Protocol: layers.IPProtocolIGMP,
+		SrcIP:    net.IPv4(10, 0, 0, 1),
+		DstIP:    net.IPv4(224, 0, 0, 22),
+	}
+
+	// IGMPv2 membership report for 239.1.1.1
+	return newConformanceFrame(conformanceUntagged, layers.EthernetTypeIPv4, ipLayer,
+		gopacket.Payload([]byte{0x16, 0x00, 0xfa, 0xf9, 0xef, 0x01, 0x01, 0x01}),
+	)
+}
@@ -0,0 +1,359 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Interval between two allocations, longer than the 125us of a real PON frame to keep the
+	// simulation light
+	DBA_CYCLE = 1 * time.Millisecond
+
+	DEFAULT_TCONT_PRIORITY = 0
+	DEFAULT_TCONT_WEIGHT   = 1
+	MAX_TCONT_PRIORITY     = 7
+
+	// Weight of the last cycle in the smoothed utilization of a T-CONT
+	DBA_UTILIZATION_SMOOTHING = 0.1
+)
+
+/*
+PonSimTcontProfile defines how the upstream bandwidth is shared with a T-CONT: T-CONTs are
+served by decreasing priority and T-CONTs of the same priority share the remaining bandwidth
+in proportion to their weight
+*/
+type PonSimTcontProfile struct {
+	Priority int `json:"priority"`
+	Weight   int `json:"weight"`
+}
+
+/*
+ParseTcontProfiles parses a comma separated list of T-CONT profiles in the format
+alloc_id:priority:weight, e.g. 1024:7:1,1025:0:4
+*/
+func ParseTcontProfiles(spec string) (map[uint32]PonSimTcontProfile, error) {
+	profiles := make(map[uint32]PonSimTcontProfile)
+
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		fields := strings.Split(entry, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid T-CONT profile specification: %s", entry)
+		}
+
+		allocId, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid T-CONT alloc id: %s", fields[0])
+		}
+		priority, err := strconv.Atoi(fields[1])
+		if err != nil || priority < 0 || priority > MAX_TCONT_PRIORITY {
+			return nil, fmt.Errorf("invalid T-CONT priority: %s", fields[1])
+		}
+		weight, err := strconv.Atoi(fields[2])
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid T-CONT weight: %s", fields[2])
+		}
+
+		profiles[uint32(allocId)] = PonSimTcontProfile{Priority: priority, Weight: weight}
+	}
+
+	return profiles, nil
+}
+
+/*
+PonSimTcontGrants tracks the upstream demand of a T-CONT and the transmission opportunities
+granted to it
+*/
+type PonSimTcontGrants struct {
+	AllocId uint32             `json:"alloc_id"`
+	Profile PonSimTcontProfile `json:"profile"`
+
+	GrantedBytes int64   `json:"granted_bytes"`
+	SentBytes    int64   `json:"sent_bytes"`
+	Utilization  float64 `json:"utilization"`
+
+	requested int64
+	credit    int64
+}
+
+/*
+demand returns the number of bytes waiting for a grant
+*/
+func (t *PonSimTcontGrants) demand() int64 {
+	if demand := t.requested - t.credit; demand > 0 {
+		return demand
+	}
+	return 0
+}
+
+/*
+PonSimDba simulates the dynamic bandwidth allocation of the upstream capacity of a PON port.
+Every cycle the ONUs report the bytes waiting in their T-CONTs and the OLT grants each T-CONT
+a share of the capacity; a frame is only sent once enough bytes were granted to its T-CONT.
+*/
+type PonSimDba struct {
+	Rate     int                           `json:"rate"` // Mbps
+	Profiles map[uint32]PonSimTcontProfile `json:"profiles"`
+
+	mutex   sync.Mutex
+	granted *sync.Cond
+	tconts  map[uint32]*PonSimTcontGrants
+	stopped bool
+	done    chan struct{}
+}
+
+/*
+NewPonSimDba instantiates the allocation of an upstream capacity, in Mbps, between T-CONTs
+with the specified profiles
+*/
+func NewPonSimDba(rate int, profiles map[uint32]PonSimTcontProfile) (*PonSimDba, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("invalid upstream rate: %d", rate)
+	}
+
+	dba := &PonSimDba{
+		Rate:     rate,
+		Profiles: profiles,
+		tconts:   make(map[uint32]*PonSimTcontGrants),
+		stopped:  true,
+	}
+	dba.granted = sync.NewCond(&dba.mutex)
+
+	return dba, nil
+}
+
+/*
+getTcont returns the grants of a T-CONT, which are created on its first transmission
+*/
+func (d *PonSimDba) getTcont(allocId uint32) *PonSimTcontGrants {
+	tcont, ok := d.tconts[allocId]
+	if !ok {
+		profile, ok := d.Profiles[allocId]
+		if !ok {
+			profile = PonSimTcontProfile{Priority: DEFAULT_TCONT_PRIORITY, Weight: DEFAULT_TCONT_WEIGHT}
+		}
+		tcont = &PonSimTcontGrants{AllocId: allocId, Profile: profile}
+		d.tconts[allocId] = tcont
+	}
+
+	return tcont
+}
+
+/*
+Transmit reports a frame waiting in a T-CONT and blocks until enough bytes are granted to the
+T-CONT to send it.  Frames are not held while the allocation is stopped.
+*/
+func (d *PonSimDba) Transmit(allocId uint32, size int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	tcont := d.getTcont(allocId)
+	tcont.requested += int64(size)
+	for tcont.credit < int64(size) && !d.stopped {
+		d.granted.Wait()
+	}
+
+	tcont.requested -= int64(size)
+	if tcont.credit -= int64(size); tcont.credit < 0 {
+		tcont.credit = 0
+	}
+	tcont.SentBytes += int64(size)
+}
+
+/*
+Start runs the allocation cycles
+*/
+func (d *PonSimDba) Start() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if !d.stopped {
+		return
+	}
+	d.stopped = false
+	d.done = make(chan struct{})
+
+	go func(done chan struct{}) {
+		ticker := time.NewTicker(DBA_CYCLE)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				d.allocate()
+			case <-done:
+				return
+			}
+		}
+	}(d.done)
+}
+
+/*
+Stop ends the allocation cycles and releases the frames waiting for a grant
+*/
+func (d *PonSimDba) Stop() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.stopped {
+		return
+	}
+	d.stopped = true
+	close(d.done)
+	d.granted.Broadcast()
+}
+
+/*
+allocate grants the capacity of a cycle to the T-CONTs reporting a demand, by decreasing
+priority and in proportion to their weight within a priority
+*/
+func (d *PonSimDba) allocate() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	budget := int64(float64(d.Rate) * 1000000 / 8 * DBA_CYCLE.Seconds())
+	capacity := budget
+
+	byPriority := make(map[int][]*PonSimTcontGrants)
+	var priorities []int
+	for _, tcont := range d.tconts {
+		if _, ok := byPriority[tcont.Profile.Priority]; !ok {
+			priorities = append(priorities, tcont.Profile.Priority)
+		}
+		byPriority[tcont.Profile.Priority] = append(byPriority[tcont.Profile.Priority], tcont)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(priorities)))
+
+	grants := make(map[uint32]int64)
+	for _, priority := range priorities {
+		budget -= shareBudget(byPriority[priority], budget, grants)
+		if budget <= 0 {
+			break
+		}
+	}
+
+	for allocId, tcont := range d.tconts {
+		grant := grants[allocId]
+		tcont.credit += grant
+		tcont.GrantedBytes += grant
+		tcont.Utilization += DBA_UTILIZATION_SMOOTHING * (float64(grant)/float64(capacity) - tcont.Utilization)
+	}
+
+	if len(grants) > 0 {
+		d.granted.Broadcast()
+	}
+}
+
+/*
+shareBudget shares a budget between T-CONTs of the same priority in proportion to their weight,
+without granting more than their demand, and returns the number of bytes granted
+*/
+func shareBudget(tconts []*PonSimTcontGrants, budget int64, grants map[uint32]int64) int64 {
+	var total int64
+
+	for budget > 0 {
+		var weights int64
+		for _, tcont := range tconts {
+			if tcont.demand() > grants[tcont.AllocId] {
+				weights += int64(tcont.Profile.Weight)
+			}
+		}
+		if weights == 0 {
+			break
+		}
+
+		var round int64
+		for _, tcont := range tconts {
+			wanted := tcont.demand() - grants[tcont.AllocId]
+			if wanted <= 0 {
+				continue
+			}
+
+			share := budget * int64(tcont.Profile.Weight) / weights
+			if share == 0 {
+				share = 1
+			}
+			if share > wanted {
+				share = wanted
+			}
+			if share > budget-round {
+				share = budget - round
+			}
+			grants[tcont.AllocId] += share
+			round += share
+		}
+
+		budget -= round
+		total += round
+		if round == 0 {
+			break
+		}
+	}
+
+	return total
+}
+
+/*
+GetTconts returns a snapshot of the grants of the T-CONTs, sorted by alloc id
+*/
+func (d *PonSimDba) GetTconts() []PonSimTcontGrants {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	tconts := make([]PonSimTcontGrants, 0, len(d.tconts))
+	for _, tcont := range d.tconts {
+		tconts = append(tconts, *tcont)
+	}
+	sort.Slice(tconts, func(i, j int) bool { return tconts[i].AllocId < tconts[j].AllocId })
+
+	return tconts
+}
+
+/*
+MakeProto returns the counters of the T-CONTs, named after their alloc id, e.g. tcont1024.
+The utilization is the smoothed share of the upstream capacity granted to the T-CONT, in
+percent.
+*/
+func (d *PonSimDba) MakeProto() []*voltha.PonSimPortMetrics {
+	var metrics []*voltha.PonSimPortMetrics
+
+	for _, tcont := range d.GetTconts() {
+		metrics = append(metrics, &voltha.PonSimPortMetrics{
+			PortName: fmt.Sprintf("tcont%d", tcont.AllocId),
+			Packets: []*voltha.PonSimPacketCounter{
+				{Name: "priority", Value: int64(tcont.Profile.Priority)},
+				{Name: "weight", Value: int64(tcont.Profile.Weight)},
+				{Name: "granted_bytes", Value: tcont.GrantedBytes},
+				{Name: "sent_bytes", Value: tcont.SentBytes},
+				{Name: "pending_bytes", Value: tcont.requested},
+				{Name: "utilization", Value: int64(tcont.Utilization*100 + 0.5)},
+			},
+		})
+	}
+
+	return metrics
+}
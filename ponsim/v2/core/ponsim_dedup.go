@@ -0,0 +1,88 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"crypto/sha256"
+	"github.com/google/gopacket"
+	"sync"
+	"time"
+)
+
+type dedupEntry struct {
+	Digest   [sha256.Size]byte
+	Received time.Time
+}
+
+/*
+PonSimDedup detects exact duplicates of frames received on the same port within a short window,
+such as the double deliveries caused by the veth/bridge plumbing of the simulator
+*/
+type PonSimDedup struct {
+	Window time.Duration `json:"window"`
+
+	mutex   sync.Mutex
+	entries map[int][]dedupEntry
+	seen    map[int]map[[sha256.Size]byte]bool
+}
+
+/*
+NewPonSimDedup instantiates a deduplication guard remembering frames for the specified window
+*/
+func NewPonSimDedup(window time.Duration) *PonSimDedup {
+	return &PonSimDedup{
+		Window:  window,
+		entries: make(map[int][]dedupEntry),
+		seen:    make(map[int]map[[sha256.Size]byte]bool),
+	}
+}
+
+/*
+IsDuplicate reports whether an identical frame was received on the port within the window,
+and remembers the frame otherwise
+*/
+func (d *PonSimDedup) IsDuplicate(port int, frame gopacket.Packet) bool {
+	if d == nil {
+		return false
+	}
+
+	now := time.Now()
+	digest := sha256.Sum256(frame.Data())
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, ok := d.seen[port]; !ok {
+		d.seen[port] = make(map[[sha256.Size]byte]bool)
+	}
+	seen := d.seen[port]
+
+	// Entries are kept in order of reception, forget the ones which left the window
+	entries := d.entries[port]
+	for len(entries) > 0 && now.Sub(entries[0].Received) > d.Window {
+		delete(seen, entries[0].Digest)
+		entries = entries[1:]
+	}
+
+	duplicate := seen[digest]
+	if !duplicate {
+		seen[digest] = true
+		entries = append(entries, dedupEntry{Digest: digest, Received: now})
+	}
+	d.entries[port] = entries
+
+	return duplicate
+}
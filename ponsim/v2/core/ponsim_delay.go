@@ -0,0 +1,274 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+PonSimDirection identifies whether a setting applies to frames received or sent on a port
+*/
+type PonSimDirection uint8
+
+const (
+	INGRESS PonSimDirection = iota
+	EGRESS
+)
+
+var enum_ponsim_direction = []string{
+	"INGRESS",
+	"EGRESS",
+}
+
+func (d PonSimDirection) String() string {
+	return enum_ponsim_direction[d]
+}
+
+/*
+PonSimDelayDistribution defines how delays are sampled
+*/
+type PonSimDelayDistribution uint8
+
+const (
+	FIXED PonSimDelayDistribution = iota
+	UNIFORM
+	NORMAL
+)
+
+var enum_ponsim_delay_distribution = []string{
+	"FIXED",
+	"UNIFORM",
+	"NORMAL",
+}
+
+func (d PonSimDelayDistribution) String() string {
+	return enum_ponsim_delay_distribution[d]
+}
+
+/*
+PonSimDelay describes the latency added to frames along with its jitter.
+
+FIXED always applies the delay, UNIFORM picks a value within [delay-jitter, delay+jitter]
+and NORMAL uses the jitter as standard deviation around the delay.
+*/
+type PonSimDelay struct {
+	Distribution PonSimDelayDistribution `json:"distribution"`
+	Delay        time.Duration           `json:"delay"`
+	Jitter       time.Duration           `json:"jitter"`
+}
+
+/*
+Sample returns a delay drawn from the distribution
+*/
+func (d PonSimDelay) Sample() time.Duration {
+	delay := d.Delay
+
+	switch d.Distribution {
+	case UNIFORM:
+		if d.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(2*d.Jitter)+1)) - d.Jitter
+		}
+	case NORMAL:
+		delay += time.Duration(rand.NormFloat64() * float64(d.Jitter))
+	}
+
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+/*
+PonSimPortDelays holds the delays configured per port and direction, along with the queues
+delaying the frames sent on each port
+*/
+type PonSimPortDelays struct {
+	mutex  sync.RWMutex
+	delays map[int][2]*PonSimDelay
+	queues map[int]*PonSimDelayQueue
+}
+
+/*
+NewPonSimPortDelays instantiates an empty delay configuration
+*/
+func NewPonSimPortDelays() *PonSimPortDelays {
+	return &PonSimPortDelays{
+		delays: make(map[int][2]*PonSimDelay),
+		queues: make(map[int]*PonSimDelayQueue),
+	}
+}
+
+/*
+Set configures the delay of a port in the specified direction; a nil delay removes it
+*/
+func (p *PonSimPortDelays) Set(port int, direction PonSimDirection, delay *PonSimDelay) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	entry := p.delays[port]
+	entry[direction] = delay
+	p.delays[port] = entry
+}
+
+/*
+Get returns the delay configured on a port in the specified direction
+*/
+func (p *PonSimPortDelays) Get(port int, direction PonSimDirection) *PonSimDelay {
+	if p == nil {
+		return nil
+	}
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.delays[port][direction]
+}
+
+/*
+Clear removes the delays configured on every port
+*/
+func (p *PonSimPortDelays) Clear() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.delays = make(map[int][2]*PonSimDelay)
+}
+
+/*
+Sample returns the delay to apply to a frame received on a port and sent on another
+*/
+func (p *PonSimPortDelays) Sample(ingressPort int, egressPort int) time.Duration {
+	var total time.Duration
+
+	if delay := p.Get(ingressPort, INGRESS); delay != nil {
+		total += delay.Sample()
+	}
+	if delay := p.Get(egressPort, EGRESS); delay != nil {
+		total += delay.Sample()
+	}
+
+	return total
+}
+
+/*
+Queue returns the queue delaying the frames sent on a port, created when a frame is first delayed.
+Once the queue exists every frame sent on the port goes through it, delayed or not, so that the
+frames of the port are not reordered.
+*/
+func (p *PonSimPortDelays) Queue(port int, delay time.Duration) *PonSimDelayQueue {
+	if p == nil {
+		return nil
+	}
+
+	p.mutex.RLock()
+	queue := p.queues[port]
+	p.mutex.RUnlock()
+
+	if queue != nil || delay <= 0 {
+		return queue
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if queue = p.queues[port]; queue == nil {
+		queue = NewPonSimDelayQueue(DEFAULT_DELAY_QUEUE_DEPTH)
+		p.queues[port] = queue
+	}
+
+	return queue
+}
+
+/*
+Stop terminates the queues delaying the frames sent on the ports
+*/
+func (p *PonSimPortDelays) Stop() {
+	if p == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for port, queue := range p.queues {
+		queue.Stop()
+		delete(p.queues, port)
+	}
+}
+
+/*
+ParsePortDelays parses a comma separated list of port delays in the format
+port:direction:distribution:delay[:jitter], e.g. 2:egress:normal:10ms:2ms
+*/
+func ParsePortDelays(spec string) (*PonSimPortDelays, error) {
+	delays := NewPonSimPortDelays()
+
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		fields := strings.Split(entry, ":")
+		if len(fields) != 4 && len(fields) != 5 {
+			return nil, fmt.Errorf("invalid delay specification: %s", entry)
+		}
+
+		port, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid delay port: %s", fields[0])
+		}
+
+		direction, err := parseEnum(enum_ponsim_direction, fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid delay direction: %s", fields[1])
+		}
+
+		distribution, err := parseEnum(enum_ponsim_delay_distribution, fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid delay distribution: %s", fields[2])
+		}
+
+		delay := &PonSimDelay{Distribution: PonSimDelayDistribution(distribution)}
+		if delay.Delay, err = time.ParseDuration(fields[3]); err != nil {
+			return nil, fmt.Errorf("invalid delay duration: %s", fields[3])
+		}
+		if len(fields) == 5 {
+			if delay.Jitter, err = time.ParseDuration(fields[4]); err != nil {
+				return nil, fmt.Errorf("invalid delay jitter: %s", fields[4])
+			}
+		}
+
+		delays.Set(port, PonSimDirection(direction), delay)
+	}
+
+	return delays, nil
+}
+
+func parseEnum(values []string, value string) (int, error) {
+	for i, v := range values {
+		if strings.EqualFold(v, value) {
+			return i, nil
+		}
+	}
+
+	return -1, fmt.Errorf("unknown value: %s", value)
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_DELAY_QUEUE_DEPTH = 1024
+)
+
+/*
+ErrDelayQueueStopped is returned when a task is submitted to a stopped delay queue
+*/
+var ErrDelayQueueStopped = errors.New("delay queue is stopped")
+
+/*
+PonSimDelayQueue runs delayed tasks, such as the delivery of frames, in order of submission.
+
+A task runs once its delay has elapsed but never before the tasks submitted earlier, so that
+frames sent on a link are not reordered when their delays vary.  Submitting a task blocks while
+the queue is full.
+*/
+type PonSimDelayQueue struct {
+	tasks chan ponSimDelayedTask
+	done  chan struct{}
+	once  sync.Once
+}
+
+type ponSimDelayedTask struct {
+	release time.Time
+	run     func()
+}
+
+/*
+NewPonSimDelayQueue instantiates and starts a delay queue holding up to depth tasks
+*/
+func NewPonSimDelayQueue(depth int) *PonSimDelayQueue {
+	if depth <= 0 {
+		depth = DEFAULT_DELAY_QUEUE_DEPTH
+	}
+
+	q := &PonSimDelayQueue{
+		tasks: make(chan ponSimDelayedTask, depth),
+		done:  make(chan struct{}),
+	}
+	go q.work()
+
+	return q
+}
+
+func (q *PonSimDelayQueue) work() {
+	for {
+		select {
+		case task := <-q.tasks:
+			if wait := time.Until(task.release); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-q.done:
+					timer.Stop()
+					return
+				}
+			}
+			task.run()
+		case <-q.done:
+			return
+		}
+	}
+}
+
+/*
+Submit queues a task to run after the specified delay
+*/
+func (q *PonSimDelayQueue) Submit(delay time.Duration, task func()) error {
+	select {
+	case q.tasks <- ponSimDelayedTask{release: time.Now().Add(delay), run: task}:
+		return nil
+	case <-q.done:
+		return ErrDelayQueueStopped
+	}
+}
+
+/*
+Stop terminates the queue, discarding the tasks still queued
+*/
+func (q *PonSimDelayQueue) Stop() {
+	if q == nil {
+		return
+	}
+
+	q.once.Do(func() { close(q.done) })
+}
@@ -16,23 +16,30 @@
 package core
 
 import (
+	"bytes"
 	"context"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcap"
 	"github.com/opencord/voltha/ponsim/v2/common"
 	"github.com/opencord/voltha/protos/go/openflow_13"
 	"github.com/sirupsen/logrus"
 	"net"
 	"sort"
+	"sync"
+	"time"
 )
 
+// Logger of the forwarding of the frames, whose level is set independently
+var forwardingLogger = common.Logger().Component(common.LOG_COMPONENT_FORWARDING)
+
 // TODO: Pass-in the certificate information as a structure parameter
 // TODO: Add certification information
 
 type PonSimDevice struct {
 	Name        string               `json:name`
 	Port        int32                `json:port`
+	RestPort    int32                `json:"rest_port"`
+	Socket      string               `json:"socket"`
 	Address     string               `json:address`
 	ExternalIf  string               `json:external_if`
 	InternalIf  string               `json:internal_if`
@@ -42,35 +49,246 @@ type PonSimDevice struct {
 	AlarmsFreq  int                  `json:alarm_freq`
 	Counter     *PonSimMetricCounter `json:counter`
 
+	BandwidthProfile *PonSimBandwidthProfile `json:"bandwidth_profile"`
+	FlowJournal      *PonSimFlowJournal      `json:"flow_journal"`
+	FlowStore        *PonSimFlowStore        `json:"flow_store"`
+	Checkpoint       *PonSimCheckpoint       `json:"checkpoint"`
+	FlowStats        *PonSimFlowStats        `json:"-"`
+	Delays           *PonSimPortDelays       `json:"-"`
+	Faults           *PonSimPortFaults       `json:"-"`
+	PortStates       *PonSimPortStates       `json:"-"`
+	Events           *PonSimEventBus         `json:"-"`
+	BootDelay        time.Duration           `json:"boot_delay"`
+	FrameHash        bool                    `json:"frame_hash"`
+	ResponseSize     int                     `json:"response_size"`
+	RunInfo          *PonSimRunInfo          `json:"-"`
+	Jobs             *PonSimJobs             `json:"-"`
+	PacketIO         *PonSimPacketIO         `json:"-"`
+	Mtus             *PonSimPortMtus         `json:"-"`
+	Dedup            *PonSimDedup            `json:"-"`
+	Inventory        *PonSimInventory        `json:"inventory"`
+	Identity         *PonSimIdentity         `json:"identity"`
+	Clock            *PonSimClock            `json:"-"`
+	Workers          *PonSimWorkerPool       `json:"workers"`
+	Audit            *PonSimAudit            `json:"audit"`
+	RateLimit        *PonSimRateLimit        `json:"rate_limit"`
+	ApiAuth          *PonSimApiAuth          `json:"-"`
+	Compression      string                  `json:"compression"`
+	AlarmSink        *common.KafkaProducer   `json:"alarm_sink"`
+	Pm               *PonSimPm               `json:"pm"`
+	Diagnostics      *PonSimDiagnostics      `json:"diagnostics"`
+	Sflow            *PonSimSflowSampler     `json:"-"`
+	Ipfix            *PonSimIpfixExporter    `json:"ipfix"`
+	Chaos            *PonSimChaos            `json:"chaos"`
+	PcapDir          string                  `json:"pcap_dir"`
+	Mirror           *PonSimMirror           `json:"-"`
+	Cfm              *PonSimCfm              `json:"-"`
+	TableMiss        *PonSimTableMiss        `json:"-"`
+
 	//*grpc.GrpcSecurity
 
-	flows          []*openflow_13.OfpFlowStats `json:-`
-	ingressHandler *pcap.Handle                `json:-`
-	egressHandler  *pcap.Handle                `json:-`
-	links          map[int]map[int]interface{} `json:-`
+	flows          *PonSimFlowTable          `json:-`
+	ingressHandler PonSimPacketHandle        `json:-`
+	egressHandler  PonSimPacketHandle        `json:-`
+	links          *ponSimLinks              `json:-`
+	shapers        map[int]*PonSimPortShaper `json:"-"`
+	trapPort       int                       `json:"-"`
+	groups         *PonSimGroupTable         `json:"-"`
+	meters         *PonSimMeterTable         `json:"-"`
+}
+
+/*
+ponSimLinks holds the functional operations linked to the ports of a device.  Links change
+while frames are forwarded, e.g. when ONUs register with an OLT.
+*/
+type ponSimLinks struct {
+	mutex sync.RWMutex
+	ports map[int]map[int]interface{}
+}
+
+/*
+get returns the functional operations linked to a port
+*/
+func (l *ponSimLinks) get(port int) []interface{} {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	links := make([]interface{}, 0, len(l.ports[port]))
+	for _, link := range l.ports[port] {
+		links = append(links, link)
+	}
+
+	return links
+}
+
+/*
+linkedPorts returns the ports of the device to which functional operations are linked
+*/
+func (l *ponSimLinks) linkedPorts() []int {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	ports := make([]int, 0, len(l.ports))
+	for port := range l.ports {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+
+	return ports
+}
+
+/*
+ponSimOutput is a frame resulting from the processing of a flow along with its egress port
+*/
+type ponSimOutput struct {
+	Port  uint32
+	Frame gopacket.Packet
+	Flow  *openflow_13.OfpFlowStats
 }
 
 const (
-	UDP_DST  = 1
-	UDP_SRC  = 2
-	IPV4_DST = 4
-	VLAN_PCP = 8
-	VLAN_VID = 16
-	IP_PROTO = 32
-	ETH_TYPE = 64
-	IN_PORT  = 128
+	UDP_DST     = 1
+	UDP_SRC     = 2
+	TCP_DST     = 4
+	TCP_SRC     = 8
+	ICMPV6_TYPE = 16
+	IPV4_DST    = 32
+	IPV6_DST    = 64
+	IPV6_SRC    = 128
+	VLAN_PCP    = 256
+	VLAN_VID    = 512
+	IP_PROTO    = 1024
+	ETH_TYPE    = 2048
+	IN_PORT     = 4096
+
+	// Mask of a flow whose criteria are not all met by a frame
+	NO_MATCH = -1
 )
 
 /*
 Start performs common setup operations for a ponsim device
 */
 func (o *PonSimDevice) Start(ctx context.Context) {
+	// Restore the flows recorded before the last shutdown
+	if o.FlowJournal != nil {
+		if flows, err := o.FlowJournal.Replay(); err != nil {
+			common.Logger().WithFields(logrus.Fields{
+				"device":  o,
+				"journal": o.FlowJournal.Path,
+				"error":   err.Error(),
+			}).Error("Failed to replay flow journal")
+		} else if flows != nil {
+			o.applyFlows(flows)
+			return
+		}
+	}
+
+	// Otherwise restore the flows saved by a previous instance
+	if o.FlowStore != nil {
+		if flows, err := o.FlowStore.Load(); err != nil {
+			common.Logger().WithFields(logrus.Fields{
+				"device": o,
+				"key":    o.FlowStore.Key,
+				"error":  err.Error(),
+			}).Error("Failed to load flows from store")
+		} else if flows != nil {
+			common.Logger().WithFields(logrus.Fields{
+				"device": o,
+				"key":    o.FlowStore.Key,
+				"flows":  len(flows),
+			}).Info("Restored flows from store")
+			o.applyFlows(flows)
+		}
+	}
 }
 
 /*
 Stop performs common cleanup operations for a ponsim device
 */
 func (o *PonSimDevice) Stop(ctx context.Context) {
+	if o.FlowJournal != nil {
+		o.FlowJournal.Close()
+	}
+
+	o.Mirror.Close()
+	o.Cfm.Stop()
+	o.Workers.Stop()
+	o.Delays.Stop()
+	for _, shaper := range o.shapers {
+		shaper.Stop()
+	}
+}
+
+/*
+reboot clears the state lost by a device when it reboots and keeps its ports down
+until the boot delay has elapsed
+*/
+func (o *PonSimDevice) reboot(ctx context.Context) {
+	common.Logger().WithFields(logrus.Fields{
+		"device":    o,
+		"bootDelay": o.BootDelay,
+	}).Info("Rebooting device")
+
+	o.publishEvent(newRebootEvent(o.Name, o.BootDelay))
+
+	if err := o.InstallFlows(ctx, nil); err != nil {
+		common.Logger().WithFields(logrus.Fields{
+			"device": o,
+			"error":  err.Error(),
+		}).Error("Problem clearing flows")
+	}
+
+	if o.PortStates != nil {
+		o.setPortState(1, false)
+		o.setPortState(2, false)
+
+		time.AfterFunc(o.BootDelay, func() {
+			o.setPortState(1, true)
+			o.setPortState(2, true)
+
+			common.Logger().WithFields(logrus.Fields{
+				"device": o,
+			}).Info("Device has booted")
+		})
+	}
+}
+
+/*
+stopTraffic clears the faults and delays injected on the ports, disables the chaos controller
+and cancels the running jobs
+*/
+func (o *PonSimDevice) stopTraffic() {
+	if o.Faults != nil {
+		o.Faults.Clear()
+	}
+	if o.Delays != nil {
+		o.Delays.Clear()
+	}
+
+	// Disabling the chaos controller disarms its pending faults; its catalog is kept so that
+	// it can be enabled again
+	if o.Chaos != nil {
+		_, catalog := o.Chaos.Get()
+		o.Chaos.Set(false, catalog)
+	}
+
+	cancelled := 0
+	if o.Jobs != nil {
+		cancelled = o.Jobs.CancelAll()
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"device":    o,
+		"cancelled": cancelled,
+	}).Warn("Stopped all traffic")
 }
 
 /*
@@ -87,58 +305,324 @@ func (o *PonSimDevice) GetPort() int32 {
 	return o.Port
 }
 
+/*
+GetRestPort returns the port on which the services of the device are exposed over REST/JSON,
+or 0 when they are not
+*/
+func (o *PonSimDevice) GetRestPort() int32 {
+	return o.RestPort
+}
+
+/*
+GetSocket returns the path of the Unix socket on which the GRPC services of the device are
+also served, or an empty string when they are only served over TCP
+*/
+func (o *PonSimDevice) GetSocket() string {
+	return o.Socket
+}
+
 /*
 Forward is responsible of processing incoming data, filtering it and redirecting to the
-intended destination
+intended destination.  Frames are processed by the worker pool of the device when it has one.
 */
 func (o *PonSimDevice) Forward(
 	ctx context.Context,
 	port int,
 	frame gopacket.Packet,
 ) error {
-	common.Logger().WithFields(logrus.Fields{
-		"device": o,
-		"port":   port,
-		"frame":  frame,
-	}).Debug("Forwarding packet")
+	if o.Workers == nil {
+		return o.forward(ctx, port, frame)
+	}
+
+	return o.Workers.Submit(port, frame, func() {
+		if err := o.forward(ctx, port, frame); err != nil {
+			forwardingLogger.WithFields(logrus.Fields{
+				"device": o,
+				"port":   port,
+				"error":  err.Error(),
+			}).Error("Problem forwarding frame")
+		}
+	})
+}
+
+/*
+forward processes a frame received on a port
+*/
+func (o *PonSimDevice) forward(
+	ctx context.Context,
+	port int,
+	frame gopacket.Packet,
+) error {
+	if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+		entry.WithFields(logrus.Fields{
+			"device": o,
+			"port":   port,
+			"frame":  frame,
+		}).Debug("Forwarding packet")
+	}
 
 	var err error
 
+	ctx, span := common.StartSpan(ctx, "Forward", common.SPAN_KIND_CONSUMER)
+	span.SetAttribute("ponsim.device", o.Name)
+	span.SetAttribute("ponsim.port", port)
+	span.SetAttribute("ponsim.size", len(frame.Data()))
+	defer span.Finish()
+
+	// Frames received on a port which is down are lost
+	if !o.PortStates.IsUp(port) {
+		return nil
+	}
+
 	o.Counter.CountRxFrame(port, len(common.GetEthernetLayer(frame).Payload))
+	o.mirrorFrame(port, MIRROR_RX, frame)
 
-	if egressPort, egressFrame := o.processFrame(ctx, port, frame); egressFrame != nil {
-		forwarded := 0
-		links := o.links[int(egressPort)]
+	// The CFM frames of the level of a maintenance end point facing the link are consumed by it
+	if o.Cfm.Receive(port, CFM_DOWN, frame) {
+		return nil
+	}
 
-		o.Counter.CountTxFrame(int(egressPort), len(common.GetEthernetLayer(egressFrame).Payload))
+	if o.Dedup.IsDuplicate(port, frame) {
+		o.Counter.CountDuplicateFrame(port)
+		forwardingLogger.WithFields(logrus.Fields{
+			"device": o,
+			"port":   port,
+		}).Debug("Dropping duplicate frame")
+		return nil
+	}
 
-		for _, link := range links {
-			forwarded += 1
+	if !o.Mtus.Fits(port, frame) {
+		o.Counter.CountOversizeFrame(port)
+		forwardingLogger.WithFields(logrus.Fields{
+			"device": o,
+			"port":   port,
+			"size":   len(frame.Data()),
+			"mtu":    o.Mtus.Get(port),
+		}).Debug("Dropping frame exceeding the MTU of the ingress port")
+		return nil
+	}
 
-			common.Logger().WithFields(logrus.Fields{
+	// Verify the integrity of the frame as received, and carry its hash so that any
+	// alteration other than the configured actions can be detected downstream
+	hash := common.GetFrameHash(frame)
+	if hash != nil && !bytes.Equal(hash, common.HashFrame(frame.Data())) {
+		o.Counter.CountHashError(port)
+		forwardingLogger.WithFields(logrus.Fields{
+			"device": o,
+			"port":   port,
+		}).Warn("Frame content does not match its hash")
+	} else if hash == nil && o.FrameHash {
+		hash = common.HashFrame(frame.Data())
+	}
+
+	// Inject the faults configured on the ingress port
+	var corrupted bool
+	if frame, corrupted = o.Faults.Apply(port, frame); frame == nil {
+		o.Counter.CountDroppedFrame(port)
+		forwardingLogger.WithFields(logrus.Fields{
+			"device": o,
+			"port":   port,
+		}).Debug("Dropped frame by fault injection")
+		return nil
+	} else if corrupted {
+		o.Counter.CountCorruptedFrame(port)
+	}
+
+	// Enforce the bandwidth profile of traffic received on a shaped port; the frame is
+	// switched once released by the shaper, after the span of its receipt has ended
+	if shaper, ok := o.shapers[port]; ok {
+		span.SetAttribute("ponsim.shaped", true)
+		o.shapeFrame(shaper.Upstream, port, frame, func() {
+			o.switchFrame(ctx, span, port, frame, hash, corrupted)
+		})
+		return nil
+	}
+
+	span.SetAttribute("ponsim.outputs", o.switchFrame(ctx, span, port, frame, hash, corrupted))
+
+	return err
+}
+
+/*
+switchFrame processes a received frame against the flow table and sends it to the resulting
+outputs.  It returns the number of outputs.
+*/
+func (o *PonSimDevice) switchFrame(
+	ctx context.Context,
+	span *common.Span,
+	port int,
+	frame gopacket.Packet,
+	hash []byte,
+	corrupted bool,
+) int {
+	outputs := o.processFrame(ctx, port, frame)
+	if outputs == nil {
+		forwardingLogger.WithFields(logrus.Fields{
+			"device": o,
+			"port":   port,
+			"frame":  frame,
+		}).Error("Failed to properly process frame")
+	}
+
+	for _, output := range outputs {
+		// Frames output to the controller, or trapped to VOLTHA through the NNI, are packet-ins,
+		// those without a flow having matched none
+		if output.Port == CONTROLLER_PORT || o.isTrappedOutput(output) {
+			reason := openflow_13.OfpPacketInReason_OFPR_ACTION
+			if output.Flow == nil {
+				reason = openflow_13.OfpPacketInReason_OFPR_NO_MATCH
+			}
+			output.Frame = withPacketIn(output.Frame, newPacketIn(port, output.Flow, reason))
+		}
+
+		if hash != nil {
+			// Frames rewritten by actions are hashed again, unless they were corrupted on receipt
+			outputHash := hash
+			if output.Frame != frame && !corrupted {
+				outputHash = common.HashFrame(output.Frame.Data())
+			}
+			common.SetFrameHash(output.Frame, outputHash)
+		}
+		if span != nil {
+			common.SetFrameTrace(output.Frame, span.Context)
+		}
+
+		// Frames output to the controller are not subject to the egress processing of a port
+		if output.Port == CONTROLLER_PORT {
+			o.sendToController(port, output.Frame)
+			continue
+		}
+
+		o.sendFrame(port, int(output.Port), output.Frame)
+	}
+
+	return len(outputs)
+}
+
+/*
+sendFrame delivers a processed frame to the links of its egress port
+*/
+func (o *PonSimDevice) sendFrame(port int, egressPort int, egressFrame gopacket.Packet) {
+	links := o.links.get(egressPort)
+
+	if !o.PortStates.IsUp(egressPort) {
+		return
+	}
+
+	// Likewise for the maintenance end points facing the device
+	if o.Cfm.Receive(egressPort, CFM_UP, egressFrame) {
+		return
+	}
+
+	if !o.Mtus.Fits(egressPort, egressFrame) {
+		o.Counter.CountOversizeFrame(egressPort)
+		forwardingLogger.WithFields(logrus.Fields{
+			"device": o,
+			"port":   egressPort,
+			"size":   len(egressFrame.Data()),
+			"mtu":    o.Mtus.Get(egressPort),
+		}).Debug("Dropping frame exceeding the MTU of the egress port")
+		return
+	}
+
+	// Enforce the bandwidth profile of traffic sent to a shaped port
+	if shaper, ok := o.shapers[egressPort]; ok {
+		o.shapeFrame(shaper.Downstream, egressPort, egressFrame, func() {
+			o.transmitFrame(port, egressPort, egressFrame, links)
+		})
+		return
+	}
+
+	o.transmitFrame(port, egressPort, egressFrame, links)
+}
+
+/*
+transmitFrame delivers a frame to the links of its egress port, after the latency of its ports
+*/
+func (o *PonSimDevice) transmitFrame(port int, egressPort int, egressFrame gopacket.Packet, links []interface{}) {
+	o.Counter.CountTxFrame(egressPort, len(common.GetEthernetLayer(egressFrame).Payload))
+	o.Sflow.Sample(port, egressPort, egressFrame)
+	o.Ipfix.Record(port, egressPort, egressFrame)
+	o.mirrorFrame(egressPort, MIRROR_TX, egressFrame)
+
+	// Lazily decoded frames must be complete before being shared with the links
+	common.DecodeFrame(egressFrame)
+
+	if len(links) == 0 {
+		forwardingLogger.WithFields(logrus.Fields{
+			"device": o,
+			"port":   port,
+			"frame":  egressFrame,
+		}).Warn("Nothing was forwarded")
+		return
+	}
+
+	// Apply the latency configured on the ingress and egress ports
+	delay := o.Delays.Sample(port, egressPort)
+
+	deliver := func() {
+		for _, link := range links {
+			forwardingLogger.WithFields(logrus.Fields{
 				"device":      o,
 				"egressPort":  port,
 				"egressFrame": egressFrame,
+				"delay":       delay,
 			}).Debug("Forwarding packet to link")
 
-			link.(func(int, gopacket.Packet))(int(egressPort), egressFrame)
+			link.(func(int, gopacket.Packet))(egressPort, egressFrame)
 		}
-		if forwarded == 0 {
-			common.Logger().WithFields(logrus.Fields{
+	}
+
+	if queue := o.Delays.Queue(egressPort, delay); queue != nil {
+		if err := queue.Submit(delay, deliver); err != nil {
+			forwardingLogger.WithFields(logrus.Fields{
 				"device": o,
-				"port":   port,
-				"frame":  frame,
-			}).Warn("Nothing was forwarded")
+				"port":   egressPort,
+				"error":  err.Error(),
+			}).Debug("Dropping delayed frame")
 		}
 	} else {
-		common.Logger().WithFields(logrus.Fields{
-			"device": o,
-			"port":   egressPort,
-			"frame":  egressFrame,
-		}).Error("Failed to properly process frame")
+		deliver()
 	}
+}
 
-	return err
+/*
+SetBandwidthProfile enables the shaping of the traffic received and sent on a port
+*/
+func (o *PonSimDevice) SetBandwidthProfile(port int, profile PonSimBandwidthProfile) {
+	if o.shapers == nil {
+		o.shapers = make(map[int]*PonSimPortShaper)
+	}
+	if shaper, ok := o.shapers[port]; ok {
+		shaper.Stop()
+	}
+	o.shapers[port] = NewPonSimPortShaper(profile)
+
+	common.Logger().WithFields(logrus.Fields{
+		"device":  o,
+		"port":    port,
+		"profile": profile,
+	}).Info("Applied bandwidth profile")
+}
+
+/*
+shapeFrame queues a frame until it conforms to the bandwidth profile of the port, and then
+releases it.  Frames exceeding the profile are dropped.
+*/
+func (o *PonSimDevice) shapeFrame(shaper *PonSimShaper, port int, frame gopacket.Packet, release func()) {
+	if ok, err := shaper.Release(len(frame.Data()), release); !ok {
+		forwardingLogger.WithFields(logrus.Fields{
+			"device": o,
+			"port":   port,
+			"frame":  frame,
+		}).Debug("Dropping frame exceeding bandwidth profile")
+	} else if err != nil {
+		forwardingLogger.WithFields(logrus.Fields{
+			"device": o,
+			"port":   port,
+			"error":  err.Error(),
+		}).Debug("Dropping shaped frame")
+	}
 }
 
 /*
@@ -150,8 +634,8 @@ func (o *PonSimDevice) connectNetworkInterfaces() {
 	}).Debug("Opening network interfaces")
 
 	var err error
-	if o.ingressHandler, err = pcap.OpenLive(
-		o.ExternalIf, o.SnapshotLen, o.Promiscuous, pcap.BlockForever,
+	if o.ingressHandler, err = o.PacketIO.Open(
+		o.ExternalIf, o.SnapshotLen, o.Promiscuous,
 	); err != nil {
 		common.Logger().WithFields(logrus.Fields{
 			"device":    o,
@@ -165,8 +649,8 @@ func (o *PonSimDevice) connectNetworkInterfaces() {
 		}).Info("Opened Ingress interface")
 	}
 
-	if o.egressHandler, err = pcap.OpenLive(
-		o.InternalIf, o.SnapshotLen, o.Promiscuous, pcap.BlockForever,
+	if o.egressHandler, err = o.PacketIO.Open(
+		o.InternalIf, o.SnapshotLen, o.Promiscuous,
 	); err != nil {
 		common.Logger().WithFields(logrus.Fields{
 			"device":    o,
@@ -199,12 +683,16 @@ func (o *PonSimDevice) AddLink(
 	}).Debug("Linking port to functional operation")
 
 	if o.links == nil {
-		o.links = make(map[int]map[int]interface{})
+		o.links = &ponSimLinks{ports: make(map[int]map[int]interface{})}
 	}
-	if _, ok := o.links[port]; !ok {
-		o.links[port] = make(map[int]interface{})
+
+	o.links.mutex.Lock()
+	defer o.links.mutex.Unlock()
+
+	if _, ok := o.links.ports[port]; !ok {
+		o.links.ports[port] = make(map[int]interface{})
 	}
-	o.links[port][index] = function
+	o.links.ports[port][index] = function
 
 	return nil
 }
@@ -216,15 +704,22 @@ func (o *PonSimDevice) RemoveLink(
 	port int,
 	index int,
 ) error {
-	if _, hasPort := o.links[port]; hasPort {
-		if _, hasIndex := o.links[port][index]; hasIndex {
+	if o.links == nil {
+		return nil
+	}
+
+	o.links.mutex.Lock()
+	defer o.links.mutex.Unlock()
+
+	if _, hasPort := o.links.ports[port]; hasPort {
+		if _, hasIndex := o.links.ports[port][index]; hasIndex {
 			common.Logger().WithFields(logrus.Fields{
 				"device": o,
 				"port":   port,
 				"index":  index,
 			}).Debug("Removing link functional operation")
 
-			delete(o.links[port], index)
+			delete(o.links.ports[port], index)
 
 		} else {
 			common.Logger().WithFields(logrus.Fields{
@@ -252,70 +747,121 @@ func (o *PonSimDevice) InstallFlows(
 	ctx context.Context,
 	flows []*openflow_13.OfpFlowStats,
 ) error {
-	common.Logger().WithFields(logrus.Fields{
+	return o.updateFlows(ctx, func([]*openflow_13.OfpFlowStats) ([]*openflow_13.OfpFlowStats, error) {
+		return flows, nil
+	})
+}
+
+/*
+updateFlows computes the flows of the device from the installed flows, then records and applies
+them while preventing any other update
+*/
+func (o *PonSimDevice) updateFlows(
+	ctx context.Context,
+	update func([]*openflow_13.OfpFlowStats) ([]*openflow_13.OfpFlowStats, error),
+) error {
+	table := o.flowTable()
+	table.updateMutex.Lock()
+	defer table.updateMutex.Unlock()
+
+	flows, err := update(table.Get())
+	if err != nil {
+		return err
+	}
+
+	common.Logger().ForContext(ctx).WithFields(logrus.Fields{
 		"device": o,
 		"flows":  flows,
 	}).Debug("Installing flows")
 
-	o.flows = flows
-	sort.Sort(common.SortByPriority(o.flows))
+	// Flows must be journaled before being applied so they survive a restart
+	if o.FlowJournal != nil {
+		if err := o.FlowJournal.Append(flows); err != nil {
+			return err
+		}
+	}
+	if o.FlowStore != nil {
+		if err := o.FlowStore.Save(flows); err != nil {
+			return err
+		}
+	}
+
+	o.applyFlows(flows)
+
+	return nil
+}
+
+/*
+applyFlows replaces the flows of the device, sorted in decreasing order of priority
+*/
+func (o *PonSimDevice) applyFlows(flows []*openflow_13.OfpFlowStats) {
+	o.FlowStats.Reset(flows)
+	o.flowTable().Set(flows)
 
 	common.Logger().WithFields(logrus.Fields{
 		"device": o,
 	}).Debug("Installed sorted flows")
+}
 
-	return nil
+/*
+flowTable returns the flow table of the device, created on first use for devices which were not
+instantiated by a constructor, such as scratch devices
+*/
+func (o *PonSimDevice) flowTable() *PonSimFlowTable {
+	if o.flows == nil {
+		o.flows = NewPonSimFlowTable()
+	}
+
+	return o.flows
 }
 
 /*
 processFrame is responsible for matching or discarding a frame based on the configured flows
+
+The frame is processed by the highest priority flow whose criteria are all met.  When several
+flows of that priority match, the flow matching the most significant fields wins (see the
+match masks), and among equally specific flows the one installed first.
 */
 func (o *PonSimDevice) processFrame(
 	ctx context.Context,
 	port int,
 	frame gopacket.Packet,
-) (uint32, gopacket.Packet) {
-	common.Logger().WithFields(logrus.Fields{
-		"device": o,
-		"port":   port,
-		"frame":  frame,
-	}).Debug("Processing frame")
+) []ponSimOutput {
+	if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+		entry.WithFields(logrus.Fields{
+			"device": o,
+			"port":   port,
+			"frame":  frame,
+		}).Debug("Processing frame")
+	}
 
 	var err error
-	var matchedMask int = 0
+	var matchedMask int = NO_MATCH
 	var currentMask int
-	var highestPriority uint32 = 0
 	var matchedFlow *openflow_13.OfpFlowStats = nil
 
-	common.Logger().WithFields(logrus.Fields{
+	forwardingLogger.WithFields(logrus.Fields{
 		"device": o,
 	}).Debug("Looping through flows")
 
-	for _, flow := range o.flows {
-		common.Logger().WithFields(logrus.Fields{
+	for _, flow := range o.flows.Get() {
+		forwardingLogger.WithFields(logrus.Fields{
 			"device": o,
 			"flow":   flow,
 		}).Debug("Checking flow")
 
-		if matchedFlow != nil && flow.Priority < highestPriority {
-			common.Logger().WithFields(logrus.Fields{
+		// Flows are sorted by decreasing priority, lower priority flows cannot take precedence
+		if matchedFlow != nil && flow.Priority < matchedFlow.Priority {
+			forwardingLogger.WithFields(logrus.Fields{
 				"device":      o,
 				"matchedFlow": matchedFlow,
-				"priority":    highestPriority,
+				"priority":    matchedFlow.Priority,
 			}).Debug("Flow has already been matched")
 			break
-		} else {
-			common.Logger().WithFields(logrus.Fields{
-				"device":          o,
-				"matchedFlow":     matchedFlow,
-				"priority":        flow.Priority,
-				"highestPriority": highestPriority,
-			}).Debug("Flow OR Priority requirements not met")
 		}
 
-		highestPriority = flow.Priority
 		if currentMask, err = o.isMatch(ctx, flow, port, frame); err != nil {
-			common.Logger().WithFields(logrus.Fields{
+			forwardingLogger.WithFields(logrus.Fields{
 				"device": o,
 				"flow":   flow,
 				"port":   port,
@@ -327,29 +873,39 @@ func (o *PonSimDevice) processFrame(
 			matchedMask = currentMask
 			matchedFlow = flow
 
-			common.Logger().WithFields(logrus.Fields{
-				"device":      o,
-				"matchedFlow": flow,
-				"port":        port,
-				"frame":       frame,
-				"matchedMask": matchedMask,
-			}).Debug("Flow matches")
+			if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+				entry.WithFields(logrus.Fields{
+					"device":      o,
+					"matchedFlow": flow,
+					"port":        port,
+					"frame":       frame,
+					"matchedMask": matchedMask,
+				}).Debug("Flow matches")
+			}
 		}
 	}
 
 	if matchedFlow != nil {
-		egressPort, egressFrame := o.processActions(ctx, matchedFlow, frame)
+		o.FlowStats.Count(matchedFlow, len(frame.Data()))
 
-		common.Logger().WithFields(logrus.Fields{
-			"device":      o,
-			"port":        port,
-			"egressPort":  egressPort,
-			"egressFrame": egressFrame,
+		// Frames exceeding the rate of a meter are dropped without being a processing failure
+		if !o.meterFrame(matchedFlow, port, frame) {
+			return []ponSimOutput{}
+		}
+
+		outputs := o.processActions(ctx, matchedFlow, frame)
+
+		forwardingLogger.WithFields(logrus.Fields{
+			"device":  o,
+			"port":    port,
+			"outputs": outputs,
 		}).Debug("Processed actions to matched flow")
 
-		return egressPort, egressFrame
+		return outputs
+	} else if outputs := o.missFrame(port, frame); outputs != nil {
+		return outputs
 	} else {
-		common.Logger().WithFields(logrus.Fields{
+		forwardingLogger.WithFields(logrus.Fields{
 			"device":      o,
 			"port":        port,
 			"frame":       frame,
@@ -357,7 +913,7 @@ func (o *PonSimDevice) processFrame(
 		}).Warn("Flow was not successfully matched")
 	}
 
-	return 0, nil
+	return nil
 }
 
 /*
@@ -376,15 +932,15 @@ func (o *PonSimDevice) isMatch(
 			switch ofbfield.GetOfbField().Type {
 			case openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_IN_PORT:
 				if ofbfield.GetOfbField().GetPort() != uint32(port) {
-					common.Logger().WithFields(logrus.Fields{
+					forwardingLogger.WithFields(logrus.Fields{
 						"device":   o,
 						"flow":     flow,
 						"expected": ofbfield.GetOfbField().GetPort(),
 						"actual":   port,
 					}).Warn("Port does not match")
-					return 0, nil
+					return NO_MATCH, nil
 				} else {
-					common.Logger().WithFields(logrus.Fields{
+					forwardingLogger.WithFields(logrus.Fields{
 						"device":   o,
 						"flow":     flow,
 						"expected": ofbfield.GetOfbField().GetPort(),
@@ -399,15 +955,15 @@ func (o *PonSimDevice) isMatch(
 					cmpType = uint32(dot1q.Type)
 				}
 				if ofbfield.GetOfbField().GetEthType() != cmpType {
-					common.Logger().WithFields(logrus.Fields{
+					forwardingLogger.WithFields(logrus.Fields{
 						"device":   o,
 						"flow":     flow,
 						"expected": layers.EthernetType(ofbfield.GetOfbField().GetEthType()),
 						"actual":   common.GetEthernetLayer(frame).EthernetType,
 					}).Warn("Frame type does not match")
-					return 0, nil
+					return NO_MATCH, nil
 				} else {
-					common.Logger().WithFields(logrus.Fields{
+					forwardingLogger.WithFields(logrus.Fields{
 						"device":   o,
 						"flow":     flow,
 						"expected": layers.EthernetType(ofbfield.GetOfbField().GetEthType()),
@@ -417,20 +973,21 @@ func (o *PonSimDevice) isMatch(
 				matchedMask |= ETH_TYPE
 
 			case openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_IP_PROTO:
-				if ofbfield.GetOfbField().GetIpProto() != uint32(common.GetIpLayer(frame).Protocol) {
-					common.Logger().WithFields(logrus.Fields{
+				ipProto, isIp := common.GetIpProtocol(frame)
+				if !isIp || ofbfield.GetOfbField().GetIpProto() != uint32(ipProto) {
+					forwardingLogger.WithFields(logrus.Fields{
 						"device":   o,
 						"flow":     flow,
 						"expected": ofbfield.GetOfbField().GetIpProto(),
-						"actual":   common.GetIpLayer(frame).Protocol,
+						"actual":   ipProto,
 					}).Warn("IP protocol does not match")
-					return 0, nil
+					return NO_MATCH, nil
 				} else {
-					common.Logger().WithFields(logrus.Fields{
+					forwardingLogger.WithFields(logrus.Fields{
 						"device":   o,
 						"flow":     flow,
 						"expected": ofbfield.GetOfbField().GetIpProto(),
-						"actual":   common.GetIpLayer(frame).Protocol,
+						"actual":   ipProto,
 					}).Debug("IP protocol matches")
 				}
 				matchedMask |= IP_PROTO
@@ -440,26 +997,26 @@ func (o *PonSimDevice) isMatch(
 				dot1q := common.GetDot1QLayer(frame)
 
 				if (expectedVlan&4096 == 0) != (dot1q == nil) {
-					common.Logger().WithFields(logrus.Fields{
+					forwardingLogger.WithFields(logrus.Fields{
 						"device":       o,
 						"flow":         flow,
 						"expectedVlan": expectedVlan,
 						"vlanBitwise":  expectedVlan & 4096,
 						"dot1q":        dot1q,
 					}).Warn("VLAN condition not met")
-					return 0, nil
+					return NO_MATCH, nil
 				}
 				if dot1q != nil {
 					if uint32(dot1q.VLANIdentifier) != (expectedVlan & 4095) {
-						common.Logger().WithFields(logrus.Fields{
+						forwardingLogger.WithFields(logrus.Fields{
 							"device":   o,
 							"flow":     flow,
 							"expected": expectedVlan,
 							"actual":   uint32(dot1q.VLANIdentifier),
 						}).Warn("VLAN VID does not match")
-						return 0, nil
+						return NO_MATCH, nil
 					} else {
-						common.Logger().WithFields(logrus.Fields{
+						forwardingLogger.WithFields(logrus.Fields{
 							"device":   o,
 							"flow":     flow,
 							"expected": expectedVlan,
@@ -467,7 +1024,7 @@ func (o *PonSimDevice) isMatch(
 						}).Debug("VLAN VID matches")
 					}
 				} else {
-					common.Logger().WithFields(logrus.Fields{
+					forwardingLogger.WithFields(logrus.Fields{
 						"device": o,
 						"flow":   flow,
 					}).Warn("VLAN VID missing. Not dot1q encapsulation")
@@ -476,15 +1033,15 @@ func (o *PonSimDevice) isMatch(
 
 			case openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_VLAN_PCP:
 				if ofbfield.GetOfbField().GetVlanPcp() != uint32(common.GetDot1QLayer(frame).Priority) {
-					common.Logger().WithFields(logrus.Fields{
+					forwardingLogger.WithFields(logrus.Fields{
 						"device":   o,
 						"flow":     flow,
 						"expected": ofbfield.GetOfbField().GetVlanPcp(),
 						"actual":   uint32(common.GetDot1QLayer(frame).Priority),
 					}).Warn("VLAN priority does not match")
-					return 0, nil
+					return NO_MATCH, nil
 				} else {
-					common.Logger().WithFields(logrus.Fields{
+					forwardingLogger.WithFields(logrus.Fields{
 						"device":   o,
 						"flow":     flow,
 						"expected": ofbfield.GetOfbField().GetVlanPcp(),
@@ -502,15 +1059,15 @@ func (o *PonSimDevice) isMatch(
 					byte(dstIpRaw&0xFF))
 
 				if !dstIp.Equal(common.GetIpLayer(frame).DstIP) {
-					common.Logger().WithFields(logrus.Fields{
+					forwardingLogger.WithFields(logrus.Fields{
 						"device":   o,
 						"flow":     flow,
 						"expected": dstIp,
 						"actual":   common.GetIpLayer(frame).DstIP,
 					}).Warn("IPv4 destination does not match")
-					return 0, nil
+					return NO_MATCH, nil
 				} else {
-					common.Logger().WithFields(logrus.Fields{
+					forwardingLogger.WithFields(logrus.Fields{
 						"device":   o,
 						"flow":     flow,
 						"expected": dstIp,
@@ -522,15 +1079,15 @@ func (o *PonSimDevice) isMatch(
 
 			case openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_UDP_SRC:
 				if ofbfield.GetOfbField().GetUdpSrc() != uint32(common.GetUdpLayer(frame).SrcPort) {
-					common.Logger().WithFields(logrus.Fields{
+					forwardingLogger.WithFields(logrus.Fields{
 						"device":   o,
 						"flow":     flow,
 						"expected": ofbfield.GetOfbField().GetUdpSrc(),
 						"actual":   common.GetUdpLayer(frame).SrcPort,
 					}).Warn("UDP source port does not match")
-					return 0, nil
+					return NO_MATCH, nil
 				} else {
-					common.Logger().WithFields(logrus.Fields{
+					forwardingLogger.WithFields(logrus.Fields{
 						"device":   o,
 						"flow":     flow,
 						"expected": ofbfield.GetOfbField().GetUdpSrc(),
@@ -541,15 +1098,15 @@ func (o *PonSimDevice) isMatch(
 
 			case openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_UDP_DST:
 				if ofbfield.GetOfbField().GetUdpDst() != uint32(common.GetUdpLayer(frame).DstPort) {
-					common.Logger().WithFields(logrus.Fields{
+					forwardingLogger.WithFields(logrus.Fields{
 						"device":   o,
 						"flow":     flow,
 						"expected": ofbfield.GetOfbField().GetUdpDst(),
 						"actual":   common.GetUdpLayer(frame).DstPort,
 					}).Warn("UDP destination port does not match")
-					return 0, nil
+					return NO_MATCH, nil
 				} else {
-					common.Logger().WithFields(logrus.Fields{
+					forwardingLogger.WithFields(logrus.Fields{
 						"device":   o,
 						"flow":     flow,
 						"expected": ofbfield.GetOfbField().GetUdpDst(),
@@ -558,15 +1115,112 @@ func (o *PonSimDevice) isMatch(
 				}
 				matchedMask |= UDP_DST
 
+			case openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_IPV6_SRC:
+				srcIp := net.IP(ofbfield.GetOfbField().GetIpv6Src())
+				if !ipv6Matches(ofbfield, srcIp, ofbfield.GetOfbField().GetIpv6SrcMask(), common.GetIpv6Layer(frame).SrcIP) {
+					forwardingLogger.WithFields(logrus.Fields{
+						"device":   o,
+						"flow":     flow,
+						"expected": srcIp,
+						"actual":   common.GetIpv6Layer(frame).SrcIP,
+					}).Warn("IPv6 source does not match")
+					return NO_MATCH, nil
+				} else {
+					forwardingLogger.WithFields(logrus.Fields{
+						"device":   o,
+						"flow":     flow,
+						"expected": srcIp,
+						"actual":   common.GetIpv6Layer(frame).SrcIP,
+					}).Debug("IPv6 source matches")
+				}
+				matchedMask |= IPV6_SRC
+
+			case openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_IPV6_DST:
+				dstIp := net.IP(ofbfield.GetOfbField().GetIpv6Dst())
+				if !ipv6Matches(ofbfield, dstIp, ofbfield.GetOfbField().GetIpv6DstMask(), common.GetIpv6Layer(frame).DstIP) {
+					forwardingLogger.WithFields(logrus.Fields{
+						"device":   o,
+						"flow":     flow,
+						"expected": dstIp,
+						"actual":   common.GetIpv6Layer(frame).DstIP,
+					}).Warn("IPv6 destination does not match")
+					return NO_MATCH, nil
+				} else {
+					forwardingLogger.WithFields(logrus.Fields{
+						"device":   o,
+						"flow":     flow,
+						"expected": dstIp,
+						"actual":   common.GetIpv6Layer(frame).DstIP,
+					}).Debug("IPv6 destination matches")
+				}
+				matchedMask |= IPV6_DST
+
+			case openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_TCP_SRC:
+				if ofbfield.GetOfbField().GetTcpSrc() != uint32(common.GetTcpLayer(frame).SrcPort) {
+					forwardingLogger.WithFields(logrus.Fields{
+						"device":   o,
+						"flow":     flow,
+						"expected": ofbfield.GetOfbField().GetTcpSrc(),
+						"actual":   common.GetTcpLayer(frame).SrcPort,
+					}).Warn("TCP source port does not match")
+					return NO_MATCH, nil
+				} else {
+					forwardingLogger.WithFields(logrus.Fields{
+						"device":   o,
+						"flow":     flow,
+						"expected": ofbfield.GetOfbField().GetTcpSrc(),
+						"actual":   common.GetTcpLayer(frame).SrcPort,
+					}).Debug("TCP source port matches")
+				}
+				matchedMask |= TCP_SRC
+
+			case openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_TCP_DST:
+				if ofbfield.GetOfbField().GetTcpDst() != uint32(common.GetTcpLayer(frame).DstPort) {
+					forwardingLogger.WithFields(logrus.Fields{
+						"device":   o,
+						"flow":     flow,
+						"expected": ofbfield.GetOfbField().GetTcpDst(),
+						"actual":   common.GetTcpLayer(frame).DstPort,
+					}).Warn("TCP destination port does not match")
+					return NO_MATCH, nil
+				} else {
+					forwardingLogger.WithFields(logrus.Fields{
+						"device":   o,
+						"flow":     flow,
+						"expected": ofbfield.GetOfbField().GetTcpDst(),
+						"actual":   common.GetTcpLayer(frame).DstPort,
+					}).Debug("TCP destination port matches")
+				}
+				matchedMask |= TCP_DST
+
+			case openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_ICMPV6_TYPE:
+				icmp := common.GetIcmpv6Layer(frame)
+				if icmp == nil || ofbfield.GetOfbField().GetIcmpv6Type() != uint32(icmp.TypeCode.Type()) {
+					forwardingLogger.WithFields(logrus.Fields{
+						"device":   o,
+						"flow":     flow,
+						"expected": ofbfield.GetOfbField().GetIcmpv6Type(),
+						"icmp":     icmp,
+					}).Warn("ICMPv6 type does not match")
+					return NO_MATCH, nil
+				} else {
+					forwardingLogger.WithFields(logrus.Fields{
+						"device":   o,
+						"flow":     flow,
+						"expected": ofbfield.GetOfbField().GetIcmpv6Type(),
+					}).Debug("ICMPv6 type matches")
+				}
+				matchedMask |= ICMPV6_TYPE
+
 			case openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_METADATA:
-				common.Logger().WithFields(logrus.Fields{
+				forwardingLogger.WithFields(logrus.Fields{
 					"device": o,
 					"flow":   flow,
 				}).Warn("Skipping metadata")
 				continue
 
 			default:
-				common.Logger().WithFields(logrus.Fields{
+				forwardingLogger.WithFields(logrus.Fields{
 					"device": o,
 					"flow":   flow,
 					"type":   ofbfield.GetOfbField().Type,
@@ -577,6 +1231,44 @@ func (o *PonSimDevice) isMatch(
 	return matchedMask, nil
 }
 
+/*
+serializeFrame builds the frame resulting from an action, or keeps the original frame if it cannot be built
+*/
+func (o *PonSimDevice) serializeFrame(
+	flow *openflow_13.OfpFlowStats,
+	frame gopacket.Packet,
+	options gopacket.SerializeOptions,
+	frameLayers ...gopacket.SerializableLayer,
+) gopacket.Packet {
+	retFrame, err := common.SerializeFrame(options, frameLayers...)
+	if err != nil {
+		forwardingLogger.WithFields(logrus.Fields{
+			"device": o,
+			"flow":   flow,
+			"frame":  frame,
+			"error":  err.Error(),
+		}).Error("Problem serializing frame")
+		return frame
+	}
+
+	return retFrame
+}
+
+/*
+ipv6Matches compares an IPv6 address with the expected address of a match field, restricted
+to the bits of the field mask if one is present
+*/
+func ipv6Matches(field *openflow_13.OfpOxmField, expected net.IP, mask []byte, actual net.IP) bool {
+	if len(expected) != net.IPv6len || actual == nil {
+		return false
+	}
+	if !field.GetOfbField().GetHasMask() || len(mask) != net.IPv6len {
+		return expected.Equal(actual)
+	}
+
+	return expected.Mask(mask).Equal(actual.Mask(mask))
+}
+
 /*
 processActions applies transformation instructions to a frame that met all the flow criteria
 */
@@ -584,228 +1276,270 @@ func (o *PonSimDevice) processActions(
 	ctx context.Context,
 	flow *openflow_13.OfpFlowStats,
 	frame gopacket.Packet,
-) (uint32, gopacket.Packet) {
+) []ponSimOutput {
 	var egressPort uint32
 	var retFrame gopacket.Packet = frame
+	var outputs []ponSimOutput
 
-	common.Logger().WithFields(logrus.Fields{
-		"device": o,
-		"flow":   flow,
-		"frame":  retFrame,
-	}).Info("Processing actions")
+	if entry := forwardingLogger.ForFrame(logrus.InfoLevel); entry != nil {
+		entry.WithFields(logrus.Fields{
+			"device": o,
+			"flow":   flow,
+			"frame":  retFrame,
+		}).Info("Processing actions")
+	}
 
 	for _, instruction := range flow.Instructions {
-		common.Logger().WithFields(logrus.Fields{
-			"device":      o,
-			"flow":        flow,
-			"frame":       retFrame,
-			"instruction": instruction,
-		}).Debug("Processing actions - Instruction entry")
+		if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+			entry.WithFields(logrus.Fields{
+				"device":      o,
+				"flow":        flow,
+				"frame":       retFrame,
+				"instruction": instruction,
+			}).Debug("Processing actions - Instruction entry")
+		}
 		if instruction.Type == uint32(openflow_13.OfpInstructionType_OFPIT_APPLY_ACTIONS) {
-			for _, action := range instruction.GetActions().GetActions() {
-				common.Logger().WithFields(logrus.Fields{
-					"device":     o,
-					"flow":       flow,
-					"frame":      retFrame,
-					"action":     action,
-					"actionType": action.Type,
-				}).Debug("Processing actions - Action entry")
-
-				switch action.Type {
-				case openflow_13.OfpActionType_OFPAT_OUTPUT:
-					common.Logger().WithFields(logrus.Fields{
-						"device": o,
-						"flow":   flow,
-						"frame":  retFrame,
-					}).Debug("Processing action OFPAT output")
-					egressPort = action.GetOutput().Port
+			var groupOutputs []ponSimOutput
+			egressPort, retFrame, groupOutputs = o.applyActions(
+				ctx,
+				flow,
+				instruction.GetActions().GetActions(),
+				egressPort,
+				retFrame,
+			)
+			outputs = append(outputs, groupOutputs...)
+		}
+	}
 
-				case openflow_13.OfpActionType_OFPAT_POP_VLAN:
-					common.Logger().WithFields(logrus.Fields{
-						"device": o,
-						"flow":   flow,
-						"frame":  retFrame,
-					}).Debug("Processing action OFPAT POP VLAN")
-					if shim := common.GetDot1QLayer(retFrame); shim != nil {
-						if eth := common.GetEthernetLayer(retFrame); eth != nil {
-							ethernetLayer := &layers.Ethernet{
-								SrcMAC:       eth.SrcMAC,
-								DstMAC:       eth.DstMAC,
-								EthernetType: shim.Type,
-							}
-							buffer := gopacket.NewSerializeBuffer()
-							gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{},
-								ethernetLayer,
-								gopacket.Payload(shim.Payload),
-							)
-							retFrame = gopacket.NewPacket(
-								buffer.Bytes(),
-								layers.LayerTypeEthernet,
-								gopacket.Default,
-							)
-						} else {
-							common.Logger().WithFields(logrus.Fields{
-								"device": o,
-								"flow":   flow,
-								"frame":  retFrame,
-							}).Warn("No ETH found while processing POP VLAN action")
+	forwardingLogger.WithFields(logrus.Fields{
+		"device":     o,
+		"flow":       flow,
+		"egressPort": egressPort,
+		"retFrame":   retFrame,
+		"outputs":    len(outputs),
+	}).Debug("Processed actions")
+
+	// Frames only sent to groups are not output on their own
+	if egressPort != 0 || len(outputs) == 0 {
+		outputs = append([]ponSimOutput{{Port: egressPort, Frame: retFrame, Flow: flow}}, outputs...)
+	}
+
+	return outputs
+}
+
+/*
+applyActions applies a list of actions to a frame and returns the resulting frame, the port
+selected by the output actions and the frames output by groups
+*/
+func (o *PonSimDevice) applyActions(
+	ctx context.Context,
+	flow *openflow_13.OfpFlowStats,
+	actions []*openflow_13.OfpAction,
+	egressPort uint32,
+	retFrame gopacket.Packet,
+) (uint32, gopacket.Packet, []ponSimOutput) {
+	var outputs []ponSimOutput
+
+	for _, action := range actions {
+		if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+			entry.WithFields(logrus.Fields{
+				"device":     o,
+				"flow":       flow,
+				"frame":      retFrame,
+				"action":     action,
+				"actionType": action.Type,
+			}).Debug("Processing actions - Action entry")
+		}
+
+		switch action.Type {
+		case openflow_13.OfpActionType_OFPAT_OUTPUT:
+			if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+				entry.WithFields(logrus.Fields{
+					"device": o,
+					"flow":   flow,
+					"frame":  retFrame,
+				}).Debug("Processing action OFPAT output")
+			}
+			egressPort = action.GetOutput().Port
+
+		case openflow_13.OfpActionType_OFPAT_POP_VLAN:
+			if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+				entry.WithFields(logrus.Fields{
+					"device": o,
+					"flow":   flow,
+					"frame":  retFrame,
+				}).Debug("Processing action OFPAT POP VLAN")
+			}
+			if popped := popVlanTag(retFrame); popped != nil {
+				retFrame = popped
+			} else {
+				forwardingLogger.WithFields(logrus.Fields{
+					"device": o,
+					"flow":   flow,
+					"frame":  retFrame,
+				}).Warn("No DOT1Q found while processing POP VLAN action")
+			}
+		case openflow_13.OfpActionType_OFPAT_PUSH_VLAN:
+			if pushed := pushVlanTag(retFrame, uint16(action.GetPush().GetEthertype())); pushed != nil {
+				retFrame = pushed
+			} else {
+				forwardingLogger.WithFields(logrus.Fields{
+					"device":    o,
+					"flow":      flow,
+					"frame":     retFrame,
+					"ethertype": action.GetPush().GetEthertype(),
+				}).Warn("Invalid ethertype or no ETH found while processing PUSH VLAN action")
+			}
+		case openflow_13.OfpActionType_OFPAT_SET_FIELD:
+			if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+				entry.WithFields(logrus.Fields{
+					"device": o,
+					"flow":   flow,
+					"frame":  retFrame,
+				}).Debug("Processing action OFPAT SET FIELD")
+			}
+			if action.GetSetField().GetField().GetOxmClass() ==
+				openflow_13.OfpOxmClass_OFPXMC_OPENFLOW_BASIC {
+				field := action.GetSetField().GetField().GetOfbField()
+
+				switch field.Type {
+				case openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_VLAN_VID:
+					if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+						entry.WithFields(logrus.Fields{
+							"device": o,
+							"flow":   flow,
+							"frame":  retFrame,
+						}).Debug("Processing action OFPAT SET FIELD - VLAN VID")
+					}
+					if rewritten := setVlanVid(retFrame, uint16(field.GetVlanVid())); rewritten != nil {
+						retFrame = rewritten
+						if entry := forwardingLogger.ForFrame(logrus.InfoLevel); entry != nil {
+							entry.WithFields(logrus.Fields{
+								"device":  o,
+								"flow":    flow,
+								"frame":   retFrame,
+								"vlanVid": field.GetVlanVid() & VLAN_VID_MASK,
+							}).Info("Setting DOT1Q VLAN VID")
 						}
 					} else {
-						common.Logger().WithFields(logrus.Fields{
+						forwardingLogger.WithFields(logrus.Fields{
 							"device": o,
 							"flow":   flow,
 							"frame":  retFrame,
-						}).Warn("No DOT1Q found while processing POP VLAN action")
+						}).Warn("No DOT1Q found while setting VLAN VID")
 					}
-				case openflow_13.OfpActionType_OFPAT_PUSH_VLAN:
-					if eth := common.GetEthernetLayer(retFrame); eth != nil {
-						ethernetLayer := &layers.Ethernet{
-							SrcMAC:       eth.SrcMAC,
-							DstMAC:       eth.DstMAC,
-							EthernetType: layers.EthernetType(action.GetPush().GetEthertype()),
-						}
-						dot1qLayer := &layers.Dot1Q{
-							Type: eth.EthernetType,
-						}
 
-						buffer := gopacket.NewSerializeBuffer()
-						gopacket.SerializeLayers(
-							buffer,
-							gopacket.SerializeOptions{
-								FixLengths: false,
-							},
-							ethernetLayer,
-							dot1qLayer,
-							gopacket.Payload(eth.Payload),
-						)
-						retFrame = gopacket.NewPacket(
-							buffer.Bytes(),
-							layers.LayerTypeEthernet,
-							gopacket.Default,
-						)
+				case openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_VLAN_PCP:
+					if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+						entry.WithFields(logrus.Fields{
+							"device": o,
+							"flow":   flow,
+							"frame":  retFrame,
+						}).Debug("Processing action OFPAT SET FIELD - VLAN PCP")
+					}
+					if rewritten := setVlanPcp(retFrame, uint8(field.GetVlanPcp())); rewritten != nil {
+						retFrame = rewritten
+						if entry := forwardingLogger.ForFrame(logrus.InfoLevel); entry != nil {
+							entry.WithFields(logrus.Fields{
+								"device":   o,
+								"flow":     flow,
+								"frame":    retFrame,
+								"priority": field.GetVlanPcp(),
+							}).Info("Setting DOT1Q VLAN PCP")
+						}
 					} else {
-						common.Logger().WithFields(logrus.Fields{
+						forwardingLogger.WithFields(logrus.Fields{
 							"device": o,
 							"flow":   flow,
 							"frame":  retFrame,
-						}).Warn("No ETH found while processing PUSH VLAN action")
+						}).Warn("No DOT1Q found while setting VLAN PCP")
 					}
-				case openflow_13.OfpActionType_OFPAT_SET_FIELD:
-					common.Logger().WithFields(logrus.Fields{
-						"device": o,
-						"flow":   flow,
-						"frame":  retFrame,
-					}).Debug("Processing action OFPAT SET FIELD")
-					if action.GetSetField().GetField().GetOxmClass() ==
-						openflow_13.OfpOxmClass_OFPXMC_OPENFLOW_BASIC {
-						field := action.GetSetField().GetField().GetOfbField()
-
-						switch field.Type {
-						case openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_VLAN_VID:
-							common.Logger().WithFields(logrus.Fields{
-								"device": o,
-								"flow":   flow,
-								"frame":  retFrame,
-							}).Debug("Processing action OFPAT SET FIELD - VLAN VID")
-							if shim := common.GetDot1QLayer(retFrame); shim != nil {
-								eth := common.GetEthernetLayer(retFrame)
-								buffer := gopacket.NewSerializeBuffer()
-
-								var dot1qLayer *layers.Dot1Q
-								var ethernetLayer *layers.Ethernet
-								ethernetLayer = &layers.Ethernet{
-									SrcMAC:       eth.SrcMAC,
-									DstMAC:       eth.DstMAC,
-									EthernetType: eth.EthernetType,
-								}
-
-								dot1qLayer = &layers.Dot1Q{
-									Type:           shim.Type,
-									VLANIdentifier: uint16(field.GetVlanVid() & 4095),
-								}
-
-								gopacket.SerializeLayers(
-									buffer,
-									gopacket.SerializeOptions{},
-									ethernetLayer,
-									dot1qLayer,
-									gopacket.Payload(shim.LayerPayload()),
-								)
-								retFrame = gopacket.NewPacket(
-									buffer.Bytes(),
-									layers.LayerTypeEthernet,
-									gopacket.Default,
-								)
-
-								common.Logger().WithFields(logrus.Fields{
-									"device":    o,
-									"flow":      flow,
-									"frame":     retFrame,
-									"frameDump": retFrame.Dump(),
-									"vlanVid":   shim.VLANIdentifier,
-								}).Info("Setting DOT1Q VLAN VID")
-							} else {
-								common.Logger().WithFields(logrus.Fields{
-									"device": o,
-									"flow":   flow,
-									"frame":  retFrame,
-								}).Warn("No DOT1Q found while setting VLAN VID")
-							}
-
-						case openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_VLAN_PCP:
-							common.Logger().WithFields(logrus.Fields{
+
+				case openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_IP_DSCP:
+					if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+						entry.WithFields(logrus.Fields{
+							"device": o,
+							"flow":   flow,
+							"frame":  retFrame,
+						}).Debug("Processing action OFPAT SET FIELD - IP DSCP")
+					}
+					if rewritten := setIpDscp(retFrame, uint8(field.GetIpDscp())); rewritten != nil {
+						retFrame = rewritten
+						if entry := forwardingLogger.ForFrame(logrus.InfoLevel); entry != nil {
+							entry.WithFields(logrus.Fields{
 								"device": o,
 								"flow":   flow,
 								"frame":  retFrame,
-							}).Debug("Processing action OFPAT SET FIELD - VLAN PCP")
-							if shim := common.GetDot1QLayer(retFrame); shim != nil {
-								shim.Priority = uint8(field.GetVlanPcp())
-								common.Logger().WithFields(logrus.Fields{
-									"device":   o,
-									"flow":     flow,
-									"frame":    retFrame,
-									"priority": shim.Priority,
-								}).Info("Setting DOT1Q VLAN PCP")
-							} else {
-								common.Logger().WithFields(logrus.Fields{
-									"device": o,
-									"flow":   flow,
-									"frame":  retFrame,
-								}).Warn("No DOT1Q found while setting VLAN PCP")
-							}
-						default:
-							common.Logger().WithFields(logrus.Fields{
+								"dscp":   field.GetIpDscp(),
+							}).Info("Setting IP DSCP")
+						}
+					} else {
+						forwardingLogger.WithFields(logrus.Fields{
+							"device": o,
+							"flow":   flow,
+							"frame":  retFrame,
+						}).Warn("No IP header found while setting IP DSCP")
+					}
+
+				case openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_IP_ECN:
+					if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+						entry.WithFields(logrus.Fields{
+							"device": o,
+							"flow":   flow,
+							"frame":  retFrame,
+						}).Debug("Processing action OFPAT SET FIELD - IP ECN")
+					}
+					if rewritten := setIpEcn(retFrame, uint8(field.GetIpEcn())); rewritten != nil {
+						retFrame = rewritten
+						if entry := forwardingLogger.ForFrame(logrus.InfoLevel); entry != nil {
+							entry.WithFields(logrus.Fields{
 								"device": o,
 								"flow":   flow,
 								"frame":  retFrame,
-								"type":   field.Type,
-							}).Warn("Set field not implemented for this type")
+								"ecn":    field.GetIpEcn(),
+							}).Info("Setting IP ECN")
 						}
 					} else {
-						common.Logger().WithFields(logrus.Fields{
+						forwardingLogger.WithFields(logrus.Fields{
 							"device": o,
 							"flow":   flow,
 							"frame":  retFrame,
-						}).Warn("Field not of type OF-BASIC")
+						}).Warn("No IP header found while setting IP ECN")
 					}
 				default:
-					common.Logger().WithFields(logrus.Fields{
+					forwardingLogger.WithFields(logrus.Fields{
 						"device": o,
 						"flow":   flow,
 						"frame":  retFrame,
-						"type":   action.Type,
-					}).Warn("Action type not implemented")
+						"type":   field.Type,
+					}).Warn("Set field not implemented for this type")
 				}
+			} else {
+				forwardingLogger.WithFields(logrus.Fields{
+					"device": o,
+					"flow":   flow,
+					"frame":  retFrame,
+				}).Warn("Field not of type OF-BASIC")
 			}
+		case openflow_13.OfpActionType_OFPAT_GROUP:
+			if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+				entry.WithFields(logrus.Fields{
+					"device": o,
+					"flow":   flow,
+					"frame":  retFrame,
+					"group":  action.GetGroup().GroupId,
+				}).Debug("Processing action OFPAT GROUP")
+			}
+			outputs = append(outputs, o.processGroup(ctx, flow, action.GetGroup().GroupId, retFrame)...)
+		default:
+			forwardingLogger.WithFields(logrus.Fields{
+				"device": o,
+				"flow":   flow,
+				"frame":  retFrame,
+				"type":   action.Type,
+			}).Warn("Action type not implemented")
 		}
 	}
 
-	common.Logger().WithFields(logrus.Fields{
-		"device":     o,
-		"flow":       flow,
-		"egressPort": egressPort,
-		"retFrame":   retFrame,
-	}).Debug("Processed actions")
-
-	return egressPort, retFrame
+	return egressPort, retFrame, outputs
 }
@@ -20,11 +20,13 @@ type PonSimDeviceType uint8
 const (
 	OLT PonSimDeviceType = iota
 	ONU
+	DUAL
 )
 
 var enum_ponsim_device_types = []string{
 	"OLT",
 	"ONU",
+	"DUAL",
 }
 
 func (t PonSimDeviceType) String() string {
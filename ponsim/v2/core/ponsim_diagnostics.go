@@ -0,0 +1,136 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+const (
+	// Typical readings of the digital diagnostic monitoring (DDM) of a PON transceiver
+	DEFAULT_DDM_TEMPERATURE  = 45.0 // Celsius
+	DEFAULT_DDM_VOLTAGE      = 3.3  // Volts
+	DEFAULT_DDM_BIAS_CURRENT = 20.0 // Milliamperes
+
+	// Standard deviation of the readings, relative to their baseline
+	DEFAULT_DDM_NOISE = 0.01
+	MAX_DDM_NOISE     = 0.5
+)
+
+/*
+PonSimDdmValues are the environmental readings of a transceiver
+*/
+type PonSimDdmValues struct {
+	Temperature float64 `json:"temperature"`
+	Voltage     float64 `json:"voltage"`
+	BiasCurrent float64 `json:"bias_current"`
+}
+
+/*
+PonSimDiagnostics simulates the digital diagnostic monitoring of the transceiver of a device:
+every reading is drawn around its baseline with a normally distributed noise
+*/
+type PonSimDiagnostics struct {
+	Baseline PonSimDdmValues `json:"baseline"`
+	Noise    float64         `json:"noise"`
+
+	mutex sync.Mutex
+}
+
+/*
+NewPonSimDiagnostics instantiates the DDM of a transceiver with its baseline readings
+*/
+func NewPonSimDiagnostics(baseline PonSimDdmValues, noise float64) (*PonSimDiagnostics, error) {
+	diagnostics := &PonSimDiagnostics{}
+	if err := diagnostics.Set(baseline, noise); err != nil {
+		return nil, err
+	}
+
+	return diagnostics, nil
+}
+
+/*
+Set changes the baseline readings and the noise of the DDM
+*/
+func (d *PonSimDiagnostics) Set(baseline PonSimDdmValues, noise float64) error {
+	if baseline.Voltage < 0 {
+		return fmt.Errorf("invalid voltage: %g", baseline.Voltage)
+	}
+	if baseline.BiasCurrent < 0 {
+		return fmt.Errorf("invalid bias current: %g", baseline.BiasCurrent)
+	}
+	if noise < 0 || noise > MAX_DDM_NOISE {
+		return fmt.Errorf("invalid noise: %g", noise)
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.Baseline = baseline
+	d.Noise = noise
+
+	return nil
+}
+
+/*
+Copy returns a new DDM with the same baseline and noise, for another device
+*/
+func (d *PonSimDiagnostics) Copy() *PonSimDiagnostics {
+	if d == nil {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return &PonSimDiagnostics{Baseline: d.Baseline, Noise: d.Noise}
+}
+
+/*
+Read draws the current readings of the transceiver
+*/
+func (d *PonSimDiagnostics) Read() PonSimDdmValues {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	read := func(baseline float64) float64 {
+		return baseline + rand.NormFloat64()*d.Noise*math.Abs(baseline)
+	}
+
+	// Unlike the temperature, the voltage and the current never turn negative
+	return PonSimDdmValues{
+		Temperature: read(d.Baseline.Temperature),
+		Voltage:     math.Max(read(d.Baseline.Voltage), 0),
+		BiasCurrent: math.Max(read(d.Baseline.BiasCurrent), 0),
+	}
+}
+
+/*
+MakeProto returns the current readings of the transceiver as a GRPC message
+*/
+func (d *PonSimDiagnostics) MakeProto() *voltha.PonSimDiagnostics {
+	values := d.Read()
+
+	return &voltha.PonSimDiagnostics{
+		TemperatureC:  values.Temperature,
+		VoltageV:      values.Voltage,
+		BiasCurrentMa: values.BiasCurrent,
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+/*
+PonSimEchoResponder answers the ICMP echo requests received on the UNI of an ONU for the
+addresses of the subscriber hosts it simulates, losing and delaying the replies as configured
+*/
+type PonSimEchoResponder struct {
+	mutex   sync.Mutex
+	loss    float32
+	latency time.Duration
+	replies uint64
+	lost    uint64
+}
+
+/*
+Set changes the percentage of replies lost and the latency of the others
+*/
+func (r *PonSimEchoResponder) Set(loss float32, latency time.Duration) error {
+	if loss < 0 || loss > 100 {
+		return fmt.Errorf("invalid loss percentage: %g", loss)
+	}
+	if latency < 0 {
+		return fmt.Errorf("invalid latency: %s", latency)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.loss, r.latency = loss, latency
+
+	return nil
+}
+
+/*
+Get returns the percentage of replies lost, the latency of the others and the number of
+replies sent and lost so far
+*/
+func (r *PonSimEchoResponder) Get() (float32, time.Duration, uint64, uint64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.loss, r.latency, r.replies, r.lost
+}
+
+/*
+HandleFrame answers an ICMP echo request for an address bound by the ARP responder, replying
+through the inject function with the VLAN tags of the request, and reports whether the frame
+was consumed
+*/
+func (r *PonSimEchoResponder) HandleFrame(
+	frame gopacket.Packet,
+	bindings *PonSimArpResponder,
+	inject func(gopacket.Packet),
+) bool {
+	icmpLayer := frame.Layer(layers.LayerTypeICMPv4)
+	ipLayer := frame.Layer(layers.LayerTypeIPv4)
+	if icmpLayer == nil || ipLayer == nil {
+		return false
+	}
+	icmp := icmpLayer.(*layers.ICMPv4)
+	ip := ipLayer.(*layers.IPv4)
+	if icmp.TypeCode.Type() != layers.ICMPv4TypeEchoRequest {
+		return false
+	}
+
+	// Like a host, the subscriber only answers the requests sent to its hardware address
+	binding := bindings.Lookup(ip.DstIP)
+	if binding == nil || !bytes.Equal(common.GetEthernetLayer(frame).DstMAC, binding.Mac) {
+		return false
+	}
+
+	r.mutex.Lock()
+	lost := r.loss > 0 && rand.Float32()*100 < r.loss
+	if lost {
+		r.lost++
+	} else {
+		r.replies++
+	}
+	latency := r.latency
+	r.mutex.Unlock()
+
+	if lost {
+		return true
+	}
+
+	reply := &layers.IPv4{
+		Version:  ipVersion,
+		TTL:      ttl,
+		Protocol: layers.IPProtocolICMPv4,
+		SrcIP:    binding.Ip,
+		DstIP:    ip.SrcIP,
+	}
+	echo := &layers.ICMPv4{
+		TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoReply, 0),
+		Id:       icmp.Id,
+		Seq:      icmp.Seq,
+	}
+
+	frameLayers := newReplyLayers(frame, binding.Mac, common.GetEthernetLayer(frame).SrcMAC, layers.EthernetTypeIPv4)
+	frameLayers = append(frameLayers, reply, echo, gopacket.Payload(icmp.Payload))
+
+	replyFrame, err := common.SerializeFrame(
+		gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		frameLayers...,
+	)
+	if err != nil {
+		common.Logger().WithFields(logrus.Fields{
+			"ip":    binding.Ip,
+			"error": err.Error(),
+		}).Error("Problem serializing ICMP echo reply")
+		return true
+	}
+
+	if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+		entry.WithFields(logrus.Fields{
+			"ip":      binding.Ip,
+			"sender":  ip.SrcIP,
+			"seq":     icmp.Seq,
+			"latency": latency,
+		}).Debug("Answering ICMP echo request")
+	}
+
+	if latency > 0 {
+		time.AfterFunc(latency, func() { inject(replyFrame) })
+	} else {
+		go inject(replyFrame)
+	}
+
+	return true
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	EVENT_QUEUE_DEPTH = 128
+)
+
+/*
+PonSimEventBus distributes the events raised by a device to all of its subscribers
+*/
+type PonSimEventBus struct {
+	mutex       sync.RWMutex
+	nextId      int
+	subscribers map[int]chan *voltha.PonSimEvent
+}
+
+/*
+NewPonSimEventBus instantiates an event bus without any subscribers
+*/
+func NewPonSimEventBus() *PonSimEventBus {
+	return &PonSimEventBus{subscribers: make(map[int]chan *voltha.PonSimEvent)}
+}
+
+/*
+Subscribe registers a new subscriber and returns its identifier along with the channel
+on which events are delivered
+*/
+func (b *PonSimEventBus) Subscribe() (int, <-chan *voltha.PonSimEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.nextId += 1
+	events := make(chan *voltha.PonSimEvent, EVENT_QUEUE_DEPTH)
+	b.subscribers[b.nextId] = events
+
+	return b.nextId, events
+}
+
+/*
+Unsubscribe removes a subscriber and closes its channel
+*/
+func (b *PonSimEventBus) Unsubscribe(id int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if events, ok := b.subscribers[id]; ok {
+		delete(b.subscribers, id)
+		close(events)
+	}
+}
+
+/*
+Publish delivers an event to all subscribers.  Events are dropped for subscribers
+which are not keeping up.
+*/
+func (b *PonSimEventBus) Publish(event *voltha.PonSimEvent) {
+	if b == nil {
+		return
+	}
+
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().UnixNano()
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for id, events := range b.subscribers {
+		select {
+		case events <- event:
+		default:
+			common.Logger().WithFields(logrus.Fields{
+				"subscriber": id,
+				"event":      event,
+			}).Warn("Dropping event for slow subscriber")
+		}
+	}
+}
+
+/*
+newPortStatusEvent creates an event reporting the operational status of a port
+*/
+func newPortStatusEvent(device string, port int, up bool) *voltha.PonSimEvent {
+	return &voltha.PonSimEvent{
+		Device: device,
+		Event: &voltha.PonSimEvent_PortStatus{
+			PortStatus: &voltha.PonSimPortStatus{Port: int32(port), Up: up},
+		},
+	}
+}
+
+/*
+newClockSyncEvent creates an event reporting that the time of a device was resynchronized
+*/
+func newClockSyncEvent(device string, correction time.Duration) *voltha.PonSimEvent {
+	return &voltha.PonSimEvent{
+		Device: device,
+		Event: &voltha.PonSimEvent_ClockSync{
+			ClockSync: &voltha.PonSimClockSync{CorrectionNs: int64(correction)},
+		},
+	}
+}
+
+/*
+newRebootEvent creates an event reporting that a device is rebooting
+*/
+func newRebootEvent(device string, bootDelay time.Duration) *voltha.PonSimEvent {
+	return &voltha.PonSimEvent{
+		Device: device,
+		Event: &voltha.PonSimEvent_Reboot{
+			Reboot: &voltha.PonSimDeviceReboot{BootDelayMs: uint32(bootDelay / time.Millisecond)},
+		},
+	}
+}
+
+/*
+publishEvent stamps an event with the time of the device and publishes it on the event bus
+*/
+func (o *PonSimDevice) publishEvent(event *voltha.PonSimEvent) {
+	event.Timestamp = o.Clock.Now().UnixNano()
+	o.Events.Publish(event)
+}
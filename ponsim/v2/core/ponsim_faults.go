@@ -0,0 +1,154 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Corruption is limited to the bytes following the ethernet header so frames remain routable
+const ethernetHeaderSize = 14
+
+/*
+PonSimFault describes the percentage of frames received on a port which are dropped or corrupted
+*/
+type PonSimFault struct {
+	DropPercent    float64 `json:"drop_percent"`
+	CorruptPercent float64 `json:"corrupt_percent"`
+}
+
+/*
+PonSimPortFaults holds the faults injected per port
+*/
+type PonSimPortFaults struct {
+	mutex  sync.RWMutex
+	faults map[int]PonSimFault
+}
+
+/*
+NewPonSimPortFaults instantiates an empty fault configuration
+*/
+func NewPonSimPortFaults() *PonSimPortFaults {
+	return &PonSimPortFaults{faults: make(map[int]PonSimFault)}
+}
+
+/*
+Set configures the faults injected on a port; a fault without any percentage removes it
+*/
+func (p *PonSimPortFaults) Set(port int, fault PonSimFault) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if fault.DropPercent <= 0 && fault.CorruptPercent <= 0 {
+		delete(p.faults, port)
+	} else {
+		p.faults[port] = fault
+	}
+}
+
+/*
+Get returns the faults injected on a port
+*/
+func (p *PonSimPortFaults) Get(port int) (PonSimFault, bool) {
+	if p == nil {
+		return PonSimFault{}, false
+	}
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	fault, ok := p.faults[port]
+	return fault, ok
+}
+
+/*
+Clear removes the faults injected on every port
+*/
+func (p *PonSimPortFaults) Clear() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.faults = make(map[int]PonSimFault)
+}
+
+/*
+Apply decides the fate of a frame received on a port.  It returns the frame to process,
+which is nil when the frame is dropped, and whether the frame was corrupted.
+*/
+func (p *PonSimPortFaults) Apply(port int, frame gopacket.Packet) (gopacket.Packet, bool) {
+	fault, ok := p.Get(port)
+	if !ok {
+		return frame, false
+	}
+
+	if rand.Float64()*100 < fault.DropPercent {
+		return nil, false
+	}
+
+	if rand.Float64()*100 < fault.CorruptPercent {
+		data := append([]byte(nil), frame.Data()...)
+		if len(data) > ethernetHeaderSize {
+			bit := rand.Intn((len(data) - ethernetHeaderSize) * 8)
+			data[ethernetHeaderSize+bit/8] ^= 1 << uint(bit%8)
+
+			return common.NewFrame(data), true
+		}
+	}
+
+	return frame, false
+}
+
+/*
+ParsePortFaults parses a comma separated list of port faults in the format
+port:drop_percent:corrupt_percent, e.g. 1:0.5:0.1
+*/
+func ParsePortFaults(spec string) (*PonSimPortFaults, error) {
+	faults := NewPonSimPortFaults()
+
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		fields := strings.Split(entry, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid fault specification: %s", entry)
+		}
+
+		port, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid fault port: %s", fields[0])
+		}
+
+		fault := PonSimFault{}
+		if fault.DropPercent, err = strconv.ParseFloat(fields[1], 64); err != nil {
+			return nil, fmt.Errorf("invalid drop percentage: %s", fields[1])
+		}
+		if fault.CorruptPercent, err = strconv.ParseFloat(fields[2], 64); err != nil {
+			return nil, fmt.Errorf("invalid corruption percentage: %s", fields[2])
+		}
+
+		faults.Set(port, fault)
+	}
+
+	return faults, nil
+}
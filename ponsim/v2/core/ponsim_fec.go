@@ -0,0 +1,234 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+const (
+	// Frames are protected by RS(255,239) codewords, each correcting up to 8 erroneous bytes
+	FEC_CODEWORD_SIZE       = 255
+	FEC_CODEWORD_PAYLOAD    = 239
+	FEC_CORRECTABLE_SYMBOLS = 8
+
+	FEC_UPSTREAM   = 0
+	FEC_DOWNSTREAM = 1
+
+	MAX_FEC_BIT_ERROR_RATE = 0.5
+
+	// Error rates are reported as GRPC metrics in errors per billion
+	FEC_RATE_SCALE = 1e9
+)
+
+/*
+PonSimFecCounters counts the codewords and frames received in one direction of the PON
+*/
+type PonSimFecCounters struct {
+	Codewords              int64 `json:"codewords"`
+	CorrectedCodewords     int64 `json:"corrected_codewords"`
+	UncorrectableCodewords int64 `json:"uncorrectable_codewords"`
+	Frames                 int64 `json:"frames"`
+	ErroredFrames          int64 `json:"errored_frames"`
+}
+
+/*
+ResidualErrorRate returns the share of the frames lost to bit errors
+*/
+func (c PonSimFecCounters) ResidualErrorRate() float64 {
+	if c.Frames == 0 {
+		return 0
+	}
+	return float64(c.ErroredFrames) / float64(c.Frames)
+}
+
+/*
+PonSimFec simulates the bit errors of the optical link of an ONU and their correction by the
+forward error correction.  Without FEC a frame hit by a single bit error is lost, while with
+FEC a frame is only lost when one of its codewords has more erroneous bytes than can be
+corrected.
+*/
+type PonSimFec struct {
+	Enabled      bool                 `json:"enabled"`
+	BitErrorRate float64              `json:"bit_error_rate"` // Before correction
+	Counters     [2]PonSimFecCounters `json:"counters"`       // [UPSTREAM,DOWNSTREAM]
+
+	mutex sync.Mutex
+
+	// Probabilities that a codeword has errors and that they cannot be corrected
+	errored       float64
+	uncorrectable float64
+}
+
+/*
+NewPonSimFec instantiates the error model of an optical link with a pre-FEC bit error rate
+*/
+func NewPonSimFec(enabled bool, bitErrorRate float64) (*PonSimFec, error) {
+	fec := &PonSimFec{}
+	if err := fec.Set(enabled, bitErrorRate); err != nil {
+		return nil, err
+	}
+
+	return fec, nil
+}
+
+/*
+Set enables or disables the FEC and changes the bit error rate of the link, the counters
+being kept
+*/
+func (f *PonSimFec) Set(enabled bool, bitErrorRate float64) error {
+	if bitErrorRate < 0 || bitErrorRate > MAX_FEC_BIT_ERROR_RATE {
+		return fmt.Errorf("invalid bit error rate: %g", bitErrorRate)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.Enabled = enabled
+	f.BitErrorRate = bitErrorRate
+
+	// A byte is erroneous when any of its bits is, the number of erroneous bytes of a
+	// codeword following a binomial distribution
+	symbolErrorRate := 1 - math.Pow(1-bitErrorRate, 8)
+	f.errored = 1 - math.Pow(1-symbolErrorRate, FEC_CODEWORD_SIZE)
+
+	correctable := 0.0
+	if symbolErrorRate < 1 {
+		term := math.Pow(1-symbolErrorRate, FEC_CODEWORD_SIZE)
+		for k := 0; k <= FEC_CORRECTABLE_SYMBOLS; k++ {
+			correctable += term
+			term *= float64(FEC_CODEWORD_SIZE-k) / float64(k+1) * symbolErrorRate / (1 - symbolErrorRate)
+		}
+	}
+	if f.uncorrectable = 1 - correctable; f.uncorrectable < 0 {
+		f.uncorrectable = 0
+	}
+
+	return nil
+}
+
+/*
+Receive draws the bit errors hitting a frame of the specified size in one direction and
+reports whether the frame is received intact
+*/
+func (f *PonSimFec) Receive(direction int, size int) bool {
+	if f == nil {
+		return true
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	counters := &f.Counters[direction]
+	counters.Frames++
+
+	intact := true
+	if f.Enabled {
+		codewords := (size + FEC_CODEWORD_PAYLOAD - 1) / FEC_CODEWORD_PAYLOAD
+		for i := 0; i < codewords; i++ {
+			counters.Codewords++
+			if draw := rand.Float64(); draw < f.uncorrectable {
+				counters.UncorrectableCodewords++
+				intact = false
+			} else if draw < f.errored {
+				counters.CorrectedCodewords++
+			}
+		}
+	} else if f.BitErrorRate > 0 {
+		intact = rand.Float64() >= 1-math.Pow(1-f.BitErrorRate, float64(size*8))
+	}
+
+	if !intact {
+		counters.ErroredFrames++
+	}
+
+	return intact
+}
+
+/*
+GetCounters returns a snapshot of the counters of a direction
+*/
+func (f *PonSimFec) GetCounters(direction int) PonSimFecCounters {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.Counters[direction]
+}
+
+/*
+MakeProto returns the optical layer counters of the link of an ONU, named after its port,
+e.g. fec128.  The error rates are reported in errors per billion.
+*/
+func (f *PonSimFec) MakeProto(onuPort int32) *voltha.PonSimPortMetrics {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	enabled := int64(0)
+	if f.Enabled {
+		enabled = 1
+	}
+
+	metrics := &voltha.PonSimPortMetrics{
+		PortName: fmt.Sprintf("fec%d", onuPort),
+		Packets: []*voltha.PonSimPacketCounter{
+			{Name: "fec_enabled", Value: enabled},
+			{Name: "pre_fec_ber", Value: int64(f.BitErrorRate*FEC_RATE_SCALE + 0.5)},
+		},
+	}
+	for i, prefix := range []string{"us", "ds"} {
+		counters := f.Counters[i]
+		metrics.Packets = append(
+			metrics.Packets,
+			&voltha.PonSimPacketCounter{Name: prefix + "_codewords", Value: counters.Codewords},
+			&voltha.PonSimPacketCounter{Name: prefix + "_corrected_codewords", Value: counters.CorrectedCodewords},
+			&voltha.PonSimPacketCounter{Name: prefix + "_uncorrectable_codewords", Value: counters.UncorrectableCodewords},
+			&voltha.PonSimPacketCounter{Name: prefix + "_errored_pkts", Value: counters.ErroredFrames},
+			&voltha.PonSimPacketCounter{Name: prefix + "_residual_error_rate", Value: int64(counters.ResidualErrorRate()*FEC_RATE_SCALE + 0.5)},
+		)
+	}
+
+	return metrics
+}
+
+/*
+SetOnuFec configures the forward error correction and the bit error rate of the optical link
+of a registered ONU
+*/
+func (o *PonSimOltDevice) SetOnuFec(onuPort int32, enabled bool, bitErrorRate float64) (*PonSimFec, error) {
+	onu := o.GetOnu(onuPort)
+	if onu == nil || onu.Fec == nil {
+		return nil, fmt.Errorf("no ONU on port %d", onuPort)
+	}
+
+	if err := onu.Fec.Set(enabled, bitErrorRate); err != nil {
+		return nil, err
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"device":       o,
+		"port":         onuPort,
+		"enabled":      enabled,
+		"bitErrorRate": bitErrorRate,
+	}).Info("Configured ONU FEC")
+
+	return onu.Fec, nil
+}
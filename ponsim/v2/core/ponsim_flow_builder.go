@@ -0,0 +1,149 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"github.com/opencord/voltha/protos/go/openflow_13"
+	"net"
+)
+
+/*
+The following helpers construct flow entries in the same format as the ones
+pushed by the PONSIM adapters.  They are used by the built-in test routines.
+*/
+
+func newFlow(
+	priority uint32,
+	fields []*openflow_13.OfpOxmOfbField,
+	actions ...*openflow_13.OfpAction,
+) *openflow_13.OfpFlowStats {
+	match := &openflow_13.OfpMatch{Type: openflow_13.OfpMatchType_OFPMT_OXM}
+	for _, field := range fields {
+		match.OxmFields = append(match.OxmFields, &openflow_13.OfpOxmField{
+			OxmClass: openflow_13.OfpOxmClass_OFPXMC_OPENFLOW_BASIC,
+			Field:    &openflow_13.OfpOxmField_OfbField{OfbField: field},
+		})
+	}
+
+	return &openflow_13.OfpFlowStats{
+		Priority: priority,
+		Match:    match,
+		Instructions: []*openflow_13.OfpInstruction{
+			{
+				Type: uint32(openflow_13.OfpInstructionType_OFPIT_APPLY_ACTIONS),
+				Data: &openflow_13.OfpInstruction_Actions{
+					Actions: &openflow_13.OfpInstructionActions{Actions: actions},
+				},
+			},
+		},
+	}
+}
+
+func matchFields(fields ...*openflow_13.OfpOxmOfbField) []*openflow_13.OfpOxmOfbField {
+	return fields
+}
+
+func matchInPort(port uint32) *openflow_13.OfpOxmOfbField {
+	return &openflow_13.OfpOxmOfbField{
+		Type:  openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_IN_PORT,
+		Value: &openflow_13.OfpOxmOfbField_Port{Port: port},
+	}
+}
+
+func matchEthType(ethType uint32) *openflow_13.OfpOxmOfbField {
+	return &openflow_13.OfpOxmOfbField{
+		Type:  openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_ETH_TYPE,
+		Value: &openflow_13.OfpOxmOfbField_EthType{EthType: ethType},
+	}
+}
+
+func matchVlanVid(vid uint32) *openflow_13.OfpOxmOfbField {
+	return &openflow_13.OfpOxmOfbField{
+		Type:  openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_VLAN_VID,
+		Value: &openflow_13.OfpOxmOfbField_VlanVid{VlanVid: vid},
+	}
+}
+
+func matchVlanPcp(pcp uint32) *openflow_13.OfpOxmOfbField {
+	return &openflow_13.OfpOxmOfbField{
+		Type:  openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_VLAN_PCP,
+		Value: &openflow_13.OfpOxmOfbField_VlanPcp{VlanPcp: pcp},
+	}
+}
+
+func matchIpProto(proto uint32) *openflow_13.OfpOxmOfbField {
+	return &openflow_13.OfpOxmOfbField{
+		Type:  openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_IP_PROTO,
+		Value: &openflow_13.OfpOxmOfbField_IpProto{IpProto: proto},
+	}
+}
+
+func matchUdpSrc(port uint32) *openflow_13.OfpOxmOfbField {
+	return &openflow_13.OfpOxmOfbField{
+		Type:  openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_UDP_SRC,
+		Value: &openflow_13.OfpOxmOfbField_UdpSrc{UdpSrc: port},
+	}
+}
+
+func matchUdpDst(port uint32) *openflow_13.OfpOxmOfbField {
+	return &openflow_13.OfpOxmOfbField{
+		Type:  openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_UDP_DST,
+		Value: &openflow_13.OfpOxmOfbField_UdpDst{UdpDst: port},
+	}
+}
+
+func matchIpv6Dst(ip net.IP, prefixLength int) *openflow_13.OfpOxmOfbField {
+	return &openflow_13.OfpOxmOfbField{
+		Type:    openflow_13.OxmOfbFieldTypes_OFPXMT_OFB_IPV6_DST,
+		HasMask: true,
+		Value:   &openflow_13.OfpOxmOfbField_Ipv6Dst{Ipv6Dst: ip.To16()},
+		Mask:    &openflow_13.OfpOxmOfbField_Ipv6DstMask{Ipv6DstMask: net.CIDRMask(prefixLength, 128)},
+	}
+}
+
+func actionOutput(port uint32) *openflow_13.OfpAction {
+	return &openflow_13.OfpAction{
+		Type:   openflow_13.OfpActionType_OFPAT_OUTPUT,
+		Action: &openflow_13.OfpAction_Output{Output: &openflow_13.OfpActionOutput{Port: port}},
+	}
+}
+
+func actionPushVlan(ethType uint32) *openflow_13.OfpAction {
+	return &openflow_13.OfpAction{
+		Type:   openflow_13.OfpActionType_OFPAT_PUSH_VLAN,
+		Action: &openflow_13.OfpAction_Push{Push: &openflow_13.OfpActionPush{Ethertype: ethType}},
+	}
+}
+
+func actionPopVlan() *openflow_13.OfpAction {
+	return &openflow_13.OfpAction{
+		Type: openflow_13.OfpActionType_OFPAT_POP_VLAN,
+	}
+}
+
+func actionSetField(field *openflow_13.OfpOxmOfbField) *openflow_13.OfpAction {
+	return &openflow_13.OfpAction{
+		Type: openflow_13.OfpActionType_OFPAT_SET_FIELD,
+		Action: &openflow_13.OfpAction_SetField{
+			SetField: &openflow_13.OfpActionSetField{
+				Field: &openflow_13.OfpOxmField{
+					OxmClass: openflow_13.OfpOxmClass_OFPXMC_OPENFLOW_BASIC,
+					Field:    &openflow_13.OfpOxmField_OfbField{OfbField: field},
+				},
+			},
+		},
+	}
+}
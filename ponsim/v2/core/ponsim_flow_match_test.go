@@ -0,0 +1,89 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"context"
+	"github.com/google/gopacket/layers"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/openflow_13"
+	"testing"
+)
+
+func tagFlow(priority uint32, vid uint32, fields ...*openflow_13.OfpOxmOfbField) *openflow_13.OfpFlowStats {
+	return newFlow(priority, fields,
+		actionPushVlan(uint32(layers.EthernetTypeDot1Q)),
+		actionSetField(matchVlanVid(4096|vid)),
+		actionOutput(2),
+	)
+}
+
+func matchedVlan(t *testing.T, flows ...*openflow_13.OfpFlowStats) int {
+	device := &PonSimDevice{Name: "test"}
+	device.InstallFlows(context.Background(), flows)
+
+	outputs := device.processFrame(context.Background(), 1, newDhcpFrame(conformanceUntagged))
+	if len(outputs) == 0 {
+		t.Fatal("The frame should have matched a flow")
+	}
+	if dot1q := common.GetDot1QLayer(outputs[0].Frame); dot1q != nil {
+		return int(dot1q.VLANIdentifier)
+	}
+
+	return conformanceUntagged
+}
+
+func TestProcessFrame_HighestPriorityWins(t *testing.T) {
+	ipv4 := matchEthType(uint32(layers.EthernetTypeIPv4))
+
+	// The most specific flow has the lowest priority, regardless of the installation order
+	if vlan := matchedVlan(t, tagFlow(1000, 100, matchInPort(1), ipv4), tagFlow(2000, 200, matchInPort(1))); vlan != 200 {
+		t.Error("The highest priority flow should have been applied", vlan)
+	}
+	if vlan := matchedVlan(t, tagFlow(2000, 200, matchInPort(1)), tagFlow(1000, 100, matchInPort(1), ipv4)); vlan != 200 {
+		t.Error("The highest priority flow should have been applied", vlan)
+	}
+}
+
+func TestProcessFrame_TieMostSpecificWins(t *testing.T) {
+	ipv4 := matchEthType(uint32(layers.EthernetTypeIPv4))
+
+	if vlan := matchedVlan(t, tagFlow(1000, 100, matchInPort(1)), tagFlow(1000, 200, matchInPort(1), ipv4)); vlan != 200 {
+		t.Error("The most specific flow should have been applied", vlan)
+	}
+	if vlan := matchedVlan(t, tagFlow(1000, 200, matchInPort(1), ipv4), tagFlow(1000, 100, matchInPort(1))); vlan != 200 {
+		t.Error("The most specific flow should have been applied", vlan)
+	}
+}
+
+func TestProcessFrame_TieFirstInstalledWins(t *testing.T) {
+	if vlan := matchedVlan(t, tagFlow(1000, 100, matchInPort(1)), tagFlow(1000, 200, matchInPort(1))); vlan != 100 {
+		t.Error("The first installed flow should have been applied", vlan)
+	}
+	if vlan := matchedVlan(t, tagFlow(1000, 200, matchInPort(1)), tagFlow(1000, 100, matchInPort(1))); vlan != 200 {
+		t.Error("The first installed flow should have been applied", vlan)
+	}
+}
+
+func TestProcessFrame_WildcardFallback(t *testing.T) {
+	// A flow without criteria matches any frame not matched by a higher priority flow
+	if vlan := matchedVlan(t, tagFlow(0, 100), tagFlow(2000, 200, matchInPort(2))); vlan != 100 {
+		t.Error("The wildcard flow should have been applied", vlan)
+	}
+	if vlan := matchedVlan(t, tagFlow(0, 100), tagFlow(2000, 200, matchInPort(1))); vlan != 200 {
+		t.Error("The wildcard flow should not take precedence over a higher priority flow", vlan)
+	}
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"context"
+	"github.com/golang/protobuf/proto"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/openflow_13"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+/*
+PonSimFlowShadow keeps a copy of the flow table requested for each ONU, by serial number,
+so that the flows can be restored when the ONU reconnects
+*/
+type PonSimFlowShadow struct {
+	mutex sync.RWMutex
+	flows map[string][]*openflow_13.OfpFlowStats
+}
+
+/*
+NewPonSimFlowShadow instantiates an empty shadow copy of the ONU flow tables
+*/
+func NewPonSimFlowShadow() *PonSimFlowShadow {
+	return &PonSimFlowShadow{flows: make(map[string][]*openflow_13.OfpFlowStats)}
+}
+
+/*
+Update applies a flow table update to the copy of the flows of an ONU
+*/
+func (s *PonSimFlowShadow) Update(serialNumber string, table *voltha.FlowTable) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	flows, err := applyFlowTable(s.flows[serialNumber], table)
+	if err != nil {
+		return err
+	}
+	s.flows[serialNumber] = flows
+
+	return nil
+}
+
+/*
+Get returns the copy of the flows of an ONU and whether any update was recorded for it
+*/
+func (s *PonSimFlowShadow) Get(serialNumber string) ([]*openflow_13.OfpFlowStats, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	flows, ok := s.flows[serialNumber]
+	return flows, ok
+}
+
+/*
+diffFlows computes the updates bringing the installed flows in line with the expected flows:
+the expected flows which are missing or differ are added, and the installed flows which are
+not expected are deleted
+*/
+func diffFlows(
+	expected []*openflow_13.OfpFlowStats,
+	installed []*openflow_13.OfpFlowStats,
+) (added []*openflow_13.OfpFlowStats, deleted []*openflow_13.OfpFlowStats) {
+	for _, flow := range expected {
+		found := false
+		for _, other := range installed {
+			if isIdenticalFlow(flow, other) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			added = append(added, flow)
+		}
+	}
+
+	for _, flow := range installed {
+		found := false
+		for _, other := range expected {
+			if isSameFlow(flow, other) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			deleted = append(deleted, flow)
+		}
+	}
+
+	return added, deleted
+}
+
+/*
+isIdenticalFlow reports whether two flows are the same, regardless of their statistics
+*/
+func isIdenticalFlow(flow *openflow_13.OfpFlowStats, other *openflow_13.OfpFlowStats) bool {
+	if !isSameFlow(flow, other) ||
+		flow.Cookie != other.Cookie ||
+		flow.TableId != other.TableId ||
+		flow.Flags != other.Flags ||
+		flow.IdleTimeout != other.IdleTimeout ||
+		flow.HardTimeout != other.HardTimeout ||
+		len(flow.Instructions) != len(other.Instructions) {
+		return false
+	}
+
+	for i := range flow.Instructions {
+		if !proto.Equal(flow.Instructions[i], other.Instructions[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+newFlowResyncEvent creates an event reporting that the flows of an ONU were restored
+*/
+func newFlowResyncEvent(device string, port int32, serialNumber string, added int, deleted int) *voltha.PonSimEvent {
+	return &voltha.PonSimEvent{
+		Device: device,
+		Event: &voltha.PonSimEvent_FlowResync{
+			FlowResync: &voltha.PonSimFlowResync{
+				Port:         port,
+				SerialNumber: serialNumber,
+				Added:        uint32(added),
+				Deleted:      uint32(deleted),
+			},
+		},
+	}
+}
+
+/*
+resyncOnuFlows restores the flows of an ONU which registers again, pushing only the difference
+between the shadow copy and the flows still installed on the ONU, and raises an event once done
+*/
+func (o *PonSimOltDevice) resyncOnuFlows(ctx context.Context, port int32) {
+	onu := o.GetOnu(port)
+	if onu == nil || o.FlowShadow == nil {
+		return
+	}
+
+	serialNumber := onu.Device.SerialNumber
+	if _, ok := o.FlowShadow.Get(serialNumber); !ok {
+		return
+	}
+
+	err := onu.Operations.Submit(func() error {
+		client := voltha.NewPonSimClient(onu.Conn)
+
+		// Updates requested before this operation are reflected by the shadow copy
+		expected, _ := o.FlowShadow.Get(serialNumber)
+
+		installed, err := client.GetFlowStats(ctx, &voltha.PonSimPort{})
+		if err != nil {
+			return err
+		}
+
+		added, deleted := diffFlows(expected, installed.Flows)
+		if len(deleted) > 0 {
+			if _, err = client.UpdateFlowTable(ctx, &voltha.FlowTable{
+				Operation: voltha.FlowTable_DELETE_STRICT,
+				Flows:     deleted,
+			}); err != nil {
+				return err
+			}
+		}
+		if len(added) > 0 {
+			if _, err = client.UpdateFlowTable(ctx, &voltha.FlowTable{
+				Operation: voltha.FlowTable_ADD,
+				Flows:     added,
+			}); err != nil {
+				return err
+			}
+		}
+
+		common.Logger().WithFields(logrus.Fields{
+			"device":       o,
+			"port":         port,
+			"serialNumber": serialNumber,
+			"added":        len(added),
+			"deleted":      len(deleted),
+		}).Info("Resynchronized ONU flows")
+
+		o.publishEvent(newFlowResyncEvent(o.Name, port, serialNumber, len(added), len(deleted)))
+
+		return nil
+	})
+
+	if err != nil {
+		common.Logger().WithFields(logrus.Fields{
+			"device":       o,
+			"port":         port,
+			"serialNumber": serialNumber,
+			"error":        err.Error(),
+		}).Error("Problem resynchronizing ONU flows")
+	}
+}
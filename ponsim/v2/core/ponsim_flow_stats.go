@@ -0,0 +1,123 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/opencord/voltha/protos/go/openflow_13"
+	"sync"
+	"time"
+)
+
+type flowCounter struct {
+	Packets   uint64
+	Bytes     uint64
+	Installed time.Time
+}
+
+/*
+PonSimFlowStats tracks the packets and bytes matched by each flow installed on a device
+
+Counters are keyed by flow id so that they survive the replacement of the flow table
+as long as the flow remains installed.
+*/
+type PonSimFlowStats struct {
+	mutex    sync.Mutex
+	counters map[uint64]*flowCounter
+}
+
+/*
+NewPonSimFlowStats instantiates flow statistics without any flows
+*/
+func NewPonSimFlowStats() *PonSimFlowStats {
+	return &PonSimFlowStats{counters: make(map[uint64]*flowCounter)}
+}
+
+/*
+Reset synchronizes the counters with a new flow table; counters of removed flows are discarded
+and new flows start from zero
+*/
+func (s *PonSimFlowStats) Reset(flows []*openflow_13.OfpFlowStats) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	counters := make(map[uint64]*flowCounter)
+	for _, flow := range flows {
+		if counter, ok := s.counters[flow.Id]; ok {
+			counters[flow.Id] = counter
+		} else {
+			counters[flow.Id] = &flowCounter{Installed: time.Now()}
+		}
+	}
+	s.counters = counters
+}
+
+/*
+Count accounts for a frame matched by a flow
+*/
+func (s *PonSimFlowStats) Count(flow *openflow_13.OfpFlowStats, size int) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if counter, ok := s.counters[flow.Id]; ok {
+		counter.Packets += 1
+		counter.Bytes += uint64(size)
+	}
+}
+
+/*
+MakeProto returns copies of the flows annotated with their counters and lifetime
+*/
+func (s *PonSimFlowStats) MakeProto(flows []*openflow_13.OfpFlowStats) []*openflow_13.OfpFlowStats {
+	var stats []*openflow_13.OfpFlowStats
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, flow := range flows {
+		stat := proto.Clone(flow).(*openflow_13.OfpFlowStats)
+		if counter, ok := s.counters[flow.Id]; ok {
+			duration := time.Since(counter.Installed)
+			stat.PacketCount = counter.Packets
+			stat.ByteCount = counter.Bytes
+			stat.DurationSec = uint32(duration / time.Second)
+			stat.DurationNsec = uint32(duration % time.Second)
+		}
+		stats = append(stats, stat)
+	}
+
+	return stats
+}
+
+/*
+GetFlowStats returns the flows installed on the device along with their statistics
+*/
+func (o *PonSimDevice) GetFlowStats() []*openflow_13.OfpFlowStats {
+	flows := o.flows.Get()
+	if o.FlowStats == nil {
+		return flows
+	}
+
+	return o.FlowStats.MakeProto(flows)
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/openflow_13"
+)
+
+const (
+	// Keys of the flow tables are made of this prefix followed by the name of the device
+	FLOW_STORE_PREFIX = "ponsim/flows/"
+)
+
+/*
+PonSimFlowStore persists the flow table of a device to a key/value store (e.g. etcd or
+Consul) so that the programmed state can be restored by a new instance of the simulator
+*/
+type PonSimFlowStore struct {
+	Key   string         `json:"key"`
+	Store common.KVStore `json:"-"`
+}
+
+/*
+NewPonSimFlowStore instantiates the flow store of a device in the specified key/value store
+*/
+func NewPonSimFlowStore(store common.KVStore, device string) *PonSimFlowStore {
+	return &PonSimFlowStore{Key: FLOW_STORE_PREFIX + device, Store: store}
+}
+
+/*
+Load returns the flow table last saved for the device, or nil when none was saved
+*/
+func (s *PonSimFlowStore) Load() ([]*openflow_13.OfpFlowStats, error) {
+	value, err := s.Store.Get(s.Key)
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	flows := &openflow_13.Flows{}
+	if err := proto.Unmarshal(value, flows); err != nil {
+		return nil, err
+	}
+
+	return flows.Items, nil
+}
+
+/*
+Save replaces the flow table saved for the device
+*/
+func (s *PonSimFlowStore) Save(flows []*openflow_13.OfpFlowStats) error {
+	value, err := proto.Marshal(&openflow_13.Flows{Items: flows})
+	if err != nil {
+		return err
+	}
+
+	return s.Store.Put(s.Key, value)
+}
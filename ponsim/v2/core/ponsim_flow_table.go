@@ -0,0 +1,208 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/openflow_13"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+)
+
+/*
+PonSimFlowTable holds the flows installed on a device, sorted in decreasing order of priority.
+The flows are replaced as a whole, frames being matched against a snapshot of the table.
+
+Updates, from their computation out of the installed flows until the resulting flows are
+applied, are serialized so that concurrent updates are not lost.
+*/
+type PonSimFlowTable struct {
+	updateMutex sync.Mutex
+	mutex       sync.RWMutex
+	flows       []*openflow_13.OfpFlowStats
+}
+
+/*
+NewPonSimFlowTable instantiates an empty flow table
+*/
+func NewPonSimFlowTable() *PonSimFlowTable {
+	return &PonSimFlowTable{}
+}
+
+/*
+Set replaces the flows of the table with a sorted copy of the specified flows
+*/
+func (t *PonSimFlowTable) Set(flows []*openflow_13.OfpFlowStats) {
+	sorted := append([]*openflow_13.OfpFlowStats(nil), flows...)
+	sort.Stable(common.SortByPriority(sorted))
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.flows = sorted
+}
+
+/*
+Get returns a snapshot of the flows of the table, which must not be modified
+*/
+func (t *PonSimFlowTable) Get() []*openflow_13.OfpFlowStats {
+	if t == nil {
+		return nil
+	}
+
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	return t.flows
+}
+
+/*
+UpdateFlows applies a flow table update to the device
+
+Besides a wholesale replacement of the flow table, flows can be added or deleted incrementally
+following the OpenFlow semantics of OFPFC_ADD, OFPFC_DELETE and OFPFC_DELETE_STRICT.
+*/
+func (o *PonSimDevice) UpdateFlows(ctx context.Context, table *voltha.FlowTable) error {
+	common.Logger().ForContext(ctx).WithFields(logrus.Fields{
+		"device":    o,
+		"operation": table.Operation,
+		"cookie":    table.Cookie,
+		"mask":      table.CookieMask,
+	}).Debug("Updating flows")
+
+	if err := o.chaosFlowFailure(); err != nil {
+		return err
+	}
+
+	return o.updateFlows(ctx, func(installed []*openflow_13.OfpFlowStats) ([]*openflow_13.OfpFlowStats, error) {
+		return applyFlowTable(installed, table)
+	})
+}
+
+/*
+applyFlowTable returns the flows resulting from a flow table update of the installed flows
+*/
+func applyFlowTable(
+	installed []*openflow_13.OfpFlowStats,
+	table *voltha.FlowTable,
+) ([]*openflow_13.OfpFlowStats, error) {
+	switch table.Operation {
+	case voltha.FlowTable_REPLACE:
+		return table.Flows, nil
+
+	case voltha.FlowTable_ADD:
+		return addFlows(installed, table.Flows), nil
+
+	case voltha.FlowTable_DELETE, voltha.FlowTable_DELETE_STRICT:
+		strict := table.Operation == voltha.FlowTable_DELETE_STRICT
+		if strict && len(table.Flows) == 0 {
+			return nil, fmt.Errorf("strict deletion requires the flows to delete")
+		}
+
+		var kept []*openflow_13.OfpFlowStats
+		for _, flow := range installed {
+			if !isDeleted(flow, table, strict) {
+				kept = append(kept, flow)
+			}
+		}
+
+		return kept, nil
+	}
+
+	return nil, fmt.Errorf("unsupported flow table operation %s", table.Operation)
+}
+
+/*
+addFlows adds flows to a flow table, replacing the flows with an identical priority and match
+*/
+func addFlows(
+	installed []*openflow_13.OfpFlowStats,
+	flows []*openflow_13.OfpFlowStats,
+) []*openflow_13.OfpFlowStats {
+	var table []*openflow_13.OfpFlowStats
+
+	for _, flow := range installed {
+		replaced := false
+		for _, added := range flows {
+			if isSameFlow(flow, added) {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			table = append(table, flow)
+		}
+	}
+
+	return append(table, flows...)
+}
+
+/*
+isDeleted reports whether an installed flow is targeted by a deletion request
+*/
+func isDeleted(flow *openflow_13.OfpFlowStats, table *voltha.FlowTable, strict bool) bool {
+	if flow.Cookie&table.CookieMask != table.Cookie&table.CookieMask {
+		return false
+	}
+
+	// A non-strict deletion without flows targets every flow with a matching cookie
+	if len(table.Flows) == 0 {
+		return true
+	}
+
+	for _, deleted := range table.Flows {
+		if strict && isSameFlow(flow, deleted) {
+			return true
+		} else if !strict && isCoveredBy(flow.Match, deleted.Match) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+isSameFlow reports whether two flows have the same priority and match
+*/
+func isSameFlow(flow *openflow_13.OfpFlowStats, other *openflow_13.OfpFlowStats) bool {
+	return flow.Priority == other.Priority && proto.Equal(flow.Match, other.Match)
+}
+
+/*
+isCoveredBy reports whether a match is at least as specific as a filter, i.e. every field of
+the filter is also present in the match.  An empty filter covers every match.
+*/
+func isCoveredBy(match *openflow_13.OfpMatch, filter *openflow_13.OfpMatch) bool {
+	for _, field := range filter.GetOxmFields() {
+		covered := false
+		for _, candidate := range match.GetOxmFields() {
+			if proto.Equal(field, candidate) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"sync"
+)
+
+const (
+	// Drop the frame being queued when the queue is full
+	QUEUE_TAIL_DROP = "tail_drop"
+
+	// Drop the oldest queued frame to make room for the frame being queued
+	QUEUE_HEAD_DROP = "head_drop"
+
+	// Wait for the consumer to make room for the frame being queued
+	QUEUE_BLOCK = "block"
+)
+
+/*
+PonSimFrameQueue is a bounded ring buffer of frames whose behaviour when full is set by its
+drop policy.  Consumers wait for frames on the Ready channel and then Pop them.
+*/
+type PonSimFrameQueue struct {
+	Capacity int    `json:"capacity"`
+	Policy   string `json:"policy"`
+
+	mutex  sync.Mutex
+	frames []gopacket.Packet
+	head   int
+	length int
+	closed bool
+	ready  chan struct{}
+	space  chan struct{}
+	done   chan struct{}
+}
+
+/*
+NewPonSimFrameQueue instantiates a queue holding up to capacity frames with a drop policy
+(tail_drop if empty)
+*/
+func NewPonSimFrameQueue(capacity int, policy string) (*PonSimFrameQueue, error) {
+	if capacity <= 0 {
+		return nil, fmt.Errorf("invalid queue capacity: %d", capacity)
+	}
+
+	if policy == "" {
+		policy = QUEUE_TAIL_DROP
+	}
+
+	switch policy {
+	case QUEUE_TAIL_DROP, QUEUE_HEAD_DROP, QUEUE_BLOCK:
+	default:
+		return nil, fmt.Errorf("unknown queue policy: %s", policy)
+	}
+
+	return &PonSimFrameQueue{
+		Capacity: capacity,
+		Policy:   policy,
+		frames:   make([]gopacket.Packet, capacity),
+		ready:    make(chan struct{}, 1),
+		space:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+func notifyChannel(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+/*
+Push queues a frame according to the drop policy.  It reports whether the frame was queued,
+and returns the oldest queued frame when it was dropped to make room (head_drop).  With the
+block policy it waits until the frame is queued or the queue is closed.
+*/
+func (q *PonSimFrameQueue) Push(frame gopacket.Packet) (bool, gopacket.Packet) {
+	return q.push(frame, q.Policy)
+}
+
+/*
+push queues a frame according to the specified drop policy
+*/
+func (q *PonSimFrameQueue) push(frame gopacket.Packet, policy string) (bool, gopacket.Packet) {
+	for {
+		q.mutex.Lock()
+
+		if q.closed {
+			q.mutex.Unlock()
+			return false, nil
+		}
+
+		var evicted gopacket.Packet
+		if q.length == q.Capacity {
+			switch policy {
+			case QUEUE_TAIL_DROP:
+				q.mutex.Unlock()
+				return false, nil
+			case QUEUE_HEAD_DROP:
+				evicted = q.frames[q.head]
+				q.frames[q.head] = nil
+				q.head = (q.head + 1) % q.Capacity
+				q.length--
+			case QUEUE_BLOCK:
+				q.mutex.Unlock()
+				select {
+				case <-q.space:
+				case <-q.done:
+				}
+				continue
+			}
+		}
+
+		q.frames[(q.head+q.length)%q.Capacity] = frame
+		q.length++
+		notifyChannel(q.ready)
+		q.mutex.Unlock()
+
+		return true, evicted
+	}
+}
+
+/*
+Pop dequeues the oldest frame, or returns nil when the queue is empty
+*/
+func (q *PonSimFrameQueue) Pop() gopacket.Packet {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.length == 0 {
+		return nil
+	}
+
+	frame := q.frames[q.head]
+	q.frames[q.head] = nil
+	q.head = (q.head + 1) % q.Capacity
+	q.length--
+
+	notifyChannel(q.space)
+	if q.length > 0 {
+		notifyChannel(q.ready)
+	}
+
+	return frame
+}
+
+/*
+Ready returns a channel signalled when frames are available
+*/
+func (q *PonSimFrameQueue) Ready() <-chan struct{} {
+	return q.ready
+}
+
+/*
+Len returns the number of frames currently queued
+*/
+func (q *PonSimFrameQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.length
+}
+
+/*
+Close releases the producers waiting for room; frames pushed afterwards are dropped
+*/
+func (q *PonSimFrameQueue) Close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.done)
+	}
+}
+
+/*
+MakeProto reports the occupancy of the queue as GRPC metrics
+*/
+func (q *PonSimFrameQueue) MakeProto(name string) *voltha.PonSimPortMetrics {
+	return &voltha.PonSimPortMetrics{
+		PortName: name,
+		Packets: []*voltha.PonSimPacketCounter{
+			{Name: "queue_depth", Value: int64(q.Len())},
+			{Name: "queue_capacity", Value: int64(q.Capacity)},
+		},
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	BASE_ALLOC_ID     = 1024
+	BASE_GEM_PORT_ID  = 1024
+	GEM_PORTS_PER_ONU = 8
+
+	// Downstream frames which are not addressed to a single ONU are carried on the broadcast
+	// GEM port, accepted by every ONU
+	BROADCAST_GEM_PORT_ID = BASE_GEM_PORT_ID - 1
+)
+
+/*
+PonSimGemPort models a GEM port carrying the traffic of an ONU over the PON link
+*/
+type PonSimGemPort struct {
+	GemId   uint32 `json:"gem_id"`
+	AllocId uint32 `json:"alloc_id"`
+
+	RxFrames int64 `json:"rx_frames"`
+	RxBytes  int64 `json:"rx_bytes"`
+	TxFrames int64 `json:"tx_frames"`
+	TxBytes  int64 `json:"tx_bytes"`
+
+	// AES encryption of the GEM port, the key being exchanged with the ONU
+	Encrypted bool   `json:"encrypted"`
+	KeyIndex  uint32 `json:"key_index"`
+	KeyState  string `json:"key_state"`
+	key       []byte
+	keyMutex  sync.Mutex
+}
+
+/*
+CountRxFrame increments the receive counters of the GEM port
+*/
+func (g *PonSimGemPort) CountRxFrame(size int) {
+	atomic.AddInt64(&g.RxFrames, 1)
+	atomic.AddInt64(&g.RxBytes, int64(size))
+}
+
+/*
+CountTxFrame increments the transmit counters of the GEM port
+*/
+func (g *PonSimGemPort) CountTxFrame(size int) {
+	atomic.AddInt64(&g.TxFrames, 1)
+	atomic.AddInt64(&g.TxBytes, int64(size))
+}
+
+/*
+PonSimTcont models a T-CONT, i.e. the upstream transmission container of an ONU
+*/
+type PonSimTcont struct {
+	AllocId  uint32                    `json:"alloc_id"`
+	GemPorts map[uint32]*PonSimGemPort `json:"gem_ports"`
+}
+
+/*
+NewPonSimTcont instantiates a T-CONT with the GEM ports assigned to the specified ONU index
+*/
+func NewPonSimTcont(onuIndex int32) *PonSimTcont {
+	tcont := &PonSimTcont{
+		AllocId:  uint32(BASE_ALLOC_ID + onuIndex),
+		GemPorts: make(map[uint32]*PonSimGemPort),
+	}
+
+	// Every ONU is assigned a default GEM port
+	gemId := uint32(BASE_GEM_PORT_ID + onuIndex*GEM_PORTS_PER_ONU)
+	tcont.GemPorts[gemId] = &PonSimGemPort{GemId: gemId, AllocId: tcont.AllocId, KeyState: KEY_NONE}
+
+	return tcont
+}
+
+/*
+GetGemPortIds returns the sorted list of GEM port identifiers of the T-CONT
+*/
+func (t *PonSimTcont) GetGemPortIds() []uint32 {
+	ids := make([]uint32, 0, len(t.GemPorts))
+	for id := range t.GemPorts {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids
+}
+
+/*
+GetDefaultGemPort returns the GEM port used when no other GEM port was selected for a frame
+*/
+func (t *PonSimTcont) GetDefaultGemPort() *PonSimGemPort {
+	if ids := t.GetGemPortIds(); len(ids) > 0 {
+		return t.GemPorts[ids[0]]
+	}
+
+	return nil
+}
+
+/*
+GetGemPort returns a specific GEM port of the T-CONT
+*/
+func (t *PonSimTcont) GetGemPort(gemId uint32) *PonSimGemPort {
+	if gem, ok := t.GemPorts[gemId]; ok {
+		return gem
+	}
+
+	return nil
+}
+
+type gemPortKey struct{}
+
+/*
+WithGemPort attaches the GEM port a frame was received on to the forwarding context
+*/
+func WithGemPort(ctx context.Context, gemId uint32) context.Context {
+	return context.WithValue(ctx, gemPortKey{}, gemId)
+}
+
+/*
+GemPortFromContext returns the GEM port attached to the forwarding context (if any)
+*/
+func GemPortFromContext(ctx context.Context) (uint32, bool) {
+	gemId, ok := ctx.Value(gemPortKey{}).(uint32)
+	return gemId, ok
+}
@@ -0,0 +1,295 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/ponsim"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+const (
+	// The GEM port is not encrypted
+	KEY_NONE = "none"
+
+	// The key of the GEM port is being exchanged with the ONU
+	KEY_EXCHANGING = "exchanging"
+
+	// The OLT and the ONU encrypt the GEM port with the same key
+	KEY_ACTIVE = "active"
+
+	// The key exchange failed, the GEM port keeps its previous key if it had one
+	KEY_FAILED = "failed"
+
+	AES_KEY_SIZE = 16
+
+	// Number of key requests sent to the ONU before the key exchange fails
+	KEY_EXCHANGE_ATTEMPTS = 3
+)
+
+/*
+PonSimGemKeys holds the keys generated by an ONU for its encrypted GEM ports
+*/
+type PonSimGemKeys struct {
+	mutex     sync.Mutex
+	generated map[uint32]map[uint32][]byte
+	active    map[uint32]uint32
+}
+
+/*
+GenerateGemKey generates the key of an encrypted GEM port of the ONU, as requested by the OLT
+*/
+func (o *PonSimOnuDevice) GenerateGemKey(gemId uint32, keyIndex uint32) ([]byte, error) {
+	if gemId == BROADCAST_GEM_PORT_ID || !o.acceptsGemPort(gemId) {
+		return nil, fmt.Errorf("unknown GEM port %d", gemId)
+	}
+
+	key := make([]byte, AES_KEY_SIZE)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	o.gemKeys.mutex.Lock()
+	defer o.gemKeys.mutex.Unlock()
+
+	if o.gemKeys.generated == nil {
+		o.gemKeys.generated = make(map[uint32]map[uint32][]byte)
+		o.gemKeys.active = make(map[uint32]uint32)
+	}
+	if _, ok := o.gemKeys.generated[gemId]; !ok {
+		o.gemKeys.generated[gemId] = make(map[uint32][]byte)
+	}
+	o.gemKeys.generated[gemId][keyIndex] = key
+
+	return key, nil
+}
+
+/*
+ActivateGemKey makes the ONU use a key it generated for a GEM port
+*/
+func (o *PonSimOnuDevice) ActivateGemKey(gemId uint32, keyIndex uint32) error {
+	o.gemKeys.mutex.Lock()
+	defer o.gemKeys.mutex.Unlock()
+
+	if _, ok := o.gemKeys.generated[gemId][keyIndex]; !ok {
+		return fmt.Errorf("no key %d was generated for GEM port %d", keyIndex, gemId)
+	}
+	o.gemKeys.active[gemId] = keyIndex
+
+	common.Logger().WithFields(logrus.Fields{
+		"device":   o.Name,
+		"gemId":    gemId,
+		"keyIndex": keyIndex,
+	}).Info("Switched GEM port key")
+
+	return nil
+}
+
+/*
+newKeyExchangeEvent creates an event reporting the outcome of the key exchange of a GEM port
+*/
+func newKeyExchangeEvent(device string, port int32, gemId uint32, keyIndex uint32, err error) *voltha.PonSimEvent {
+	exchange := &voltha.PonSimKeyExchange{
+		Port:     port,
+		GemPort:  gemId,
+		KeyIndex: keyIndex,
+		Success:  err == nil,
+	}
+	if err != nil {
+		exchange.Reason = err.Error()
+	}
+
+	return &voltha.PonSimEvent{
+		Device: device,
+		Event:  &voltha.PonSimEvent_KeyExchange{KeyExchange: exchange},
+	}
+}
+
+/*
+GetEncryptedGemPortIds returns the sorted list of the encrypted GEM ports of the T-CONT
+*/
+func (t *PonSimTcont) GetEncryptedGemPortIds() []uint32 {
+	var ids []uint32
+	for _, id := range t.GetGemPortIds() {
+		if t.GemPorts[id].Encrypted {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+/*
+getOnuGemPort returns a registered ONU along with one of its GEM ports
+*/
+func (o *PonSimOltDevice) getOnuGemPort(onuPort int32, gemId uint32) (*OnuRegistree, *PonSimGemPort, error) {
+	onu := o.GetOnu(onuPort)
+	if onu == nil {
+		return nil, nil, fmt.Errorf("no ONU on port %d", onuPort)
+	}
+	if onu.Tcont == nil || onu.Tcont.GetGemPort(gemId) == nil {
+		return nil, nil, fmt.Errorf("ONU on port %d has no GEM port %d", onuPort, gemId)
+	}
+
+	return onu, onu.Tcont.GetGemPort(gemId), nil
+}
+
+/*
+SetGemEncryption enables or disables the encryption of a GEM port of an ONU, a key being
+exchanged with the ONU when it is enabled
+*/
+func (o *PonSimOltDevice) SetGemEncryption(ctx context.Context, onuPort int32, gemId uint32, encrypted bool) (*PonSimGemPort, error) {
+	onu, gem, err := o.getOnuGemPort(onuPort, gemId)
+	if err != nil {
+		return nil, err
+	}
+
+	gem.keyMutex.Lock()
+	defer gem.keyMutex.Unlock()
+
+	if !encrypted {
+		gem.Encrypted = false
+		gem.KeyState = KEY_NONE
+		gem.key = nil
+		return gem, nil
+	}
+
+	gem.Encrypted = true
+	return gem, o.exchangeKey(ctx, onuPort, onu, gem)
+}
+
+/*
+SwitchGemKey exchanges a new key for an encrypted GEM port of an ONU
+*/
+func (o *PonSimOltDevice) SwitchGemKey(ctx context.Context, onuPort int32, gemId uint32) (*PonSimGemPort, error) {
+	onu, gem, err := o.getOnuGemPort(onuPort, gemId)
+	if err != nil {
+		return nil, err
+	}
+
+	gem.keyMutex.Lock()
+	defer gem.keyMutex.Unlock()
+
+	if !gem.Encrypted {
+		return nil, fmt.Errorf("GEM port %d is not encrypted", gemId)
+	}
+
+	return gem, o.exchangeKey(ctx, onuPort, onu, gem)
+}
+
+/*
+exchangeKey requests a new key from the ONU for a GEM port and switches both ends to it.  The
+key requests lost through injected faults are retried up to KEY_EXCHANGE_ATTEMPTS times.
+*/
+func (o *PonSimOltDevice) exchangeKey(ctx context.Context, onuPort int32, onu *OnuRegistree, gem *PonSimGemPort) error {
+	// The new key uses the other index so that the previous key stays valid until the switch
+	keyIndex := gem.KeyIndex
+	if gem.key != nil {
+		keyIndex = (gem.KeyIndex + 1) % 2
+	}
+	previous := gem.KeyState
+	gem.KeyState = KEY_EXCHANGING
+
+	client := ponsim.NewPonSimOnuClient(onu.Conn)
+
+	var reply *ponsim.KeyReply
+	var err error
+	for attempt := 1; attempt <= KEY_EXCHANGE_ATTEMPTS; attempt++ {
+		if o.consumeKeyFault(onuPort) {
+			err = errors.New("key request timed out")
+		} else if reply, err = client.RequestKey(ctx, &ponsim.KeyRequest{GemPort: gem.GemId, KeyIndex: keyIndex}); err == nil && len(reply.Key) != AES_KEY_SIZE {
+			err = fmt.Errorf("invalid key of %d bytes", len(reply.Key))
+		}
+		if err == nil {
+			break
+		}
+
+		common.Logger().WithFields(logrus.Fields{
+			"device":  o.Name,
+			"port":    onuPort,
+			"gemId":   gem.GemId,
+			"attempt": attempt,
+			"error":   err.Error(),
+		}).Warn("Key request failed")
+	}
+
+	if err == nil {
+		_, err = client.SwitchKey(ctx, &ponsim.KeySwitch{GemPort: gem.GemId, KeyIndex: keyIndex})
+	}
+
+	if err != nil {
+		gem.KeyState = KEY_FAILED
+		if previous == KEY_ACTIVE {
+			gem.KeyState = KEY_ACTIVE
+		}
+		err = fmt.Errorf("key exchange of GEM port %d failed: %s", gem.GemId, err.Error())
+	} else {
+		gem.key = reply.Key
+		gem.KeyIndex = keyIndex
+		gem.KeyState = KEY_ACTIVE
+
+		common.Logger().WithFields(logrus.Fields{
+			"device":   o.Name,
+			"port":     onuPort,
+			"gemId":    gem.GemId,
+			"keyIndex": keyIndex,
+		}).Info("Switched GEM port key")
+	}
+
+	o.publishEvent(newKeyExchangeEvent(o.Name, onuPort, gem.GemId, keyIndex, err))
+
+	return err
+}
+
+/*
+SetKeyExchangeFaults makes the next key requests sent to an ONU fail
+*/
+func (o *PonSimOltDevice) SetKeyExchangeFaults(onuPort int32, failures int) error {
+	if o.GetOnu(onuPort) == nil {
+		return fmt.Errorf("no ONU on port %d", onuPort)
+	}
+
+	o.keyMutex.Lock()
+	defer o.keyMutex.Unlock()
+
+	if o.keyFaults == nil {
+		o.keyFaults = make(map[int32]int)
+	}
+	o.keyFaults[onuPort] = failures
+
+	return nil
+}
+
+/*
+consumeKeyFault reports whether a key request sent to an ONU must fail
+*/
+func (o *PonSimOltDevice) consumeKeyFault(onuPort int32) bool {
+	o.keyMutex.Lock()
+	defer o.keyMutex.Unlock()
+
+	if o.keyFaults[onuPort] > 0 {
+		o.keyFaults[onuPort]--
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// Datasource which Grafana asks for when the dashboard is imported
+	grafanaDatasource = "${DS_PROMETHEUS}"
+
+	// Window over which the rates of the counters are computed
+	grafanaRateInterval = "1m"
+)
+
+type grafanaTarget struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+	RefId        string `json:"refId"`
+}
+
+type grafanaPanel struct {
+	Id         int                      `json:"id"`
+	Title      string                   `json:"title"`
+	Type       string                   `json:"type"`
+	Datasource string                   `json:"datasource"`
+	GridPos    map[string]int           `json:"gridPos"`
+	Targets    []grafanaTarget          `json:"targets"`
+	Yaxes      []map[string]interface{} `json:"yaxes"`
+	Lines      bool                     `json:"lines"`
+	Linewidth  int                      `json:"linewidth"`
+	Legend     map[string]bool          `json:"legend"`
+}
+
+/*
+rateByDevice sums the rate of a metric for the selected devices by the specified labels
+*/
+func rateByDevice(metric string, by string) string {
+	return fmt.Sprintf(`sum by (device, %s) (rate(%s{device=~"$device"}[%s]))`, by, metric, grafanaRateInterval)
+}
+
+/*
+newGrafanaPanel creates a graph panel laid out in a grid of two panels per row
+*/
+func newGrafanaPanel(id int, title string, unit string, targets ...grafanaTarget) grafanaPanel {
+	for i := range targets {
+		targets[i].RefId = string('A' + rune(i))
+	}
+
+	// Each panel is half of the 24 columns wide
+	return grafanaPanel{
+		Id:         id,
+		Title:      title,
+		Type:       "graph",
+		Datasource: grafanaDatasource,
+		GridPos:    map[string]int{"h": 8, "w": 12, "x": ((id - 1) % 2) * 12, "y": ((id - 1) / 2) * 8},
+		Targets:    targets,
+		Yaxes: []map[string]interface{}{
+			{"format": unit, "min": 0, "show": true},
+			{"format": "short", "show": false},
+		},
+		Lines:     true,
+		Linewidth: 1,
+		Legend:    map[string]bool{"show": true},
+	}
+}
+
+/*
+NewGrafanaDashboard generates a Grafana dashboard charting the metrics which the simulator
+exposes to Prometheus: the rates of frames per port, the drops and the alarms
+*/
+func NewGrafanaDashboard() ([]byte, error) {
+	panels := []grafanaPanel{
+		newGrafanaPanel(1, "Received frames", "pps",
+			grafanaTarget{Expr: rateByDevice(METRIC_RX_PACKETS, "port"), LegendFormat: "{{device}} {{port}}"},
+		),
+		newGrafanaPanel(2, "Sent frames", "pps",
+			grafanaTarget{Expr: rateByDevice(METRIC_TX_PACKETS, "port"), LegendFormat: "{{device}} {{port}}"},
+		),
+		newGrafanaPanel(3, "Dropped frames", "pps",
+			grafanaTarget{Expr: rateByDevice(METRIC_RX_DROPPED_PACKETS, "port"), LegendFormat: "{{device}} {{port}} dropped"},
+			grafanaTarget{Expr: rateByDevice(METRIC_OVERSIZE_PACKETS, "port"), LegendFormat: "{{device}} {{port}} oversize"},
+			grafanaTarget{Expr: rateByDevice(METRIC_RX_DUPLICATE_PACKETS, "port"), LegendFormat: "{{device}} {{port}} duplicate"},
+			grafanaTarget{Expr: rateByDevice(METRIC_CPU_DROPPED_PACKETS, "class"), LegendFormat: "{{device}} {{class}} towards VOLTHA"},
+		),
+		newGrafanaPanel(4, "Damaged frames", "pps",
+			grafanaTarget{Expr: rateByDevice(METRIC_RX_CORRUPTED_PACKETS, "port"), LegendFormat: "{{device}} {{port}} corrupted"},
+			grafanaTarget{Expr: rateByDevice(METRIC_RX_HASH_ERRORS, "port"), LegendFormat: "{{device}} {{port}} hash errors"},
+		),
+		newGrafanaPanel(5, "Frames towards VOLTHA", "pps",
+			grafanaTarget{Expr: rateByDevice(METRIC_CPU_PACKETS, "class"), LegendFormat: "{{device}} {{class}}"},
+		),
+		newGrafanaPanel(6, "Alarms", "short",
+			grafanaTarget{
+				Expr:         fmt.Sprintf(`sum by (device, state) (increase(%s{device=~"$device"}[%s]))`, METRIC_ALARMS, grafanaRateInterval),
+				LegendFormat: "{{device}} {{state}}",
+			},
+		),
+	}
+
+	dashboard := map[string]interface{}{
+		"__inputs": []map[string]string{
+			{
+				"name":     "DS_PROMETHEUS",
+				"label":    "Prometheus",
+				"type":     "datasource",
+				"pluginId": "prometheus",
+			},
+		},
+		"title":         "PonSim",
+		"tags":          []string{"ponsim", "voltha"},
+		"editable":      true,
+		"schemaVersion": 16,
+		"refresh":       "10s",
+		"time":          map[string]string{"from": "now-1h", "to": "now"},
+		"templating": map[string]interface{}{
+			"list": []map[string]interface{}{
+				{
+					"name":       "device",
+					"label":      "Device",
+					"type":       "query",
+					"datasource": grafanaDatasource,
+					"query":      fmt.Sprintf("label_values(%s, device)", METRIC_RX_PACKETS),
+					"refresh":    2,
+					"multi":      true,
+					"includeAll": true,
+					"allValue":   ".*",
+				},
+			},
+		},
+		"panels": panels,
+	}
+
+	return json.MarshalIndent(dashboard, "", "  ")
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/openflow_13"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+const (
+	// Groups may reference other groups, deeper chains are considered loops
+	MAX_GROUP_CHAIN_DEPTH = 8
+)
+
+type groupDepthKey struct{}
+
+/*
+PonSimGroupTable holds the OpenFlow groups of a device.  ALL and INDIRECT groups are supported.
+*/
+type PonSimGroupTable struct {
+	mutex  sync.RWMutex
+	groups map[uint32]*openflow_13.OfpGroupMod
+}
+
+/*
+NewPonSimGroupTable instantiates an empty group table
+*/
+func NewPonSimGroupTable() *PonSimGroupTable {
+	return &PonSimGroupTable{groups: make(map[uint32]*openflow_13.OfpGroupMod)}
+}
+
+/*
+Apply adds, modifies or deletes a group following the semantics of an OpenFlow group-mod
+*/
+func (t *PonSimGroupTable) Apply(mod *openflow_13.OfpGroupMod) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	_, exists := t.groups[mod.GroupId]
+
+	switch mod.Command {
+	case openflow_13.OfpGroupModCommand_OFPGC_ADD, openflow_13.OfpGroupModCommand_OFPGC_MODIFY:
+		if mod.Type != openflow_13.OfpGroupType_OFPGT_ALL && mod.Type != openflow_13.OfpGroupType_OFPGT_INDIRECT {
+			return fmt.Errorf("unsupported type %s for group %d", mod.Type, mod.GroupId)
+		}
+		if mod.Type == openflow_13.OfpGroupType_OFPGT_INDIRECT && len(mod.Buckets) != 1 {
+			return fmt.Errorf("indirect group %d must have exactly one bucket", mod.GroupId)
+		}
+		if mod.Command == openflow_13.OfpGroupModCommand_OFPGC_ADD && exists {
+			return fmt.Errorf("group %d already exists", mod.GroupId)
+		}
+		if mod.Command == openflow_13.OfpGroupModCommand_OFPGC_MODIFY && !exists {
+			return fmt.Errorf("unknown group %d", mod.GroupId)
+		}
+		t.groups[mod.GroupId] = mod
+
+	case openflow_13.OfpGroupModCommand_OFPGC_DELETE:
+		if mod.GroupId == uint32(openflow_13.OfpGroup_OFPG_ALL) {
+			t.groups = make(map[uint32]*openflow_13.OfpGroupMod)
+		} else {
+			delete(t.groups, mod.GroupId)
+		}
+
+	default:
+		return fmt.Errorf("unsupported group command %s", mod.Command)
+	}
+
+	return nil
+}
+
+/*
+Get returns the group with the specified identifier, if any
+*/
+func (t *PonSimGroupTable) Get(groupId uint32) *openflow_13.OfpGroupMod {
+	if t == nil {
+		return nil
+	}
+
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	return t.groups[groupId]
+}
+
+/*
+UpdateGroups applies group-mods to the group table of the device
+*/
+func (o *PonSimDevice) UpdateGroups(ctx context.Context, mods []*openflow_13.OfpGroupMod) error {
+	if o.groups == nil {
+		o.groups = NewPonSimGroupTable()
+	}
+
+	for _, mod := range mods {
+		common.Logger().WithFields(logrus.Fields{
+			"device":  o,
+			"command": mod.Command,
+			"group":   mod.GroupId,
+			"type":    mod.Type,
+		}).Debug("Updating group")
+
+		if err := o.groups.Apply(mod); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+processGroup applies the buckets of a group to a frame.  Each bucket of an ALL group processes
+its own copy of the frame while an INDIRECT group applies its single bucket.
+*/
+func (o *PonSimDevice) processGroup(
+	ctx context.Context,
+	flow *openflow_13.OfpFlowStats,
+	groupId uint32,
+	frame gopacket.Packet,
+) []ponSimOutput {
+	var outputs []ponSimOutput
+
+	group := o.groups.Get(groupId)
+	if group == nil {
+		common.Logger().WithFields(logrus.Fields{
+			"device": o,
+			"flow":   flow,
+			"group":  groupId,
+		}).Warn("Unknown group")
+		return nil
+	}
+
+	depth, _ := ctx.Value(groupDepthKey{}).(int)
+	if depth >= MAX_GROUP_CHAIN_DEPTH {
+		common.Logger().WithFields(logrus.Fields{
+			"device": o,
+			"flow":   flow,
+			"group":  groupId,
+		}).Warn("Group chain is too deep")
+		return nil
+	}
+	ctx = context.WithValue(ctx, groupDepthKey{}, depth+1)
+
+	for _, bucket := range group.Buckets {
+		bucketFrame := common.NewFrame(append([]byte(nil), frame.Data()...))
+
+		egressPort, bucketFrame, groupOutputs := o.applyActions(ctx, flow, bucket.Actions, 0, bucketFrame)
+		if egressPort != 0 {
+			outputs = append(outputs, ponSimOutput{Port: egressPort, Frame: bucketFrame, Flow: flow})
+		}
+		outputs = append(outputs, groupOutputs...)
+	}
+
+	return outputs
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"strings"
+)
+
+const (
+	DEFAULT_VENDOR           = "PONSIM"
+	DEFAULT_OLT_MODEL        = "PONSIM-OLT"
+	DEFAULT_ONU_MODEL        = "PONSIM-ONU"
+	DEFAULT_HARDWARE_VERSION = "1.0"
+	DEFAULT_FIRMWARE_VERSION = "1.0.0"
+
+	// Prefix of the serial numbers derived for the OLTs
+	OLT_SERIAL_PREFIX = "PSOL"
+)
+
+/*
+PonSimIdentity holds the identity reported by a device, as displayed by the inventory of the
+adapters.  The attributes which are not configured take default values.
+*/
+type PonSimIdentity struct {
+	Vendor          string `json:"vendor"`
+	Model           string `json:"model"`
+	HardwareVersion string `json:"hardware_version"`
+	FirmwareVersion string `json:"firmware_version"`
+	SerialNumber    string `json:"serial_number"`
+}
+
+/*
+ParseIdentity parses a comma separated list of identity attributes in the format key=value,
+e.g. vendor=Acme,model=X-16,hw=2.1,fw=4.2.0,serial=ACME00001234
+*/
+func ParseIdentity(spec string) (*PonSimIdentity, error) {
+	identity := &PonSimIdentity{}
+
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		fields := strings.SplitN(entry, "=", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid identity specification: %s", entry)
+		}
+
+		switch key, value := strings.ToLower(fields[0]), fields[1]; key {
+		case "vendor":
+			identity.Vendor = value
+		case "model":
+			identity.Model = value
+		case "hw":
+			identity.HardwareVersion = value
+		case "fw":
+			identity.FirmwareVersion = value
+		case "serial":
+			identity.SerialNumber = value
+		default:
+			return nil, fmt.Errorf("unknown identity attribute: %s", fields[0])
+		}
+	}
+
+	return identity, nil
+}
+
+/*
+complete returns the identity with its missing attributes set to default values
+*/
+func (i *PonSimIdentity) complete(model string, firmwareVersion string, serialNumber string) PonSimIdentity {
+	var out PonSimIdentity
+	if i != nil {
+		out = *i
+	}
+
+	if out.Vendor == "" {
+		out.Vendor = DEFAULT_VENDOR
+	}
+	if out.Model == "" {
+		out.Model = model
+	}
+	if out.HardwareVersion == "" {
+		out.HardwareVersion = DEFAULT_HARDWARE_VERSION
+	}
+	if out.FirmwareVersion == "" {
+		out.FirmwareVersion = firmwareVersion
+	}
+	if out.SerialNumber == "" {
+		out.SerialNumber = serialNumber
+	}
+
+	return out
+}
+
+/*
+GetIdentity returns the identity of the OLT.
+
+When no serial number was configured, one is derived from the hardware address of the internal
+interface, or else from the GRPC port, so that each OLT instance is unique.
+*/
+func (o *PonSimOltDevice) GetIdentity() PonSimIdentity {
+	suffix := fmt.Sprintf("%08X", o.Port)
+	if hwAddr := common.GetMacAddress(o.InternalIf); len(hwAddr) >= 4 {
+		suffix = fmt.Sprintf("%02X%02X%02X%02X",
+			hwAddr[len(hwAddr)-4], hwAddr[len(hwAddr)-3], hwAddr[len(hwAddr)-2], hwAddr[len(hwAddr)-1])
+	}
+
+	return o.Identity.complete(DEFAULT_OLT_MODEL, DEFAULT_FIRMWARE_VERSION, OLT_SERIAL_PREFIX+suffix)
+}
+
+/*
+GetIdentity returns the identity of the ONU, whose firmware is its active software image unless
+a firmware version was configured
+*/
+func (o *PonSimOnuDevice) GetIdentity() PonSimIdentity {
+	firmwareVersion := DEFAULT_FIRMWARE_VERSION
+	images, _ := o.GetImages()
+	for _, image := range images {
+		if image.Active {
+			firmwareVersion = image.Version
+		}
+	}
+
+	return o.Identity.complete(DEFAULT_ONU_MODEL, firmwareVersion, o.GetSerialNumber())
+}
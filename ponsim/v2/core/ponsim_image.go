@@ -0,0 +1,455 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+	"hash/crc32"
+	"sync"
+	"time"
+)
+
+const (
+	// States of the download of an image to the ONU
+	IMAGE_DOWNLOAD_NONE      = "none"
+	IMAGE_DOWNLOAD_STARTED   = "downloading"
+	IMAGE_DOWNLOAD_SUCCEEDED = "succeeded"
+	IMAGE_DOWNLOAD_FAILED    = "failed"
+	IMAGE_DOWNLOAD_CANCELLED = "cancelled"
+
+	// Outcomes of the activation and of the commit of an image, reported as events
+	IMAGE_ACTIVATED         = "activated"
+	IMAGE_ACTIVATION_FAILED = "activation_failed"
+	IMAGE_COMMITTED         = "committed"
+
+	// Faults injected into the next download or activation
+	IMAGE_FAULT_NONE     = "none"
+	IMAGE_FAULT_ABORT    = "abort"    // The transfer is interrupted halfway
+	IMAGE_FAULT_CORRUPT  = "corrupt"  // The image is received with a CRC mismatch
+	IMAGE_FAULT_ACTIVATE = "activate" // The new image fails to boot, the committed one is restored
+
+	// Images are transferred through OMCI at about 128KB/s, in 100ms steps
+	DEFAULT_IMAGE_DOWNLOAD_RATE = 128 * 1024
+	IMAGE_TRANSFER_STEP         = 100 * time.Millisecond
+	MAX_IMAGE_SIZE              = 64 * 1024 * 1024
+
+	// Version of the image the ONUs are shipped with
+	DEFAULT_IMAGE_VERSION = "1.0.0"
+
+	IMAGE_SLOTS = 2
+)
+
+/*
+PonSimImage is one of the two software images of an ONU.  The ONU runs its active image and
+boots its committed image.
+*/
+type PonSimImage struct {
+	Version   string `json:"version"`
+	Size      int    `json:"size"`
+	Crc       uint32 `json:"crc"`
+	Valid     bool   `json:"valid"`
+	Active    bool   `json:"active"`
+	Committed bool   `json:"committed"`
+}
+
+/*
+PonSimImageDownload is the progress of the last download of an image to the ONU
+*/
+type PonSimImageDownload struct {
+	Version     string `json:"version"`
+	Slot        int    `json:"slot"`
+	Size        int    `json:"size"`
+	Transferred int    `json:"transferred"`
+	State       string `json:"state"`
+	Reason      string `json:"reason"`
+}
+
+/*
+PonSimImages holds the software images of an ONU and the download in progress
+*/
+type PonSimImages struct {
+	mutex    sync.Mutex
+	slots    []PonSimImage
+	download PonSimImageDownload
+	fault    string
+	cancel   context.CancelFunc
+}
+
+/*
+ImageCrc returns the CRC-32 of the simulated content of an image, made of its version
+repeated over its size
+*/
+func ImageCrc(version string, size int) uint32 {
+	return crc32.ChecksumIEEE(imageContent(version, size))
+}
+
+func imageContent(version string, size int) []byte {
+	content := make([]byte, size)
+	if version == "" {
+		return content
+	}
+	for i := 0; i < size; i += len(version) {
+		copy(content[i:], version)
+	}
+
+	return content
+}
+
+/*
+init ships the ONU with a valid image in the first slot, active and committed
+*/
+func (i *PonSimImages) init() {
+	if i.slots != nil {
+		return
+	}
+
+	i.slots = make([]PonSimImage, IMAGE_SLOTS)
+	i.slots[0] = PonSimImage{Version: DEFAULT_IMAGE_VERSION, Valid: true, Active: true, Committed: true}
+	i.download.State = IMAGE_DOWNLOAD_NONE
+}
+
+/*
+activeSlot returns the slot of the running image
+*/
+func (i *PonSimImages) activeSlot() int {
+	for slot := range i.slots {
+		if i.slots[slot].Active {
+			return slot
+		}
+	}
+
+	return 0
+}
+
+/*
+takeFault returns the fault injected into the next operation if it is of the specified kind,
+consuming it
+*/
+func (i *PonSimImages) takeFault(fault string) bool {
+	if i.fault != fault {
+		return false
+	}
+	i.fault = IMAGE_FAULT_NONE
+
+	return true
+}
+
+/*
+GetImages returns the software images of the ONU along with the progress of the last download
+*/
+func (o *PonSimOnuDevice) GetImages() ([]PonSimImage, PonSimImageDownload) {
+	o.images.mutex.Lock()
+	defer o.images.mutex.Unlock()
+
+	o.images.init()
+
+	return append([]PonSimImage(nil), o.images.slots...), o.images.download
+}
+
+/*
+SetImageFault injects a fault into the next download or activation of an image
+*/
+func (o *PonSimOnuDevice) SetImageFault(fault string) error {
+	switch fault {
+	case IMAGE_FAULT_NONE, IMAGE_FAULT_ABORT, IMAGE_FAULT_CORRUPT, IMAGE_FAULT_ACTIVATE:
+	default:
+		return fmt.Errorf("unknown image fault: %s", fault)
+	}
+
+	o.images.mutex.Lock()
+	defer o.images.mutex.Unlock()
+
+	o.images.fault = fault
+
+	return nil
+}
+
+/*
+StartImageDownload starts the transfer of an image into the inactive slot of the ONU, at the
+specified rate in bytes per second.  The image is validated against the expected CRC once
+transferred, the CRC of the genuine image being expected if not set.
+*/
+func (o *PonSimOnuDevice) StartImageDownload(version string, size int, crc uint32, rate int) error {
+	if version == "" {
+		return errors.New("an image requires a version")
+	}
+	if size <= 0 || size > MAX_IMAGE_SIZE {
+		return fmt.Errorf("invalid image size %d, expected 1 to %d bytes", size, MAX_IMAGE_SIZE)
+	}
+	if rate < 0 {
+		return fmt.Errorf("invalid download rate: %d", rate)
+	} else if rate == 0 {
+		rate = DEFAULT_IMAGE_DOWNLOAD_RATE
+	}
+	if crc == 0 {
+		crc = ImageCrc(version, size)
+	}
+
+	o.images.mutex.Lock()
+	defer o.images.mutex.Unlock()
+
+	o.images.init()
+	if o.images.download.State == IMAGE_DOWNLOAD_STARTED {
+		return fmt.Errorf("image %s is being downloaded", o.images.download.Version)
+	}
+
+	// The image being downloaded replaces the inactive image, which is no longer valid
+	slot := (o.images.activeSlot() + 1) % IMAGE_SLOTS
+	o.images.slots[slot] = PonSimImage{Version: version, Size: size}
+	o.images.download = PonSimImageDownload{
+		Version: version,
+		Slot:    slot,
+		Size:    size,
+		State:   IMAGE_DOWNLOAD_STARTED,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	o.images.cancel = cancel
+
+	abort := o.images.takeFault(IMAGE_FAULT_ABORT)
+	corrupt := o.images.takeFault(IMAGE_FAULT_CORRUPT)
+	go o.transferImage(ctx, crc, rate, abort, corrupt)
+
+	common.Logger().WithFields(logrus.Fields{
+		"device":  o.Name,
+		"version": version,
+		"size":    size,
+		"slot":    slot,
+		"rate":    rate,
+	}).Info("Started image download")
+
+	o.publishEvent(newImageEvent(o.Name, o.images.download))
+
+	return nil
+}
+
+/*
+transferImage receives the sections of the image being downloaded at the download rate, then
+validates the received image against the expected CRC
+*/
+func (o *PonSimOnuDevice) transferImage(ctx context.Context, crc uint32, rate int, abort bool, corrupt bool) {
+	ticker := time.NewTicker(IMAGE_TRANSFER_STEP)
+	defer ticker.Stop()
+
+	step := int(int64(rate) * int64(IMAGE_TRANSFER_STEP) / int64(time.Second))
+	if step < 1 {
+		step = 1
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		o.images.mutex.Lock()
+		download := &o.images.download
+		if download.State != IMAGE_DOWNLOAD_STARTED {
+			o.images.mutex.Unlock()
+			return
+		}
+
+		if download.Transferred += step; download.Transferred > download.Size {
+			download.Transferred = download.Size
+		}
+
+		if abort && download.Transferred >= download.Size/2 {
+			download.Transferred = download.Size / 2
+			o.completeDownload(IMAGE_DOWNLOAD_FAILED, "transfer aborted")
+		} else if download.Transferred == download.Size {
+			content := imageContent(download.Version, download.Size)
+			if corrupt {
+				content[len(content)/2] ^= 0xff
+			}
+
+			if received := crc32.ChecksumIEEE(content); received != crc {
+				o.completeDownload(IMAGE_DOWNLOAD_FAILED, fmt.Sprintf("CRC mismatch: received %08x, expected %08x", received, crc))
+			} else {
+				image := &o.images.slots[download.Slot]
+				image.Crc = received
+				image.Valid = true
+				o.completeDownload(IMAGE_DOWNLOAD_SUCCEEDED, "")
+			}
+		}
+
+		done := download.State != IMAGE_DOWNLOAD_STARTED
+		o.images.mutex.Unlock()
+
+		if done {
+			return
+		}
+	}
+}
+
+/*
+completeDownload ends the download in progress, the images lock being held
+*/
+func (o *PonSimOnuDevice) completeDownload(state string, reason string) {
+	o.images.download.State = state
+	o.images.download.Reason = reason
+	if o.images.cancel != nil {
+		o.images.cancel()
+		o.images.cancel = nil
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"device":  o.Name,
+		"version": o.images.download.Version,
+		"state":   state,
+		"reason":  reason,
+	}).Info("Completed image download")
+
+	o.publishEvent(newImageEvent(o.Name, o.images.download))
+}
+
+/*
+CancelImageDownload stops the download in progress, leaving the inactive slot invalid
+*/
+func (o *PonSimOnuDevice) CancelImageDownload() error {
+	o.images.mutex.Lock()
+	defer o.images.mutex.Unlock()
+
+	o.images.init()
+	if o.images.download.State != IMAGE_DOWNLOAD_STARTED {
+		return errors.New("no image is being downloaded")
+	}
+	o.completeDownload(IMAGE_DOWNLOAD_CANCELLED, "cancelled")
+
+	return nil
+}
+
+/*
+ActivateImage makes the ONU run its valid inactive image, the ONU rebooting into it.  The
+image is only booted again after a reboot once committed.
+*/
+func (o *PonSimOnuDevice) ActivateImage(ctx context.Context) error {
+	o.images.mutex.Lock()
+
+	o.images.init()
+	if o.images.download.State == IMAGE_DOWNLOAD_STARTED {
+		o.images.mutex.Unlock()
+		return errors.New("an image is being downloaded")
+	}
+
+	active := o.images.activeSlot()
+	slot := (active + 1) % IMAGE_SLOTS
+	image := &o.images.slots[slot]
+	if !image.Valid {
+		o.images.mutex.Unlock()
+		return fmt.Errorf("no valid image to activate in slot %d", slot)
+	}
+
+	event := PonSimImageDownload{Version: image.Version, Slot: slot, Size: image.Size, State: IMAGE_ACTIVATED}
+	if o.images.takeFault(IMAGE_FAULT_ACTIVATE) {
+		// The ONU fails to boot the new image and falls back to its committed image
+		event.State = IMAGE_ACTIVATION_FAILED
+		event.Reason = "image failed to boot"
+		image.Valid = false
+		o.bootCommittedImage()
+	} else {
+		o.images.slots[active].Active = false
+		image.Active = true
+	}
+	o.images.mutex.Unlock()
+
+	common.Logger().WithFields(logrus.Fields{
+		"device":  o.Name,
+		"version": event.Version,
+		"slot":    slot,
+		"state":   event.State,
+	}).Info("Activated image")
+
+	o.publishEvent(newImageEvent(o.Name, event))
+	if event.State == IMAGE_ACTIVATION_FAILED {
+		o.setOnuState(ONU_STATE_FAILED, event.Reason)
+	}
+	o.restart(ctx)
+
+	if event.State == IMAGE_ACTIVATION_FAILED {
+		return fmt.Errorf("activation of image %s failed: %s", event.Version, event.Reason)
+	}
+
+	return nil
+}
+
+/*
+CommitImage makes the ONU boot its active image
+*/
+func (o *PonSimOnuDevice) CommitImage() error {
+	o.images.mutex.Lock()
+	defer o.images.mutex.Unlock()
+
+	o.images.init()
+	active := o.images.activeSlot()
+	image := &o.images.slots[active]
+	if image.Committed {
+		return fmt.Errorf("image %s is already committed", image.Version)
+	}
+
+	for slot := range o.images.slots {
+		o.images.slots[slot].Committed = slot == active
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"device":  o.Name,
+		"version": image.Version,
+		"slot":    active,
+	}).Info("Committed image")
+
+	o.publishEvent(newImageEvent(o.Name, PonSimImageDownload{
+		Version: image.Version,
+		Slot:    active,
+		Size:    image.Size,
+		State:   IMAGE_COMMITTED,
+	}))
+
+	return nil
+}
+
+/*
+bootCommittedImage makes the committed image active, as when the ONU boots, the images lock
+being held
+*/
+func (o *PonSimOnuDevice) bootCommittedImage() {
+	o.images.init()
+	for slot := range o.images.slots {
+		o.images.slots[slot].Active = o.images.slots[slot].Committed
+	}
+}
+
+/*
+newImageEvent creates an event reporting a change of state of an image of an ONU
+*/
+func newImageEvent(device string, download PonSimImageDownload) *voltha.PonSimEvent {
+	return &voltha.PonSimEvent{
+		Device: device,
+		Event: &voltha.PonSimEvent_Image{
+			Image: &voltha.PonSimImageEvent{
+				Version:     download.Version,
+				Slot:        uint32(download.Slot),
+				State:       download.State,
+				Transferred: uint32(download.Transferred),
+				Reason:      download.Reason,
+			},
+		},
+	}
+}
@@ -29,5 +29,11 @@ type PonSimInterface interface {
 
 	GetPort() int32
 
+	GetRestPort() int32
+
+	GetSocket() string
+
 	Forward(context.Context, int, gopacket.Packet) error
+
+	Reboot(context.Context) error
 }
@@ -0,0 +1,125 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"strconv"
+	"strings"
+)
+
+/*
+PonSimLocation is the geographic position of a device in decimal degrees
+*/
+type PonSimLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+/*
+PonSimInventory holds the inventory metadata of a simulated device, i.e. where it is installed
+*/
+type PonSimInventory struct {
+	Clli     string          `json:"clli"`
+	Rack     string          `json:"rack"`
+	Shelf    string          `json:"shelf"`
+	Slot     string          `json:"slot"`
+	Location *PonSimLocation `json:"location"`
+}
+
+/*
+ParseInventory parses a comma separated list of inventory attributes in the format key=value,
+e.g. clli=MTVWCA01OLT,rack=1,shelf=2,slot=3,gps=37.39:-122.08
+*/
+func ParseInventory(spec string) (*PonSimInventory, error) {
+	inventory := &PonSimInventory{}
+
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		fields := strings.SplitN(entry, "=", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid inventory specification: %s", entry)
+		}
+
+		switch key, value := strings.ToLower(fields[0]), fields[1]; key {
+		case "clli":
+			inventory.Clli = value
+		case "rack":
+			inventory.Rack = value
+		case "shelf":
+			inventory.Shelf = value
+		case "slot":
+			inventory.Slot = value
+		case "gps":
+			location, err := parseLocation(value)
+			if err != nil {
+				return nil, err
+			}
+			inventory.Location = location
+		default:
+			return nil, fmt.Errorf("unknown inventory attribute: %s", fields[0])
+		}
+	}
+
+	return inventory, nil
+}
+
+func parseLocation(spec string) (*PonSimLocation, error) {
+	fields := strings.Split(spec, ":")
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("invalid GPS location: %s", spec)
+	}
+
+	latitude, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || latitude < -90 || latitude > 90 {
+		return nil, fmt.Errorf("invalid latitude: %s", fields[0])
+	}
+
+	longitude, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || longitude < -180 || longitude > 180 {
+		return nil, fmt.Errorf("invalid longitude: %s", fields[1])
+	}
+
+	return &PonSimLocation{Latitude: latitude, Longitude: longitude}, nil
+}
+
+/*
+MakeProto converts the inventory metadata to its GRPC representation
+*/
+func (i *PonSimInventory) MakeProto() *voltha.PonSimInventory {
+	out := &voltha.PonSimInventory{}
+	if i == nil {
+		return out
+	}
+
+	out.Clli = i.Clli
+	out.Rack = i.Rack
+	out.Shelf = i.Shelf
+	out.Slot = i.Slot
+
+	if i.Location != nil {
+		out.Location = &voltha.PonSimLocation{
+			Latitude:  i.Location.Latitude,
+			Longitude: i.Location.Longitude,
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"encoding/binary"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/opencord/voltha/ponsim/v2/common"
+)
+
+const (
+	IPV4_MIN_HEADER_LENGTH = 20
+	IPV4_CHECKSUM_OFFSET   = 10
+	IPV6_HEADER_LENGTH     = 40
+	IP_DSCP_SHIFT          = 2
+	IP_DSCP_MASK           = 0xfc
+	IP_ECN_MASK            = 0x03
+)
+
+/*
+ipHeaderOffset returns the offset and ethertype of the IP header of a frame, behind any number of VLAN tags
+*/
+func ipHeaderOffset(data []byte) (int, layers.EthernetType) {
+	offset := VLAN_TAG_OFFSET
+	for offset+2 <= len(data) && isVlanTpid(binary.BigEndian.Uint16(data[offset:])) {
+		offset += VLAN_TAG_LENGTH
+	}
+	if offset+2 > len(data) {
+		return 0, 0
+	}
+	return offset + 2, layers.EthernetType(binary.BigEndian.Uint16(data[offset:]))
+}
+
+/*
+ipv4HeaderChecksum computes the checksum of an IPv4 header, skipping the checksum field itself
+*/
+func ipv4HeaderChecksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(header); i += 2 {
+		if i != IPV4_CHECKSUM_OFFSET {
+			sum += uint32(binary.BigEndian.Uint16(header[i:]))
+		}
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+/*
+setIpTrafficClass replaces the masked bits of the IPv4 type of service or IPv6 traffic class of a frame.
+The IPv4 header checksum is recomputed, whereas upper layer checksums are not affected since the traffic
+class is not part of their pseudo header. Nil is returned if the frame does not carry IP.
+*/
+func setIpTrafficClass(frame gopacket.Packet, mask uint8, value uint8) gopacket.Packet {
+	data := frame.Data()
+	offset, etherType := ipHeaderOffset(data)
+
+	switch etherType {
+	case layers.EthernetTypeIPv4:
+		if offset+IPV4_MIN_HEADER_LENGTH > len(data) {
+			return nil
+		}
+		headerLength := int(data[offset]&0x0f) * 4
+		if headerLength < IPV4_MIN_HEADER_LENGTH || offset+headerLength > len(data) {
+			return nil
+		}
+
+		rewritten := make([]byte, len(data))
+		copy(rewritten, data)
+		header := rewritten[offset : offset+headerLength]
+		header[1] = header[1]&^mask | value&mask
+		binary.BigEndian.PutUint16(header[IPV4_CHECKSUM_OFFSET:], ipv4HeaderChecksum(header))
+
+		return common.NewFrame(rewritten)
+
+	case layers.EthernetTypeIPv6:
+		if offset+IPV6_HEADER_LENGTH > len(data) {
+			return nil
+		}
+
+		rewritten := make([]byte, len(data))
+		copy(rewritten, data)
+		header := rewritten[offset:]
+		trafficClass := header[0]<<4 | header[1]>>4
+		trafficClass = trafficClass&^mask | value&mask
+		header[0] = header[0]&0xf0 | trafficClass>>4
+		header[1] = header[1]&0x0f | trafficClass<<4
+
+		return common.NewFrame(rewritten)
+	}
+
+	return nil
+}
+
+/*
+setIpDscp sets the differentiated services code point of an IP frame
+*/
+func setIpDscp(frame gopacket.Packet, dscp uint8) gopacket.Packet {
+	return setIpTrafficClass(frame, IP_DSCP_MASK, dscp<<IP_DSCP_SHIFT)
+}
+
+/*
+setIpEcn sets the explicit congestion notification bits of an IP frame
+*/
+func setIpEcn(frame gopacket.Packet, ecn uint8) gopacket.Packet {
+	return setIpTrafficClass(frame, IP_ECN_MASK, ecn)
+}
@@ -0,0 +1,511 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/sirupsen/logrus"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	IPFIX_VERSION      = 10
+	NETFLOW_V9_VERSION = 9
+
+	DEFAULT_IPFIX_PORT      = 4739
+	DEFAULT_NETFLOW_V9_PORT = 2055
+
+	// Interval at which the flow cache is scanned for expired flows
+	IPFIX_SCAN_INTERVAL = time.Second
+
+	// Interval at which the templates are sent again, as collectors may restart
+	IPFIX_TEMPLATE_REFRESH = 30 * time.Second
+
+	// Size of the exported messages, fitting an Ethernet frame
+	IPFIX_MAX_MESSAGE_SIZE = 1400
+
+	IPFIX_TEMPLATE_IPV4 = 256
+	IPFIX_TEMPLATE_IPV6 = 257
+
+	// Identifier of the observation domain (IPFIX) or source (NetFlow v9) of the exported records
+	IPFIX_OBSERVATION_DOMAIN = 1
+
+	// Values of the flowDirection and flowEndReason information elements
+	IPFIX_DIRECTION_INGRESS  = 0
+	IPFIX_DIRECTION_EGRESS   = 1
+	IPFIX_END_IDLE_TIMEOUT   = 1
+	IPFIX_END_ACTIVE_TIMEOUT = 2
+	IPFIX_END_FORCED         = 4
+)
+
+/*
+ipfixField is an information element of a template, with the NetFlow v9 field type replacing
+it when it differs
+*/
+type ipfixField struct {
+	Id        uint16
+	Length    uint16
+	NetflowId uint16
+}
+
+var ipfixCommonFields = []ipfixField{
+	{Id: 7, Length: 2},                  // sourceTransportPort
+	{Id: 11, Length: 2},                 // destinationTransportPort
+	{Id: 4, Length: 1},                  // protocolIdentifier
+	{Id: 243, Length: 2},                // dot1qVlanId
+	{Id: 245, Length: 2},                // dot1qCustomerVlanId
+	{Id: 61, Length: 1},                 // flowDirection
+	{Id: 10, Length: 4},                 // ingressInterface
+	{Id: 14, Length: 4},                 // egressInterface
+	{Id: 1, Length: 8},                  // octetDeltaCount
+	{Id: 2, Length: 8},                  // packetDeltaCount
+	{Id: 152, Length: 8, NetflowId: 22}, // flowStartMilliseconds, or FIRST_SWITCHED
+	{Id: 153, Length: 8, NetflowId: 21}, // flowEndMilliseconds, or LAST_SWITCHED
+	{Id: 136, Length: 1},                // flowEndReason
+}
+
+var ipfixTemplates = map[uint16][]ipfixField{
+	IPFIX_TEMPLATE_IPV4: append([]ipfixField{
+		{Id: 8, Length: 4},  // sourceIPv4Address
+		{Id: 12, Length: 4}, // destinationIPv4Address
+	}, ipfixCommonFields...),
+	IPFIX_TEMPLATE_IPV6: append([]ipfixField{
+		{Id: 27, Length: 16}, // sourceIPv6Address
+		{Id: 28, Length: 16}, // destinationIPv6Address
+	}, ipfixCommonFields...),
+}
+
+/*
+ipfixFlowKey identifies a flow by its 5-tuple, its VLAN tags identifying the subscriber and
+its direction through the NNI
+*/
+type ipfixFlowKey struct {
+	Source      [16]byte
+	Destination [16]byte
+	SourcePort  uint16
+	DestPort    uint16
+	Protocol    uint8
+	Ipv6        bool
+	Vlan        uint16
+	InnerVlan   uint16
+	Direction   uint8
+	Ingress     uint32
+	Egress      uint32
+}
+
+type ipfixFlow struct {
+	Octets  uint64
+	Packets uint64
+	Start   time.Time
+	End     time.Time
+}
+
+/*
+PonSimIpfixExporter maintains a cache of the IP flows crossing the NNI of an OLT and exports
+the flows to an IPFIX or NetFlow v9 collector when they expire: once no frame was seen for
+IdleTimeout, every ActiveTimeout for long lasting flows, and when the simulator stops.
+*/
+type PonSimIpfixExporter struct {
+	Collector     string        `json:"collector"`
+	Version       int           `json:"version"`
+	ActiveTimeout time.Duration `json:"active_timeout"`
+	IdleTimeout   time.Duration `json:"idle_timeout"`
+
+	conn          net.Conn
+	start         time.Time
+	cancel        context.CancelFunc
+	done          chan struct{}
+	mutex         sync.Mutex
+	cache         map[ipfixFlowKey]*ipfixFlow
+	sequence      uint32
+	templatesSent time.Time
+}
+
+/*
+ipfixRecord is an expired flow awaiting export
+*/
+type ipfixRecord struct {
+	Key    ipfixFlowKey
+	Flow   ipfixFlow
+	Reason uint8
+}
+
+/*
+NewPonSimIpfixExporter instantiates an exporter of IPFIX (version 10) or NetFlow v9 (version 9)
+records to a collector, whose port defaults to 4739 or 2055 respectively
+*/
+func NewPonSimIpfixExporter(
+	collector string,
+	version int,
+	activeTimeout time.Duration,
+	idleTimeout time.Duration,
+) (*PonSimIpfixExporter, error) {
+	port := DEFAULT_IPFIX_PORT
+	switch version {
+	case IPFIX_VERSION:
+	case NETFLOW_V9_VERSION:
+		port = DEFAULT_NETFLOW_V9_PORT
+	default:
+		return nil, fmt.Errorf("unsupported flow export version %d, expected %d or %d", version, IPFIX_VERSION, NETFLOW_V9_VERSION)
+	}
+	if activeTimeout <= 0 || idleTimeout <= 0 {
+		return nil, fmt.Errorf("invalid flow timeouts %s and %s, expected positive durations", activeTimeout, idleTimeout)
+	}
+
+	if _, _, err := net.SplitHostPort(collector); err != nil {
+		collector = net.JoinHostPort(collector, strconv.Itoa(port))
+	}
+
+	conn, err := net.Dial("udp", collector)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PonSimIpfixExporter{
+		Collector:     collector,
+		Version:       version,
+		ActiveTimeout: activeTimeout,
+		IdleTimeout:   idleTimeout,
+		conn:          conn,
+		start:         time.Now(),
+		cache:         make(map[ipfixFlowKey]*ipfixFlow),
+	}, nil
+}
+
+/*
+Start exports the expired flows until the context is cancelled or the exporter is stopped, when
+the remaining flows are exported
+*/
+func (e *PonSimIpfixExporter) Start(ctx context.Context) {
+	ctx, e.cancel = context.WithCancel(ctx)
+	e.done = make(chan struct{})
+
+	go func() {
+		defer close(e.done)
+		defer e.conn.Close()
+
+		ticker := time.NewTicker(IPFIX_SCAN_INTERVAL)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				e.export(e.expire(time.Now(), false))
+			case <-ctx.Done():
+				e.export(e.expire(time.Now(), true))
+				return
+			}
+		}
+	}()
+}
+
+/*
+Stop exports the remaining flows and waits until they are sent
+*/
+func (e *PonSimIpfixExporter) Stop() {
+	e.cancel()
+	<-e.done
+}
+
+/*
+Record accounts a frame forwarded from a port to another in the flow cache if it is an IP frame
+crossing the NNI (port 2)
+*/
+func (e *PonSimIpfixExporter) Record(port int, egressPort int, frame gopacket.Packet) {
+	if e == nil || (port != 2 && egressPort != 2) {
+		return
+	}
+
+	key := ipfixFlowKey{Ingress: uint32(port), Egress: uint32(egressPort)}
+	if port == 2 {
+		key.Direction = IPFIX_DIRECTION_INGRESS
+	} else {
+		key.Direction = IPFIX_DIRECTION_EGRESS
+	}
+
+	if ipv4, ok := frame.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+		copy(key.Source[:], ipv4.SrcIP.To4())
+		copy(key.Destination[:], ipv4.DstIP.To4())
+		key.Protocol = uint8(ipv4.Protocol)
+	} else if ipv6, ok := frame.Layer(layers.LayerTypeIPv6).(*layers.IPv6); ok {
+		copy(key.Source[:], ipv6.SrcIP.To16())
+		copy(key.Destination[:], ipv6.DstIP.To16())
+		key.Protocol = uint8(ipv6.NextHeader)
+		key.Ipv6 = true
+	} else {
+		return
+	}
+
+	if tcp, ok := frame.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+		key.SourcePort, key.DestPort = uint16(tcp.SrcPort), uint16(tcp.DstPort)
+	} else if udp, ok := frame.Layer(layers.LayerTypeUDP).(*layers.UDP); ok {
+		key.SourcePort, key.DestPort = uint16(udp.SrcPort), uint16(udp.DstPort)
+	}
+
+	// The outer tag identifies the service and the inner tag the subscriber
+	var tags []uint16
+	for _, layer := range frame.Layers() {
+		if dot1q, ok := layer.(*layers.Dot1Q); ok {
+			tags = append(tags, dot1q.VLANIdentifier)
+		}
+	}
+	if len(tags) > 0 {
+		key.Vlan = tags[0]
+	}
+	if len(tags) > 1 {
+		key.InnerVlan = tags[1]
+	}
+
+	now := time.Now()
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	flow, ok := e.cache[key]
+	if !ok {
+		flow = &ipfixFlow{Start: now}
+		e.cache[key] = flow
+	}
+	flow.Octets += uint64(len(frame.Data()))
+	flow.Packets++
+	flow.End = now
+}
+
+/*
+expire removes the flows which expired from the cache, or all the flows when forced.  An active
+flow is exported with the frames seen so far and accounted anew.
+*/
+func (e *PonSimIpfixExporter) expire(now time.Time, force bool) []ipfixRecord {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var records []ipfixRecord
+	for key, flow := range e.cache {
+		var reason uint8
+		switch {
+		case force:
+			reason = IPFIX_END_FORCED
+		case now.Sub(flow.End) >= e.IdleTimeout:
+			reason = IPFIX_END_IDLE_TIMEOUT
+		case now.Sub(flow.Start) >= e.ActiveTimeout:
+			reason = IPFIX_END_ACTIVE_TIMEOUT
+		default:
+			continue
+		}
+
+		records = append(records, ipfixRecord{Key: key, Flow: *flow, Reason: reason})
+		delete(e.cache, key)
+	}
+
+	return records
+}
+
+/*
+export sends the records to the collector, in as many messages as needed.  The templates are
+sent along with the first records and then periodically.
+*/
+func (e *PonSimIpfixExporter) export(records []ipfixRecord) {
+	for len(records) > 0 {
+		now := time.Now()
+		sets := &ipfixWriter{}
+		templateCount, recordCount := 0, 0
+
+		if now.Sub(e.templatesSent) >= IPFIX_TEMPLATE_REFRESH {
+			templates := &ipfixWriter{}
+			for _, id := range []uint16{IPFIX_TEMPLATE_IPV4, IPFIX_TEMPLATE_IPV6} {
+				templates.u16(id)
+				templates.u16(uint16(len(ipfixTemplates[id])))
+				for _, field := range ipfixTemplates[id] {
+					templates.u16(e.fieldId(field))
+					templates.u16(e.fieldLength(field))
+				}
+				templateCount++
+			}
+			sets.set(e.templateSetId(), templates)
+			e.templatesSent = now
+		}
+
+		for _, id := range []uint16{IPFIX_TEMPLATE_IPV4, IPFIX_TEMPLATE_IPV6} {
+			data := &ipfixWriter{}
+			remaining := records[:0]
+			for _, record := range records {
+				if record.template() != id || e.headerSize()+sets.Len()+4+data.Len()+e.recordSize(id) > IPFIX_MAX_MESSAGE_SIZE {
+					remaining = append(remaining, record)
+					continue
+				}
+				e.encode(data, record)
+				recordCount++
+			}
+			records = remaining
+			if data.Len() > 0 {
+				sets.set(id, data)
+			}
+		}
+
+		message := &ipfixWriter{}
+		message.u16(uint16(e.Version))
+		if e.Version == IPFIX_VERSION {
+			// The sequence of IPFIX counts the data records sent before the message
+			message.u16(uint16(e.headerSize() + sets.Len()))
+			message.u32(uint32(now.Unix()))
+			message.u32(e.sequence)
+			e.sequence += uint32(recordCount)
+		} else {
+			// The sequence of NetFlow v9 counts the messages
+			message.u16(uint16(templateCount + recordCount))
+			message.u32(e.uptime(now))
+			message.u32(uint32(now.Unix()))
+			message.u32(e.sequence)
+			e.sequence++
+		}
+		message.u32(IPFIX_OBSERVATION_DOMAIN)
+		message.Write(sets.Bytes())
+
+		if _, err := e.conn.Write(message.Bytes()); err != nil {
+			common.Logger().WithFields(logrus.Fields{
+				"collector": e.Collector,
+				"error":     err.Error(),
+			}).Warn("Failed to export flow records")
+		}
+	}
+}
+
+/*
+encode appends a data record in the order of the fields of its template
+*/
+func (e *PonSimIpfixExporter) encode(w *ipfixWriter, record ipfixRecord) {
+	key := record.Key
+	if key.Ipv6 {
+		w.Write(key.Source[:])
+		w.Write(key.Destination[:])
+	} else {
+		w.Write(key.Source[:4])
+		w.Write(key.Destination[:4])
+	}
+	w.u16(key.SourcePort)
+	w.u16(key.DestPort)
+	w.WriteByte(key.Protocol)
+	w.u16(key.Vlan)
+	w.u16(key.InnerVlan)
+	w.WriteByte(key.Direction)
+	w.u32(key.Ingress)
+	w.u32(key.Egress)
+	w.u64(record.Flow.Octets)
+	w.u64(record.Flow.Packets)
+	if e.Version == IPFIX_VERSION {
+		w.u64(uint64(record.Flow.Start.UnixNano() / int64(time.Millisecond)))
+		w.u64(uint64(record.Flow.End.UnixNano() / int64(time.Millisecond)))
+	} else {
+		w.u32(e.uptime(record.Flow.Start))
+		w.u32(e.uptime(record.Flow.End))
+	}
+	w.WriteByte(record.Reason)
+}
+
+func (e *PonSimIpfixExporter) headerSize() int {
+	if e.Version == IPFIX_VERSION {
+		return 16
+	}
+	return 20
+}
+
+func (e *PonSimIpfixExporter) templateSetId() uint16 {
+	if e.Version == IPFIX_VERSION {
+		return 2
+	}
+	return 0
+}
+
+func (e *PonSimIpfixExporter) fieldId(field ipfixField) uint16 {
+	if e.Version == NETFLOW_V9_VERSION && field.NetflowId != 0 {
+		return field.NetflowId
+	}
+	return field.Id
+}
+
+/*
+fieldLength returns the length of a field, NetFlow v9 timestamps being 32 bit uptimes
+*/
+func (e *PonSimIpfixExporter) fieldLength(field ipfixField) uint16 {
+	if e.Version == NETFLOW_V9_VERSION && field.NetflowId != 0 {
+		return 4
+	}
+	return field.Length
+}
+
+func (e *PonSimIpfixExporter) recordSize(id uint16) int {
+	size := 0
+	for _, field := range ipfixTemplates[id] {
+		size += int(e.fieldLength(field))
+	}
+	return size
+}
+
+func (e *PonSimIpfixExporter) uptime(t time.Time) uint32 {
+	return uint32(t.Sub(e.start) / time.Millisecond)
+}
+
+func (r ipfixRecord) template() uint16 {
+	if r.Key.Ipv6 {
+		return IPFIX_TEMPLATE_IPV6
+	}
+	return IPFIX_TEMPLATE_IPV4
+}
+
+/*
+ipfixWriter encodes the sets and records of IPFIX and NetFlow v9 messages
+*/
+type ipfixWriter struct {
+	bytes.Buffer
+}
+
+func (w *ipfixWriter) u16(value uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], value)
+	w.Write(b[:])
+}
+
+func (w *ipfixWriter) u32(value uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], value)
+	w.Write(b[:])
+}
+
+func (w *ipfixWriter) u64(value uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], value)
+	w.Write(b[:])
+}
+
+/*
+set appends a set of records, padded to a multiple of 4 bytes
+*/
+func (w *ipfixWriter) set(id uint16, records *ipfixWriter) {
+	padding := (4 - records.Len()%4) % 4
+	w.u16(id)
+	w.u16(uint16(4 + records.Len() + padding))
+	w.Write(records.Bytes())
+	w.Write(make([]byte, padding))
+}
@@ -0,0 +1,297 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/ponsim"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	MAX_FINISHED_JOBS = 64
+)
+
+/*
+PonSimJobState tracks the lifecycle of a long-running operation
+*/
+type PonSimJobState uint8
+
+const (
+	JOB_RUNNING PonSimJobState = iota
+	JOB_COMPLETED
+	JOB_FAILED
+	JOB_CANCELLED
+)
+
+var enum_ponsim_job_states = []string{
+	"RUNNING",
+	"COMPLETED",
+	"FAILED",
+	"CANCELLED",
+}
+
+func (s PonSimJobState) String() string {
+	return enum_ponsim_job_states[s]
+}
+
+/*
+PonSimJob is a long-running operation executed in the background.  The operation reports
+its progress through the job and must stop when the context of the job is cancelled.
+*/
+type PonSimJob struct {
+	Id        string
+	Kind      string
+	StartTime time.Time
+
+	mutex    sync.Mutex
+	ctx      context.Context
+	cancel   context.CancelFunc
+	state    PonSimJobState
+	progress float32
+	message  string
+	result   interface{}
+	endTime  time.Time
+}
+
+/*
+Context returns the context of the job, which is cancelled when the job is cancelled
+*/
+func (j *PonSimJob) Context() context.Context {
+	return j.ctx
+}
+
+/*
+SetProgress reports the completion percentage of the job along with a status message
+*/
+func (j *PonSimJob) SetProgress(progress float32, message string) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.progress = progress
+	j.message = message
+}
+
+/*
+SetResult records the outcome of the job
+*/
+func (j *PonSimJob) SetResult(result interface{}) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.result = result
+}
+
+/*
+GetState returns the current state of the job
+*/
+func (j *PonSimJob) GetState() PonSimJobState {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.state
+}
+
+func (j *PonSimJob) finish(err error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.endTime = time.Now()
+	switch {
+	case j.ctx.Err() != nil:
+		j.state = JOB_CANCELLED
+		j.message = "cancelled"
+	case err != nil:
+		j.state = JOB_FAILED
+		j.message = err.Error()
+	default:
+		j.state = JOB_COMPLETED
+		j.progress = 100
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"job":   j.Id,
+		"kind":  j.Kind,
+		"state": j.state,
+	}).Info("Job finished")
+}
+
+/*
+MakeProto converts the job to its GRPC representation
+*/
+func (j *PonSimJob) MakeProto() *ponsim.Job {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	job := &ponsim.Job{
+		Id:        j.Id,
+		Kind:      j.Kind,
+		State:     ponsim.Job_State(j.state),
+		Progress:  j.progress,
+		Message:   j.message,
+		StartTime: j.StartTime.UnixNano(),
+	}
+	if !j.endTime.IsZero() {
+		job.EndTime = j.endTime.UnixNano()
+	}
+
+	switch result := j.result.(type) {
+	case *ponsim.ConformanceReport:
+		job.Result = &ponsim.Job_Conformance{Conformance: result}
+	case *ponsim.TrafficReport:
+		job.Result = &ponsim.Job_Traffic{Traffic: result}
+	}
+
+	return job
+}
+
+/*
+PonSimJobs keeps track of the jobs of a simulator.  Running jobs are kept until they finish
+while only the most recent finished jobs are retained.
+*/
+type PonSimJobs struct {
+	mutex sync.RWMutex
+	jobs  []*PonSimJob
+}
+
+/*
+NewPonSimJobs instantiates an empty job registry
+*/
+func NewPonSimJobs() *PonSimJobs {
+	return &PonSimJobs{}
+}
+
+/*
+Start runs an operation in the background and returns the job tracking it
+*/
+func (s *PonSimJobs) Start(kind string, run func(*PonSimJob) error) *PonSimJob {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &PonSimJob{
+		Id:        uuid.New().String(),
+		Kind:      kind,
+		StartTime: time.Now(),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	s.mutex.Lock()
+	s.jobs = append(s.jobs, job)
+	s.prune()
+	s.mutex.Unlock()
+
+	common.Logger().WithFields(logrus.Fields{
+		"job":  job.Id,
+		"kind": kind,
+	}).Info("Starting job")
+
+	go func() {
+		defer cancel()
+		job.finish(run(job))
+	}()
+
+	return job
+}
+
+/*
+Get returns the job with the specified identifier
+*/
+func (s *PonSimJobs) Get(id string) (*PonSimJob, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, job := range s.jobs {
+		if job.Id == id {
+			return job, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown job %s", id)
+}
+
+/*
+List returns the known jobs, oldest first
+*/
+func (s *PonSimJobs) List() []*PonSimJob {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return append([]*PonSimJob(nil), s.jobs...)
+}
+
+/*
+Cancel requests a running job to stop
+*/
+func (s *PonSimJobs) Cancel(id string) (*PonSimJob, error) {
+	job, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.GetState() != JOB_RUNNING {
+		return nil, fmt.Errorf("job %s is not running", id)
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"job":  job.Id,
+		"kind": job.Kind,
+	}).Info("Cancelling job")
+
+	job.cancel()
+
+	return job, nil
+}
+
+/*
+CancelAll requests every running job to stop and returns the number of jobs cancelled
+*/
+func (s *PonSimJobs) CancelAll() int {
+	cancelled := 0
+	for _, job := range s.List() {
+		if job.GetState() == JOB_RUNNING {
+			job.cancel()
+			cancelled += 1
+		}
+	}
+
+	return cancelled
+}
+
+/*
+prune discards the oldest finished jobs beyond the retention limit
+*/
+func (s *PonSimJobs) prune() {
+	finished := 0
+	for _, job := range s.jobs {
+		if job.GetState() != JOB_RUNNING {
+			finished += 1
+		}
+	}
+
+	var jobs []*PonSimJob
+	for _, job := range s.jobs {
+		if finished > MAX_FINISHED_JOBS && job.GetState() != JOB_RUNNING {
+			finished -= 1
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	s.jobs = jobs
+}
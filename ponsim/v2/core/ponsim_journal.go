@@ -0,0 +1,261 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/openflow_13"
+	"github.com/sirupsen/logrus"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// Every journal record is prefixed with the length and checksum of its payload
+	journalHeaderSize = 8
+
+	// Records larger than this can only result from a corrupted header
+	journalMaxRecordSize = 64 * 1024 * 1024
+
+	// Every record holds a complete flow table, so the journal is rewritten with the last
+	// one once it holds this many records
+	journalCompactionRecords = 1000
+)
+
+/*
+PonSimFlowJournal persists flow operations to an append-only file so that the flow state
+of a device can be restored after a restart
+*/
+type PonSimFlowJournal struct {
+	Path string `json:"path"`
+
+	mutex   sync.Mutex
+	file    *os.File
+	records int
+}
+
+/*
+NewPonSimFlowJournal instantiates a flow journal backed by the specified file
+*/
+func NewPonSimFlowJournal(path string) *PonSimFlowJournal {
+	return &PonSimFlowJournal{Path: path}
+}
+
+/*
+Replay reads the journal and returns the last flow table which was recorded.
+
+A partially written record (e.g. following a crash) terminates the replay; the journal is
+then compacted to its last complete record and opened for appending.
+*/
+func (j *PonSimFlowJournal) Replay() ([]*openflow_13.OfpFlowStats, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	var flows *openflow_13.Flows
+	records := 0
+
+	if file, err := os.Open(j.Path); err == nil {
+		reader := bufio.NewReader(file)
+		for {
+			record, err := readJournalRecord(reader)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				common.Logger().WithFields(logrus.Fields{
+					"journal": j.Path,
+					"records": records,
+					"error":   err.Error(),
+				}).Warn("Discarding incomplete journal record")
+				break
+			}
+			flows = record
+			records += 1
+		}
+		file.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := j.compact(flows); err != nil {
+		return nil, err
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"journal": j.Path,
+		"records": records,
+	}).Info("Replayed flow journal")
+
+	if flows == nil {
+		return nil, nil
+	}
+	return flows.Items, nil
+}
+
+/*
+Append records a flow table and flushes it to stable storage.  The journal is compacted
+instead once it holds enough records.
+*/
+func (j *PonSimFlowJournal) Append(flows []*openflow_13.OfpFlowStats) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.file == nil {
+		file, err := os.OpenFile(j.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		j.file = file
+	}
+
+	if j.records >= journalCompactionRecords {
+		common.Logger().WithFields(logrus.Fields{
+			"journal": j.Path,
+			"records": j.records,
+		}).Debug("Compacting flow journal")
+
+		return j.compact(&openflow_13.Flows{Items: flows})
+	}
+
+	if err := writeJournalRecord(j.file, &openflow_13.Flows{Items: flows}); err != nil {
+		return err
+	}
+	j.records += 1
+
+	return nil
+}
+
+/*
+Close releases the journal file
+*/
+func (j *PonSimFlowJournal) Close() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+
+	err := j.file.Close()
+	j.file = nil
+
+	return err
+}
+
+/*
+compact rewrites the journal so that it only holds the specified flow table
+*/
+func (j *PonSimFlowJournal) compact(flows *openflow_13.Flows) error {
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+
+	tmpPath := j.Path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if flows != nil {
+		if err := writeJournalRecord(file, flows); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, j.Path); err != nil {
+		return err
+	}
+	if err := syncDirectory(filepath.Dir(j.Path)); err != nil {
+		return err
+	}
+
+	j.records = 0
+	if flows != nil {
+		j.records = 1
+	}
+	j.file, err = os.OpenFile(j.Path, os.O_WRONLY|os.O_APPEND, 0644)
+
+	return err
+}
+
+/*
+syncDirectory flushes a directory to stable storage so that the files renamed into it persist
+*/
+func syncDirectory(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+func writeJournalRecord(file *os.File, flows *openflow_13.Flows) error {
+	payload, err := proto.Marshal(flows)
+	if err != nil {
+		return err
+	}
+
+	record := make([]byte, journalHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[journalHeaderSize:], payload)
+
+	if _, err := file.Write(record); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+func readJournalRecord(reader io.Reader) (*openflow_13.Flows, error) {
+	header := make([]byte, journalHeaderSize)
+	if _, err := io.ReadFull(reader, header); err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, errors.New("truncated record header")
+	}
+
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size > journalMaxRecordSize {
+		return nil, errors.New("invalid record size")
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, errors.New("truncated record payload")
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errors.New("record checksum mismatch")
+	}
+
+	flows := &openflow_13.Flows{}
+	if err := proto.Unmarshal(payload, flows); err != nil {
+		return nil, err
+	}
+
+	return flows, nil
+}
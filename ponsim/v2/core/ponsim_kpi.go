@@ -0,0 +1,132 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"context"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	DEFAULT_KPI_TOPIC = "voltha.kpis"
+
+	// Prefix of the metric groups and alarm identifiers, followed by the name of the device
+	KPI_PREFIX = "voltha.ponsim"
+)
+
+/*
+kafkaMarshaler encodes events as VOLTHA does before publishing them on Kafka, i.e. with the
+original field names and the fields holding default values
+*/
+var kafkaMarshaler = jsonpb.Marshaler{OrigName: true, EmitDefaults: true}
+
+/*
+NewKpiEvent converts the metrics of a device to a KPI event in slice mode, with a metric group
+per port named after the prefix, the device and the port, e.g. voltha.ponsim.PON_OLT.nni
+*/
+func NewKpiEvent(metrics *voltha.PonSimMetrics, timestamp time.Time) *voltha.KpiEvent {
+	event := &voltha.KpiEvent{
+		Type:     voltha.KpiEventType_slice,
+		Ts:       epochSeconds(timestamp),
+		Prefixes: make(map[string]*voltha.MetricValuePairs),
+	}
+
+	for _, port := range metrics.Metrics {
+		pairs := &voltha.MetricValuePairs{Metrics: make(map[string]float32)}
+		for _, counter := range port.Packets {
+			pairs.Metrics[counter.Name] = float32(counter.Value)
+		}
+		event.Prefixes[KPI_PREFIX+"."+metrics.Device+"."+port.PortName] = pairs
+	}
+
+	return event
+}
+
+/*
+PonSimKpiPublisher periodically publishes the metrics of devices as KPI events on Kafka
+*/
+type PonSimKpiPublisher struct {
+	Producer *common.KafkaProducer `json:"producer"`
+	Interval time.Duration         `json:"interval"`
+
+	clock    *PonSimClock
+	counters []*PonSimMetricCounter
+}
+
+/*
+NewPonSimKpiPublisher instantiates a publisher of the counters of devices, stamped with the
+time of the simulator clock
+*/
+func NewPonSimKpiPublisher(
+	producer *common.KafkaProducer,
+	interval time.Duration,
+	clock *PonSimClock,
+	counters ...*PonSimMetricCounter,
+) *PonSimKpiPublisher {
+	return &PonSimKpiPublisher{
+		Producer: producer,
+		Interval: interval,
+		clock:    clock,
+		counters: counters,
+	}
+}
+
+/*
+Start publishes the metrics at every interval until the context is cancelled
+*/
+func (p *PonSimKpiPublisher) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(p.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.Publish()
+			case <-ctx.Done():
+				p.Producer.Close()
+				return
+			}
+		}
+	}()
+}
+
+/*
+Publish sends a KPI event for each device.  Failures are logged and the metrics are sent
+again at the next interval.
+*/
+func (p *PonSimKpiPublisher) Publish() {
+	for _, counter := range p.counters {
+		event := NewKpiEvent(counter.MakeProto(), p.clock.Now())
+
+		message, err := kafkaMarshaler.MarshalToString(event)
+		if err == nil {
+			err = p.Producer.Send([]byte(counter.Name), []byte(message))
+		}
+
+		if err != nil {
+			common.Logger().WithFields(logrus.Fields{
+				"device": counter.Name,
+				"topic":  p.Producer.Topic,
+				"error":  err.Error(),
+			}).Error("Failed to publish KPI event")
+		}
+	}
+}
@@ -0,0 +1,241 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/ponsim"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// All the traffic is carried by the first member that is up, the other one is a standby
+	LAG_ACTIVE_STANDBY = "active_standby"
+
+	// The flows are spread over the members that are up according to their MAC addresses and VLAN
+	LAG_HASH = "hash"
+
+	LAG_MEMBER_COUNT = 2
+)
+
+/*
+PonSimLagMember is one of the uplinks aggregated on the NNI of the OLT
+*/
+type PonSimLagMember struct {
+	Member   uint32 `json:"member"`
+	Up       bool   `json:"up"`
+	RxFrames int64  `json:"rx_frames"`
+	TxFrames int64  `json:"tx_frames"`
+}
+
+/*
+PonSimLag simulates a link aggregation group of two uplinks on the NNI of the OLT.  The frames
+still go through the single NNI interface, the group decides which member carries them and
+drops them when all the members are down.
+*/
+type PonSimLag struct {
+	Mode    string             `json:"mode"`
+	Members []*PonSimLagMember `json:"members"`
+
+	mutex sync.RWMutex
+}
+
+/*
+NewPonSimLag instantiates a link aggregation group whose members are all up, or returns nil
+when no mode is specified
+*/
+func NewPonSimLag(mode string) (*PonSimLag, error) {
+	if mode == "" {
+		return nil, nil
+	}
+
+	switch mode {
+	case LAG_ACTIVE_STANDBY, LAG_HASH:
+	default:
+		return nil, fmt.Errorf("unknown LAG mode: %s", mode)
+	}
+
+	lag := &PonSimLag{Mode: mode}
+	for i := 0; i < LAG_MEMBER_COUNT; i++ {
+		lag.Members = append(lag.Members, &PonSimLagMember{Member: uint32(i), Up: true})
+	}
+
+	return lag, nil
+}
+
+/*
+SetMember changes the status of a member of the group and reports whether it changed
+*/
+func (l *PonSimLag) SetMember(member uint32, up bool) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if int(member) >= len(l.Members) {
+		return false, fmt.Errorf("invalid LAG member %d, expected 0 to %d", member, len(l.Members)-1)
+	}
+	if l.Members[member].Up == up {
+		return false, nil
+	}
+	l.Members[member].Up = up
+
+	return true, nil
+}
+
+/*
+selectMember returns the member carrying a frame, or nil when all the members are down
+*/
+func (l *PonSimLag) selectMember(frame gopacket.Packet) *PonSimLagMember {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	var up []*PonSimLagMember
+	for _, member := range l.Members {
+		if member.Up {
+			up = append(up, member)
+		}
+	}
+	if len(up) == 0 {
+		return nil
+	}
+	if l.Mode != LAG_HASH || len(up) == 1 {
+		return up[0]
+	}
+
+	return up[hashFrame(frame)%uint32(len(up))]
+}
+
+/*
+hashFrame computes the hash used to spread the flows, so that all the frames of a subscriber
+use the same member
+*/
+func hashFrame(frame gopacket.Packet) uint32 {
+	h := fnv.New32a()
+
+	eth := common.GetEthernetLayer(frame)
+	h.Write(eth.SrcMAC)
+	h.Write(eth.DstMAC)
+	if dot1q := common.GetDot1QLayer(frame); dot1q != nil {
+		vlan := make([]byte, 2)
+		binary.BigEndian.PutUint16(vlan, dot1q.VLANIdentifier)
+		h.Write(vlan)
+	}
+
+	return h.Sum32()
+}
+
+/*
+Receive selects the member on which a frame arrives from the network, or returns false when
+the frame is lost because all the members are down
+*/
+func (l *PonSimLag) Receive(frame gopacket.Packet) bool {
+	member := l.selectMember(frame)
+	if member == nil {
+		return false
+	}
+	atomic.AddInt64(&member.RxFrames, 1)
+
+	return true
+}
+
+/*
+wrap returns an INGRESS function sending frames through the group before forwarding them
+*/
+func (l *PonSimLag) wrap(device string, forward func(int, gopacket.Packet)) func(int, gopacket.Packet) {
+	return func(port int, frame gopacket.Packet) {
+		member := l.selectMember(frame)
+		if member == nil {
+			forwardingLogger.WithFields(logrus.Fields{
+				"device": device,
+				"port":   port,
+				"frame":  frame,
+			}).Warn("Dropping frame, all LAG members are down")
+			return
+		}
+		atomic.AddInt64(&member.TxFrames, 1)
+
+		forward(port, frame)
+	}
+}
+
+/*
+MakeProto returns the status of the group and of its members
+*/
+func (l *PonSimLag) MakeProto() *ponsim.LagStatus {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	status := &ponsim.LagStatus{Mode: l.Mode}
+	active := true
+	for _, member := range l.Members {
+		status.Members = append(status.Members, &ponsim.LagMember{
+			Member:   member.Member,
+			Up:       member.Up,
+			Active:   member.Up && (l.Mode == LAG_HASH || active),
+			RxFrames: atomic.LoadInt64(&member.RxFrames),
+			TxFrames: atomic.LoadInt64(&member.TxFrames),
+		})
+		if member.Up {
+			active = false
+		}
+	}
+
+	return status
+}
+
+/*
+SetLagMember fails or restores a member of the link aggregation group of the NNI
+*/
+func (o *PonSimOltDevice) SetLagMember(member uint32, up bool) error {
+	if o.Lag == nil {
+		return fmt.Errorf("no LAG is configured on the NNI")
+	}
+
+	changed, err := o.Lag.SetMember(member, up)
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		common.Logger().WithFields(logrus.Fields{
+			"device": o,
+			"member": member,
+			"up":     up,
+		}).Info("Changed LAG member status")
+
+		o.publishEvent(newLagMemberEvent(o.Name, member, up))
+	}
+
+	return nil
+}
+
+/*
+newLagMemberEvent creates an event reporting the status of a member of the NNI link aggregation group
+*/
+func newLagMemberEvent(device string, member uint32, up bool) *voltha.PonSimEvent {
+	return &voltha.PonSimEvent{
+		Device: device,
+		Event: &voltha.PonSimEvent_LagMember{
+			LagMember: &voltha.PonSimLagMemberStatus{Member: member, Up: up},
+		},
+	}
+}
@@ -0,0 +1,340 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/ponsim"
+	"github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// Logical port of the NNI reported to VOLTHA, which identifies the NNI in the packet-ins
+	NNI_LOGICAL_PORT = 0
+
+	DEFAULT_LLDP_PORT_ID = "nni"
+	DEFAULT_LLDP_SRC_MAC = "02:00:00:00:00:fe"
+
+	// The neighbours keep the information for a few intervals, as with the default hold
+	// multiplier of 802.1AB
+	LLDP_HOLD_MULTIPLIER = 4
+	LLDP_MAX_ID_LENGTH   = 255
+	LLDP_MAX_TTL         = 65535
+)
+
+var (
+	lldpMulticast = net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e}
+)
+
+/*
+PonSimLldpNeighbor is the system last announced by LLDP on the NNI
+*/
+type PonSimLldpNeighbor struct {
+	ChassisId string    `json:"chassis_id"`
+	PortId    string    `json:"port_id"`
+	Ttl       uint16    `json:"ttl"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+/*
+PonSimLldp emits LLDP frames periodically on the NNI of an OLT and keeps track of the neighbour
+announced by the LLDP frames it receives
+*/
+type PonSimLldp struct {
+	mutex     sync.Mutex
+	ChassisId string              `json:"chassis_id"`
+	PortId    string              `json:"port_id"`
+	Interval  time.Duration       `json:"interval"`
+	Sent      uint64              `json:"sent"`
+	Received  uint64              `json:"received"`
+	Neighbor  *PonSimLldpNeighbor `json:"neighbor"`
+
+	srcMac net.HardwareAddr
+	send   func(gopacket.Packet)
+	stop   chan struct{}
+}
+
+/*
+NewPonSimLldp instantiates the LLDP agent of an NNI, which does not emit frames when the
+interval is zero
+*/
+func NewPonSimLldp(chassisId string, portId string, interval time.Duration) (*PonSimLldp, error) {
+	lldp := &PonSimLldp{}
+	if err := lldp.validate(chassisId, portId, interval); err != nil {
+		return nil, err
+	}
+	lldp.ChassisId, lldp.PortId, lldp.Interval = chassisId, portId, interval
+
+	return lldp, nil
+}
+
+/*
+validate verifies the identifiers and the interval of the LLDP frames
+*/
+func (l *PonSimLldp) validate(chassisId string, portId string, interval time.Duration) error {
+	if chassisId == "" || len(chassisId) >= LLDP_MAX_ID_LENGTH {
+		return fmt.Errorf("invalid LLDP chassis ID: %s", chassisId)
+	}
+	if portId == "" || len(portId) >= LLDP_MAX_ID_LENGTH {
+		return fmt.Errorf("invalid LLDP port ID: %s", portId)
+	}
+	if interval < 0 || interval*LLDP_HOLD_MULTIPLIER > LLDP_MAX_TTL*time.Second {
+		return fmt.Errorf("invalid LLDP interval: %s", interval)
+	}
+
+	return nil
+}
+
+/*
+Start emits the LLDP frames from a MAC address through the send function until the agent is
+stopped
+*/
+func (l *PonSimLldp) Start(srcMac net.HardwareAddr, send func(gopacket.Packet)) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if len(srcMac) == 0 {
+		srcMac, _ = net.ParseMAC(DEFAULT_LLDP_SRC_MAC)
+	}
+	l.srcMac, l.send = srcMac, send
+	l.restart()
+}
+
+/*
+Stop halts the emission of LLDP frames
+*/
+func (l *PonSimLldp) Stop() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.stop != nil {
+		close(l.stop)
+		l.stop = nil
+	}
+	l.send = nil
+}
+
+/*
+Set changes the identifiers and the interval of the LLDP frames, the identifiers being kept
+when empty and the emission being stopped when the interval is zero
+*/
+func (l *PonSimLldp) Set(chassisId string, portId string, interval time.Duration) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	chassisId = withDefault(chassisId, l.ChassisId)
+	portId = withDefault(portId, l.PortId)
+	if err := l.validate(chassisId, portId, interval); err != nil {
+		return err
+	}
+	l.ChassisId, l.PortId, l.Interval = chassisId, portId, interval
+
+	if l.send != nil {
+		l.restart()
+	}
+
+	return nil
+}
+
+/*
+restart replaces the loop emitting the frames with one using the current interval, the first
+frame being sent immediately so that the neighbours learn the changes
+*/
+func (l *PonSimLldp) restart() {
+	if l.stop != nil {
+		close(l.stop)
+		l.stop = nil
+	}
+	if l.Interval == 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	l.stop = stop
+	interval, send := l.Interval, l.send
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if frame := l.makeFrame(); frame != nil {
+				send(frame)
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+/*
+makeFrame builds an LLDP frame announcing the chassis and port IDs
+*/
+func (l *PonSimLldp) makeFrame() gopacket.Packet {
+	l.mutex.Lock()
+	chassisId, portId, interval, srcMac := l.ChassisId, l.PortId, l.Interval, l.srcMac
+	l.mutex.Unlock()
+
+	frame, err := common.SerializeFrame(
+		gopacket.SerializeOptions{},
+		&layers.Ethernet{
+			SrcMAC:       srcMac,
+			DstMAC:       lldpMulticast,
+			EthernetType: layers.EthernetTypeLinkLayerDiscovery,
+		},
+		&layers.LinkLayerDiscovery{
+			ChassisID: layers.LLDPChassisID{Subtype: layers.LLDPChassisIDSubTypeLocal, ID: []byte(chassisId)},
+			PortID:    layers.LLDPPortID{Subtype: layers.LLDPPortIDSubtypeLocal, ID: []byte(portId)},
+			TTL:       uint16(interval * LLDP_HOLD_MULTIPLIER / time.Second),
+		},
+	)
+	if err != nil {
+		common.Logger().WithFields(logrus.Fields{
+			"chassisId": chassisId,
+			"portId":    portId,
+			"error":     err.Error(),
+		}).Error("Problem serializing LLDP frame")
+		return nil
+	}
+
+	l.mutex.Lock()
+	l.Sent++
+	l.mutex.Unlock()
+
+	return frame
+}
+
+/*
+Receive records the neighbour announced by an LLDP frame received on the NNI
+*/
+func (l *PonSimLldp) Receive(frame gopacket.Packet) {
+	lldpLayer := frame.Layer(layers.LayerTypeLinkLayerDiscovery)
+	if lldpLayer == nil {
+		return
+	}
+	lldp := lldpLayer.(*layers.LinkLayerDiscovery)
+
+	neighbor := &PonSimLldpNeighbor{
+		ChassisId: string(lldp.ChassisID.ID),
+		PortId:    string(lldp.PortID.ID),
+		Ttl:       lldp.TTL,
+		LastSeen:  time.Now(),
+	}
+	if lldp.ChassisID.Subtype == layers.LLDPChassisIDSubTypeMACAddr {
+		neighbor.ChassisId = net.HardwareAddr(lldp.ChassisID.ID).String()
+	}
+	if lldp.PortID.Subtype == layers.LLDPPortIDSubtypeMACAddr {
+		neighbor.PortId = net.HardwareAddr(lldp.PortID.ID).String()
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.Received++
+	l.Neighbor = neighbor
+}
+
+/*
+MakeProto returns the configuration and the counters of the LLDP agent as a GRPC message,
+along with the neighbour if it did not expire
+*/
+func (l *PonSimLldp) MakeProto() *ponsim.LldpStatus {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	status := &ponsim.LldpStatus{
+		ChassisId: l.ChassisId,
+		PortId:    l.PortId,
+		Interval:  uint32(l.Interval / time.Second),
+		TxFrames:  l.Sent,
+		RxFrames:  l.Received,
+	}
+	if n := l.Neighbor; n != nil && time.Since(n.LastSeen) < time.Duration(n.Ttl)*time.Second {
+		status.Neighbor = &ponsim.LldpNeighbor{
+			ChassisId: n.ChassisId,
+			PortId:    n.PortId,
+			Ttl:       uint32(n.Ttl),
+			Age:       uint32(time.Since(n.LastSeen) / time.Second),
+		}
+	}
+
+	return status
+}
+
+/*
+sendToNni writes a frame originated by the OLT to the NNI, where it reaches the neighbour of the
+OLT
+*/
+func (o *PonSimOltDevice) sendToNni(frame gopacket.Packet) {
+	if !o.PortStates.IsUp(2) {
+		return
+	}
+
+	o.Counter.CountTxFrame(2, len(common.GetEthernetLayer(frame).Payload))
+	o.mirrorFrame(2, MIRROR_TX, frame)
+
+	if err := o.egressHandler.WritePacketData(frame.Data()); err != nil {
+		forwardingLogger.WithFields(logrus.Fields{
+			"device": o,
+			"error":  err.Error(),
+		}).Error("Problem while sending frame on the NNI")
+	}
+}
+
+/*
+receiveLldp processes an LLDP frame received on the NNI.  Like the frames sent by the ONUs, the
+frame is tagged with the logical port it came from, the NNI, so that it is sent to VOLTHA as a
+packet-in by the controller-bound flows installed for LLDP, and dropped without such flows.
+*/
+func (o *PonSimOltDevice) receiveLldp(ctx context.Context, frame gopacket.Packet) {
+	if o.Lldp != nil {
+		o.Lldp.Receive(frame)
+	}
+
+	eth := common.GetEthernetLayer(frame)
+	tagged, err := common.SerializeFrame(
+		gopacket.SerializeOptions{},
+		&layers.Ethernet{
+			SrcMAC:       eth.SrcMAC,
+			DstMAC:       eth.DstMAC,
+			EthernetType: layers.EthernetTypeDot1Q,
+		},
+		&layers.Dot1Q{
+			VLANIdentifier: NNI_LOGICAL_PORT,
+			Type:           eth.EthernetType,
+		},
+		gopacket.Payload(eth.Payload),
+	)
+	if err != nil {
+		forwardingLogger.WithFields(logrus.Fields{
+			"device": o,
+			"error":  err.Error(),
+		}).Error("Problem tagging LLDP frame")
+		return
+	}
+
+	o.Forward(ctx, 2, tagged)
+}
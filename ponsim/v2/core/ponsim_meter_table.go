@@ -0,0 +1,241 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/openflow_13"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+/*
+PonSimMeter rate-limits the frames of the flows referencing it.  Each DROP band discards
+the frames exceeding its rate, either in kbps or in packets per second.
+*/
+type PonSimMeter struct {
+	Mod *openflow_13.OfpMeterMod
+
+	mutex   sync.Mutex
+	packets bool
+	bands   []*tokenBucket
+}
+
+/*
+NewPonSimMeter instantiates a meter from an OpenFlow meter-mod
+*/
+func NewPonSimMeter(mod *openflow_13.OfpMeterMod) (*PonSimMeter, error) {
+	kbps := mod.Flags&uint32(openflow_13.OfpMeterFlags_OFPMF_KBPS) != 0
+	packets := mod.Flags&uint32(openflow_13.OfpMeterFlags_OFPMF_PKTPS) != 0
+	burst := mod.Flags&uint32(openflow_13.OfpMeterFlags_OFPMF_BURST) != 0
+
+	if kbps == packets {
+		return nil, fmt.Errorf("meter %d must use either kbps or packets per second", mod.MeterId)
+	}
+
+	meter := &PonSimMeter{Mod: mod, packets: packets}
+
+	for _, band := range mod.Bands {
+		if band.Type != openflow_13.OfpMeterBandType_OFPMBT_DROP {
+			return nil, fmt.Errorf("unsupported band type %s for meter %d", band.Type, mod.MeterId)
+		}
+
+		var bucket *tokenBucket
+		switch {
+		case packets && burst:
+			bucket = newPacketBucket(band.Rate, band.BurstSize)
+		case packets:
+			bucket = newPacketBucket(band.Rate, 1)
+		case burst:
+			// Burst sizes of rates in kbps are expressed in kilobits
+			bucket = newTokenBucket(int(band.Rate), int(band.BurstSize)*1000/8)
+		default:
+			bucket = newTokenBucket(int(band.Rate), 0)
+		}
+		meter.bands = append(meter.bands, bucket)
+	}
+
+	return meter, nil
+}
+
+func newPacketBucket(rate uint32, size uint32) *tokenBucket {
+	if size < 1 {
+		size = 1
+	}
+	return &tokenBucket{
+		rate:   float64(rate),
+		size:   float64(size),
+		tokens: float64(size),
+		last:   time.Now(),
+	}
+}
+
+/*
+Conform reports whether a frame of the specified size is within the rate of every band
+of the meter, in which case the frame consumes its share of each band
+*/
+func (m *PonSimMeter) Conform(size int) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	cost := float64(size)
+	if m.packets {
+		cost = 1
+	}
+
+	now := time.Now()
+	for _, band := range m.bands {
+		band.refill(now)
+		if band.tokens < cost {
+			return false
+		}
+	}
+	for _, band := range m.bands {
+		band.tokens -= cost
+	}
+
+	return true
+}
+
+/*
+PonSimMeterTable holds the OpenFlow meters of a device
+*/
+type PonSimMeterTable struct {
+	mutex  sync.RWMutex
+	meters map[uint32]*PonSimMeter
+}
+
+/*
+NewPonSimMeterTable instantiates an empty meter table
+*/
+func NewPonSimMeterTable() *PonSimMeterTable {
+	return &PonSimMeterTable{meters: make(map[uint32]*PonSimMeter)}
+}
+
+/*
+Apply adds, modifies or deletes a meter following the semantics of an OpenFlow meter-mod
+*/
+func (t *PonSimMeterTable) Apply(mod *openflow_13.OfpMeterMod) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	_, exists := t.meters[mod.MeterId]
+
+	switch mod.Command {
+	case openflow_13.OfpMeterModCommand_OFPMC_ADD, openflow_13.OfpMeterModCommand_OFPMC_MODIFY:
+		if mod.Command == openflow_13.OfpMeterModCommand_OFPMC_ADD && exists {
+			return fmt.Errorf("meter %d already exists", mod.MeterId)
+		}
+		if mod.Command == openflow_13.OfpMeterModCommand_OFPMC_MODIFY && !exists {
+			return fmt.Errorf("unknown meter %d", mod.MeterId)
+		}
+		meter, err := NewPonSimMeter(mod)
+		if err != nil {
+			return err
+		}
+		t.meters[mod.MeterId] = meter
+
+	case openflow_13.OfpMeterModCommand_OFPMC_DELETE:
+		if mod.MeterId == uint32(openflow_13.OfpMeter_OFPM_ALL) {
+			t.meters = make(map[uint32]*PonSimMeter)
+		} else {
+			delete(t.meters, mod.MeterId)
+		}
+
+	default:
+		return fmt.Errorf("unsupported meter command %s", mod.Command)
+	}
+
+	return nil
+}
+
+/*
+Get returns the meter with the specified identifier, if any
+*/
+func (t *PonSimMeterTable) Get(meterId uint32) *PonSimMeter {
+	if t == nil {
+		return nil
+	}
+
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	return t.meters[meterId]
+}
+
+/*
+UpdateMeters applies meter-mods to the meter table of the device
+*/
+func (o *PonSimDevice) UpdateMeters(ctx context.Context, mods []*openflow_13.OfpMeterMod) error {
+	if o.meters == nil {
+		o.meters = NewPonSimMeterTable()
+	}
+
+	for _, mod := range mods {
+		common.Logger().WithFields(logrus.Fields{
+			"device":  o,
+			"command": mod.Command,
+			"meter":   mod.MeterId,
+			"flags":   mod.Flags,
+		}).Debug("Updating meter")
+
+		if err := o.meters.Apply(mod); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+meterFrame enforces the meters referenced by the instructions of a flow and reports whether
+the frame may be processed further.  Flows referencing an unknown meter are not rate-limited.
+*/
+func (o *PonSimDevice) meterFrame(flow *openflow_13.OfpFlowStats, port int, frame gopacket.Packet) bool {
+	for _, instruction := range flow.Instructions {
+		if instruction.Type != uint32(openflow_13.OfpInstructionType_OFPIT_METER) {
+			continue
+		}
+
+		meterId := instruction.GetMeter().GetMeterId()
+		meter := o.meters.Get(meterId)
+		if meter == nil {
+			forwardingLogger.WithFields(logrus.Fields{
+				"device": o,
+				"flow":   flow,
+				"meter":  meterId,
+			}).Warn("Unknown meter")
+			continue
+		}
+
+		if !meter.Conform(len(frame.Data())) {
+			o.Counter.CountDroppedFrame(port)
+			forwardingLogger.WithFields(logrus.Fields{
+				"device": o,
+				"port":   port,
+				"meter":  meterId,
+				"frame":  frame,
+			}).Debug("Dropping frame exceeding meter rate")
+			return false
+		}
+	}
+
+	return true
+}
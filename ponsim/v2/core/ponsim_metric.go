@@ -16,9 +16,12 @@
 package core
 
 import (
+	"encoding/json"
 	"github.com/opencord/voltha/ponsim/v2/common"
 	"github.com/opencord/voltha/protos/go/voltha"
 	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
 )
 
 /*
@@ -110,12 +113,28 @@ func (t rxMetricCounterType) String() string {
 }
 
 /*
-
  */
 type PonSimMetricCounter struct {
 	Name       string
 	TxCounters map[txMetricCounterType]*metricCounter
 	RxCounters map[rxMetricCounterType]*metricCounter
+	Dropped    [2]int // [PON,NNI] frames dropped by fault injection
+	Corrupted  [2]int // [PON,NNI] frames corrupted by fault injection
+	HashErrors [2]int // [PON,NNI] frames received with content not matching their hash
+	Oversize   [2]int // [PON,NNI] frames received or sent exceeding the MTU of the port
+	Duplicates [2]int // [PON,NNI] frames dropped as duplicates of a recently received frame
+	GemFilter  [2]int // [PON,NNI] frames discarded because they were carried on the GEM port of another ONU
+	ToCpu      [2]int // [CONTROL,DATA] frames queued towards VOLTHA
+	CpuDropped [2]int // [CONTROL,DATA] frames dropped because the queue towards VOLTHA was full
+	Alarms     [2]int // [RAISED,CLEARED] alarms sent towards VOLTHA
+
+	FlowUpdates     [2]int        // [APPLIED,FAILED] flow table updates
+	FlowUpdateTime  time.Duration // Time spent applying flow table updates
+	FlowUpdatesPeak int           // Highest number of flow table updates applied concurrently
+	flowUpdates     int
+
+	// Frames are counted concurrently when they are processed by a worker pool
+	mutex sync.Mutex
 }
 
 /*
@@ -146,13 +165,39 @@ func NewPonSimMetricCounter(name string) *PonSimMetricCounter {
 	return counter
 }
 
+/*
+MarshalJSON serializes the counters consistently while frames are being counted, e.g. when
+logging a device
+*/
+func (mc *PonSimMetricCounter) MarshalJSON() ([]byte, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	type counters PonSimMetricCounter
+	return json.Marshal((*counters)(mc))
+}
+
+/*
+portIndex returns the index of the counters of a port: the NNI or UNI port (2) is counted apart
+from the PON ports, of which an OLT may have several
+*/
+func portIndex(port int) int {
+	if port == 2 {
+		return 1
+	}
+	return 0
+}
+
 /*
 CountRxFrame increments the receive count for a specific packet size metric
 */
 func (mc *PonSimMetricCounter) CountRxFrame(port int, size int) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
 	for k, v := range mc.RxCounters {
 		if size >= v.Min && size <= v.Max {
-			mc.RxCounters[k].Value[port-1] += 1
+			mc.RxCounters[k].Value[portIndex(port)] += 1
 		}
 	}
 }
@@ -161,17 +206,177 @@ func (mc *PonSimMetricCounter) CountRxFrame(port int, size int) {
 CountTxFrame increments the transmit count for a specific packet size metric
 */
 func (mc *PonSimMetricCounter) CountTxFrame(port int, size int) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
 	for k, v := range mc.TxCounters {
 		if size >= v.Min && size <= v.Max {
-			mc.TxCounters[k].Value[port-1] += 1
+			mc.TxCounters[k].Value[portIndex(port)] += 1
+		}
+	}
+}
+
+/*
+CountDroppedFrame increments the count of frames dropped on a port
+*/
+func (mc *PonSimMetricCounter) CountDroppedFrame(port int) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	mc.Dropped[portIndex(port)] += 1
+}
+
+/*
+CountCorruptedFrame increments the count of frames corrupted on a port
+*/
+func (mc *PonSimMetricCounter) CountCorruptedFrame(port int) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	mc.Corrupted[portIndex(port)] += 1
+}
+
+/*
+CountHashError increments the count of frames received on a port which failed their integrity check
+*/
+func (mc *PonSimMetricCounter) CountHashError(port int) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	mc.HashErrors[portIndex(port)] += 1
+}
+
+/*
+CountOversizeFrame increments the count of frames dropped for exceeding the MTU of a port
+*/
+func (mc *PonSimMetricCounter) CountOversizeFrame(port int) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	mc.Oversize[portIndex(port)] += 1
+}
+
+/*
+CountDuplicateFrame increments the count of frames received on a port which duplicate a recent frame
+*/
+func (mc *PonSimMetricCounter) CountDuplicateFrame(port int) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	mc.Duplicates[portIndex(port)] += 1
+}
+
+/*
+CountGemFilteredFrame increments the count of frames received on a port which were carried on the GEM port of another ONU
+*/
+func (mc *PonSimMetricCounter) CountGemFilteredFrame(port int) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	mc.GemFilter[portIndex(port)] += 1
+}
+
+/*
+CountCpuFrame increments the count of control or data frames sent towards VOLTHA
+*/
+func (mc *PonSimMetricCounter) CountCpuFrame(control bool, dropped bool) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	index := 1
+	if control {
+		index = 0
+	}
+
+	if dropped {
+		mc.CpuDropped[index] += 1
+	} else {
+		mc.ToCpu[index] += 1
+	}
+}
+
+/*
+CountAlarm increments the count of alarms raised or cleared
+*/
+func (mc *PonSimMetricCounter) CountAlarm(raised bool) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	if raised {
+		mc.Alarms[0] += 1
+	} else {
+		mc.Alarms[1] += 1
+	}
+}
+
+/*
+StartFlowUpdate records that a flow table update is being applied
+*/
+func (mc *PonSimMetricCounter) StartFlowUpdate() {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	mc.flowUpdates += 1
+	if mc.flowUpdates > mc.FlowUpdatesPeak {
+		mc.FlowUpdatesPeak = mc.flowUpdates
+	}
+}
+
+/*
+EndFlowUpdate counts a flow table update once applied, along with the time it took
+*/
+func (mc *PonSimMetricCounter) EndFlowUpdate(failed bool, elapsed time.Duration) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	mc.flowUpdates -= 1
+	mc.FlowUpdateTime += elapsed
+	if failed {
+		mc.FlowUpdates[1] += 1
+	} else {
+		mc.FlowUpdates[0] += 1
+	}
+}
+
+/*
+Restore sets the counters to the values of previously saved counters, e.g. after a restart
+*/
+func (mc *PonSimMetricCounter) Restore(saved *PonSimMetricCounter) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	for name, counter := range saved.TxCounters {
+		if current, ok := mc.TxCounters[name]; ok {
+			current.Value = counter.Value
 		}
 	}
+	for name, counter := range saved.RxCounters {
+		if current, ok := mc.RxCounters[name]; ok {
+			current.Value = counter.Value
+		}
+	}
+
+	mc.Dropped = saved.Dropped
+	mc.Corrupted = saved.Corrupted
+	mc.HashErrors = saved.HashErrors
+	mc.Oversize = saved.Oversize
+	mc.Duplicates = saved.Duplicates
+	mc.GemFilter = saved.GemFilter
+	mc.ToCpu = saved.ToCpu
+	mc.CpuDropped = saved.CpuDropped
+	mc.Alarms = saved.Alarms
+	mc.FlowUpdates = saved.FlowUpdates
+	mc.FlowUpdateTime = saved.FlowUpdateTime
+	mc.FlowUpdatesPeak = saved.FlowUpdatesPeak
 }
 
 /*
 LogCounts logs the current counts for all RX/TX packets
 */
 func (mc *PonSimMetricCounter) LogCounts() {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
 	common.Logger().WithFields(logrus.Fields{
 		"counters": mc.RxCounters,
 	}).Info("RX Metrics")
@@ -184,6 +389,9 @@ func (mc *PonSimMetricCounter) LogCounts() {
 MakeProto collects all RX/TX metrics with which it constructs a GRPC proto metrics structure
 */
 func (mc *PonSimMetricCounter) MakeProto() *voltha.PonSimMetrics {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
 	simMetrics := &voltha.PonSimMetrics{Device: mc.Name}
 	ponMetrics := &voltha.PonSimPortMetrics{PortName: "pon"}
 	nniMetrics := &voltha.PonSimPortMetrics{PortName: "nni"}
@@ -227,9 +435,64 @@ func (mc *PonSimMetricCounter) MakeProto() *voltha.PonSimMetrics {
 		)
 	}
 
+	// Collect fault injection metrics
+	for i, portMetrics := range []*voltha.PonSimPortMetrics{ponMetrics, nniMetrics} {
+		portMetrics.Packets = append(
+			portMetrics.Packets,
+			&voltha.PonSimPacketCounter{
+				Name:  "rx_dropped_pkts",
+				Value: int64(mc.Dropped[i]),
+			},
+			&voltha.PonSimPacketCounter{
+				Name:  "rx_corrupted_pkts",
+				Value: int64(mc.Corrupted[i]),
+			},
+			&voltha.PonSimPacketCounter{
+				Name:  "rx_hash_errors",
+				Value: int64(mc.HashErrors[i]),
+			},
+			&voltha.PonSimPacketCounter{
+				Name:  "oversize_pkts",
+				Value: int64(mc.Oversize[i]),
+			},
+			&voltha.PonSimPacketCounter{
+				Name:  "rx_duplicate_pkts",
+				Value: int64(mc.Duplicates[i]),
+			},
+			&voltha.PonSimPacketCounter{
+				Name:  "rx_gem_filtered_pkts",
+				Value: int64(mc.GemFilter[i]),
+			},
+		)
+	}
+
+	// Collect metrics of frames trapped towards VOLTHA
+	cpuMetrics := &voltha.PonSimPortMetrics{PortName: "cpu"}
+	cpuMetrics.Packets = append(
+		cpuMetrics.Packets,
+		&voltha.PonSimPacketCounter{Name: "tx_control_pkts", Value: int64(mc.ToCpu[0])},
+		&voltha.PonSimPacketCounter{Name: "tx_data_pkts", Value: int64(mc.ToCpu[1])},
+		&voltha.PonSimPacketCounter{Name: "tx_control_dropped_pkts", Value: int64(mc.CpuDropped[0])},
+		&voltha.PonSimPacketCounter{Name: "tx_data_dropped_pkts", Value: int64(mc.CpuDropped[1])},
+		&voltha.PonSimPacketCounter{Name: "tx_alarms_raised", Value: int64(mc.Alarms[0])},
+		&voltha.PonSimPacketCounter{Name: "tx_alarms_cleared", Value: int64(mc.Alarms[1])},
+	)
+
+	// Collect provisioning metrics
+	flowMetrics := &voltha.PonSimPortMetrics{PortName: "flows"}
+	flowMetrics.Packets = append(
+		flowMetrics.Packets,
+		&voltha.PonSimPacketCounter{Name: "flow_updates", Value: int64(mc.FlowUpdates[0])},
+		&voltha.PonSimPacketCounter{Name: "flow_update_errors", Value: int64(mc.FlowUpdates[1])},
+		&voltha.PonSimPacketCounter{Name: "flow_update_time_us", Value: int64(mc.FlowUpdateTime / time.Microsecond)},
+		&voltha.PonSimPacketCounter{Name: "flow_updates_peak", Value: int64(mc.FlowUpdatesPeak)},
+	)
+
 	// Populate GRPC proto structure
 	simMetrics.Metrics = append(simMetrics.Metrics, ponMetrics)
 	simMetrics.Metrics = append(simMetrics.Metrics, nniMetrics)
+	simMetrics.Metrics = append(simMetrics.Metrics, cpuMetrics)
+	simMetrics.Metrics = append(simMetrics.Metrics, flowMetrics)
 
 	return simMetrics
 }
@@ -0,0 +1,441 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/ponsim"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Directions of the frames mirrored on a port
+const (
+	MIRROR_RX   = "rx"
+	MIRROR_TX   = "tx"
+	MIRROR_BOTH = "both"
+)
+
+var MIRROR_DIRECTIONS = []string{
+	MIRROR_RX,
+	MIRROR_TX,
+	MIRROR_BOTH,
+}
+
+const (
+	// Frames queued for a mirror stream before frames are dropped
+	MIRROR_QUEUE_DEPTH = 1024
+
+	// Snapshot length of the capture files written by the mirrors
+	MIRROR_SNAPSHOT_LEN = 65535
+)
+
+/*
+ponSimFrameFilter tells whether a frame is selected by a filter expression
+*/
+type ponSimFrameFilter func(data []byte) bool
+
+// compileFrameFilter is only set when the simulator is built with libpcap support
+var compileFrameFilter func(expression string) (ponSimFrameFilter, error)
+
+/*
+newFrameFilter compiles a tcpdump filter expression into a BPF program; an empty expression
+selects every frame
+*/
+func newFrameFilter(expression string) (ponSimFrameFilter, error) {
+	if expression == "" {
+		return nil, nil
+	}
+	if compileFrameFilter == nil {
+		return nil, fmt.Errorf("filters are not supported without libpcap")
+	}
+
+	filter, err := compileFrameFilter(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %s: %s", expression, err.Error())
+	}
+
+	return filter, nil
+}
+
+/*
+PonSimMirrorSession copies the frames received and/or sent on a port to a single destination:
+another port of the device, a capture file or a stream.  A filter expression restricts the
+frames copied, e.g. to the DHCP traffic or to the traffic of a subscriber.
+*/
+type PonSimMirrorSession struct {
+	Port            int    `json:"port"`
+	Direction       string `json:"direction"`
+	DestinationPort int    `json:"destination_port"`
+	File            string `json:"file"`
+	Filter          string `json:"filter"`
+
+	filter  ponSimFrameFilter
+	mutex   sync.Mutex
+	file    *os.File
+	writer  *pcapgo.Writer
+	frames  chan *ponsim.MirroredFrame
+	copied  uint64
+	dropped uint64
+}
+
+/*
+NewPonSimMirrorSession validates the mirror of a port, opening its capture file when it writes
+to one.  The capture files are created in the capture directory, outside of which they cannot
+be written, and cannot be written when the simulator has none.
+*/
+func NewPonSimMirrorSession(dir string, request *ponsim.MirrorRequest) (*PonSimMirrorSession, error) {
+	i, err := parseEnum(MIRROR_DIRECTIONS, withDefault(request.Direction, MIRROR_BOTH))
+	if err != nil {
+		return nil, fmt.Errorf("unknown direction: %s", request.Direction)
+	}
+
+	filter, err := newFrameFilter(request.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &PonSimMirrorSession{
+		Port:            int(request.Port),
+		Direction:       MIRROR_DIRECTIONS[i],
+		DestinationPort: int(request.DestinationPort),
+		File:            request.File,
+		Filter:          request.Filter,
+		filter:          filter,
+	}
+
+	switch {
+	case s.DestinationPort == 0 && s.File == "":
+		return nil, fmt.Errorf("missing mirror destination")
+	case s.DestinationPort != 0 && s.File != "":
+		return nil, fmt.Errorf("a mirror has a single destination")
+	case s.DestinationPort == s.Port:
+		return nil, fmt.Errorf("invalid destination port: %d", s.DestinationPort)
+	case s.File != "":
+		if dir == "" {
+			return nil, fmt.Errorf("no capture directory is configured")
+		}
+		if s.file, err = os.Create(filepath.Join(dir, filepath.Clean("/"+s.File))); err != nil {
+			return nil, fmt.Errorf("invalid capture file %s: %s", s.File, err.Error())
+		}
+		s.writer = pcapgo.NewWriter(s.file)
+		if err := s.writer.WriteFileHeader(MIRROR_SNAPSHOT_LEN, layers.LinkTypeEthernet); err != nil {
+			s.file.Close()
+			return nil, fmt.Errorf("invalid capture file %s: %s", s.File, err.Error())
+		}
+	}
+
+	return s, nil
+}
+
+/*
+newMirrorStream creates the session of a mirror stream, whose frames are delivered on a channel
+*/
+func newMirrorStream(port int, direction string, expression string) (*PonSimMirrorSession, error) {
+	i, err := parseEnum(MIRROR_DIRECTIONS, withDefault(direction, MIRROR_BOTH))
+	if err != nil {
+		return nil, fmt.Errorf("unknown direction: %s", direction)
+	}
+
+	filter, err := newFrameFilter(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PonSimMirrorSession{
+		Port:      port,
+		Direction: MIRROR_DIRECTIONS[i],
+		Filter:    expression,
+		filter:    filter,
+		frames:    make(chan *ponsim.MirroredFrame, MIRROR_QUEUE_DEPTH),
+	}, nil
+}
+
+/*
+mirrors tells whether the session copies a frame of a port in a direction
+*/
+func (s *PonSimMirrorSession) mirrors(port int, direction string, frame gopacket.Packet) bool {
+	return s.Port == port && (s.Direction == MIRROR_BOTH || s.Direction == direction) &&
+		(s.filter == nil || s.filter(frame.Data()))
+}
+
+/*
+close closes the capture file of the session, or its stream
+*/
+func (s *PonSimMirrorSession) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file != nil {
+		s.file.Close()
+		s.file, s.writer = nil, nil
+	}
+	if s.frames != nil {
+		close(s.frames)
+		s.frames = nil
+	}
+}
+
+/*
+copy writes a frame to the capture file of the session, or queues it on its stream.  Frames are
+dropped for streams which are not keeping up.
+*/
+func (s *PonSimMirrorSession) copy(direction string, timestamp time.Time, frame gopacket.Packet) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data := frame.Data()
+	switch {
+	case s.writer != nil:
+		info := gopacket.CaptureInfo{Timestamp: timestamp, CaptureLength: len(data), Length: len(data)}
+		if len(data) > MIRROR_SNAPSHOT_LEN {
+			info.CaptureLength = MIRROR_SNAPSHOT_LEN
+		}
+		if err := s.writer.WritePacket(info, data[:info.CaptureLength]); err != nil {
+			s.dropped++
+			return
+		}
+
+	case s.frames != nil:
+		select {
+		case s.frames <- &ponsim.MirroredFrame{
+			Port:      int32(s.Port),
+			Direction: direction,
+			Timestamp: timestamp.UnixNano(),
+			Payload:   data,
+		}:
+		default:
+			s.dropped++
+			return
+		}
+	}
+	s.copied++
+}
+
+/*
+MakeProto reports the configuration and counters of the session
+*/
+func (s *PonSimMirrorSession) MakeProto() *ponsim.MirrorSession {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return &ponsim.MirrorSession{
+		Port:            int32(s.Port),
+		Direction:       s.Direction,
+		DestinationPort: int32(s.DestinationPort),
+		File:            s.File,
+		Filter:          s.Filter,
+		Stream:          s.File == "" && s.DestinationPort == 0,
+		Frames:          s.copied,
+		Dropped:         s.dropped,
+	}
+}
+
+/*
+PonSimMirror holds the mirrors of the ports of a device: at most one configured mirror per
+port, and the mirror streams opened through the API
+*/
+type PonSimMirror struct {
+	mutex    sync.RWMutex
+	sessions map[int]*PonSimMirrorSession
+	streams  map[int]*PonSimMirrorSession
+	nextId   int
+}
+
+/*
+NewPonSimMirror instantiates the mirrors of a device, none being configured
+*/
+func NewPonSimMirror() *PonSimMirror {
+	return &PonSimMirror{
+		sessions: make(map[int]*PonSimMirrorSession),
+		streams:  make(map[int]*PonSimMirrorSession),
+	}
+}
+
+/*
+Set configures the mirror of a port, replacing its previous one; a nil session removes it
+*/
+func (m *PonSimMirror) Set(port int, session *PonSimMirrorSession) {
+	m.mutex.Lock()
+	previous := m.sessions[port]
+	if session != nil {
+		m.sessions[port] = session
+	} else {
+		delete(m.sessions, port)
+	}
+	m.mutex.Unlock()
+
+	if previous != nil {
+		previous.close()
+	}
+}
+
+/*
+List returns the configured mirrors and the open mirror streams, ordered by port
+*/
+func (m *PonSimMirror) List() []*PonSimMirrorSession {
+	if m == nil {
+		return nil
+	}
+
+	m.mutex.RLock()
+	sessions := make([]*PonSimMirrorSession, 0, len(m.sessions)+len(m.streams))
+	for _, session := range m.sessions {
+		sessions = append(sessions, session)
+	}
+	for _, session := range m.streams {
+		sessions = append(sessions, session)
+	}
+	m.mutex.RUnlock()
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Port < sessions[j].Port
+	})
+	return sessions
+}
+
+/*
+Subscribe opens a mirror stream of the frames of a port selected by a filter expression and
+returns its identifier along with the channel on which the mirrored frames are delivered
+*/
+func (m *PonSimMirror) Subscribe(port int, direction string, filter string) (int, <-chan *ponsim.MirroredFrame, error) {
+	session, err := newMirrorStream(port, direction, filter)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.nextId += 1
+	m.streams[m.nextId] = session
+
+	return m.nextId, session.frames, nil
+}
+
+/*
+Unsubscribe closes a mirror stream
+*/
+func (m *PonSimMirror) Unsubscribe(id int) {
+	m.mutex.Lock()
+	session, ok := m.streams[id]
+	delete(m.streams, id)
+	m.mutex.Unlock()
+
+	if ok {
+		session.close()
+	}
+}
+
+/*
+Close removes all the mirrors, closing their capture files and streams
+*/
+func (m *PonSimMirror) Close() {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	sessions := m.sessions
+	streams := m.streams
+	m.sessions = make(map[int]*PonSimMirrorSession)
+	m.streams = make(map[int]*PonSimMirrorSession)
+	m.mutex.Unlock()
+
+	for _, session := range sessions {
+		session.close()
+	}
+	for _, session := range streams {
+		session.close()
+	}
+}
+
+/*
+matching returns the mirrors copying a frame of a port in a direction
+*/
+func (m *PonSimMirror) matching(port int, direction string, frame gopacket.Packet) []*PonSimMirrorSession {
+	if m == nil {
+		return nil
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if len(m.sessions) == 0 && len(m.streams) == 0 {
+		return nil
+	}
+
+	var sessions []*PonSimMirrorSession
+	if session, ok := m.sessions[port]; ok && session.mirrors(port, direction, frame) {
+		sessions = append(sessions, session)
+	}
+	for _, session := range m.streams {
+		if session.mirrors(port, direction, frame) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
+
+/*
+mirrorFrame copies a frame received or sent on a port to the mirrors of the port.  Frames
+mirrored to another port are delivered to its links as they are, without being processed by
+the flows of the device.
+*/
+func (o *PonSimDevice) mirrorFrame(port int, direction string, frame gopacket.Packet) {
+	sessions := o.Mirror.matching(port, direction, frame)
+	if len(sessions) == 0 {
+		return
+	}
+
+	// Lazily decoded frames must be complete before being shared with the mirrors
+	common.DecodeFrame(frame)
+
+	now := o.Clock.Now()
+	for _, session := range sessions {
+		if session.DestinationPort == 0 {
+			session.copy(direction, now, frame)
+			continue
+		}
+
+		if !o.PortStates.IsUp(session.DestinationPort) {
+			continue
+		}
+		for _, link := range o.links.get(session.DestinationPort) {
+			link.(func(int, gopacket.Packet))(session.DestinationPort, frame)
+		}
+		session.mutex.Lock()
+		session.copied++
+		session.mutex.Unlock()
+	}
+
+	if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+		entry.WithFields(logrus.Fields{
+			"device":    o,
+			"port":      port,
+			"direction": direction,
+			"mirrors":   len(sessions),
+		}).Debug("Mirrored frame")
+	}
+}
@@ -0,0 +1,43 @@
+// +build cgo
+
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"sync"
+)
+
+func init() {
+	compileFrameFilter = func(expression string) (ponSimFrameFilter, error) {
+		bpf, err := pcap.NewBPF(layers.LinkTypeEthernet, MIRROR_SNAPSHOT_LEN, expression)
+		if err != nil {
+			return nil, err
+		}
+
+		// A BPF program matches a single frame at a time
+		var mutex sync.Mutex
+		return func(data []byte) bool {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			return bpf.Matches(gopacket.CaptureInfo{CaptureLength: len(data), Length: len(data)}, data)
+		}, nil
+	}
+}
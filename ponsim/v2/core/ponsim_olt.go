@@ -18,16 +18,20 @@ package core
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 	"github.com/opencord/voltha/ponsim/v2/common"
 	"github.com/opencord/voltha/protos/go/ponsim"
+	"github.com/opencord/voltha/protos/go/voltha"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -41,24 +45,97 @@ type PonSimOltDevice struct {
 	VCoreEndpoint string                  `json:vcore_ep`
 	MaxOnuCount   int                     `json:max_onu`
 	Onus          map[int32]*OnuRegistree `json:onu_registrees`
-	outgoing      chan []byte
+	PonPorts      []*PonSimPonPort        `json:"pon_ports"`
+	Outgoing      *PonSimFrameQueue       `json:"outgoing"`
+	Priorities    *PonSimPriorityQueues   `json:"priorities"`
+	OnuAuth       *PonSimOnuAuth          `json:"onu_auth"`
+	OnuActivation *PonSimOnuActivation    `json:"onu_activation"`
+	FlowShadow    *PonSimFlowShadow       `json:"-"`
+	Lag           *PonSimLag              `json:"lag"`
+	Lldp          *PonSimLldp             `json:"lldp"`
+
+	OnuQueueDepth     int           `json:"onu_queue_depth"`
+	OnuOperationDelay time.Duration `json:"onu_operation_delay"`
+	TrapQueueDepth    int           `json:"trap_queue_depth"`
+	Cascaded          bool          `json:"cascaded"`
+	control           chan gopacket.Packet
+
+	// Forward error correction and pre-FEC bit error rate of the ONUs when they register
+	FecEnabled      bool    `json:"fec_enabled"`
+	FecBitErrorRate float64 `json:"fec_bit_error_rate"`
+
+	// Launch power of the ONUs when they register and drift of the optical power levels
+	OnuTxPower   float64 `json:"onu_tx_power"`
+	OpticalDrift float64 `json:"optical_drift"`
 
 	counterLoop *common.IntervalHandler
 	alarmLoop   *common.IntervalHandler
+	alarms      *PonSimAlarm
+
+	// Ports of the ONUs registered before a restart, by serial number
+	restoredOnus map[string]int32
+
+	// The registered ONUs are also indexed by serial number and by GEM port, as they are
+	// looked up for every upstream frame and registration
+	onuMutex     sync.RWMutex
+	onusBySerial map[string]int32
+	onusByGem    map[uint32]int32
+
+	// Number of key requests to fail for each ONU
+	keyMutex  sync.Mutex
+	keyFaults map[int32]int
 }
 
 /*
-
  */
 type OnuRegistree struct {
 	Device *PonSimOnuDevice                      `json:onu_device`
 	Conn   *grpc.ClientConn                      `json:grpc_conn`
 	Client ponsim.PonSimCommonClient             `json:client`
 	Stream ponsim.PonSimCommon_ProcessDataClient `json:stream`
+	Tcont  *PonSimTcont                          `json:"tcont"`
+
+	Operations *PonSimOperationQueue `json:"operations"`
+	Ranging    *PonSimRanging        `json:"ranging"`
+	Fec        *PonSimFec            `json:"fec"`
+	Optics     *PonSimOptics         `json:"optics"`
+
+	streamMutex sync.Mutex
+
+	// Frames travelling over the fiber to and from the ONU
+	downstream *PonSimDelayQueue
+	upstream   *PonSimDelayQueue
+}
+
+/*
+Send forwards a frame on the stream to the ONU
+
+A gRPC stream does not support concurrent sends, frames forwarded by several workers are therefore
+sent one at a time.
+*/
+func (r *OnuRegistree) Send(incoming *ponsim.IncomingData) error {
+	r.streamMutex.Lock()
+	defer r.streamMutex.Unlock()
+
+	return r.Stream.Send(incoming)
+}
+
+/*
+CloseStream closes the stream to the ONU once the frames being sent have been sent
+*/
+func (r *OnuRegistree) CloseStream() (*empty.Empty, error) {
+	r.streamMutex.Lock()
+	defer r.streamMutex.Unlock()
+
+	return r.Stream.CloseAndRecv()
 }
 
 const (
 	BASE_PORT_NUMBER = 128
+
+	DEFAULT_TRAP_QUEUE_DEPTH = 64
+
+	DEFAULT_OUTGOING_QUEUE_DEPTH = 1
 )
 
 /*
@@ -66,6 +143,7 @@ NewPonSimOltDevice instantiates a new OLT device structure
 */
 func NewPonSimOltDevice(device PonSimDevice) *PonSimOltDevice {
 	olt := &PonSimOltDevice{PonSimDevice: device}
+	olt.flows = NewPonSimFlowTable()
 	return olt
 }
 
@@ -78,25 +156,133 @@ func (o *PonSimOltDevice) forwardToONU(onuPort int32) func(int, gopacket.Packet)
 		incoming := &ponsim.IncomingData{
 			Id:      "EGRESS.OLT." + ipAddress,
 			Address: ipAddress,
-			Port:    int32(port),
+			Port:    1, // The ONU receives the frame on its own PON port
 			Payload: frame.Data(),
+			Hash:    common.GetFrameHash(frame),
 		}
-		common.Logger().WithFields(logrus.Fields{
+
+		if pon := o.GetPonPort(port); pon != nil {
+			pon.CountTxFrame(len(incoming.Payload))
+		}
+
+		// The PON is a shared medium: every ONU receives the frame, carried over the GEM port
+		// of the ONU it is addressed to, and reaches the ONU after the propagation delay
+		incoming.GemPort = BROADCAST_GEM_PORT_ID
+		if targetPort, gem := o.getDownstreamGemPort(frame); gem != nil {
+			incoming.GemPort = gem.GemId
+			if targetPort == onuPort {
+				gem.CountTxFrame(len(incoming.Payload))
+			}
+		}
+		onu := o.GetOnu(onuPort)
+		if onu == nil {
+			forwardingLogger.WithFields(logrus.Fields{
+				"device": o,
+				"port":   onuPort,
+			}).Debug("Dropping downstream frame, the ONU is no longer registered")
+			return
+		}
+
+		// Each ONU receives the frame after its own propagation delay
+		if err := onu.downstream.Submit(onu.Ranging.DownstreamDelay(), func() {
+			o.deliverToOnu(port, onuPort, onu, frame, incoming)
+		}); err != nil {
+			forwardingLogger.WithFields(logrus.Fields{
+				"device": o,
+				"port":   onuPort,
+				"error":  err.Error(),
+			}).Debug("Dropping downstream frame")
+		}
+	}
+}
+
+/*
+deliverToOnu sends a downstream frame to an ONU once it has travelled over the fiber
+*/
+func (o *PonSimOltDevice) deliverToOnu(
+	port int,
+	onuPort int32,
+	onu *OnuRegistree,
+	frame gopacket.Packet,
+	incoming *ponsim.IncomingData,
+) {
+	if onu.Optics.IsLos() {
+		forwardingLogger.WithFields(logrus.Fields{
+			"device": o,
+			"port":   onuPort,
+		}).Debug("Dropping downstream frame, the ONU lost the signal")
+		return
+	}
+	if !onu.Fec.Receive(FEC_DOWNSTREAM, len(incoming.Payload)) {
+		forwardingLogger.WithFields(logrus.Fields{
+			"device": o,
+			"port":   onuPort,
+		}).Debug("Dropping downstream frame with uncorrectable bit errors")
+		return
+	}
+
+	span := common.StartFrameSpan(frame, "ForwardToONU", common.SPAN_KIND_PRODUCER)
+	span.SetAttribute("ponsim.device", o.Name)
+	span.SetAttribute("ponsim.port", port)
+	span.SetAttribute("ponsim.onu_port", onuPort)
+	span.SetAttribute("ponsim.gem_port", incoming.GemPort)
+	defer span.Finish()
+	if span != nil {
+		incoming.TraceParent = span.Context.Traceparent()
+	}
+
+	if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+		entry.WithFields(logrus.Fields{
 			"device": o,
 			"port":   port,
 			"frame":  frame,
 		}).Debug("Forwarding to ONU")
+	}
 
-		// Forward packet to ONU
-		if err := o.GetOnu(onuPort).Stream.Send(incoming); err != nil {
-			common.Logger().WithFields(logrus.Fields{
-				"device":    o,
-				"frameDump": frame.Dump(),
-				"incoming":  incoming,
-				"error":     err.Error(),
-			}).Error("A problem occurred while forwarding to ONU")
-		}
+	// Forward packet to ONU
+	if err := onu.Send(incoming); err != nil {
+		span.SetError(err)
+		forwardingLogger.WithFields(logrus.Fields{
+			"device":    o,
+			"frameDump": frame.Dump(),
+			"incoming":  incoming,
+			"error":     err.Error(),
+		}).Error("A problem occurred while forwarding to ONU")
+	}
+}
 
+/*
+getDownstreamGemPort returns the ONU to which a downstream frame is addressed, identified by its
+outer VLAN, along with the GEM port carrying the frame, or a nil GEM port when the frame is
+broadcast to all the ONUs
+*/
+func (o *PonSimOltDevice) getDownstreamGemPort(frame gopacket.Packet) (int32, *PonSimGemPort) {
+	dot1q := common.GetDot1QLayer(frame)
+	if dot1q == nil {
+		return -1, nil
+	}
+
+	onuPort := int32(dot1q.VLANIdentifier)
+	if onu := o.GetOnu(onuPort); onu != nil && onu.Tcont != nil {
+		return onuPort, onu.Tcont.GetDefaultGemPort()
+	}
+
+	return -1, nil
+}
+
+/*
+forwardToNNI defines an INGRESS function to forward a packet to the parent device through the NNI
+*/
+func (o *PonSimOltDevice) forwardToNNI() func(int, gopacket.Packet) {
+	return func(port int, frame gopacket.Packet) {
+		if err := o.egressHandler.WritePacketData(frame.Data()); err != nil {
+			forwardingLogger.WithFields(logrus.Fields{
+				"device": o,
+				"port":   port,
+				"frame":  frame,
+				"error":  err.Error(),
+			}).Error("Problem while forwarding packet to parent")
+		}
 	}
 }
 
@@ -104,19 +290,71 @@ func (o *PonSimOltDevice) forwardToONU(onuPort int32) func(int, gopacket.Packet)
 forwardToLAN defines an INGRESS function to forward a packet to VOLTHA
 */
 func (o *PonSimOltDevice) forwardToLAN() func(int, gopacket.Packet) {
+	toController := o.forwardToController()
+
 	return func(port int, frame gopacket.Packet) {
-		common.Logger().WithFields(logrus.Fields{
-			"frame": frame.Dump(),
-		}).Info("Sending packet")
+		if entry := forwardingLogger.ForFrame(logrus.InfoLevel); entry != nil {
+			entry.WithFields(logrus.Fields{
+				"frame": frame,
+			}).Info("Sending packet")
+		}
 
-		select {
-		case o.outgoing <- frame.Data():
-			common.Logger().WithFields(logrus.Fields{
-				"frame": frame.Dump(),
-			}).Info("Sent packet")
-		default:
-			common.Logger().WithFields(logrus.Fields{
-				"frame": frame.Dump(),
+		// Frames trapped by the controller-bound flows are delivered as packet-ins
+		if GetPacketIn(frame) != nil {
+			toController(port, frame)
+			return
+		}
+
+		// Control frames are queued separately so that data frames cannot delay them
+		if common.IsControlFrame(frame) {
+			select {
+			case o.control <- frame:
+				o.Counter.CountCpuFrame(true, false)
+				if entry := forwardingLogger.ForFrame(logrus.InfoLevel); entry != nil {
+					entry.WithFields(logrus.Fields{
+						"frame":   frame,
+						"control": true,
+					}).Info("Sent packet")
+				}
+			default:
+				o.Counter.CountCpuFrame(true, true)
+				forwardingLogger.WithFields(logrus.Fields{
+					"frame":   frame,
+					"control": true,
+				}).Warn("Unable to send packet")
+			}
+			return
+		}
+
+		// The policy of the queue decides which frame is dropped when VOLTHA is not keeping up,
+		// the frames being first queued by priority if they are scheduled
+		var queued bool
+		var evicted gopacket.Packet
+		if o.Priorities != nil {
+			queued, evicted = o.Priorities.Push(frame)
+		} else {
+			queued, evicted = o.Outgoing.Push(frame)
+		}
+		if evicted != nil {
+			o.Counter.CountCpuFrame(false, true)
+			forwardingLogger.WithFields(logrus.Fields{
+				"frame":   evicted,
+				"control": false,
+			}).Warn("Dropped oldest packet")
+		}
+		if queued {
+			o.Counter.CountCpuFrame(false, false)
+			if entry := forwardingLogger.ForFrame(logrus.InfoLevel); entry != nil {
+				entry.WithFields(logrus.Fields{
+					"frame":   frame,
+					"control": false,
+				}).Info("Sent packet")
+			}
+		} else {
+			o.Counter.CountCpuFrame(false, true)
+			forwardingLogger.WithFields(logrus.Fields{
+				"frame":   frame,
+				"control": false,
 			}).Warn("Unable to send packet")
 		}
 	}
@@ -132,27 +370,121 @@ func (o *PonSimOltDevice) Start(ctx context.Context) {
 	// Open network interfaces for listening
 	o.connectNetworkInterfaces()
 
-	o.outgoing = make(chan []byte, 1)
+	// A cascaded OLT writes upstream frames to its NNI, only frames sent by the parent must be read back
+	if o.Cascaded {
+		o.egressHandler.SetInboundOnly()
+	}
+
+	if o.Outgoing == nil {
+		o.Outgoing, _ = NewPonSimFrameQueue(DEFAULT_OUTGOING_QUEUE_DEPTH, QUEUE_TAIL_DROP)
+	}
+	if o.Priorities != nil {
+		o.Priorities.Start(o.Outgoing)
+	}
+	if o.TrapQueueDepth <= 0 {
+		o.TrapQueueDepth = DEFAULT_TRAP_QUEUE_DEPTH
+	}
+	o.control = make(chan gopacket.Packet, o.TrapQueueDepth)
+
+	// Restore the state saved before the last shutdown and keep saving it
+	if o.Checkpoint != nil {
+		o.restoreCheckpoint()
+		o.Checkpoint.Start(ctx, o.makeCheckpoint)
+	}
+
+	// Add INGRESS operation, the uplink frames go through the LAG of the NNI if there is one
+	toLAN := o.forwardToLAN()
+	if o.Lag != nil {
+		toLAN = o.Lag.wrap(o.Name, toLAN)
+	}
+	o.AddLink(2, 0, toLAN)
+	if o.Cascaded {
+		toNNI := o.forwardToNNI()
+		if o.Lag != nil {
+			toNNI = o.Lag.wrap(o.Name, toNNI)
+		}
+		o.AddLink(2, 1, toNNI)
+	}
+
+	// The frames trapped by the flows decomposed by VOLTHA are sent to it through the NNI
+	o.trapPort = 2
+
+	// Frames output to the controller by the flows are sent to VOLTHA as packet-ins
+	o.AddLink(int(CONTROLLER_PORT), 0, o.forwardToController())
 
-	// Add INGRESS operation
-	o.AddLink(2, 0, o.forwardToLAN())
+	// Frames received on the NNI are processed once for all the ONUs
+	go o.Listen(ctx)
+
+	// Announce the OLT to the neighbour of the NNI
+	if o.Lldp != nil {
+		o.Lldp.Start(common.GetMacAddress(o.InternalIf), o.sendToNni)
+	}
+
+	// Send the CCMs of the maintenance end points
+	if o.Cfm != nil {
+		o.Cfm.Start(o.transmitCfm(ctx))
+	}
+
+	// Start the allocation of the upstream bandwidth of the PON ports
+	for _, pon := range o.GetPonPorts() {
+		if pon.Dba != nil {
+			pon.Dba.Start()
+		}
+	}
+
+	// Accumulate the counters into PM intervals
+	if o.Pm != nil {
+		o.Pm.Start(ctx, o.Clock, o.MakeMetrics)
+	}
 
 	// Start PM counter logging
 	o.counterLoop = common.NewIntervalHandler(90, o.Counter.LogCounts)
 	o.counterLoop.Start()
 
+	// Alarms are either simulated or triggered on demand
+	o.alarms = NewPonSimAlarm(o.InternalIf, o.VCoreEndpoint, o.forwardToLAN())
+	o.alarms.clock = o.Clock
+	o.alarms.counter = o.Counter
+	o.alarms.device = o.Name
+	o.alarms.sink = o.AlarmSink
+
+	// The thresholds crossed by the PM counters are reported as alarms
+	if o.Pm != nil {
+		o.Pm.OnThresholdCrossing(o.reportThresholdCrossing())
+	}
+
+	// The optical power levels drift, the ONUs losing the signal when they fall too low
+	go o.monitorOptics(ctx)
+
+	// Inject random faults from the chaos catalog
+	if o.Chaos != nil {
+		o.Chaos.Start(ctx, o.injectChaos)
+	}
+
 	// Start alarm simulation
 	if o.AlarmsOn {
 		common.Logger().WithFields(logrus.Fields{
 			"device": o,
 		}).Debug("Starting alarm simulation")
 
-		alarms := NewPonSimAlarm(o.InternalIf, o.VCoreEndpoint, o.forwardToLAN())
-		o.alarmLoop = common.NewIntervalHandler(o.AlarmsFreq, alarms.GenerateAlarm)
+		o.alarmLoop = common.NewIntervalHandler(o.AlarmsFreq, o.alarms.GenerateAlarm)
 		o.alarmLoop.Start()
 	}
 }
 
+/*
+StopAllTraffic halts the frames generated by the simulator on the OLT: the alarm simulation
+and the chaos controller are stopped, injected faults and delays are cleared and running jobs
+are cancelled.  The ONUs are not affected.
+*/
+func (o *PonSimOltDevice) StopAllTraffic() {
+	if o.alarmLoop != nil {
+		o.alarmLoop.Stop()
+	}
+
+	o.PonSimDevice.stopTraffic()
+}
+
 /*
 Stop performs cleanup operations for an OLT device
 */
@@ -169,12 +501,169 @@ func (o *PonSimOltDevice) Stop(ctx context.Context) {
 	}
 	o.alarmLoop = nil
 
+	if o.Lldp != nil {
+		o.Lldp.Stop()
+	}
+
 	o.ingressHandler.Close()
 	o.egressHandler.Close()
 
+	for _, pon := range o.GetPonPorts() {
+		if pon.Dba != nil {
+			pon.Dba.Stop()
+		}
+	}
+	for _, onu := range o.GetOnus() {
+		onu.downstream.Stop()
+		onu.upstream.Stop()
+	}
+
+	// Release the frames waiting for room in the queue towards VOLTHA
+	if o.Priorities != nil {
+		o.Priorities.Close()
+	}
+	o.Outgoing.Close()
+
+	if o.Checkpoint != nil {
+		o.Checkpoint.save(o.makeCheckpoint())
+	}
+
 	o.PonSimDevice.Stop(ctx)
 }
 
+/*
+Reboot simulates the reboot of the OLT; flows are lost and the NBI streams are closed
+*/
+func (o *PonSimOltDevice) Reboot(ctx context.Context) error {
+	o.PonSimDevice.reboot(ctx)
+
+	return nil
+}
+
+/*
+Forward accounts for the GEM port on which a frame was received before processing it.  Frames
+sent by an ONU enter the OLT through the PON port on which the ONU is registered, once the
+upstream delay resulting from its ranging has elapsed.  Frames received on the NNI are lost
+when all the members of its LAG are down.
+*/
+func (o *PonSimOltDevice) Forward(
+	ctx context.Context,
+	port int,
+	frame gopacket.Packet,
+) error {
+	if port == 2 && o.Lag != nil && !o.Lag.Receive(frame) {
+		forwardingLogger.WithFields(logrus.Fields{
+			"device": o,
+			"port":   port,
+			"frame":  frame,
+		}).Warn("Dropping frame, all LAG members are down")
+		return nil
+	}
+
+	if gemId, ok := GemPortFromContext(ctx); ok {
+		if onuPort, gem := o.getGemPortOnu(gemId); gem != nil {
+			gem.CountRxFrame(len(frame.Data()))
+
+			// The ONU only sends the frame once the DBA grants enough bytes to its T-CONT
+			if pon := o.GetOnuPonPort(onuPort); pon != nil && pon.Dba != nil {
+				pon.Dba.Transmit(gem.AllocId, len(frame.Data()))
+			}
+
+			// The frames of each ONU reach the OLT after its own upstream delay
+			if onu := o.GetOnu(onuPort); onu != nil {
+				return onu.upstream.Submit(onu.Ranging.UpstreamDelay(), func() {
+					if err := o.receiveFromOnu(ctx, port, onuPort, onu, frame); err != nil {
+						forwardingLogger.WithFields(logrus.Fields{
+							"device": o,
+							"port":   onuPort,
+							"error":  err.Error(),
+						}).Error("Problem forwarding upstream frame")
+					}
+				})
+			}
+			return o.receiveFromOnu(ctx, port, onuPort, nil, frame)
+		} else {
+			forwardingLogger.WithFields(logrus.Fields{
+				"device": o,
+				"port":   port,
+				"gemId":  gemId,
+			}).Warn("Received frame on unknown GEM port")
+		}
+	}
+
+	return o.PonSimDevice.Forward(ctx, port, frame)
+}
+
+/*
+receiveFromOnu processes an upstream frame once it has travelled over the fiber from an ONU,
+unless the signal of the ONU is lost or the frame was corrupted on the way
+*/
+func (o *PonSimOltDevice) receiveFromOnu(
+	ctx context.Context,
+	port int,
+	onuPort int32,
+	onu *OnuRegistree,
+	frame gopacket.Packet,
+) error {
+	if onu != nil {
+		if onu.Optics.IsLos() {
+			forwardingLogger.WithFields(logrus.Fields{
+				"device": o,
+				"port":   onuPort,
+			}).Debug("Dropping upstream frame, the OLT lost the signal of the ONU")
+			return nil
+		}
+		if !onu.Fec.Receive(FEC_UPSTREAM, len(frame.Data())) {
+			forwardingLogger.WithFields(logrus.Fields{
+				"device": o,
+				"port":   onuPort,
+			}).Debug("Dropping upstream frame with uncorrectable bit errors")
+			return nil
+		}
+	}
+
+	if pon := o.GetOnuPonPort(onuPort); pon != nil && port == PonPortNumber(0) {
+		pon.CountRxFrame(len(frame.Data()))
+		port = pon.Port
+	}
+
+	return o.PonSimDevice.Forward(ctx, port, frame)
+}
+
+/*
+MakeMetrics collects the counters of the OLT, of its queues and of its PON ports as GRPC metrics
+*/
+func (o *PonSimOltDevice) MakeMetrics() *voltha.PonSimMetrics {
+	metrics := o.Counter.MakeProto()
+	if outgoing := o.GetOutgoing(); outgoing != nil {
+		metrics.Metrics = append(metrics.Metrics, outgoing.MakeProto("outgoing_queue"))
+	}
+	if o.Priorities != nil {
+		metrics.Metrics = append(metrics.Metrics, o.Priorities.MakeProto()...)
+	}
+
+	// The ONUs of the PON ports change as they register and leave
+	o.onuMutex.RLock()
+	defer o.onuMutex.RUnlock()
+
+	for _, pon := range o.GetPonPorts() {
+		metrics.Metrics = append(metrics.Metrics, pon.MakeProto())
+		if pon.Dba != nil {
+			metrics.Metrics = append(metrics.Metrics, pon.Dba.MakeProto()...)
+		}
+		for _, port := range pon.GetOnuPorts() {
+			if onu := o.Onus[port]; onu != nil && onu.Fec != nil {
+				metrics.Metrics = append(metrics.Metrics, onu.Fec.MakeProto(port))
+			}
+			if onu := o.Onus[port]; onu != nil && onu.Optics != nil {
+				metrics.Metrics = append(metrics.Metrics, onu.Optics.MakeProto(port, pon.TxPower))
+			}
+		}
+	}
+
+	return metrics
+}
+
 /*
 ConnectToRemoteOnu establishes communication to a remote ONU device
 */
@@ -199,10 +688,11 @@ func (o *PonSimOltDevice) ConnectToRemoteOnu(onu *OnuRegistree) error {
 	})
 
 	// GRPC communication needs to be secured
+	options := append(o.ApiAuth.DialOptions(), grpc.WithTransportCredentials(ta))
 	if onu.Conn, err = grpc.DialContext(
 		context.Background(),
 		host,
-		grpc.WithTransportCredentials(ta),
+		append(options, common.GrpcDialOptions()...)...,
 	); err != nil {
 		common.Logger().WithFields(logrus.Fields{
 			"device": o,
@@ -214,14 +704,15 @@ func (o *PonSimOltDevice) ConnectToRemoteOnu(onu *OnuRegistree) error {
 }
 
 /*
-Listen waits for incoming EGRESS data on the internal interface
+openOnuStream establishes the stream on which the frames are forwarded to a registered ONU
 */
-func (o *PonSimOltDevice) Listen(ctx context.Context, port int32) {
-	var reply *empty.Empty
+func (o *PonSimOltDevice) openOnuStream(ctx context.Context, port int32) {
 	var err error
 
-	// Establish a GRPC connection with the ONU
 	onu := o.GetOnu(port)
+	if onu == nil {
+		return
+	}
 
 	common.Logger().WithFields(logrus.Fields{
 		"onu": onu,
@@ -236,7 +727,7 @@ func (o *PonSimOltDevice) Listen(ctx context.Context, port int32) {
 	}
 
 	// Prepare stream to ONU to forward incoming data as needed
-	if onu.Stream, err = onu.Client.ProcessData(ctx); err != nil {
+	if onu.Stream, err = onu.Client.ProcessData(ctx, common.CompressionCallOptions(o.Compression)...); err != nil {
 		common.Logger().WithFields(logrus.Fields{
 			"device": o,
 		}).Error("Problem establishing stream to ONU")
@@ -244,8 +735,19 @@ func (o *PonSimOltDevice) Listen(ctx context.Context, port int32) {
 		return
 	}
 
+	// The ONU is only reachable once its stream is established
+	if pon := o.GetOnuPonPort(port); pon != nil {
+		o.AddLink(pon.Port, int(port), o.forwardToONU(port))
+	}
+}
+
+/*
+Listen waits for incoming EGRESS data on the internal interface
+*/
+func (o *PonSimOltDevice) Listen(ctx context.Context) {
 	defer o.egressHandler.Close()
 	packetSource := gopacket.NewPacketSource(o.egressHandler, o.egressHandler.LinkType())
+	packetSource.DecodeOptions = common.FrameDecodeOptions
 	common.Logger().WithFields(logrus.Fields{
 		"device":    o,
 		"interface": o.InternalIf,
@@ -259,6 +761,15 @@ func (o *PonSimOltDevice) Listen(ctx context.Context, port int32) {
 				"packet": packet,
 			}).Debug("Received EGRESS packet")
 
+			o.Forward(ctx, 2, packet)
+		} else if common.GetEthernetLayer(packet).EthernetType == layers.EthernetTypeLinkLayerDiscovery {
+			common.Logger().WithFields(logrus.Fields{
+				"device": o,
+				"packet": packet,
+			}).Debug("Received LLDP packet")
+
+			o.receiveLldp(ctx, packet)
+		} else if common.GetEthernetLayer(packet).EthernetType == CFM_ETHER_TYPE {
 			o.Forward(ctx, 2, packet)
 		}
 	}
@@ -267,58 +778,100 @@ func (o *PonSimOltDevice) Listen(ctx context.Context, port int32) {
 		"device": o,
 	}).Debug("No more packets to process")
 
-	if reply, err = onu.Stream.CloseAndRecv(); err != nil {
-		common.Logger().WithFields(logrus.Fields{
-			"device": o,
-			"error":  err.Error(),
-		}).Error("A problem occurred while closing client stream")
-	} else {
-		common.Logger().WithFields(logrus.Fields{
-			"device": o,
-			"reply":  reply,
-		}).Warn("Client stream closed")
+	for port, onu := range o.GetOnus() {
+		if onu.Stream == nil {
+			continue
+		}
+		if reply, err := onu.CloseStream(); err != nil {
+			common.Logger().WithFields(logrus.Fields{
+				"device": o,
+				"port":   port,
+				"error":  err.Error(),
+			}).Error("A problem occurred while closing client stream")
+		} else {
+			common.Logger().WithFields(logrus.Fields{
+				"device": o,
+				"port":   port,
+				"reply":  reply,
+			}).Warn("Client stream closed")
+		}
 	}
 }
 
 /*
-GetOnus returns the list of registered ONU devices
+GetOnus returns a snapshot of the registered ONU devices, by port
 */
 func (o *PonSimOltDevice) GetOnus() map[int32]*OnuRegistree {
-	if o.Onus == nil {
-		o.Onus = make(map[int32]*OnuRegistree)
+	o.onuMutex.RLock()
+	defer o.onuMutex.RUnlock()
+
+	onus := make(map[int32]*OnuRegistree, len(o.Onus))
+	for port, onu := range o.Onus {
+		onus[port] = onu
 	}
 
-	return o.Onus
+	return onus
 }
 
 /*
 GetOnu return a specific registered ONU
 */
 func (o *PonSimOltDevice) GetOnu(index int32) *OnuRegistree {
-	var onu *OnuRegistree
-	var ok bool
+	o.onuMutex.RLock()
+	defer o.onuMutex.RUnlock()
 
-	if onu, ok = (o.GetOnus())[index]; ok {
-		return onu
+	return o.Onus[index]
+}
+
+/*
+GetGemPort returns a GEM port assigned to one of the registered ONUs
+*/
+func (o *PonSimOltDevice) GetGemPort(gemId uint32) *PonSimGemPort {
+	_, gem := o.getGemPortOnu(gemId)
+	return gem
+}
+
+/*
+getGemPortOnu returns a GEM port along with the port of the ONU it is assigned to
+*/
+func (o *PonSimOltDevice) getGemPortOnu(gemId uint32) (int32, *PonSimGemPort) {
+	o.onuMutex.RLock()
+	defer o.onuMutex.RUnlock()
+
+	if port, ok := o.onusByGem[gemId]; ok {
+		if onu := o.Onus[port]; onu != nil && onu.Tcont != nil {
+			return port, onu.Tcont.GetGemPort(gemId)
+		}
 	}
 
-	return nil
+	return -1, nil
 }
 
-func (o *PonSimOltDevice) GetOutgoing() chan []byte {
-	return o.outgoing
+/*
+GetOutgoing returns the queue of data frames sent towards VOLTHA
+*/
+func (o *PonSimOltDevice) GetOutgoing() *PonSimFrameQueue {
+	return o.Outgoing
 }
 
 /*
-nextAvailablePort returns a port that is not already used by a registered ONU
+GetControl returns the queue of control frames trapped towards VOLTHA
 */
-func (o *PonSimOltDevice) nextAvailablePort() int32 {
-	var port int32 = BASE_PORT_NUMBER
+func (o *PonSimOltDevice) GetControl() chan gopacket.Packet {
+	return o.control
+}
 
-	if len(o.GetOnus()) < o.MaxOnuCount {
+/*
+nextAvailablePort returns a port of a PON port that is not already used by a registered ONU.
+It is called with the ONU lock held.
+*/
+func (o *PonSimOltDevice) nextAvailablePort(pon *PonSimPonPort) int32 {
+	var port int32 = pon.BaseOnuPort()
+
+	if len(pon.Onus) < o.MaxOnuCount {
 		for {
-			if o.GetOnu(port) != nil {
-				// port is already used
+			if o.Onus[port] != nil || o.isRestoredPort(port) {
+				// port is already used or reserved
 				port += 1
 			} else {
 				// port is available... use it
@@ -326,11 +879,84 @@ func (o *PonSimOltDevice) nextAvailablePort() int32 {
 			}
 		}
 	} else {
-		// OLT has reached its max number of ONUs
+		// PON port has reached its max number of ONUs
 		return -1
 	}
 }
 
+/*
+GetOnuBySerialNumber returns the port of the registered ONU using the specified serial number
+*/
+func (o *PonSimOltDevice) GetOnuBySerialNumber(serialNumber string) (int32, *OnuRegistree) {
+	o.onuMutex.RLock()
+	defer o.onuMutex.RUnlock()
+
+	if port, ok := o.onusBySerial[serialNumber]; ok {
+		return port, o.Onus[port]
+	}
+
+	return -1, nil
+}
+
+/*
+addOnuEntry records a registered ONU in the maps and indexes of the OLT and of its PON port.
+It is called with the ONU lock held.
+*/
+func (o *PonSimOltDevice) addOnuEntry(pon *PonSimPonPort, port int32, onu *OnuRegistree) {
+	if o.Onus == nil {
+		o.Onus = make(map[int32]*OnuRegistree)
+		o.onusBySerial = make(map[string]int32)
+		o.onusByGem = make(map[uint32]int32)
+	}
+
+	o.Onus[port] = onu
+	pon.Onus[port] = onu
+	if onu.Device.SerialNumber != "" {
+		o.onusBySerial[onu.Device.SerialNumber] = port
+	}
+	if onu.Tcont != nil {
+		for gemId := range onu.Tcont.GemPorts {
+			o.onusByGem[gemId] = port
+		}
+	}
+}
+
+/*
+removeOnuEntry removes a registered ONU from the maps and indexes of the OLT and of its PON port
+*/
+func (o *PonSimOltDevice) removeOnuEntry(port int32) *OnuRegistree {
+	o.onuMutex.Lock()
+	defer o.onuMutex.Unlock()
+
+	return o.deleteOnuEntry(port)
+}
+
+/*
+deleteOnuEntry removes a registered ONU from the maps and indexes of the OLT and of its PON port.
+It is called with the ONU lock held.
+*/
+func (o *PonSimOltDevice) deleteOnuEntry(port int32) *OnuRegistree {
+	onu, ok := o.Onus[port]
+	if !ok {
+		return nil
+	}
+
+	delete(o.Onus, port)
+	if pon := o.GetOnuPonPort(port); pon != nil {
+		delete(pon.Onus, port)
+	}
+	if o.onusBySerial[onu.Device.SerialNumber] == port {
+		delete(o.onusBySerial, onu.Device.SerialNumber)
+	}
+	if onu.Tcont != nil {
+		for gemId := range onu.Tcont.GemPorts {
+			delete(o.onusByGem, gemId)
+		}
+	}
+
+	return onu
+}
+
 /*
 AddOnu registers an ONU device and sets up all required monitoring and connections
 */
@@ -338,27 +964,104 @@ func (o *PonSimOltDevice) AddOnu(onu *PonSimOnuDevice) (int32, error) {
 	var portNum int32
 	ctx := context.Background()
 
-	if portNum = o.nextAvailablePort(); portNum != -1 {
+	ranging, err := NewPonSimRanging(onu.Distance)
+	if err != nil {
 		common.Logger().WithFields(logrus.Fields{
-			"device": o,
-			"port":   portNum,
-			"onu":    onu,
+			"device":       o,
+			"serialNumber": onu.SerialNumber,
+			"distance":     onu.Distance,
+		}).Warn("ONU failed ranging")
+
+		return -1, err
+	}
+
+	fec, err := NewPonSimFec(o.FecEnabled, o.FecBitErrorRate)
+	if err != nil {
+		return -1, err
+	}
+
+	optics, err := NewPonSimOptics(o.OnuTxPower, onu.Distance, o.OpticalDrift)
+	if err != nil {
+		return -1, err
+	}
+
+	// Registrations are serialized so that concurrent ONUs are assigned distinct ports, and
+	// are checked for duplicate serial numbers
+	o.onuMutex.Lock()
+	defer o.onuMutex.Unlock()
+
+	if port, ok := o.onusBySerial[onu.SerialNumber]; ok {
+		existing := o.Onus[port]
+		if existing.Device.Address != onu.Address || existing.Device.Port != onu.Port {
+			common.Logger().WithFields(logrus.Fields{
+				"device":       o,
+				"port":         port,
+				"serialNumber": onu.SerialNumber,
+			}).Warn("An ONU with the same serial number is already registered")
+
+			return -1, fmt.Errorf("serial number %s is already registered on port %d", onu.SerialNumber, port)
+		}
+
+		// The same ONU was rebooted and replaces its previous registration
+		common.Logger().WithFields(logrus.Fields{
+			"device":       o,
+			"port":         port,
+			"serialNumber": onu.SerialNumber,
+		}).Info("ONU is registering again")
+
+		o.releaseOnu(port, o.deleteOnuEntry(port))
+	}
+
+	// An ONU registered before a restart recovers its port, unless it requests another PON port
+	portNum = o.restoredPort(onu.SerialNumber)
+	pon := o.GetOnuPonPort(portNum)
+	if pon == nil || (onu.PonPort != 0 && int(onu.PonPort) != pon.Port) {
+		var err error
+		if pon, err = o.selectPonPort(onu.PonPort); err != nil {
+			common.Logger().WithFields(logrus.Fields{
+				"device":       o,
+				"serialNumber": onu.SerialNumber,
+				"ponPort":      onu.PonPort,
+			}).Warn("ONU requested an unknown PON port")
+
+			return -1, err
+		}
+		portNum = o.nextAvailablePort(pon)
+	}
+
+	if portNum != -1 {
+		common.Logger().WithFields(logrus.Fields{
+			"device":  o,
+			"port":    portNum,
+			"ponPort": pon.Port,
+			"onu":     onu,
 		}).Info("Adding ONU")
 
-		registree := &OnuRegistree{Device: onu}
+		registree := &OnuRegistree{
+			Device:     onu,
+			Tcont:      NewPonSimTcont(portNum - BASE_PORT_NUMBER),
+			Ranging:    ranging,
+			Fec:        fec,
+			Optics:     optics,
+			Operations: NewPonSimOperationQueue(o.OnuQueueDepth, o.OnuOperationDelay),
+		}
 
 		// Setup GRPC communication and check if it succeeded
 		if err := o.ConnectToRemoteOnu(registree); err == nil {
-			o.GetOnus()[portNum] = registree
+			registree.downstream = NewPonSimDelayQueue(DEFAULT_DELAY_QUEUE_DEPTH)
+			registree.upstream = NewPonSimDelayQueue(DEFAULT_DELAY_QUEUE_DEPTH)
+			o.addOnuEntry(pon, portNum, registree)
+			onu.PonPort = int32(pon.Port)
 
-			o.AddLink(1, int(portNum), o.forwardToONU(portNum))
 			go o.MonitorOnu(ctx, portNum)
-			go o.Listen(ctx, portNum)
+			go o.openOnuStream(ctx, portNum)
+			go o.resyncOnuFlows(ctx, portNum)
 		}
 
 	} else {
 		common.Logger().WithFields(logrus.Fields{
-			"device": o,
+			"device":  o,
+			"ponPort": pon.Port,
 		}).Warn("ONU Map is full")
 	}
 
@@ -369,7 +1072,32 @@ func (o *PonSimOltDevice) AddOnu(onu *PonSimOnuDevice) (int32, error) {
 RemoveOnu removes the reference to a registered ONU
 */
 func (o *PonSimOltDevice) RemoveOnu(ctx context.Context, onuIndex int32) error {
-	onu := o.GetOnu(onuIndex)
+	onu := o.removeOnuEntry(onuIndex)
+	if onu == nil {
+		return fmt.Errorf("no ONU registered on port %d", onuIndex)
+	}
+
+	o.releaseOnu(onuIndex, onu)
+
+	return nil
+}
+
+/*
+releaseOnu unlinks an ONU removed from the registered ONUs and closes its connection
+*/
+func (o *PonSimOltDevice) releaseOnu(onuIndex int32, onu *OnuRegistree) {
+	// Remove link entries for this ONU
+	if pon := o.GetOnuPonPort(onuIndex); pon != nil {
+		o.RemoveLink(pon.Port, int(onuIndex))
+	}
+	onu.downstream.Stop()
+	onu.upstream.Stop()
+
+	// A LOS of the ONU does not outlive its registration
+	if alarm := onu.Optics.releaseLosAlarm(); alarm != nil && o.alarms != nil {
+		o.alarms.clearAlarm(alarm)
+	}
+
 	if err := onu.Conn.Close(); err != nil {
 		common.Logger().WithFields(logrus.Fields{
 			"device":   o,
@@ -382,14 +1110,7 @@ func (o *PonSimOltDevice) RemoveOnu(ctx context.Context, onuIndex int32) error {
 		"device":   o,
 		"onu":      onu,
 		"onuIndex": onuIndex,
-	}).Info("Removing ONU")
-
-	delete(o.Onus, onuIndex)
-
-	// Remove link entries for this ONU
-	o.RemoveLink(1, int(onuIndex))
-
-	return nil
+	}).Info("Removed ONU")
 }
 
 /*
@@ -397,12 +1118,15 @@ MonitorOnu verifies the connection status of a specific ONU and cleans up as nec
 */
 func (o *PonSimOltDevice) MonitorOnu(ctx context.Context, onuIndex int32) {
 	for {
-		if o.GetOnu(onuIndex) != nil {
-			if conn := o.GetOnu(onuIndex).Conn; conn.GetState() == connectivity.Ready {
+		if onu := o.GetOnu(onuIndex); onu != nil {
+			if conn := onu.Conn; conn.GetState() == connectivity.Ready {
 				// Wait for any change to occur
 				conn.WaitForStateChange(ctx, conn.GetState())
-				// We lost communication with the ONU ... remove it
-				o.RemoveOnu(ctx, onuIndex)
+				// We lost communication with the ONU ... remove it, unless it already
+				// registered again on the same port after a reboot
+				if o.GetOnu(onuIndex) == onu {
+					o.RemoveOnu(ctx, onuIndex)
+				}
 				return
 			}
 			common.Logger().WithFields(logrus.Fields{
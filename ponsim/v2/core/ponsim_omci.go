@@ -0,0 +1,462 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// Baseline OMCI messages: transaction id, message type, device identifier, managed entity
+	// class and instance, 32 bytes of contents and the trailer
+	OMCI_HEADER_SIZE   = 8
+	OMCI_CONTENTS_SIZE = 32
+	OMCI_MESSAGE_SIZE  = OMCI_HEADER_SIZE + OMCI_CONTENTS_SIZE + 4
+	OMCI_DEVICE_ID     = 0x0a
+	OMCI_TRAILER       = 0x00000028
+
+	// Bits of the message type
+	OMCI_AK        = 0x20
+	OMCI_TYPE_MASK = 0x1f
+
+	// Message types
+	OMCI_CREATE          = 4
+	OMCI_DELETE          = 6
+	OMCI_SET             = 8
+	OMCI_GET             = 9
+	OMCI_MIB_UPLOAD      = 13
+	OMCI_MIB_UPLOAD_NEXT = 14
+	OMCI_MIB_RESET       = 15
+
+	// Results of the commands
+	OMCI_SUCCESS           = 0
+	OMCI_NOT_SUPPORTED     = 2
+	OMCI_PARAMETER_ERROR   = 3
+	OMCI_UNKNOWN_ENTITY    = 4
+	OMCI_UNKNOWN_INSTANCE  = 5
+	OMCI_INSTANCE_EXISTS   = 7
+	OMCI_ATTRIBUTE_FAILURE = 9
+
+	// Managed entity classes of the MIB
+	OMCI_ONU_DATA          = 2
+	OMCI_SOFTWARE_IMAGE    = 7
+	OMCI_PPTP_ETHERNET_UNI = 11
+	OMCI_ONU_G             = 256
+	OMCI_ONU2_G            = 257
+	OMCI_TCONT             = 262
+	OMCI_ANI_G             = 263
+
+	// Attribute values carried by a MIB upload next response, after the class, instance and mask
+	OMCI_UPLOAD_SEGMENT_SIZE = OMCI_CONTENTS_SIZE - 6
+)
+
+/*
+omciAttributeSizes are the sizes of the attributes of the managed entities of the MIB, by
+class and attribute index starting at 1, as defined by openomci
+*/
+var omciAttributeSizes = map[uint16][]int{
+	OMCI_ONU_DATA:          {2},
+	OMCI_SOFTWARE_IMAGE:    {14, 1, 1, 1, 25, 16},
+	OMCI_PPTP_ETHERNET_UNI: {1, 1, 1, 1, 1, 1, 1, 2, 1, 2, 1, 1, 1, 1, 1},
+	OMCI_ONU_G:             {4, 14, 8, 1, 1, 1, 1, 1, 1, 24, 12, 1, 2},
+	OMCI_ONU2_G:            {20, 1, 2, 1, 1, 2, 1, 1, 2, 4, 2, 1, 2, 2},
+	OMCI_TCONT:             {2, 1, 1},
+	OMCI_ANI_G:             {1, 2, 2, 1, 1, 1, 1, 1, 1, 2, 1, 1, 1, 2, 1, 1},
+}
+
+/*
+omciEntity is an instance of a managed entity, its attribute values indexed from 1
+*/
+type omciEntity struct {
+	class      uint16
+	instance   uint16
+	attributes map[int][]byte
+}
+
+/*
+omciSegment is a command of a MIB upload, carrying some attributes of an entity
+*/
+type omciSegment struct {
+	class    uint16
+	instance uint16
+	mask     uint16
+	values   []byte
+}
+
+/*
+PonSimMib is the minimal OMCI MIB of an ONU.  The MIB data sync counter (MDS) is reset along
+with the MIB and incremented by every change of the MIB made by the OLT, so that the OLT can
+audit its copy of the MIB.
+*/
+type PonSimMib struct {
+	mutex    sync.Mutex
+	entities map[uint32]*omciEntity
+	upload   []omciSegment
+
+	MibDataSync uint8 `json:"mib_data_sync"`
+	Resets      int64 `json:"resets"`
+	Uploads     int64 `json:"uploads"`
+	Messages    int64 `json:"messages"`
+}
+
+func omciKey(class uint16, instance uint16) uint32 {
+	return uint32(class)<<16 | uint32(instance)
+}
+
+/*
+omciString returns a string attribute padded with zeros to its size
+*/
+func omciString(value string, size int) []byte {
+	attribute := make([]byte, size)
+	copy(attribute, value)
+	return attribute
+}
+
+func omciUint(value uint32, size int) []byte {
+	attribute := make([]byte, size)
+	for i := size - 1; i >= 0; i-- {
+		attribute[i] = byte(value)
+		value >>= 8
+	}
+	return attribute
+}
+
+/*
+omciBool returns a one byte boolean attribute
+*/
+func omciBool(value bool) []byte {
+	if value {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+/*
+add creates an entity with its attributes, any attribute not given being zero
+*/
+func (m *PonSimMib) add(class uint16, instance uint16, attributes map[int][]byte) {
+	entity := &omciEntity{class: class, instance: instance, attributes: make(map[int][]byte)}
+	for index, size := range omciAttributeSizes[class] {
+		value := make([]byte, size)
+		copy(value, attributes[index+1])
+		entity.attributes[index+1] = value
+	}
+	m.entities[omciKey(class, instance)] = entity
+}
+
+/*
+build populates the MIB with the entities of the ONU as it boots
+*/
+func (m *PonSimMib) build(o *PonSimOnuDevice) {
+	m.entities = make(map[uint32]*omciEntity)
+	m.upload = nil
+	m.MibDataSync = 0
+
+	m.add(OMCI_ONU_DATA, 0, nil)
+
+	serialNumber := omciString(o.VendorId, 8)
+	if sn := o.GetSerialNumber(); strings.HasPrefix(sn, o.VendorId) {
+		if suffix, err := hex.DecodeString(sn[len(o.VendorId):]); err == nil && len(suffix) == 4 {
+			copy(serialNumber[4:], suffix)
+		}
+	}
+	m.add(OMCI_ONU_G, 0, map[int][]byte{
+		1: omciString(o.VendorId, 4),
+		2: omciString("PONSIM", 14),
+		3: serialNumber,
+	})
+	m.add(OMCI_ONU2_G, 0, map[int][]byte{
+		1: omciString("PONSIM-ONU", 20),
+		2: {0xa0}, // OMCC version
+		6: omciUint(8, 2),
+		7: {1},
+		9: omciUint(uint32(len(o.GemPorts)), 2),
+	})
+
+	images, _ := o.GetImages()
+	for slot, image := range images {
+		m.add(OMCI_SOFTWARE_IMAGE, uint16(slot), map[int][]byte{
+			1: omciString(image.Version, 14),
+			2: omciBool(image.Committed),
+			3: omciBool(image.Active),
+			4: omciBool(image.Valid),
+		})
+	}
+
+	m.add(OMCI_ANI_G, 0x8001, map[int][]byte{
+		1: {1},
+		2: omciUint(1, 2),
+		3: omciUint(48, 2),
+		6: {5},
+		7: {9},
+	})
+	m.add(OMCI_TCONT, 0x8001, map[int][]byte{
+		1: omciUint(0xffff, 2), // Until the OLT assigns an alloc id
+		2: {1},
+	})
+	m.add(OMCI_PPTP_ETHERNET_UNI, 0x0101, map[int][]byte{
+		8:  omciUint(1518, 2),
+		11: {2},
+	})
+}
+
+/*
+segments splits the entities of the MIB into the commands of a MIB upload, the ONU data
+entity excepted
+*/
+func (m *PonSimMib) segments() []omciSegment {
+	keys := make([]int, 0, len(m.entities))
+	for key := range m.entities {
+		keys = append(keys, int(key))
+	}
+	sort.Ints(keys)
+
+	var segments []omciSegment
+	for _, key := range keys {
+		entity := m.entities[uint32(key)]
+		if entity.class == OMCI_ONU_DATA {
+			continue
+		}
+
+		segment := omciSegment{class: entity.class, instance: entity.instance}
+		for index := 1; index <= len(entity.attributes); index++ {
+			value := entity.attributes[index]
+			if len(segment.values)+len(value) > OMCI_UPLOAD_SEGMENT_SIZE {
+				segments = append(segments, segment)
+				segment = omciSegment{class: entity.class, instance: entity.instance}
+			}
+			segment.mask |= 1 << uint(16-index)
+			segment.values = append(segment.values, value...)
+		}
+		if segment.mask != 0 || len(entity.attributes) == 0 {
+			segments = append(segments, segment)
+		}
+	}
+
+	return segments
+}
+
+/*
+changed increments the MIB data sync counter after a change of the MIB made by the OLT; the
+value 0 being reserved for a MIB which was just reset, the counter wraps around to 1
+*/
+func (m *PonSimMib) changed() {
+	if m.MibDataSync++; m.MibDataSync == 0 {
+		m.MibDataSync = 1
+	}
+	binary.BigEndian.PutUint16(m.entities[omciKey(OMCI_ONU_DATA, 0)].attributes[1], uint16(m.MibDataSync))
+}
+
+/*
+attributeIndices returns the indices of the attributes selected by a mask, in order
+*/
+func attributeIndices(mask uint16) []int {
+	var indices []int
+	for index := 1; index <= 16; index++ {
+		if mask&(1<<uint(16-index)) != 0 {
+			indices = append(indices, index)
+		}
+	}
+	return indices
+}
+
+/*
+HandleOmci processes an OMCI message sent by the OLT to the ONU and returns the response
+*/
+func (o *PonSimOnuDevice) HandleOmci(request []byte) ([]byte, error) {
+	if len(request) < OMCI_HEADER_SIZE {
+		return nil, fmt.Errorf("OMCI message of %d bytes is too short", len(request))
+	}
+	if request[3] != OMCI_DEVICE_ID {
+		return nil, fmt.Errorf("unsupported OMCI device identifier %#x", request[3])
+	}
+
+	messageType := request[2] & OMCI_TYPE_MASK
+	class := binary.BigEndian.Uint16(request[4:6])
+	instance := binary.BigEndian.Uint16(request[6:8])
+	contents := make([]byte, OMCI_CONTENTS_SIZE)
+	copy(contents, request[OMCI_HEADER_SIZE:])
+
+	response := make([]byte, OMCI_MESSAGE_SIZE)
+	copy(response[0:2], request[0:2])
+	response[2] = messageType | OMCI_AK
+	response[3] = OMCI_DEVICE_ID
+	copy(response[4:8], request[4:8])
+	binary.BigEndian.PutUint32(response[OMCI_HEADER_SIZE+OMCI_CONTENTS_SIZE:], OMCI_TRAILER)
+	reply := response[OMCI_HEADER_SIZE : OMCI_HEADER_SIZE+OMCI_CONTENTS_SIZE]
+
+	o.mib.mutex.Lock()
+	defer o.mib.mutex.Unlock()
+
+	if o.mib.entities == nil {
+		o.mib.build(o)
+	}
+	o.mib.Messages++
+
+	switch messageType {
+	case OMCI_MIB_RESET:
+		o.mib.build(o)
+		o.mib.Resets++
+
+	case OMCI_MIB_UPLOAD:
+		// The MIB is uploaded as it is when the upload starts
+		o.mib.upload = o.mib.segments()
+		o.mib.Uploads++
+		binary.BigEndian.PutUint16(reply[0:2], uint16(len(o.mib.upload)))
+
+	case OMCI_MIB_UPLOAD_NEXT:
+		if sequence := int(binary.BigEndian.Uint16(contents[0:2])); sequence < len(o.mib.upload) {
+			segment := o.mib.upload[sequence]
+			binary.BigEndian.PutUint16(reply[0:2], segment.class)
+			binary.BigEndian.PutUint16(reply[2:4], segment.instance)
+			binary.BigEndian.PutUint16(reply[4:6], segment.mask)
+			copy(reply[6:], segment.values)
+		}
+
+	case OMCI_GET:
+		entity, ok := o.mib.entities[omciKey(class, instance)]
+		if !ok {
+			reply[0] = o.mib.unknown(class)
+			break
+		}
+
+		var mask uint16
+		values := reply[3:]
+		for _, index := range attributeIndices(binary.BigEndian.Uint16(contents[0:2])) {
+			value, ok := entity.attributes[index]
+			if !ok || len(value) > len(values) {
+				reply[0] = OMCI_ATTRIBUTE_FAILURE
+				continue
+			}
+			mask |= 1 << uint(16-index)
+			values = values[copy(values, value):]
+		}
+		binary.BigEndian.PutUint16(reply[1:3], mask)
+
+	case OMCI_SET:
+		entity, ok := o.mib.entities[omciKey(class, instance)]
+		if !ok {
+			reply[0] = o.mib.unknown(class)
+			break
+		}
+
+		var set, unsupported uint16
+		values := contents[2:]
+		for _, index := range attributeIndices(binary.BigEndian.Uint16(contents[0:2])) {
+			value, ok := entity.attributes[index]
+			if !ok || len(value) > len(values) {
+				unsupported |= 1 << uint(16-index)
+				continue
+			}
+			values = values[copy(value, values):]
+			set |= 1 << uint(16-index)
+		}
+		if unsupported != 0 {
+			reply[0] = OMCI_ATTRIBUTE_FAILURE
+			binary.BigEndian.PutUint16(reply[1:3], unsupported)
+		}
+
+		// The OLT sets the MIB data sync counter itself once it aligned its copy of the MIB
+		if class == OMCI_ONU_DATA {
+			o.mib.MibDataSync = uint8(binary.BigEndian.Uint16(entity.attributes[1]))
+		} else if set != 0 {
+			o.mib.changed()
+		}
+
+	case OMCI_CREATE:
+		if _, ok := o.mib.entities[omciKey(class, instance)]; ok {
+			reply[0] = OMCI_INSTANCE_EXISTS
+			break
+		}
+
+		// The attributes set by the creation are only known for the classes of the MIB
+		o.mib.add(class, instance, nil)
+		o.mib.changed()
+
+	case OMCI_DELETE:
+		if _, ok := o.mib.entities[omciKey(class, instance)]; !ok || class == OMCI_ONU_DATA {
+			reply[0] = OMCI_UNKNOWN_INSTANCE
+			break
+		}
+
+		delete(o.mib.entities, omciKey(class, instance))
+		o.mib.changed()
+
+	default:
+		reply[0] = OMCI_NOT_SUPPORTED
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"device":      o.Name,
+		"messageType": messageType,
+		"class":       class,
+		"instance":    instance,
+		"result":      reply[0],
+		"mds":         o.mib.MibDataSync,
+	}).Debug("Processed OMCI message")
+
+	return response, nil
+}
+
+/*
+unknown returns the result of a command addressing an entity missing from the MIB
+*/
+func (m *PonSimMib) unknown(class uint16) byte {
+	for _, entity := range m.entities {
+		if entity.class == class {
+			return OMCI_UNKNOWN_INSTANCE
+		}
+	}
+	return OMCI_UNKNOWN_ENTITY
+}
+
+/*
+clear discards the MIB, which is built again with the entities of the ONU when it is next
+addressed, as after a reboot
+*/
+func (m *PonSimMib) clear() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.entities = nil
+	m.upload = nil
+}
+
+/*
+MakeProto returns the OMCI counters of the ONU, the MIB data sync counter included
+*/
+func (m *PonSimMib) MakeProto() *voltha.PonSimPortMetrics {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return &voltha.PonSimPortMetrics{
+		PortName: "omci",
+		Packets: []*voltha.PonSimPacketCounter{
+			{Name: "mib_data_sync", Value: int64(m.MibDataSync)},
+			{Name: "mib_resets", Value: m.Resets},
+			{Name: "mib_uploads", Value: m.Uploads},
+			{Name: "rx_msgs", Value: m.Messages},
+		},
+	}
+}
@@ -18,17 +18,21 @@ package core
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/google/gopacket"
 	"github.com/google/uuid"
 	"github.com/opencord/voltha/ponsim/v2/common"
 	"github.com/opencord/voltha/protos/go/ponsim"
+	"github.com/opencord/voltha/protos/go/voltha"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"net"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -41,26 +45,135 @@ PonSimOnuDevice is the structure responsible for the handling of an ONU device
 type PonSimOnuDevice struct {
 	PonSimDevice
 
-	ParentAddress string
-	ParentPort    int32
-	AssignedPort  int32
-	Conn          *grpc.ClientConn
-
-	oltClient ponsim.PonSimCommonClient
-	stream    ponsim.PonSimCommon_ProcessDataClient
-	monitor   chan PonSimDeviceState
-	state     PonSimDeviceState
+	ParentAddress  string
+	ParentPort     int32
+	AssignedPort   int32
+	PonPort        int32 // PON port of the OLT requested at registration (0 for any), then assigned
+	Conn           *grpc.ClientConn
+	VendorId       string
+	SerialNumber   string
+	RegistrationId string
+	Loid           string
+	LoidPassword   string `json:"-"`
+	AllocId        uint32
+	GemPorts       []uint32
+
+	// Fiber distance to the OLT in meters and equalization delay assigned by its ranging
+	Distance          uint32
+	EqualizationDelay time.Duration
+
+	oltClient   ponsim.PonSimCommonClient
+	stream      ponsim.PonSimCommon_ProcessDataClient
+	streamMutex sync.Mutex
+	monitor     chan PonSimDeviceState
+	state       PonSimDeviceState
+	bootUntil   int64 // end of the current reboot in nanoseconds, accessed atomically
+
+	subscribers *PonSimSubscribers
+	arp         *PonSimArpResponder
+	echo        *PonSimEchoResponder
+	gemKeys     PonSimGemKeys
+	images      PonSimImages
+	mib         PonSimMib
+	lifecycle   PonSimOnuLifecycle
 }
 
 /*
 NewPonSimOnuDevice instantiates a new ONU device structure
 */
 func NewPonSimOnuDevice(device PonSimDevice) *PonSimOnuDevice {
-	onu := &PonSimOnuDevice{PonSimDevice: device}
+	onu := &PonSimOnuDevice{
+		PonSimDevice: device,
+		subscribers:  NewPonSimSubscribers(),
+		arp:          NewPonSimArpResponder(),
+		echo:         &PonSimEchoResponder{},
+	}
+	onu.flows = NewPonSimFlowTable()
 
 	return onu
 }
 
+/*
+DefaultOnuSerialNumber returns the serial number of an ONU configured without one.  It is
+derived from the vendor id and the hardware address of the internal interface so that each
+ONU instance is unique.
+*/
+func DefaultOnuSerialNumber(vendorId string, internalIf string) string {
+	suffix := strings.ToUpper(strings.Replace(uuid.New().String(), "-", "", -1))[:8]
+	if hwAddr := common.GetMacAddress(internalIf); len(hwAddr) >= 4 {
+		suffix = fmt.Sprintf("%02X%02X%02X%02X",
+			hwAddr[len(hwAddr)-4], hwAddr[len(hwAddr)-3], hwAddr[len(hwAddr)-2], hwAddr[len(hwAddr)-1])
+	}
+
+	return vendorId + suffix
+}
+
+/*
+GetSerialNumber returns the serial number of the ONU
+*/
+func (o *PonSimOnuDevice) GetSerialNumber() string {
+	return o.SerialNumber
+}
+
+/*
+GetDefaultGemPort returns the GEM port used to carry upstream frames
+*/
+func (o *PonSimOnuDevice) GetDefaultGemPort() uint32 {
+	if len(o.GemPorts) > 0 {
+		return o.GemPorts[0]
+	}
+
+	return 0
+}
+
+/*
+acceptsGemPort reports whether a frame received from the OLT on a GEM port is for the ONU
+*/
+func (o *PonSimOnuDevice) acceptsGemPort(gemId uint32) bool {
+	if gemId == BROADCAST_GEM_PORT_ID || len(o.GemPorts) == 0 {
+		return true
+	}
+	for _, id := range o.GemPorts {
+		if id == gemId {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Forward discards the frames broadcast by the OLT on the GEM ports of other ONUs before
+processing them
+*/
+func (o *PonSimOnuDevice) Forward(
+	ctx context.Context,
+	port int,
+	frame gopacket.Packet,
+) error {
+	if o.IsDisabled() {
+		o.Counter.CountDroppedFrame(port)
+		forwardingLogger.WithFields(logrus.Fields{
+			"device": o,
+			"port":   port,
+		}).Debug("Dropping frame, the ONU is disabled")
+		return nil
+	}
+
+	if gemId, ok := GemPortFromContext(ctx); ok && port == 1 && !o.acceptsGemPort(gemId) {
+		o.Counter.CountGemFilteredFrame(port)
+		forwardingLogger.WithFields(logrus.Fields{
+			"device":   o,
+			"port":     port,
+			"gemId":    gemId,
+			"gemPorts": o.GemPorts,
+		}).Debug("Discarding frame carried on the GEM port of another ONU")
+		return nil
+	}
+
+	return o.PonSimDevice.Forward(ctx, port, frame)
+}
+
 /*
 forwardToOLT defines a INGRESS function to forward a packet to the parent OLT
 */
@@ -72,18 +185,32 @@ func (o *PonSimOnuDevice) forwardToOLT() func(int, gopacket.Packet) {
 			Address: ipAddress,
 			Port:    int32(port),
 			Payload: frame.Data(),
+			GemPort: o.GetDefaultGemPort(),
+			Hash:    common.GetFrameHash(frame),
+		}
+
+		span := common.StartFrameSpan(frame, "ForwardToOLT", common.SPAN_KIND_PRODUCER)
+		span.SetAttribute("ponsim.device", o.Name)
+		span.SetAttribute("ponsim.port", port)
+		span.SetAttribute("ponsim.gem_port", incoming.GemPort)
+		defer span.Finish()
+		if span != nil {
+			incoming.TraceParent = span.Context.Traceparent()
+		}
+
+		if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+			entry.WithFields(logrus.Fields{
+				"device":   o,
+				"port":     port,
+				"frame":    frame,
+				"incoming": incoming,
+			}).Debug("Forwarding to OLT")
 		}
-		common.Logger().WithFields(logrus.Fields{
-			"device":    o,
-			"port":      port,
-			"frame":     frame,
-			"frameDump": frame.Dump(),
-			"incoming":  incoming,
-		}).Debug("Forwarding to OLT")
 
 		// Forward packet to OLT
-		if err := o.stream.Send(incoming); err != nil {
-			common.Logger().WithFields(logrus.Fields{
+		if err := o.sendToOlt(incoming); err != nil {
+			span.SetError(err)
+			forwardingLogger.WithFields(logrus.Fields{
 				"device":    o,
 				"port":      port,
 				"frameDump": frame.Dump(),
@@ -93,25 +220,40 @@ func (o *PonSimOnuDevice) forwardToOLT() func(int, gopacket.Packet) {
 	}
 }
 
+/*
+sendToOlt sends a frame on the stream to the OLT
+
+A gRPC stream does not support concurrent sends, frames forwarded by several workers are therefore
+sent one at a time.
+*/
+func (o *PonSimOnuDevice) sendToOlt(incoming *ponsim.IncomingData) error {
+	o.streamMutex.Lock()
+	defer o.streamMutex.Unlock()
+
+	return o.stream.Send(incoming)
+}
+
 /*
 forwardToWAN defines a EGRESS function to forward a packet to the world
 */
 func (o *PonSimOnuDevice) forwardToWAN() func(int, gopacket.Packet) {
 	return func(port int, frame gopacket.Packet) {
 		var err error
-		common.Logger().WithFields(logrus.Fields{
-			"device": o,
-			"port":   port,
-			"frame":  frame,
-		}).Debug("Forwarding packet to world")
+		if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+			entry.WithFields(logrus.Fields{
+				"device": o,
+				"port":   port,
+				"frame":  frame,
+			}).Debug("Forwarding packet to world")
+		}
 		if err = o.ingressHandler.WritePacketData(frame.Data()); err != nil {
-			common.Logger().WithFields(logrus.Fields{
+			forwardingLogger.WithFields(logrus.Fields{
 				"device": o,
 				"port":   port,
 				"frame":  frame,
 			}).Fatal("Problem while forwarding packet to world")
-		} else {
-			common.Logger().WithFields(logrus.Fields{
+		} else if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+			entry.WithFields(logrus.Fields{
 				"device": o,
 				"port":   port,
 				"frame":  frame,
@@ -120,6 +262,98 @@ func (o *PonSimOnuDevice) forwardToWAN() func(int, gopacket.Packet) {
 	}
 }
 
+/*
+forwardToSubscribers defines a EGRESS function delivering packets to the simulated subscriber hosts,
+the ARP and ICMP echo requests for their addresses being answered by the responders
+*/
+func (o *PonSimOnuDevice) forwardToSubscribers() func(int, gopacket.Packet) {
+	return func(port int, frame gopacket.Packet) {
+		if o.arp.HandleFrame(frame, o.injectSubscriberFrame) ||
+			o.echo.HandleFrame(frame, o.arp, o.injectSubscriberFrame) {
+			return
+		}
+		o.subscribers.Dispatch(frame)
+	}
+}
+
+/*
+injectSubscriberFrame forwards a frame sent by a simulated subscriber host as received on the UNI port
+*/
+func (o *PonSimOnuDevice) injectSubscriberFrame(frame gopacket.Packet) {
+	if err := o.Forward(context.Background(), 2, frame); err != nil {
+		common.Logger().WithFields(logrus.Fields{
+			"device": o,
+			"error":  err.Error(),
+		}).Error("Problem forwarding subscriber frame")
+	}
+}
+
+/*
+SetArpBinding makes the ONU answer the ARP requests for the address of a binding
+*/
+func (o *PonSimOnuDevice) SetArpBinding(binding *PonSimArpBinding) {
+	common.Logger().WithFields(logrus.Fields{
+		"device": o,
+		"ip":     binding.Ip,
+		"mac":    binding.Mac.String(),
+	}).Info("Binding subscriber address")
+
+	o.arp.Set(binding)
+}
+
+/*
+RemoveArpBinding stops answering the ARP requests for an address and reports whether it was bound
+*/
+func (o *PonSimOnuDevice) RemoveArpBinding(ip net.IP) bool {
+	return o.arp.Remove(ip)
+}
+
+/*
+GetArpBindings returns the addresses for which the ONU answers the ARP requests
+*/
+func (o *PonSimOnuDevice) GetArpBindings() []PonSimArpBinding {
+	return o.arp.List()
+}
+
+/*
+GetEchoResponder returns the responder answering the ICMP echo requests for the bound addresses
+*/
+func (o *PonSimOnuDevice) GetEchoResponder() *PonSimEchoResponder {
+	return o.echo
+}
+
+/*
+StartIpv6Subscriber simulates a subscriber host behind the UNI port which acquires IPv6
+addresses through SLAAC and DHCPv6.  A negative vlan sends untagged frames.
+*/
+func (o *PonSimOnuDevice) StartIpv6Subscriber(ctx context.Context, mac net.HardwareAddr, vlan int) {
+	subscriber := NewPonSimSubscriber(mac, vlan, func(frame gopacket.Packet) {
+		if err := o.Forward(ctx, 2, frame); err != nil {
+			common.Logger().WithFields(logrus.Fields{
+				"device":     o,
+				"subscriber": mac.String(),
+				"error":      err.Error(),
+			}).Error("Problem forwarding subscriber frame")
+		}
+	})
+
+	common.Logger().WithFields(logrus.Fields{
+		"device":     o,
+		"subscriber": mac.String(),
+		"vlan":       vlan,
+	}).Info("Starting IPv6 subscriber")
+
+	o.subscribers.Add(subscriber)
+	subscriber.Start()
+}
+
+/*
+GetIpv6Subscribers returns the subscriber hosts simulated behind the UNI port
+*/
+func (o *PonSimOnuDevice) GetIpv6Subscribers() []*PonSimSubscriber {
+	return o.subscribers.List()
+}
+
 /*
 Start performs setup operations for an ONU device
 */
@@ -127,15 +361,96 @@ func (o *PonSimOnuDevice) Start(ctx context.Context) {
 	// Initialize the parent
 	o.PonSimDevice.Start(ctx)
 
+	// Restore the state saved before the last shutdown and keep saving it
+	if o.Checkpoint != nil {
+		o.restoreCheckpoint()
+		o.Checkpoint.Start(ctx, o.makeCheckpoint)
+	}
+
+	// Accumulate the counters into PM intervals
+	if o.Pm != nil {
+		o.Pm.Start(ctx, o.Clock, o.MakeMetrics)
+	}
+
+	// Inject random faults from the chaos catalog
+	if o.Chaos != nil {
+		o.Chaos.Start(ctx, o.injectChaos)
+	}
+
+	// Shape the traffic of the UNI port
+	if o.BandwidthProfile != nil {
+		o.SetBandwidthProfile(2, *o.BandwidthProfile)
+	}
+
 	// Setup flow behaviours
 	// ONU -> OLT
 	o.AddLink(1, 0, o.forwardToOLT())
 	// ONU -> World
 	o.AddLink(2, 0, o.forwardToWAN())
+	// ONU -> Simulated subscribers
+	o.AddLink(2, 1, o.forwardToSubscribers())
+
+	// Send the CCMs of the maintenance end points
+	if o.Cfm != nil {
+		o.Cfm.Start(o.transmitCfm(ctx))
+	}
 
 	go o.MonitorConnection(ctx)
 }
 
+/*
+MakeMetrics collects the counters of the ONU and of its OMCI channel as GRPC metrics
+*/
+func (o *PonSimOnuDevice) MakeMetrics() *voltha.PonSimMetrics {
+	metrics := o.Counter.MakeProto()
+	metrics.Metrics = append(metrics.Metrics, o.mib.MakeProto())
+	return metrics
+}
+
+/*
+Reboot simulates the reboot of the ONU; flows are lost and the ONU registers again
+with the OLT once the boot delay has elapsed, running its committed image
+*/
+func (o *PonSimOnuDevice) Reboot(ctx context.Context) error {
+	o.images.mutex.Lock()
+	o.bootCommittedImage()
+	o.images.mutex.Unlock()
+
+	o.restart(ctx)
+
+	return nil
+}
+
+/*
+restart reboots the ONU without changing its active image
+*/
+func (o *PonSimOnuDevice) restart(ctx context.Context) {
+	atomic.StoreInt64(&o.bootUntil, time.Now().Add(o.BootDelay).UnixNano())
+	o.PonSimDevice.reboot(ctx)
+	o.mib.clear()
+
+	// Dropping the connection to the OLT triggers a new registration
+	if conn := o.Conn; conn != nil {
+		conn.Close()
+	}
+}
+
+/*
+StopAllTraffic halts the frames generated by the simulator on the ONU: the subscriber hosts
+are removed, the chaos controller is stopped, injected faults and delays are cleared and
+running jobs are cancelled
+*/
+func (o *PonSimOnuDevice) StopAllTraffic() {
+	count := o.subscribers.Clear()
+
+	common.Logger().WithFields(logrus.Fields{
+		"device":      o,
+		"subscribers": count,
+	}).Info("Removed IPv6 subscribers")
+
+	o.PonSimDevice.stopTraffic()
+}
+
 /*
 Stop performs cleanup operations for an ONU device
 */
@@ -146,6 +461,11 @@ func (o *PonSimOnuDevice) Stop(ctx context.Context) {
 
 	o.RemoveLink(1, 0)
 	o.RemoveLink(2, 0)
+	o.RemoveLink(2, 1)
+
+	if o.Checkpoint != nil {
+		o.Checkpoint.save(o.makeCheckpoint())
+	}
 
 	o.PonSimDevice.Stop(ctx)
 }
@@ -165,7 +485,7 @@ func (o *PonSimOnuDevice) Listen(ctx context.Context) {
 	}
 
 	// Establish GRPC connection with OLT
-	if o.stream, err = o.oltClient.ProcessData(ctx); err != nil {
+	if o.stream, err = o.oltClient.ProcessData(ctx, common.CompressionCallOptions(o.Compression)...); err != nil {
 		common.Logger().WithFields(logrus.Fields{
 			"device": o,
 			"error":  err.Error(),
@@ -175,6 +495,7 @@ func (o *PonSimOnuDevice) Listen(ctx context.Context) {
 
 	defer o.ingressHandler.Close()
 	packetSource := gopacket.NewPacketSource(o.ingressHandler, o.ingressHandler.LinkType())
+	packetSource.DecodeOptions = common.FrameDecodeOptions
 	common.Logger().WithFields(logrus.Fields{
 		"device":    o,
 		"interface": o.ExternalIf,
@@ -193,7 +514,10 @@ func (o *PonSimOnuDevice) Listen(ctx context.Context) {
 		"device": o,
 	}).Debug("No more packets to process")
 
-	if reply, err = o.stream.CloseAndRecv(); err != nil {
+	o.streamMutex.Lock()
+	reply, err = o.stream.CloseAndRecv()
+	o.streamMutex.Unlock()
+	if err != nil {
 		common.Logger().Fatal("A problem occurred while closing Ingress stream", err.Error())
 	} else {
 		common.Logger().Info("Ingress stream closed", reply)
@@ -201,7 +525,23 @@ func (o *PonSimOnuDevice) Listen(ctx context.Context) {
 }
 
 /*
-Register sends a registration request to the remote OLT
+getRegistrationAddress returns the address on which the OLT reaches the ONU: the address of its
+internal interface or, for an ONU simulated in-process without interfaces, its GRPC address
+*/
+func (o *PonSimOnuDevice) getRegistrationAddress() string {
+	if address := common.GetInterfaceIP(o.InternalIf); address != "" {
+		return address
+	}
+
+	return o.Address
+}
+
+// Interval at which an ONU waiting for its activation announces its serial number
+const ONU_ANNOUNCE_INTERVAL = 1 * time.Second
+
+/*
+Register sends a registration request to the remote OLT, again and again while the OLT
+has not activated the ONU
 */
 func (o *PonSimOnuDevice) Register(ctx context.Context) error {
 	var err error
@@ -212,22 +552,60 @@ func (o *PonSimOnuDevice) Register(ctx context.Context) error {
 	if o.Conn != nil {
 		if client = ponsim.NewPonSimOltClient(o.Conn); client != nil {
 			rreq = &ponsim.RegistrationRequest{
-				Id:      uuid.New().String(),
-				Address: common.GetInterfaceIP(o.InternalIf),
-				Port:    o.Port,
+				Id:             uuid.New().String(),
+				Address:        o.getRegistrationAddress(),
+				Port:           o.Port,
+				VendorId:       o.VendorId,
+				SerialNumber:   o.GetSerialNumber(),
+				RegistrationId: o.RegistrationId,
+				Loid:           o.Loid,
+				LoidPassword:   o.LoidPassword,
+				PonPort:        o.PonPort,
+				DistanceM:      o.Distance,
 			}
 			common.Logger().Printf("Request details %+v\n", rreq)
 
+			o.setOnuState(ONU_STATE_DISCOVERED, "registering with the OLT")
+
 			// TODO: Loop registration until an OLT becomes available??
 
 			rrep, err = client.Register(ctx, rreq)
+
+			// Announce the serial number again until the OLT activates the ONU
+			for err == nil && rrep.GetStatus() == ponsim.RegistrationReply_PENDING_ACTIVATION {
+				common.Logger().WithFields(logrus.Fields{
+					"device":       o,
+					"serialNumber": rreq.SerialNumber,
+				}).Debug("Waiting for activation")
+
+				select {
+				case <-time.After(ONU_ANNOUNCE_INTERVAL):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				rrep, err = client.Register(ctx, rreq)
+			}
+
 			if err != nil {
 				common.Logger().Printf("Problem with registration", err.Error())
+			} else if rrep.GetStatus() != ponsim.RegistrationReply_REGISTERED {
+				err = fmt.Errorf("registration rejected by OLT: %s", rrep.GetStatusMessage())
+				o.setOnuState(ONU_STATE_FAILED, err.Error())
+				common.Logger().WithFields(logrus.Fields{
+					"device":       o,
+					"serialNumber": rreq.SerialNumber,
+					"status":       rrep.GetStatus(),
+				}).Error("Registration was rejected")
 			} else {
 				// Save OLT address details
 				o.ParentAddress = rrep.GetParentAddress()
 				o.ParentPort = rrep.GetParentPort()
 				o.AssignedPort = rrep.GetAssignedPort()
+				o.PonPort = rrep.GetPonPort()
+				o.AllocId = rrep.GetAllocId()
+				o.GemPorts = rrep.GetGemPorts()
+				o.EqualizationDelay = time.Duration(rrep.GetEqualizationDelayNs())
+				o.setOnuState(ONU_STATE_ACTIVATING, "registered with the OLT")
 
 				common.Logger().Printf("Registration details - %+v\n", rrep)
 
@@ -248,6 +626,11 @@ MonitorConnection verifies the communication with the OLT
 func (o *PonSimOnuDevice) MonitorConnection(ctx context.Context) {
 	for {
 		if o.state == DISCONNECTED_FROM_PON {
+			// Wait for a reboot to complete
+			if wait := time.Unix(0, atomic.LoadInt64(&o.bootUntil)).Sub(time.Now()); wait > 0 {
+				time.Sleep(wait)
+			}
+
 			// Establish communication with OLT
 			o.Connect(ctx)
 		}
@@ -302,6 +685,11 @@ func (o *PonSimOnuDevice) Disconnect(ctx context.Context) {
 		o.monitor = nil
 		o.state = DISCONNECTED_FROM_PON
 	}
+
+	// A failed ONU stays in that state until it registers again
+	if state, _, _ := o.GetOnuState(); state == ONU_STATE_ACTIVATING || state == ONU_STATE_ACTIVE {
+		o.setOnuState(ONU_STATE_DISCOVERED, "disconnected from the OLT")
+	}
 }
 
 /*
@@ -344,6 +732,7 @@ func (o *PonSimOnuDevice) MonitorState(ctx context.Context, wg *sync.WaitGroup)
 				case CONNECTED_IO_INTERFACE:
 					// Start listening on local interfaces
 					go o.Listen(ctx)
+					o.setOnuState(ONU_STATE_ACTIVE, "interfaces connected")
 				}
 			} else {
 				common.Logger().WithFields(logrus.Fields{
@@ -384,8 +773,11 @@ func (o *PonSimOnuDevice) ConnectToRemoteOlt() {
 		InsecureSkipVerify: true,
 	})
 
+	options := append(common.RequestIdDialOptions(), grpc.WithTransportCredentials(ta), grpc.WithBlock())
 	if o.Conn, err = grpc.DialContext(
-		context.Background(), host, grpc.WithTransportCredentials(ta), grpc.WithBlock(),
+		context.Background(),
+		host,
+		append(options, common.GrpcDialOptions()...)...,
 	); err != nil {
 		common.Logger().WithFields(logrus.Fields{
 			"device": o,
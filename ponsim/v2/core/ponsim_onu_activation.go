@@ -0,0 +1,198 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// The ONUs are activated as soon as they announce their serial number
+	ONU_ACTIVATION_AUTO = "auto"
+
+	// The ONUs wait for an explicit activation once their serial number is discovered
+	ONU_ACTIVATION_MANUAL = "manual"
+)
+
+const (
+	// The serial number of the ONU was announced, it waits for its activation
+	ONU_DISCOVERED = "discovered"
+
+	// The ONU was activated and completes its registration on its next announcement
+	ONU_ACTIVATED = "activated"
+)
+
+/*
+PonSimOnuDiscovery describes an ONU which announced its serial number to the OLT
+*/
+type PonSimOnuDiscovery struct {
+	SerialNumber string    `json:"serial_number"`
+	VendorId     string    `json:"vendor_id"`
+	PonPort      int32     `json:"pon_port"`
+	State        string    `json:"state"`
+	DiscoveredAt time.Time `json:"discovered_at"`
+}
+
+/*
+PonSimOnuActivation tracks the activation of the ONUs of an OLT by serial number.  The ONUs
+stay activated when they disconnect, so that they register again without operator action.
+*/
+type PonSimOnuActivation struct {
+	Mode string `json:"mode"`
+
+	mutex sync.Mutex
+	onus  map[string]*PonSimOnuDiscovery
+}
+
+/*
+NewPonSimOnuActivation instantiates the activation state of the ONUs, or returns nil when the
+ONUs are activated automatically
+*/
+func NewPonSimOnuActivation(mode string) (*PonSimOnuActivation, error) {
+	switch mode {
+	case "", ONU_ACTIVATION_AUTO:
+		return nil, nil
+	case ONU_ACTIVATION_MANUAL:
+	default:
+		return nil, fmt.Errorf("unknown ONU activation mode: %s", mode)
+	}
+
+	return &PonSimOnuActivation{
+		Mode: mode,
+		onus: make(map[string]*PonSimOnuDiscovery),
+	}, nil
+}
+
+/*
+announce records the serial number announced by an ONU and reports whether the ONU was
+discovered for the first time and whether it is activated
+*/
+func (a *PonSimOnuActivation) announce(onu *PonSimOnuDevice, now time.Time) (bool, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if discovery, ok := a.onus[onu.SerialNumber]; ok {
+		discovery.PonPort = onu.PonPort
+		return false, discovery.State == ONU_ACTIVATED
+	}
+
+	a.onus[onu.SerialNumber] = &PonSimOnuDiscovery{
+		SerialNumber: onu.SerialNumber,
+		VendorId:     onu.VendorId,
+		PonPort:      onu.PonPort,
+		State:        ONU_DISCOVERED,
+		DiscoveredAt: now,
+	}
+
+	return true, false
+}
+
+/*
+Activate allows a discovered ONU to complete its registration
+*/
+func (a *PonSimOnuActivation) Activate(serialNumber string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	discovery, ok := a.onus[serialNumber]
+	if !ok {
+		return fmt.Errorf("ONU %s was not discovered", serialNumber)
+	}
+	discovery.State = ONU_ACTIVATED
+
+	return nil
+}
+
+/*
+GetDiscovered returns the ONUs which announced their serial number, sorted by serial number
+*/
+func (a *PonSimOnuActivation) GetDiscovered() []PonSimOnuDiscovery {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	onus := make([]PonSimOnuDiscovery, 0, len(a.onus))
+	for _, discovery := range a.onus {
+		onus = append(onus, *discovery)
+	}
+	sort.Slice(onus, func(i, j int) bool { return onus[i].SerialNumber < onus[j].SerialNumber })
+
+	return onus
+}
+
+/*
+newOnuDiscoveredEvent creates an event reporting that an ONU announced its serial number
+*/
+func newOnuDiscoveredEvent(device string, onu *PonSimOnuDevice) *voltha.PonSimEvent {
+	return &voltha.PonSimEvent{
+		Device: device,
+		Event: &voltha.PonSimEvent_OnuDiscovered{
+			OnuDiscovered: &voltha.PonSimOnuDiscovered{
+				SerialNumber: onu.SerialNumber,
+				VendorId:     onu.VendorId,
+				PonPort:      onu.PonPort,
+			},
+		},
+	}
+}
+
+/*
+DiscoverOnu processes the serial number announced by a registering ONU and reports whether the
+ONU is activated.  An onu_discovered event is raised when the ONU is discovered.
+*/
+func (o *PonSimOltDevice) DiscoverOnu(onu *PonSimOnuDevice) bool {
+	if o.OnuActivation == nil {
+		return true
+	}
+
+	discovered, activated := o.OnuActivation.announce(onu, o.Clock.Now())
+	if discovered {
+		common.Logger().WithFields(logrus.Fields{
+			"device":       o.Name,
+			"serialNumber": onu.SerialNumber,
+			"ponPort":      onu.PonPort,
+		}).Info("Discovered ONU")
+
+		o.publishEvent(newOnuDiscoveredEvent(o.Name, onu))
+	}
+
+	return activated
+}
+
+/*
+ActivateOnu activates a discovered ONU, which registers on its next announcement
+*/
+func (o *PonSimOltDevice) ActivateOnu(serialNumber string) error {
+	if o.OnuActivation == nil {
+		return fmt.Errorf("ONUs are activated automatically")
+	}
+
+	if err := o.OnuActivation.Activate(serialNumber); err != nil {
+		return err
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"device":       o.Name,
+		"serialNumber": serialNumber,
+	}).Info("Activated ONU")
+
+	return nil
+}
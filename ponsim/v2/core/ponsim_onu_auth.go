@@ -0,0 +1,177 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+const (
+	// The ONU is admitted on its serial number alone
+	AUTH_NONE = "none"
+
+	// The ONU must present a logical ONU identifier (LOID) and its password
+	AUTH_LOID = "loid"
+
+	// The ONU must present a registration identifier, i.e. a PLOAM password
+	AUTH_REGISTRATION_ID = "reg_id"
+
+	// Serial number matching the ONUs without credentials of their own
+	AUTH_ANY_ONU = "*"
+)
+
+/*
+PonSimOnuCredentials describes how an ONU authenticates and the credentials it must present
+*/
+type PonSimOnuCredentials struct {
+	Mode           string `json:"mode"`
+	Loid           string `json:"loid"`
+	Password       string `json:"-"`
+	RegistrationId string `json:"-"`
+}
+
+/*
+PonSimOnuAuth holds the credentials expected by the OLT from each ONU, by serial number
+*/
+type PonSimOnuAuth struct {
+	Credentials map[string]PonSimOnuCredentials `json:"credentials"`
+}
+
+/*
+ParseOnuAuth parses a comma separated list of ONU credentials in the formats
+serial:loid:loid[:password], serial:reg_id:registration_id or serial:none,
+e.g. PSMO00000001:loid:0001:secret,PSMO00000002:reg_id:0123456789,*:none
+where * stands for the ONUs which are not listed.  ONUs are not authenticated
+when the specification is empty.
+*/
+func ParseOnuAuth(spec string) (*PonSimOnuAuth, error) {
+	auth := &PonSimOnuAuth{Credentials: make(map[string]PonSimOnuCredentials)}
+
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		fields := strings.SplitN(entry, ":", 4)
+		if len(fields) < 2 || fields[0] == "" {
+			return nil, fmt.Errorf("invalid ONU authentication specification: %s", entry)
+		}
+
+		credentials := PonSimOnuCredentials{Mode: strings.ToLower(fields[1])}
+		switch credentials.Mode {
+		case AUTH_NONE:
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("unexpected credentials for ONU %s: %s", fields[0], entry)
+			}
+		case AUTH_LOID:
+			if len(fields) < 3 || fields[2] == "" {
+				return nil, fmt.Errorf("missing LOID for ONU %s: %s", fields[0], entry)
+			}
+			credentials.Loid = fields[2]
+			if len(fields) == 4 {
+				credentials.Password = fields[3]
+			}
+		case AUTH_REGISTRATION_ID:
+			if len(fields) != 3 || fields[2] == "" {
+				return nil, fmt.Errorf("invalid registration ID for ONU %s: %s", fields[0], entry)
+			}
+			credentials.RegistrationId = fields[2]
+		default:
+			return nil, fmt.Errorf("unknown ONU authentication mode: %s", fields[1])
+		}
+
+		if _, ok := auth.Credentials[fields[0]]; ok {
+			return nil, fmt.Errorf("duplicate credentials for ONU %s", fields[0])
+		}
+		auth.Credentials[fields[0]] = credentials
+	}
+
+	if len(auth.Credentials) == 0 {
+		return nil, nil
+	}
+
+	return auth, nil
+}
+
+/*
+Authenticate checks the credentials presented by an ONU.  ONUs without credentials
+configured, either for their serial number or for any ONU, are rejected.
+*/
+func (a *PonSimOnuAuth) Authenticate(serialNumber string, loid string, password string, registrationId string) (string, error) {
+	if a == nil {
+		return AUTH_NONE, nil
+	}
+
+	credentials, ok := a.Credentials[serialNumber]
+	if !ok {
+		if credentials, ok = a.Credentials[AUTH_ANY_ONU]; !ok {
+			return AUTH_NONE, fmt.Errorf("no credentials configured for ONU %s", serialNumber)
+		}
+	}
+
+	switch credentials.Mode {
+	case AUTH_LOID:
+		if loid != credentials.Loid || password != credentials.Password {
+			return credentials.Mode, fmt.Errorf("invalid LOID or password for ONU %s", serialNumber)
+		}
+	case AUTH_REGISTRATION_ID:
+		if registrationId != credentials.RegistrationId {
+			return credentials.Mode, fmt.Errorf("invalid registration ID for ONU %s", serialNumber)
+		}
+	}
+
+	return credentials.Mode, nil
+}
+
+/*
+newAuthFailureEvent creates an event reporting that an ONU failed to authenticate
+*/
+func newAuthFailureEvent(device string, serialNumber string, mode string, reason string) *voltha.PonSimEvent {
+	return &voltha.PonSimEvent{
+		Device: device,
+		Event: &voltha.PonSimEvent_AuthFailure{
+			AuthFailure: &voltha.PonSimOnuAuthFailure{
+				SerialNumber: serialNumber,
+				Mode:         mode,
+				Reason:       reason,
+			},
+		},
+	}
+}
+
+/*
+AuthenticateOnu checks the credentials presented by a registering ONU and raises an
+authentication failure event when they are rejected
+*/
+func (o *PonSimOltDevice) AuthenticateOnu(onu *PonSimOnuDevice) error {
+	mode, err := o.OnuAuth.Authenticate(onu.SerialNumber, onu.Loid, onu.LoidPassword, onu.RegistrationId)
+	if err != nil {
+		common.Logger().WithFields(logrus.Fields{
+			"device":       o.Name,
+			"serialNumber": onu.SerialNumber,
+			"mode":         mode,
+			"error":        err.Error(),
+		}).Warn("ONU failed to authenticate")
+
+		o.publishEvent(newAuthFailureEvent(o.Name, onu.SerialNumber, mode, err.Error()))
+	}
+
+	return err
+}
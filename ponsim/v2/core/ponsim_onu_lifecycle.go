@@ -0,0 +1,226 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	// The ONU announces its serial number and waits for the OLT to accept its registration
+	ONU_STATE_DISCOVERED = "discovered"
+
+	// The OLT accepted the registration of the ONU, which connects its interfaces
+	ONU_STATE_ACTIVATING = "activating"
+
+	// The ONU forwards the traffic of its subscribers
+	ONU_STATE_ACTIVE = "active"
+
+	// The ONU was administratively disabled and drops all frames until it is enabled
+	ONU_STATE_DISABLED = "disabled"
+
+	// The registration of the ONU was rejected or its image failed to boot
+	ONU_STATE_FAILED = "failed"
+)
+
+/*
+onuTransitions lists the states which each state of the ONU may change to
+*/
+var onuTransitions = map[string][]string{
+	ONU_STATE_DISCOVERED: {ONU_STATE_ACTIVATING, ONU_STATE_DISABLED, ONU_STATE_FAILED},
+	ONU_STATE_ACTIVATING: {ONU_STATE_ACTIVE, ONU_STATE_DISCOVERED, ONU_STATE_DISABLED, ONU_STATE_FAILED},
+	ONU_STATE_ACTIVE:     {ONU_STATE_DISCOVERED, ONU_STATE_DISABLED, ONU_STATE_FAILED},
+	ONU_STATE_DISABLED:   {ONU_STATE_DISCOVERED, ONU_STATE_ACTIVE},
+	ONU_STATE_FAILED:     {ONU_STATE_DISCOVERED, ONU_STATE_DISABLED},
+}
+
+/*
+PonSimOnuLifecycle holds the state of an ONU along its lifecycle.  The administrative state
+prevails over the operational one: a disabled ONU only leaves that state when it is enabled.
+*/
+type PonSimOnuLifecycle struct {
+	State  string    `json:"state"`
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+
+	mutex sync.Mutex
+}
+
+/*
+init sets the initial state of an ONU which just booted, the lifecycle lock being held
+*/
+func (l *PonSimOnuLifecycle) init() {
+	if l.State == "" {
+		l.State = ONU_STATE_DISCOVERED
+		l.Since = time.Now()
+	}
+}
+
+/*
+transition changes the state of the lifecycle, the lock being held, and returns the previous
+state.  A change to the state which the lifecycle is already in is accepted and has no effect.
+*/
+func (l *PonSimOnuLifecycle) transition(state string, reason string) (string, error) {
+	l.init()
+	previous := l.State
+	if previous == state {
+		return previous, nil
+	}
+
+	allowed := false
+	for _, next := range onuTransitions[previous] {
+		allowed = allowed || next == state
+	}
+	if !allowed {
+		return previous, fmt.Errorf("ONU cannot change from state %s to %s", previous, state)
+	}
+
+	l.State = state
+	l.Reason = reason
+	l.Since = time.Now()
+
+	return previous, nil
+}
+
+/*
+GetOnuState returns the lifecycle state of the ONU, the reason of its last change and when it
+changed
+*/
+func (o *PonSimOnuDevice) GetOnuState() (string, string, time.Time) {
+	o.lifecycle.mutex.Lock()
+	defer o.lifecycle.mutex.Unlock()
+
+	o.lifecycle.init()
+	return o.lifecycle.State, o.lifecycle.Reason, o.lifecycle.Since
+}
+
+/*
+IsDisabled returns whether the ONU was administratively disabled
+*/
+func (o *PonSimOnuDevice) IsDisabled() bool {
+	state, _, _ := o.GetOnuState()
+	return state == ONU_STATE_DISABLED
+}
+
+/*
+setOnuState moves the ONU to a state reached through its registration or through a fault; the
+change is ignored while the ONU is disabled
+*/
+func (o *PonSimOnuDevice) setOnuState(state string, reason string) {
+	o.lifecycle.mutex.Lock()
+	if o.lifecycle.State == ONU_STATE_DISABLED {
+		o.lifecycle.mutex.Unlock()
+		return
+	}
+	previous, err := o.lifecycle.transition(state, reason)
+	o.lifecycle.mutex.Unlock()
+
+	if err != nil {
+		common.Logger().WithFields(logrus.Fields{
+			"device": o.Name,
+			"state":  state,
+			"error":  err.Error(),
+		}).Warn("Ignoring change of ONU state")
+		return
+	}
+	if previous != state {
+		o.onuStateChanged(previous, state, reason)
+	}
+}
+
+/*
+EnableOnu resumes the operation of a disabled ONU, which becomes active again when it is still
+registered with the OLT
+*/
+func (o *PonSimOnuDevice) EnableOnu() error {
+	state := ONU_STATE_DISCOVERED
+	if o.state == CONNECTED_IO_INTERFACE {
+		state = ONU_STATE_ACTIVE
+	}
+
+	return o.setAdminState(true, state)
+}
+
+/*
+DisableOnu administratively disables the ONU, which drops the frames of its subscribers until
+it is enabled again
+*/
+func (o *PonSimOnuDevice) DisableOnu() error {
+	return o.setAdminState(false, ONU_STATE_DISABLED)
+}
+
+func (o *PonSimOnuDevice) setAdminState(enabled bool, state string) error {
+	reason := "administratively disabled"
+	if enabled {
+		reason = "administratively enabled"
+	}
+
+	o.lifecycle.mutex.Lock()
+	o.lifecycle.init()
+	if disabled := o.lifecycle.State == ONU_STATE_DISABLED; disabled != enabled {
+		o.lifecycle.mutex.Unlock()
+		if enabled {
+			return errors.New("ONU is not disabled")
+		}
+		return errors.New("ONU is already disabled")
+	}
+	previous, err := o.lifecycle.transition(state, reason)
+	o.lifecycle.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+	o.onuStateChanged(previous, state, reason)
+
+	return nil
+}
+
+/*
+onuStateChanged reports a change of state of the ONU
+*/
+func (o *PonSimOnuDevice) onuStateChanged(previous string, state string, reason string) {
+	common.Logger().WithFields(logrus.Fields{
+		"device":   o.Name,
+		"previous": previous,
+		"state":    state,
+		"reason":   reason,
+	}).Info("ONU changed state")
+
+	o.publishEvent(newOnuStateEvent(o.Name, o.GetSerialNumber(), previous, state, reason))
+}
+
+/*
+newOnuStateEvent creates an event reporting a change of state of an ONU
+*/
+func newOnuStateEvent(device string, serialNumber string, previous string, state string, reason string) *voltha.PonSimEvent {
+	return &voltha.PonSimEvent{
+		Device: device,
+		Event: &voltha.PonSimEvent_OnuState{
+			OnuState: &voltha.PonSimOnuStateChange{
+				SerialNumber:  serialNumber,
+				PreviousState: previous,
+				State:         state,
+				Reason:        reason,
+			},
+		},
+	}
+}
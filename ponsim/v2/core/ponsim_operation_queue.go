@@ -0,0 +1,79 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DEFAULT_OPERATION_QUEUE_DEPTH = 16
+)
+
+/*
+ErrDeviceBusy is returned when an operation is submitted to a full operation queue
+*/
+var ErrDeviceBusy = errors.New("device is busy")
+
+/*
+PonSimOperationQueue models the bounded queue of control operations pending on an ONU.
+
+Operations are executed one at a time, each taking at least the configured processing
+delay, and operations submitted while the queue is full are rejected.
+*/
+type PonSimOperationQueue struct {
+	Depth int           `json:"depth"`
+	Delay time.Duration `json:"delay"`
+
+	Pending  int32 `json:"pending"`
+	Rejected int64 `json:"rejected"`
+
+	mutex sync.Mutex
+}
+
+/*
+NewPonSimOperationQueue instantiates an operation queue holding up to depth operations
+*/
+func NewPonSimOperationQueue(depth int, delay time.Duration) *PonSimOperationQueue {
+	if depth <= 0 {
+		depth = DEFAULT_OPERATION_QUEUE_DEPTH
+	}
+	return &PonSimOperationQueue{Depth: depth, Delay: delay}
+}
+
+/*
+Submit queues an operation and waits for its completion
+*/
+func (q *PonSimOperationQueue) Submit(operation func() error) error {
+	if atomic.AddInt32(&q.Pending, 1) > int32(q.Depth) {
+		atomic.AddInt32(&q.Pending, -1)
+		atomic.AddInt64(&q.Rejected, 1)
+		return ErrDeviceBusy
+	}
+	defer atomic.AddInt32(&q.Pending, -1)
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.Delay > 0 {
+		time.Sleep(q.Delay)
+	}
+
+	return operation()
+}
@@ -0,0 +1,325 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// Launch power of the lasers of the OLT PON ports and of the ONUs, in dBm
+	DEFAULT_OLT_TX_POWER = 3.0
+	DEFAULT_ONU_TX_POWER = 2.0
+
+	// The ODN attenuates the light through its splitters and along the fiber
+	SPLITTER_LOSS     = 17.0
+	FIBER_LOSS_PER_KM = 0.35
+
+	// Below their sensitivity the receivers lose the signal, in dBm
+	OLT_RX_SENSITIVITY = -28.0
+	ONU_RX_SENSITIVITY = -27.0
+
+	// The power levels wander within the drift, in dB, moving at most a tenth of it per step
+	DEFAULT_OPTICAL_DRIFT = 0.5
+	MAX_OPTICAL_DRIFT     = 10.0
+	OPTICS_DRIFT_INTERVAL = time.Second
+
+	// Power levels are reported as GRPC metrics in hundredths of dBm
+	OPTICS_POWER_SCALE = 100
+)
+
+/*
+PonSimOptics simulates the optical power levels of the link of an ONU.  The power received at
+each end is the launch power of the other end less the loss of the ODN, any injected
+degradation and a drift.  A receiver loses the signal (LOS) when the power falls below its
+sensitivity, the link then carrying no frames.
+*/
+type PonSimOptics struct {
+	TxPower     float64 `json:"tx_power"`    // Launch power of the ONU
+	Loss        float64 `json:"loss"`        // Attenuation of the ODN
+	Degradation float64 `json:"degradation"` // Injected loss, on top of the attenuation
+	Drift       float64 `json:"drift"`
+
+	mutex sync.Mutex
+
+	// Current drift of the upstream and downstream power levels
+	upstreamDrift   float64
+	downstreamDrift float64
+
+	los      bool
+	losAlarm *Alarm
+}
+
+/*
+NewPonSimOptics instantiates the optical link of an ONU at the specified distance from the
+OLT, in meters
+*/
+func NewPonSimOptics(txPower float64, distance uint32, drift float64) (*PonSimOptics, error) {
+	if drift < 0 || drift > MAX_OPTICAL_DRIFT {
+		return nil, fmt.Errorf("invalid optical drift: %g", drift)
+	}
+
+	return &PonSimOptics{
+		TxPower: txPower,
+		Loss:    SPLITTER_LOSS + float64(distance)/1000*FIBER_LOSS_PER_KM,
+		Drift:   drift,
+	}, nil
+}
+
+/*
+Set changes the launch power of the ONU and the injected degradation of the link
+*/
+func (p *PonSimOptics) Set(txPower float64, degradation float64) error {
+	if degradation < 0 {
+		return fmt.Errorf("invalid degradation: %g", degradation)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.TxPower = txPower
+	p.Degradation = degradation
+
+	return nil
+}
+
+/*
+GetTxPower returns the launch power of the ONU
+*/
+func (p *PonSimOptics) GetTxPower() float64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.TxPower
+}
+
+/*
+GetRxPower returns the power received by the ONU from a PON port launching at oltTxPower
+*/
+func (p *PonSimOptics) GetRxPower(oltTxPower float64) float64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return oltTxPower - p.Loss - p.Degradation + p.downstreamDrift
+}
+
+/*
+GetOltRxPower returns the power received by the OLT from the ONU
+*/
+func (p *PonSimOptics) GetOltRxPower() float64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.TxPower - p.Loss - p.Degradation + p.upstreamDrift
+}
+
+/*
+IsLos reports whether the signal of the link is lost
+*/
+func (p *PonSimOptics) IsLos() bool {
+	if p == nil {
+		return false
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.los
+}
+
+/*
+releaseLosAlarm returns the LOS alarm raised for the link, if any, so that it gets cleared
+*/
+func (p *PonSimOptics) releaseLosAlarm() *Alarm {
+	if p == nil {
+		return nil
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	alarm := p.losAlarm
+	p.los = false
+	p.losAlarm = nil
+
+	return alarm
+}
+
+/*
+drift moves the power levels a step in a random direction, within the drift
+*/
+func (p *PonSimOptics) drift() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	step := func(current float64) float64 {
+		current += (rand.Float64()*2 - 1) * p.Drift / 10
+		if current > p.Drift {
+			return p.Drift
+		} else if current < -p.Drift {
+			return -p.Drift
+		}
+		return current
+	}
+	p.upstreamDrift = step(p.upstreamDrift)
+	p.downstreamDrift = step(p.downstreamDrift)
+}
+
+/*
+MakeProto returns the power levels of the link of an ONU, named after its port, e.g. optics128.
+The power levels are reported in hundredths of dBm.
+*/
+func (p *PonSimOptics) MakeProto(onuPort int32, oltTxPower float64) *voltha.PonSimPortMetrics {
+	los := int64(0)
+	if p.IsLos() {
+		los = 1
+	}
+
+	return &voltha.PonSimPortMetrics{
+		PortName: fmt.Sprintf("optics%d", onuPort),
+		Packets: []*voltha.PonSimPacketCounter{
+			{Name: "onu_tx_power", Value: toCentiDbm(p.GetTxPower())},
+			{Name: "onu_rx_power", Value: toCentiDbm(p.GetRxPower(oltTxPower))},
+			{Name: "olt_rx_power", Value: toCentiDbm(p.GetOltRxPower())},
+			{Name: "los", Value: los},
+		},
+	}
+}
+
+func toCentiDbm(power float64) int64 {
+	if power < 0 {
+		return int64(power*OPTICS_POWER_SCALE - 0.5)
+	}
+	return int64(power*OPTICS_POWER_SCALE + 0.5)
+}
+
+/*
+GetOnuOltTxPower returns the launch power of the PON port of a registered ONU
+*/
+func (o *PonSimOltDevice) GetOnuOltTxPower(onuPort int32) float64 {
+	if pon := o.GetOnuPonPort(onuPort); pon != nil {
+		return pon.TxPower
+	}
+	return DEFAULT_OLT_TX_POWER
+}
+
+/*
+SetOnuOptics configures the launch power of a registered ONU and degrades its link, the loss
+of signal being evaluated right away
+*/
+func (o *PonSimOltDevice) SetOnuOptics(onuPort int32, txPower float64, degradation float64) (*PonSimOptics, error) {
+	onu := o.GetOnu(onuPort)
+	if onu == nil || onu.Optics == nil {
+		return nil, fmt.Errorf("no ONU on port %d", onuPort)
+	}
+
+	if err := onu.Optics.Set(txPower, degradation); err != nil {
+		return nil, err
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"device":      o,
+		"port":        onuPort,
+		"txPower":     txPower,
+		"degradation": degradation,
+	}).Info("Configured ONU optics")
+
+	o.checkSignal(onuPort, onu.Optics)
+
+	return onu.Optics, nil
+}
+
+/*
+monitorOptics drifts the power levels of the links of the ONUs and checks their signal, until
+the context is cancelled
+*/
+func (o *PonSimOltDevice) monitorOptics(ctx context.Context) {
+	ticker := time.NewTicker(OPTICS_DRIFT_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for port, onu := range o.GetOnus() {
+				if onu.Optics != nil {
+					onu.Optics.drift()
+					o.checkSignal(port, onu.Optics)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+/*
+checkSignal raises a LOS alarm when either end of the link of an ONU receives less than its
+sensitivity, and clears it once the signal is restored
+*/
+func (o *PonSimOltDevice) checkSignal(onuPort int32, optics *PonSimOptics) {
+	onuRxPower := optics.GetRxPower(o.GetOnuOltTxPower(onuPort))
+	oltRxPower := optics.GetOltRxPower()
+	los := onuRxPower < ONU_RX_SENSITIVITY || oltRxPower < OLT_RX_SENSITIVITY
+
+	optics.mutex.Lock()
+	if los == optics.los {
+		optics.mutex.Unlock()
+		return
+	}
+	optics.los = los
+
+	var raised, cleared *Alarm
+	if los {
+		now := o.Clock.Now()
+		raised = &Alarm{
+			Severity:    int(voltha.AlarmEventSeverity_MAJOR),
+			Type:        int(voltha.AlarmEventType_COMMUNICATION),
+			Category:    int(voltha.AlarmEventCategory_PON),
+			TimeStamp:   now.UTC().Second(),
+			Description: fmt.Sprintf("LOS of ONU on port %d (ONU rx %.2f dBm, OLT rx %.2f dBm)", onuPort, onuRxPower, oltRxPower),
+			raisedAt:    now,
+		}
+		optics.losAlarm = raised
+	} else {
+		cleared = optics.losAlarm
+		optics.losAlarm = nil
+	}
+	optics.mutex.Unlock()
+
+	common.Logger().WithFields(logrus.Fields{
+		"device":     o,
+		"port":       onuPort,
+		"onuRxPower": onuRxPower,
+		"oltRxPower": oltRxPower,
+		"los":        los,
+	}).Warn("ONU signal changed")
+
+	if o.alarms == nil {
+		return
+	}
+	if raised != nil {
+		o.alarms.raiseAlarm(raised)
+	} else if cleared != nil {
+		o.alarms.clearAlarm(cleared)
+	}
+}
@@ -0,0 +1,208 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"github.com/google/gopacket"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/openflow_13"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Frames output to the controller are delivered to the links of this reserved port
+	CONTROLLER_PORT = uint32(openflow_13.OfpPortNo_OFPP_CONTROLLER)
+
+	// The controller-bound flows decomposed by VOLTHA tag the frames with this VLAN on top of the
+	// VLAN of their logical port, and output them to the NNI
+	PACKET_IN_VLAN = 4000
+)
+
+/*
+SetPacketIn attaches the packet-in metadata to a frame output to the controller
+*/
+func SetPacketIn(frame gopacket.Packet, packetIn *voltha.PonSimPacketIn) {
+	metadata := frame.Metadata()
+	for i, data := range metadata.AncillaryData {
+		if _, ok := data.(*voltha.PonSimPacketIn); ok {
+			metadata.AncillaryData[i] = packetIn
+			return
+		}
+	}
+	metadata.AncillaryData = append(metadata.AncillaryData, packetIn)
+}
+
+/*
+GetPacketIn returns the packet-in metadata attached to a frame, if any
+*/
+func GetPacketIn(frame gopacket.Packet) *voltha.PonSimPacketIn {
+	for _, data := range frame.Metadata().AncillaryData {
+		if packetIn, ok := data.(*voltha.PonSimPacketIn); ok {
+			return packetIn
+		}
+	}
+
+	return nil
+}
+
+/*
+isTrappedFrame reports whether a frame is tagged by a controller-bound flow decomposed by VOLTHA
+*/
+func isTrappedFrame(frame gopacket.Packet) bool {
+	data := frame.Data()
+	if tci, ok := outerVlanTci(data); !ok || tci&VLAN_VID_MASK != PACKET_IN_VLAN {
+		return false
+	}
+	_, ok := outerVlanTci(data[VLAN_TAG_LENGTH:])
+	return ok
+}
+
+/*
+isTrappedOutput reports whether a frame is trapped to VOLTHA by its output to the port carrying
+the trapped frames; only the NNI of an OLT does
+*/
+func (o *PonSimDevice) isTrappedOutput(output ponSimOutput) bool {
+	return o.trapPort > 0 && int(output.Port) == o.trapPort && isTrappedFrame(output.Frame)
+}
+
+/*
+newPacketIn describes a frame received on a port of the device and sent to the controller
+*/
+func newPacketIn(
+	port int,
+	flow *openflow_13.OfpFlowStats,
+	reason openflow_13.OfpPacketInReason,
+) *voltha.PonSimPacketIn {
+	packetIn := &voltha.PonSimPacketIn{
+		InPort:      uint32(port),
+		LogicalPort: uint32(port),
+		Reason:      reason,
+	}
+	if flow != nil {
+		packetIn.FlowId = flow.Id
+		packetIn.Cookie = flow.Cookie
+		packetIn.TableId = flow.TableId
+	}
+
+	return packetIn
+}
+
+/*
+withPacketIn returns a copy of a frame carrying the packet-in metadata, since the frame may be
+shared with other outputs
+*/
+func withPacketIn(frame gopacket.Packet, packetIn *voltha.PonSimPacketIn) gopacket.Packet {
+	frame = common.NewFrame(append([]byte(nil), frame.Data()...))
+	SetPacketIn(frame, packetIn)
+
+	return frame
+}
+
+/*
+sendToController delivers a frame output to the controller by a flow
+*/
+func (o *PonSimDevice) sendToController(port int, frame gopacket.Packet) {
+	packetIn := GetPacketIn(frame)
+
+	links := o.links.get(int(CONTROLLER_PORT))
+	if len(links) == 0 {
+		forwardingLogger.WithFields(logrus.Fields{
+			"device": o,
+			"port":   port,
+			"frame":  frame,
+		}).Warn("No controller to send the packet to")
+		return
+	}
+
+	if entry := forwardingLogger.ForFrame(logrus.InfoLevel); entry != nil {
+		entry.WithFields(logrus.Fields{
+			"device":   o,
+			"port":     port,
+			"frame":    frame,
+			"packetIn": packetIn,
+		}).Info("Sending packet to controller")
+	}
+
+	for _, link := range links {
+		link.(func(int, gopacket.Packet))(int(CONTROLLER_PORT), frame)
+	}
+}
+
+/*
+forwardToController defines the function delivering packet-ins to VOLTHA along with the control frames.
+The frames received on a PON are tagged with the logical port of the ONU UNI, and trapped frames
+with the trap VLAN on top of their logical port: the tags are removed so that VOLTHA gets the frame
+as it was received on the logical port.
+*/
+func (o *PonSimOltDevice) forwardToController() func(int, gopacket.Packet) {
+	return func(port int, frame gopacket.Packet) {
+		packetIn := GetPacketIn(frame)
+		if packetIn == nil {
+			return
+		}
+
+		data := frame.Data()
+		tci, tagged := outerVlanTci(data)
+		vid := uint32(tci & VLAN_VID_MASK)
+
+		var tags int
+		switch {
+		case isTrappedFrame(frame):
+			// The trap VLAN is removed along with the VLAN of the logical port underneath
+			inner, _ := outerVlanTci(data[VLAN_TAG_LENGTH:])
+			packetIn.LogicalPort = uint32(inner & VLAN_VID_MASK)
+			tags = 2
+		case packetIn.InPort == 2:
+			// The frames received on the NNI are only tagged by the device to present them to the flows
+			packetIn.LogicalPort = NNI_LOGICAL_PORT
+			if tagged && vid == NNI_LOGICAL_PORT {
+				tags = 1
+			}
+		case tagged:
+			packetIn.LogicalPort = vid
+			tags = 1
+		}
+
+		if tags > 0 {
+			hashed := common.GetFrameHash(frame) != nil
+			for ; tags > 0; tags-- {
+				frame = popVlanTag(frame)
+			}
+			if hashed {
+				common.SetFrameHash(frame, common.HashFrame(frame.Data()))
+			}
+			SetPacketIn(frame, packetIn)
+		}
+
+		select {
+		case o.control <- frame:
+			o.Counter.CountCpuFrame(true, false)
+			if entry := forwardingLogger.ForFrame(logrus.InfoLevel); entry != nil {
+				entry.WithFields(logrus.Fields{
+					"frame":    frame,
+					"packetIn": packetIn,
+				}).Info("Sent packet-in")
+			}
+		default:
+			o.Counter.CountCpuFrame(true, true)
+			forwardingLogger.WithFields(logrus.Fields{
+				"frame":    frame,
+				"packetIn": packetIn,
+			}).Warn("Unable to send packet-in")
+		}
+	}
+}
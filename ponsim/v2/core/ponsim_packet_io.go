@@ -0,0 +1,290 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+)
+
+const (
+	PACKET_IO_AUTO = "auto"
+	PACKET_IO_PCAP = "pcap"
+	PACKET_IO_UDP  = "udp"
+	PACKET_IO_NONE = "none"
+
+	BRIDGE_IO_AF_PACKET = "afpacket"
+	BRIDGE_IO_AF_XDP    = "afxdp"
+)
+
+/*
+PonSimPacketHandle reads and writes the frames of a network interface of a device
+*/
+type PonSimPacketHandle interface {
+	gopacket.PacketDataSource
+	WritePacketData(data []byte) error
+	LinkType() layers.LinkType
+
+	// SetInboundOnly restricts reading to the frames which were not written through the handle
+	SetInboundOnly() error
+	Close()
+}
+
+// openPcap is only set when the simulator is built with libpcap support
+var openPcap func(ifName string, snapshotLen int32, promiscuous bool) (PonSimPacketHandle, error)
+
+// openAfPacket is only set on the platforms providing AF_PACKET sockets
+var openAfPacket func(ifName string, snapshotLen int32, promiscuous bool) (PonSimPacketHandle, error)
+
+// openAfXdp is only set when the simulator is built with the afxdp tag on Linux
+var openAfXdp func(ifName string, snapshotLen int32, promiscuous bool) (PonSimPacketHandle, error)
+
+type ponSimTunnel struct {
+	Local string
+	Peer  string
+}
+
+/*
+PonSimPacketIO selects how the frames of the network interfaces of a device are exchanged
+
+The pcap backend captures and injects frames on the interfaces themselves, which requires
+libpcap and raw socket privileges.  The udp backend instead maps each interface to a tunnel
+carrying one Ethernet frame per datagram, which runs unprivileged on any platform.  With the
+none backend the interfaces carry no frames at all and the data plane is only reachable through
+GRPC (PON links, VOLTHA packet-in/out and frame injection), which requires no privileges nor
+network setup.
+
+The auto backend uses pcap whenever raw sockets can be opened and otherwise falls back to the
+tunnel configured for the interface, or to no frames at all.
+
+Whatever the backend, an interface may be bridged to a host device, such as a tap or veth
+device, whose frames are exchanged through an AF_PACKET socket, or through an AF_XDP socket
+when the simulator is built with the afxdp tag and the kernel supports it.
+*/
+type PonSimPacketIO struct {
+	Backend  string
+	BridgeIO string
+	tunnels  map[string]ponSimTunnel
+	bridges  map[string]string
+}
+
+/*
+NewPonSimPacketIO instantiates the packet I/O of a device using the specified backend (auto if
+empty) and a comma separated list of tunnels in the format interface=local_address/peer_address,
+e.g. eth1=:50100/127.0.0.1:50101
+*/
+func NewPonSimPacketIO(backend string, tunnels string) (*PonSimPacketIO, error) {
+	if backend == "" {
+		backend = PACKET_IO_AUTO
+	}
+
+	switch backend {
+	case PACKET_IO_PCAP:
+		if openPcap == nil {
+			return nil, fmt.Errorf("packet I/O backend %s is not available in this build", backend)
+		}
+	case PACKET_IO_AUTO, PACKET_IO_UDP, PACKET_IO_NONE:
+	default:
+		return nil, fmt.Errorf("unknown packet I/O backend: %s", backend)
+	}
+
+	packetIO := &PonSimPacketIO{
+		Backend:  backend,
+		BridgeIO: BRIDGE_IO_AF_PACKET,
+		tunnels:  make(map[string]ponSimTunnel),
+		bridges:  make(map[string]string),
+	}
+
+	for _, entry := range strings.Split(tunnels, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		fields := strings.SplitN(entry, "=", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid tunnel specification: %s", entry)
+		}
+
+		addresses := strings.SplitN(fields[1], "/", 2)
+		if len(addresses) != 2 || addresses[1] == "" {
+			return nil, fmt.Errorf("invalid tunnel addresses: %s", fields[1])
+		}
+
+		packetIO.tunnels[fields[0]] = ponSimTunnel{Local: addresses[0], Peer: addresses[1]}
+	}
+
+	return packetIO, nil
+}
+
+/*
+ParseUniBridges parses the host devices to which the UNIs of the simulated ONUs are bridged, as
+a comma separated list of onu=device entries where the ONUs are numbered from 1, e.g. 1=rg1,2=rg2
+*/
+func ParseUniBridges(spec string) (map[int]string, error) {
+	bridges := make(map[int]string)
+
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		fields := strings.SplitN(entry, "=", 2)
+		if len(fields) != 2 || fields[1] == "" {
+			return nil, fmt.Errorf("invalid UNI bridge specification: %s", entry)
+		}
+
+		onu, err := strconv.Atoi(fields[0])
+		if err != nil || onu < 1 {
+			return nil, fmt.Errorf("invalid ONU number: %s", fields[0])
+		}
+		if _, ok := bridges[onu]; ok {
+			return nil, fmt.Errorf("UNI of ONU %d is bridged more than once", onu)
+		}
+
+		bridges[onu] = fields[1]
+	}
+
+	return bridges, nil
+}
+
+/*
+Bridge makes a network interface exchange its frames with a host device through an AF_PACKET
+socket, regardless of the backend
+*/
+func (p *PonSimPacketIO) Bridge(ifName string, hostIf string) error {
+	if openAfPacket == nil {
+		return fmt.Errorf("AF_PACKET sockets are not available on this platform")
+	}
+
+	p.bridges[ifName] = hostIf
+
+	return nil
+}
+
+/*
+SetBridgeIO selects the sockets through which the frames of the bridged host devices are
+exchanged, either afpacket or afxdp
+*/
+func (p *PonSimPacketIO) SetBridgeIO(bridgeIO string) error {
+	switch bridgeIO {
+	case BRIDGE_IO_AF_PACKET:
+	case BRIDGE_IO_AF_XDP:
+		if openAfXdp == nil {
+			return fmt.Errorf("bridge I/O %s is not available in this build", bridgeIO)
+		}
+	default:
+		return fmt.Errorf("unknown bridge I/O: %s", bridgeIO)
+	}
+
+	p.BridgeIO = bridgeIO
+
+	return nil
+}
+
+/*
+Open returns a handle reading and writing the frames of a network interface
+*/
+func (p *PonSimPacketIO) Open(ifName string, snapshotLen int32, promiscuous bool) (PonSimPacketHandle, error) {
+	if hostIf, ok := p.getBridge(ifName); ok {
+		common.Logger().WithFields(logrus.Fields{
+			"interface": ifName,
+			"host":      hostIf,
+		}).Info("Bridging interface to host device")
+		return p.openBridge(hostIf, snapshotLen, promiscuous)
+	}
+
+	if p == nil || p.Backend == PACKET_IO_PCAP {
+		if openPcap == nil {
+			return nil, fmt.Errorf("packet I/O backend %s is not available in this build", PACKET_IO_PCAP)
+		}
+		return openPcap(ifName, snapshotLen, promiscuous)
+	}
+
+	tunnel, hasTunnel := p.tunnels[ifName]
+
+	switch p.Backend {
+	case PACKET_IO_UDP:
+		if !hasTunnel {
+			return nil, fmt.Errorf("no tunnel configured for interface %s", ifName)
+		}
+		return openUdpTunnel(tunnel, snapshotLen)
+
+	case PACKET_IO_NONE:
+		return newNullHandle(), nil
+	}
+
+	// Raw sockets are preferred as long as the process is allowed to open them
+	if openPcap != nil {
+		handle, err := openPcap(ifName, snapshotLen, promiscuous)
+		if err == nil {
+			return handle, nil
+		}
+
+		common.Logger().WithFields(logrus.Fields{
+			"interface": ifName,
+			"error":     err.Error(),
+		}).Warn("Unable to open raw socket, running unprivileged")
+	}
+
+	if hasTunnel {
+		common.Logger().WithFields(logrus.Fields{
+			"interface": ifName,
+			"local":     tunnel.Local,
+			"peer":      tunnel.Peer,
+		}).Info("Exchanging frames through UDP tunnel")
+		return openUdpTunnel(tunnel, snapshotLen)
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"interface": ifName,
+	}).Info("Interface carries no frames, data plane is only reachable through GRPC")
+
+	return newNullHandle(), nil
+}
+
+func (p *PonSimPacketIO) getBridge(ifName string) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+
+	hostIf, ok := p.bridges[ifName]
+	return hostIf, ok
+}
+
+/*
+openBridge opens the socket of a bridged host device, falling back to AF_PACKET when AF_XDP is
+not supported by the kernel or the device
+*/
+func (p *PonSimPacketIO) openBridge(hostIf string, snapshotLen int32, promiscuous bool) (PonSimPacketHandle, error) {
+	if p.BridgeIO == BRIDGE_IO_AF_XDP && openAfXdp != nil {
+		handle, err := openAfXdp(hostIf, snapshotLen, promiscuous)
+		if err == nil {
+			return handle, nil
+		}
+
+		common.Logger().WithFields(logrus.Fields{
+			"host":  hostIf,
+			"error": err.Error(),
+		}).Warn("Unable to open AF_XDP socket, falling back to AF_PACKET")
+	}
+
+	return openAfPacket(hostIf, snapshotLen, promiscuous)
+}
@@ -0,0 +1,239 @@
+// +build linux
+
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"encoding/binary"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	// Time after which a read on a socket checks whether the socket was closed
+	AF_PACKET_READ_TIMEOUT = 100 * time.Millisecond
+
+	// Socket option and status flags of the kernel reporting the VLAN tag stripped from a frame
+	PACKET_AUXDATA            = 8
+	TP_STATUS_VLAN_VALID      = 1 << 4
+	TP_STATUS_VLAN_TPID_VALID = 1 << 6
+)
+
+/*
+afPacketHandle reads and writes the frames of a host interface, such as a tap or veth device,
+through an AF_PACKET socket bound to it
+*/
+type afPacketHandle struct {
+	fd          int
+	snapshotLen int32
+	inboundOnly int32
+	closed      int32
+}
+
+// packetMreq mirrors the packet_mreq structure of the kernel
+type packetMreq struct {
+	ifindex int32
+	mrType  uint16
+	alen    uint16
+	address [8]byte
+}
+
+// tpacketAuxdata mirrors the tpacket_auxdata structure of the kernel
+type tpacketAuxdata struct {
+	status   uint32
+	length   uint32
+	snapLen  uint32
+	mac      uint16
+	net      uint16
+	vlanTci  uint16
+	vlanTpid uint16
+}
+
+func init() {
+	openAfPacket = func(ifName string, snapshotLen int32, promiscuous bool) (PonSimPacketHandle, error) {
+		iface, err := net.InterfaceByName(ifName)
+		if err != nil {
+			return nil, err
+		}
+
+		fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(syscall.ETH_P_ALL)))
+		if err != nil {
+			return nil, err
+		}
+
+		if err = afPacketSetup(fd, iface.Index, promiscuous); err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+
+		return &afPacketHandle{fd: fd, snapshotLen: snapshotLen}, nil
+	}
+}
+
+/*
+afPacketSetup binds a socket to an interface, optionally in promiscuous mode, and bounds the
+time its reads block.  The kernel strips the VLAN tag of the frames received on interfaces
+offloading it, the tag is therefore requested alongside each frame.
+*/
+func afPacketSetup(fd int, ifIndex int, promiscuous bool) error {
+	address := &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ALL), Ifindex: ifIndex}
+	if err := syscall.Bind(fd, address); err != nil {
+		return err
+	}
+
+	if promiscuous {
+		if err := afPacketPromiscuous(fd, ifIndex); err != nil {
+			return err
+		}
+	}
+
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_PACKET, PACKET_AUXDATA, 1); err != nil {
+		return err
+	}
+
+	timeout := syscall.NsecToTimeval(int64(AF_PACKET_READ_TIMEOUT))
+	return syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout)
+}
+
+/*
+afPacketPromiscuous puts an interface in promiscuous mode for as long as a packet socket is open
+*/
+func afPacketPromiscuous(fd int, ifIndex int) error {
+	mreq := packetMreq{ifindex: int32(ifIndex), mrType: syscall.PACKET_MR_PROMISC}
+	if _, _, errno := syscall.Syscall6(
+		syscall.SYS_SETSOCKOPT,
+		uintptr(fd),
+		syscall.SOL_PACKET,
+		syscall.PACKET_ADD_MEMBERSHIP,
+		uintptr(unsafe.Pointer(&mreq)),
+		unsafe.Sizeof(mreq),
+		0,
+	); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+func htons(value uint16) uint16 {
+	return value<<8 | value>>8
+}
+
+/*
+vlanTag returns the VLAN tag which the kernel stripped from a frame, if any
+*/
+func vlanTag(oob []byte) []byte {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+
+	for _, message := range messages {
+		if message.Header.Level != syscall.SOL_PACKET || message.Header.Type != PACKET_AUXDATA ||
+			len(message.Data) < int(unsafe.Sizeof(tpacketAuxdata{})) {
+			continue
+		}
+
+		auxdata := (*tpacketAuxdata)(unsafe.Pointer(&message.Data[0]))
+		if auxdata.status&TP_STATUS_VLAN_VALID == 0 {
+			return nil
+		}
+
+		tpid := uint16(layers.EthernetTypeDot1Q)
+		if auxdata.status&TP_STATUS_VLAN_TPID_VALID != 0 {
+			tpid = auxdata.vlanTpid
+		}
+
+		tag := make([]byte, 4)
+		binary.BigEndian.PutUint16(tag[0:2], tpid)
+		binary.BigEndian.PutUint16(tag[2:4], auxdata.vlanTci)
+		return tag
+	}
+
+	return nil
+}
+
+func (h *afPacketHandle) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	buffer := make([]byte, 65535)
+	oob := make([]byte, syscall.CmsgSpace(int(unsafe.Sizeof(tpacketAuxdata{}))))
+
+	for {
+		if atomic.LoadInt32(&h.closed) != 0 {
+			return nil, gopacket.CaptureInfo{}, io.EOF
+		}
+
+		n, oobn, _, from, err := syscall.Recvmsg(h.fd, buffer, oob, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			if atomic.LoadInt32(&h.closed) != 0 {
+				err = io.EOF
+			}
+			return nil, gopacket.CaptureInfo{}, err
+		}
+
+		// The frames sent on the interface are looped back to the socket
+		outgoing := false
+		if address, ok := from.(*syscall.SockaddrLinklayer); ok {
+			outgoing = address.Pkttype == syscall.PACKET_OUTGOING
+		}
+		if outgoing && atomic.LoadInt32(&h.inboundOnly) != 0 {
+			continue
+		}
+
+		// The VLAN tag is inserted back after the MAC addresses
+		frame := buffer[:n]
+		if tag := vlanTag(oob[:oobn]); tag != nil && n >= 12 {
+			frame = append(append(append(make([]byte, 0, n+len(tag)), buffer[:12]...), tag...), buffer[12:n]...)
+			n = len(frame)
+		}
+
+		ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: n, Length: n}
+		if h.snapshotLen > 0 && n > int(h.snapshotLen) {
+			ci.CaptureLength = int(h.snapshotLen)
+		}
+
+		return frame[:ci.CaptureLength], ci, nil
+	}
+}
+
+func (h *afPacketHandle) WritePacketData(data []byte) error {
+	_, err := syscall.Write(h.fd, data)
+	return err
+}
+
+func (h *afPacketHandle) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+func (h *afPacketHandle) SetInboundOnly() error {
+	atomic.StoreInt32(&h.inboundOnly, 1)
+	return nil
+}
+
+func (h *afPacketHandle) Close() {
+	if atomic.CompareAndSwapInt32(&h.closed, 0, 1) {
+		syscall.Close(h.fd)
+	}
+}
@@ -0,0 +1,643 @@
+// +build linux,afxdp
+// +build amd64 arm64
+
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	// Frames of the UMEM shared by a socket and the kernel: the first half of them receive
+	// frames and the second half transmit frames
+	AF_XDP_FRAME_SIZE = 4096
+	AF_XDP_FRAMES     = 4096
+	AF_XDP_RING_SIZE  = AF_XDP_FRAMES / 2
+
+	// Time after which a read on a socket checks whether the socket was closed
+	AF_XDP_READ_TIMEOUT = 100 * time.Millisecond
+)
+
+// Kernel interface of AF_XDP sockets, which the syscall package does not provide
+const (
+	AF_XDP                         = 44
+	SOL_XDP                        = 283
+	XDP_MMAP_OFFSETS               = 1
+	XDP_RX_RING                    = 2
+	XDP_TX_RING                    = 3
+	XDP_UMEM_REG                   = 4
+	XDP_UMEM_FILL_RING             = 5
+	XDP_UMEM_COMPLETION_RING       = 6
+	XDP_PGOFF_RX_RING              = 0
+	XDP_PGOFF_TX_RING              = 0x80000000
+	XDP_UMEM_PGOFF_FILL_RING       = 0x100000000
+	XDP_UMEM_PGOFF_COMPLETION_RING = 0x180000000
+
+	BPF_MAP_CREATE        = 0
+	BPF_MAP_UPDATE_ELEM   = 2
+	BPF_PROG_LOAD         = 5
+	BPF_LINK_CREATE       = 28
+	BPF_XDP               = 37
+	BPF_MAP_TYPE_XSKMAP   = 17
+	BPF_PROG_TYPE_XDP     = 6
+	BPF_PSEUDO_MAP_FD     = 1
+	BPF_FUNC_REDIRECT_MAP = 51
+	XDP_PASS              = 2
+
+	IFLA_XDP                    = 43
+	IFLA_XDP_FD                 = 1
+	IFLA_XDP_FLAGS              = 3
+	NLA_F_NESTED                = 0x8000
+	XDP_FLAGS_UPDATE_IF_NOEXIST = 1 << 0
+	XDP_FLAGS_SKB_MODE          = 1 << 1
+	XDP_FLAGS_DRV_MODE          = 1 << 2
+)
+
+/*
+afXdpHandle reads and writes the frames of a host interface through an AF_XDP socket bound to
+its first queue, to which an XDP program redirects the received frames.  The frames are
+exchanged with the kernel through a memory area (UMEM) and rings shared with it, bypassing
+the network stack.
+*/
+type afXdpHandle struct {
+	ifIndex     int
+	snapshotLen int32
+	closed      int32
+
+	fd       int
+	epollFd  int
+	mapFd    int
+	progFd   int
+	linkFd   int
+	packetFd int    // Keeps the interface promiscuous
+	xdpFlags uint32 // Mode of a program attached through netlink, which outlives the process
+
+	umem       []byte
+	fill       *xdpRing
+	completion *xdpRing
+	rx         *xdpRing
+	tx         *xdpRing
+
+	rxMutex sync.Mutex
+	txMutex sync.Mutex
+	txFree  []uint64
+}
+
+/*
+xdpRing is a ring shared with the kernel, whose producer and consumer indices run freely and
+wrap around its size
+*/
+type xdpRing struct {
+	memory   []byte
+	producer *uint32
+	consumer *uint32
+	entries  uint64
+	mask     uint32
+}
+
+// xdpRingOffset mirrors the xdp_ring_offset structure of the kernel
+type xdpRingOffset struct {
+	producer uint64
+	consumer uint64
+	desc     uint64
+	flags    uint64
+}
+
+// xdpDesc mirrors the xdp_desc structure of the kernel
+type xdpDesc struct {
+	addr    uint64
+	len     uint32
+	options uint32
+}
+
+// xdpUmemReg mirrors the first version of the xdp_umem_reg structure of the kernel
+type xdpUmemReg struct {
+	addr      uint64
+	len       uint64
+	chunkSize uint32
+	headroom  uint32
+}
+
+// sockaddrXdp mirrors the sockaddr_xdp structure of the kernel
+type sockaddrXdp struct {
+	family       uint16
+	flags        uint16
+	ifindex      uint32
+	queueId      uint32
+	sharedUmemFd uint32
+}
+
+// bpfInsn mirrors the bpf_insn structure of the kernel, the registers packed in one byte
+type bpfInsn struct {
+	code uint8
+	regs uint8
+	off  int16
+	imm  int32
+}
+
+type bpfMapCreateAttr struct {
+	mapType    uint32
+	keySize    uint32
+	valueSize  uint32
+	maxEntries uint32
+	mapFlags   uint32
+}
+
+type bpfMapUpdateAttr struct {
+	mapFd uint32
+	pad   uint32
+	key   uint64
+	value uint64
+	flags uint64
+}
+
+type bpfLinkCreateAttr struct {
+	progFd     uint32
+	ifIndex    uint32
+	attachType uint32
+	flags      uint32
+}
+
+type bpfProgLoadAttr struct {
+	progType    uint32
+	insnCnt     uint32
+	insns       uint64
+	license     uint64
+	logLevel    uint32
+	logSize     uint32
+	logBuf      uint64
+	kernVersion uint32
+	progFlags   uint32
+}
+
+func init() {
+	openAfXdp = func(ifName string, snapshotLen int32, promiscuous bool) (PonSimPacketHandle, error) {
+		iface, err := net.InterfaceByName(ifName)
+		if err != nil {
+			return nil, err
+		}
+
+		h := &afXdpHandle{
+			ifIndex:     iface.Index,
+			snapshotLen: snapshotLen,
+			fd:          -1,
+			epollFd:     -1,
+			mapFd:       -1,
+			progFd:      -1,
+			linkFd:      -1,
+			packetFd:    -1,
+		}
+		if err := h.open(promiscuous); err != nil {
+			h.release()
+			return nil, err
+		}
+
+		return h, nil
+	}
+}
+
+/*
+open sets up the UMEM and the rings of the socket, binds the socket to the first queue of the
+interface and attaches the XDP program redirecting the frames of that queue to the socket
+*/
+func (h *afXdpHandle) open(promiscuous bool) error {
+	var err error
+
+	if h.umem, err = syscall.Mmap(
+		-1, 0, AF_XDP_FRAMES*AF_XDP_FRAME_SIZE,
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS|syscall.MAP_POPULATE,
+	); err != nil {
+		return err
+	}
+
+	if h.fd, err = syscall.Socket(AF_XDP, syscall.SOCK_RAW, 0); err != nil {
+		return err
+	}
+
+	reg := xdpUmemReg{
+		addr:      uint64(uintptr(unsafe.Pointer(&h.umem[0]))),
+		len:       uint64(len(h.umem)),
+		chunkSize: AF_XDP_FRAME_SIZE,
+	}
+	if err := setsockopt(h.fd, SOL_XDP, XDP_UMEM_REG, unsafe.Pointer(&reg), unsafe.Sizeof(reg)); err != nil {
+		return err
+	}
+	for _, ring := range []int{XDP_UMEM_FILL_RING, XDP_UMEM_COMPLETION_RING, XDP_RX_RING, XDP_TX_RING} {
+		if err := syscall.SetsockoptInt(h.fd, SOL_XDP, ring, AF_XDP_RING_SIZE); err != nil {
+			return err
+		}
+	}
+
+	offsets, err := xdpMmapOffsets(h.fd)
+	if err != nil {
+		return err
+	}
+	if h.rx, err = mapXdpRing(h.fd, offsets[0], XDP_PGOFF_RX_RING, unsafe.Sizeof(xdpDesc{})); err != nil {
+		return err
+	}
+	if h.tx, err = mapXdpRing(h.fd, offsets[1], XDP_PGOFF_TX_RING, unsafe.Sizeof(xdpDesc{})); err != nil {
+		return err
+	}
+	if h.fill, err = mapXdpRing(h.fd, offsets[2], XDP_UMEM_PGOFF_FILL_RING, 8); err != nil {
+		return err
+	}
+	if h.completion, err = mapXdpRing(h.fd, offsets[3], XDP_UMEM_PGOFF_COMPLETION_RING, 8); err != nil {
+		return err
+	}
+
+	// The kernel receives the frames into the first half of the UMEM
+	for i := uint32(0); i < AF_XDP_RING_SIZE; i++ {
+		*h.fill.addr(i) = uint64(i) * AF_XDP_FRAME_SIZE
+	}
+	atomic.StoreUint32(h.fill.producer, AF_XDP_RING_SIZE)
+	for i := uint64(AF_XDP_RING_SIZE); i < AF_XDP_FRAMES; i++ {
+		h.txFree = append(h.txFree, i*AF_XDP_FRAME_SIZE)
+	}
+
+	address := sockaddrXdp{family: AF_XDP, ifindex: uint32(h.ifIndex)}
+	if _, _, errno := syscall.Syscall(
+		syscall.SYS_BIND, uintptr(h.fd), uintptr(unsafe.Pointer(&address)), unsafe.Sizeof(address),
+	); errno != 0 {
+		return errno
+	}
+
+	if err := h.attachProgram(); err != nil {
+		return err
+	}
+
+	if h.epollFd, err = syscall.EpollCreate1(0); err != nil {
+		return err
+	}
+	event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(h.fd)}
+	if err := syscall.EpollCtl(h.epollFd, syscall.EPOLL_CTL_ADD, h.fd, &event); err != nil {
+		return err
+	}
+
+	if promiscuous {
+		if h.packetFd, err = syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, 0); err != nil {
+			return err
+		}
+		if err := afPacketPromiscuous(h.packetFd, h.ifIndex); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+attachProgram loads the XDP program redirecting the frames received on the first queue of the
+interface to the socket, and leaves the frames of the other queues to the network stack.  The
+program is attached in native mode when the driver supports it, in generic mode otherwise,
+through a BPF link which the kernel releases along with the process.  Kernels older than 5.7
+only attach programs through netlink.
+*/
+func (h *afXdpHandle) attachProgram() error {
+	var err error
+
+	mapAttr := bpfMapCreateAttr{mapType: BPF_MAP_TYPE_XSKMAP, keySize: 4, valueSize: 4, maxEntries: 1}
+	if h.mapFd, err = bpf(BPF_MAP_CREATE, unsafe.Pointer(&mapAttr), unsafe.Sizeof(mapAttr)); err != nil {
+		return fmt.Errorf("unable to create XSK map: %s", err.Error())
+	}
+
+	key, value := uint32(0), uint32(h.fd)
+	updateAttr := bpfMapUpdateAttr{
+		mapFd: uint32(h.mapFd),
+		key:   uint64(uintptr(unsafe.Pointer(&key))),
+		value: uint64(uintptr(unsafe.Pointer(&value))),
+	}
+	if _, err = bpf(BPF_MAP_UPDATE_ELEM, unsafe.Pointer(&updateAttr), unsafe.Sizeof(updateAttr)); err != nil {
+		return fmt.Errorf("unable to register socket in XSK map: %s", err.Error())
+	}
+
+	program := []bpfInsn{
+		{code: 0x61, regs: 1<<4 | 2, off: 16},                             // r2 = ctx->rx_queue_index
+		{code: 0x18, regs: BPF_PSEUDO_MAP_FD<<4 | 1, imm: int32(h.mapFd)}, // r1 = XSK map
+		{},
+		{code: 0xb7, regs: 3, imm: XDP_PASS},     // r3 = action when the queue has no socket
+		{code: 0x85, imm: BPF_FUNC_REDIRECT_MAP}, // r0 = bpf_redirect_map(r1, r2, r3)
+		{code: 0x95},                             // return r0
+	}
+	license := []byte("GPL\x00")
+	progAttr := bpfProgLoadAttr{
+		progType: BPF_PROG_TYPE_XDP,
+		insnCnt:  uint32(len(program)),
+		insns:    uint64(uintptr(unsafe.Pointer(&program[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+	}
+	if h.progFd, err = bpf(BPF_PROG_LOAD, unsafe.Pointer(&progAttr), unsafe.Sizeof(progAttr)); err != nil {
+		return fmt.Errorf("unable to load XDP program: %s", err.Error())
+	}
+
+	modes := []uint32{XDP_FLAGS_DRV_MODE, XDP_FLAGS_SKB_MODE}
+	for _, mode := range modes {
+		linkAttr := bpfLinkCreateAttr{
+			progFd:     uint32(h.progFd),
+			ifIndex:    uint32(h.ifIndex),
+			attachType: BPF_XDP,
+			flags:      mode,
+		}
+		if h.linkFd, err = bpf(BPF_LINK_CREATE, unsafe.Pointer(&linkAttr), unsafe.Sizeof(linkAttr)); err == nil {
+			return nil
+		}
+	}
+	if err != syscall.EINVAL {
+		return fmt.Errorf("unable to attach XDP program: %s", err.Error())
+	}
+	for _, mode := range modes {
+		if err = setXdpProgram(h.ifIndex, h.progFd, mode|XDP_FLAGS_UPDATE_IF_NOEXIST); err == nil {
+			h.xdpFlags = mode
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unable to attach XDP program: %s", err.Error())
+}
+
+/*
+setXdpProgram attaches an XDP program to an interface, or detaches it with a descriptor of -1,
+through a netlink request.  The supported architectures are little endian.
+*/
+func setXdpProgram(ifIndex int, progFd int, flags uint32) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	request := make([]byte, syscall.SizeofNlMsghdr+syscall.SizeofIfInfomsg+20)
+	binary.LittleEndian.PutUint32(request[0:], uint32(len(request)))
+	binary.LittleEndian.PutUint16(request[4:], syscall.RTM_SETLINK)
+	binary.LittleEndian.PutUint16(request[6:], syscall.NLM_F_REQUEST|syscall.NLM_F_ACK)
+	binary.LittleEndian.PutUint32(request[8:], 1)
+
+	// Interface, followed by the program and the flags nested in an IFLA_XDP attribute
+	info := request[syscall.SizeofNlMsghdr:]
+	info[0] = syscall.AF_UNSPEC
+	binary.LittleEndian.PutUint32(info[4:], uint32(ifIndex))
+	attributes := info[syscall.SizeofIfInfomsg:]
+	binary.LittleEndian.PutUint16(attributes[0:], 20)
+	binary.LittleEndian.PutUint16(attributes[2:], IFLA_XDP|NLA_F_NESTED)
+	binary.LittleEndian.PutUint16(attributes[4:], 8)
+	binary.LittleEndian.PutUint16(attributes[6:], IFLA_XDP_FD)
+	binary.LittleEndian.PutUint32(attributes[8:], uint32(int32(progFd)))
+	binary.LittleEndian.PutUint16(attributes[12:], 8)
+	binary.LittleEndian.PutUint16(attributes[14:], IFLA_XDP_FLAGS)
+	binary.LittleEndian.PutUint32(attributes[16:], flags)
+
+	if err := syscall.Sendto(fd, request, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	reply := make([]byte, syscall.Getpagesize())
+	n, _, err := syscall.Recvfrom(fd, reply, 0)
+	if err != nil {
+		return err
+	}
+	messages, err := syscall.ParseNetlinkMessage(reply[:n])
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		if message.Header.Type == syscall.NLMSG_ERROR && len(message.Data) >= 4 {
+			if errno := int32(binary.LittleEndian.Uint32(message.Data)); errno != 0 {
+				return syscall.Errno(-errno)
+			}
+		}
+	}
+
+	return nil
+}
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := syscall.Syscall(SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+func setsockopt(fd int, level int, name int, value unsafe.Pointer, size uintptr) error {
+	if _, _, errno := syscall.Syscall6(
+		syscall.SYS_SETSOCKOPT, uintptr(fd), uintptr(level), uintptr(name), uintptr(value), size, 0,
+	); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+/*
+xdpMmapOffsets returns the offsets of the indices and entries of the RX, TX, fill and
+completion rings in their memory.  Kernels older than 5.4 do not report the flags of the rings.
+*/
+func xdpMmapOffsets(fd int) ([4]xdpRingOffset, error) {
+	var offsets [4]xdpRingOffset
+	var raw [16]uint64
+
+	size := uint32(unsafe.Sizeof(raw))
+	if _, _, errno := syscall.Syscall6(
+		syscall.SYS_GETSOCKOPT, uintptr(fd), SOL_XDP, XDP_MMAP_OFFSETS,
+		uintptr(unsafe.Pointer(&raw)), uintptr(unsafe.Pointer(&size)), 0,
+	); errno != 0 {
+		return offsets, errno
+	}
+
+	stride := 4
+	if size < uint32(unsafe.Sizeof(raw)) {
+		stride = 3
+	}
+	for i := range offsets {
+		offsets[i] = xdpRingOffset{
+			producer: raw[i*stride],
+			consumer: raw[i*stride+1],
+			desc:     raw[i*stride+2],
+		}
+	}
+
+	return offsets, nil
+}
+
+func mapXdpRing(fd int, offset xdpRingOffset, pgoff int64, entrySize uintptr) (*xdpRing, error) {
+	memory, err := syscall.Mmap(
+		fd, pgoff, int(offset.desc)+AF_XDP_RING_SIZE*int(entrySize),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &xdpRing{
+		memory:   memory,
+		producer: (*uint32)(unsafe.Pointer(&memory[offset.producer])),
+		consumer: (*uint32)(unsafe.Pointer(&memory[offset.consumer])),
+		entries:  offset.desc,
+		mask:     AF_XDP_RING_SIZE - 1,
+	}, nil
+}
+
+func (r *xdpRing) desc(index uint32) *xdpDesc {
+	return (*xdpDesc)(unsafe.Pointer(&r.memory[r.entries+uint64(index&r.mask)*uint64(unsafe.Sizeof(xdpDesc{}))]))
+}
+
+func (r *xdpRing) addr(index uint32) *uint64 {
+	return (*uint64)(unsafe.Pointer(&r.memory[r.entries+uint64(index&r.mask)*8]))
+}
+
+func (h *afXdpHandle) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	h.rxMutex.Lock()
+	defer h.rxMutex.Unlock()
+
+	events := make([]syscall.EpollEvent, 1)
+	for {
+		if atomic.LoadInt32(&h.closed) != 0 {
+			return nil, gopacket.CaptureInfo{}, io.EOF
+		}
+
+		consumer := *h.rx.consumer
+		if atomic.LoadUint32(h.rx.producer) == consumer {
+			_, err := syscall.EpollWait(h.epollFd, events, int(AF_XDP_READ_TIMEOUT/time.Millisecond))
+			if err != nil && err != syscall.EINTR {
+				return nil, gopacket.CaptureInfo{}, err
+			}
+			continue
+		}
+
+		desc := h.rx.desc(consumer)
+		n := int(desc.len)
+		frame := make([]byte, n)
+		copy(frame, h.umem[desc.addr:desc.addr+uint64(n)])
+		chunk := desc.addr &^ (AF_XDP_FRAME_SIZE - 1)
+		atomic.StoreUint32(h.rx.consumer, consumer+1)
+
+		// The frame is handed back to the kernel to receive the next ones
+		producer := *h.fill.producer
+		*h.fill.addr(producer) = chunk
+		atomic.StoreUint32(h.fill.producer, producer+1)
+
+		ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: n, Length: n}
+		if h.snapshotLen > 0 && n > int(h.snapshotLen) {
+			ci.CaptureLength = int(h.snapshotLen)
+		}
+
+		return frame[:ci.CaptureLength], ci, nil
+	}
+}
+
+func (h *afXdpHandle) WritePacketData(data []byte) error {
+	if len(data) > AF_XDP_FRAME_SIZE {
+		return fmt.Errorf("frame of %d bytes exceeds the %d bytes of an AF_XDP frame", len(data), AF_XDP_FRAME_SIZE)
+	}
+
+	h.txMutex.Lock()
+	defer h.txMutex.Unlock()
+
+	if atomic.LoadInt32(&h.closed) != 0 {
+		return errors.New("AF_XDP socket is closed")
+	}
+
+	h.reclaim()
+	if len(h.txFree) == 0 {
+		h.kick()
+		if h.reclaim(); len(h.txFree) == 0 {
+			return errors.New("no AF_XDP frame left to transmit")
+		}
+	}
+	addr := h.txFree[len(h.txFree)-1]
+	h.txFree = h.txFree[:len(h.txFree)-1]
+	copy(h.umem[addr:], data)
+
+	producer := *h.tx.producer
+	*h.tx.desc(producer) = xdpDesc{addr: addr, len: uint32(len(data))}
+	atomic.StoreUint32(h.tx.producer, producer+1)
+	h.kick()
+
+	return nil
+}
+
+/*
+reclaim takes back the frames which the kernel transmitted
+*/
+func (h *afXdpHandle) reclaim() {
+	consumer := *h.completion.consumer
+	for producer := atomic.LoadUint32(h.completion.producer); consumer != producer; consumer++ {
+		h.txFree = append(h.txFree, *h.completion.addr(consumer))
+	}
+	atomic.StoreUint32(h.completion.consumer, consumer)
+}
+
+/*
+kick makes the kernel transmit the frames queued on the TX ring
+*/
+func (h *afXdpHandle) kick() {
+	syscall.Syscall6(syscall.SYS_SENDTO, uintptr(h.fd), 0, 0, syscall.MSG_DONTWAIT, 0, 0)
+}
+
+func (h *afXdpHandle) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+// The XDP program only sees the frames received by the interface
+func (h *afXdpHandle) SetInboundOnly() error {
+	return nil
+}
+
+func (h *afXdpHandle) Close() {
+	if !atomic.CompareAndSwapInt32(&h.closed, 0, 1) {
+		return
+	}
+
+	// Wait for the reads and writes in progress before releasing the shared memory
+	h.rxMutex.Lock()
+	defer h.rxMutex.Unlock()
+	h.txMutex.Lock()
+	defer h.txMutex.Unlock()
+
+	h.release()
+}
+
+func (h *afXdpHandle) release() {
+	if h.xdpFlags != 0 {
+		setXdpProgram(h.ifIndex, -1, h.xdpFlags)
+	}
+
+	for _, ring := range []*xdpRing{h.rx, h.tx, h.fill, h.completion} {
+		if ring != nil {
+			syscall.Munmap(ring.memory)
+		}
+	}
+	for _, fd := range []int{h.fd, h.epollFd, h.linkFd, h.progFd, h.mapFd, h.packetFd} {
+		if fd >= 0 {
+			syscall.Close(fd)
+		}
+	}
+	if h.umem != nil {
+		syscall.Munmap(h.umem)
+	}
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"io"
+	"sync"
+)
+
+/*
+nullHandle stands for an interface which carries no frames: nothing is ever read from it
+and the frames written to it are discarded
+*/
+type nullHandle struct {
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newNullHandle() PonSimPacketHandle {
+	return &nullHandle{closed: make(chan struct{})}
+}
+
+func (h *nullHandle) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	<-h.closed
+	return nil, gopacket.CaptureInfo{}, io.EOF
+}
+
+func (h *nullHandle) WritePacketData(data []byte) error {
+	return nil
+}
+
+func (h *nullHandle) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+func (h *nullHandle) SetInboundOnly() error {
+	return nil
+}
+
+func (h *nullHandle) Close() {
+	h.once.Do(func() { close(h.closed) })
+}
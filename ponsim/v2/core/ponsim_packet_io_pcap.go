@@ -0,0 +1,44 @@
+// +build cgo
+
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"github.com/google/gopacket/pcap"
+)
+
+/*
+pcapHandle captures and injects frames on a network interface through libpcap
+*/
+type pcapHandle struct {
+	*pcap.Handle
+}
+
+func (h pcapHandle) SetInboundOnly() error {
+	return h.SetDirection(pcap.DirectionIn)
+}
+
+func init() {
+	openPcap = func(ifName string, snapshotLen int32, promiscuous bool) (PonSimPacketHandle, error) {
+		handle, err := pcap.OpenLive(ifName, snapshotLen, promiscuous, pcap.BlockForever)
+		if err != nil {
+			return nil, err
+		}
+		return pcapHandle{handle}, nil
+	}
+}
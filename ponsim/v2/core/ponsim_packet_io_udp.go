@@ -0,0 +1,94 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+/*
+udpTunnel carries the frames of an interface as UDP datagrams exchanged with a peer
+*/
+type udpTunnel struct {
+	conn        *net.UDPConn
+	peer        *net.UDPAddr
+	snapshotLen int32
+	closed      int32
+}
+
+func openUdpTunnel(tunnel ponSimTunnel, snapshotLen int32) (PonSimPacketHandle, error) {
+	local, err := net.ResolveUDPAddr("udp", tunnel.Local)
+	if err != nil {
+		return nil, err
+	}
+
+	peer, err := net.ResolveUDPAddr("udp", tunnel.Peer)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", local)
+	if err != nil {
+		return nil, err
+	}
+
+	return &udpTunnel{conn: conn, peer: peer, snapshotLen: snapshotLen}, nil
+}
+
+func (t *udpTunnel) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	buffer := make([]byte, 65535)
+
+	n, _, err := t.conn.ReadFromUDP(buffer)
+	if err != nil {
+		// Report the closure of the tunnel as the end of the packet source
+		if atomic.LoadInt32(&t.closed) != 0 {
+			err = io.EOF
+		}
+		return nil, gopacket.CaptureInfo{}, err
+	}
+
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: n, Length: n}
+	if t.snapshotLen > 0 && n > int(t.snapshotLen) {
+		ci.CaptureLength = int(t.snapshotLen)
+	}
+
+	return buffer[:ci.CaptureLength], ci, nil
+}
+
+func (t *udpTunnel) WritePacketData(data []byte) error {
+	_, err := t.conn.WriteToUDP(data, t.peer)
+	return err
+}
+
+func (t *udpTunnel) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+// Frames written to a tunnel are sent to the peer and are never read back
+func (t *udpTunnel) SetInboundOnly() error {
+	return nil
+}
+
+func (t *udpTunnel) Close() {
+	if atomic.CompareAndSwapInt32(&t.closed, 0, 1) {
+		t.conn.Close()
+	}
+}
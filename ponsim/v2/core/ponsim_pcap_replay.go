@@ -0,0 +1,261 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/ponsim"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	DEFAULT_PCAP_REPLAY_SPEED = 1.0
+
+	// Frames replayed between two updates of the progress of the replay
+	PCAP_REPLAY_PROGRESS_FRAMES = 100
+)
+
+/*
+pcapReader reads the frames of a pcap or pcapng file
+*/
+type pcapReader interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+}
+
+/*
+PonSimPcapReplay replays the frames of a capture file, keeping the intervals between them
+as captured or shortened by the speed of the replay
+*/
+type PonSimPcapReplay struct {
+	File     string  `json:"file"`
+	Path     string  `json:"path"`
+	Speed    float64 `json:"speed"`
+	TopSpeed bool    `json:"top_speed"`
+	Loop     bool    `json:"loop"`
+	Frames   int     `json:"frames"`
+}
+
+/*
+NewPonSimPcapReplay validates a replay request for a file of the capture directory, counting
+the frames of the file.  The files cannot be looked up outside the capture directory, and
+replays are refused when the simulator has none.
+*/
+func NewPonSimPcapReplay(dir string, request *ponsim.PcapReplayRequest) (*PonSimPcapReplay, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("no capture directory is configured")
+	}
+	if request.File == "" {
+		return nil, fmt.Errorf("missing capture file")
+	}
+	if request.Speed < 0 {
+		return nil, fmt.Errorf("invalid speed: %g", request.Speed)
+	}
+
+	r := &PonSimPcapReplay{
+		File:     request.File,
+		Path:     filepath.Join(dir, filepath.Clean("/"+request.File)),
+		Speed:    request.Speed,
+		TopSpeed: request.TopSpeed,
+		Loop:     request.Loop,
+	}
+	if r.Speed == 0 {
+		r.Speed = DEFAULT_PCAP_REPLAY_SPEED
+	}
+
+	file, reader, err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	for {
+		if _, _, err := reader.ReadPacketData(); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid capture file %s: %s", r.File, err.Error())
+		}
+		r.Frames++
+	}
+	if r.Frames == 0 {
+		return nil, fmt.Errorf("no frames in capture file %s", r.File)
+	}
+
+	return r, nil
+}
+
+/*
+open opens the capture file, as a pcap file or otherwise as a pcapng file
+*/
+func (r *PonSimPcapReplay) open() (*os.File, pcapReader, error) {
+	file, err := os.Open(r.Path)
+	if os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("unknown capture file: %s", r.File)
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("invalid capture file %s: %s", r.File, err.Error())
+	}
+
+	var reader pcapReader
+	if reader, err = pcapgo.NewReader(file); err != nil {
+		if _, err = file.Seek(0, io.SeekStart); err == nil {
+			reader, err = pcapgo.NewNgReader(file, pcapgo.DefaultNgReaderOptions)
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("invalid capture file %s: %s", r.File, err.Error())
+	}
+	if reader.LinkType() != layers.LinkTypeEthernet {
+		file.Close()
+		return nil, nil, fmt.Errorf("unsupported link type: %s", reader.LinkType())
+	}
+
+	return file, reader, nil
+}
+
+/*
+Run sends the frames of the capture file through the send function, once or until the job is
+cancelled when looping.  The frames which cannot be sent, e.g. when the port is down, are
+counted as errors.
+*/
+func (r *PonSimPcapReplay) Run(job *PonSimJob, send func(gopacket.Packet) error) error {
+	report := &ponsim.TrafficReport{}
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	start := time.Now()
+	setProgress := func(loops int) {
+		result := *report
+		if elapsed := time.Since(start).Seconds(); elapsed > 0 {
+			result.Rate = float64(report.Frames) / elapsed
+		}
+		job.SetResult(&result)
+
+		if r.Loop {
+			job.SetProgress(0, fmt.Sprintf("%d frames sent in %d loops", report.Frames, loops))
+		} else {
+			job.SetProgress(float32(report.Frames*100)/float32(r.Frames), fmt.Sprintf("%d frames sent", report.Frames))
+		}
+	}
+
+	for loops := 0; loops == 0 || r.Loop; loops++ {
+		progress := func() { setProgress(loops) }
+		if err := r.replay(job, timer, report, send, progress); err != nil {
+			setProgress(loops)
+			return err
+		}
+		if job.Context().Err() != nil {
+			setProgress(loops)
+			return nil
+		}
+		setProgress(loops + 1)
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"job":    job.Id,
+		"frames": report.Frames,
+		"errors": report.Errors,
+	}).Info("Capture replay completed")
+
+	return nil
+}
+
+/*
+replay sends the frames of the capture file once, at the time they were captured relative to
+the first frame divided by the speed of the replay, and updates the progress of the job
+regularly
+*/
+func (r *PonSimPcapReplay) replay(
+	job *PonSimJob,
+	timer *time.Timer,
+	report *ponsim.TrafficReport,
+	send func(gopacket.Packet) error,
+	progress func(),
+) error {
+	file, reader, err := r.open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var first time.Time
+	start := time.Now()
+	for {
+		data, info, err := reader.ReadPacketData()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if first.IsZero() {
+			first = info.Timestamp
+		}
+		if !r.TopSpeed {
+			offset := time.Duration(float64(info.Timestamp.Sub(first)) / r.Speed)
+			if wait := time.Until(start.Add(offset)); wait > 0 {
+				timer.Reset(wait)
+				select {
+				case <-job.Context().Done():
+					return nil
+				case <-timer.C:
+				}
+			}
+		}
+		if job.Context().Err() != nil {
+			return nil
+		}
+
+		if err := send(common.NewFrame(data)); err != nil {
+			report.Errors++
+		} else {
+			report.Bytes += uint64(len(data))
+		}
+		report.Frames++
+
+		if report.Frames%PCAP_REPLAY_PROGRESS_FRAMES == 0 {
+			progress()
+		}
+	}
+}
+
+/*
+StartPcapReplay replays the frames of a capture file on the NNI of the OLT, or on the UNI of
+an ONU, as a job
+*/
+func (o *PonSimDevice) StartPcapReplay(replay *PonSimPcapReplay) *PonSimJob {
+	common.Logger().WithFields(logrus.Fields{
+		"device": o,
+		"path":   replay.Path,
+		"speed":  replay.Speed,
+		"loop":   replay.Loop,
+		"frames": replay.Frames,
+	}).Info("Starting capture replay")
+
+	return o.Jobs.Start("pcap_replay", func(job *PonSimJob) error {
+		return replay.Run(job, func(frame gopacket.Packet) error {
+			return o.Forward(job.Context(), 2, frame)
+		})
+	})
+}
@@ -0,0 +1,305 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Standard length of a performance monitoring interval
+	PM_INTERVAL = 15 * time.Minute
+
+	// Interval at which the end of the current PM interval is checked against the device clock
+	PM_CHECK_INTERVAL = time.Second
+
+	// Like the OMCI interval end time, intervals are numbered modulo 256
+	PM_INTERVAL_NUMBERS = 256
+)
+
+/*
+pmGauges are the counters which report a level rather than a count of events; an interval
+records their value at its end instead of their increase
+*/
+var pmGauges = map[string]bool{
+	"onus":                   true,
+	"queue_depth":            true,
+	"queue_capacity":         true,
+	"priority":               true,
+	"weight":                 true,
+	"pending_bytes":          true,
+	"utilization":            true,
+	"flow_updates_peak":      true,
+	"fec_enabled":            true,
+	"pre_fec_ber":            true,
+	"us_residual_error_rate": true,
+	"ds_residual_error_rate": true,
+	"tx_power":               true,
+	"onu_tx_power":           true,
+	"onu_rx_power":           true,
+	"olt_rx_power":           true,
+	"los":                    true,
+	"mib_data_sync":          true,
+}
+
+/*
+PonSimPmInterval holds the counters of the ports of a device accumulated during an interval,
+indexed by port name and counter name
+*/
+type PonSimPmInterval struct {
+	EndTime  uint32                      `json:"end_time"` // Interval number, modulo 256
+	Start    time.Time                   `json:"start"`
+	End      time.Time                   `json:"end"`
+	Suspect  bool                        `json:"suspect"` // The interval was not fully monitored
+	Counters map[string]map[string]int64 `json:"counters"`
+}
+
+/*
+GetPorts returns the sorted names of the ports of the interval
+*/
+func (i *PonSimPmInterval) GetPorts() []string {
+	ports := make([]string, 0, len(i.Counters))
+	for port := range i.Counters {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+
+	return ports
+}
+
+/*
+filter returns a copy of the interval restricted to a port, or to all ports if not set
+*/
+func (i *PonSimPmInterval) filter(port string) (*PonSimPmInterval, error) {
+	filtered := *i
+	if port == "" {
+		return &filtered, nil
+	}
+
+	counters, ok := i.Counters[port]
+	if !ok {
+		return nil, fmt.Errorf("unknown port: %s", port)
+	}
+	filtered.Counters = map[string]map[string]int64{port: counters}
+
+	return &filtered, nil
+}
+
+/*
+PonSimPm accumulates the counters of a device into intervals aligned on the device clock,
+15 minutes long by default, and retains a number of completed intervals
+*/
+type PonSimPm struct {
+	Interval time.Duration `json:"interval"`
+	History  int           `json:"history"`
+
+	mutex     sync.Mutex
+	clock     *PonSimClock
+	collect   func() *voltha.PonSimMetrics
+	current   *PonSimPmInterval
+	baseline  map[string]map[string]int64
+	completed []*PonSimPmInterval
+
+	// Thresholds of the counters, indexed by port and counter, and the TCAs raised
+	thresholds map[string]PonSimThreshold
+	crossed    map[string]bool
+	onCrossing func(PonSimThreshold, int64, bool)
+}
+
+/*
+NewPonSimPm instantiates the collection of intervals of the specified length, retaining
+history completed intervals.  PM collection is disabled without history.
+*/
+func NewPonSimPm(interval time.Duration, history int) (*PonSimPm, error) {
+	if history <= 0 {
+		return nil, nil
+	}
+	if interval < PM_CHECK_INTERVAL {
+		return nil, fmt.Errorf("PM interval must be at least %s", PM_CHECK_INTERVAL)
+	}
+
+	return &PonSimPm{Interval: interval, History: history}, nil
+}
+
+/*
+snapshot indexes the current values of the counters by port name and counter name
+*/
+func (p *PonSimPm) snapshot() map[string]map[string]int64 {
+	values := make(map[string]map[string]int64)
+	for _, port := range p.collect().Metrics {
+		counters := make(map[string]int64)
+		for _, counter := range port.Packets {
+			counters[counter.Name] = counter.Value
+		}
+		values[port.PortName] = counters
+	}
+
+	return values
+}
+
+/*
+accumulate returns the increase of the counters since the baseline, gauges keeping their
+current value.  Ports and counters which appeared since the baseline started from zero.
+*/
+func (p *PonSimPm) accumulate(values map[string]map[string]int64) map[string]map[string]int64 {
+	deltas := make(map[string]map[string]int64)
+	for port, counters := range values {
+		portDeltas := make(map[string]int64)
+		for name, value := range counters {
+			if !pmGauges[name] {
+				value -= p.baseline[port][name]
+			}
+			portDeltas[name] = value
+		}
+		deltas[port] = portDeltas
+	}
+
+	return deltas
+}
+
+/*
+begin opens an interval and takes the values of the counters at its beginning as baseline
+*/
+func (p *PonSimPm) begin(start time.Time, number uint32, suspect bool) {
+	p.current = &PonSimPmInterval{
+		EndTime: number % PM_INTERVAL_NUMBERS,
+		Start:   start,
+		End:     start.Add(p.Interval),
+		Suspect: suspect,
+	}
+	p.baseline = p.snapshot()
+}
+
+/*
+Start opens the first interval and completes the intervals as the device clock reaches their
+end, until the context is cancelled
+*/
+func (p *PonSimPm) Start(ctx context.Context, clock *PonSimClock, collect func() *voltha.PonSimMetrics) {
+	p.mutex.Lock()
+	p.clock = clock
+	p.collect = collect
+	// The first interval is only monitored from now on
+	now := clock.Now()
+	p.begin(now.Truncate(p.Interval), 0, !now.Equal(now.Truncate(p.Interval)))
+	p.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(PM_CHECK_INTERVAL)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.check()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+/*
+check completes the current interval once the device clock reaches its end and evaluates the
+thresholds against the counters of the current interval
+*/
+func (p *PonSimPm) check() {
+	p.mutex.Lock()
+	p.complete()
+
+	var crossings []pmCrossing
+	if len(p.thresholds) > 0 {
+		crossings = p.evaluate(p.accumulate(p.snapshot()))
+	}
+	p.mutex.Unlock()
+
+	p.notifyCrossings(crossings)
+}
+
+/*
+complete closes the current interval if the device clock reached its end
+*/
+func (p *PonSimPm) complete() {
+	now := p.clock.Now()
+	if now.Before(p.current.End) {
+		return
+	}
+
+	completed := p.current
+	completed.Counters = p.accumulate(p.snapshot())
+
+	p.completed = append([]*PonSimPmInterval{completed}, p.completed...)
+	if len(p.completed) > p.History {
+		p.completed = p.completed[:p.History]
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"endTime": completed.EndTime,
+		"start":   completed.Start,
+		"suspect": completed.Suspect,
+	}).Debug("Completed PM interval")
+
+	// The intervals missed while the clock jumped forward are skipped, leaving the next
+	// interval suspect
+	if now.Sub(completed.End) >= p.Interval {
+		p.begin(now.Truncate(p.Interval), completed.EndTime+1, true)
+	} else {
+		p.begin(completed.End, completed.EndTime+1, false)
+	}
+}
+
+/*
+GetCurrent returns the counters accumulated so far during the current interval, for a port
+or for all ports if not set
+*/
+func (p *PonSimPm) GetCurrent(port string) (*PonSimPmInterval, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.current == nil {
+		return nil, fmt.Errorf("PM collection is not started")
+	}
+
+	current := *p.current
+	current.Counters = p.accumulate(p.snapshot())
+
+	return current.filter(port)
+}
+
+/*
+GetHistory returns the completed intervals, the most recent first, for a port or for all
+ports if not set
+*/
+func (p *PonSimPm) GetHistory(port string) []*PonSimPmInterval {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	intervals := make([]*PonSimPmInterval, 0, len(p.completed))
+	for _, completed := range p.completed {
+		// The port may not have existed yet during the older intervals
+		if interval, err := completed.filter(port); err == nil {
+			intervals = append(intervals, interval)
+		}
+	}
+
+	return intervals
+}
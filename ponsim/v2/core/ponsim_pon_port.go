@@ -0,0 +1,205 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"sort"
+	"sync/atomic"
+)
+
+const (
+	DEFAULT_PON_PORT_COUNT = 1
+	MAX_PON_PORT_COUNT     = 16
+
+	// Range of ONU port numbers reserved for each PON port, starting at BASE_PORT_NUMBER
+	ONU_PORTS_PER_PON = 256
+)
+
+/*
+PonSimPonPort models one of the PON ports of an OLT along with the ONUs registered on it
+*/
+type PonSimPonPort struct {
+	Index int                     `json:"index"`
+	Port  int                     `json:"port"`
+	Onus  map[int32]*OnuRegistree `json:"-"`
+	Dba   *PonSimDba              `json:"dba"`
+
+	// Launch power of the laser of the PON port, in dBm
+	TxPower float64 `json:"tx_power"`
+
+	RxFrames int64 `json:"rx_frames"`
+	RxBytes  int64 `json:"rx_bytes"`
+	TxFrames int64 `json:"tx_frames"`
+	TxBytes  int64 `json:"tx_bytes"`
+}
+
+/*
+PonPortNumber returns the number of the PON port with the specified index.  The first PON
+keeps port 1 and the others follow the NNI port (2), i.e. 3, 4, ...
+*/
+func PonPortNumber(index int) int {
+	if index == 0 {
+		return 1
+	}
+	return index + 2
+}
+
+/*
+NewPonSimPonPorts instantiates the PON ports of an OLT, each accepting up to the specified
+number of ONUs
+*/
+func NewPonSimPonPorts(count int, maxOnuCount int) ([]*PonSimPonPort, error) {
+	if count < 1 || count > MAX_PON_PORT_COUNT {
+		return nil, fmt.Errorf("invalid number of PON ports %d, expected 1 to %d", count, MAX_PON_PORT_COUNT)
+	}
+	if count > 1 && maxOnuCount > ONU_PORTS_PER_PON {
+		return nil, fmt.Errorf("at most %d ONUs per PON port are supported with several PON ports", ONU_PORTS_PER_PON)
+	}
+
+	ports := make([]*PonSimPonPort, count)
+	for i := range ports {
+		ports[i] = &PonSimPonPort{
+			Index:   i,
+			Port:    PonPortNumber(i),
+			Onus:    make(map[int32]*OnuRegistree),
+			TxPower: DEFAULT_OLT_TX_POWER,
+		}
+	}
+
+	return ports, nil
+}
+
+/*
+BaseOnuPort returns the first port number assigned to the ONUs registered on the PON port
+*/
+func (p *PonSimPonPort) BaseOnuPort() int32 {
+	return int32(BASE_PORT_NUMBER + p.Index*ONU_PORTS_PER_PON)
+}
+
+/*
+GetOnuPorts returns the sorted ports of the ONUs registered on the PON port.
+It is called with the ONU lock of the OLT held.
+*/
+func (p *PonSimPonPort) GetOnuPorts() []int32 {
+	ports := make([]int32, 0, len(p.Onus))
+	for port := range p.Onus {
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+
+	return ports
+}
+
+/*
+CountRxFrame increments the counters of frames received from the ONUs of the PON port
+*/
+func (p *PonSimPonPort) CountRxFrame(size int) {
+	atomic.AddInt64(&p.RxFrames, 1)
+	atomic.AddInt64(&p.RxBytes, int64(size))
+}
+
+/*
+CountTxFrame increments the counters of frames sent to the ONUs of the PON port
+*/
+func (p *PonSimPonPort) CountTxFrame(size int) {
+	atomic.AddInt64(&p.TxFrames, 1)
+	atomic.AddInt64(&p.TxBytes, int64(size))
+}
+
+/*
+MakeProto returns the counters of the PON port, named after its port number, e.g. pon3.
+It is called with the ONU lock of the OLT held.
+*/
+func (p *PonSimPonPort) MakeProto() *voltha.PonSimPortMetrics {
+	return &voltha.PonSimPortMetrics{
+		PortName: fmt.Sprintf("pon%d", p.Port),
+		Packets: []*voltha.PonSimPacketCounter{
+			{Name: "rx_frames", Value: atomic.LoadInt64(&p.RxFrames)},
+			{Name: "rx_bytes", Value: atomic.LoadInt64(&p.RxBytes)},
+			{Name: "tx_frames", Value: atomic.LoadInt64(&p.TxFrames)},
+			{Name: "tx_bytes", Value: atomic.LoadInt64(&p.TxBytes)},
+			{Name: "onus", Value: int64(len(p.Onus))},
+			{Name: "tx_power", Value: toCentiDbm(p.TxPower)},
+		},
+	}
+}
+
+/*
+GetPonPorts returns the PON ports of the OLT, defaulting to a single PON port
+*/
+func (o *PonSimOltDevice) GetPonPorts() []*PonSimPonPort {
+	if len(o.PonPorts) == 0 {
+		o.PonPorts, _ = NewPonSimPonPorts(DEFAULT_PON_PORT_COUNT, o.MaxOnuCount)
+	}
+
+	return o.PonPorts
+}
+
+/*
+GetPonPort returns the PON port with the specified port number, or nil
+*/
+func (o *PonSimOltDevice) GetPonPort(port int) *PonSimPonPort {
+	for _, pon := range o.GetPonPorts() {
+		if pon.Port == port {
+			return pon
+		}
+	}
+
+	return nil
+}
+
+/*
+GetOnuPonPort returns the PON port on which an ONU port number is assigned, or nil
+*/
+func (o *PonSimOltDevice) GetOnuPonPort(onuPort int32) *PonSimPonPort {
+	if onuPort < BASE_PORT_NUMBER {
+		return nil
+	}
+
+	pons := o.GetPonPorts()
+	if len(pons) == 1 {
+		return pons[0]
+	}
+	if index := int(onuPort-BASE_PORT_NUMBER) / ONU_PORTS_PER_PON; index < len(pons) {
+		return pons[index]
+	}
+
+	return nil
+}
+
+/*
+selectPonPort returns the PON port requested by a registering ONU or, when none is requested,
+the PON port with the fewest ONUs.  It is called with the ONU lock held.
+*/
+func (o *PonSimOltDevice) selectPonPort(requested int32) (*PonSimPonPort, error) {
+	if requested != 0 {
+		if pon := o.GetPonPort(int(requested)); pon != nil {
+			return pon, nil
+		}
+		return nil, fmt.Errorf("unknown PON port %d", requested)
+	}
+
+	var selected *PonSimPonPort
+	for _, pon := range o.GetPonPorts() {
+		if selected == nil || len(pon.Onus) < len(selected.Onus) {
+			selected = pon
+		}
+	}
+
+	return selected, nil
+}
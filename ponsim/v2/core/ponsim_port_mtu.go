@@ -0,0 +1,138 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// Smallest MTU accepted on a port, as required by IPv4
+	MIN_MTU = 68
+
+	// Largest MTU accepted on a port, i.e. jumbo frames
+	MAX_MTU = 9000
+
+	dot1qTagSize = 4
+)
+
+/*
+PonSimPortMtus holds the MTU configured per port.  Ports without an MTU accept frames of any size.
+*/
+type PonSimPortMtus struct {
+	mutex sync.RWMutex
+	mtus  map[int]int
+}
+
+/*
+NewPonSimPortMtus instantiates a configuration without any MTU
+*/
+func NewPonSimPortMtus() *PonSimPortMtus {
+	return &PonSimPortMtus{mtus: make(map[int]int)}
+}
+
+/*
+Set configures the MTU of a port; an MTU of 0 removes the limit
+*/
+func (p *PonSimPortMtus) Set(port int, mtu int) error {
+	if mtu != 0 && (mtu < MIN_MTU || mtu > MAX_MTU) {
+		return fmt.Errorf("MTU %d of port %d is not between %d and %d", mtu, port, MIN_MTU, MAX_MTU)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if mtu == 0 {
+		delete(p.mtus, port)
+	} else {
+		p.mtus[port] = mtu
+	}
+
+	return nil
+}
+
+/*
+Get returns the MTU of a port, 0 if frames of any size are accepted
+*/
+func (p *PonSimPortMtus) Get(port int) int {
+	if p == nil {
+		return 0
+	}
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.mtus[port]
+}
+
+/*
+Fits reports whether a frame does not exceed the MTU of a port.  The MTU applies to the
+payload of the frame, excluding its ethernet header and VLAN tags.
+*/
+func (p *PonSimPortMtus) Fits(port int, frame gopacket.Packet) bool {
+	mtu := p.Get(port)
+	if mtu == 0 {
+		return true
+	}
+
+	size := len(frame.Data()) - ethernetHeaderSize
+	for _, layer := range frame.Layers() {
+		if layer.LayerType() == layers.LayerTypeDot1Q {
+			size -= dot1qTagSize
+		}
+	}
+
+	return size <= mtu
+}
+
+/*
+ParsePortMtus parses a comma separated list of port MTUs in the format port:mtu, e.g. 2:9000
+*/
+func ParsePortMtus(spec string) (*PonSimPortMtus, error) {
+	mtus := NewPonSimPortMtus()
+
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		fields := strings.Split(entry, ":")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid MTU specification: %s", entry)
+		}
+
+		port, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid MTU port: %s", fields[0])
+		}
+
+		mtu, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid MTU: %s", fields[1])
+		}
+
+		if err = mtus.Set(port, mtu); err != nil {
+			return nil, err
+		}
+	}
+
+	return mtus, nil
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+PonSimPortStates tracks the link and administrative state of the ports of a device.  A port is
+operationally up when its link is up and it is administratively enabled.
+*/
+type PonSimPortStates struct {
+	mutex    sync.RWMutex
+	down     map[int]bool
+	disabled map[int]bool
+}
+
+/*
+NewPonSimPortStates instantiates a port state tracker with all ports enabled and up
+*/
+func NewPonSimPortStates() *PonSimPortStates {
+	return &PonSimPortStates{down: make(map[int]bool), disabled: make(map[int]bool)}
+}
+
+/*
+IsUp returns whether a port is operationally up
+*/
+func (p *PonSimPortStates) IsUp(port int) bool {
+	if p == nil {
+		return true
+	}
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return !p.down[port] && !p.disabled[port]
+}
+
+/*
+IsEnabled returns whether a port is administratively enabled
+*/
+func (p *PonSimPortStates) IsEnabled(port int) bool {
+	if p == nil {
+		return true
+	}
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return !p.disabled[port]
+}
+
+/*
+SetUp changes the link state of a port and reports whether its operational state changed
+*/
+func (p *PonSimPortStates) SetUp(port int, up bool) bool {
+	return p.set(p.down, port, !up)
+}
+
+/*
+SetEnabled changes the administrative state of a port and reports whether its operational
+state changed
+*/
+func (p *PonSimPortStates) SetEnabled(port int, enabled bool) bool {
+	return p.set(p.disabled, port, !enabled)
+}
+
+/*
+Snapshot returns the ports whose link is down and the ports which are administratively disabled
+*/
+func (p *PonSimPortStates) Snapshot() (down []int, disabled []int) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	for port := range p.down {
+		down = append(down, port)
+	}
+	for port := range p.disabled {
+		disabled = append(disabled, port)
+	}
+	sort.Ints(down)
+	sort.Ints(disabled)
+
+	return down, disabled
+}
+
+/*
+Restore replaces the port states with a snapshot, without raising port status events
+*/
+func (p *PonSimPortStates) Restore(down []int, disabled []int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.down = make(map[int]bool)
+	for _, port := range down {
+		p.down[port] = true
+	}
+	p.disabled = make(map[int]bool)
+	for _, port := range disabled {
+		p.disabled[port] = true
+	}
+}
+
+func (p *PonSimPortStates) set(states map[int]bool, port int, value bool) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	wasUp := !p.down[port] && !p.disabled[port]
+
+	if value {
+		states[port] = true
+	} else {
+		delete(states, port)
+	}
+
+	return wasUp != (!p.down[port] && !p.disabled[port])
+}
+
+/*
+FlapPort takes a port (1: PON, 2: NNI or UNI) down for the specified duration and brings it
+back up, raising a port status event on each transition
+*/
+func (o *PonSimDevice) FlapPort(port int, duration time.Duration) error {
+	if port != 1 && port != 2 {
+		return fmt.Errorf("invalid port %d", port)
+	}
+	if o.PortStates == nil {
+		return fmt.Errorf("device does not support port state changes")
+	}
+
+	if !o.PortStates.IsUp(port) {
+		return fmt.Errorf("port %d is already down", port)
+	}
+	o.setPortState(port, false)
+
+	time.AfterFunc(duration, func() { o.setPortState(port, true) })
+
+	return nil
+}
+
+/*
+EnablePort administratively enables the NNI or UNI port (2) and resumes forwarding on it
+*/
+func (o *PonSimDevice) EnablePort(port int) error {
+	return o.setPortAdminState(port, true)
+}
+
+/*
+DisablePort administratively shuts the NNI or UNI port (2); frames are no longer forwarded
+through it until it is enabled again
+*/
+func (o *PonSimDevice) DisablePort(port int) error {
+	return o.setPortAdminState(port, false)
+}
+
+func (o *PonSimDevice) setPortAdminState(port int, enabled bool) error {
+	if port != 2 {
+		return fmt.Errorf("invalid port %d, only the NNI or UNI port can be administered", port)
+	}
+	if o.PortStates == nil {
+		return fmt.Errorf("device does not support port state changes")
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"device":  o,
+		"port":    port,
+		"enabled": enabled,
+	}).Info("Changing port administrative state")
+
+	if o.PortStates.SetEnabled(port, enabled) {
+		o.portStateChanged(port)
+	}
+
+	return nil
+}
+
+func (o *PonSimDevice) setPortState(port int, up bool) bool {
+	if !o.PortStates.SetUp(port, up) {
+		return false
+	}
+
+	o.portStateChanged(port)
+
+	return true
+}
+
+/*
+portStateChanged raises an event reporting the new operational state of a port
+*/
+func (o *PonSimDevice) portStateChanged(port int) {
+	up := o.PortStates.IsUp(port)
+
+	common.Logger().WithFields(logrus.Fields{
+		"device": o,
+		"port":   port,
+		"up":     up,
+	}).Info("Port status changed")
+
+	o.publishEvent(newPortStatusEvent(o.Name, port, up))
+}
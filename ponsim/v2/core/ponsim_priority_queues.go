@@ -0,0 +1,219 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// The queue of the highest priority holding frames is always served first
+	SCHED_STRICT = "strict"
+
+	// The queues are served in turn, from the highest priority, up to their weight in frames
+	SCHED_WRR = "wrr"
+
+	PRIORITY_QUEUE_COUNT = 8
+
+	DEFAULT_PRIORITY_QUEUE_DEPTH = 64
+)
+
+/*
+PonSimPriorityQueues classifies frames into 8 queues according to the 802.1p priority (PCP)
+of their outer VLAN tag, untagged frames having the lowest priority, and schedules them
+towards an outgoing queue.
+*/
+type PonSimPriorityQueues struct {
+	Scheduler string                                  `json:"scheduler"`
+	Weights   [PRIORITY_QUEUE_COUNT]int               `json:"weights"`
+	Queues    [PRIORITY_QUEUE_COUNT]*PonSimFrameQueue `json:"queues"`
+
+	mutex   sync.Mutex
+	current int
+	credit  int
+	ready   chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+/*
+NewPonSimPriorityQueues instantiates the priority queues, each holding up to depth frames with
+a drop policy.  The WRR weights are indexed by priority and default to the priority plus one.
+*/
+func NewPonSimPriorityQueues(scheduler string, weights []int, depth int, policy string) (*PonSimPriorityQueues, error) {
+	switch scheduler {
+	case SCHED_STRICT, SCHED_WRR:
+	default:
+		return nil, fmt.Errorf("unknown scheduler: %s", scheduler)
+	}
+	if len(weights) != 0 && len(weights) != PRIORITY_QUEUE_COUNT {
+		return nil, fmt.Errorf("expected %d weights, got %d", PRIORITY_QUEUE_COUNT, len(weights))
+	}
+
+	p := &PonSimPriorityQueues{
+		Scheduler: scheduler,
+		current:   PRIORITY_QUEUE_COUNT - 1,
+		ready:     make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	for i := range p.Queues {
+		queue, err := NewPonSimFrameQueue(depth, policy)
+		if err != nil {
+			return nil, err
+		}
+		p.Queues[i] = queue
+
+		p.Weights[i] = i + 1
+		if len(weights) != 0 {
+			if weights[i] <= 0 {
+				return nil, fmt.Errorf("invalid weight of priority %d: %d", i, weights[i])
+			}
+			p.Weights[i] = weights[i]
+		}
+	}
+	p.credit = p.Weights[p.current]
+
+	return p, nil
+}
+
+/*
+ParsePriorityWeights parses the comma separated WRR weights of the priorities 0 to 7
+*/
+func ParsePriorityWeights(spec string) ([]int, error) {
+	var weights []int
+
+	if spec = strings.TrimSpace(spec); spec == "" {
+		return nil, nil
+	}
+	for _, field := range strings.Split(spec, ",") {
+		weight, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight: %s", field)
+		}
+		weights = append(weights, weight)
+	}
+
+	return weights, nil
+}
+
+/*
+classify returns the priority of a frame
+*/
+func classify(frame gopacket.Packet) int {
+	if dot1q := common.GetDot1QLayer(frame); dot1q != nil {
+		return int(dot1q.Priority)
+	}
+	return 0
+}
+
+/*
+Push queues a frame in the queue of its priority according to the drop policy.  It reports
+whether the frame was queued and returns the frame dropped to make room (head_drop).
+*/
+func (p *PonSimPriorityQueues) Push(frame gopacket.Packet) (bool, gopacket.Packet) {
+	queued, evicted := p.Queues[classify(frame)].Push(frame)
+	if queued {
+		notifyChannel(p.ready)
+	}
+
+	return queued, evicted
+}
+
+/*
+Pop dequeues the next frame selected by the scheduler, or returns nil when all the queues
+are empty
+*/
+func (p *PonSimPriorityQueues) Pop() gopacket.Packet {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.Scheduler == SCHED_STRICT {
+		for i := PRIORITY_QUEUE_COUNT - 1; i >= 0; i-- {
+			if frame := p.Queues[i].Pop(); frame != nil {
+				return frame
+			}
+		}
+		return nil
+	}
+
+	// Visit every queue once, the current one being served while it has credit left
+	for i := 0; i <= PRIORITY_QUEUE_COUNT; i++ {
+		if p.credit > 0 {
+			if frame := p.Queues[p.current].Pop(); frame != nil {
+				p.credit--
+				return frame
+			}
+		}
+		p.current = (p.current + PRIORITY_QUEUE_COUNT - 1) % PRIORITY_QUEUE_COUNT
+		p.credit = p.Weights[p.current]
+	}
+
+	return nil
+}
+
+/*
+Start moves the scheduled frames to the outgoing queue, waiting for room in it
+*/
+func (p *PonSimPriorityQueues) Start(outgoing *PonSimFrameQueue) {
+	go func() {
+		for {
+			if frame := p.Pop(); frame != nil {
+				if queued, _ := outgoing.push(frame, QUEUE_BLOCK); !queued {
+					return
+				}
+				continue
+			}
+
+			select {
+			case <-p.ready:
+			case <-p.done:
+				return
+			}
+		}
+	}()
+}
+
+/*
+Close stops the scheduling of the frames and releases the producers waiting for room
+*/
+func (p *PonSimPriorityQueues) Close() {
+	p.once.Do(func() {
+		close(p.done)
+		for _, queue := range p.Queues {
+			queue.Close()
+		}
+	})
+}
+
+/*
+MakeProto reports the occupancy of the queues as GRPC metrics, named after their priority,
+e.g. priority_queue7
+*/
+func (p *PonSimPriorityQueues) MakeProto() []*voltha.PonSimPortMetrics {
+	var metrics []*voltha.PonSimPortMetrics
+
+	for i, queue := range p.Queues {
+		metrics = append(metrics, queue.MakeProto(fmt.Sprintf("priority_queue%d", i)))
+	}
+
+	return metrics
+}
@@ -0,0 +1,241 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+Names of the metrics exposed in the Prometheus text format.  Ports are labelled pon and nni
+(the UNI of an ONU), frames sent towards VOLTHA are labelled by class (control or data).
+*/
+const (
+	METRIC_RX_PACKETS           = "ponsim_rx_packets_total"
+	METRIC_TX_PACKETS           = "ponsim_tx_packets_total"
+	METRIC_RX_DROPPED_PACKETS   = "ponsim_rx_dropped_packets_total"
+	METRIC_RX_CORRUPTED_PACKETS = "ponsim_rx_corrupted_packets_total"
+	METRIC_RX_HASH_ERRORS       = "ponsim_rx_hash_errors_total"
+	METRIC_OVERSIZE_PACKETS     = "ponsim_oversize_packets_total"
+	METRIC_RX_DUPLICATE_PACKETS = "ponsim_rx_duplicate_packets_total"
+	METRIC_RX_GEM_FILTERED      = "ponsim_rx_gem_filtered_packets_total"
+	METRIC_CPU_PACKETS          = "ponsim_cpu_packets_total"
+	METRIC_CPU_DROPPED_PACKETS  = "ponsim_cpu_dropped_packets_total"
+	METRIC_ALARMS               = "ponsim_alarms_total"
+	METRIC_FLOW_UPDATES         = "ponsim_flow_updates_total"
+	METRIC_FLOW_UPDATE_TIME     = "ponsim_flow_update_microseconds_total"
+)
+
+var prometheusPorts = []string{"pon", "nni"}
+var prometheusClasses = []string{"control", "data"}
+var prometheusAlarmStates = []string{"raised", "cleared"}
+var prometheusFlowResults = []string{"applied", "failed"}
+
+type prometheusSample struct {
+	Labels []string // Alternating label names and values
+	Value  int
+}
+
+type prometheusMetric struct {
+	Name    string
+	Help    string
+	Collect func(mc *PonSimMetricCounter) []prometheusSample
+}
+
+/*
+perPort collects the samples of a [PON,NNI] counter
+*/
+func perPort(counter func(mc *PonSimMetricCounter) [2]int) func(mc *PonSimMetricCounter) []prometheusSample {
+	return func(mc *PonSimMetricCounter) []prometheusSample {
+		values := counter(mc)
+		return []prometheusSample{
+			{Labels: []string{"port", prometheusPorts[0]}, Value: values[0]},
+			{Labels: []string{"port", prometheusPorts[1]}, Value: values[1]},
+		}
+	}
+}
+
+/*
+perSize collects the samples of the size buckets of received or sent frames, e.g. rx_65_127_pkts
+is exposed with the size 65_127
+*/
+func perSize(counters []*metricCounter, prefix string) []prometheusSample {
+	sort.Slice(counters, func(i, j int) bool { return counters[i].Min < counters[j].Min })
+
+	var samples []prometheusSample
+	for _, counter := range counters {
+		size := strings.TrimSuffix(strings.TrimPrefix(counter.Name, prefix), "_pkts")
+		for i, port := range prometheusPorts {
+			samples = append(samples, prometheusSample{
+				Labels: []string{"port", port, "size", size},
+				Value:  counter.Value[i],
+			})
+		}
+	}
+	return samples
+}
+
+var prometheusMetrics = []prometheusMetric{
+	{
+		Name: METRIC_RX_PACKETS,
+		Help: "Frames received on a port, by size",
+		Collect: func(mc *PonSimMetricCounter) []prometheusSample {
+			var counters []*metricCounter
+			for _, counter := range mc.RxCounters {
+				counters = append(counters, counter)
+			}
+			return perSize(counters, "rx_")
+		},
+	},
+	{
+		Name: METRIC_TX_PACKETS,
+		Help: "Frames sent on a port, by size",
+		Collect: func(mc *PonSimMetricCounter) []prometheusSample {
+			var counters []*metricCounter
+			for _, counter := range mc.TxCounters {
+				counters = append(counters, counter)
+			}
+			return perSize(counters, "tx_")
+		},
+	},
+	{
+		Name:    METRIC_RX_DROPPED_PACKETS,
+		Help:    "Frames dropped on receipt by fault injection or metering",
+		Collect: perPort(func(mc *PonSimMetricCounter) [2]int { return mc.Dropped }),
+	},
+	{
+		Name:    METRIC_RX_CORRUPTED_PACKETS,
+		Help:    "Frames corrupted on receipt by fault injection",
+		Collect: perPort(func(mc *PonSimMetricCounter) [2]int { return mc.Corrupted }),
+	},
+	{
+		Name:    METRIC_RX_HASH_ERRORS,
+		Help:    "Frames received with content not matching their hash",
+		Collect: perPort(func(mc *PonSimMetricCounter) [2]int { return mc.HashErrors }),
+	},
+	{
+		Name:    METRIC_OVERSIZE_PACKETS,
+		Help:    "Frames received or sent exceeding the MTU of a port",
+		Collect: perPort(func(mc *PonSimMetricCounter) [2]int { return mc.Oversize }),
+	},
+	{
+		Name:    METRIC_RX_DUPLICATE_PACKETS,
+		Help:    "Frames dropped as duplicates of a recently received frame",
+		Collect: perPort(func(mc *PonSimMetricCounter) [2]int { return mc.Duplicates }),
+	},
+	{
+		Name:    METRIC_RX_GEM_FILTERED,
+		Help:    "Frames discarded because they were carried on the GEM port of another ONU",
+		Collect: perPort(func(mc *PonSimMetricCounter) [2]int { return mc.GemFilter }),
+	},
+	{
+		Name: METRIC_CPU_PACKETS,
+		Help: "Frames queued towards VOLTHA, by class",
+		Collect: func(mc *PonSimMetricCounter) []prometheusSample {
+			return []prometheusSample{
+				{Labels: []string{"class", prometheusClasses[0]}, Value: mc.ToCpu[0]},
+				{Labels: []string{"class", prometheusClasses[1]}, Value: mc.ToCpu[1]},
+			}
+		},
+	},
+	{
+		Name: METRIC_CPU_DROPPED_PACKETS,
+		Help: "Frames dropped because the queue towards VOLTHA was full, by class",
+		Collect: func(mc *PonSimMetricCounter) []prometheusSample {
+			return []prometheusSample{
+				{Labels: []string{"class", prometheusClasses[0]}, Value: mc.CpuDropped[0]},
+				{Labels: []string{"class", prometheusClasses[1]}, Value: mc.CpuDropped[1]},
+			}
+		},
+	},
+	{
+		Name: METRIC_ALARMS,
+		Help: "Alarms sent towards VOLTHA, by state",
+		Collect: func(mc *PonSimMetricCounter) []prometheusSample {
+			return []prometheusSample{
+				{Labels: []string{"state", prometheusAlarmStates[0]}, Value: mc.Alarms[0]},
+				{Labels: []string{"state", prometheusAlarmStates[1]}, Value: mc.Alarms[1]},
+			}
+		},
+	},
+	{
+		Name: METRIC_FLOW_UPDATES,
+		Help: "Flow table updates, by result",
+		Collect: func(mc *PonSimMetricCounter) []prometheusSample {
+			return []prometheusSample{
+				{Labels: []string{"result", prometheusFlowResults[0]}, Value: mc.FlowUpdates[0]},
+				{Labels: []string{"result", prometheusFlowResults[1]}, Value: mc.FlowUpdates[1]},
+			}
+		},
+	},
+	{
+		Name: METRIC_FLOW_UPDATE_TIME,
+		Help: "Time spent applying flow table updates",
+		Collect: func(mc *PonSimMetricCounter) []prometheusSample {
+			return []prometheusSample{
+				{Value: int(mc.FlowUpdateTime / time.Microsecond)},
+			}
+		},
+	},
+}
+
+var prometheusEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+/*
+WritePrometheusMetrics writes the counters of devices in the Prometheus text exposition format,
+each sample being labelled with the name of its device
+*/
+func WritePrometheusMetrics(w io.Writer, counters ...*PonSimMetricCounter) error {
+	for _, metric := range prometheusMetrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", metric.Name, metric.Help, metric.Name); err != nil {
+			return err
+		}
+
+		for _, mc := range counters {
+			mc.mutex.Lock()
+			samples := metric.Collect(mc)
+			mc.mutex.Unlock()
+
+			for _, sample := range samples {
+				labels := []string{fmt.Sprintf(`device="%s"`, prometheusEscaper.Replace(mc.Name))}
+				for i := 0; i+1 < len(sample.Labels); i += 2 {
+					labels = append(labels, fmt.Sprintf(`%s="%s"`, sample.Labels[i], prometheusEscaper.Replace(sample.Labels[i+1])))
+				}
+
+				if _, err := fmt.Fprintf(w, "%s{%s} %d\n", metric.Name, strings.Join(labels, ","), sample.Value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+NewPonSimMetricsHandler returns an HTTP handler exposing the counters of devices to Prometheus
+*/
+func NewPonSimMetricsHandler(counters ...*PonSimMetricCounter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WritePrometheusMetrics(w, counters...)
+	})
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// Maximum logical reach between the OLT and an ONU, in meters
+	MAX_ONU_DISTANCE = 20000
+
+	// Propagation delay of the light in the fiber, per kilometer
+	FIBER_DELAY_PER_KM = 5 * time.Microsecond
+
+	// Time taken by an ONU to answer a ranging request
+	ONU_RESPONSE_TIME = 35 * time.Microsecond
+)
+
+/*
+PonSimRanging holds the result of the ranging of an ONU.  The OLT equalizes the ONUs so that
+they all appear at the maximum reach: the upstream frames of an ONU are held for its
+equalization delay on top of the propagation delay, while the downstream frames only suffer
+the propagation delay.
+*/
+type PonSimRanging struct {
+	Distance          uint32        `json:"distance"`
+	RoundTripDelay    time.Duration `json:"round_trip_delay"`
+	EqualizationDelay time.Duration `json:"equalization_delay"`
+}
+
+/*
+propagationDelay returns the one way delay over the specified length of fiber, in meters
+*/
+func propagationDelay(distance uint32) time.Duration {
+	return time.Duration(distance) * FIBER_DELAY_PER_KM / 1000
+}
+
+/*
+NewPonSimRanging ranges an ONU at the specified distance from the OLT, in meters
+*/
+func NewPonSimRanging(distance uint32) (*PonSimRanging, error) {
+	if distance > MAX_ONU_DISTANCE {
+		return nil, fmt.Errorf("ONU at %dm is beyond the reach of the OLT (%dm)", distance, MAX_ONU_DISTANCE)
+	}
+
+	maxRoundTripDelay := 2*propagationDelay(MAX_ONU_DISTANCE) + ONU_RESPONSE_TIME
+	roundTripDelay := 2*propagationDelay(distance) + ONU_RESPONSE_TIME
+
+	return &PonSimRanging{
+		Distance:          distance,
+		RoundTripDelay:    roundTripDelay,
+		EqualizationDelay: maxRoundTripDelay - roundTripDelay,
+	}, nil
+}
+
+/*
+UpstreamDelay returns the latency of the frames sent by the ONU to the OLT
+*/
+func (r *PonSimRanging) UpstreamDelay() time.Duration {
+	if r == nil {
+		return 0
+	}
+	return propagationDelay(r.Distance) + r.EqualizationDelay
+}
+
+/*
+DownstreamDelay returns the latency of the frames sent by the OLT to the ONU
+*/
+func (r *PonSimRanging) DownstreamDelay() time.Duration {
+	if r == nil {
+		return 0
+	}
+	return propagationDelay(r.Distance)
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+PonSimRate is the sustained rate (in calls per second) and the burst of calls accepted for an RPC
+*/
+type PonSimRate struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+/*
+PonSimRateLimit limits the rate at which the management RPCs are accepted, to simulate the
+slow management plane of a real device.  Each limit is enforced by a token bucket, the RPCs
+covered by the limit on all of them sharing a single bucket.
+*/
+type PonSimRateLimit struct {
+	Limits   map[string]PonSimRate `json:"limits"`
+	Rejected int64                 `json:"rejected"`
+
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+/*
+ParseRateLimit parses a comma separated list of RPC rate limits in the format rpc=rate[:burst],
+e.g. UpdateFlowTable=5:10,all=20 where all stands for every RPC changing the state of the
+simulator which is not listed.  The burst defaults to the rate, and an empty specification
+disables rate limiting.
+*/
+func ParseRateLimit(spec string) (*PonSimRateLimit, error) {
+	limits := make(map[string]PonSimRate)
+
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		fields := strings.SplitN(entry, "=", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid rate limit specification: %s", entry)
+		}
+
+		method := strings.TrimSpace(fields[0])
+		if strings.ToLower(method) == "all" {
+			method = "all"
+		} else if i, err := parseEnum(AUDITABLE_METHODS, method); err != nil {
+			return nil, fmt.Errorf("unknown management RPC: %s", method)
+		} else {
+			method = AUDITABLE_METHODS[i]
+		}
+
+		values := strings.SplitN(fields[1], ":", 2)
+		rate, err := strconv.ParseFloat(strings.TrimSpace(values[0]), 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid rate for %s: %s", method, values[0])
+		}
+
+		burst := int(math.Ceil(rate))
+		if len(values) == 2 {
+			if burst, err = strconv.Atoi(strings.TrimSpace(values[1])); err != nil || burst < 1 {
+				return nil, fmt.Errorf("invalid burst for %s: %s", method, values[1])
+			}
+		}
+
+		if _, ok := limits[method]; ok {
+			return nil, fmt.Errorf("duplicate rate limit for %s", method)
+		}
+		limits[method] = PonSimRate{Rate: rate, Burst: burst}
+	}
+
+	if len(limits) == 0 {
+		return nil, nil
+	}
+
+	return NewPonSimRateLimit(limits), nil
+}
+
+/*
+NewPonSimRateLimit instantiates the token buckets enforcing rate limits, with a full burst
+*/
+func NewPonSimRateLimit(limits map[string]PonSimRate) *PonSimRateLimit {
+	l := &PonSimRateLimit{
+		Limits:  limits,
+		buckets: make(map[string]*tokenBucket),
+	}
+
+	now := time.Now()
+	newBucket := func(rate PonSimRate) *tokenBucket {
+		return &tokenBucket{
+			rate:   rate.Rate,
+			size:   float64(rate.Burst),
+			tokens: float64(rate.Burst),
+			last:   now,
+		}
+	}
+
+	if rate, ok := limits["all"]; ok {
+		shared := newBucket(rate)
+		for _, method := range AUDITABLE_METHODS {
+			l.buckets[method] = shared
+		}
+	}
+	for method, rate := range limits {
+		if method != "all" {
+			l.buckets[method] = newBucket(rate)
+		}
+	}
+
+	return l
+}
+
+/*
+Allow consumes a token for a call of an RPC, designated by its name without the service.
+It returns false if the call exceeds the rate limit of the RPC and must be rejected.
+*/
+func (l *PonSimRateLimit) Allow(method string) bool {
+	if l == nil {
+		return true
+	}
+
+	bucket, ok := l.buckets[method]
+	if !ok {
+		return true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	bucket.refill(time.Now())
+	if bucket.tokens < 1 {
+		atomic.AddInt64(&l.Rejected, 1)
+		return false
+	}
+	bucket.tokens--
+
+	return true
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/opencord/voltha/protos/go/ponsim"
+	"runtime"
+	"sort"
+	"time"
+)
+
+/*
+PonSimRunInfo describes the build and configuration of a simulator instance so that the
+results it produces can be attributed to an exact simulator setup
+*/
+type PonSimRunInfo struct {
+	Version   string            `json:"version"`
+	Commit    string            `json:"commit"`
+	GoVersion string            `json:"go_version"`
+	StartTime time.Time         `json:"start_time"`
+	Seed      int64             `json:"seed"`
+	Config    map[string]string `json:"config"`
+	Features  []string          `json:"features"`
+}
+
+/*
+NewPonSimRunInfo instantiates the run information of a simulator started now
+*/
+func NewPonSimRunInfo(version string, commit string, seed int64, config map[string]string) *PonSimRunInfo {
+	return &PonSimRunInfo{
+		Version:   version,
+		Commit:    commit,
+		GoVersion: runtime.Version(),
+		StartTime: time.Now(),
+		Seed:      seed,
+		Config:    config,
+	}
+}
+
+/*
+EnableFeature records an optional simulator feature as active for this run
+*/
+func (r *PonSimRunInfo) EnableFeature(feature string) {
+	r.Features = append(r.Features, feature)
+	sort.Strings(r.Features)
+}
+
+/*
+ConfigHash returns a digest of the active configuration which is identical for all
+instances started with the same settings
+*/
+func (r *PonSimRunInfo) ConfigHash() string {
+	var keys []string
+	for key := range r.Config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s=%s\n", key, r.Config[key])
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+/*
+MakeProto converts the run information to its GRPC representation
+*/
+func (r *PonSimRunInfo) MakeProto() *ponsim.RunInfo {
+	return &ponsim.RunInfo{
+		Version:    r.Version,
+		Commit:     r.Commit,
+		GoVersion:  r.GoVersion,
+		StartTime:  r.StartTime.UnixNano(),
+		ConfigHash: r.ConfigHash(),
+		Seed:       r.Seed,
+		Features:   r.Features,
+		Config:     r.Config,
+	}
+}
+
+/*
+MakeVersionProto converts the build information and the active features to their GRPC
+representation
+*/
+func (r *PonSimRunInfo) MakeVersionProto() *ponsim.VersionInfo {
+	return &ponsim.VersionInfo{
+		Version:   r.Version,
+		Commit:    r.Commit,
+		GoVersion: r.GoVersion,
+		Features:  r.Features,
+	}
+}
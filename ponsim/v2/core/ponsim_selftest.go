@@ -0,0 +1,261 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/openflow_13"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"google.golang.org/grpc/connectivity"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Names of the checks run by a self-test
+const (
+	SELF_TEST_FLOW_TABLE       = "flow-table"
+	SELF_TEST_CHANNELS         = "channels"
+	SELF_TEST_ONU_REACHABILITY = "onu-reachability"
+	SELF_TEST_OLT_REACHABILITY = "olt-reachability"
+	SELF_TEST_ONU_STATE        = "onu-state"
+)
+
+/*
+PonSimSelfTestCheck is the outcome of one of the consistency checks of a self-test
+*/
+type PonSimSelfTestCheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+/*
+newSelfTestCheck builds the outcome of a check from the problems it found, if any
+*/
+func newSelfTestCheck(name string, problems []string, detail string) PonSimSelfTestCheck {
+	if len(problems) > 0 {
+		return PonSimSelfTestCheck{Name: name, Detail: strings.Join(problems, "; ")}
+	}
+
+	return PonSimSelfTestCheck{Name: name, Passed: true, Detail: detail}
+}
+
+/*
+PonSimSelfTest holds the outcome of the consistency checks run by a self-test of a device
+*/
+type PonSimSelfTest struct {
+	Checks   []PonSimSelfTestCheck `json:"checks"`
+	Duration time.Duration         `json:"duration"`
+}
+
+/*
+Passed reports whether every check of the self-test passed
+*/
+func (t *PonSimSelfTest) Passed() bool {
+	for _, check := range t.Checks {
+		if !check.Passed {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+MakeProto reports the outcome of the self-test as a GRPC message
+*/
+func (t *PonSimSelfTest) MakeProto() *voltha.PonSimSelfTestResult {
+	result := &voltha.PonSimSelfTestResult{
+		Passed:     t.Passed(),
+		DurationNs: int64(t.Duration),
+	}
+	for _, check := range t.Checks {
+		result.Checks = append(result.Checks, &voltha.PonSimSelfTestCheck{
+			Name:   check.Name,
+			Passed: check.Passed,
+			Detail: check.Detail,
+		})
+	}
+
+	return result
+}
+
+/*
+runSelfTest times the checks of a self-test
+*/
+func runSelfTest(checks ...func() PonSimSelfTestCheck) *PonSimSelfTest {
+	start := time.Now()
+
+	test := &PonSimSelfTest{}
+	for _, check := range checks {
+		test.Checks = append(test.Checks, check())
+	}
+	test.Duration = time.Since(start)
+
+	return test
+}
+
+/*
+checkFlowTable verifies that the installed flows are sorted in decreasing order of priority,
+are not installed twice, and only reference existing groups and meters
+*/
+func (o *PonSimDevice) checkFlowTable() PonSimSelfTestCheck {
+	var problems []string
+
+	flows := o.flows.Get()
+	if !sort.IsSorted(common.SortByPriority(flows)) {
+		problems = append(problems, "flows are not sorted by priority")
+	}
+
+	for i, flow := range flows {
+		for _, other := range flows[:i] {
+			if isSameFlow(flow, other) {
+				problems = append(problems, fmt.Sprintf("flow %d duplicates a flow of priority %d", flow.Id, flow.Priority))
+				break
+			}
+		}
+
+		for _, instruction := range flow.Instructions {
+			switch instruction.Type {
+			case uint32(openflow_13.OfpInstructionType_OFPIT_METER):
+				if meterId := instruction.GetMeter().GetMeterId(); o.meters.Get(meterId) == nil {
+					problems = append(problems, fmt.Sprintf("flow %d references unknown meter %d", flow.Id, meterId))
+				}
+			case uint32(openflow_13.OfpInstructionType_OFPIT_APPLY_ACTIONS):
+				for _, action := range instruction.GetActions().GetActions() {
+					if action.Type != openflow_13.OfpActionType_OFPAT_GROUP {
+						continue
+					}
+					if groupId := action.GetGroup().GroupId; o.groups.Get(groupId) == nil {
+						problems = append(problems, fmt.Sprintf("flow %d references unknown group %d", flow.Id, groupId))
+					}
+				}
+			}
+		}
+	}
+
+	return newSelfTestCheck(SELF_TEST_FLOW_TABLE, problems, fmt.Sprintf("%d flows", len(flows)))
+}
+
+/*
+checkQueue reports a queue that is full, and so drops or holds back the frames pushed to it
+*/
+func checkQueue(problems []string, name string, queue *PonSimFrameQueue) []string {
+	if queue != nil && queue.Len() >= queue.Capacity {
+		problems = append(problems, fmt.Sprintf("%s queue is full (%d frames)", name, queue.Capacity))
+	}
+
+	return problems
+}
+
+/*
+SelfTest checks the consistency of the flow table of the OLT, the health of its frame queues
+and the reachability of its registered ONUs
+*/
+func (o *PonSimOltDevice) SelfTest() *PonSimSelfTest {
+	return runSelfTest(o.checkFlowTable, o.checkChannels, o.checkOnuReachability)
+}
+
+/*
+checkChannels verifies that the queues of frames sent towards VOLTHA are not full
+*/
+func (o *PonSimOltDevice) checkChannels() PonSimSelfTestCheck {
+	var problems []string
+
+	if control := o.control; control != nil && cap(control) > 0 && len(control) >= cap(control) {
+		problems = append(problems, fmt.Sprintf("control queue is full (%d frames)", cap(control)))
+	}
+	problems = checkQueue(problems, "outgoing", o.Outgoing)
+	if o.Priorities != nil {
+		for i, queue := range o.Priorities.Queues {
+			problems = checkQueue(problems, fmt.Sprintf("priority_queue%d", i), queue)
+		}
+	}
+
+	return newSelfTestCheck(SELF_TEST_CHANNELS, problems, "queues have room")
+}
+
+/*
+checkOnuReachability verifies that the connection and data stream of every registered ONU are
+established
+*/
+func (o *PonSimOltDevice) checkOnuReachability() PonSimSelfTestCheck {
+	var problems []string
+
+	onus := o.GetOnus()
+	ports := make([]int, 0, len(onus))
+	for port := range onus {
+		ports = append(ports, int(port))
+	}
+	sort.Ints(ports)
+
+	for _, port := range ports {
+		onu := onus[int32(port)]
+		if onu.Conn == nil || onu.Conn.GetState() != connectivity.Ready {
+			problems = append(problems, fmt.Sprintf("ONU on port %d is not connected", port))
+		} else if onu.Stream == nil {
+			problems = append(problems, fmt.Sprintf("ONU on port %d has no data stream", port))
+		}
+	}
+
+	return newSelfTestCheck(SELF_TEST_ONU_REACHABILITY, problems, fmt.Sprintf("%d ONUs reachable", len(onus)))
+}
+
+/*
+SelfTest checks the consistency of the flow table of the ONU, its connection to the OLT and its
+lifecycle state
+*/
+func (o *PonSimOnuDevice) SelfTest() *PonSimSelfTest {
+	return runSelfTest(o.checkFlowTable, o.checkOltReachability, o.checkOnuState)
+}
+
+/*
+checkOltReachability verifies that the connection and data stream to the OLT are established
+*/
+func (o *PonSimOnuDevice) checkOltReachability() PonSimSelfTestCheck {
+	var problems []string
+	var target string
+
+	if conn := o.Conn; conn == nil || conn.GetState() != connectivity.Ready {
+		problems = append(problems, "not connected to the OLT")
+	} else if o.stream == nil {
+		problems = append(problems, "no data stream to the OLT")
+	} else {
+		target = conn.Target()
+	}
+
+	return newSelfTestCheck(SELF_TEST_OLT_REACHABILITY, problems, fmt.Sprintf("connected to %s", target))
+}
+
+/*
+checkOnuState verifies that the ONU completed its activation
+*/
+func (o *PonSimOnuDevice) checkOnuState() PonSimSelfTestCheck {
+	var problems []string
+
+	state, reason, _ := o.GetOnuState()
+	if state != ONU_STATE_ACTIVE {
+		detail := fmt.Sprintf("ONU is %s", state)
+		if reason != "" {
+			detail += fmt.Sprintf(" (%s)", reason)
+		}
+		problems = append(problems, detail)
+	}
+
+	return newSelfTestCheck(SELF_TEST_ONU_STATE, problems, state)
+}
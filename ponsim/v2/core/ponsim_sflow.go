@@ -0,0 +1,398 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_SFLOW_PORT = 6343
+
+	// Bytes of the headers of a sampled frame exported to the collector
+	SFLOW_HEADER_SIZE = 128
+
+	SFLOW_VERSION            = 5
+	SFLOW_ADDRESS_IPV4       = 1
+	SFLOW_ADDRESS_IPV6       = 2
+	SFLOW_FLOW_SAMPLE        = 1
+	SFLOW_COUNTERS_SAMPLE    = 2
+	SFLOW_RAW_PACKET_HEADER  = 1
+	SFLOW_GENERIC_INTERFACE  = 1
+	SFLOW_HEADER_ETHERNET    = 1
+	SFLOW_IF_TYPE_ETHERNET   = 6
+	SFLOW_IF_DIRECTION_FULL  = 1
+	SFLOW_IF_STATUS_ADMIN_UP = 1
+	SFLOW_IF_STATUS_OPER_UP  = 2
+)
+
+/*
+PonSimSflowAgent exports the frames forwarded by devices to an sFlow collector.  Each device is
+a sub-agent whose ports are the data sources, identified by their port number as ifIndex.  One
+frame in Rate forwarded from a port is sampled at random, and the interface counters of the
+ports are exported at every Interval.
+*/
+type PonSimSflowAgent struct {
+	Collector string        `json:"collector"`
+	Rate      int           `json:"rate"`
+	Interval  time.Duration `json:"interval"`
+
+	conn     net.Conn
+	address  net.IP
+	start    time.Time
+	samplers []*PonSimSflowSampler
+}
+
+/*
+PonSimSflowSampler samples the frames forwarded by a device on behalf of an sFlow agent
+*/
+type PonSimSflowSampler struct {
+	agent      *PonSimSflowAgent
+	subAgentId uint32
+	portStates *PonSimPortStates
+
+	mutex    sync.Mutex
+	sequence uint32
+	ports    map[int]*sflowPort
+}
+
+type sflowPort struct {
+	flowSequence    uint32
+	counterSequence uint32
+	pool            uint32 // Frames forwarded from the port, among which samples are drawn
+	skip            int    // Frames left to forward before the next sample
+	in              sflowCounters
+	out             sflowCounters
+}
+
+type sflowCounters struct {
+	octets    uint64
+	unicast   uint32
+	multicast uint32
+	broadcast uint32
+}
+
+/*
+sflowWriter encodes the XDR structures of sFlow datagrams
+*/
+type sflowWriter struct {
+	bytes.Buffer
+}
+
+/*
+NewPonSimSflowAgent instantiates an agent exporting to a collector, whose port defaults to
+6343.  Counters are not exported when the interval is 0.
+*/
+func NewPonSimSflowAgent(collector string, rate int, interval time.Duration) (*PonSimSflowAgent, error) {
+	if rate < 1 {
+		return nil, fmt.Errorf("invalid sampling rate %d, expected at least 1", rate)
+	}
+	if interval < 0 {
+		return nil, fmt.Errorf("invalid counter polling interval %s", interval)
+	}
+
+	if _, _, err := net.SplitHostPort(collector); err != nil {
+		collector = net.JoinHostPort(collector, strconv.Itoa(DEFAULT_SFLOW_PORT))
+	}
+
+	conn, err := net.Dial("udp", collector)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PonSimSflowAgent{
+		Collector: collector,
+		Rate:      rate,
+		Interval:  interval,
+		conn:      conn,
+		address:   conn.LocalAddr().(*net.UDPAddr).IP,
+		start:     time.Now(),
+	}, nil
+}
+
+/*
+AddDevice makes the agent sample the frames forwarded by a device, which must not forward
+frames yet
+*/
+func (a *PonSimSflowAgent) AddDevice(device *PonSimDevice) {
+	sampler := &PonSimSflowSampler{
+		agent:      a,
+		subAgentId: uint32(len(a.samplers)),
+		portStates: device.PortStates,
+		ports:      make(map[int]*sflowPort),
+	}
+
+	a.samplers = append(a.samplers, sampler)
+	device.Sflow = sampler
+}
+
+/*
+Start exports the interface counters at every interval until the context is cancelled
+*/
+func (a *PonSimSflowAgent) Start(ctx context.Context) {
+	go func() {
+		defer a.conn.Close()
+
+		if a.Interval == 0 {
+			<-ctx.Done()
+			return
+		}
+
+		ticker := time.NewTicker(a.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				for _, sampler := range a.samplers {
+					sampler.pollCounters()
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+/*
+nextSkip draws the number of frames to forward until the next sample, averaging the rate
+*/
+func (a *PonSimSflowAgent) nextSkip() int {
+	if a.Rate == 1 {
+		return 1
+	}
+	return 1 + rand.Intn(2*a.Rate-1)
+}
+
+func (a *PonSimSflowAgent) send(datagram []byte) {
+	if _, err := a.conn.Write(datagram); err != nil {
+		common.Logger().WithFields(logrus.Fields{
+			"collector": a.Collector,
+			"error":     err.Error(),
+		}).Warn("Failed to export sFlow datagram")
+	}
+}
+
+/*
+Sample counts a frame forwarded from a port to another and exports it to the collector if it
+is selected
+*/
+func (s *PonSimSflowSampler) Sample(port int, egressPort int, frame gopacket.Packet) {
+	if s == nil {
+		return
+	}
+
+	data := frame.Data()
+
+	s.mutex.Lock()
+
+	ingress, egress := s.port(port), s.port(egressPort)
+	ingress.in.count(data)
+	egress.out.count(data)
+	ingress.pool++
+
+	if ingress.skip--; ingress.skip > 0 {
+		s.mutex.Unlock()
+		return
+	}
+	ingress.skip = s.agent.nextSkip()
+	ingress.flowSequence++
+
+	header := data
+	if len(header) > SFLOW_HEADER_SIZE {
+		header = header[:SFLOW_HEADER_SIZE]
+	}
+
+	record := &sflowWriter{}
+	record.u32(SFLOW_HEADER_ETHERNET)
+	record.u32(uint32(len(data)))
+	record.u32(0)
+	record.opaque(header)
+
+	sample := &sflowWriter{}
+	sample.u32(ingress.flowSequence)
+	sample.u32(uint32(port))
+	sample.u32(uint32(s.agent.Rate))
+	sample.u32(ingress.pool)
+	sample.u32(0)
+	sample.u32(uint32(port))
+	sample.u32(uint32(egressPort))
+	sample.u32(1)
+	sample.u32(SFLOW_RAW_PACKET_HEADER)
+	sample.opaque(record.Bytes())
+
+	datagram := s.datagram(SFLOW_FLOW_SAMPLE, sample.Bytes())
+
+	s.mutex.Unlock()
+
+	s.agent.send(datagram)
+}
+
+/*
+pollCounters exports the generic interface counters of the ports which forwarded frames
+*/
+func (s *PonSimSflowSampler) pollCounters() {
+	s.mutex.Lock()
+
+	if len(s.ports) == 0 {
+		s.mutex.Unlock()
+		return
+	}
+
+	numbers := make([]int, 0, len(s.ports))
+	for number := range s.ports {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+
+	var samples [][]byte
+	for _, number := range numbers {
+		port := s.ports[number]
+		port.counterSequence++
+
+		status := uint32(0)
+		if s.portStates.IsEnabled(number) {
+			status |= SFLOW_IF_STATUS_ADMIN_UP
+		}
+		if s.portStates.IsUp(number) {
+			status |= SFLOW_IF_STATUS_OPER_UP
+		}
+
+		record := &sflowWriter{}
+		record.u32(uint32(number))
+		record.u32(SFLOW_IF_TYPE_ETHERNET)
+		record.u64(0) // Unknown speed
+		record.u32(SFLOW_IF_DIRECTION_FULL)
+		record.u32(status)
+		record.counters(port.in)
+		record.u32(0) // Unknown protocols
+		record.counters(port.out)
+		record.u32(0) // Not promiscuous
+
+		sample := &sflowWriter{}
+		sample.u32(port.counterSequence)
+		sample.u32(uint32(number))
+		sample.u32(1)
+		sample.u32(SFLOW_GENERIC_INTERFACE)
+		sample.opaque(record.Bytes())
+
+		samples = append(samples, sample.Bytes())
+	}
+
+	datagram := s.datagram(SFLOW_COUNTERS_SAMPLE, samples...)
+
+	s.mutex.Unlock()
+
+	s.agent.send(datagram)
+}
+
+/*
+datagram encodes the samples of the same format in a datagram of the sub-agent
+*/
+func (s *PonSimSflowSampler) datagram(format uint32, samples ...[]byte) []byte {
+	s.sequence++
+
+	datagram := &sflowWriter{}
+	datagram.u32(SFLOW_VERSION)
+	if ipv4 := s.agent.address.To4(); ipv4 != nil {
+		datagram.u32(SFLOW_ADDRESS_IPV4)
+		datagram.Write(ipv4)
+	} else {
+		datagram.u32(SFLOW_ADDRESS_IPV6)
+		datagram.Write(s.agent.address.To16())
+	}
+	datagram.u32(s.subAgentId)
+	datagram.u32(s.sequence)
+	datagram.u32(uint32(time.Since(s.agent.start) / time.Millisecond))
+	datagram.u32(uint32(len(samples)))
+	for _, sample := range samples {
+		datagram.u32(format)
+		datagram.opaque(sample)
+	}
+
+	return datagram.Bytes()
+}
+
+func (s *PonSimSflowSampler) port(number int) *sflowPort {
+	port, ok := s.ports[number]
+	if !ok {
+		port = &sflowPort{skip: s.agent.nextSkip()}
+		s.ports[number] = port
+	}
+	return port
+}
+
+func (c *sflowCounters) count(data []byte) {
+	c.octets += uint64(len(data))
+
+	switch {
+	case len(data) < 6 || data[0]&1 == 0:
+		c.unicast++
+	case bytes.Equal(data[:6], layers.EthernetBroadcast):
+		c.broadcast++
+	default:
+		c.multicast++
+	}
+}
+
+func (w *sflowWriter) u32(value uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], value)
+	w.Write(b[:])
+}
+
+func (w *sflowWriter) u64(value uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], value)
+	w.Write(b[:])
+}
+
+/*
+counters encodes the octets and frames received or sent on an interface, followed by its
+discards and errors which are not simulated
+*/
+func (w *sflowWriter) counters(c sflowCounters) {
+	w.u64(c.octets)
+	w.u32(c.unicast)
+	w.u32(c.multicast)
+	w.u32(c.broadcast)
+	w.u32(0)
+	w.u32(0)
+}
+
+/*
+opaque encodes variable length data, padded to a multiple of 4 bytes
+*/
+func (w *sflowWriter) opaque(data []byte) {
+	w.u32(uint32(len(data)))
+	w.Write(data)
+	if padding := len(data) % 4; padding != 0 {
+		w.Write(make([]byte, 4-padding))
+	}
+}
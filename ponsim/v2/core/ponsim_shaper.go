@@ -0,0 +1,195 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Smallest burst size accepted by a shaper; it must hold at least one maximum sized frame
+	MIN_BURST_SIZE = 1522
+
+	// Frames which would have to wait longer than this to conform are dropped
+	MAX_SHAPING_DELAY = time.Second
+)
+
+/*
+PonSimBandwidthProfile defines the rates (in kbps) and burst sizes (in bytes) provisioned on a UNI port
+*/
+type PonSimBandwidthProfile struct {
+	Cir int `json:"cir"`
+	Pir int `json:"pir"`
+	Cbs int `json:"cbs"`
+	Pbs int `json:"pbs"`
+}
+
+/*
+tokenBucket accumulates tokens (in bytes) at a fixed rate up to the bucket size
+*/
+type tokenBucket struct {
+	rate   float64
+	size   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rateKbps int, size int) *tokenBucket {
+	if size < MIN_BURST_SIZE {
+		size = MIN_BURST_SIZE
+	}
+	return &tokenBucket{
+		rate:   float64(rateKbps) * 1000 / 8,
+		size:   float64(size),
+		tokens: float64(size),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.size {
+		b.tokens = b.size
+	}
+	b.last = now
+}
+
+/*
+PonSimShaper enforces a bandwidth profile on the traffic of a single direction.
+
+Frames fitting in the committed bucket are forwarded immediately (green), frames fitting
+in the peak bucket are forwarded as excess traffic (yellow) and the remaining frames
+are delayed until the peak bucket refills, or dropped if that would take too long (red).
+*/
+type PonSimShaper struct {
+	Profile PonSimBandwidthProfile `json:"profile"`
+
+	GreenFrames   int64 `json:"green_frames"`
+	YellowFrames  int64 `json:"yellow_frames"`
+	DelayedFrames int64 `json:"delayed_frames"`
+	RedFrames     int64 `json:"red_frames"`
+
+	mutex     sync.Mutex
+	committed *tokenBucket
+	peak      *tokenBucket
+	queue     *PonSimDelayQueue
+}
+
+/*
+NewPonSimShaper instantiates a shaper for the specified bandwidth profile
+*/
+func NewPonSimShaper(profile PonSimBandwidthProfile) *PonSimShaper {
+	// The peak rate can never be lower than the committed rate
+	if profile.Pir < profile.Cir {
+		profile.Pir = profile.Cir
+	}
+
+	return &PonSimShaper{
+		Profile:   profile,
+		committed: newTokenBucket(profile.Cir, profile.Cbs),
+		peak:      newTokenBucket(profile.Pir, profile.Pbs),
+		queue:     NewPonSimDelayQueue(DEFAULT_DELAY_QUEUE_DEPTH),
+	}
+}
+
+/*
+Shape reserves bandwidth for a frame of the specified size.  It returns the delay to apply
+before transmitting the frame and whether the frame may be transmitted at all.
+*/
+func (s *PonSimShaper) Shape(size int) (time.Duration, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	s.committed.refill(now)
+	s.peak.refill(now)
+
+	bytes := float64(size)
+
+	switch {
+	case s.committed.tokens >= bytes:
+		s.committed.tokens -= bytes
+		s.peak.tokens -= bytes
+		atomic.AddInt64(&s.GreenFrames, 1)
+		return 0, true
+
+	case s.peak.tokens >= bytes:
+		s.peak.tokens -= bytes
+		atomic.AddInt64(&s.YellowFrames, 1)
+		return 0, true
+
+	case s.peak.rate > 0:
+		delay := time.Duration((bytes - s.peak.tokens) / s.peak.rate * float64(time.Second))
+		if delay <= MAX_SHAPING_DELAY {
+			// Borrow the tokens; the bucket is replenished while the frame is delayed
+			s.peak.tokens -= bytes
+			atomic.AddInt64(&s.DelayedFrames, 1)
+			return delay, true
+		}
+	}
+
+	atomic.AddInt64(&s.RedFrames, 1)
+	return 0, false
+}
+
+/*
+Release reserves bandwidth for a frame of the specified size and queues its release until
+it conforms to the profile.  Frames are released in order, including those which were not
+delayed.  It returns false if the frame exceeds the profile and must be dropped.
+*/
+func (s *PonSimShaper) Release(size int, release func()) (bool, error) {
+	delay, ok := s.Shape(size)
+	if !ok {
+		return false, nil
+	}
+
+	return true, s.queue.Submit(delay, release)
+}
+
+/*
+Stop terminates the release of the frames, discarding those still delayed
+*/
+func (s *PonSimShaper) Stop() {
+	s.queue.Stop()
+}
+
+/*
+PonSimPortShaper holds the upstream and downstream shapers of a UNI port
+*/
+type PonSimPortShaper struct {
+	Upstream   *PonSimShaper `json:"upstream"`
+	Downstream *PonSimShaper `json:"downstream"`
+}
+
+/*
+NewPonSimPortShaper instantiates shapers enforcing the same bandwidth profile in both directions
+*/
+func NewPonSimPortShaper(profile PonSimBandwidthProfile) *PonSimPortShaper {
+	return &PonSimPortShaper{
+		Upstream:   NewPonSimShaper(profile),
+		Downstream: NewPonSimShaper(profile),
+	}
+}
+
+/*
+Stop terminates the shapers of both directions
+*/
+func (s *PonSimPortShaper) Stop() {
+	s.Upstream.Stop()
+	s.Downstream.Stop()
+}
@@ -0,0 +1,649 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_SNMP_COMMUNITY = "public"
+
+	// Largest number of variables returned by a GetBulk request
+	SNMP_MAX_REPETITIONS = 100
+
+	SNMP_VERSION_1  = 0
+	SNMP_VERSION_2C = 1
+
+	SNMP_GET      = 0xa0
+	SNMP_GET_NEXT = 0xa1
+	SNMP_RESPONSE = 0xa2
+	SNMP_SET      = 0xa3
+	SNMP_GET_BULK = 0xa5
+
+	SNMP_NO_SUCH_NAME   = 2
+	SNMP_READ_ONLY      = 4
+	SNMP_NOT_WRITABLE   = 17
+	SNMP_NO_SUCH_OBJECT = 0x80
+	SNMP_END_OF_MIB     = 0x82
+
+	BER_INTEGER      = 0x02
+	BER_OCTET_STRING = 0x04
+	BER_NULL         = 0x05
+	BER_OID          = 0x06
+	BER_SEQUENCE     = 0x30
+	BER_COUNTER32    = 0x41
+	BER_GAUGE32      = 0x42
+	BER_TIMETICKS    = 0x43
+	BER_COUNTER64    = 0x46
+
+	// Types of the interfaces in the IF-MIB
+	SNMP_IF_TYPE_ETHERNET = 6
+	SNMP_IF_TYPE_GPON     = 250
+)
+
+/*
+Roots of the objects exposed by the agent: the system group and the interfaces of the IF-MIB,
+and the PON MIB of the simulator in the experimental arc
+*/
+var (
+	snmpSystem   = []uint32{1, 3, 6, 1, 2, 1, 1}
+	snmpIfTable  = []uint32{1, 3, 6, 1, 2, 1, 2}
+	snmpIfXTable = []uint32{1, 3, 6, 1, 2, 1, 31, 1, 1, 1}
+	snmpPonMib   = []uint32{1, 3, 6, 1, 3, 9999}
+)
+
+/*
+PonSimSnmpAgent answers the SNMP v1 and v2c requests of an NMS with the statistics of the
+devices, the same as returned by GetStats.  The first device is selected by the community,
+another one by appending its name to the community, e.g. public@PON_OLT_ONU_1.  The agent is
+read-only.
+*/
+type PonSimSnmpAgent struct {
+	Address   string `json:"address"`
+	Community string `json:"-"`
+
+	conn    net.PacketConn
+	start   time.Time
+	devices []PonSimInterface
+}
+
+/*
+snmpObject is an object instance of the MIB along with its encoded value
+*/
+type snmpObject struct {
+	Oid   []uint32
+	Value []byte
+}
+
+/*
+snmpPdu is a decoded request, whose second and third fields are the non-repeaters and
+maximum repetitions of a GetBulk request
+*/
+type snmpPdu struct {
+	Type      byte
+	RequestId []byte
+	Fields    [2]int
+	Names     [][]uint32
+}
+
+/*
+NewPonSimSnmpAgent instantiates an agent listening on a UDP address for the requests about
+devices
+*/
+func NewPonSimSnmpAgent(address string, community string, devices ...PonSimInterface) (*PonSimSnmpAgent, error) {
+	if len(devices) == 0 {
+		return nil, errors.New("no device to manage")
+	}
+
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PonSimSnmpAgent{
+		Address:   conn.LocalAddr().String(),
+		Community: community,
+		conn:      conn,
+		start:     time.Now(),
+		devices:   devices,
+	}, nil
+}
+
+/*
+Start answers the requests until the context is cancelled
+*/
+func (a *PonSimSnmpAgent) Start(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		a.conn.Close()
+	}()
+
+	go func() {
+		buffer := make([]byte, 65535)
+		for {
+			n, peer, err := a.conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+
+			response, err := a.handle(buffer[:n])
+			if err != nil {
+				common.Logger().WithFields(logrus.Fields{
+					"peer":  peer.String(),
+					"error": err.Error(),
+				}).Debug("Discarding SNMP request")
+				continue
+			}
+
+			a.conn.WriteTo(response, peer)
+		}
+	}()
+}
+
+/*
+handle decodes a request and encodes its response.  Requests of another community are
+discarded, as SNMP agents do.
+*/
+func (a *PonSimSnmpAgent) handle(request []byte) ([]byte, error) {
+	message, _, err := berParse(request, BER_SEQUENCE)
+	if err != nil {
+		return nil, err
+	}
+	versionField, message, err := berParse(message, BER_INTEGER)
+	if err != nil {
+		return nil, err
+	}
+	version := int(berInt(versionField))
+	if version != SNMP_VERSION_1 && version != SNMP_VERSION_2C {
+		return nil, fmt.Errorf("unsupported SNMP version %d", version+1)
+	}
+	community, message, err := berParse(message, BER_OCTET_STRING)
+	if err != nil {
+		return nil, err
+	}
+	device, err := a.getDevice(string(community))
+	if err != nil {
+		return nil, err
+	}
+	pdu, err := parsePdu(message)
+	if err != nil {
+		return nil, err
+	}
+	if pdu.Type == SNMP_GET_BULK && version == SNMP_VERSION_1 {
+		return nil, errors.New("GetBulk is not supported by SNMP v1")
+	}
+
+	objects := a.makeObjects(device)
+	if version == SNMP_VERSION_1 {
+		// Counter64 does not exist in SNMP v1
+		supported := objects[:0]
+		for _, object := range objects {
+			if object.Value[0] != BER_COUNTER64 {
+				supported = append(supported, object)
+			}
+		}
+		objects = supported
+	}
+
+	var results []snmpObject
+	errorStatus, errorIndex := 0, 0
+
+	switch pdu.Type {
+	case SNMP_GET, SNMP_GET_NEXT:
+		for i, name := range pdu.Names {
+			var object snmpObject
+			if pdu.Type == SNMP_GET {
+				object = getObject(objects, name)
+			} else {
+				object = getNextObject(objects, name)
+			}
+			if version == SNMP_VERSION_1 && object.Value[0]&0x80 != 0 && errorStatus == 0 {
+				errorStatus, errorIndex = SNMP_NO_SUCH_NAME, i+1
+			}
+			results = append(results, object)
+		}
+
+	case SNMP_GET_BULK:
+		nonRepeaters, repetitions := pdu.Fields[0], pdu.Fields[1]
+		if nonRepeaters < 0 {
+			nonRepeaters = 0
+		}
+		if nonRepeaters > len(pdu.Names) {
+			nonRepeaters = len(pdu.Names)
+		}
+		for _, name := range pdu.Names[:nonRepeaters] {
+			results = append(results, getNextObject(objects, name))
+		}
+
+		names := pdu.Names[nonRepeaters:]
+		for r := 0; r < repetitions && len(names) > 0 && len(results) < SNMP_MAX_REPETITIONS; r++ {
+			for i, name := range names {
+				object := getNextObject(objects, name)
+				results = append(results, object)
+				names[i] = object.Oid
+			}
+		}
+
+	case SNMP_SET:
+		errorStatus, errorIndex = SNMP_NOT_WRITABLE, 1
+		if version == SNMP_VERSION_1 {
+			errorStatus = SNMP_READ_ONLY
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported SNMP PDU type %#x", pdu.Type)
+	}
+
+	// Errors are reported along with the variables of the request
+	if errorStatus != 0 {
+		results = results[:0]
+		for _, name := range pdu.Names {
+			results = append(results, snmpObject{Oid: name, Value: berEncode(BER_NULL, nil)})
+		}
+	}
+
+	var varbinds []byte
+	for _, result := range results {
+		varbinds = append(varbinds, berEncode(BER_SEQUENCE, append(berEncode(BER_OID, berOid(result.Oid)), result.Value...))...)
+	}
+
+	body := berEncode(BER_INTEGER, pdu.RequestId)
+	body = append(body, berEncodeInt(BER_INTEGER, int64(errorStatus))...)
+	body = append(body, berEncodeInt(BER_INTEGER, int64(errorIndex))...)
+	body = append(body, berEncode(BER_SEQUENCE, varbinds)...)
+
+	response := berEncodeInt(BER_INTEGER, int64(version))
+	response = append(response, berEncode(BER_OCTET_STRING, community)...)
+	response = append(response, berEncode(SNMP_RESPONSE, body)...)
+
+	return berEncode(BER_SEQUENCE, response), nil
+}
+
+/*
+getDevice returns the device selected by the community of a request
+*/
+func (a *PonSimSnmpAgent) getDevice(community string) (PonSimInterface, error) {
+	name := ""
+	if i := strings.Index(community, "@"); i >= 0 {
+		community, name = community[:i], community[i+1:]
+	}
+	if community != a.Community {
+		return nil, errors.New("unknown community")
+	}
+	if name == "" {
+		return a.devices[0], nil
+	}
+
+	for _, device := range a.devices {
+		if d := getPonSimDevice(device); d != nil && d.Name == name {
+			return device, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown device %s", name)
+}
+
+/*
+makeObjects collects the objects of the MIB of a device, in lexicographic order of their OIDs
+*/
+func (a *PonSimSnmpAgent) makeObjects(device PonSimInterface) []snmpObject {
+	var metrics *voltha.PonSimMetrics
+	var onus map[int32]*OnuRegistree
+	uni := "nni"
+
+	switch d := device.(type) {
+	case *PonSimOltDevice:
+		metrics = d.MakeMetrics()
+		onus = d.GetOnus()
+	case *PonSimOnuDevice:
+		metrics = d.MakeMetrics()
+		uni = "uni"
+	default:
+		return nil
+	}
+	d := getPonSimDevice(device)
+
+	var objects []snmpObject
+	add := func(root []uint32, suffix []uint32, value []byte) {
+		oid := make([]uint32, 0, len(root)+len(suffix))
+		objects = append(objects, snmpObject{Oid: append(append(oid, root...), suffix...), Value: value})
+	}
+
+	add(snmpSystem, []uint32{1, 0}, berEncode(BER_OCTET_STRING, []byte(fmt.Sprintf("PONSIM %s", d.Name))))
+	add(snmpSystem, []uint32{2, 0}, berEncode(BER_OID, berOid(snmpPonMib)))
+	add(snmpSystem, []uint32{3, 0}, berEncodeUint(BER_TIMETICKS, uint64(time.Since(a.start)/(10*time.Millisecond))))
+	add(snmpSystem, []uint32{5, 0}, berEncode(BER_OCTET_STRING, []byte(d.Name)))
+
+	// The PON port (1) and the NNI or UNI port (2) are the interfaces
+	counters := make(map[string]map[string]int64)
+	for _, group := range metrics.Metrics {
+		counters[group.PortName] = make(map[string]int64)
+		for _, counter := range group.Packets {
+			counters[group.PortName][counter.Name] = counter.Value
+		}
+	}
+
+	add(snmpIfTable, []uint32{1, 0}, berEncodeInt(BER_INTEGER, 2))
+	for i, name := range []string{"pon", "nni"} {
+		port := i + 1
+		values := counters[name]
+		descr := name
+		if name == "nni" {
+			descr = uni
+		}
+
+		var rx, tx int64
+		for _, counter := range rxMetricCounterEnum {
+			rx += values[counter]
+		}
+		for _, counter := range txMetricCounterEnum {
+			tx += values[counter]
+		}
+		discards := values["rx_dropped_pkts"] + values["rx_duplicate_pkts"] + values["oversize_pkts"] + values["rx_gem_filtered_pkts"]
+		inErrors := values["rx_corrupted_pkts"] + values["rx_hash_errors"]
+
+		ifType := int64(SNMP_IF_TYPE_ETHERNET)
+		if name == "pon" {
+			ifType = SNMP_IF_TYPE_GPON
+		}
+		adminStatus, operStatus := int64(1), int64(1)
+		if !d.PortStates.IsEnabled(port) {
+			adminStatus = 2
+		}
+		if !d.PortStates.IsUp(port) {
+			operStatus = 2
+		}
+
+		index := uint32(port)
+		add(snmpIfTable, []uint32{2, 1, 1, index}, berEncodeInt(BER_INTEGER, int64(port)))
+		add(snmpIfTable, []uint32{2, 1, 2, index}, berEncode(BER_OCTET_STRING, []byte(descr)))
+		add(snmpIfTable, []uint32{2, 1, 3, index}, berEncodeInt(BER_INTEGER, ifType))
+		add(snmpIfTable, []uint32{2, 1, 4, index}, berEncodeInt(BER_INTEGER, int64(d.Mtus.Get(port))))
+		add(snmpIfTable, []uint32{2, 1, 7, index}, berEncodeInt(BER_INTEGER, adminStatus))
+		add(snmpIfTable, []uint32{2, 1, 8, index}, berEncodeInt(BER_INTEGER, operStatus))
+		add(snmpIfTable, []uint32{2, 1, 11, index}, berEncodeUint(BER_COUNTER32, uint64(uint32(rx))))
+		add(snmpIfTable, []uint32{2, 1, 13, index}, berEncodeUint(BER_COUNTER32, uint64(uint32(discards))))
+		add(snmpIfTable, []uint32{2, 1, 14, index}, berEncodeUint(BER_COUNTER32, uint64(uint32(inErrors))))
+		add(snmpIfTable, []uint32{2, 1, 17, index}, berEncodeUint(BER_COUNTER32, uint64(uint32(tx))))
+		add(snmpIfXTable, []uint32{1, index}, berEncode(BER_OCTET_STRING, []byte(descr)))
+		add(snmpIfXTable, []uint32{7, index}, berEncodeUint(BER_COUNTER64, uint64(rx)))
+		add(snmpIfXTable, []uint32{11, index}, berEncodeUint(BER_COUNTER64, uint64(tx)))
+	}
+
+	// Every statistic of GetStats, indexed by the names of its group and counter
+	for group, values := range counters {
+		for name, value := range values {
+			index := append(snmpStringIndex(group), snmpStringIndex(name)...)
+			value32 := value
+			if value32 > 1<<31-1 {
+				value32 = 1<<31 - 1
+			} else if value32 < -1<<31 {
+				value32 = -1 << 31
+			}
+			value64 := uint64(0)
+			if value > 0 {
+				value64 = uint64(value)
+			}
+
+			add(snmpPonMib, append([]uint32{1, 1, 1}, index...), berEncode(BER_OCTET_STRING, []byte(group)))
+			add(snmpPonMib, append([]uint32{1, 1, 2}, index...), berEncode(BER_OCTET_STRING, []byte(name)))
+			add(snmpPonMib, append([]uint32{1, 1, 3}, index...), berEncodeInt(BER_INTEGER, value32))
+			add(snmpPonMib, append([]uint32{1, 1, 4}, index...), berEncodeUint(BER_COUNTER64, value64))
+		}
+	}
+
+	// The ONUs registered with an OLT, indexed by their port
+	for port, onu := range onus {
+		index := uint32(port)
+		add(snmpPonMib, []uint32{2, 1, 1, index}, berEncodeInt(BER_INTEGER, int64(port)))
+		add(snmpPonMib, []uint32{2, 1, 2, index}, berEncode(BER_OCTET_STRING, []byte(onu.Device.SerialNumber)))
+		add(snmpPonMib, []uint32{2, 1, 3, index}, berEncodeInt(BER_INTEGER, int64(onu.Device.PonPort)))
+		add(snmpPonMib, []uint32{2, 1, 4, index}, berEncodeUint(BER_GAUGE32, uint64(onu.Device.Distance)))
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return compareOids(objects[i].Oid, objects[j].Oid) < 0 })
+
+	return objects
+}
+
+func getPonSimDevice(device PonSimInterface) *PonSimDevice {
+	switch d := device.(type) {
+	case *PonSimOltDevice:
+		return &d.PonSimDevice
+	case *PonSimOnuDevice:
+		return &d.PonSimDevice
+	}
+	return nil
+}
+
+/*
+getObject returns the object with an OID, or noSuchObject
+*/
+func getObject(objects []snmpObject, oid []uint32) snmpObject {
+	i := sort.Search(len(objects), func(i int) bool { return compareOids(objects[i].Oid, oid) >= 0 })
+	if i < len(objects) && compareOids(objects[i].Oid, oid) == 0 {
+		return objects[i]
+	}
+	return snmpObject{Oid: oid, Value: berEncode(SNMP_NO_SUCH_OBJECT, nil)}
+}
+
+/*
+getNextObject returns the object following an OID, or endOfMibView
+*/
+func getNextObject(objects []snmpObject, oid []uint32) snmpObject {
+	i := sort.Search(len(objects), func(i int) bool { return compareOids(objects[i].Oid, oid) > 0 })
+	if i < len(objects) {
+		return objects[i]
+	}
+	return snmpObject{Oid: oid, Value: berEncode(SNMP_END_OF_MIB, nil)}
+}
+
+func compareOids(a []uint32, b []uint32) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+/*
+snmpStringIndex encodes a string as a table index, prefixed with its length
+*/
+func snmpStringIndex(s string) []uint32 {
+	index := []uint32{uint32(len(s))}
+	for _, c := range []byte(s) {
+		index = append(index, uint32(c))
+	}
+	return index
+}
+
+func parsePdu(data []byte) (*snmpPdu, error) {
+	if len(data) == 0 {
+		return nil, errors.New("missing SNMP PDU")
+	}
+	pdu := &snmpPdu{Type: data[0]}
+
+	body, _, err := berParse(data, pdu.Type)
+	if err != nil {
+		return nil, err
+	}
+	if pdu.RequestId, body, err = berParse(body, BER_INTEGER); err != nil {
+		return nil, err
+	}
+	for i := range pdu.Fields {
+		var field []byte
+		if field, body, err = berParse(body, BER_INTEGER); err != nil {
+			return nil, err
+		}
+		pdu.Fields[i] = int(berInt(field))
+	}
+
+	varbinds, _, err := berParse(body, BER_SEQUENCE)
+	if err != nil {
+		return nil, err
+	}
+	for len(varbinds) > 0 {
+		var varbind, name []byte
+		if varbind, varbinds, err = berParse(varbinds, BER_SEQUENCE); err != nil {
+			return nil, err
+		}
+		if name, _, err = berParse(varbind, BER_OID); err != nil {
+			return nil, err
+		}
+		oid, err := parseOid(name)
+		if err != nil {
+			return nil, err
+		}
+		pdu.Names = append(pdu.Names, oid)
+	}
+
+	return pdu, nil
+}
+
+/*
+berParse decodes a TLV of the expected type, returning its content and the data following it
+*/
+func berParse(data []byte, tag byte) ([]byte, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, errors.New("truncated BER value")
+	}
+	if data[0] != tag {
+		return nil, nil, fmt.Errorf("unexpected BER type %#x instead of %#x", data[0], tag)
+	}
+
+	length, offset := int(data[1]), 2
+	if length&0x80 != 0 {
+		size := length & 0x7f
+		if size == 0 || size > 3 || len(data) < 2+size {
+			return nil, nil, errors.New("invalid BER length")
+		}
+		length = 0
+		for _, b := range data[2 : 2+size] {
+			length = length<<8 | int(b)
+		}
+		offset += size
+	}
+	if len(data) < offset+length {
+		return nil, nil, errors.New("truncated BER value")
+	}
+
+	return data[offset : offset+length], data[offset+length:], nil
+}
+
+func berEncode(tag byte, content []byte) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteByte(tag)
+
+	switch length := len(content); {
+	case length < 0x80:
+		buffer.WriteByte(byte(length))
+	case length < 0x100:
+		buffer.Write([]byte{0x81, byte(length)})
+	default:
+		buffer.Write([]byte{0x82, byte(length >> 8), byte(length)})
+	}
+	buffer.Write(content)
+
+	return buffer.Bytes()
+}
+
+func berEncodeInt(tag byte, value int64) []byte {
+	var content []byte
+	for {
+		content = append([]byte{byte(value)}, content...)
+		if value >= -0x80 && value < 0x80 {
+			break
+		}
+		value >>= 8
+	}
+	return berEncode(tag, content)
+}
+
+/*
+berEncodeUint encodes an unsigned value, such as a counter, with a leading zero byte when its
+most significant bit is set
+*/
+func berEncodeUint(tag byte, value uint64) []byte {
+	content := []byte{byte(value)}
+	for value >>= 8; value != 0; value >>= 8 {
+		content = append([]byte{byte(value)}, content...)
+	}
+	if content[0]&0x80 != 0 {
+		content = append([]byte{0}, content...)
+	}
+	return berEncode(tag, content)
+}
+
+func berInt(content []byte) int64 {
+	var value int64
+	for i, b := range content {
+		if i == 0 && b&0x80 != 0 {
+			value = -1
+		}
+		value = value<<8 | int64(b)
+	}
+	return value
+}
+
+func berOid(oid []uint32) []byte {
+	if len(oid) < 2 {
+		return []byte{0}
+	}
+
+	content := []byte{byte(oid[0]*40 + oid[1])}
+	for _, arc := range oid[2:] {
+		encoded := []byte{byte(arc & 0x7f)}
+		for arc >>= 7; arc != 0; arc >>= 7 {
+			encoded = append([]byte{byte(arc&0x7f) | 0x80}, encoded...)
+		}
+		content = append(content, encoded...)
+	}
+	return content
+}
+
+func parseOid(content []byte) ([]uint32, error) {
+	if len(content) == 0 {
+		return nil, errors.New("empty OID")
+	}
+
+	oid := []uint32{uint32(content[0]) / 40, uint32(content[0]) % 40}
+	var arc uint32
+	for i, b := range content[1:] {
+		arc = arc<<7 | uint32(b&0x7f)
+		if b&0x80 == 0 {
+			oid = append(oid, arc)
+			arc = 0
+		} else if i == len(content)-2 {
+			return nil, errors.New("truncated OID")
+		}
+	}
+	return oid, nil
+}
@@ -0,0 +1,441 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/ponsim"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"net"
+	"sync"
+)
+
+/*
+PonSimSubscriberState tracks the progress of the IPv6 address acquisition of a subscriber
+*/
+type PonSimSubscriberState uint8
+
+const (
+	SUBSCRIBER_INIT PonSimSubscriberState = iota
+	SUBSCRIBER_SOLICITING_ROUTER
+	SUBSCRIBER_SLAAC_CONFIGURED
+	SUBSCRIBER_SOLICITING_DHCPV6
+	SUBSCRIBER_REQUESTING_DHCPV6
+	SUBSCRIBER_BOUND
+)
+
+var enum_ponsim_subscriber_states = []string{
+	"INIT",
+	"SOLICITING_ROUTER",
+	"SLAAC_CONFIGURED",
+	"SOLICITING_DHCPV6",
+	"REQUESTING_DHCPV6",
+	"BOUND",
+}
+
+func (s PonSimSubscriberState) String() string {
+	return enum_ponsim_subscriber_states[s]
+}
+
+const (
+	raFlagManaged        = 0x80
+	prefixFlagAutonomous = 0x40
+	dhcpv6ClientPort     = 546
+	dhcpv6ServerPort     = 547
+)
+
+var (
+	allRoutersAddress      = net.ParseIP("ff02::2")
+	allRoutersMac          = net.HardwareAddr{0x33, 0x33, 0x00, 0x00, 0x00, 0x02}
+	allDhcpv6AgentsAddress = net.ParseIP("ff02::1:2")
+	allDhcpv6AgentsMac     = net.HardwareAddr{0x33, 0x33, 0x00, 0x01, 0x00, 0x02}
+)
+
+/*
+PonSimSubscriber simulates the IPv6 stack of a subscriber host attached to a UNI port.
+
+The host solicits routers, configures a SLAAC address from the advertised prefixes and,
+when the router advertises managed configuration, acquires an address through DHCPv6.
+*/
+type PonSimSubscriber struct {
+	Mac           net.HardwareAddr      `json:"mac"`
+	Vlan          int                   `json:"vlan"`
+	State         PonSimSubscriberState `json:"state"`
+	LinkLocal     net.IP                `json:"link_local"`
+	SlaacAddress  net.IP                `json:"slaac_address"`
+	Dhcpv6Address net.IP                `json:"dhcpv6_address"`
+	Router        net.IP                `json:"router"`
+
+	mutex         sync.Mutex
+	transactionId []byte
+	inject        func(gopacket.Packet)
+}
+
+/*
+NewPonSimSubscriber instantiates a subscriber host which sends its frames through the inject function.
+A negative vlan sends untagged frames.
+*/
+func NewPonSimSubscriber(mac net.HardwareAddr, vlan int, inject func(gopacket.Packet)) *PonSimSubscriber {
+	return &PonSimSubscriber{
+		Mac:       mac,
+		Vlan:      vlan,
+		LinkLocal: eui64Address(net.ParseIP("fe80::"), mac),
+		inject:    inject,
+	}
+}
+
+/*
+Start begins the address acquisition by soliciting routers
+*/
+func (s *PonSimSubscriber) Start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.State = SUBSCRIBER_SOLICITING_ROUTER
+	s.SlaacAddress = nil
+	s.Dhcpv6Address = nil
+	s.Router = nil
+
+	solicitation := &layers.ICMPv6RouterSolicitation{
+		Options: layers.ICMPv6Options{
+			{Type: layers.ICMPv6OptSourceAddress, Data: []byte(s.Mac)},
+		},
+	}
+
+	s.send(allRoutersMac, allRoutersAddress, 255, s.icmpv6(layers.ICMPv6TypeRouterSolicitation), solicitation)
+}
+
+/*
+HandleFrame processes a frame delivered to the subscriber and reports whether it was consumed
+*/
+func (s *PonSimSubscriber) HandleFrame(frame gopacket.Packet) bool {
+	eth := common.GetEthernetLayer(frame)
+	if !bytes.Equal(eth.DstMAC, s.Mac) && eth.DstMAC[0]&0x01 == 0 {
+		return false
+	}
+
+	ipv6Layer := frame.Layer(layers.LayerTypeIPv6)
+	if ipv6Layer == nil {
+		return false
+	}
+	ipv6 := ipv6Layer.(*layers.IPv6)
+
+	if ra := frame.Layer(layers.LayerTypeICMPv6RouterAdvertisement); ra != nil {
+		s.handleRouterAdvertisement(ipv6, ra.(*layers.ICMPv6RouterAdvertisement))
+		return true
+	}
+	if dhcpv6 := frame.Layer(layers.LayerTypeDHCPv6); dhcpv6 != nil {
+		return s.handleDhcpv6(dhcpv6.(*layers.DHCPv6))
+	}
+
+	return false
+}
+
+func (s *PonSimSubscriber) handleRouterAdvertisement(ipv6 *layers.IPv6, ra *layers.ICMPv6RouterAdvertisement) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.State != SUBSCRIBER_SOLICITING_ROUTER {
+		return
+	}
+
+	s.Router = ipv6.SrcIP
+
+	for _, option := range ra.Options {
+		// Prefix information: length, flags, valid and preferred lifetimes, reserved, prefix
+		if option.Type != layers.ICMPv6OptPrefixInfo || len(option.Data) < 30 {
+			continue
+		}
+		if option.Data[0] == 64 && option.Data[1]&prefixFlagAutonomous != 0 {
+			s.SlaacAddress = eui64Address(net.IP(option.Data[14:30]), s.Mac)
+			s.State = SUBSCRIBER_SLAAC_CONFIGURED
+		}
+	}
+
+	common.Logger().WithFields(logrus.Fields{
+		"subscriber": s.Mac.String(),
+		"router":     s.Router,
+		"address":    s.SlaacAddress,
+		"managed":    ra.Flags&raFlagManaged != 0,
+	}).Info("Received router advertisement")
+
+	if ra.Flags&raFlagManaged != 0 {
+		s.State = SUBSCRIBER_SOLICITING_DHCPV6
+		s.transactionId = []byte{byte(rand.Intn(256)), byte(rand.Intn(256)), byte(rand.Intn(256))}
+		s.sendDhcpv6(layers.DHCPv6MsgTypeSolicit, nil, nil)
+	}
+}
+
+func (s *PonSimSubscriber) handleDhcpv6(dhcpv6 *layers.DHCPv6) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !bytes.Equal(dhcpv6.TransactionID, s.transactionId) {
+		return false
+	}
+
+	var serverId, iana []byte
+	for _, option := range dhcpv6.Options {
+		switch option.Code {
+		case layers.DHCPv6OptServerID:
+			serverId = option.Data
+		case layers.DHCPv6OptIANA:
+			iana = option.Data
+		}
+	}
+
+	switch {
+	case dhcpv6.MsgType == layers.DHCPv6MsgTypeAdverstise && s.State == SUBSCRIBER_SOLICITING_DHCPV6:
+		s.State = SUBSCRIBER_REQUESTING_DHCPV6
+		s.sendDhcpv6(layers.DHCPv6MsgTypeRequest, serverId, iana)
+
+	case dhcpv6.MsgType == layers.DHCPv6MsgTypeReply && s.State == SUBSCRIBER_REQUESTING_DHCPV6:
+		if address := ianaAddress(iana); address != nil {
+			s.Dhcpv6Address = address
+			s.State = SUBSCRIBER_BOUND
+
+			common.Logger().WithFields(logrus.Fields{
+				"subscriber": s.Mac.String(),
+				"address":    address,
+			}).Info("Acquired DHCPv6 address")
+		}
+	}
+
+	return true
+}
+
+/*
+sendDhcpv6 sends a DHCPv6 message requesting a non-temporary address
+*/
+func (s *PonSimSubscriber) sendDhcpv6(msgType layers.DHCPv6MsgType, serverId []byte, iana []byte) {
+	clientId := &layers.DHCPv6DUID{
+		Type:             layers.DHCPv6DUIDTypeLL,
+		HardwareType:     []byte{0, 1},
+		LinkLayerAddress: s.Mac,
+	}
+
+	if iana == nil {
+		// IAID derived from the hardware address, T1 and T2 left to the server
+		iana = make([]byte, 12)
+		copy(iana, s.Mac[2:])
+	}
+
+	dhcpv6 := &layers.DHCPv6{
+		MsgType:       msgType,
+		TransactionID: s.transactionId,
+		Options: layers.DHCPv6Options{
+			layers.NewDHCPv6Option(layers.DHCPv6OptClientID, clientId.Encode()),
+			layers.NewDHCPv6Option(layers.DHCPv6OptElapsedTime, []byte{0, 0}),
+			layers.NewDHCPv6Option(layers.DHCPv6OptIANA, iana),
+		},
+	}
+	if serverId != nil {
+		dhcpv6.Options = append(dhcpv6.Options, layers.NewDHCPv6Option(layers.DHCPv6OptServerID, serverId))
+	}
+
+	udp := &layers.UDP{SrcPort: dhcpv6ClientPort, DstPort: dhcpv6ServerPort}
+
+	s.send(allDhcpv6AgentsMac, allDhcpv6AgentsAddress, 1, udp, dhcpv6)
+}
+
+func (s *PonSimSubscriber) icmpv6(typ uint8) *layers.ICMPv6 {
+	return &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(typ, 0)}
+}
+
+/*
+send serializes a packet from the link-local address of the subscriber and injects it
+*/
+func (s *PonSimSubscriber) send(
+	dstMac net.HardwareAddr,
+	dstIp net.IP,
+	hopLimit uint8,
+	upper ...gopacket.SerializableLayer,
+) {
+	ipv6 := &layers.IPv6{
+		Version:  6,
+		HopLimit: hopLimit,
+		SrcIP:    s.LinkLocal,
+		DstIP:    dstIp,
+	}
+
+	switch l := upper[0].(type) {
+	case *layers.ICMPv6:
+		ipv6.NextHeader = layers.IPProtocolICMPv6
+		l.SetNetworkLayerForChecksum(ipv6)
+	case *layers.UDP:
+		ipv6.NextHeader = layers.IPProtocolUDP
+		l.SetNetworkLayerForChecksum(ipv6)
+	}
+
+	eth := &layers.Ethernet{SrcMAC: s.Mac, DstMAC: dstMac, EthernetType: layers.EthernetTypeIPv6}
+	frameLayers := []gopacket.SerializableLayer{eth}
+	if s.Vlan >= 0 {
+		eth.EthernetType = layers.EthernetTypeDot1Q
+		frameLayers = append(frameLayers, &layers.Dot1Q{Type: layers.EthernetTypeIPv6, VLANIdentifier: uint16(s.Vlan)})
+	}
+	frameLayers = append(frameLayers, ipv6)
+	frameLayers = append(frameLayers, upper...)
+
+	buffer := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(
+		buffer,
+		gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		frameLayers...,
+	); err != nil {
+		common.Logger().WithFields(logrus.Fields{
+			"subscriber": s.Mac.String(),
+			"error":      err.Error(),
+		}).Error("Problem serializing subscriber frame")
+		return
+	}
+
+	// Frames are injected outside of the caller so that a response delivered synchronously
+	// by the device does not contend for the subscriber lock
+	go s.inject(common.NewFrame(buffer.Bytes()))
+}
+
+/*
+MakeProto reports the addresses acquired by the subscriber
+*/
+func (s *PonSimSubscriber) MakeProto() *ponsim.Ipv6Subscriber {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	toString := func(ip net.IP) string {
+		if ip == nil {
+			return ""
+		}
+		return ip.String()
+	}
+
+	return &ponsim.Ipv6Subscriber{
+		Mac:           s.Mac.String(),
+		Vlan:          int32(s.Vlan),
+		State:         s.State.String(),
+		LinkLocal:     toString(s.LinkLocal),
+		SlaacAddress:  toString(s.SlaacAddress),
+		Dhcpv6Address: toString(s.Dhcpv6Address),
+		Router:        toString(s.Router),
+	}
+}
+
+/*
+eui64Address builds an address from a /64 prefix and the modified EUI-64 of a hardware address
+*/
+func eui64Address(prefix net.IP, mac net.HardwareAddr) net.IP {
+	address := make(net.IP, net.IPv6len)
+	copy(address, prefix.To16()[:8])
+	address[8] = mac[0] ^ 0x02
+	address[9] = mac[1]
+	address[10] = mac[2]
+	address[11] = 0xff
+	address[12] = 0xfe
+	address[13] = mac[3]
+	address[14] = mac[4]
+	address[15] = mac[5]
+
+	return address
+}
+
+/*
+ianaAddress extracts the first address from the content of an IA_NA option
+*/
+func ianaAddress(iana []byte) net.IP {
+	// IAID, T1 and T2 precede the options of the IA_NA
+	if len(iana) < 12 {
+		return nil
+	}
+	for data := iana[12:]; len(data) >= 4; {
+		code := binary.BigEndian.Uint16(data[0:2])
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if len(data) < 4+length {
+			break
+		}
+		if layers.DHCPv6Opt(code) == layers.DHCPv6OptIAAddr && length >= net.IPv6len {
+			return net.IP(append([]byte(nil), data[4:4+net.IPv6len]...))
+		}
+		data = data[4+length:]
+	}
+
+	return nil
+}
+
+/*
+PonSimSubscribers holds the subscriber hosts simulated behind the UNI port of an ONU
+*/
+type PonSimSubscribers struct {
+	mutex       sync.RWMutex
+	subscribers map[string]*PonSimSubscriber
+}
+
+/*
+NewPonSimSubscribers instantiates an empty set of subscriber hosts
+*/
+func NewPonSimSubscribers() *PonSimSubscribers {
+	return &PonSimSubscribers{subscribers: make(map[string]*PonSimSubscriber)}
+}
+
+/*
+Add registers a subscriber host, replacing any host with the same hardware address
+*/
+func (s *PonSimSubscribers) Add(subscriber *PonSimSubscriber) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.subscribers[subscriber.Mac.String()] = subscriber
+}
+
+/*
+List returns the registered subscriber hosts
+*/
+func (s *PonSimSubscribers) List() []*PonSimSubscriber {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var subscribers []*PonSimSubscriber
+	for _, subscriber := range s.subscribers {
+		subscribers = append(subscribers, subscriber)
+	}
+
+	return subscribers
+}
+
+/*
+Clear unregisters every subscriber host and returns the number of hosts removed
+*/
+func (s *PonSimSubscribers) Clear() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	count := len(s.subscribers)
+	s.subscribers = make(map[string]*PonSimSubscriber)
+
+	return count
+}
+
+/*
+Dispatch delivers a frame to each subscriber host
+*/
+func (s *PonSimSubscribers) Dispatch(frame gopacket.Packet) {
+	for _, subscriber := range s.List() {
+		subscriber.HandleFrame(frame)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/sirupsen/logrus"
+	"sync/atomic"
+)
+
+// Behaviours of the flows for the frames matching none of them
+const (
+	TABLE_MISS_DROP       = "drop"
+	TABLE_MISS_FLOOD      = "flood"
+	TABLE_MISS_CONTROLLER = "controller"
+)
+
+var TABLE_MISS_MODES = []string{TABLE_MISS_DROP, TABLE_MISS_FLOOD, TABLE_MISS_CONTROLLER}
+
+/*
+PonSimTableMiss holds the table-miss behaviour of a device, which changes while frames are forwarded
+*/
+type PonSimTableMiss struct {
+	mode   int32
+	misses uint64
+}
+
+/*
+NewPonSimTableMiss validates a table-miss behaviour, frames being dropped if none is provided
+*/
+func NewPonSimTableMiss(mode string) (*PonSimTableMiss, error) {
+	m := &PonSimTableMiss{}
+	if err := m.Set(mode); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+/*
+Copy returns the table-miss behaviour of another device, with its own counter
+*/
+func (m *PonSimTableMiss) Copy() *PonSimTableMiss {
+	if m == nil {
+		return nil
+	}
+
+	return &PonSimTableMiss{mode: atomic.LoadInt32(&m.mode)}
+}
+
+/*
+Set changes the table-miss behaviour
+*/
+func (m *PonSimTableMiss) Set(mode string) error {
+	i, err := parseEnum(TABLE_MISS_MODES, withDefault(mode, TABLE_MISS_DROP))
+	if err != nil {
+		return fmt.Errorf("unknown table miss behaviour: %s", mode)
+	}
+	atomic.StoreInt32(&m.mode, int32(i))
+
+	return nil
+}
+
+/*
+Get returns the table-miss behaviour along with the number of frames which matched no flow
+*/
+func (m *PonSimTableMiss) Get() (string, uint64) {
+	if m == nil {
+		return TABLE_MISS_DROP, 0
+	}
+
+	return TABLE_MISS_MODES[atomic.LoadInt32(&m.mode)], atomic.LoadUint64(&m.misses)
+}
+
+/*
+miss counts a frame which matched no flow and returns the behaviour to apply to it
+*/
+func (m *PonSimTableMiss) miss() string {
+	if m == nil {
+		return TABLE_MISS_DROP
+	}
+	atomic.AddUint64(&m.misses, 1)
+
+	return TABLE_MISS_MODES[atomic.LoadInt32(&m.mode)]
+}
+
+/*
+missFrame applies the table-miss behaviour of the device to a frame which matched no flow.
+Flooded frames are output to every port but the ingress port, while frames sent to the
+controller become packet-ins without a flow.  Nil is returned when the frame is dropped.
+*/
+func (o *PonSimDevice) missFrame(port int, frame gopacket.Packet) []ponSimOutput {
+	switch o.TableMiss.miss() {
+	case TABLE_MISS_FLOOD:
+		outputs := []ponSimOutput{}
+		for _, egressPort := range o.links.linkedPorts() {
+			if egressPort != port && egressPort != int(CONTROLLER_PORT) {
+				outputs = append(outputs, ponSimOutput{Port: uint32(egressPort), Frame: frame})
+			}
+		}
+
+		if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+			entry.WithFields(logrus.Fields{
+				"device":  o,
+				"port":    port,
+				"outputs": len(outputs),
+			}).Debug("Flooding frame which matched no flow")
+		}
+
+		return outputs
+
+	case TABLE_MISS_CONTROLLER:
+		if entry := forwardingLogger.ForFrame(logrus.DebugLevel); entry != nil {
+			entry.WithFields(logrus.Fields{
+				"device": o,
+				"port":   port,
+			}).Debug("Sending frame which matched no flow to the controller")
+		}
+
+		return []ponSimOutput{{Port: CONTROLLER_PORT, Frame: frame}}
+	}
+
+	return nil
+}
@@ -0,0 +1,208 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+PonSimThreshold is the value of a counter of a port, accumulated during a PM interval, which
+raises a threshold crossing alert (TCA) when it is reached
+*/
+type PonSimThreshold struct {
+	Port    string `json:"port"`
+	Counter string `json:"counter"`
+	Value   int64  `json:"value"`
+}
+
+func (t PonSimThreshold) key() string {
+	return t.Port + "." + t.Counter
+}
+
+/*
+ParsePmThresholds parses a comma separated list of thresholds in the format
+port_name:counter:value, e.g. nni:rx_dropped_pkts:100,fec128:us_corrected_codewords:1000
+*/
+func ParsePmThresholds(spec string) ([]PonSimThreshold, error) {
+	var thresholds []PonSimThreshold
+
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		fields := strings.Split(entry, ":")
+		if len(fields) != 3 || fields[0] == "" || fields[1] == "" {
+			return nil, fmt.Errorf("invalid threshold specification: %s", entry)
+		}
+
+		value, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("invalid threshold value: %s", fields[2])
+		}
+
+		thresholds = append(thresholds, PonSimThreshold{Port: fields[0], Counter: fields[1], Value: value})
+	}
+
+	return thresholds, nil
+}
+
+/*
+pmCrossing is a change of state of a TCA to notify once the PM lock is released
+*/
+type pmCrossing struct {
+	threshold PonSimThreshold
+	value     int64
+	crossed   bool
+}
+
+/*
+SetThreshold configures the threshold of a counter of a port; a threshold without value
+removes it, clearing its TCA if it was raised
+*/
+func (p *PonSimPm) SetThreshold(threshold PonSimThreshold) {
+	p.mutex.Lock()
+
+	if p.thresholds == nil {
+		p.thresholds = make(map[string]PonSimThreshold)
+		p.crossed = make(map[string]bool)
+	}
+
+	var crossings []pmCrossing
+	key := threshold.key()
+	if threshold.Value <= 0 {
+		if p.crossed[key] {
+			crossings = append(crossings, pmCrossing{threshold: p.thresholds[key]})
+		}
+		delete(p.thresholds, key)
+		delete(p.crossed, key)
+	} else {
+		p.thresholds[key] = threshold
+	}
+
+	p.mutex.Unlock()
+
+	p.notifyCrossings(crossings)
+}
+
+/*
+GetThresholds returns the configured thresholds, sorted by port and counter
+*/
+func (p *PonSimPm) GetThresholds() []PonSimThreshold {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	thresholds := make([]PonSimThreshold, 0, len(p.thresholds))
+	for _, threshold := range p.thresholds {
+		thresholds = append(thresholds, threshold)
+	}
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i].key() < thresholds[j].key() })
+
+	return thresholds
+}
+
+/*
+OnThresholdCrossing registers the function notified when a TCA is raised or cleared
+*/
+func (p *PonSimPm) OnThresholdCrossing(handler func(threshold PonSimThreshold, value int64, crossed bool)) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.onCrossing = handler
+}
+
+/*
+evaluate compares the counters accumulated during the current interval with the thresholds.
+A TCA is raised when a counter reaches its threshold and cleared once it falls below it,
+which happens at the beginning of the next interval for counters of events.
+*/
+func (p *PonSimPm) evaluate(counters map[string]map[string]int64) []pmCrossing {
+	var crossings []pmCrossing
+
+	for key, threshold := range p.thresholds {
+		value, ok := counters[threshold.Port][threshold.Counter]
+		if crossed := ok && value >= threshold.Value; crossed != p.crossed[key] {
+			p.crossed[key] = crossed
+			crossings = append(crossings, pmCrossing{threshold: threshold, value: value, crossed: crossed})
+		}
+	}
+
+	return crossings
+}
+
+/*
+notifyCrossings reports the changes of state of the TCAs
+*/
+func (p *PonSimPm) notifyCrossings(crossings []pmCrossing) {
+	p.mutex.Lock()
+	handler := p.onCrossing
+	p.mutex.Unlock()
+
+	for _, crossing := range crossings {
+		common.Logger().WithFields(logrus.Fields{
+			"port":      crossing.threshold.Port,
+			"counter":   crossing.threshold.Counter,
+			"threshold": crossing.threshold.Value,
+			"value":     crossing.value,
+			"crossed":   crossing.crossed,
+		}).Info("Threshold crossing alert")
+
+		if handler != nil {
+			handler(crossing.threshold, crossing.value, crossing.crossed)
+		}
+	}
+}
+
+/*
+reportThresholdCrossing raises a TCA as an alarm of the OLT, or clears it
+*/
+func (o *PonSimOltDevice) reportThresholdCrossing() func(PonSimThreshold, int64, bool) {
+	var mutex sync.Mutex
+	raised := make(map[string]*Alarm)
+
+	return func(threshold PonSimThreshold, value int64, crossed bool) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if !crossed {
+			if alarm, ok := raised[threshold.key()]; ok {
+				delete(raised, threshold.key())
+				o.alarms.clearAlarm(alarm)
+			}
+			return
+		}
+
+		now := o.Clock.Now()
+		alarm := &Alarm{
+			Severity:    int(voltha.AlarmEventSeverity_WARNING),
+			Type:        int(voltha.AlarmEventType_COMMUNICATION),
+			Category:    int(voltha.AlarmEventCategory_PON),
+			TimeStamp:   now.UTC().Second(),
+			Description: fmt.Sprintf("TCA %s reached %d (threshold %d)", threshold.key(), value, threshold.Value),
+			raisedAt:    now,
+		}
+		raised[threshold.key()] = alarm
+		o.alarms.raiseAlarm(alarm)
+	}
+}
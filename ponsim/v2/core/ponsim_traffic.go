@@ -0,0 +1,294 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/ponsim"
+	"github.com/sirupsen/logrus"
+	"net"
+	"strings"
+	"time"
+)
+
+// Transport protocols of the frames originated by the traffic generator
+const (
+	TRAFFIC_UDP = "udp"
+	TRAFFIC_TCP = "tcp"
+)
+
+var TRAFFIC_PROTOCOLS = []string{
+	TRAFFIC_UDP,
+	TRAFFIC_TCP,
+}
+
+const (
+	DEFAULT_TRAFFIC_RATE       = 100
+	DEFAULT_TRAFFIC_FRAME_SIZE = 64
+	DEFAULT_TRAFFIC_SRC_MAC    = "02:00:00:00:00:01"
+	DEFAULT_TRAFFIC_DST_MAC    = "02:00:00:00:00:02"
+	DEFAULT_TRAFFIC_SRC_IP     = "10.0.0.1"
+	DEFAULT_TRAFFIC_DST_IP     = "10.0.0.2"
+	DEFAULT_TRAFFIC_SRC_PORT   = 1024
+	DEFAULT_TRAFFIC_DST_PORT   = 5001
+
+	// Largest frame originated, i.e. a jumbo frame
+	MAX_TRAFFIC_FRAME_SIZE = 9216
+
+	// Interval at which the frames due at the rate of the generator are sent
+	TRAFFIC_TICK = 10 * time.Millisecond
+)
+
+/*
+PonSimTraffic originates synthetic frames at a constant rate, all built from the same
+Ethernet, VLAN, IP and UDP or TCP headers and padded to the frame size
+*/
+type PonSimTraffic struct {
+	Rate  int `json:"rate"`
+	Count int `json:"count"`
+
+	frame []byte
+}
+
+/*
+NewPonSimTraffic builds the frame originated by the traffic generator from a request, using
+the default rate, frame size, addresses and ports for those that are not set.  The VLANs are
+listed from the outer one, and IPv6 frames are originated when the addresses are IPv6.
+*/
+func NewPonSimTraffic(request *ponsim.TrafficRequest) (*PonSimTraffic, error) {
+	t := &PonSimTraffic{Rate: int(request.Rate), Count: int(request.Count)}
+	if t.Rate == 0 {
+		t.Rate = DEFAULT_TRAFFIC_RATE
+	}
+
+	size := int(request.FrameSize)
+	if size == 0 {
+		size = DEFAULT_TRAFFIC_FRAME_SIZE
+	} else if size > MAX_TRAFFIC_FRAME_SIZE {
+		return nil, fmt.Errorf("invalid frame size: %d", size)
+	}
+
+	srcMac, err := net.ParseMAC(withDefault(request.SrcMac, DEFAULT_TRAFFIC_SRC_MAC))
+	if err != nil {
+		return nil, fmt.Errorf("invalid source MAC address: %s", request.SrcMac)
+	}
+	dstMac, err := net.ParseMAC(withDefault(request.DstMac, DEFAULT_TRAFFIC_DST_MAC))
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination MAC address: %s", request.DstMac)
+	}
+
+	srcIp := net.ParseIP(withDefault(request.SrcIp, DEFAULT_TRAFFIC_SRC_IP))
+	if srcIp == nil {
+		return nil, fmt.Errorf("invalid source IP address: %s", request.SrcIp)
+	}
+	dstIp := net.ParseIP(withDefault(request.DstIp, DEFAULT_TRAFFIC_DST_IP))
+	if dstIp == nil || (dstIp.To4() == nil) != (srcIp.To4() == nil) {
+		return nil, fmt.Errorf("invalid destination IP address: %s", request.DstIp)
+	}
+
+	i, err := parseEnum(TRAFFIC_PROTOCOLS, withDefault(request.Protocol, TRAFFIC_UDP))
+	if err != nil {
+		return nil, fmt.Errorf("unknown protocol: %s", request.Protocol)
+	}
+	protocol := TRAFFIC_PROTOCOLS[i]
+
+	for _, vlan := range request.Vlans {
+		if vlan > 4094 {
+			return nil, fmt.Errorf("invalid VLAN: %d", vlan)
+		}
+	}
+	if request.Pcp > 7 {
+		return nil, fmt.Errorf("invalid PCP: %d", request.Pcp)
+	}
+
+	srcPort := uint16(request.SrcPort)
+	if srcPort == 0 {
+		srcPort = DEFAULT_TRAFFIC_SRC_PORT
+	}
+	dstPort := uint16(request.DstPort)
+	if dstPort == 0 {
+		dstPort = DEFAULT_TRAFFIC_DST_PORT
+	}
+
+	// Ethernet and VLAN headers
+	etherType := layers.EthernetTypeIPv4
+	if srcIp.To4() == nil {
+		etherType = layers.EthernetTypeIPv6
+	}
+	eth := &layers.Ethernet{SrcMAC: srcMac, DstMAC: dstMac, EthernetType: etherType}
+	frameLayers := []gopacket.SerializableLayer{eth}
+	for i, vlan := range request.Vlans {
+		if i == 0 {
+			eth.EthernetType = layers.EthernetTypeDot1Q
+		} else {
+			frameLayers[len(frameLayers)-1].(*layers.Dot1Q).Type = layers.EthernetTypeDot1Q
+		}
+		frameLayers = append(frameLayers, &layers.Dot1Q{
+			Priority:       uint8(request.Pcp),
+			VLANIdentifier: uint16(vlan),
+			Type:           etherType,
+		})
+	}
+
+	// IP header
+	var network gopacket.NetworkLayer
+	ipProtocol := layers.IPProtocolUDP
+	if protocol == TRAFFIC_TCP {
+		ipProtocol = layers.IPProtocolTCP
+	}
+	if etherType == layers.EthernetTypeIPv4 {
+		network = &layers.IPv4{Version: ipVersion, TTL: ttl, Protocol: ipProtocol, SrcIP: srcIp, DstIP: dstIp}
+	} else {
+		network = &layers.IPv6{Version: 6, HopLimit: ttl, NextHeader: ipProtocol, SrcIP: srcIp, DstIP: dstIp}
+	}
+	frameLayers = append(frameLayers, network.(gopacket.SerializableLayer))
+
+	// Transport header
+	switch protocol {
+	case TRAFFIC_UDP:
+		udp := &layers.UDP{SrcPort: layers.UDPPort(srcPort), DstPort: layers.UDPPort(dstPort)}
+		udp.SetNetworkLayerForChecksum(network)
+		frameLayers = append(frameLayers, udp)
+	case TRAFFIC_TCP:
+		tcp := &layers.TCP{
+			SrcPort: layers.TCPPort(srcPort),
+			DstPort: layers.TCPPort(dstPort),
+			ACK:     true,
+			Window:  65535,
+		}
+		tcp.SetNetworkLayerForChecksum(network)
+		frameLayers = append(frameLayers, tcp)
+	}
+
+	// A payload pads the headers to the frame size.  The headers are measured behind a payload
+	// long enough for the ethernet layer not to pad the frame itself.
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buffer, options, append(frameLayers, gopacket.Payload(make([]byte, size)))...); err != nil {
+		return nil, err
+	}
+	padding := size - (len(buffer.Bytes()) - size)
+	if padding < 0 {
+		padding = 0
+	}
+	if err := gopacket.SerializeLayers(buffer, options, append(frameLayers, gopacket.Payload(make([]byte, padding)))...); err != nil {
+		return nil, err
+	}
+	t.frame = buffer.Bytes()
+
+	return t, nil
+}
+
+/*
+withDefault returns a value, or its default when it is not set
+*/
+func withDefault(value string, defaultValue string) string {
+	if value = strings.TrimSpace(value); value == "" {
+		return defaultValue
+	}
+
+	return value
+}
+
+/*
+FrameSize returns the size of the frames originated
+*/
+func (t *PonSimTraffic) FrameSize() int {
+	return len(t.frame)
+}
+
+/*
+Run sends the frames through the send function at the rate of the generator, until the count
+of frames is reached or the job is cancelled.  The frames which cannot be sent, e.g. when the
+port is down, are counted as errors.
+*/
+func (t *PonSimTraffic) Run(job *PonSimJob, send func(gopacket.Packet) error) error {
+	report := &ponsim.TrafficReport{}
+	ticker := time.NewTicker(TRAFFIC_TICK)
+	defer ticker.Stop()
+
+	start := time.Now()
+	setProgress := func(now time.Time) {
+		elapsed := now.Sub(start).Seconds()
+		result := *report
+		if elapsed > 0 {
+			result.Rate = float64(report.Frames) / elapsed
+		}
+		job.SetResult(&result)
+
+		progress := float32(0)
+		if t.Count > 0 {
+			progress = float32(report.Frames*100) / float32(t.Count)
+		}
+		job.SetProgress(progress, fmt.Sprintf("%d frames sent", report.Frames))
+	}
+
+	for {
+		select {
+		case <-job.Context().Done():
+			setProgress(time.Now())
+			return nil
+
+		case now := <-ticker.C:
+			due := uint64(now.Sub(start).Seconds() * float64(t.Rate))
+			if t.Count > 0 && due > uint64(t.Count) {
+				due = uint64(t.Count)
+			}
+
+			for report.Frames < due {
+				// The frames share the same content, which is never modified once decoded
+				if err := send(common.NewFrame(t.frame)); err != nil {
+					report.Errors++
+				} else {
+					report.Bytes += uint64(len(t.frame))
+				}
+				report.Frames++
+			}
+			setProgress(now)
+
+			if t.Count > 0 && report.Frames >= uint64(t.Count) {
+				common.Logger().WithFields(logrus.Fields{
+					"job":    job.Id,
+					"frames": report.Frames,
+					"errors": report.Errors,
+				}).Info("Traffic generator completed")
+				return nil
+			}
+		}
+	}
+}
+
+/*
+StartTraffic originates the synthetic frames of the traffic generator on the NNI of the OLT, or
+on the UNI of an ONU, as a job
+*/
+func (o *PonSimDevice) StartTraffic(traffic *PonSimTraffic) *PonSimJob {
+	common.Logger().WithFields(logrus.Fields{
+		"device":    o,
+		"rate":      traffic.Rate,
+		"count":     traffic.Count,
+		"frameSize": traffic.FrameSize(),
+	}).Info("Starting traffic generator")
+
+	return o.Jobs.Start("traffic", func(job *PonSimJob) error {
+		return traffic.Run(job, func(frame gopacket.Packet) error {
+			return o.Forward(job.Context(), 2, frame)
+		})
+	})
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"encoding/binary"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/opencord/voltha/ponsim/v2/common"
+)
+
+// VLAN tags are rewritten on the raw frame so that the outer TPID, PCP and DEI of stacked tags
+// survive, which is not the case when the frame is rebuilt from decoded layers
+const (
+	VLAN_TAG_OFFSET = 12
+	VLAN_TAG_LENGTH = 4
+	VLAN_VID_MASK   = 0x0fff
+	VLAN_PCP_MASK   = 0xe000
+	VLAN_PCP_SHIFT  = 13
+)
+
+/*
+isVlanTpid reports whether an ethertype identifies a VLAN tag
+*/
+func isVlanTpid(etherType uint16) bool {
+	return etherType == uint16(layers.EthernetTypeDot1Q) || etherType == uint16(layers.EthernetTypeQinQ)
+}
+
+/*
+outerVlanTci returns the tag control information of the outermost VLAN tag of a frame
+*/
+func outerVlanTci(data []byte) (uint16, bool) {
+	if len(data) < VLAN_TAG_OFFSET+VLAN_TAG_LENGTH+2 ||
+		!isVlanTpid(binary.BigEndian.Uint16(data[VLAN_TAG_OFFSET:])) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(data[VLAN_TAG_OFFSET+2:]), true
+}
+
+/*
+pushVlanTag inserts a new outermost VLAN tag with the provided TPID. As per OpenFlow, the VID and
+PCP of the new tag are copied from the previous outermost tag, if any, and are zero otherwise.
+Nil is returned if the TPID is not a VLAN ethertype or the frame has no ethernet header.
+*/
+func pushVlanTag(frame gopacket.Packet, tpid uint16) gopacket.Packet {
+	data := frame.Data()
+	if !isVlanTpid(tpid) || len(data) < VLAN_TAG_OFFSET+2 {
+		return nil
+	}
+	tci, _ := outerVlanTci(data)
+
+	tagged := make([]byte, len(data)+VLAN_TAG_LENGTH)
+	copy(tagged, data[:VLAN_TAG_OFFSET])
+	binary.BigEndian.PutUint16(tagged[VLAN_TAG_OFFSET:], tpid)
+	binary.BigEndian.PutUint16(tagged[VLAN_TAG_OFFSET+2:], tci)
+	copy(tagged[VLAN_TAG_OFFSET+VLAN_TAG_LENGTH:], data[VLAN_TAG_OFFSET:])
+
+	return common.NewFrame(tagged)
+}
+
+/*
+popVlanTag removes the outermost VLAN tag, exposing the inner tag if the frame is double tagged.
+Nil is returned if the frame is untagged.
+*/
+func popVlanTag(frame gopacket.Packet) gopacket.Packet {
+	data := frame.Data()
+	if _, ok := outerVlanTci(data); !ok {
+		return nil
+	}
+
+	untagged := make([]byte, len(data)-VLAN_TAG_LENGTH)
+	copy(untagged, data[:VLAN_TAG_OFFSET])
+	copy(untagged[VLAN_TAG_OFFSET:], data[VLAN_TAG_OFFSET+VLAN_TAG_LENGTH:])
+
+	return common.NewFrame(untagged)
+}
+
+/*
+setVlanTci replaces the masked bits of the tag control information of the outermost VLAN tag,
+leaving its TPID, the other bits and any inner tag untouched. Nil is returned if the frame is untagged.
+*/
+func setVlanTci(frame gopacket.Packet, mask uint16, value uint16) gopacket.Packet {
+	data := frame.Data()
+	tci, ok := outerVlanTci(data)
+	if !ok {
+		return nil
+	}
+
+	rewritten := make([]byte, len(data))
+	copy(rewritten, data)
+	binary.BigEndian.PutUint16(rewritten[VLAN_TAG_OFFSET+2:], tci&^mask|value&mask)
+
+	return common.NewFrame(rewritten)
+}
+
+/*
+setVlanVid sets the VID of the outermost VLAN tag
+*/
+func setVlanVid(frame gopacket.Packet, vid uint16) gopacket.Packet {
+	return setVlanTci(frame, VLAN_VID_MASK, vid)
+}
+
+/*
+setVlanPcp sets the priority code point of the outermost VLAN tag
+*/
+func setVlanPcp(frame gopacket.Packet, pcp uint8) gopacket.Packet {
+	return setVlanTci(frame, VLAN_PCP_MASK, uint16(pcp)<<VLAN_PCP_SHIFT)
+}
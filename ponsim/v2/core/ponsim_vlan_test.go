@@ -0,0 +1,182 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/opencord/voltha/protos/go/openflow_13"
+	"testing"
+)
+
+type testVlanTag struct {
+	Tpid uint16
+	Pcp  uint8
+	Vid  uint16
+}
+
+func vlanTags(frame gopacket.Packet) []testVlanTag {
+	var tags []testVlanTag
+	data := frame.Data()
+	for offset := VLAN_TAG_OFFSET; offset+VLAN_TAG_LENGTH <= len(data); offset += VLAN_TAG_LENGTH {
+		tpid := binary.BigEndian.Uint16(data[offset:])
+		if !isVlanTpid(tpid) {
+			break
+		}
+		tci := binary.BigEndian.Uint16(data[offset+2:])
+		tags = append(tags, testVlanTag{Tpid: tpid, Pcp: uint8(tci >> VLAN_PCP_SHIFT), Vid: tci & VLAN_VID_MASK})
+	}
+	return tags
+}
+
+func applyVlanActions(t *testing.T, frame gopacket.Packet, actions ...*openflow_13.OfpAction) gopacket.Packet {
+	device := &PonSimDevice{Name: "test"}
+	device.InstallFlows(context.Background(), []*openflow_13.OfpFlowStats{
+		newFlow(1000, matchFields(matchInPort(1)), append(actions, actionOutput(2))...),
+	})
+
+	outputs := device.processFrame(context.Background(), 1, frame)
+	if len(outputs) != 1 || outputs[0].Port != 2 {
+		t.Fatal("The frame should have been output on port 2", outputs)
+	}
+	return outputs[0].Frame
+}
+
+func assertVlanTags(t *testing.T, frame gopacket.Packet, expected ...testVlanTag) {
+	tags := vlanTags(frame)
+	if len(tags) != len(expected) {
+		t.Fatalf("Expected %d tags, got %+v", len(expected), tags)
+	}
+	for i := range expected {
+		if tags[i] != expected[i] {
+			t.Errorf("Tag %d: expected %+v, got %+v", i, expected[i], tags[i])
+		}
+	}
+}
+
+func assertSamePayload(t *testing.T, frame gopacket.Packet, original gopacket.Packet) {
+	if udp := frame.Layer(layers.LayerTypeUDP); udp == nil ||
+		!bytes.Equal(udp.LayerContents(), original.Layer(layers.LayerTypeUDP).LayerContents()) {
+		t.Error("The payload of the frame should have been preserved")
+	}
+}
+
+func doubleTaggedFrame(t *testing.T) gopacket.Packet {
+	return applyVlanActions(t, newDhcpFrame(100),
+		actionSetField(matchVlanPcp(3)),
+		actionPushVlan(uint32(layers.EthernetTypeQinQ)),
+		actionSetField(matchVlanVid(4096|200)),
+		actionSetField(matchVlanPcp(5)),
+	)
+}
+
+func TestVlan_PushOnUntagged(t *testing.T) {
+	original := newDhcpFrame(conformanceUntagged)
+	frame := applyVlanActions(t, original, actionPushVlan(uint32(layers.EthernetTypeDot1Q)))
+
+	assertVlanTags(t, frame, testVlanTag{Tpid: uint16(layers.EthernetTypeDot1Q)})
+	assertSamePayload(t, frame, original)
+}
+
+func TestVlan_PushOnSingleTaggedCopiesOuterTag(t *testing.T) {
+	original := newDhcpFrame(100)
+	frame := applyVlanActions(t, original,
+		actionSetField(matchVlanPcp(3)),
+		actionPushVlan(uint32(layers.EthernetTypeQinQ)),
+	)
+
+	assertVlanTags(t, frame,
+		testVlanTag{Tpid: uint16(layers.EthernetTypeQinQ), Pcp: 3, Vid: 100},
+		testVlanTag{Tpid: uint16(layers.EthernetTypeDot1Q), Pcp: 3, Vid: 100},
+	)
+	assertSamePayload(t, frame, original)
+}
+
+func TestVlan_PushInvalidEthertype(t *testing.T) {
+	original := newDhcpFrame(100)
+	frame := applyVlanActions(t, original, actionPushVlan(uint32(layers.EthernetTypeIPv4)))
+
+	if !bytes.Equal(frame.Data(), original.Data()) {
+		t.Error("A push with an invalid ethertype should leave the frame untouched")
+	}
+}
+
+func TestVlan_PopSingleTagged(t *testing.T) {
+	frame := applyVlanActions(t, newDhcpFrame(100), actionPopVlan())
+
+	assertVlanTags(t, frame)
+	if !bytes.Equal(frame.Data(), newDhcpFrame(conformanceUntagged).Data()) {
+		t.Error("Popping the only tag should restore the untagged frame")
+	}
+}
+
+func TestVlan_PopDoubleTaggedExposesInnerTag(t *testing.T) {
+	frame := applyVlanActions(t, doubleTaggedFrame(t), actionPopVlan())
+
+	assertVlanTags(t, frame, testVlanTag{Tpid: uint16(layers.EthernetTypeDot1Q), Pcp: 3, Vid: 100})
+	assertSamePayload(t, frame, newDhcpFrame(100))
+}
+
+func TestVlan_PopUntagged(t *testing.T) {
+	original := newDhcpFrame(conformanceUntagged)
+	frame := applyVlanActions(t, original, actionPopVlan())
+
+	if !bytes.Equal(frame.Data(), original.Data()) {
+		t.Error("Popping an untagged frame should leave it untouched")
+	}
+}
+
+func TestVlan_SetVidSingleTaggedPreservesPcp(t *testing.T) {
+	frame := applyVlanActions(t, newDhcpFrame(100),
+		actionSetField(matchVlanPcp(6)),
+		actionSetField(matchVlanVid(4096|200)),
+	)
+
+	assertVlanTags(t, frame, testVlanTag{Tpid: uint16(layers.EthernetTypeDot1Q), Pcp: 6, Vid: 200})
+}
+
+func TestVlan_SetFieldsDoubleTaggedOnlyRewriteOuterTag(t *testing.T) {
+	frame := doubleTaggedFrame(t)
+	assertVlanTags(t, frame,
+		testVlanTag{Tpid: uint16(layers.EthernetTypeQinQ), Pcp: 5, Vid: 200},
+		testVlanTag{Tpid: uint16(layers.EthernetTypeDot1Q), Pcp: 3, Vid: 100},
+	)
+
+	frame = applyVlanActions(t, frame,
+		actionSetField(matchVlanVid(4096|300)),
+		actionSetField(matchVlanPcp(7)),
+	)
+	assertVlanTags(t, frame,
+		testVlanTag{Tpid: uint16(layers.EthernetTypeQinQ), Pcp: 7, Vid: 300},
+		testVlanTag{Tpid: uint16(layers.EthernetTypeDot1Q), Pcp: 3, Vid: 100},
+	)
+	assertSamePayload(t, frame, newDhcpFrame(100))
+}
+
+func TestVlan_SetFieldsUntagged(t *testing.T) {
+	original := newDhcpFrame(conformanceUntagged)
+	frame := applyVlanActions(t, original,
+		actionSetField(matchVlanVid(4096|100)),
+		actionSetField(matchVlanPcp(5)),
+	)
+
+	if !bytes.Equal(frame.Data(), original.Data()) {
+		t.Error("Setting VLAN fields of an untagged frame should leave it untouched")
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"errors"
+	"github.com/google/gopacket"
+	"hash/fnv"
+	"sync"
+)
+
+const (
+	DEFAULT_WORKER_QUEUE_DEPTH = 256
+)
+
+/*
+ErrWorkersStopped is returned when a frame is submitted to a stopped worker pool
+*/
+var ErrWorkersStopped = errors.New("worker pool is stopped")
+
+/*
+PonSimWorkerPool processes frames in parallel on a fixed number of workers.
+
+Frames are assigned to workers by flow, i.e. by ingress port, MAC addresses and VLAN tag,
+so that the frames of a flow are always processed by the same worker in order of reception.
+Submitting a frame blocks while the queue of its worker is full.
+*/
+type PonSimWorkerPool struct {
+	Size       int `json:"size"`
+	QueueDepth int `json:"queue_depth"`
+
+	queues []chan func()
+	done   chan struct{}
+	once   sync.Once
+}
+
+/*
+NewPonSimWorkerPool instantiates and starts a pool of workers, each with its own queue
+holding up to depth frames
+*/
+func NewPonSimWorkerPool(size int, depth int) *PonSimWorkerPool {
+	if size <= 0 {
+		size = 1
+	}
+	if depth <= 0 {
+		depth = DEFAULT_WORKER_QUEUE_DEPTH
+	}
+
+	p := &PonSimWorkerPool{
+		Size:       size,
+		QueueDepth: depth,
+		queues:     make([]chan func(), size),
+		done:       make(chan struct{}),
+	}
+
+	for i := range p.queues {
+		p.queues[i] = make(chan func(), depth)
+		go p.work(p.queues[i])
+	}
+
+	return p
+}
+
+func (p *PonSimWorkerPool) work(queue chan func()) {
+	for {
+		select {
+		case task := <-queue:
+			task()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+/*
+Submit queues the processing of a frame received on a port with the worker of its flow
+*/
+func (p *PonSimWorkerPool) Submit(port int, frame gopacket.Packet, task func()) error {
+	queue := p.queues[flowKey(port, frame)%uint32(len(p.queues))]
+
+	select {
+	case queue <- task:
+		return nil
+	case <-p.done:
+		return ErrWorkersStopped
+	}
+}
+
+/*
+Stop terminates the workers, discarding the frames still queued
+*/
+func (p *PonSimWorkerPool) Stop() {
+	if p == nil {
+		return
+	}
+
+	p.once.Do(func() { close(p.done) })
+}
+
+/*
+flowKey hashes the ingress port and the ethernet header of a frame, up to its outer VLAN tag,
+without decoding the frame
+*/
+func flowKey(port int, frame gopacket.Packet) uint32 {
+	data := frame.Data()
+
+	// Destination and source MAC addresses, followed by the TPID and TCI of a VLAN tag
+	size := 12
+	if len(data) >= 16 && data[12] == 0x81 && data[13] == 0x00 {
+		size = 16
+	}
+	if len(data) < size {
+		size = len(data)
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte{byte(port)})
+	hash.Write(data[:size])
+
+	return hash.Sum32()
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"context"
+	"github.com/opencord/voltha/protos/go/openflow_13"
+	"github.com/opencord/voltha/protos/go/ponsim"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testProcessDataClient struct {
+	ponsim.PonSimCommon_ProcessDataClient
+
+	sending    int32
+	concurrent int32
+	sent       sync.WaitGroup
+}
+
+func (c *testProcessDataClient) Send(incoming *ponsim.IncomingData) error {
+	defer c.sent.Done()
+
+	if atomic.AddInt32(&c.sending, 1) > 1 {
+		atomic.StoreInt32(&c.concurrent, 1)
+	}
+	time.Sleep(time.Millisecond)
+	atomic.AddInt32(&c.sending, -1)
+
+	return nil
+}
+
+func TestWorkerPool_SendsToOltOneAtATime(t *testing.T) {
+	const frames = 64
+
+	onu := NewPonSimOnuDevice(PonSimDevice{
+		Name:    "test",
+		Counter: NewPonSimMetricCounter("test"),
+		Workers: NewPonSimWorkerPool(4, 0),
+	})
+	defer onu.Workers.Stop()
+
+	stream := &testProcessDataClient{}
+	stream.sent.Add(frames)
+	onu.stream = stream
+
+	onu.InstallFlows(context.Background(), []*openflow_13.OfpFlowStats{
+		newFlow(1000, matchFields(matchInPort(2)), actionOutput(1)),
+	})
+	onu.AddLink(1, 0, onu.forwardToOLT())
+
+	// Frames of different VLANs belong to different flows, processed by different workers
+	for vlan := 1; vlan <= frames; vlan++ {
+		if err := onu.PonSimDevice.Forward(context.Background(), 2, newDhcpFrame(vlan)); err != nil {
+			t.Fatal("Failed to submit frame", err)
+		}
+	}
+	stream.sent.Wait()
+
+	if atomic.LoadInt32(&stream.concurrent) != 0 {
+		t.Error("Frames should not be sent concurrently on the stream to the OLT")
+	}
+}
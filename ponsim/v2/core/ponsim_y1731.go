@@ -0,0 +1,337 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/opencord/voltha/protos/go/ponsim"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Operation codes of the performance monitoring PDUs of Y.1731
+const (
+	CFM_OPCODE_LMR = 42
+	CFM_OPCODE_LMM = 43
+	CFM_OPCODE_DMR = 46
+	CFM_OPCODE_DMM = 47
+)
+
+const (
+	CFM_LMM_TLV_OFFSET = 12
+	CFM_DMM_TLV_OFFSET = 32
+
+	DEFAULT_CFM_MEASUREMENT_COUNT    = 10
+	DEFAULT_CFM_MEASUREMENT_INTERVAL = 100 * time.Millisecond
+)
+
+/*
+cfmReply is a reply to a measurement message, along with the time it was received and the
+service frames received by the MEP at the time
+*/
+type cfmReply struct {
+	pdu      []byte
+	received time.Time
+	rxFrames uint32
+}
+
+/*
+countFrame counts a service frame received or sent by the MEP
+*/
+func (m *PonSimMep) countFrame(received bool) {
+	if received {
+		atomic.AddUint64(&m.rxFrames, 1)
+	} else {
+		atomic.AddUint64(&m.txFrames, 1)
+	}
+}
+
+/*
+putTimestamp writes a time in the format of the time stamps of Y.1731, seconds and nanoseconds
+*/
+func putTimestamp(b []byte, t time.Time) {
+	binary.BigEndian.PutUint32(b, uint32(t.Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(t.Nanosecond()))
+}
+
+/*
+getTimestamp reads a time stamp of Y.1731
+*/
+func getTimestamp(b []byte) time.Time {
+	return time.Unix(int64(binary.BigEndian.Uint32(b)), int64(binary.BigEndian.Uint32(b[4:])))
+}
+
+/*
+receiveMeasurement answers the delay and loss measurement messages and hands the replies to the
+measurement waiting for them; the caller holds the lock of the MEP
+*/
+func (m *PonSimMep) receiveMeasurement(srcMac net.HardwareAddr, pdu []byte) gopacket.Packet {
+	now := time.Now()
+
+	switch pdu[1] {
+	case CFM_OPCODE_DMM:
+		if len(pdu) < 4+CFM_DMM_TLV_OFFSET {
+			return nil
+		}
+		m.dmmsReceived++
+
+		reply := append([]byte{}, pdu...)
+		reply[1] = CFM_OPCODE_DMR
+		putTimestamp(reply[12:], now)
+		putTimestamp(reply[20:], time.Now())
+		return m.makeFrame(srcMac, reply)
+
+	case CFM_OPCODE_LMM:
+		if len(pdu) < 4+CFM_LMM_TLV_OFFSET {
+			return nil
+		}
+		m.lmmsReceived++
+
+		reply := append([]byte{}, pdu...)
+		reply[1] = CFM_OPCODE_LMR
+		binary.BigEndian.PutUint32(reply[8:], uint32(atomic.LoadUint64(&m.rxFrames)))
+		binary.BigEndian.PutUint32(reply[12:], uint32(atomic.LoadUint64(&m.txFrames)))
+		return m.makeFrame(srcMac, reply)
+
+	case CFM_OPCODE_DMR, CFM_OPCODE_LMR:
+		if replies, ok := m.replies[pdu[1]]; ok {
+			select {
+			case replies <- cfmReply{
+				pdu:      append([]byte{}, pdu...),
+				received: now,
+				rxFrames: uint32(atomic.LoadUint64(&m.rxFrames)),
+			}:
+			default:
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+measure sends measurement messages built by the newMessage function at an interval and returns the
+replies received before the timeout; a single measurement of each kind runs at a time
+*/
+func (m *PonSimMep) measure(
+	transmit func(int, string, gopacket.Packet),
+	replyOpcode uint8,
+	count int,
+	interval time.Duration,
+	timeout time.Duration,
+	newMessage func() gopacket.Packet,
+) ([]cfmReply, error) {
+	m.mutex.Lock()
+	if _, ok := m.replies[replyOpcode]; ok {
+		m.mutex.Unlock()
+		return nil, fmt.Errorf("a measurement is already running on MEP %d", m.MepId)
+	}
+	replies := make(chan cfmReply, 1)
+	m.replies[replyOpcode] = replies
+	m.mutex.Unlock()
+
+	defer func() {
+		m.mutex.Lock()
+		delete(m.replies, replyOpcode)
+		m.mutex.Unlock()
+	}()
+
+	var received []cfmReply
+	for i := 0; i < count; i++ {
+		start := time.Now()
+
+		// Replies to the previous messages which arrived too late are ignored
+		select {
+		case <-replies:
+		default:
+		}
+
+		if frame := newMessage(); frame != nil {
+			transmit(m.Port, m.Direction, frame)
+		}
+
+		select {
+		case reply := <-replies:
+			received = append(received, reply)
+		case <-time.After(timeout):
+		}
+
+		if i < count-1 {
+			time.Sleep(interval - time.Since(start))
+		}
+	}
+
+	return received, nil
+}
+
+/*
+makeDmm builds a delay measurement message sent to a MAC address
+*/
+func (m *PonSimMep) makeDmm(dstMac net.HardwareAddr) gopacket.Packet {
+	pdu := newCfmHeader(m.Level, CFM_OPCODE_DMM, 0, CFM_DMM_TLV_OFFSET)
+	pdu = append(pdu, make([]byte, CFM_DMM_TLV_OFFSET+1)...)
+	putTimestamp(pdu[4:], time.Now())
+
+	return m.makeFrame(dstMac, pdu)
+}
+
+/*
+makeLmm builds a loss measurement message sent to a MAC address, carrying the service frames
+sent by the MEP
+*/
+func (m *PonSimMep) makeLmm(dstMac net.HardwareAddr) gopacket.Packet {
+	pdu := newCfmHeader(m.Level, CFM_OPCODE_LMM, 0, CFM_LMM_TLV_OFFSET)
+	pdu = append(pdu, make([]byte, CFM_LMM_TLV_OFFSET+1)...)
+	binary.BigEndian.PutUint32(pdu[4:], uint32(atomic.LoadUint64(&m.txFrames)))
+
+	return m.makeFrame(dstMac, pdu)
+}
+
+/*
+DelayMeasurement measures the two-way frame delay between a MEP and a MAC address, or a remote
+MEP when no address is given, with DMM and DMR.  The processing time of the peer, given by the
+time stamps of its replies, is excluded from the delays.
+*/
+func (c *PonSimCfm) DelayMeasurement(
+	mepId uint16,
+	target net.HardwareAddr,
+	remoteMepId uint16,
+	count int,
+	interval time.Duration,
+	timeout time.Duration,
+) (*ponsim.CfmDelayResult, error) {
+	mep, target, transmit, err := c.resolve(mepId, target, remoteMepId)
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := mep.measure(transmit, CFM_OPCODE_DMR, count, interval, timeout, func() gopacket.Packet {
+		return mep.makeDmm(target)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ponsim.CfmDelayResult{
+		MepId:     uint32(mepId),
+		TargetMac: target.String(),
+		Sent:      uint32(count),
+		Received:  uint32(len(replies)),
+		Timestamp: time.Now().Unix(),
+	}
+	var total, variation, previous time.Duration
+	for i, reply := range replies {
+		delay := reply.received.Sub(getTimestamp(reply.pdu[4:])) -
+			getTimestamp(reply.pdu[20:]).Sub(getTimestamp(reply.pdu[12:]))
+		if delay < 0 {
+			delay = 0
+		}
+
+		us := uint32(delay / time.Microsecond)
+		if i == 0 || us < result.MinDelayUs {
+			result.MinDelayUs = us
+		}
+		if us > result.MaxDelayUs {
+			result.MaxDelayUs = us
+		}
+		if i > 0 {
+			if delay > previous {
+				variation += delay - previous
+			} else {
+				variation += previous - delay
+			}
+		}
+		total += delay
+		previous = delay
+	}
+	if len(replies) > 0 {
+		result.AvgDelayUs = uint32(total / time.Duration(len(replies)) / time.Microsecond)
+	}
+	if len(replies) > 1 {
+		result.AvgVariationUs = uint32(variation / time.Duration(len(replies)-1) / time.Microsecond)
+	}
+
+	mep.mutex.Lock()
+	mep.lastDelay = result
+	mep.mutex.Unlock()
+
+	return result, nil
+}
+
+/*
+LossMeasurement measures the service frames lost between a MEP and a MAC address, or a remote MEP
+when no address is given, with LMM and LMR.  The far-end loss is the loss of the frames sent by
+the MEP and the near-end loss the loss of the frames it received, between the first and the last
+replies.
+*/
+func (c *PonSimCfm) LossMeasurement(
+	mepId uint16,
+	target net.HardwareAddr,
+	remoteMepId uint16,
+	count int,
+	interval time.Duration,
+	timeout time.Duration,
+) (*ponsim.CfmLossResult, error) {
+	mep, target, transmit, err := c.resolve(mepId, target, remoteMepId)
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := mep.measure(transmit, CFM_OPCODE_LMR, count, interval, timeout, func() gopacket.Packet {
+		return mep.makeLmm(target)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ponsim.CfmLossResult{
+		MepId:     uint32(mepId),
+		TargetMac: target.String(),
+		Sent:      uint32(count),
+		Received:  uint32(len(replies)),
+		Timestamp: time.Now().Unix(),
+	}
+	if len(replies) > 1 {
+		first, last := replies[0], replies[len(replies)-1]
+		counter := func(reply cfmReply, offset int) uint32 {
+			return binary.BigEndian.Uint32(reply.pdu[offset:])
+		}
+
+		// The counters wrap around, the frames in flight may be counted as sent but not received
+		result.FarEndTx = counter(last, 4) - counter(first, 4)
+		farEndRx := counter(last, 8) - counter(first, 8)
+		result.NearEndTx = counter(last, 12) - counter(first, 12)
+		nearEndRx := last.rxFrames - first.rxFrames
+
+		if farEndRx < result.FarEndTx {
+			result.FarEndLoss = result.FarEndTx - farEndRx
+			result.FarEndLossRatio = float32(result.FarEndLoss) / float32(result.FarEndTx)
+		}
+		if nearEndRx < result.NearEndTx {
+			result.NearEndLoss = result.NearEndTx - nearEndRx
+			result.NearEndLossRatio = float32(result.NearEndLoss) / float32(result.NearEndTx)
+		}
+	}
+
+	mep.mutex.Lock()
+	mep.lastLoss = result
+	mep.mutex.Unlock()
+
+	return result, nil
+}
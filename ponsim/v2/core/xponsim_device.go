@@ -27,19 +27,16 @@ type XPonSimDevice struct {
 }
 
 /*
-
  */
 func (d *XPonSimDevice) Start(ctx context.Context) {
 }
 
 /*
-
  */
 func (d *XPonSimDevice) Stop(ctx context.Context) {
 }
 
 /*
-
  */
 func (d *XPonSimDevice) CreateInterface(ctx context.Context, config *voltha.InterfaceConfig) {
 	common.Logger().WithFields(logrus.Fields{
@@ -51,7 +48,6 @@ func (d *XPonSimDevice) CreateInterface(ctx context.Context, config *voltha.Inte
 }
 
 /*
-
  */
 func (d *XPonSimDevice) UpdateInterface(ctx context.Context, config *voltha.InterfaceConfig) {
 	common.Logger().WithFields(logrus.Fields{
@@ -63,7 +59,6 @@ func (d *XPonSimDevice) UpdateInterface(ctx context.Context, config *voltha.Inte
 }
 
 /*
-
  */
 func (d *XPonSimDevice) RemoveInterface(ctx context.Context, config *voltha.InterfaceConfig) {
 	common.Logger().WithFields(logrus.Fields{
@@ -75,7 +70,6 @@ func (d *XPonSimDevice) RemoveInterface(ctx context.Context, config *voltha.Inte
 }
 
 /*
-
  */
 func (d *XPonSimDevice) CreateTcont(ctx context.Context,
 	config *bbf_fiber.TcontsConfigData,
@@ -90,7 +84,6 @@ func (d *XPonSimDevice) CreateTcont(ctx context.Context,
 }
 
 /*
-
  */
 func (d *XPonSimDevice) UpdateTcont(
 	ctx context.Context,
@@ -106,7 +99,6 @@ func (d *XPonSimDevice) UpdateTcont(
 }
 
 /*
-
  */
 func (d *XPonSimDevice) RemoveTcont(
 	ctx context.Context,
@@ -122,7 +114,6 @@ func (d *XPonSimDevice) RemoveTcont(
 }
 
 /*
-
  */
 func (d *XPonSimDevice) CreateGemport(ctx context.Context, config *voltha.InterfaceConfig) {
 	common.Logger().WithFields(logrus.Fields{
@@ -134,7 +125,6 @@ func (d *XPonSimDevice) CreateGemport(ctx context.Context, config *voltha.Interf
 }
 
 /*
-
  */
 func (d *XPonSimDevice) UpdateGemport(ctx context.Context, config *voltha.InterfaceConfig) {
 	common.Logger().WithFields(logrus.Fields{
@@ -146,7 +136,6 @@ func (d *XPonSimDevice) UpdateGemport(ctx context.Context, config *voltha.Interf
 }
 
 /*
-
  */
 func (d *XPonSimDevice) RemoveGemport(ctx context.Context, config *voltha.InterfaceConfig) {
 	common.Logger().WithFields(logrus.Fields{
@@ -158,7 +147,6 @@ func (d *XPonSimDevice) RemoveGemport(ctx context.Context, config *voltha.Interf
 }
 
 /*
-
  */
 func (d *XPonSimDevice) CreateMulticastGemport(ctx context.Context, config *voltha.InterfaceConfig) {
 	common.Logger().WithFields(logrus.Fields{
@@ -170,7 +158,6 @@ func (d *XPonSimDevice) CreateMulticastGemport(ctx context.Context, config *volt
 }
 
 /*
-
  */
 func (d *XPonSimDevice) UpdateMulticastGemport(ctx context.Context, config *voltha.InterfaceConfig) {
 	common.Logger().WithFields(logrus.Fields{
@@ -182,7 +169,6 @@ func (d *XPonSimDevice) UpdateMulticastGemport(ctx context.Context, config *volt
 }
 
 /*
-
  */
 func (d *XPonSimDevice) RemoveMulticastGemport(ctx context.Context, config *voltha.InterfaceConfig) {
 	common.Logger().WithFields(logrus.Fields{
@@ -194,7 +180,6 @@ func (d *XPonSimDevice) RemoveMulticastGemport(ctx context.Context, config *volt
 }
 
 /*
-
  */
 func (d *XPonSimDevice) CreateMulticastDistributionSet(ctx context.Context, config *voltha.InterfaceConfig) {
 	common.Logger().WithFields(logrus.Fields{
@@ -206,7 +191,6 @@ func (d *XPonSimDevice) CreateMulticastDistributionSet(ctx context.Context, conf
 }
 
 /*
-
  */
 func (d *XPonSimDevice) UpdateMulticastDistributionSet(ctx context.Context, config *voltha.InterfaceConfig) {
 	common.Logger().WithFields(logrus.Fields{
@@ -218,7 +202,6 @@ func (d *XPonSimDevice) UpdateMulticastDistributionSet(ctx context.Context, conf
 }
 
 /*
-
  */
 func (d *XPonSimDevice) RemoveMulticastDistributionSet(ctx context.Context, config *voltha.InterfaceConfig) {
 	common.Logger().WithFields(logrus.Fields{
@@ -28,17 +28,32 @@ import (
 	"github.com/opencord/voltha/protos/go/voltha"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"os"
 	"strconv"
 	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_KEEPALIVE_TIME    = 30 * time.Second
+	DEFAULT_KEEPALIVE_TIMEOUT = 10 * time.Second
 )
 
 type GrpcServer struct {
 	gs       *grpc.Server
 	address  string
 	port     int32
+	socket   string
 	secure   bool
 	services []func(*grpc.Server)
 
+	interceptors       []grpc.UnaryServerInterceptor
+	streamInterceptors []grpc.StreamServerInterceptor
+
+	keepaliveTime    time.Duration
+	keepaliveTimeout time.Duration
+
 	*GrpcSecurity
 }
 
@@ -52,14 +67,49 @@ func NewGrpcServer(
 	secure bool,
 ) *GrpcServer {
 	server := &GrpcServer{
-		address:      address,
-		port:         port,
-		secure:       secure,
-		GrpcSecurity: certs,
+		address:          address,
+		port:             port,
+		secure:           secure,
+		keepaliveTime:    DEFAULT_KEEPALIVE_TIME,
+		keepaliveTimeout: DEFAULT_KEEPALIVE_TIMEOUT,
+		GrpcSecurity:     certs,
 	}
 	return server
 }
 
+/*
+SetKeepalive defines how often idle connections are probed and how long to wait for an
+acknowledgement before the connection, along with its streams, is closed
+*/
+func (s *GrpcServer) SetKeepalive(interval time.Duration, timeout time.Duration) {
+	s.keepaliveTime = interval
+	s.keepaliveTimeout = timeout
+}
+
+/*
+SetSocket serves the requests received on a Unix socket as well, e.g. from the adapters
+running in the same pod
+*/
+func (s *GrpcServer) SetSocket(path string) {
+	s.socket = path
+}
+
+/*
+listenSocket listens on the Unix socket, replacing the socket left by a previous instance
+*/
+func (s *GrpcServer) listenSocket() net.Listener {
+	if info, err := os.Stat(s.socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(s.socket)
+	}
+
+	lis, err := net.Listen("unix", s.socket)
+	if err != nil {
+		common.Logger().Fatalf("failed to listen on %s: %v", s.socket, err)
+	}
+
+	return lis
+}
+
 /*
 Start prepares the GRPC server and starts servicing requests
 */
@@ -74,16 +124,37 @@ func (s *GrpcServer) Start(ctx context.Context) {
 		common.Logger().Fatalf("failed to listen: %v", err)
 	}
 
+	// Probe peers periodically so that half-open connections get detected and closed
+	options := []grpc.ServerOption{
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    s.keepaliveTime,
+			Timeout: s.keepaliveTimeout,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             s.keepaliveTimeout,
+			PermitWithoutStream: true,
+		}),
+	}
+
+	options = append(options, common.GrpcServerOptions()...)
+
+	if len(s.interceptors) > 0 {
+		options = append(options, grpc.UnaryInterceptor(chainUnaryInterceptors(s.interceptors)))
+	}
+	if len(s.streamInterceptors) > 0 {
+		options = append(options, grpc.StreamInterceptor(chainStreamInterceptors(s.streamInterceptors)))
+	}
+
 	if s.secure {
 		creds, err := credentials.NewServerTLSFromFile(s.CertFile, s.KeyFile)
 		if err != nil {
 			common.Logger().Fatalf("could not load TLS keys: %s", err)
 		}
-		s.gs = grpc.NewServer(grpc.Creds(creds))
+		s.gs = grpc.NewServer(append(options, grpc.Creds(creds))...)
 
 	} else {
 		common.Logger().Println("In DEFAULT\n")
-		s.gs = grpc.NewServer()
+		s.gs = grpc.NewServer(options...)
 	}
 
 	// Register all required services
@@ -91,6 +162,14 @@ func (s *GrpcServer) Start(ctx context.Context) {
 		service(s.gs)
 	}
 
+	if s.socket != "" {
+		go func(lis net.Listener) {
+			if err := s.gs.Serve(lis); err != nil {
+				common.Logger().Errorf("failed to serve on %s: %v", s.socket, err)
+			}
+		}(s.listenSocket())
+	}
+
 	if err := s.gs.Serve(lis); err != nil {
 		common.Logger().Fatalf("failed to serve: %v\n", err)
 	}
@@ -113,6 +192,88 @@ func (s *GrpcServer) AddService(
 	s.services = append(s.services, func(gs *grpc.Server) { registerFunction(gs, handler) })
 }
 
+/*
+chainUnaryInterceptors combines interceptors, the first one being the outermost, since a
+server accepts a single interceptor
+*/
+func chainUnaryInterceptors(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		request interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(ctx context.Context, request interface{}) (interface{}, error) {
+				return interceptor(ctx, request, info, next)
+			}
+		}
+
+		return chained(ctx, request)
+	}
+}
+
+/*
+chainStreamInterceptors combines stream interceptors, the first one being the outermost
+*/
+func chainStreamInterceptors(interceptors []grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(
+		server interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(server interface{}, stream grpc.ServerStream) error {
+				return interceptor(server, stream, info, next)
+			}
+		}
+
+		return chained(server, stream)
+	}
+}
+
+/*
+AddRequestIdInterceptor attaches a correlation ID to every request, received from the caller
+or generated, so that the logs of a request can be correlated across the simulated devices
+*/
+func (s *GrpcServer) AddRequestIdInterceptor() {
+	s.interceptors = append(s.interceptors, common.RequestIdUnaryServerInterceptor)
+	s.streamInterceptors = append(s.streamInterceptors, common.RequestIdStreamServerInterceptor)
+}
+
+/*
+AddAuditInterceptor publishes the calls of the RPCs audited by a device on its event bus
+*/
+func (s *GrpcServer) AddAuditInterceptor(device core.PonSimInterface) {
+	s.interceptors = append(s.interceptors, nbi.NewAuditInterceptor(device))
+}
+
+/*
+AddAuthInterceptor rejects the calls of the RPCs changing the state of a device whose caller is not authenticated
+*/
+func (s *GrpcServer) AddAuthInterceptor(device core.PonSimInterface) {
+	s.interceptors = append(s.interceptors, nbi.NewAuthInterceptor(device))
+}
+
+/*
+AddRateLimitInterceptor rejects the calls of the management RPCs exceeding the rate limits of a device
+*/
+func (s *GrpcServer) AddRateLimitInterceptor(device core.PonSimInterface) {
+	s.interceptors = append(s.interceptors, nbi.NewRateLimitInterceptor(device))
+}
+
+/*
+AddChaosInterceptor delays the responses of the PonSim RPCs by the delays injected by the chaos controller of a device
+*/
+func (s *GrpcServer) AddChaosInterceptor(device core.PonSimInterface) {
+	s.interceptors = append(s.interceptors, nbi.NewChaosInterceptor(device))
+}
+
 /*
 AddPonSimService appends service request functions for PonSim devices
 */
@@ -137,6 +298,18 @@ func (s *GrpcServer) AddCommonService(device core.PonSimInterface) {
 	)
 }
 
+/*
+AddAdminService appends service request functions used to administer the simulator
+*/
+func (s *GrpcServer) AddAdminService(device core.PonSimInterface) {
+	s.services = append(
+		s.services,
+		func(gs *grpc.Server) {
+			ponsim.RegisterPonSimAdminServer(gs, nbi.NewPonSimAdminHandler(device))
+		},
+	)
+}
+
 /*
 AddOltService appends service request functions specific to OLT devices
 */
@@ -152,6 +325,21 @@ func (s *GrpcServer) AddOltService(device core.PonSimInterface) {
 	)
 }
 
+/*
+AddOnuService appends service request functions specific to ONU devices
+*/
+func (s *GrpcServer) AddOnuService(device core.PonSimInterface) {
+	s.services = append(
+		s.services,
+		func(gs *grpc.Server) {
+			ponsim.RegisterPonSimOnuServer(
+				gs,
+				sbi.NewPonSimOnuHandler(device.(*core.PonSimOnuDevice)),
+			)
+		},
+	)
+}
+
 /*
 AddXPonService appends service request functions specific to XPonSim
 */
@@ -17,7 +17,6 @@ package nbi
 
 import (
 	"context"
-	"github.com/opencord/voltha/ponsim/v2/common"
 	"github.com/opencord/voltha/protos/go/bal"
 )
 
@@ -36,7 +35,7 @@ func (handler *BalHandler) BalApiInit(
 	ctx context.Context,
 	request *bal.BalInit,
 ) (*bal.BalErr, error) {
-	common.Logger().Info("BalApiInit Called", ctx, request)
+	nbiLogger.Info("BalApiInit Called", ctx, request)
 	return &bal.BalErr{Err: bal.BalErrno_BAL_ERR_OK}, nil
 }
 
@@ -44,7 +43,7 @@ func (handler *BalHandler) BalApiFinish(
 	ctx context.Context,
 	request *bal.BalCfg,
 ) (*bal.BalErr, error) {
-	common.Logger().Info("BalApiFinish Called", ctx, request)
+	nbiLogger.Info("BalApiFinish Called", ctx, request)
 	return &bal.BalErr{Err: bal.BalErrno_BAL_ERR_OK}, nil
 }
 
@@ -52,7 +51,7 @@ func (handler *BalHandler) BalCfgSet(
 	ctx context.Context,
 	request *bal.BalCfg,
 ) (*bal.BalErr, error) {
-	common.Logger().Info("BalCfgSet Called", ctx, request)
+	nbiLogger.Info("BalCfgSet Called", ctx, request)
 	return &bal.BalErr{Err: bal.BalErrno_BAL_ERR_OK}, nil
 }
 
@@ -60,7 +59,7 @@ func (handler *BalHandler) BalCfgClear(
 	ctx context.Context,
 	request *bal.BalKey,
 ) (*bal.BalErr, error) {
-	common.Logger().Info("BalCfgClear Called", ctx, request)
+	nbiLogger.Info("BalCfgClear Called", ctx, request)
 	return &bal.BalErr{Err: bal.BalErrno_BAL_ERR_OK}, nil
 }
 
@@ -68,7 +67,7 @@ func (handler *BalHandler) BalCfgGet(
 	ctx context.Context,
 	request *bal.BalKey,
 ) (*bal.BalCfg, error) {
-	common.Logger().Info("BalCfgGet Called", ctx, request)
+	nbiLogger.Info("BalCfgGet Called", ctx, request)
 	return &bal.BalCfg{}, nil
 }
 
@@ -76,7 +75,7 @@ func (handler *BalHandler) BalApiReboot(
 	ctx context.Context,
 	request *bal.BalReboot,
 ) (*bal.BalErr, error) {
-	common.Logger().Info("BalApiReboot Called", ctx, request)
+	nbiLogger.Info("BalApiReboot Called", ctx, request)
 	return &bal.BalErr{Err: bal.BalErrno_BAL_ERR_OK}, nil
 }
 
@@ -84,7 +83,7 @@ func (handler *BalHandler) BalApiHeartbeat(
 	ctx context.Context,
 	request *bal.BalHeartbeat,
 ) (*bal.BalRebootState, error) {
-	common.Logger().Info("BalApiHeartbeat Called", ctx, request)
+	nbiLogger.Info("BalApiHeartbeat Called", ctx, request)
 	return &bal.BalRebootState{}, nil
 }
 
@@ -92,6 +91,6 @@ func (handler *BalHandler) BalCfgStatGet(
 	ctx context.Context,
 	request *bal.BalInterfaceKey,
 ) (*bal.BalInterfaceStat, error) {
-	common.Logger().Info("BalCfgStatGet Called", ctx, request)
+	nbiLogger.Info("BalCfgStatGet Called", ctx, request)
 	return &bal.BalInterfaceStat{}, nil
 }
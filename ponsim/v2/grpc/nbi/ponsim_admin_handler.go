@@ -0,0 +1,2219 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package nbi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/ponsim/v2/core"
+	"github.com/opencord/voltha/protos/go/ponsim"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+type PonSimAdminHandler struct {
+	device core.PonSimInterface
+}
+
+/*
+NewPonSimAdminHandler instantiates a handler for the simulator administration services
+*/
+func NewPonSimAdminHandler(device core.PonSimInterface) *PonSimAdminHandler {
+	var handler *PonSimAdminHandler
+	handler = &PonSimAdminHandler{device: device}
+	return handler
+}
+
+/*
+RunConformance executes the built-in conformance suite against the flow engine
+*/
+func (handler *PonSimAdminHandler) RunConformance(
+	ctx context.Context,
+	empty *empty.Empty,
+) (*ponsim.ConformanceReport, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+	}).Info("Running conformance suite")
+
+	report := core.RunConformanceSuite(ctx)
+
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"passed":  report.Passed,
+		"failed":  report.Failed,
+	}).Info("Conformance suite results")
+
+	return report, nil
+}
+
+/*
+SetPortDelay configures the latency applied to the frames of a port
+*/
+func (handler *PonSimAdminHandler) SetPortDelay(
+	ctx context.Context,
+	request *ponsim.PortDelay,
+) (*empty.Empty, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"request": request,
+	}).Info("Setting port delay")
+
+	device := getPonSimDevice(handler.device)
+	if device == nil || device.Delays == nil {
+		return nil, errors.New("device does not support delay injection")
+	}
+	if _, ok := ponsim.Direction_name[int32(request.Direction)]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown delay direction: %d", request.Direction)
+	}
+	if _, ok := ponsim.PortDelay_Distribution_name[int32(request.Distribution)]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown delay distribution: %d", request.Distribution)
+	}
+
+	var delay *core.PonSimDelay
+	if request.Distribution != ponsim.PortDelay_NONE {
+		delay = &core.PonSimDelay{
+			Distribution: core.PonSimDelayDistribution(request.Distribution - ponsim.PortDelay_FIXED),
+			Delay:        time.Duration(request.DelayMs) * time.Millisecond,
+			Jitter:       time.Duration(request.JitterMs) * time.Millisecond,
+		}
+	}
+	device.Delays.Set(int(request.Port), core.PonSimDirection(request.Direction), delay)
+
+	return &empty.Empty{}, nil
+}
+
+/*
+SetPortFault configures the percentage of frames dropped or corrupted on a port
+*/
+func (handler *PonSimAdminHandler) SetPortFault(
+	ctx context.Context,
+	request *ponsim.PortFault,
+) (*empty.Empty, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"request": request,
+	}).Info("Setting port fault")
+
+	device := getPonSimDevice(handler.device)
+	if device == nil || device.Faults == nil {
+		return nil, errors.New("device does not support fault injection")
+	}
+
+	device.Faults.Set(int(request.Port), core.PonSimFault{
+		DropPercent:    float64(request.DropPercent),
+		CorruptPercent: float64(request.CorruptPercent),
+	})
+
+	return &empty.Empty{}, nil
+}
+
+/*
+FlapPort takes a port down for the requested duration before bringing it back up
+*/
+func (handler *PonSimAdminHandler) FlapPort(
+	ctx context.Context,
+	request *ponsim.PortFlap,
+) (*empty.Empty, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"request": request,
+	}).Info("Flapping port")
+
+	device := getPonSimDevice(handler.device)
+	if device == nil {
+		return nil, errors.New("device does not support port state changes")
+	}
+
+	if err := device.FlapPort(int(request.Port), time.Duration(request.DurationMs)*time.Millisecond); err != nil {
+		return nil, err
+	}
+
+	return &empty.Empty{}, nil
+}
+
+/*
+StartIpv6Subscriber simulates a subscriber host acquiring IPv6 addresses behind the UNI port of an ONU
+*/
+func (handler *PonSimAdminHandler) StartIpv6Subscriber(
+	ctx context.Context,
+	request *ponsim.Ipv6SubscriberRequest,
+) (*empty.Empty, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"request": request,
+	}).Info("Starting IPv6 subscriber")
+
+	onu, ok := handler.device.(*core.PonSimOnuDevice)
+	if !ok {
+		return nil, errors.New("subscribers can only be simulated on an ONU")
+	}
+
+	mac, err := net.ParseMAC(request.Mac)
+	if err != nil {
+		return nil, err
+	}
+
+	vlan := int(request.Vlan)
+	if request.Untagged {
+		vlan = -1
+	}
+
+	// The subscriber outlives the request
+	onu.StartIpv6Subscriber(context.Background(), mac, vlan)
+
+	return &empty.Empty{}, nil
+}
+
+/*
+GetIpv6Subscribers reports the addresses acquired by the subscriber hosts of an ONU
+*/
+func (handler *PonSimAdminHandler) GetIpv6Subscribers(
+	ctx context.Context,
+	empty *empty.Empty,
+) (*ponsim.Ipv6Subscribers, error) {
+	onu, ok := handler.device.(*core.PonSimOnuDevice)
+	if !ok {
+		return nil, errors.New("subscribers can only be simulated on an ONU")
+	}
+
+	subscribers := &ponsim.Ipv6Subscribers{}
+	for _, subscriber := range onu.GetIpv6Subscribers() {
+		subscribers.Subscribers = append(subscribers.Subscribers, subscriber.MakeProto())
+	}
+
+	return subscribers, nil
+}
+
+/*
+GetRunInfo reports the build, configuration and features of the simulator instance
+*/
+func (handler *PonSimAdminHandler) GetRunInfo(
+	ctx context.Context,
+	empty *empty.Empty,
+) (*ponsim.RunInfo, error) {
+	device := getPonSimDevice(handler.device)
+	if device == nil || device.RunInfo == nil {
+		return nil, errors.New("device does not report run information")
+	}
+
+	return device.RunInfo.MakeProto(), nil
+}
+
+/*
+GetVersion reports the build of the simulator and its active features, so that test harnesses
+can check the capabilities of the instance they run against
+*/
+func (handler *PonSimAdminHandler) GetVersion(
+	ctx context.Context,
+	empty *empty.Empty,
+) (*ponsim.VersionInfo, error) {
+	device := getPonSimDevice(handler.device)
+	if device == nil || device.RunInfo == nil {
+		return nil, errors.New("device does not report run information")
+	}
+
+	return device.RunInfo.MakeVersionProto(), nil
+}
+
+/*
+GetClock reports the time of the device and how far it drifted from the real time
+*/
+func (handler *PonSimAdminHandler) GetClock(
+	ctx context.Context,
+	empty *empty.Empty,
+) (*ponsim.ClockStatus, error) {
+	device := getPonSimDevice(handler.device)
+	if device == nil || device.Clock == nil {
+		return nil, errors.New("device does not have a clock")
+	}
+
+	return makeClockStatus(device.Clock), nil
+}
+
+/*
+SetClockDrift changes the rate at which the time of the device drifts from the real time
+*/
+func (handler *PonSimAdminHandler) SetClockDrift(
+	ctx context.Context,
+	request *ponsim.ClockDrift,
+) (*ponsim.ClockStatus, error) {
+	device := getPonSimDevice(handler.device)
+	if device == nil {
+		return nil, errors.New("device does not have a clock")
+	}
+
+	if err := device.SetClockDrift(request.DriftPpm); err != nil {
+		return nil, err
+	}
+
+	return makeClockStatus(device.Clock), nil
+}
+
+/*
+ResyncClock brings the time of the device back to the real time
+*/
+func (handler *PonSimAdminHandler) ResyncClock(
+	ctx context.Context,
+	empty *empty.Empty,
+) (*ponsim.ClockStatus, error) {
+	device := getPonSimDevice(handler.device)
+	if device == nil {
+		return nil, errors.New("device does not have a clock")
+	}
+
+	if err := device.ResyncClock(); err != nil {
+		return nil, err
+	}
+
+	return makeClockStatus(device.Clock), nil
+}
+
+/*
+makeClockStatus converts the state of a device clock to its GRPC representation
+*/
+func makeClockStatus(clock *core.PonSimClock) *ponsim.ClockStatus {
+	return &ponsim.ClockStatus{
+		DriftPpm: clock.Drift(),
+		Time:     clock.Now().UnixNano(),
+		OffsetNs: int64(clock.Offset()),
+	}
+}
+
+/*
+StartConformance runs the conformance suite as a job whose report is attached to the job
+once completed
+*/
+func (handler *PonSimAdminHandler) StartConformance(
+	ctx context.Context,
+	empty *empty.Empty,
+) (*ponsim.Job, error) {
+	jobs, err := handler.getJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	return jobs.Start("conformance", core.RunConformanceJob).MakeProto(), nil
+}
+
+/*
+StartTraffic originates synthetic frames on the NNI of the OLT, or on the UNI of an ONU, as a
+job.  On an OLT, the request addressing the port of an ONU is relayed to the ONU, which runs
+the job.
+*/
+func (handler *PonSimAdminHandler) StartTraffic(
+	ctx context.Context,
+	request *ponsim.TrafficRequest,
+) (*ponsim.Job, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"request": request,
+	}).Info("Starting traffic generator")
+
+	if _, ok := handler.device.(*core.PonSimOltDevice); ok && request.Port != 0 {
+		_, client, err := handler.getOnu(request.Port)
+		if err != nil {
+			return nil, err
+		}
+
+		relayed := *request
+		relayed.Port = 0
+		return client.StartTraffic(ctx, &relayed)
+	}
+
+	if _, err := handler.getJobs(); err != nil {
+		return nil, err
+	}
+
+	traffic, err := core.NewPonSimTraffic(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return getPonSimDevice(handler.device).StartTraffic(traffic).MakeProto(), nil
+}
+
+/*
+StartPcapReplay replays the frames of a capture file on the NNI of the OLT, or on the UNI of
+an ONU, as a job.  On an OLT, the request addressing the port of an ONU is relayed to the ONU,
+which replays the file from its own capture directory.
+*/
+func (handler *PonSimAdminHandler) StartPcapReplay(
+	ctx context.Context,
+	request *ponsim.PcapReplayRequest,
+) (*ponsim.Job, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"request": request,
+	}).Info("Starting capture replay")
+
+	if _, ok := handler.device.(*core.PonSimOltDevice); ok && request.Port != 0 {
+		_, client, err := handler.getOnu(request.Port)
+		if err != nil {
+			return nil, err
+		}
+
+		relayed := *request
+		relayed.Port = 0
+		return client.StartPcapReplay(ctx, &relayed)
+	}
+
+	if _, err := handler.getJobs(); err != nil {
+		return nil, err
+	}
+
+	device := getPonSimDevice(handler.device)
+	replay, err := core.NewPonSimPcapReplay(device.PcapDir, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return device.StartPcapReplay(replay).MakeProto(), nil
+}
+
+/*
+ListJobs returns the running and recently finished jobs
+*/
+func (handler *PonSimAdminHandler) ListJobs(
+	ctx context.Context,
+	empty *empty.Empty,
+) (*ponsim.Jobs, error) {
+	jobs, err := handler.getJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	out := &ponsim.Jobs{}
+	for _, job := range jobs.List() {
+		out.Jobs = append(out.Jobs, job.MakeProto())
+	}
+
+	return out, nil
+}
+
+/*
+GetJob returns the progress and outcome of a job
+*/
+func (handler *PonSimAdminHandler) GetJob(
+	ctx context.Context,
+	request *ponsim.JobRequest,
+) (*ponsim.Job, error) {
+	if client, err := handler.getJobOnu(request); err != nil {
+		return nil, err
+	} else if client != nil {
+		return client.GetJob(ctx, &ponsim.JobRequest{Id: request.Id})
+	}
+
+	jobs, err := handler.getJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := jobs.Get(request.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return job.MakeProto(), nil
+}
+
+/*
+CancelJob requests a running job to stop
+*/
+func (handler *PonSimAdminHandler) CancelJob(
+	ctx context.Context,
+	request *ponsim.JobRequest,
+) (*ponsim.Job, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"job":     request.Id,
+	}).Info("Cancelling job")
+
+	if client, err := handler.getJobOnu(request); err != nil {
+		return nil, err
+	} else if client != nil {
+		return client.CancelJob(ctx, &ponsim.JobRequest{Id: request.Id})
+	}
+
+	jobs, err := handler.getJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := jobs.Cancel(request.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return job.MakeProto(), nil
+}
+
+/*
+getJobOnu returns the client of the admin API of the ONU running a job, when the request
+addresses the port of an ONU of the OLT handled
+*/
+func (handler *PonSimAdminHandler) getJobOnu(request *ponsim.JobRequest) (ponsim.PonSimAdminClient, error) {
+	if _, ok := handler.device.(*core.PonSimOltDevice); !ok || request.Port == 0 {
+		return nil, nil
+	}
+
+	_, client, err := handler.getOnu(request.Port)
+	return client, err
+}
+
+/*
+StopAllTraffic is a kill switch for runaway traffic.  It removes the simulated subscribers,
+stops the alarm simulation, disables the chaos controller, clears the injected faults and delays
+and cancels the running jobs, such as traffic generation and pcap replays.  On an OLT, the
+request is also forwarded to every registered ONU.
+*/
+func (handler *PonSimAdminHandler) StopAllTraffic(
+	ctx context.Context,
+	request *empty.Empty,
+) (*empty.Empty, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+	}).Warn("Stopping all traffic")
+
+	switch device := handler.device.(type) {
+	case *core.PonSimOltDevice:
+		device.StopAllTraffic()
+
+		// Stop as many ONUs as possible before reporting a failure
+		var failure error
+		for port, child := range device.GetOnus() {
+			if err := stopOnuTraffic(ctx, child); err != nil {
+				nbiLogger.WithFields(logrus.Fields{
+					"handler": handler,
+					"port":    port,
+					"error":   err.Error(),
+				}).Error("Problem stopping ONU traffic")
+				failure = err
+			}
+		}
+		if failure != nil {
+			return nil, failure
+		}
+	case *core.PonSimOnuDevice:
+		device.StopAllTraffic()
+	default:
+		return nil, errors.New("device does not support stopping traffic")
+	}
+
+	return &empty.Empty{}, nil
+}
+
+func stopOnuTraffic(ctx context.Context, child *core.OnuRegistree) error {
+	conn, _, err := onuConn(child)
+	if err != nil {
+		return err
+	}
+
+	_, err = ponsim.NewPonSimAdminClient(conn).StopAllTraffic(ctx, &empty.Empty{})
+	return err
+}
+
+/*
+TriggerAlarm raises an alarm on the OLT and clears it after the requested duration
+*/
+func (handler *PonSimAdminHandler) TriggerAlarm(
+	ctx context.Context,
+	request *ponsim.AlarmRequest,
+) (*empty.Empty, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"request": request,
+	}).Info("Triggering alarm")
+
+	olt, ok := handler.device.(*core.PonSimOltDevice)
+	if !ok {
+		return nil, errors.New("only an OLT raises alarms")
+	}
+
+	duration := time.Duration(request.DurationMs) * time.Millisecond
+	if err := olt.TriggerAlarm(request.Severity, request.Type, request.Category, duration); err != nil {
+		return nil, err
+	}
+
+	return &empty.Empty{}, nil
+}
+
+/*
+RemoveOnu removes the registration of an ONU from the OLT, as if the ONU had disconnected
+*/
+func (handler *PonSimAdminHandler) RemoveOnu(
+	ctx context.Context,
+	request *ponsim.OnuRequest,
+) (*empty.Empty, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"port":    request.Port,
+	}).Info("Removing ONU")
+
+	olt, ok := handler.device.(*core.PonSimOltDevice)
+	if !ok {
+		return nil, errors.New("only an OLT has ONUs")
+	}
+	if olt.GetOnu(request.Port) == nil {
+		return nil, fmt.Errorf("no ONU on port %d", request.Port)
+	}
+
+	if err := olt.RemoveOnu(ctx, request.Port); err != nil {
+		return nil, err
+	}
+
+	return &empty.Empty{}, nil
+}
+
+/*
+GetLag returns the status of the link aggregation group of the NNI
+*/
+func (handler *PonSimAdminHandler) GetLag(
+	ctx context.Context,
+	request *empty.Empty,
+) (*ponsim.LagStatus, error) {
+	olt, ok := handler.device.(*core.PonSimOltDevice)
+	if !ok {
+		return nil, errors.New("only an OLT has an NNI")
+	}
+	if olt.Lag == nil {
+		return nil, errors.New("no LAG is configured on the NNI")
+	}
+
+	return olt.Lag.MakeProto(), nil
+}
+
+/*
+SetLagMember fails or restores a member of the link aggregation group of the NNI
+*/
+func (handler *PonSimAdminHandler) SetLagMember(
+	ctx context.Context,
+	request *ponsim.LagMemberRequest,
+) (*ponsim.LagStatus, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"member":  request.Member,
+		"up":      request.Up,
+	}).Info("Setting LAG member status")
+
+	olt, ok := handler.device.(*core.PonSimOltDevice)
+	if !ok {
+		return nil, errors.New("only an OLT has an NNI")
+	}
+
+	if err := olt.SetLagMember(request.Member, request.Up); err != nil {
+		return nil, err
+	}
+
+	return olt.Lag.MakeProto(), nil
+}
+
+/*
+GetLldp returns the LLDP agent of the NNI along with the neighbour it discovered
+*/
+func (handler *PonSimAdminHandler) GetLldp(
+	ctx context.Context,
+	request *empty.Empty,
+) (*ponsim.LldpStatus, error) {
+	olt, ok := handler.device.(*core.PonSimOltDevice)
+	if !ok {
+		return nil, errors.New("only an OLT has an NNI")
+	}
+	if olt.Lldp == nil {
+		return nil, errors.New("no LLDP agent is configured on the NNI")
+	}
+
+	return olt.Lldp.MakeProto(), nil
+}
+
+/*
+SetLldp changes the chassis and port IDs and the interval of the LLDP frames sent on the NNI
+*/
+func (handler *PonSimAdminHandler) SetLldp(
+	ctx context.Context,
+	request *ponsim.LldpRequest,
+) (*ponsim.LldpStatus, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler":   handler,
+		"chassisId": request.ChassisId,
+		"portId":    request.PortId,
+		"interval":  request.Interval,
+	}).Info("Setting LLDP agent")
+
+	olt, ok := handler.device.(*core.PonSimOltDevice)
+	if !ok {
+		return nil, errors.New("only an OLT has an NNI")
+	}
+	if olt.Lldp == nil {
+		return nil, errors.New("no LLDP agent is configured on the NNI")
+	}
+
+	if err := olt.Lldp.Set(request.ChassisId, request.PortId, time.Duration(request.Interval)*time.Second); err != nil {
+		return nil, err
+	}
+
+	return olt.Lldp.MakeProto(), nil
+}
+
+/*
+ListDiscoveredOnus returns the ONUs which announced their serial number to the OLT
+*/
+func (handler *PonSimAdminHandler) ListDiscoveredOnus(
+	ctx context.Context,
+	request *empty.Empty,
+) (*ponsim.DiscoveredOnus, error) {
+	olt, ok := handler.device.(*core.PonSimOltDevice)
+	if !ok {
+		return nil, errors.New("only an OLT discovers ONUs")
+	}
+	if olt.OnuActivation == nil {
+		return nil, errors.New("ONUs are activated automatically")
+	}
+
+	reply := &ponsim.DiscoveredOnus{}
+	for _, discovery := range olt.OnuActivation.GetDiscovered() {
+		reply.Onus = append(reply.Onus, &ponsim.DiscoveredOnu{
+			SerialNumber: discovery.SerialNumber,
+			VendorId:     discovery.VendorId,
+			PonPort:      discovery.PonPort,
+			State:        discovery.State,
+			DiscoveredAt: discovery.DiscoveredAt.UnixNano(),
+		})
+	}
+
+	return reply, nil
+}
+
+/*
+ActivateOnu activates a discovered ONU so that it completes its registration
+*/
+func (handler *PonSimAdminHandler) ActivateOnu(
+	ctx context.Context,
+	request *ponsim.OnuActivationRequest,
+) (*empty.Empty, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler":      handler,
+		"serialNumber": request.SerialNumber,
+	}).Info("Activating ONU")
+
+	olt, ok := handler.device.(*core.PonSimOltDevice)
+	if !ok {
+		return nil, errors.New("only an OLT activates ONUs")
+	}
+
+	if err := olt.ActivateOnu(request.SerialNumber); err != nil {
+		return nil, err
+	}
+
+	return &empty.Empty{}, nil
+}
+
+/*
+SetGemEncryption enables or disables the encryption of a GEM port of an ONU
+*/
+func (handler *PonSimAdminHandler) SetGemEncryption(
+	ctx context.Context,
+	request *ponsim.GemEncryptionRequest,
+) (*ponsim.GemEncryptionStatus, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler":   handler,
+		"port":      request.Port,
+		"gemPort":   request.GemPort,
+		"encrypted": request.Encrypted,
+	}).Info("Setting GEM port encryption")
+
+	olt, ok := handler.device.(*core.PonSimOltDevice)
+	if !ok {
+		return nil, errors.New("only an OLT encrypts GEM ports")
+	}
+
+	gem, err := olt.SetGemEncryption(ctx, request.Port, request.GemPort, request.Encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	return newGemEncryptionStatus(request.Port, gem), nil
+}
+
+/*
+SwitchGemKey exchanges a new key for an encrypted GEM port of an ONU
+*/
+func (handler *PonSimAdminHandler) SwitchGemKey(
+	ctx context.Context,
+	request *ponsim.GemKeyRequest,
+) (*ponsim.GemEncryptionStatus, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"port":    request.Port,
+		"gemPort": request.GemPort,
+	}).Info("Switching GEM port key")
+
+	olt, ok := handler.device.(*core.PonSimOltDevice)
+	if !ok {
+		return nil, errors.New("only an OLT encrypts GEM ports")
+	}
+
+	gem, err := olt.SwitchGemKey(ctx, request.Port, request.GemPort)
+	if err != nil {
+		return nil, err
+	}
+
+	return newGemEncryptionStatus(request.Port, gem), nil
+}
+
+/*
+SetKeyExchangeFaults makes the next key requests sent to an ONU fail
+*/
+func (handler *PonSimAdminHandler) SetKeyExchangeFaults(
+	ctx context.Context,
+	request *ponsim.KeyExchangeFaults,
+) (*empty.Empty, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler":  handler,
+		"port":     request.Port,
+		"failures": request.Failures,
+	}).Info("Injecting key exchange faults")
+
+	olt, ok := handler.device.(*core.PonSimOltDevice)
+	if !ok {
+		return nil, errors.New("only an OLT encrypts GEM ports")
+	}
+
+	if err := olt.SetKeyExchangeFaults(request.Port, int(request.Failures)); err != nil {
+		return nil, err
+	}
+
+	return &empty.Empty{}, nil
+}
+
+/*
+SetOnuFec configures the forward error correction and the bit error rate of the link of an ONU
+*/
+func (handler *PonSimAdminHandler) SetOnuFec(
+	ctx context.Context,
+	request *ponsim.OnuFecRequest,
+) (*ponsim.OnuFecStatus, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler":      handler,
+		"port":         request.Port,
+		"enabled":      request.Enabled,
+		"bitErrorRate": request.BitErrorRate,
+	}).Info("Configuring ONU FEC")
+
+	olt, ok := handler.device.(*core.PonSimOltDevice)
+	if !ok {
+		return nil, errors.New("only an OLT configures the FEC of ONUs")
+	}
+
+	fec, err := olt.SetOnuFec(request.Port, request.Enabled, request.BitErrorRate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ponsim.OnuFecStatus{
+		Port:         request.Port,
+		Enabled:      request.Enabled,
+		BitErrorRate: request.BitErrorRate,
+		Upstream:     newFecCounters(fec.GetCounters(core.FEC_UPSTREAM)),
+		Downstream:   newFecCounters(fec.GetCounters(core.FEC_DOWNSTREAM)),
+	}, nil
+}
+
+/*
+SetOnuOptics configures the launch power of an ONU and the degradation of its optical link
+*/
+func (handler *PonSimAdminHandler) SetOnuOptics(
+	ctx context.Context,
+	request *ponsim.OnuOpticsRequest,
+) (*ponsim.OnuOpticsStatus, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler":     handler,
+		"port":        request.Port,
+		"degradation": request.DegradationDb,
+		"setTxPower":  request.SetTxPower,
+		"txPower":     request.TxPowerDbm,
+	}).Info("Configuring ONU optics")
+
+	olt, ok := handler.device.(*core.PonSimOltDevice)
+	if !ok {
+		return nil, errors.New("only an OLT configures the optics of ONUs")
+	}
+
+	onu := olt.GetOnu(request.Port)
+	if onu == nil || onu.Optics == nil {
+		return nil, fmt.Errorf("no ONU on port %d", request.Port)
+	}
+
+	txPower := onu.Optics.GetTxPower()
+	if request.SetTxPower {
+		txPower = request.TxPowerDbm
+	}
+
+	optics, err := olt.SetOnuOptics(request.Port, txPower, request.DegradationDb)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ponsim.OnuOpticsStatus{
+		Port:          request.Port,
+		DegradationDb: request.DegradationDb,
+		OnuTxPowerDbm: optics.GetTxPower(),
+		OnuRxPowerDbm: optics.GetRxPower(olt.GetOnuOltTxPower(request.Port)),
+		OltRxPowerDbm: optics.GetOltRxPower(),
+		Los:           optics.IsLos(),
+	}, nil
+}
+
+/*
+SetDiagnostics changes the baseline readings and the noise of the transceiver diagnostics of the
+device, or of an ONU when an OLT is addressed with the port of the ONU
+*/
+func (handler *PonSimAdminHandler) SetDiagnostics(
+	ctx context.Context,
+	request *ponsim.DiagnosticsRequest,
+) (*empty.Empty, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"request": request,
+	}).Info("Setting diagnostics")
+
+	if olt, ok := handler.device.(*core.PonSimOltDevice); ok && request.Port != 0 {
+		child := olt.GetOnu(request.Port)
+		if child == nil {
+			return nil, fmt.Errorf("no ONU on port %d", request.Port)
+		}
+
+		conn, _, err := onuConn(child)
+		if err != nil {
+			return nil, err
+		}
+
+		relayed := *request
+		relayed.Port = 0
+		return ponsim.NewPonSimAdminClient(conn).SetDiagnostics(ctx, &relayed)
+	}
+
+	device := getPonSimDevice(handler.device)
+	if device == nil || device.Diagnostics == nil {
+		return nil, errors.New("device does not support diagnostics")
+	}
+
+	baseline := core.PonSimDdmValues{
+		Temperature: request.TemperatureC,
+		Voltage:     request.VoltageV,
+		BiasCurrent: request.BiasCurrentMa,
+	}
+	if err := device.Diagnostics.Set(baseline, request.Noise); err != nil {
+		return nil, err
+	}
+
+	return &empty.Empty{}, nil
+}
+
+/*
+StartImageDownload starts the download of a software image to an ONU
+*/
+func (handler *PonSimAdminHandler) StartImageDownload(
+	ctx context.Context,
+	request *ponsim.ImageDownloadRequest,
+) (*ponsim.ImageStatus, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"request": request,
+	}).Info("Starting image download")
+
+	onu, client, err := handler.getOnu(request.Port)
+	if err != nil {
+		return nil, err
+	} else if client != nil {
+		relayed := *request
+		relayed.Port = 0
+		status, err := client.StartImageDownload(ctx, &relayed)
+		return relayedImageStatus(request.Port, status, err)
+	}
+
+	if err := onu.StartImageDownload(request.Version, int(request.Size), request.Crc, int(request.Rate)); err != nil {
+		return nil, err
+	}
+
+	return newImageStatus(request.Port, onu), nil
+}
+
+/*
+CancelImageDownload stops the download of a software image to an ONU
+*/
+func (handler *PonSimAdminHandler) CancelImageDownload(
+	ctx context.Context,
+	request *ponsim.ImageRequest,
+) (*ponsim.ImageStatus, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"port":    request.Port,
+	}).Info("Cancelling image download")
+
+	onu, client, err := handler.getOnu(request.Port)
+	if err != nil {
+		return nil, err
+	} else if client != nil {
+		status, err := client.CancelImageDownload(ctx, &ponsim.ImageRequest{})
+		return relayedImageStatus(request.Port, status, err)
+	}
+
+	if err := onu.CancelImageDownload(); err != nil {
+		return nil, err
+	}
+
+	return newImageStatus(request.Port, onu), nil
+}
+
+/*
+ActivateImage makes an ONU reboot into its downloaded image
+*/
+func (handler *PonSimAdminHandler) ActivateImage(
+	ctx context.Context,
+	request *ponsim.ImageRequest,
+) (*ponsim.ImageStatus, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"port":    request.Port,
+	}).Info("Activating image")
+
+	onu, client, err := handler.getOnu(request.Port)
+	if err != nil {
+		return nil, err
+	} else if client != nil {
+		status, err := client.ActivateImage(ctx, &ponsim.ImageRequest{})
+		return relayedImageStatus(request.Port, status, err)
+	}
+
+	if err := onu.ActivateImage(ctx); err != nil {
+		return nil, err
+	}
+
+	return newImageStatus(request.Port, onu), nil
+}
+
+/*
+CommitImage makes an ONU boot its active image
+*/
+func (handler *PonSimAdminHandler) CommitImage(
+	ctx context.Context,
+	request *ponsim.ImageRequest,
+) (*ponsim.ImageStatus, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"port":    request.Port,
+	}).Info("Committing image")
+
+	onu, client, err := handler.getOnu(request.Port)
+	if err != nil {
+		return nil, err
+	} else if client != nil {
+		status, err := client.CommitImage(ctx, &ponsim.ImageRequest{})
+		return relayedImageStatus(request.Port, status, err)
+	}
+
+	if err := onu.CommitImage(); err != nil {
+		return nil, err
+	}
+
+	return newImageStatus(request.Port, onu), nil
+}
+
+/*
+GetImageStatus returns the software images of an ONU and the progress of the last download
+*/
+func (handler *PonSimAdminHandler) GetImageStatus(
+	ctx context.Context,
+	request *ponsim.ImageRequest,
+) (*ponsim.ImageStatus, error) {
+	onu, client, err := handler.getOnu(request.Port)
+	if err != nil {
+		return nil, err
+	} else if client != nil {
+		status, err := client.GetImageStatus(ctx, &ponsim.ImageRequest{})
+		return relayedImageStatus(request.Port, status, err)
+	}
+
+	return newImageStatus(request.Port, onu), nil
+}
+
+/*
+SetImageFault injects a fault into the next download or activation of an image on an ONU
+*/
+func (handler *PonSimAdminHandler) SetImageFault(
+	ctx context.Context,
+	request *ponsim.ImageFaultRequest,
+) (*empty.Empty, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"port":    request.Port,
+		"fault":   request.Fault,
+	}).Info("Setting image fault")
+
+	onu, client, err := handler.getOnu(request.Port)
+	if err != nil {
+		return nil, err
+	} else if client != nil {
+		return client.SetImageFault(ctx, &ponsim.ImageFaultRequest{Fault: request.Fault})
+	}
+
+	if err := onu.SetImageFault(request.Fault); err != nil {
+		return nil, err
+	}
+
+	return &empty.Empty{}, nil
+}
+
+/*
+getOnu returns the ONU handled, or the client of the admin API of the ONU on a port of the OLT
+handled to which the requests addressing the ONU are relayed
+*/
+func (handler *PonSimAdminHandler) getOnu(port int32) (*core.PonSimOnuDevice, ponsim.PonSimAdminClient, error) {
+	switch device := handler.device.(type) {
+	case *core.PonSimOnuDevice:
+		return device, nil, nil
+	case *core.PonSimOltDevice:
+		child := device.GetOnu(port)
+		if child == nil {
+			return nil, nil, fmt.Errorf("no ONU on port %d", port)
+		}
+
+		conn, _, err := onuConn(child)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return nil, ponsim.NewPonSimAdminClient(conn), nil
+	default:
+		return nil, nil, errors.New("device does not handle ONUs")
+	}
+}
+
+/*
+relayedImageStatus returns the image status replied by an ONU, addressed by its port on the OLT
+*/
+func relayedImageStatus(port int32, status *ponsim.ImageStatus, err error) (*ponsim.ImageStatus, error) {
+	if err != nil {
+		return nil, err
+	}
+	status.Port = port
+
+	return status, nil
+}
+
+func newImageStatus(port int32, onu *core.PonSimOnuDevice) *ponsim.ImageStatus {
+	images, download := onu.GetImages()
+
+	status := &ponsim.ImageStatus{
+		Port: port,
+		Download: &ponsim.ImageDownloadStatus{
+			Version:     download.Version,
+			Slot:        uint32(download.Slot),
+			Size:        uint32(download.Size),
+			Transferred: uint32(download.Transferred),
+			State:       download.State,
+			Reason:      download.Reason,
+		},
+	}
+	for slot, image := range images {
+		status.Images = append(status.Images, &ponsim.ImageSlot{
+			Slot:      uint32(slot),
+			Version:   image.Version,
+			Size:      uint32(image.Size),
+			Crc:       image.Crc,
+			Valid:     image.Valid,
+			Active:    image.Active,
+			Committed: image.Committed,
+		})
+	}
+
+	return status
+}
+
+/*
+EnableOnu resumes the operation of an ONU disabled by DisableOnu
+*/
+func (handler *PonSimAdminHandler) EnableOnu(
+	ctx context.Context,
+	request *ponsim.OnuRequest,
+) (*ponsim.OnuState, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"port":    request.Port,
+	}).Info("Enabling ONU")
+
+	onu, client, err := handler.getOnu(request.Port)
+	if err != nil {
+		return nil, err
+	} else if client != nil {
+		state, err := client.EnableOnu(ctx, &ponsim.OnuRequest{})
+		return relayedOnuState(request.Port, state, err)
+	}
+
+	if err := onu.EnableOnu(); err != nil {
+		return nil, err
+	}
+
+	return newOnuState(request.Port, onu), nil
+}
+
+/*
+DisableOnu administratively disables an ONU, which drops the frames of its subscribers until
+it is enabled again
+*/
+func (handler *PonSimAdminHandler) DisableOnu(
+	ctx context.Context,
+	request *ponsim.OnuRequest,
+) (*ponsim.OnuState, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"port":    request.Port,
+	}).Info("Disabling ONU")
+
+	onu, client, err := handler.getOnu(request.Port)
+	if err != nil {
+		return nil, err
+	} else if client != nil {
+		state, err := client.DisableOnu(ctx, &ponsim.OnuRequest{})
+		return relayedOnuState(request.Port, state, err)
+	}
+
+	if err := onu.DisableOnu(); err != nil {
+		return nil, err
+	}
+
+	return newOnuState(request.Port, onu), nil
+}
+
+/*
+relayedOnuState returns the state replied by an ONU, addressed by its port on the OLT
+*/
+func relayedOnuState(port int32, state *ponsim.OnuState, err error) (*ponsim.OnuState, error) {
+	if err != nil {
+		return nil, err
+	}
+	state.Port = port
+
+	return state, nil
+}
+
+func newOnuState(port int32, onu *core.PonSimOnuDevice) *ponsim.OnuState {
+	state, reason, since := onu.GetOnuState()
+
+	return &ponsim.OnuState{Port: port, State: state, Reason: reason, Since: since.UnixNano()}
+}
+
+/*
+GetCurrentPm returns the counters accumulated so far during the current PM interval
+*/
+func (handler *PonSimAdminHandler) GetCurrentPm(
+	ctx context.Context,
+	request *ponsim.PmRequest,
+) (*ponsim.PmInterval, error) {
+	pm, err := handler.getPm()
+	if err != nil {
+		return nil, err
+	}
+
+	interval, err := pm.GetCurrent(request.PortName)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPmInterval(interval), nil
+}
+
+/*
+GetPmHistory returns the completed PM intervals retained, the most recent first
+*/
+func (handler *PonSimAdminHandler) GetPmHistory(
+	ctx context.Context,
+	request *ponsim.PmRequest,
+) (*ponsim.PmHistory, error) {
+	pm, err := handler.getPm()
+	if err != nil {
+		return nil, err
+	}
+
+	history := &ponsim.PmHistory{}
+	for _, interval := range pm.GetHistory(request.PortName) {
+		history.Intervals = append(history.Intervals, newPmInterval(interval))
+	}
+
+	return history, nil
+}
+
+/*
+SetPmThreshold configures the threshold of a PM counter, raising a TCA when it is crossed
+*/
+func (handler *PonSimAdminHandler) SetPmThreshold(
+	ctx context.Context,
+	request *ponsim.PmThreshold,
+) (*empty.Empty, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler":  handler,
+		"portName": request.PortName,
+		"counter":  request.Counter,
+		"value":    request.Value,
+	}).Info("Setting PM threshold")
+
+	pm, err := handler.getPm()
+	if err != nil {
+		return nil, err
+	}
+	if request.PortName == "" || request.Counter == "" {
+		return nil, errors.New("a threshold applies to a counter of a port")
+	}
+
+	pm.SetThreshold(core.PonSimThreshold{Port: request.PortName, Counter: request.Counter, Value: request.Value})
+
+	return &empty.Empty{}, nil
+}
+
+/*
+ListPmThresholds returns the thresholds of the PM counters
+*/
+func (handler *PonSimAdminHandler) ListPmThresholds(
+	ctx context.Context,
+	request *empty.Empty,
+) (*ponsim.PmThresholds, error) {
+	pm, err := handler.getPm()
+	if err != nil {
+		return nil, err
+	}
+
+	thresholds := &ponsim.PmThresholds{}
+	for _, threshold := range pm.GetThresholds() {
+		thresholds.Thresholds = append(thresholds.Thresholds, &ponsim.PmThreshold{
+			PortName: threshold.Port,
+			Counter:  threshold.Counter,
+			Value:    threshold.Value,
+		})
+	}
+
+	return thresholds, nil
+}
+
+/*
+GetFrameLogSampling reports how many of the entries logged for every frame are emitted
+*/
+func (handler *PonSimAdminHandler) GetFrameLogSampling(
+	ctx context.Context,
+	request *empty.Empty,
+) (*ponsim.FrameLogSampling, error) {
+	return makeFrameLogSampling(common.Logger().FrameSampler()), nil
+}
+
+/*
+SetFrameLogSampling changes the sampling rate of the entries logged for every frame and the
+number of them emitted per second
+*/
+func (handler *PonSimAdminHandler) SetFrameLogSampling(
+	ctx context.Context,
+	request *ponsim.FrameLogSampling,
+) (*ponsim.FrameLogSampling, error) {
+	sampler := common.Logger().FrameSampler()
+	if err := sampler.Set(int(request.Rate), int(request.Limit)); err != nil {
+		return nil, err
+	}
+
+	nbiLogger.WithFields(logrus.Fields{
+		"rate":  request.Rate,
+		"limit": request.Limit,
+	}).Info("Changed frame log sampling")
+
+	return makeFrameLogSampling(sampler), nil
+}
+
+/*
+makeFrameLogSampling converts the state of the frame log sampler to its GRPC representation
+*/
+func makeFrameLogSampling(sampler *common.LogSampler) *ponsim.FrameLogSampling {
+	rate, limit, suppressed := sampler.Get()
+
+	return &ponsim.FrameLogSampling{
+		Rate:       uint32(rate),
+		Limit:      uint32(limit),
+		Suppressed: suppressed,
+	}
+}
+
+/*
+GetLogLevels reports the level of the entries of each component
+*/
+func (handler *PonSimAdminHandler) GetLogLevels(
+	ctx context.Context,
+	request *empty.Empty,
+) (*ponsim.LogLevels, error) {
+	return makeLogLevels(), nil
+}
+
+/*
+SetLogLevel changes the level of the entries of a component, e.g. to debug the management of
+the device while the forwarding only logs warnings
+*/
+func (handler *PonSimAdminHandler) SetLogLevel(
+	ctx context.Context,
+	request *ponsim.ComponentLogLevel,
+) (*ponsim.LogLevels, error) {
+	if err := common.Logger().SetComponentLevel(request.Component, request.Level); err != nil {
+		return nil, err
+	}
+
+	nbiLogger.WithFields(logrus.Fields{
+		"component": request.Component,
+		"logLevel":  request.Level,
+	}).Info("Changed log level")
+
+	return makeLogLevels(), nil
+}
+
+/*
+makeLogLevels converts the levels of the components to their GRPC representation
+*/
+func makeLogLevels() *ponsim.LogLevels {
+	levels := common.Logger().ComponentLevels()
+
+	var components []string
+	for component := range levels {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+
+	reply := &ponsim.LogLevels{}
+	for _, component := range components {
+		reply.Levels = append(reply.Levels, &ponsim.ComponentLogLevel{
+			Component: component,
+			Level:     levels[component],
+		})
+	}
+
+	return reply
+}
+
+/*
+GetChaos reports the catalog of faults randomly injected into the device
+*/
+func (handler *PonSimAdminHandler) GetChaos(
+	ctx context.Context,
+	request *empty.Empty,
+) (*ponsim.ChaosStatus, error) {
+	chaos, err := handler.getChaos()
+	if err != nil {
+		return nil, err
+	}
+
+	return makeChaosStatus(chaos), nil
+}
+
+/*
+SetChaos enables or disables the random injection of faults into the device, replacing the
+catalog of faults when one is specified
+*/
+func (handler *PonSimAdminHandler) SetChaos(
+	ctx context.Context,
+	request *ponsim.ChaosRequest,
+) (*ponsim.ChaosStatus, error) {
+	chaos, err := handler.getChaos()
+	if err != nil {
+		return nil, err
+	}
+
+	_, catalog := chaos.Get()
+	if request.Catalog != "" {
+		if catalog, err = core.ParseChaosCatalog(request.Catalog); err != nil {
+			return nil, err
+		}
+	}
+	if request.Enabled && len(catalog) == 0 {
+		return nil, errors.New("the chaos catalog is empty")
+	}
+	chaos.Set(request.Enabled, catalog)
+
+	nbiLogger.WithFields(logrus.Fields{
+		"enabled": request.Enabled,
+		"catalog": catalog,
+	}).Info("Changed chaos injection")
+
+	return makeChaosStatus(chaos), nil
+}
+
+func (handler *PonSimAdminHandler) getChaos() (*core.PonSimChaos, error) {
+	device := getPonSimDevice(handler.device)
+	if device == nil || device.Chaos == nil {
+		return nil, errors.New("device does not support chaos injection")
+	}
+
+	return device.Chaos, nil
+}
+
+/*
+makeChaosStatus converts the state of the chaos controller to its GRPC representation
+*/
+func makeChaosStatus(chaos *core.PonSimChaos) *ponsim.ChaosStatus {
+	enabled, catalog := chaos.Get()
+
+	status := &ponsim.ChaosStatus{
+		Enabled:  enabled,
+		Interval: uint32(chaos.Interval / time.Second),
+		Injected: uint64(atomic.LoadInt64(&chaos.Injected)),
+	}
+	for _, name := range core.CHAOS_FAULTS {
+		if fault, ok := catalog[name]; ok {
+			status.Faults = append(status.Faults, &ponsim.ChaosFault{
+				Fault:       name,
+				Probability: fault.Probability,
+				DurationMs:  uint32(fault.Duration / time.Millisecond),
+			})
+		}
+	}
+
+	return status
+}
+
+/*
+GetMirrors returns the mirrors of the ports of the device and the open mirror streams
+*/
+func (handler *PonSimAdminHandler) GetMirrors(
+	ctx context.Context,
+	request *empty.Empty,
+) (*ponsim.MirrorStatus, error) {
+	mirror, err := handler.getMirror()
+	if err != nil {
+		return nil, err
+	}
+
+	return makeMirrorStatus(mirror), nil
+}
+
+/*
+SetMirror mirrors the frames of a port to another port of the device or to a capture file,
+replacing the previous mirror of the port, or removes its mirror when it is disabled
+*/
+func (handler *PonSimAdminHandler) SetMirror(
+	ctx context.Context,
+	request *ponsim.MirrorRequest,
+) (*ponsim.MirrorStatus, error) {
+	mirror, err := handler.getMirror()
+	if err != nil {
+		return nil, err
+	}
+
+	var session *core.PonSimMirrorSession
+	if request.Enabled {
+		if session, err = core.NewPonSimMirrorSession(getPonSimDevice(handler.device).PcapDir, request); err != nil {
+			return nil, err
+		}
+	}
+	mirror.Set(int(request.Port), session)
+
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"request": request,
+	}).Info("Changed port mirror")
+
+	return makeMirrorStatus(mirror), nil
+}
+
+/*
+StreamMirror streams the frames received and/or sent on a port until the stream is closed.
+Frames are dropped when the stream is not keeping up.
+*/
+func (handler *PonSimAdminHandler) StreamMirror(
+	request *ponsim.MirrorRequest,
+	stream ponsim.PonSimAdmin_StreamMirrorServer,
+) error {
+	mirror, err := handler.getMirror()
+	if err != nil {
+		return err
+	}
+
+	id, frames, err := mirror.Subscribe(int(request.Port), request.Direction, request.Filter)
+	if err != nil {
+		return err
+	}
+	defer mirror.Unsubscribe(id)
+
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"request": request,
+	}).Info("Streaming port mirror")
+
+	for {
+		select {
+		case frame, ok := <-frames:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(frame); err != nil {
+				nbiLogger.WithFields(logrus.Fields{
+					"handler": handler,
+					"error":   err,
+				}).Error("Failed to send mirrored frame")
+				return err
+			}
+		case <-stream.Context().Done():
+			nbiLogger.WithFields(logrus.Fields{
+				"handler": handler,
+				"error":   stream.Context().Err(),
+			}).Info("Closing port mirror stream")
+			return stream.Context().Err()
+		}
+	}
+}
+
+/*
+GetArpBindings returns the addresses for which an ONU answers the ARP requests received on its UNI
+*/
+func (handler *PonSimAdminHandler) GetArpBindings(
+	ctx context.Context,
+	request *ponsim.OnuRequest,
+) (*ponsim.ArpBindings, error) {
+	onu, client, err := handler.getOnu(request.Port)
+	if err != nil {
+		return nil, err
+	} else if client != nil {
+		bindings, err := client.GetArpBindings(ctx, &ponsim.OnuRequest{})
+		return relayedArpBindings(request.Port, bindings, err)
+	}
+
+	return newArpBindings(request.Port, onu), nil
+}
+
+/*
+SetArpBinding makes an ONU answer the ARP requests for an address with a hardware address, or
+stops answering them when no hardware address is specified
+*/
+func (handler *PonSimAdminHandler) SetArpBinding(
+	ctx context.Context,
+	request *ponsim.ArpBindingRequest,
+) (*ponsim.ArpBindings, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"request": request,
+	}).Info("Changing ARP binding")
+
+	onu, client, err := handler.getOnu(request.Port)
+	if err != nil {
+		return nil, err
+	} else if client != nil {
+		relayed := *request
+		relayed.Port = 0
+		bindings, err := client.SetArpBinding(ctx, &relayed)
+		return relayedArpBindings(request.Port, bindings, err)
+	}
+
+	if request.Mac == "" {
+		ip := net.ParseIP(request.Ip)
+		if ip == nil || !onu.RemoveArpBinding(ip) {
+			return nil, fmt.Errorf("unknown ARP binding: %s", request.Ip)
+		}
+	} else {
+		binding, err := core.NewPonSimArpBinding(request.Ip, request.Mac)
+		if err != nil {
+			return nil, err
+		}
+		onu.SetArpBinding(binding)
+	}
+
+	return newArpBindings(request.Port, onu), nil
+}
+
+/*
+GetEchoResponder returns the loss and latency of the ICMP echo replies of the subscriber hosts
+of an ONU
+*/
+func (handler *PonSimAdminHandler) GetEchoResponder(
+	ctx context.Context,
+	request *ponsim.OnuRequest,
+) (*ponsim.EchoResponderStatus, error) {
+	onu, client, err := handler.getOnu(request.Port)
+	if err != nil {
+		return nil, err
+	} else if client != nil {
+		status, err := client.GetEchoResponder(ctx, &ponsim.OnuRequest{})
+		return relayedEchoResponderStatus(request.Port, status, err)
+	}
+
+	return newEchoResponderStatus(request.Port, onu), nil
+}
+
+/*
+SetEchoResponder changes the loss and latency of the ICMP echo replies of the subscriber hosts
+of an ONU
+*/
+func (handler *PonSimAdminHandler) SetEchoResponder(
+	ctx context.Context,
+	request *ponsim.EchoResponderRequest,
+) (*ponsim.EchoResponderStatus, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"request": request,
+	}).Info("Configuring ICMP echo responder")
+
+	onu, client, err := handler.getOnu(request.Port)
+	if err != nil {
+		return nil, err
+	} else if client != nil {
+		relayed := *request
+		relayed.Port = 0
+		status, err := client.SetEchoResponder(ctx, &relayed)
+		return relayedEchoResponderStatus(request.Port, status, err)
+	}
+
+	latency := time.Duration(request.LatencyMs) * time.Millisecond
+	if err := onu.GetEchoResponder().Set(request.LossPercent, latency); err != nil {
+		return nil, err
+	}
+
+	return newEchoResponderStatus(request.Port, onu), nil
+}
+
+/*
+newEchoResponderStatus converts the state of the ICMP echo responder of an ONU to its GRPC
+representation
+*/
+func newEchoResponderStatus(port int32, onu *core.PonSimOnuDevice) *ponsim.EchoResponderStatus {
+	loss, latency, replies, lost := onu.GetEchoResponder().Get()
+
+	return &ponsim.EchoResponderStatus{
+		Port:        port,
+		LossPercent: loss,
+		LatencyMs:   uint32(latency / time.Millisecond),
+		Replies:     replies,
+		Lost:        lost,
+	}
+}
+
+/*
+relayedEchoResponderStatus returns the ICMP echo responder status replied by an ONU, addressed by
+its port on the OLT
+*/
+func relayedEchoResponderStatus(
+	port int32,
+	status *ponsim.EchoResponderStatus,
+	err error,
+) (*ponsim.EchoResponderStatus, error) {
+	if err != nil {
+		return nil, err
+	}
+	status.Port = port
+
+	return status, nil
+}
+
+/*
+newArpBindings converts the ARP bindings of an ONU to their GRPC representation
+*/
+func newArpBindings(port int32, onu *core.PonSimOnuDevice) *ponsim.ArpBindings {
+	bindings := &ponsim.ArpBindings{Port: port}
+	for _, binding := range onu.GetArpBindings() {
+		bindings.Bindings = append(bindings.Bindings, &ponsim.ArpBinding{
+			Ip:      binding.Ip.String(),
+			Mac:     binding.Mac.String(),
+			Replies: binding.Replies,
+		})
+	}
+
+	return bindings
+}
+
+/*
+relayedArpBindings returns the ARP bindings replied by an ONU, addressed by its port on the OLT
+*/
+func relayedArpBindings(port int32, bindings *ponsim.ArpBindings, err error) (*ponsim.ArpBindings, error) {
+	if err != nil {
+		return nil, err
+	}
+	bindings.Port = port
+
+	return bindings, nil
+}
+
+func (handler *PonSimAdminHandler) getMirror() (*core.PonSimMirror, error) {
+	device := getPonSimDevice(handler.device)
+	if device == nil || device.Mirror == nil {
+		return nil, errors.New("device does not support port mirroring")
+	}
+
+	return device.Mirror, nil
+}
+
+/*
+makeMirrorStatus converts the mirrors of a device to their GRPC representation
+*/
+func makeMirrorStatus(mirror *core.PonSimMirror) *ponsim.MirrorStatus {
+	status := &ponsim.MirrorStatus{}
+	for _, session := range mirror.List() {
+		status.Sessions = append(status.Sessions, session.MakeProto())
+	}
+
+	return status
+}
+
+func (handler *PonSimAdminHandler) getPm() (*core.PonSimPm, error) {
+	device := getPonSimDevice(handler.device)
+	if device == nil || device.Pm == nil {
+		return nil, errors.New("PM collection is not enabled")
+	}
+
+	return device.Pm, nil
+}
+
+func newPmInterval(interval *core.PonSimPmInterval) *ponsim.PmInterval {
+	pmInterval := &ponsim.PmInterval{
+		EndTime: interval.EndTime,
+		Start:   interval.Start.UnixNano(),
+		End:     interval.End.UnixNano(),
+		Suspect: interval.Suspect,
+	}
+	for _, port := range interval.GetPorts() {
+		portCounters := &ponsim.PmPortCounters{PortName: port}
+
+		names := make([]string, 0, len(interval.Counters[port]))
+		for name := range interval.Counters[port] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			portCounters.Counters = append(portCounters.Counters, &ponsim.PmCounter{
+				Name:  name,
+				Value: interval.Counters[port][name],
+			})
+		}
+		pmInterval.Ports = append(pmInterval.Ports, portCounters)
+	}
+
+	return pmInterval
+}
+
+func newFecCounters(counters core.PonSimFecCounters) *ponsim.FecCounters {
+	return &ponsim.FecCounters{
+		Codewords:              counters.Codewords,
+		CorrectedCodewords:     counters.CorrectedCodewords,
+		UncorrectableCodewords: counters.UncorrectableCodewords,
+		Frames:                 counters.Frames,
+		ErroredFrames:          counters.ErroredFrames,
+		ResidualErrorRate:      counters.ResidualErrorRate(),
+	}
+}
+
+func newGemEncryptionStatus(port int32, gem *core.PonSimGemPort) *ponsim.GemEncryptionStatus {
+	return &ponsim.GemEncryptionStatus{
+		Port:      port,
+		GemPort:   gem.GemId,
+		Encrypted: gem.Encrypted,
+		KeyIndex:  gem.KeyIndex,
+		KeyState:  gem.KeyState,
+	}
+}
+
+/*
+GetMeps returns the maintenance end points of the NNI of an OLT or of the UNI of an ONU
+*/
+func (handler *PonSimAdminHandler) GetMeps(
+	ctx context.Context,
+	request *ponsim.OnuRequest,
+) (*ponsim.Meps, error) {
+	cfm, client, err := handler.getCfm(request.Port)
+	if err != nil {
+		return nil, err
+	} else if client != nil {
+		meps, err := client.GetMeps(ctx, &ponsim.OnuRequest{})
+		return relayedMeps(request.Port, meps, err)
+	}
+
+	return newMeps(request.Port, cfm), nil
+}
+
+/*
+SetMep adds, changes or removes a maintenance end point
+*/
+func (handler *PonSimAdminHandler) SetMep(
+	ctx context.Context,
+	request *ponsim.MepRequest,
+) (*ponsim.Meps, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"request": request,
+	}).Info("Configuring maintenance end point")
+
+	cfm, client, err := handler.getCfm(request.Port)
+	if err != nil {
+		return nil, err
+	} else if client != nil {
+		relayed := *request
+		relayed.Port = 0
+		meps, err := client.SetMep(ctx, &relayed)
+		return relayedMeps(request.Port, meps, err)
+	}
+
+	if !request.Enabled {
+		if err := cfm.Remove(uint16(request.MepId)); err != nil {
+			return nil, err
+		}
+	} else if mep, err := core.NewPonSimMep(request); err != nil {
+		return nil, err
+	} else if err := cfm.Set(mep); err != nil {
+		return nil, err
+	}
+
+	return newMeps(request.Port, cfm), nil
+}
+
+/*
+CfmLoopback sends loopback messages from a maintenance end point and waits for the replies
+*/
+func (handler *PonSimAdminHandler) CfmLoopback(
+	ctx context.Context,
+	request *ponsim.CfmLoopbackRequest,
+) (*ponsim.CfmLoopbackResult, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"request": request,
+	}).Info("Sending CFM loopback messages")
+
+	cfm, client, err := handler.getCfm(request.Port)
+	if err != nil {
+		return nil, err
+	} else if client != nil {
+		relayed := *request
+		relayed.Port = 0
+		result, err := client.CfmLoopback(ctx, &relayed)
+		if err != nil {
+			return nil, err
+		}
+		result.Port = request.Port
+		return result, nil
+	}
+
+	var target net.HardwareAddr
+	if request.TargetMac != "" {
+		if target, err = net.ParseMAC(request.TargetMac); err != nil {
+			return nil, fmt.Errorf("invalid MAC address: %s", request.TargetMac)
+		}
+	}
+	count := int(request.Count)
+	if count == 0 {
+		count = 1
+	}
+	timeout := time.Duration(request.TimeoutMs) * time.Millisecond
+	if timeout == 0 {
+		timeout = core.DEFAULT_CFM_REPLY_TIMEOUT
+	}
+
+	target, rtts, err := cfm.Loopback(uint16(request.MepId), target, uint16(request.RemoteMepId), count, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ponsim.CfmLoopbackResult{
+		Port:      request.Port,
+		MepId:     request.MepId,
+		TargetMac: target.String(),
+		Sent:      uint32(count),
+		Received:  uint32(len(rtts)),
+	}
+	var total time.Duration
+	for i, rtt := range rtts {
+		us := uint32(rtt / time.Microsecond)
+		if i == 0 || us < result.MinRttUs {
+			result.MinRttUs = us
+		}
+		if us > result.MaxRttUs {
+			result.MaxRttUs = us
+		}
+		total += rtt
+	}
+	if len(rtts) > 0 {
+		result.AvgRttUs = uint32(total / time.Duration(len(rtts)) / time.Microsecond)
+	}
+
+	return result, nil
+}
+
+/*
+CfmDelayMeasurement measures the two-way frame delay from a maintenance end point with DMM and DMR
+*/
+func (handler *PonSimAdminHandler) CfmDelayMeasurement(
+	ctx context.Context,
+	request *ponsim.CfmMeasurementRequest,
+) (*ponsim.CfmDelayResult, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"request": request,
+	}).Info("Measuring CFM frame delay")
+
+	cfm, client, err := handler.getCfm(request.Port)
+	if err != nil {
+		return nil, err
+	} else if client != nil {
+		relayed := *request
+		relayed.Port = 0
+		result, err := client.CfmDelayMeasurement(ctx, &relayed)
+		if err != nil {
+			return nil, err
+		}
+		result.Port = request.Port
+		return result, nil
+	}
+
+	target, count, interval, timeout, err := parseCfmMeasurement(request)
+	if err != nil {
+		return nil, err
+	}
+	result, err := cfm.DelayMeasurement(uint16(request.MepId), target, uint16(request.RemoteMepId), count, interval, timeout)
+	if err != nil {
+		return nil, err
+	}
+	result.Port = request.Port
+
+	return result, nil
+}
+
+/*
+CfmLossMeasurement measures the frame loss from a maintenance end point with LMM and LMR
+*/
+func (handler *PonSimAdminHandler) CfmLossMeasurement(
+	ctx context.Context,
+	request *ponsim.CfmMeasurementRequest,
+) (*ponsim.CfmLossResult, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"request": request,
+	}).Info("Measuring CFM frame loss")
+
+	cfm, client, err := handler.getCfm(request.Port)
+	if err != nil {
+		return nil, err
+	} else if client != nil {
+		relayed := *request
+		relayed.Port = 0
+		result, err := client.CfmLossMeasurement(ctx, &relayed)
+		if err != nil {
+			return nil, err
+		}
+		result.Port = request.Port
+		return result, nil
+	}
+
+	target, count, interval, timeout, err := parseCfmMeasurement(request)
+	if err != nil {
+		return nil, err
+	}
+	result, err := cfm.LossMeasurement(uint16(request.MepId), target, uint16(request.RemoteMepId), count, interval, timeout)
+	if err != nil {
+		return nil, err
+	}
+	result.Port = request.Port
+
+	return result, nil
+}
+
+/*
+parseCfmMeasurement returns the target, the number of messages, the interval and the timeout of a
+measurement, with their defaults
+*/
+func parseCfmMeasurement(
+	request *ponsim.CfmMeasurementRequest,
+) (net.HardwareAddr, int, time.Duration, time.Duration, error) {
+	var target net.HardwareAddr
+	if request.TargetMac != "" {
+		var err error
+		if target, err = net.ParseMAC(request.TargetMac); err != nil {
+			return nil, 0, 0, 0, fmt.Errorf("invalid MAC address: %s", request.TargetMac)
+		}
+	}
+
+	count := int(request.Count)
+	if count == 0 {
+		count = core.DEFAULT_CFM_MEASUREMENT_COUNT
+	}
+	interval := time.Duration(request.IntervalMs) * time.Millisecond
+	if interval == 0 {
+		interval = core.DEFAULT_CFM_MEASUREMENT_INTERVAL
+	}
+	timeout := time.Duration(request.TimeoutMs) * time.Millisecond
+	if timeout == 0 {
+		timeout = core.DEFAULT_CFM_REPLY_TIMEOUT
+	}
+
+	return target, count, interval, timeout, nil
+}
+
+/*
+getCfm returns the maintenance end points of the device, or the client of the ONU on a port of
+an OLT to which the requests are relayed
+*/
+func (handler *PonSimAdminHandler) getCfm(port int32) (*core.PonSimCfm, ponsim.PonSimAdminClient, error) {
+	if _, ok := handler.device.(*core.PonSimOltDevice); ok && port != 0 {
+		_, client, err := handler.getOnu(port)
+		return nil, client, err
+	}
+
+	device := getPonSimDevice(handler.device)
+	if device == nil || device.Cfm == nil {
+		return nil, nil, errors.New("device does not support CFM")
+	}
+
+	return device.Cfm, nil, nil
+}
+
+func newMeps(port int32, cfm *core.PonSimCfm) *ponsim.Meps {
+	meps := &ponsim.Meps{Port: port}
+	for _, mep := range cfm.List() {
+		meps.Meps = append(meps.Meps, mep.MakeProto())
+	}
+
+	return meps
+}
+
+/*
+relayedMeps returns the maintenance end points replied by an ONU, addressed by its port on the OLT
+*/
+func relayedMeps(port int32, meps *ponsim.Meps, err error) (*ponsim.Meps, error) {
+	if err != nil {
+		return nil, err
+	}
+	meps.Port = port
+
+	return meps, nil
+}
+
+/*
+GetTableMiss returns what the flows of the device, or of the ONU on a port of an OLT, do with the
+frames matching none of them
+*/
+func (handler *PonSimAdminHandler) GetTableMiss(
+	ctx context.Context,
+	request *ponsim.OnuRequest,
+) (*ponsim.TableMissStatus, error) {
+	device, client, err := handler.getFlowDevice(request.Port)
+	if err != nil {
+		return nil, err
+	} else if client != nil {
+		status, err := client.GetTableMiss(ctx, &ponsim.OnuRequest{})
+		return relayedTableMissStatus(request.Port, status, err)
+	}
+
+	return newTableMissStatus(request.Port, device), nil
+}
+
+/*
+SetTableMiss changes the table-miss behaviour of the device, or of the ONU on a port of an OLT
+*/
+func (handler *PonSimAdminHandler) SetTableMiss(
+	ctx context.Context,
+	request *ponsim.TableMissRequest,
+) (*ponsim.TableMissStatus, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"request": request,
+	}).Info("Configuring table-miss behaviour")
+
+	device, client, err := handler.getFlowDevice(request.Port)
+	if err != nil {
+		return nil, err
+	} else if client != nil {
+		relayed := *request
+		relayed.Port = 0
+		status, err := client.SetTableMiss(ctx, &relayed)
+		return relayedTableMissStatus(request.Port, status, err)
+	}
+
+	if device.TableMiss == nil {
+		return nil, errors.New("device does not support changing its table-miss behaviour")
+	} else if err := device.TableMiss.Set(request.Mode); err != nil {
+		return nil, err
+	}
+
+	return newTableMissStatus(request.Port, device), nil
+}
+
+/*
+getFlowDevice returns the device whose flows are addressed, or the client of the ONU on a port of
+an OLT to which the requests are relayed
+*/
+func (handler *PonSimAdminHandler) getFlowDevice(port int32) (*core.PonSimDevice, ponsim.PonSimAdminClient, error) {
+	if _, ok := handler.device.(*core.PonSimOltDevice); ok && port != 0 {
+		_, client, err := handler.getOnu(port)
+		return nil, client, err
+	}
+
+	device := getPonSimDevice(handler.device)
+	if device == nil {
+		return nil, nil, errors.New("device has no flows")
+	}
+
+	return device, nil, nil
+}
+
+func newTableMissStatus(port int32, device *core.PonSimDevice) *ponsim.TableMissStatus {
+	mode, misses := device.TableMiss.Get()
+
+	return &ponsim.TableMissStatus{
+		Port:   port,
+		Mode:   mode,
+		Misses: misses,
+	}
+}
+
+/*
+relayedTableMissStatus returns the table-miss behaviour replied by an ONU, addressed by its port on
+the OLT
+*/
+func relayedTableMissStatus(
+	port int32,
+	status *ponsim.TableMissStatus,
+	err error,
+) (*ponsim.TableMissStatus, error) {
+	if err != nil {
+		return nil, err
+	}
+	status.Port = port
+
+	return status, nil
+}
+
+func (handler *PonSimAdminHandler) getJobs() (*core.PonSimJobs, error) {
+	device := getPonSimDevice(handler.device)
+	if device == nil || device.Jobs == nil {
+		return nil, errors.New("device does not support jobs")
+	}
+
+	return device.Jobs, nil
+}
+
+/*
+getPonSimDevice returns the common device structure of the simulated OLT or ONU
+*/
+func getPonSimDevice(device core.PonSimInterface) *core.PonSimDevice {
+	if olt, ok := device.(*core.PonSimOltDevice); ok {
+		return &olt.PonSimDevice
+	} else if onu, ok := device.(*core.PonSimOnuDevice); ok {
+		return &onu.PonSimDevice
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package nbi
+
+import (
+	"context"
+	"github.com/opencord/voltha/ponsim/v2/core"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"strings"
+)
+
+const (
+	// Metadata in which callers declare their identity for the audit trail
+	AUDIT_USER_METADATA = "user"
+)
+
+/*
+NewAuditInterceptor returns a GRPC interceptor publishing an audit event on the event bus of
+the device for every call of an audited RPC, once its result is known
+*/
+func NewAuditInterceptor(device core.PonSimInterface) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		request interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		response, err := handler(ctx, request)
+
+		if pon := getPonSimDevice(device); pon != nil {
+			// The full method is in the format /package.Service/Method
+			method := info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:]
+
+			var caller, user string
+			if p, ok := peer.FromContext(ctx); ok {
+				caller = p.Addr.String()
+			}
+			if md, ok := metadata.FromIncomingContext(ctx); ok {
+				if values := md.Get(AUDIT_USER_METADATA); len(values) > 0 {
+					user = values[0]
+				}
+			}
+
+			pon.AuditCall(method, caller, user, err)
+		}
+
+		return response, err
+	}
+}
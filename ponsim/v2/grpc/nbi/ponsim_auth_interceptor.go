@@ -0,0 +1,65 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package nbi
+
+import (
+	"context"
+	"github.com/opencord/voltha/ponsim/v2/core"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+/*
+NewAuthInterceptor returns a GRPC interceptor rejecting the calls of the restricted RPCs
+whose caller does not present valid credentials with an UNAUTHENTICATED status
+*/
+func NewAuthInterceptor(device core.PonSimInterface) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		request interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if pon := getPonSimDevice(device); pon != nil {
+			// The full method is in the format /package.Service/Method
+			method := info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:]
+
+			var authorization string
+			if md, ok := metadata.FromIncomingContext(ctx); ok {
+				if values := md[core.API_AUTH_METADATA]; len(values) > 0 {
+					authorization = values[0]
+				}
+			}
+
+			if subject, err := pon.ApiAuth.Authorize(method, authorization); err != nil {
+				nbiLogger.ForContext(ctx).WithFields(logrus.Fields{
+					"device":  pon.Name,
+					"method":  method,
+					"subject": subject,
+					"error":   err.Error(),
+				}).Warn("Rejecting unauthenticated call")
+
+				return nil, status.Errorf(codes.Unauthenticated, "%s: %s", method, err.Error())
+			}
+		}
+
+		return handler(ctx, request)
+	}
+}
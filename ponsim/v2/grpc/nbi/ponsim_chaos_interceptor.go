@@ -0,0 +1,53 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package nbi
+
+import (
+	"context"
+	"github.com/opencord/voltha/ponsim/v2/core"
+	"google.golang.org/grpc"
+	"strings"
+	"time"
+)
+
+/*
+NewChaosInterceptor returns a GRPC interceptor delaying the responses of the RPCs of the PonSim
+service by the delays injected by the chaos controller of the device
+*/
+func NewChaosInterceptor(device core.PonSimInterface) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		request interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		response, err := handler(ctx, request)
+
+		// The full method is in the format /package.Service/Method
+		if pon := getPonSimDevice(device); pon != nil && strings.HasPrefix(info.FullMethod, "/voltha.PonSim/") {
+			method := info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:]
+
+			if delay := pon.ChaosRpcDelay(method); delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+				}
+			}
+		}
+
+		return response, err
+	}
+}
@@ -17,19 +17,34 @@ package nbi
 
 import (
 	"context"
-	"crypto/tls"
 	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/google/gopacket"
-	"github.com/google/gopacket/layers"
 	"github.com/opencord/voltha/ponsim/v2/common"
 	"github.com/opencord/voltha/ponsim/v2/core"
 	"github.com/opencord/voltha/protos/go/voltha"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Logger of the northbound services, whose level is set independently
+var nbiLogger = common.Logger().Component(common.LOG_COMPONENT_NBI)
+
+const (
+	// Interval at which statistics are streamed when the request does not specify one
+	DEFAULT_STATS_INTERVAL = time.Second
+
+	// Shortest interval at which statistics can be streamed
+	MIN_STATS_INTERVAL = 10 * time.Millisecond
 )
 
 // TODO: Cleanup GRPC security config
@@ -37,6 +52,9 @@ import (
 
 type PonSimHandler struct {
 	device core.PonSimInterface
+
+	portMutex sync.Mutex
+	portLocks map[int32]*sync.Mutex
 }
 
 /*
@@ -52,14 +70,28 @@ func NewPonSimHandler(device core.PonSimInterface) *PonSimHandler {
 SendFrame handles and forwards EGRESS packets (i.e. VOLTHA to OLT)
 */
 func (handler *PonSimHandler) SendFrame(ctx context.Context, data *voltha.PonSimFrame) (*empty.Empty, error) {
-	frame := gopacket.NewPacket(data.Payload, layers.LayerTypeEthernet, gopacket.Default)
+	frame := common.NewFrame(data.Payload)
+	if len(data.Hash) > 0 {
+		common.SetFrameHash(frame, data.Hash)
+	}
 
-	common.Logger().WithFields(logrus.Fields{
-		"handler": handler,
-		"frame":   frame.Dump(),
-	}).Info("Constructed frame")
+	// Only the trace of the request is carried over, as the frame outlives the request
+	traceCtx, span := common.StartSpan(
+		common.WithSpanContext(context.Background(), common.ExtractTraceMetadata(ctx)),
+		"SendFrame",
+		common.SPAN_KIND_SERVER,
+	)
+	span.SetAttribute("ponsim.size", len(data.Payload))
+	defer span.Finish()
 
-	handler.device.Forward(context.Background(), 2, frame)
+	if entry := nbiLogger.ForFrame(logrus.InfoLevel); entry != nil {
+		entry.WithFields(logrus.Fields{
+			"handler": handler,
+			"frame":   frame,
+		}).Info("Constructed frame")
+	}
+
+	span.SetError(handler.device.Forward(traceCtx, 2, frame))
 
 	out := new(empty.Empty)
 	return out, nil
@@ -69,51 +101,95 @@ func (handler *PonSimHandler) SendFrame(ctx context.Context, data *voltha.PonSim
 ReceiveFrames handles a stream of INGRESS packets (i.e. OLT to VOLTHA)
 */
 func (handler *PonSimHandler) ReceiveFrames(empty *empty.Empty, stream voltha.PonSim_ReceiveFramesServer) error {
-	common.Logger().WithFields(logrus.Fields{
+	nbiLogger.WithFields(logrus.Fields{
 		"handler": handler,
 	}).Info("start-receiving-frames")
 
 	if _, ok := (handler.device).(*core.PonSimOltDevice); ok {
-		var data []byte
+		var frame gopacket.Packet
 		var ok bool
 
-		common.Logger().WithFields(logrus.Fields{
+		nbiLogger.WithFields(logrus.Fields{
 			"handler": handler,
 			"device":  (handler.device).(*core.PonSimOltDevice),
 		}).Info("receiving-frames-from-olt-device")
 
+		olt := (handler.device).(*core.PonSimOltDevice)
+
+		var events <-chan *voltha.PonSimEvent
+		if olt.Events != nil {
+			var id int
+			id, events = olt.Events.Subscribe()
+			defer olt.Events.Unsubscribe(id)
+		}
+
 		for {
+			// Control frames are always sent ahead of pending data frames
 			select {
-			case data, ok = <-(handler.device).(*core.PonSimOltDevice).GetOutgoing():
-				if ok {
-					frame := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
-					common.Logger().WithFields(logrus.Fields{
-						"handler": handler,
-						"frame":   frame,
-					}).Info("Received incoming data")
-
-					frameBytes := &voltha.PonSimFrame{Id: handler.device.GetAddress(), Payload: data}
-					if err := stream.Send(frameBytes); err != nil {
-						common.Logger().WithFields(logrus.Fields{
+			case frame, ok = <-olt.GetControl():
+			default:
+				select {
+				case frame, ok = <-olt.GetControl():
+				case <-olt.GetOutgoing().Ready():
+					// Another stream may have dequeued the frame in the meantime
+					if frame = olt.GetOutgoing().Pop(); frame == nil {
+						continue
+					}
+					ok = true
+				case event := <-events:
+					// The stream does not survive a reboot of the device
+					if event.GetReboot() != nil {
+						nbiLogger.WithFields(logrus.Fields{
 							"handler": handler,
-							"frame":   frame,
-							"error":   err,
-						}).Error("Failed to send incoming data")
-						return err
+						}).Info("Device is rebooting, closing frame stream")
+						return errors.New("device is rebooting")
 					}
-					common.Logger().WithFields(logrus.Fields{
+					continue
+				case <-stream.Context().Done():
+					// The stream is cancelled when the peer stops acknowledging keepalives
+					nbiLogger.WithFields(logrus.Fields{
 						"handler": handler,
-						"frame":   frame,
-					}).Info("Sent incoming data")
-
-				} else {
-					return errors.New("incoming data channel has closed")
+						"error":   stream.Context().Err(),
+					}).Warn("Peer is gone, closing frame stream")
+					return stream.Context().Err()
 				}
 			}
+
+			if !ok {
+				return errors.New("incoming data channel has closed")
+			}
+
+			if entry := nbiLogger.ForFrame(logrus.InfoLevel); entry != nil {
+				entry.WithFields(logrus.Fields{
+					"handler": handler,
+					"frame":   frame,
+				}).Info("Received incoming data")
+			}
+
+			frameBytes := &voltha.PonSimFrame{
+				Id:       handler.device.GetAddress(),
+				Payload:  frame.Data(),
+				Hash:     common.GetFrameHash(frame),
+				PacketIn: core.GetPacketIn(frame),
+			}
+			if err := stream.Send(frameBytes); err != nil {
+				nbiLogger.WithFields(logrus.Fields{
+					"handler": handler,
+					"frame":   frame,
+					"error":   err,
+				}).Error("Failed to send incoming data")
+				return err
+			}
+			if entry := nbiLogger.ForFrame(logrus.InfoLevel); entry != nil {
+				entry.WithFields(logrus.Fields{
+					"handler": handler,
+					"frame":   frame,
+				}).Info("Sent incoming data")
+			}
 		}
 
 	} else {
-		common.Logger().WithFields(logrus.Fields{
+		nbiLogger.WithFields(logrus.Fields{
 			"handler": handler,
 		}).Error("Not handling an OLT device")
 	}
@@ -128,7 +204,7 @@ func (handler *PonSimHandler) GetDeviceInfo(
 	ctx context.Context,
 	empty *empty.Empty,
 ) (*voltha.PonSimDeviceInfo, error) {
-	common.Logger().WithFields(logrus.Fields{
+	nbiLogger.WithFields(logrus.Fields{
 		"handler": handler,
 	}).Info("Getting device information")
 
@@ -136,24 +212,94 @@ func (handler *PonSimHandler) GetDeviceInfo(
 
 	// Check which device type we're currently handling
 	if _, ok := (handler.device).(*core.PonSimOltDevice); ok {
-		common.Logger().WithFields(logrus.Fields{
+		nbiLogger.WithFields(logrus.Fields{
 			"handler": handler,
 		}).Debug("Handling OLT device")
 		keys := make([]int32, 0, len((handler.device).(*core.PonSimOltDevice).GetOnus()))
-		for k := range (handler.device).(*core.PonSimOltDevice).GetOnus() {
+		onus := make([]*voltha.PonSimOnuInfo, 0, len((handler.device).(*core.PonSimOltDevice).GetOnus()))
+		for k, onu := range (handler.device).(*core.PonSimOltDevice).GetOnus() {
 			keys = append(keys, k)
+			onuInfo := &voltha.PonSimOnuInfo{
+				Port:           k,
+				VendorId:       onu.Device.VendorId,
+				SerialNumber:   onu.Device.SerialNumber,
+				RegistrationId: onu.Device.RegistrationId,
+				PonPort:        onu.Device.PonPort,
+			}
+			if onu.Tcont != nil {
+				onuInfo.AllocId = onu.Tcont.AllocId
+				onuInfo.GemPorts = onu.Tcont.GetGemPortIds()
+				onuInfo.EncryptedGemPorts = onu.Tcont.GetEncryptedGemPortIds()
+			}
+			if onu.Ranging != nil {
+				onuInfo.DistanceM = onu.Ranging.Distance
+				onuInfo.RoundTripDelayNs = int64(onu.Ranging.RoundTripDelay)
+				onuInfo.EqualizationDelayNs = int64(onu.Ranging.EqualizationDelay)
+			}
+			if onu.Optics != nil {
+				olt := (handler.device).(*core.PonSimOltDevice)
+				onuInfo.OnuTxPowerDbm = onu.Optics.GetTxPower()
+				onuInfo.OnuRxPowerDbm = onu.Optics.GetRxPower(olt.GetOnuOltTxPower(k))
+				onuInfo.OltRxPowerDbm = onu.Optics.GetOltRxPower()
+				onuInfo.Los = onu.Optics.IsLos()
+			}
+			onus = append(onus, onuInfo)
+		}
+		out = &voltha.PonSimDeviceInfo{NniPort: core.NNI_LOGICAL_PORT, UniPorts: []int32(keys), Onus: onus}
+		for _, pon := range (handler.device).(*core.PonSimOltDevice).GetPonPorts() {
+			out.PonPorts = append(out.PonPorts, int32(pon.Port))
+		}
+		setIdentity(out, (handler.device).(*core.PonSimOltDevice).GetIdentity())
+
+	} else if onu, ok := (handler.device).(*core.PonSimOnuDevice); ok {
+		nbiLogger.WithFields(logrus.Fields{
+			"handler": handler,
+		}).Debug("Handling ONU device")
+
+		out = &voltha.PonSimDeviceInfo{
+			VendorId:       onu.VendorId,
+			SerialNumber:   onu.GetSerialNumber(),
+			RegistrationId: onu.RegistrationId,
+
+			EqualizationDelayNs: int64(onu.EqualizationDelay),
 		}
-		out = &voltha.PonSimDeviceInfo{NniPort: 0, UniPorts: []int32(keys)}
+		out.OnuState, out.OnuStateReason, _ = onu.GetOnuState()
+		setIdentity(out, onu.GetIdentity())
 
 	} else {
-		common.Logger().WithFields(logrus.Fields{
+		nbiLogger.WithFields(logrus.Fields{
 			"handler": handler,
-		}).Debug("Handling ONU/OTHER device")
+		}).Debug("Handling OTHER device")
 
 		out = &voltha.PonSimDeviceInfo{}
 	}
 
-	common.Logger().WithFields(logrus.Fields{
+	if device := getPonSimDevice(handler.device); device != nil {
+		ports := []int{1, 2}
+		if olt, ok := (handler.device).(*core.PonSimOltDevice); ok {
+			for _, pon := range olt.GetPonPorts()[1:] {
+				ports = append(ports, pon.Port)
+			}
+		}
+		for _, port := range ports {
+			info := &voltha.PonSimPortInfo{
+				Port:    int32(port),
+				Enabled: device.PortStates.IsEnabled(port),
+				Up:      device.PortStates.IsUp(port),
+				Mtu:     int32(device.Mtus.Get(port)),
+			}
+			if olt, ok := (handler.device).(*core.PonSimOltDevice); ok {
+				if pon := olt.GetPonPort(port); pon != nil {
+					info.TxPowerDbm = pon.TxPower
+				}
+			}
+			out.Ports = append(out.Ports, info)
+		}
+	}
+
+	out.Padding = handler.responsePadding(out)
+
+	nbiLogger.WithFields(logrus.Fields{
 		"handler": handler,
 		"result":  out,
 	}).Info("Device information")
@@ -161,6 +307,17 @@ func (handler *PonSimHandler) GetDeviceInfo(
 	return out, nil
 }
 
+/*
+setIdentity reports the identity of a device in its information
+*/
+func setIdentity(out *voltha.PonSimDeviceInfo, identity core.PonSimIdentity) {
+	out.Vendor = identity.Vendor
+	out.Model = identity.Model
+	out.HardwareVersion = identity.HardwareVersion
+	out.FirmwareVersion = identity.FirmwareVersion
+	out.SerialNumber = identity.SerialNumber
+}
+
 /*
 UpdateFlowTable populates and cleans up the flows for a PonSim device
 */
@@ -168,101 +325,235 @@ func (handler *PonSimHandler) UpdateFlowTable(
 	ctx context.Context,
 	table *voltha.FlowTable,
 ) (*empty.Empty, error) {
-	common.Logger().WithFields(logrus.Fields{
+	nbiLogger.ForContext(ctx).WithFields(logrus.Fields{
 		"handler": handler,
 		"table":   table,
 	}).Info("Updating flows")
 
+	// Failures to apply the flows are only logged, only rejected updates are reported
+	if err := handler.applyFlowTable(ctx, table); err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+	}
+
+	nbiLogger.ForContext(ctx).WithFields(logrus.Fields{
+		"handler": handler,
+		"table":   table,
+	}).Info("Updated flows")
+
+	out := new(empty.Empty)
+	return out, nil
+}
+
+/*
+UpdateFlowTables applies a batch of flow table updates, e.g. when provisioning subscribers in bulk.
+
+The updates of different ports are applied concurrently while the updates of a port are applied
+in order.  A failed update does not prevent the next updates of its port from being applied.
+*/
+func (handler *PonSimHandler) UpdateFlowTables(
+	ctx context.Context,
+	request *voltha.PonSimFlowTables,
+) (*voltha.PonSimProvisioningReport, error) {
+	var ports []int32
+	tables := make(map[int32][]*voltha.FlowTable)
+	for _, table := range request.Tables {
+		if _, ok := tables[table.Port]; !ok {
+			ports = append(ports, table.Port)
+		}
+		tables[table.Port] = append(tables[table.Port], table)
+	}
+
+	var failed int32
+	var wg sync.WaitGroup
+	start := time.Now()
+
+	for _, port := range ports {
+		wg.Add(1)
+		go func(tables []*voltha.FlowTable) {
+			defer wg.Done()
+			for _, table := range tables {
+				if err := handler.applyFlowTable(ctx, table); err != nil {
+					atomic.AddInt32(&failed, 1)
+				}
+			}
+		}(tables[port])
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	report := &voltha.PonSimProvisioningReport{
+		Applied:    uint32(len(request.Tables)) - uint32(failed),
+		Failed:     uint32(failed),
+		Ports:      uint32(len(ports)),
+		DurationUs: uint64(elapsed / time.Microsecond),
+	}
+	if elapsed > 0 {
+		report.UpdatesPerSecond = float32(float64(len(request.Tables)) / elapsed.Seconds())
+	}
+
+	nbiLogger.ForContext(ctx).WithFields(logrus.Fields{
+		"handler": handler,
+		"report":  report,
+	}).Info("Applied flow table updates")
+
+	return report, nil
+}
+
+/*
+applyFlowTable applies a flow table update to the OLT, or forwards it to the addressed ONU,
+after the updates of the same port which are in progress.  Rejected updates are reported with
+a GRPC status.
+*/
+func (handler *PonSimHandler) applyFlowTable(ctx context.Context, table *voltha.FlowTable) error {
+	lock := handler.portLock(table.Port)
+	lock.Lock()
+	defer lock.Unlock()
+
+	var counter *core.PonSimMetricCounter
+	if device := getPonSimDevice(handler.device); device != nil && device.Counter != nil {
+		counter = device.Counter
+		counter.StartFlowUpdate()
+	}
+
+	start := time.Now()
+	err := handler.updateFlows(ctx, table)
+
+	if counter != nil {
+		counter.EndFlowUpdate(err != nil, time.Since(start))
+	}
+
+	return err
+}
+
+/*
+portLock returns the lock serializing the flow table updates of a port
+*/
+func (handler *PonSimHandler) portLock(port int32) *sync.Mutex {
+	handler.portMutex.Lock()
+	defer handler.portMutex.Unlock()
+
+	if handler.portLocks == nil {
+		handler.portLocks = make(map[int32]*sync.Mutex)
+	}
+	lock, ok := handler.portLocks[port]
+	if !ok {
+		lock = &sync.Mutex{}
+		handler.portLocks[port] = lock
+	}
+
+	return lock
+}
+
+/*
+updateFlows applies a flow table update to the OLT, or forwards it to the addressed ONU
+*/
+func (handler *PonSimHandler) updateFlows(ctx context.Context, table *voltha.FlowTable) error {
 	if _, ok := (handler.device).(*core.PonSimOltDevice); ok {
 		if table.Port == 0 {
-			common.Logger().WithFields(logrus.Fields{
+			nbiLogger.ForContext(ctx).WithFields(logrus.Fields{
 				"handler": handler,
 				"port":    table.Port,
 			}).Debug("Updating OLT flows")
 
-			if err := (handler.device).(*core.PonSimOltDevice).InstallFlows(ctx, table.Flows); err != nil {
-				common.Logger().WithFields(logrus.Fields{
+			if err := (handler.device).(*core.PonSimOltDevice).UpdateFlows(ctx, table); err != nil {
+				nbiLogger.ForContext(ctx).WithFields(logrus.Fields{
 					"handler": handler,
 					"error":   err.Error(),
 					"flows":   table.Flows,
 				}).Error("Problem updating flows on OLT")
-			} else {
-				common.Logger().WithFields(logrus.Fields{
-					"handler": handler,
-				}).Debug("Updated OLT flows")
+
+				return err
 			}
 
+			nbiLogger.ForContext(ctx).WithFields(logrus.Fields{
+				"handler": handler,
+			}).Debug("Updated OLT flows")
+
 		} else {
-			common.Logger().WithFields(logrus.Fields{
+			nbiLogger.ForContext(ctx).WithFields(logrus.Fields{
 				"handler": handler,
 				"port":    table.Port,
 			}).Debug("Updating ONU flows")
 
-			if child, ok := (handler.device).(*core.PonSimOltDevice).GetOnus()[table.Port]; ok {
-				// TODO: make it secure
-				ta := credentials.NewTLS(&tls.Config{
-					InsecureSkipVerify: true,
-				})
-
-				host := strings.Join([]string{
-					child.Device.Address,
-					strconv.Itoa(int(child.Device.Port)),
-				}, ":")
-
-				conn, err := grpc.Dial(
-					host,
-					grpc.WithTransportCredentials(ta),
-				)
+			child, ok := (handler.device).(*core.PonSimOltDevice).GetOnus()[table.Port]
+			if !ok {
+				nbiLogger.ForContext(ctx).WithFields(logrus.Fields{
+					"handler": handler,
+					"port":    table.Port,
+				}).Warn("Unable to find ONU")
+
+				return fmt.Errorf("no ONU on port %d", table.Port)
+			}
+
+			// The requested flows are restored if the ONU reconnects
+			if shadow := (handler.device).(*core.PonSimOltDevice).FlowShadow; shadow != nil {
+				if err := shadow.Update(child.Device.SerialNumber, table); err != nil {
+					return status.Error(codes.InvalidArgument, err.Error())
+				}
+			}
+
+			// The update is queued along with the other operations pending on the ONU
+			err := child.Operations.Submit(func() error {
+				conn, host, err := onuConn(child)
 				if err != nil {
-					common.Logger().WithFields(logrus.Fields{
+					nbiLogger.ForContext(ctx).WithFields(logrus.Fields{
 						"handler": handler,
 						"error":   err.Error(),
 					}).Error("GRPC Connection problem")
+
+					return err
 				}
-				defer conn.Close()
 				client := voltha.NewPonSimClient(conn)
 
 				if _, err = client.UpdateFlowTable(ctx, table); err != nil {
-					common.Logger().WithFields(logrus.Fields{
+					nbiLogger.ForContext(ctx).WithFields(logrus.Fields{
 						"handler": handler,
 						"host":    host,
 						"error":   err.Error(),
 					}).Error("Problem forwarding update request to ONU")
+
+					return fmt.Errorf("problem forwarding update request to ONU: %s", err.Error())
 				}
-			} else {
-				common.Logger().WithFields(logrus.Fields{
+
+				return nil
+			})
+			if err == core.ErrDeviceBusy {
+				nbiLogger.ForContext(ctx).WithFields(logrus.Fields{
 					"handler": handler,
 					"port":    table.Port,
-				}).Warn("Unable to find ONU")
+					"pending": child.Operations.Pending,
+				}).Warn("Rejecting update, ONU operation queue is full")
+
+				return status.Error(codes.ResourceExhausted, err.Error())
 			}
 
+			return err
 		}
 	} else if _, ok := (handler.device).(*core.PonSimOnuDevice); ok {
-		if err := (handler.device).(*core.PonSimOnuDevice).InstallFlows(ctx, table.Flows); err != nil {
-			common.Logger().WithFields(logrus.Fields{
+		if err := (handler.device).(*core.PonSimOnuDevice).UpdateFlows(ctx, table); err != nil {
+			nbiLogger.ForContext(ctx).WithFields(logrus.Fields{
 				"handler": handler,
 				"error":   err.Error(),
 				"flows":   table.Flows,
 			}).Error("Problem updating flows on ONU")
-		} else {
-			common.Logger().WithFields(logrus.Fields{
-				"handler": handler,
-			}).Debug("Updated ONU flows")
+
+			return err
 		}
+
+		nbiLogger.ForContext(ctx).WithFields(logrus.Fields{
+			"handler": handler,
+		}).Debug("Updated ONU flows")
+
 	} else {
-		common.Logger().WithFields(logrus.Fields{
+		nbiLogger.ForContext(ctx).WithFields(logrus.Fields{
 			"handler": handler,
 			"port":    table.Port,
 		}).Warn("Unknown device")
 	}
 
-	common.Logger().WithFields(logrus.Fields{
-		"handler": handler,
-		"table":   table,
-	}).Info("Updated flows")
-
-	out := new(empty.Empty)
-	return out, nil
+	return nil
 }
 
 /*
@@ -272,14 +563,12 @@ func (handler *PonSimHandler) GetStats(
 	ctx context.Context,
 	empty *empty.Empty,
 ) (*voltha.PonSimMetrics, error) {
-	common.Logger().WithFields(logrus.Fields{
+	nbiLogger.WithFields(logrus.Fields{
 		"handler": handler,
 	}).Info("Retrieving stats")
 
-	var metrics *voltha.PonSimMetrics = new(voltha.PonSimMetrics)
-
 	if olt, ok := (handler.device).(*core.PonSimOltDevice); ok {
-		common.Logger().WithFields(logrus.Fields{
+		nbiLogger.WithFields(logrus.Fields{
 			"handler": handler,
 			"olt":     olt,
 		}).Debug("Retrieving stats for OLT")
@@ -289,54 +578,616 @@ func (handler *PonSimHandler) GetStats(
 		// Loop through each onus to get stats from those as well?
 		// send grpc request to each onu
 		for _, child := range (handler.device).(*core.PonSimOltDevice).GetOnus() {
-			// TODO: make it secure
-			ta := credentials.NewTLS(&tls.Config{
-				InsecureSkipVerify: true,
-			})
-
-			host := strings.Join([]string{child.Device.Address, strconv.Itoa(int(child.Device.Port))}, ":")
-			conn, err := grpc.Dial(
-				host,
-				grpc.WithTransportCredentials(ta),
-			)
+			conn, host, err := onuConn(child)
 			if err != nil {
-				common.Logger().WithFields(logrus.Fields{
+				nbiLogger.WithFields(logrus.Fields{
 					"handler": handler,
 					"error":   err.Error(),
 				}).Error("GRPC Connection problem")
+				continue
 			}
-			defer conn.Close()
 			client := voltha.NewPonSimClient(conn)
 
 			if _, err = client.GetStats(ctx, empty); err != nil {
-				common.Logger().WithFields(logrus.Fields{
+				nbiLogger.WithFields(logrus.Fields{
 					"handler": handler,
 					"host":    host,
 					"error":   err.Error(),
 				}).Error("Problem forwarding stats request to ONU")
 			}
 		}
-		metrics = (handler.device).(*core.PonSimOltDevice).Counter.MakeProto()
+	}
+
+	metrics := handler.collectStats()
+
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+	}).Info("Retrieved stats")
+
+	return metrics, nil
+}
 
-		common.Logger().WithFields(logrus.Fields{
+/*
+collectStats snapshots the counters of the device, stamped with the device time
+*/
+func (handler *PonSimHandler) collectStats() *voltha.PonSimMetrics {
+	var metrics *voltha.PonSimMetrics = new(voltha.PonSimMetrics)
+
+	if olt, ok := (handler.device).(*core.PonSimOltDevice); ok {
+		metrics = olt.MakeMetrics()
+
+		nbiLogger.WithFields(logrus.Fields{
 			"handler": handler,
 			"metrics": metrics,
 		}).Debug("OLT Metrics")
 
 	} else if onu, ok := (handler.device).(*core.PonSimOnuDevice); ok {
-		common.Logger().WithFields(logrus.Fields{
+		nbiLogger.WithFields(logrus.Fields{
 			"handler": handler,
 			"onu":     onu,
 		}).Debug("Retrieving stats for ONU")
+
+		metrics = onu.MakeMetrics()
 	} else {
-		common.Logger().WithFields(logrus.Fields{
+		nbiLogger.WithFields(logrus.Fields{
 			"handler": handler,
 		}).Warn("Unknown device")
 	}
 
-	common.Logger().WithFields(logrus.Fields{
+	if device := getPonSimDevice(handler.device); device != nil {
+		metrics.Timestamp = device.Clock.Now().UnixNano()
+	}
+	metrics.Padding = handler.responsePadding(metrics)
+
+	return metrics
+}
+
+/*
+StreamStats pushes the statistics of a PonSim device at the requested interval until the
+client cancels the stream, sparing the client from polling GetStats
+*/
+func (handler *PonSimHandler) StreamStats(
+	request *voltha.PonSimStatsRequest,
+	stream voltha.PonSim_StreamStatsServer,
+) error {
+	interval := time.Duration(request.IntervalMs) * time.Millisecond
+	if interval == 0 {
+		interval = DEFAULT_STATS_INTERVAL
+	} else if interval < MIN_STATS_INTERVAL {
+		return status.Errorf(codes.InvalidArgument, "interval must be at least %s", MIN_STATS_INTERVAL)
+	}
+
+	nbiLogger.WithFields(logrus.Fields{
+		"handler":  handler,
+		"interval": interval,
+	}).Info("Streaming stats")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := stream.Send(handler.collectStats()); err != nil {
+			nbiLogger.WithFields(logrus.Fields{
+				"handler": handler,
+				"error":   err,
+			}).Error("Failed to send stats")
+			return err
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stream.Context().Done():
+			nbiLogger.WithFields(logrus.Fields{
+				"handler": handler,
+				"error":   stream.Context().Err(),
+			}).Info("Closing stats stream")
+			return stream.Context().Err()
+		}
+	}
+}
+
+/*
+ReceiveEvents handles a stream of events raised by a PonSim device (OLT or ONU)
+*/
+func (handler *PonSimHandler) ReceiveEvents(empty *empty.Empty, stream voltha.PonSim_ReceiveEventsServer) error {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+	}).Info("start-receiving-events")
+
+	device := getPonSimDevice(handler.device)
+	if device == nil || device.Events == nil {
+		return errors.New("device does not raise events")
+	}
+
+	id, events := device.Events.Subscribe()
+	defer device.Events.Unsubscribe(id)
+
+	for {
+		select {
+		case event := <-events:
+			if err := stream.Send(event); err != nil {
+				nbiLogger.WithFields(logrus.Fields{
+					"handler": handler,
+					"event":   event,
+					"error":   err,
+				}).Error("Failed to send event")
+				return err
+			}
+
+			// The stream does not survive a reboot of the device
+			if event.GetReboot() != nil {
+				return nil
+			}
+
+		case <-stream.Context().Done():
+			nbiLogger.WithFields(logrus.Fields{
+				"handler": handler,
+				"error":   stream.Context().Err(),
+			}).Info("Closing event stream")
+			return stream.Context().Err()
+		}
+	}
+}
+
+/*
+Reboot simulates the reboot of a PonSim device (OLT or ONU)
+*/
+func (handler *PonSimHandler) Reboot(
+	ctx context.Context,
+	empty *empty.Empty,
+) (*empty.Empty, error) {
+	nbiLogger.WithFields(logrus.Fields{
 		"handler": handler,
-	}).Info("Retrieved stats")
+	}).Info("Rebooting device")
 
-	return metrics, nil
+	if err := handler.device.Reboot(ctx); err != nil {
+		return nil, err
+	}
+
+	return empty, nil
+}
+
+/*
+EnablePort administratively enables the NNI or UNI port of a PonSim device
+*/
+func (handler *PonSimHandler) EnablePort(
+	ctx context.Context,
+	port *voltha.PonSimPort,
+) (*empty.Empty, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"port":    port.Port,
+	}).Info("Enabling port")
+
+	device := getPonSimDevice(handler.device)
+	if device == nil {
+		return nil, errors.New("device does not support port state changes")
+	}
+
+	if err := device.EnablePort(int(port.Port)); err != nil {
+		return nil, err
+	}
+
+	return &empty.Empty{}, nil
+}
+
+/*
+DisablePort administratively shuts the NNI or UNI port of a PonSim device
+*/
+func (handler *PonSimHandler) DisablePort(
+	ctx context.Context,
+	port *voltha.PonSimPort,
+) (*empty.Empty, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"port":    port.Port,
+	}).Info("Disabling port")
+
+	device := getPonSimDevice(handler.device)
+	if device == nil {
+		return nil, errors.New("device does not support port state changes")
+	}
+
+	if err := device.DisablePort(int(port.Port)); err != nil {
+		return nil, err
+	}
+
+	return &empty.Empty{}, nil
+}
+
+/*
+GetFlowStats returns the flows of a PonSim device along with their packet and byte counts.
+The port addresses the device as for UpdateFlowTable (0 for the OLT, or the port of an ONU).
+*/
+func (handler *PonSimHandler) GetFlowStats(
+	ctx context.Context,
+	port *voltha.PonSimPort,
+) (*voltha.FlowTable, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"port":    port.Port,
+	}).Info("Getting flow statistics")
+
+	if olt, ok := (handler.device).(*core.PonSimOltDevice); ok && port.Port != 0 {
+		child, ok := olt.GetOnus()[port.Port]
+		if !ok {
+			return nil, fmt.Errorf("unable to find ONU on port %d", port.Port)
+		}
+
+		conn, host, err := onuConn(child)
+		if err != nil {
+			return nil, err
+		}
+
+		table, err := voltha.NewPonSimClient(conn).GetFlowStats(ctx, &voltha.PonSimPort{})
+		if err != nil {
+			nbiLogger.WithFields(logrus.Fields{
+				"handler": handler,
+				"host":    host,
+				"error":   err.Error(),
+			}).Error("Problem forwarding flow statistics request to ONU")
+			return nil, err
+		}
+		table.Port = port.Port
+
+		return table, nil
+	}
+
+	device := getPonSimDevice(handler.device)
+	if device == nil {
+		return nil, errors.New("device does not support flow statistics")
+	}
+
+	return &voltha.FlowTable{Port: port.Port, Flows: device.GetFlowStats()}, nil
+}
+
+/*
+UpdateGroupTable applies OpenFlow group-mods to the group table of a PonSim device.
+The port addresses the device as for UpdateFlowTable (0 for the OLT, or the port of an ONU).
+*/
+func (handler *PonSimHandler) UpdateGroupTable(
+	ctx context.Context,
+	table *voltha.GroupTable,
+) (*empty.Empty, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"table":   table,
+	}).Info("Updating groups")
+
+	if olt, ok := (handler.device).(*core.PonSimOltDevice); ok && table.Port != 0 {
+		child, ok := olt.GetOnus()[table.Port]
+		if !ok {
+			return nil, fmt.Errorf("unable to find ONU on port %d", table.Port)
+		}
+
+		conn, host, err := onuConn(child)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = voltha.NewPonSimClient(conn).UpdateGroupTable(ctx, &voltha.GroupTable{GroupMods: table.GroupMods}); err != nil {
+			nbiLogger.WithFields(logrus.Fields{
+				"handler": handler,
+				"host":    host,
+				"error":   err.Error(),
+			}).Error("Problem forwarding group update to ONU")
+			return nil, err
+		}
+
+		return &empty.Empty{}, nil
+	}
+
+	device := getPonSimDevice(handler.device)
+	if device == nil {
+		return nil, errors.New("device does not support groups")
+	}
+
+	if err := device.UpdateGroups(ctx, table.GroupMods); err != nil {
+		return nil, err
+	}
+
+	return &empty.Empty{}, nil
+}
+
+/*
+UpdateMeterTable applies OpenFlow meter-mods to the meter table of a PonSim device.
+The port addresses the device as for UpdateFlowTable (0 for the OLT, or the port of an ONU).
+*/
+func (handler *PonSimHandler) UpdateMeterTable(
+	ctx context.Context,
+	table *voltha.MeterTable,
+) (*empty.Empty, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"table":   table,
+	}).Info("Updating meters")
+
+	if olt, ok := (handler.device).(*core.PonSimOltDevice); ok && table.Port != 0 {
+		child, ok := olt.GetOnus()[table.Port]
+		if !ok {
+			return nil, fmt.Errorf("unable to find ONU on port %d", table.Port)
+		}
+
+		conn, host, err := onuConn(child)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = voltha.NewPonSimClient(conn).UpdateMeterTable(ctx, &voltha.MeterTable{MeterMods: table.MeterMods}); err != nil {
+			nbiLogger.WithFields(logrus.Fields{
+				"handler": handler,
+				"host":    host,
+				"error":   err.Error(),
+			}).Error("Problem forwarding meter update to ONU")
+			return nil, err
+		}
+
+		return &empty.Empty{}, nil
+	}
+
+	device := getPonSimDevice(handler.device)
+	if device == nil {
+		return nil, errors.New("device does not support meters")
+	}
+
+	if err := device.UpdateMeters(ctx, table.MeterMods); err != nil {
+		return nil, err
+	}
+
+	return &empty.Empty{}, nil
+}
+
+/*
+GetInventory returns the inventory metadata (CLLI, rack, shelf, slot and location) of a PonSim device.
+The port addresses the device as for UpdateFlowTable (0 for the OLT, or the port of an ONU).
+*/
+func (handler *PonSimHandler) GetInventory(
+	ctx context.Context,
+	port *voltha.PonSimPort,
+) (*voltha.PonSimInventory, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"port":    port.Port,
+	}).Info("Getting inventory")
+
+	if olt, ok := (handler.device).(*core.PonSimOltDevice); ok && port.Port != 0 {
+		child, ok := olt.GetOnus()[port.Port]
+		if !ok {
+			return nil, fmt.Errorf("unable to find ONU on port %d", port.Port)
+		}
+
+		conn, host, err := onuConn(child)
+		if err != nil {
+			return nil, err
+		}
+
+		inventory, err := voltha.NewPonSimClient(conn).GetInventory(ctx, &voltha.PonSimPort{})
+		if err != nil {
+			nbiLogger.WithFields(logrus.Fields{
+				"handler": handler,
+				"host":    host,
+				"error":   err.Error(),
+			}).Error("Problem forwarding inventory request to ONU")
+			return nil, err
+		}
+		inventory.Port = port.Port
+
+		return inventory, nil
+	}
+
+	device := getPonSimDevice(handler.device)
+	if device == nil {
+		return nil, errors.New("device does not support inventory")
+	}
+
+	inventory := device.Inventory.MakeProto()
+	inventory.Port = port.Port
+
+	return inventory, nil
+}
+
+/*
+GetDiagnostics returns the environmental readings of the transceiver of a PonSim device.  The
+port addresses the device as for GetInventory.
+*/
+func (handler *PonSimHandler) GetDiagnostics(
+	ctx context.Context,
+	port *voltha.PonSimPort,
+) (*voltha.PonSimDiagnostics, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"port":    port.Port,
+	}).Debug("Getting diagnostics")
+
+	if olt, ok := (handler.device).(*core.PonSimOltDevice); ok && port.Port != 0 {
+		child, ok := olt.GetOnus()[port.Port]
+		if !ok {
+			return nil, fmt.Errorf("unable to find ONU on port %d", port.Port)
+		}
+
+		conn, host, err := onuConn(child)
+		if err != nil {
+			return nil, err
+		}
+
+		diagnostics, err := voltha.NewPonSimClient(conn).GetDiagnostics(ctx, &voltha.PonSimPort{})
+		if err != nil {
+			nbiLogger.WithFields(logrus.Fields{
+				"handler": handler,
+				"host":    host,
+				"error":   err.Error(),
+			}).Error("Problem forwarding diagnostics request to ONU")
+			return nil, err
+		}
+		diagnostics.Port = port.Port
+
+		return diagnostics, nil
+	}
+
+	device := getPonSimDevice(handler.device)
+	if device == nil || device.Diagnostics == nil {
+		return nil, errors.New("device does not support diagnostics")
+	}
+
+	diagnostics := device.Diagnostics.MakeProto()
+	diagnostics.Port = port.Port
+
+	return diagnostics, nil
+}
+
+/*
+SelfTest runs the consistency checks of a PonSim device: the integrity of its flow table, the
+health of its queues and the reachability of its ONUs or of its OLT.  The port addresses the
+device as for GetInventory.
+*/
+func (handler *PonSimHandler) SelfTest(
+	ctx context.Context,
+	port *voltha.PonSimPort,
+) (*voltha.PonSimSelfTestResult, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"port":    port.Port,
+	}).Info("Running self-test")
+
+	var test *core.PonSimSelfTest
+
+	switch device := handler.device.(type) {
+	case *core.PonSimOltDevice:
+		if port.Port != 0 {
+			child, ok := device.GetOnus()[port.Port]
+			if !ok {
+				return nil, fmt.Errorf("unable to find ONU on port %d", port.Port)
+			}
+
+			conn, host, err := onuConn(child)
+			if err != nil {
+				return nil, err
+			}
+
+			result, err := voltha.NewPonSimClient(conn).SelfTest(ctx, &voltha.PonSimPort{})
+			if err != nil {
+				nbiLogger.WithFields(logrus.Fields{
+					"handler": handler,
+					"host":    host,
+					"error":   err.Error(),
+				}).Error("Problem forwarding self-test request to ONU")
+				return nil, err
+			}
+			result.Port = port.Port
+
+			return result, nil
+		}
+		test = device.SelfTest()
+	case *core.PonSimOnuDevice:
+		test = device.SelfTest()
+	default:
+		return nil, errors.New("device does not support self-tests")
+	}
+
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"port":    port.Port,
+		"passed":  test.Passed(),
+		"checks":  test.Checks,
+	}).Info("Ran self-test")
+
+	result := test.MakeProto()
+	result.Port = port.Port
+
+	return result, nil
+}
+
+/*
+SendOmci delivers an OMCI message to an ONU and returns its response
+*/
+func (handler *PonSimHandler) SendOmci(
+	ctx context.Context,
+	request *voltha.PonSimOmciMessage,
+) (*voltha.PonSimOmciMessage, error) {
+	nbiLogger.WithFields(logrus.Fields{
+		"handler": handler,
+		"port":    request.Port,
+	}).Debug("Sending OMCI message")
+
+	switch device := handler.device.(type) {
+	case *core.PonSimOltDevice:
+		if request.Port == 0 {
+			return nil, errors.New("OMCI messages are addressed to the port of an ONU")
+		}
+
+		child, ok := device.GetOnus()[request.Port]
+		if !ok {
+			return nil, fmt.Errorf("unable to find ONU on port %d", request.Port)
+		}
+
+		conn, host, err := onuConn(child)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := voltha.NewPonSimClient(conn).SendOmci(
+			ctx,
+			&voltha.PonSimOmciMessage{Message: request.Message},
+		)
+		if err != nil {
+			nbiLogger.WithFields(logrus.Fields{
+				"handler": handler,
+				"host":    host,
+				"error":   err.Error(),
+			}).Error("Problem forwarding OMCI message to ONU")
+			return nil, err
+		}
+		response.Port = request.Port
+
+		return response, nil
+
+	case *core.PonSimOnuDevice:
+		response, err := device.HandleOmci(request.Message)
+		if err != nil {
+			return nil, err
+		}
+
+		return &voltha.PonSimOmciMessage{Port: request.Port, Message: response}, nil
+	}
+
+	return nil, errors.New("device does not support OMCI")
+}
+
+/*
+onuConn returns the GRPC connection which the OLT established with an ONU when it registered,
+on which the credentials of the OLT are presented.  It is shared by all the requests relayed
+to the ONU rather than dialed for each of them.
+*/
+func onuConn(child *core.OnuRegistree) (*grpc.ClientConn, string, error) {
+	host := strings.Join([]string{
+		child.Device.Address,
+		strconv.Itoa(int(child.Device.Port)),
+	}, ":")
+
+	if child.Conn == nil {
+		return nil, host, fmt.Errorf("no connection to ONU %s", host)
+	}
+
+	return child.Conn, host, nil
+}
+
+/*
+responsePadding returns the filler which brings the size of a response up to the response size
+configured to stress the message limits of clients
+*/
+func (handler *PonSimHandler) responsePadding(response proto.Message) []byte {
+	device := getPonSimDevice(handler.device)
+	if device == nil || device.ResponseSize <= 0 {
+		return nil
+	}
+
+	// The padding field is encoded with a one byte tag and a varint length
+	missing := device.ResponseSize - proto.Size(response)
+	for size := missing - 1; size > 0; size-- {
+		if 1+proto.SizeVarint(uint64(size))+size <= missing {
+			return make([]byte, size)
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,112 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/opencord/voltha/ponsim/v2/common"
+	"github.com/opencord/voltha/protos/go/ponsim"
+	"github.com/opencord/voltha/protos/go/voltha"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+/*
+RestGateway exposes the PonSim and PonSimAdmin services of a device over REST/JSON, e.g.
+curl http://localhost:8080/api/v1/ponsim/stats, by proxying the requests to its GRPC server
+*/
+type RestGateway struct {
+	address  string
+	endpoint string
+	server   *http.Server
+}
+
+/*
+NewRestGateway instantiates a gateway listening on a port and forwarding the requests to
+the GRPC server of a device
+*/
+func NewRestGateway(address string, port int32, grpcPort int32) *RestGateway {
+	host := address
+	if host == "" {
+		host = "localhost"
+	}
+
+	return &RestGateway{
+		address:  net.JoinHostPort(address, strconv.Itoa(int(port))),
+		endpoint: net.JoinHostPort(host, strconv.Itoa(int(grpcPort))),
+	}
+}
+
+/*
+Start connects to the GRPC server and starts servicing REST requests
+*/
+func (g *RestGateway) Start(ctx context.Context) {
+	// TODO: make it secure
+	ta := credentials.NewTLS(&tls.Config{
+		InsecureSkipVerify: true,
+	})
+
+	options := append(common.GrpcDialOptions(), grpc.WithTransportCredentials(ta))
+	conn, err := grpc.DialContext(ctx, g.endpoint, options...)
+	if err != nil {
+		common.Logger().Fatalf("failed to connect the REST gateway to %s: %v", g.endpoint, err)
+	}
+
+	// Fields are named and populated as in the proto definitions
+	mux := runtime.NewServeMux(runtime.WithMarshalerOption(
+		runtime.MIMEWildcard,
+		&runtime.JSONPb{OrigName: true, EmitDefaults: true},
+	))
+
+	if err := voltha.RegisterPonSimHandler(ctx, mux, conn); err != nil {
+		common.Logger().Fatalf("failed to register the PonSim REST handler: %v", err)
+	}
+	if err := ponsim.RegisterPonSimAdminHandler(ctx, mux, conn); err != nil {
+		common.Logger().Fatalf("failed to register the PonSimAdmin REST handler: %v", err)
+	}
+
+	g.server = &http.Server{Addr: g.address, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		g.Stop()
+		conn.Close()
+	}()
+
+	common.Logger().WithFields(logrus.Fields{
+		"address":  g.address,
+		"endpoint": g.endpoint,
+	}).Info("Starting REST gateway")
+
+	if err := g.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		common.Logger().Fatalf("failed to serve REST requests: %v", err)
+	}
+}
+
+/*
+Stop servicing REST requests
+*/
+func (g *RestGateway) Stop() {
+	if g.server != nil {
+		g.server.Close()
+	}
+}
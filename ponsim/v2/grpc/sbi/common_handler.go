@@ -18,8 +18,6 @@ package sbi
 import (
 	"context"
 	"github.com/golang/protobuf/ptypes/empty"
-	"github.com/google/gopacket"
-	"github.com/google/gopacket/layers"
 	"github.com/opencord/voltha/ponsim/v2/common"
 	"github.com/opencord/voltha/ponsim/v2/core"
 	"github.com/opencord/voltha/protos/go/ponsim"
@@ -27,6 +25,9 @@ import (
 	"io"
 )
 
+// Logger of the southbound services, whose level is set independently
+var sbiLogger = common.Logger().Component(common.LOG_COMPONENT_SBI)
+
 type PonSimCommonHandler struct {
 	device core.PonSimInterface
 }
@@ -46,7 +47,7 @@ func NewPonSimCommonHandler(device core.PonSimInterface) *PonSimCommonHandler {
 ProcessData handles and forwards streaming INGRESS/EGRESS packets
 */
 func (h *PonSimCommonHandler) ProcessData(stream ponsim.PonSimCommon_ProcessDataServer) error {
-	common.Logger().WithFields(logrus.Fields{
+	sbiLogger.WithFields(logrus.Fields{
 		"handler": h,
 	}).Debug("Processing data")
 
@@ -56,30 +57,46 @@ func (h *PonSimCommonHandler) ProcessData(stream ponsim.PonSimCommon_ProcessData
 	for {
 
 		if data, err = stream.Recv(); err == io.EOF {
-			common.Logger().WithFields(logrus.Fields{
+			sbiLogger.WithFields(logrus.Fields{
 				"handler": h,
 			}).Warn("Streaming channel was closed")
 			return stream.SendAndClose(&empty.Empty{})
 		} else if err != nil {
-			common.Logger().WithFields(logrus.Fields{
+			sbiLogger.WithFields(logrus.Fields{
 				"handler": h,
 				"error":   err.Error(),
 			}).Warn("Error occurred with stream")
 			return err
 		}
 
-		frame := gopacket.NewPacket(data.Payload, layers.LayerTypeEthernet, gopacket.Default)
+		frame := common.NewFrame(data.Payload)
+		if len(data.Hash) > 0 {
+			common.SetFrameHash(frame, data.Hash)
+		}
+
+		ctx := context.Background()
+		if data.GemPort != 0 {
+			ctx = core.WithGemPort(ctx, data.GemPort)
+		}
+		if data.TraceParent != "" {
+			if parent, ok := common.ParseTraceparent(data.TraceParent); ok {
+				ctx = common.WithSpanContext(ctx, parent)
+			}
+		}
 
 		h.device.Forward(
-			context.Background(),
+			ctx,
 			int(data.Port),
 			frame,
 		)
-		common.Logger().WithFields(logrus.Fields{
-			"handler": h,
-			"frame":   frame,
-			"port":    data.Port,
-		}).Debug("Retrieved and forwarded packet")
+		if entry := sbiLogger.ForFrame(logrus.DebugLevel); entry != nil {
+			entry.WithFields(logrus.Fields{
+				"handler": h,
+				"frame":   frame,
+				"port":    data.Port,
+				"gemPort": data.GemPort,
+			}).Debug("Retrieved and forwarded packet")
+		}
 
 	}
 
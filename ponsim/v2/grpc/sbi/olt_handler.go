@@ -40,14 +40,46 @@ func (h *PonSimOltHandler) Register(
 	ctx context.Context,
 	request *ponsim.RegistrationRequest,
 ) (*ponsim.RegistrationReply, error) {
-	common.Logger().WithFields(logrus.Fields{
-		"handler": h,
+	sbiLogger.WithFields(logrus.Fields{
+		"handler":      h,
+		"serialNumber": request.SerialNumber,
 	}).Info("Registering device")
 
 	onu := &core.PonSimOnuDevice{
 		PonSimDevice: core.PonSimDevice{
 			Address: request.Address, Port: request.Port, //GrpcSecurity: h.olt.GrpcSecurity,
-		}}
+		},
+		VendorId:       request.VendorId,
+		SerialNumber:   request.SerialNumber,
+		RegistrationId: request.RegistrationId,
+		Loid:           request.Loid,
+		LoidPassword:   request.LoidPassword,
+		PonPort:        request.PonPort,
+		Distance:       request.DistanceM,
+	}
+
+	// The ONU keeps announcing its serial number until it is activated
+	if !h.olt.DiscoverOnu(onu) {
+		return &ponsim.RegistrationReply{
+			Id:            uuid.New().String(),
+			Status:        ponsim.RegistrationReply_PENDING_ACTIVATION,
+			StatusMessage: "Waiting for the activation of the ONU",
+			ParentAddress: common.GetInterfaceIP(h.olt.ExternalIf),
+			ParentPort:    h.olt.Port,
+			AssignedPort:  -1,
+		}, nil
+	}
+
+	if err := h.olt.AuthenticateOnu(onu); err != nil {
+		return &ponsim.RegistrationReply{
+			Id:            uuid.New().String(),
+			Status:        ponsim.RegistrationReply_AUTHENTICATION_FAILED,
+			StatusMessage: err.Error(),
+			ParentAddress: common.GetInterfaceIP(h.olt.ExternalIf),
+			ParentPort:    h.olt.Port,
+			AssignedPort:  -1,
+		}, nil
+	}
 
 	if assignedPort, err := h.olt.AddOnu(onu); assignedPort == -1 || err != nil {
 		return &ponsim.RegistrationReply{
@@ -59,19 +91,30 @@ func (h *PonSimOltHandler) Register(
 			AssignedPort:  assignedPort,
 		}, err
 	} else {
-		common.Logger().WithFields(logrus.Fields{
+		sbiLogger.WithFields(logrus.Fields{
 			"handler": h,
 			"onus":    h.olt.GetOnus(),
 		}).Debug("ONU Added")
 
-		return &ponsim.RegistrationReply{
+		reply := &ponsim.RegistrationReply{
 			Id:            uuid.New().String(),
 			Status:        ponsim.RegistrationReply_REGISTERED,
 			StatusMessage: "Successfully registered ONU",
 			ParentAddress: common.GetInterfaceIP(h.olt.ExternalIf),
 			ParentPort:    h.olt.Port,
 			AssignedPort:  assignedPort,
-		}, nil
+			PonPort:       onu.PonPort,
+		}
+		if onu := h.olt.GetOnu(assignedPort); onu != nil && onu.Tcont != nil {
+			reply.AllocId = onu.Tcont.AllocId
+			reply.GemPorts = onu.Tcont.GetGemPortIds()
+		}
+		if onu := h.olt.GetOnu(assignedPort); onu != nil && onu.Ranging != nil {
+			reply.RoundTripDelayNs = int64(onu.Ranging.RoundTripDelay)
+			reply.EqualizationDelayNs = int64(onu.Ranging.EqualizationDelay)
+		}
+
+		return reply, nil
 
 	}
 }
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2017-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package sbi
+
+import (
+	"context"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/opencord/voltha/ponsim/v2/core"
+	"github.com/opencord/voltha/protos/go/ponsim"
+	"github.com/sirupsen/logrus"
+)
+
+type PonSimOnuHandler struct {
+	onu *core.PonSimOnuDevice
+}
+
+func NewPonSimOnuHandler(onu *core.PonSimOnuDevice) *PonSimOnuHandler {
+	var handler *PonSimOnuHandler
+
+	handler = &PonSimOnuHandler{onu: onu}
+
+	return handler
+}
+
+/*
+RequestKey generates a new key for an encrypted GEM port on behalf of the OLT
+*/
+func (h *PonSimOnuHandler) RequestKey(
+	ctx context.Context,
+	request *ponsim.KeyRequest,
+) (*ponsim.KeyReply, error) {
+	sbiLogger.WithFields(logrus.Fields{
+		"handler":  h,
+		"gemPort":  request.GemPort,
+		"keyIndex": request.KeyIndex,
+	}).Info("Generating GEM port key")
+
+	key, err := h.onu.GenerateGemKey(request.GemPort, request.KeyIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ponsim.KeyReply{GemPort: request.GemPort, KeyIndex: request.KeyIndex, Key: key}, nil
+}
+
+/*
+SwitchKey makes the ONU encrypt a GEM port with a key previously generated
+*/
+func (h *PonSimOnuHandler) SwitchKey(
+	ctx context.Context,
+	request *ponsim.KeySwitch,
+) (*empty.Empty, error) {
+	if err := h.onu.ActivateGemKey(request.GemPort, request.KeyIndex); err != nil {
+		return nil, err
+	}
+
+	return &empty.Empty{}, nil
+}
@@ -17,28 +17,77 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"flag"
 	"fmt"
 	"github.com/opencord/voltha/ponsim/v2/common"
 	"github.com/opencord/voltha/ponsim/v2/core"
 	"github.com/opencord/voltha/ponsim/v2/grpc"
 	"log"
+	"math/rand"
+	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
 	"path"
+	"runtime"
+	"syscall"
+	"time"
 )
 
 // TODO: Cleanup logs
 
+const (
+	// Sample one blocking event per microsecond spent blocked, and one in five mutex contentions
+	DEBUG_BLOCK_PROFILE_RATE     = 1000
+	DEBUG_MUTEX_PROFILE_FRACTION = 5
+
+	// Address on which the ONUs simulated in-process and their OLT reach each other by default
+	SIM_ONU_ADDRESS = "127.0.0.1"
+
+	// Name of the UNI of a simulated ONU bridged to a host device
+	SIM_ONU_UNI = "uni"
+)
+
+// Build information, set at link time (-ldflags "-X main.version=... -X main.commit=...")
+var (
+	version = "unknown"
+	commit  = "unknown"
+)
+
 const (
 	default_name           = "PON"
 	default_grpc_port      = 50060
 	default_grpc_addr      = ""
+	default_rest_port      = 0
+	default_grpc_socket    = ""
 	default_device_type    = "OLT"
 	default_api_type       = "PONSIM"
 	default_internal_if    = "eth0"
 	default_external_if    = "eth1"
 	default_onus           = 1
+	default_pon_ports      = core.DEFAULT_PON_PORT_COUNT
+	default_pon_port       = 0
+	default_distance       = 0
+	default_dba_rate       = 0
+	default_tcont_profiles = ""
+	default_sim_onus       = 0
+	default_nni_lag        = ""
+	default_lldp_interval  = 0
+	default_lldp_chassis   = ""
+	default_lldp_port      = core.DEFAULT_LLDP_PORT_ID
+	default_fec            = false
+	default_pre_fec_ber    = 0
+	default_olt_tx_power   = core.DEFAULT_OLT_TX_POWER
+	default_onu_tx_power   = core.DEFAULT_ONU_TX_POWER
+	default_optical_drift  = core.DEFAULT_OPTICAL_DRIFT
+	default_ddm_temp       = core.DEFAULT_DDM_TEMPERATURE
+	default_ddm_voltage    = core.DEFAULT_DDM_VOLTAGE
+	default_ddm_bias       = core.DEFAULT_DDM_BIAS_CURRENT
+	default_ddm_noise      = core.DEFAULT_DDM_NOISE
+	default_pm_history     = 0
+	default_pm_interval    = int(core.PM_INTERVAL / time.Second)
+	default_pm_thresholds  = ""
 	default_alarm_sim      = false
 	default_alarm_freq     = 60
 	default_quiet          = false
@@ -48,6 +97,101 @@ const (
 	default_parent_port    = 50060
 	default_vcore_endpoint = "vcore"
 	default_fluentd_host   = ""
+	default_vendor_id      = "PSMO"
+	default_serial_number  = ""
+	default_reg_id         = ""
+	default_cir            = 0
+	default_pir            = 0
+	default_cbs            = 0
+	default_pbs            = 0
+	default_flow_journal   = ""
+	default_delay          = ""
+	default_onu_queue      = 16
+	default_onu_op_delay   = 0
+	default_keepalive      = 30
+	default_keepalive_wait = 10
+	default_faults         = ""
+	default_trap_queue     = 64
+	default_boot_delay     = 5
+	default_frame_hash     = false
+	default_seed           = 0
+	default_response_size  = 0
+	default_packet_io      = ""
+	default_tunnels        = ""
+	default_nni_bridge     = ""
+	default_uni_bridges    = ""
+	default_bridge_io      = core.BRIDGE_IO_AF_PACKET
+	default_mtu            = ""
+	default_dedup_window   = 0
+	default_inventory      = ""
+	default_identity       = ""
+	default_clock_drift    = 0.0
+	default_workers        = 0
+	default_outgoing_queue = 1
+	default_outgoing_drop  = "tail_drop"
+	default_priority_sched = ""
+	default_priority_queue = core.DEFAULT_PRIORITY_QUEUE_DEPTH
+	default_priority_wts   = ""
+	default_metrics_addr   = ""
+	default_sflow_addr     = ""
+	default_sflow_rate     = 256
+	default_sflow_interval = 20
+	default_ipfix_addr     = ""
+	default_ipfix_version  = core.IPFIX_VERSION
+	default_ipfix_active   = 60
+	default_ipfix_idle     = 15
+	default_snmp_addr      = ""
+	default_snmp_community = core.DEFAULT_SNMP_COMMUNITY
+	default_debug_addr     = ""
+	default_trace_endpoint = ""
+	default_trace_sampling = 1.0
+	default_grafana        = false
+	default_audit          = ""
+	default_rate_limit     = ""
+	default_api_token      = ""
+	default_api_jwt_secret = ""
+	default_compression    = common.COMPRESSION_NONE
+	default_kafka_brokers  = ""
+	default_kpi_topic      = core.DEFAULT_KPI_TOPIC
+	default_kpi_interval   = 15
+	default_alarm_topic    = core.DEFAULT_ALARM_TOPIC
+	default_loid           = ""
+	default_loid_password  = ""
+	default_onu_auth       = ""
+	default_onu_activation = core.ONU_ACTIVATION_AUTO
+	default_flow_store     = ""
+	default_checkpoint     = ""
+	default_chaos          = ""
+	default_chaos_interval = 10
+	default_pcap_dir       = ""
+	default_arp_bindings   = ""
+	default_echo_loss      = 0.0
+	default_echo_latency   = 0
+	default_table_miss     = core.TABLE_MISS_DROP
+
+	default_checkpoint_interval = 30
+
+	default_max_recv_msg_size        = 0
+	default_max_send_msg_size        = 0
+	default_initial_window_size      = 0
+	default_initial_conn_window_size = 0
+
+	default_child_grpc_port   = 50061
+	default_child_rest_port   = 0
+	default_child_internal_if = "eth2"
+	default_child_external_if = "eth3"
+
+	default_syslog_addr     = ""
+	default_syslog_facility = common.SYSLOG_DEFAULT_FACILITY
+	default_syslog_sd       = ""
+
+	default_log_file        = ""
+	default_log_max_size    = common.LOG_FILE_MAX_SIZE
+	default_log_max_backups = 0
+	default_log_max_age     = 0
+	default_frame_log_rate  = 1
+	default_frame_log_limit = 0
+	default_log_levels      = ""
 
 	default_snapshot_len = 65535
 	default_promiscuous  = false
@@ -64,11 +208,27 @@ var (
 	name           string = default_name + "_" + device_type
 	grpc_port      int    = default_grpc_port
 	grpc_addr      string = default_grpc_addr
+	rest_port      int    = default_rest_port
+	grpc_socket    string = default_grpc_socket
 	device_type    string = default_device_type
 	api_type       string = default_api_type
 	internal_if    string = default_internal_if
 	external_if    string = default_external_if
 	onus           int    = default_onus
+	pon_ports      int    = default_pon_ports
+	pon_port       int    = default_pon_port
+	distance       uint   = default_distance
+	dba_rate       int    = default_dba_rate
+	tcont_profiles string = default_tcont_profiles
+	sim_onus       int    = default_sim_onus
+	nni_lag        string = default_nni_lag
+	lldp_interval  int    = default_lldp_interval
+	lldp_chassis   string = default_lldp_chassis
+	lldp_port      string = default_lldp_port
+	fec            bool   = default_fec
+	pm_history     int    = default_pm_history
+	pm_interval    int    = default_pm_interval
+	pm_thresholds  string = default_pm_thresholds
 	alarm_sim      bool   = default_alarm_sim
 	alarm_freq     int    = default_alarm_freq
 	quiet          bool   = default_quiet
@@ -81,6 +241,110 @@ var (
 	parent_port    int    = default_parent_port
 	vcore_endpoint string = default_vcore_endpoint
 	fluentd_host   string = default_fluentd_host
+	vendor_id      string = default_vendor_id
+	serial_number  string = default_serial_number
+	reg_id         string = default_reg_id
+	cir            int    = default_cir
+	pir            int    = default_pir
+	cbs            int    = default_cbs
+	pbs            int    = default_pbs
+	flow_journal   string = default_flow_journal
+	delay          string = default_delay
+	onu_queue      int    = default_onu_queue
+	onu_op_delay   int    = default_onu_op_delay
+	keepalive      int    = default_keepalive
+	keepalive_wait int    = default_keepalive_wait
+	faults         string = default_faults
+	trap_queue     int    = default_trap_queue
+	boot_delay     int    = default_boot_delay
+	frame_hash     bool   = default_frame_hash
+	seed           int64  = default_seed
+	response_size  int    = default_response_size
+	packet_io      string = default_packet_io
+	tunnels        string = default_tunnels
+	nni_bridge     string = default_nni_bridge
+	uni_bridges    string = default_uni_bridges
+	bridge_io      string = default_bridge_io
+	mtu            string = default_mtu
+	dedup_window   int    = default_dedup_window
+	inventory      string = default_inventory
+	identity       string = default_identity
+	workers        int    = default_workers
+	outgoing_queue int    = default_outgoing_queue
+	outgoing_drop  string = default_outgoing_drop
+	priority_sched string = default_priority_sched
+	priority_queue int    = default_priority_queue
+	priority_wts   string = default_priority_wts
+	metrics_addr   string = default_metrics_addr
+	sflow_addr     string = default_sflow_addr
+	sflow_rate     int    = default_sflow_rate
+	sflow_interval int    = default_sflow_interval
+	ipfix_addr     string = default_ipfix_addr
+	ipfix_version  int    = default_ipfix_version
+	ipfix_active   int    = default_ipfix_active
+	ipfix_idle     int    = default_ipfix_idle
+	snmp_addr      string = default_snmp_addr
+	snmp_community string = default_snmp_community
+	debug_addr     string = default_debug_addr
+	trace_endpoint string = default_trace_endpoint
+	grafana        bool   = default_grafana
+	audit          string = default_audit
+	rate_limit     string = default_rate_limit
+	api_token      string = default_api_token
+	api_jwt_secret string = default_api_jwt_secret
+	compression    string = default_compression
+	kafka_brokers  string = default_kafka_brokers
+	kpi_topic      string = default_kpi_topic
+	kpi_interval   int    = default_kpi_interval
+	alarm_topic    string = default_alarm_topic
+	loid           string = default_loid
+	loid_password  string = default_loid_password
+	onu_auth       string = default_onu_auth
+	onu_activation string = default_onu_activation
+	flow_store     string = default_flow_store
+	checkpoint     string = default_checkpoint
+	chaos          string = default_chaos
+	chaos_interval int    = default_chaos_interval
+	pcap_dir       string = default_pcap_dir
+	arp_bindings   string = default_arp_bindings
+	echo_latency   int    = default_echo_latency
+	table_miss     string = default_table_miss
+
+	checkpoint_interval int = default_checkpoint_interval
+
+	max_recv_msg_size        int = default_max_recv_msg_size
+	max_send_msg_size        int = default_max_send_msg_size
+	initial_window_size      int = default_initial_window_size
+	initial_conn_window_size int = default_initial_conn_window_size
+
+	clock_drift    float64 = default_clock_drift
+	trace_sampling float64 = default_trace_sampling
+	pre_fec_ber    float64 = default_pre_fec_ber
+	olt_tx_power   float64 = default_olt_tx_power
+	onu_tx_power   float64 = default_onu_tx_power
+	optical_drift  float64 = default_optical_drift
+	ddm_temp       float64 = default_ddm_temp
+	ddm_voltage    float64 = default_ddm_voltage
+	ddm_bias       float64 = default_ddm_bias
+	ddm_noise      float64 = default_ddm_noise
+	echo_loss      float64 = default_echo_loss
+
+	child_grpc_port   int    = default_child_grpc_port
+	child_rest_port   int    = default_child_rest_port
+	child_internal_if string = default_child_internal_if
+	child_external_if string = default_child_external_if
+
+	syslog_addr     string = default_syslog_addr
+	syslog_facility string = default_syslog_facility
+	syslog_sd       string = default_syslog_sd
+
+	log_file        string = default_log_file
+	log_max_size    int    = default_log_max_size
+	log_max_backups int    = default_log_max_backups
+	log_max_age     int    = default_log_max_age
+	frame_log_rate  int    = default_frame_log_rate
+	frame_log_limit int    = default_frame_log_limit
+	log_levels      string = default_log_levels
 
 	snapshot_len int32 = default_snapshot_len
 	promiscuous  bool  = default_promiscuous
@@ -89,13 +353,37 @@ var (
 func init() {
 	parseArgs()
 
+	// Write the logs to a file rather than the standard output
+	if log_file != "" {
+		if err := common.Logger().SetLogFile(log_file, log_max_size, log_max_backups, log_max_age); err != nil {
+			log.Fatalf("Invalid log file configuration: %s", err.Error())
+		}
+	}
+
+	// Set the levels of the components logging independently
+	if err := common.Logger().SetComponentLevels(log_levels); err != nil {
+		log.Fatalf("Invalid log level configuration: %s", err.Error())
+	}
+
+	// Thin out the entries logged for every frame
+	if err := common.Logger().FrameSampler().Set(frame_log_rate, frame_log_limit); err != nil {
+		log.Fatalf("Invalid frame log configuration: %s", err.Error())
+	}
+
 	// Enable fluentd support
 	if fluentd_host != "" {
 		common.Logger().SetFluentd(fluentd_host)
 	}
 
-	// Print banner unless no_banner is specified
-	if !no_banner {
+	// Enable syslog support
+	if syslog_addr != "" {
+		if err := common.Logger().SetSyslog(syslog_addr, syslog_facility, syslog_sd); err != nil {
+			log.Fatalf("Invalid syslog configuration: %s", err.Error())
+		}
+	}
+
+	// Print banner unless no_banner is specified or only the dashboard is generated
+	if !no_banner && !grafana {
 		printBanner()
 	}
 }
@@ -112,7 +400,13 @@ func parseArgs() {
 	help = fmt.Sprintf("Port used to establish GRPC server connection")
 	flag.IntVar(&grpc_port, "grpc_port", default_grpc_port, help)
 
-	help = fmt.Sprintf("Type of device to simulate (OLT or ONU)")
+	help = fmt.Sprintf("Port on which the GRPC services are exposed over REST/JSON (disabled if 0)")
+	flag.IntVar(&rest_port, "rest_port", default_rest_port, help)
+
+	help = fmt.Sprintf("Unix socket on which the GRPC services are also served, e.g. for the adapters of the same pod (disabled if empty); the OLT role of a DUAL device appends .child to it")
+	flag.StringVar(&grpc_socket, "grpc_socket", default_grpc_socket, help)
+
+	help = fmt.Sprintf("Type of device to simulate (OLT, ONU or DUAL)")
 	flag.StringVar(&device_type, "device_type", default_device_type, help)
 
 	help = fmt.Sprintf("Type of API used to communicate with devices (PONSIM or BAL)")
@@ -127,9 +421,75 @@ func parseArgs() {
 	help = fmt.Sprintf("Enable promiscuous mode on network interfaces")
 	flag.BoolVar(&promiscuous, "promiscuous", default_promiscuous, help)
 
-	help = fmt.Sprintf("Number of ONUs to simulate")
+	help = fmt.Sprintf("Number of ONUs to simulate on each PON port")
 	flag.IntVar(&onus, "onus", default_onus, help)
 
+	help = fmt.Sprintf("Number of PON ports of the OLT (at most %d)", core.MAX_PON_PORT_COUNT)
+	flag.IntVar(&pon_ports, "pon_ports", default_pon_ports, help)
+
+	help = fmt.Sprintf("PON port of the OLT on which the ONU registers (0 for the least loaded)")
+	flag.IntVar(&pon_port, "pon_port", default_pon_port, help)
+
+	help = fmt.Sprintf("Fiber distance between the OLT and the ONU in meters, at most %d (ONU and simulated ONUs only)", core.MAX_ONU_DISTANCE)
+	flag.UintVar(&distance, "distance", default_distance, help)
+
+	help = fmt.Sprintf("Upstream capacity of each PON port in Mbps shared by the DBA between the T-CONTs, e.g. 1244 (OLT only, 0 to disable)")
+	flag.IntVar(&dba_rate, "dba_rate", default_dba_rate, help)
+
+	help = fmt.Sprintf("Priority (0 to %d, highest served first) and weight of the T-CONTs, as alloc_id:priority:weight entries separated by commas (OLT only, %d:%d otherwise)", core.MAX_TCONT_PRIORITY, core.DEFAULT_TCONT_PRIORITY, core.DEFAULT_TCONT_WEIGHT)
+	flag.StringVar(&tcont_profiles, "tcont_profiles", default_tcont_profiles, help)
+
+	help = fmt.Sprintf("Number of ONUs simulated in-process on each PON port, without interfaces, serving GRPC on the ports following grpc_port (OLT only)")
+	flag.IntVar(&sim_onus, "sim_onus", default_sim_onus, help)
+
+	help = fmt.Sprintf("Mode of the link aggregation group of two uplinks on the NNI, %s or %s (OLT only, disabled if not set)", core.LAG_ACTIVE_STANDBY, core.LAG_HASH)
+	flag.StringVar(&nni_lag, "nni_lag", default_nni_lag, help)
+
+	help = fmt.Sprintf("Interval in seconds between the LLDP frames sent on the NNI (OLT only, disabled if 0)")
+	flag.IntVar(&lldp_interval, "lldp_interval", default_lldp_interval, help)
+
+	help = fmt.Sprintf("Chassis ID announced by the LLDP frames sent on the NNI (OLT only, name of the device if not set)")
+	flag.StringVar(&lldp_chassis, "lldp_chassis", default_lldp_chassis, help)
+
+	help = fmt.Sprintf("Port ID announced by the LLDP frames sent on the NNI (OLT only)")
+	flag.StringVar(&lldp_port, "lldp_port", default_lldp_port, help)
+
+	help = fmt.Sprintf("Enable the forward error correction on the links of the ONUs (OLT only)")
+	flag.BoolVar(&fec, "fec", default_fec, help)
+
+	help = fmt.Sprintf("Bit error rate of the links of the ONUs before correction, at most %g (OLT only)", core.MAX_FEC_BIT_ERROR_RATE)
+	flag.Float64Var(&pre_fec_ber, "pre_fec_ber", default_pre_fec_ber, help)
+
+	help = fmt.Sprintf("Launch power of the PON ports (in dBm, OLT only)")
+	flag.Float64Var(&olt_tx_power, "olt_tx_power", default_olt_tx_power, help)
+
+	help = fmt.Sprintf("Launch power of the ONUs (in dBm, OLT only)")
+	flag.Float64Var(&onu_tx_power, "onu_tx_power", default_onu_tx_power, help)
+
+	help = fmt.Sprintf("Range within which the optical power levels drift, at most %g (in dB, OLT only)", core.MAX_OPTICAL_DRIFT)
+	flag.Float64Var(&optical_drift, "optical_drift", default_optical_drift, help)
+
+	help = fmt.Sprintf("Baseline temperature reported by the transceiver diagnostics (in Celsius)")
+	flag.Float64Var(&ddm_temp, "ddm_temperature", default_ddm_temp, help)
+
+	help = fmt.Sprintf("Baseline supply voltage reported by the transceiver diagnostics (in V)")
+	flag.Float64Var(&ddm_voltage, "ddm_voltage", default_ddm_voltage, help)
+
+	help = fmt.Sprintf("Baseline laser bias current reported by the transceiver diagnostics (in mA)")
+	flag.Float64Var(&ddm_bias, "ddm_bias_current", default_ddm_bias, help)
+
+	help = fmt.Sprintf("Standard deviation of the transceiver diagnostics relative to their baseline, at most %g", core.MAX_DDM_NOISE)
+	flag.Float64Var(&ddm_noise, "ddm_noise", default_ddm_noise, help)
+
+	help = fmt.Sprintf("Number of completed PM intervals retained (0 to disable PM collection)")
+	flag.IntVar(&pm_history, "pm_history", default_pm_history, help)
+
+	help = fmt.Sprintf("Length of the PM intervals (in seconds)")
+	flag.IntVar(&pm_interval, "pm_interval", default_pm_interval, help)
+
+	help = fmt.Sprintf("Thresholds of the PM counters raising TCA alarms, as port_name:counter:value entries separated by commas (OLT only)")
+	flag.StringVar(&pm_thresholds, "pm_thresholds", default_pm_thresholds, help)
+
 	help = fmt.Sprintf("Suppress debug and info logs")
 	flag.BoolVar(&quiet, "quiet", default_quiet, help)
 
@@ -157,6 +517,276 @@ func parseArgs() {
 	help = fmt.Sprintf("Fluentd host address")
 	flag.StringVar(&fluentd_host, "fluentd", default_fluentd_host, help)
 
+	help = fmt.Sprintf("Syslog collector to which the logs are shipped as RFC 5424 messages, as [udp://|tcp://]host[:port] (disabled if empty)")
+	flag.StringVar(&syslog_addr, "syslog_addr", default_syslog_addr, help)
+
+	help = fmt.Sprintf("Facility of the syslog messages, by name (e.g. daemon, local0 to local7) or code")
+	flag.StringVar(&syslog_facility, "syslog_facility", default_syslog_facility, help)
+
+	help = fmt.Sprintf("Structured data element added to the syslog messages, as its SD-ID followed by name=value parameters separated by commas, e.g. olt@32473,site=lab,rack=3")
+	flag.StringVar(&syslog_sd, "syslog_sd", default_syslog_sd, help)
+
+	help = fmt.Sprintf("File to which the logs are written instead of the standard output (disabled if empty)")
+	flag.StringVar(&log_file, "log_file", default_log_file, help)
+
+	help = fmt.Sprintf("Size above which the log file is rotated (in MB)")
+	flag.IntVar(&log_max_size, "log_max_size", default_log_max_size, help)
+
+	help = fmt.Sprintf("Number of rotated log files retained (0 to retain all)")
+	flag.IntVar(&log_max_backups, "log_max_backups", default_log_max_backups, help)
+
+	help = fmt.Sprintf("Age after which a rotated log file is removed (in days, 0 to retain all)")
+	flag.IntVar(&log_max_age, "log_max_age", default_log_max_age, help)
+
+	help = fmt.Sprintf("Log one in this number of the entries logged for every frame (1 logs them all)")
+	flag.IntVar(&frame_log_rate, "frame_log_rate", default_frame_log_rate, help)
+
+	help = fmt.Sprintf("Maximum number of entries logged for the frames per second (0 for no limit)")
+	flag.IntVar(&frame_log_limit, "frame_log_limit", default_frame_log_limit, help)
+
+	help = fmt.Sprintf("Log levels of the components, as component=level entries separated by commas, e.g. forwarding=warn,nbi=debug (components: nbi, sbi, forwarding, alarm and default for the other entries)")
+	flag.StringVar(&log_levels, "log_levels", default_log_levels, help)
+
+	help = fmt.Sprintf("Vendor identifier reported by the ONU")
+	flag.StringVar(&vendor_id, "vendor_id", default_vendor_id, help)
+
+	help = fmt.Sprintf("Serial number of the ONU (derived from the vendor id when empty)")
+	flag.StringVar(&serial_number, "serial_number", default_serial_number, help)
+
+	help = fmt.Sprintf("Registration identifier (password) presented by the ONU")
+	flag.StringVar(&reg_id, "registration_id", default_reg_id, help)
+
+	help = fmt.Sprintf("Logical ONU identifier (LOID) presented by the ONU to authenticate with the OLT")
+	flag.StringVar(&loid, "loid", default_loid, help)
+
+	help = fmt.Sprintf("Password of the LOID presented by the ONU")
+	flag.StringVar(&loid_password, "loid_password", default_loid_password, help)
+
+	help = fmt.Sprintf("Credentials expected by the OLT from each ONU, as serial:loid:loid[:password], serial:reg_id:registration_id or serial:none entries separated by commas, * matching any other ONU (no authentication if empty)")
+	flag.StringVar(&onu_auth, "onu_auth", default_onu_auth, help)
+
+	help = fmt.Sprintf("Activation of the registering ONUs, %s or %s to wait for an ActivateOnu request once their serial number is discovered (OLT only)", core.ONU_ACTIVATION_AUTO, core.ONU_ACTIVATION_MANUAL)
+	flag.StringVar(&onu_activation, "onu_activation", default_onu_activation, help)
+
+	help = fmt.Sprintf("Committed information rate of the UNI port in kbps (ONU only, 0 to disable)")
+	flag.IntVar(&cir, "cir", default_cir, help)
+
+	help = fmt.Sprintf("Peak information rate of the UNI port in kbps (ONU only, 0 to disable)")
+	flag.IntVar(&pir, "pir", default_pir, help)
+
+	help = fmt.Sprintf("Committed burst size of the UNI port in bytes")
+	flag.IntVar(&cbs, "cbs", default_cbs, help)
+
+	help = fmt.Sprintf("Peak burst size of the UNI port in bytes")
+	flag.IntVar(&pbs, "pbs", default_pbs, help)
+
+	help = fmt.Sprintf("File used to journal flows so they are restored after a restart")
+	flag.StringVar(&flow_journal, "flow_journal", default_flow_journal, help)
+
+	help = fmt.Sprintf("Latency added to frames, as port:direction:distribution:delay[:jitter] entries separated by commas")
+	flag.StringVar(&delay, "delay", default_delay, help)
+
+	help = fmt.Sprintf("Maximum number of operations pending on an ONU before it reports being busy")
+	flag.IntVar(&onu_queue, "onu_queue", default_onu_queue, help)
+
+	help = fmt.Sprintf("Time taken by an ONU to process an operation (in milliseconds)")
+	flag.IntVar(&onu_op_delay, "onu_op_delay", default_onu_op_delay, help)
+
+	help = fmt.Sprintf("Interval between keepalives sent on idle GRPC connections (in seconds)")
+	flag.IntVar(&keepalive, "keepalive", default_keepalive, help)
+
+	help = fmt.Sprintf("Time to wait for a keepalive acknowledgement before closing a connection (in seconds)")
+	flag.IntVar(&keepalive_wait, "keepalive_wait", default_keepalive_wait, help)
+
+	help = fmt.Sprintf("Frames dropped or corrupted on receipt, as port:drop_percent:corrupt_percent entries separated by commas")
+	flag.StringVar(&faults, "faults", default_faults, help)
+
+	help = fmt.Sprintf("Number of control frames (EAPOL, DHCP, IGMP) queued towards VOLTHA ahead of data frames")
+	flag.IntVar(&trap_queue, "trap_queue", default_trap_queue, help)
+
+	help = fmt.Sprintf("Number of data frames queued towards VOLTHA")
+	flag.IntVar(&outgoing_queue, "outgoing_queue", default_outgoing_queue, help)
+
+	help = fmt.Sprintf("Policy applied when the queue of data frames towards VOLTHA is full (%s, %s or %s)", core.QUEUE_TAIL_DROP, core.QUEUE_HEAD_DROP, core.QUEUE_BLOCK)
+	flag.StringVar(&outgoing_drop, "outgoing_drop", default_outgoing_drop, help)
+
+	help = fmt.Sprintf("Scheduling of the data frames towards VOLTHA queued by 802.1p priority, %s or %s (OLT only, disabled if empty)", core.SCHED_STRICT, core.SCHED_WRR)
+	flag.StringVar(&priority_sched, "priority_sched", default_priority_sched, help)
+
+	help = fmt.Sprintf("Number of data frames held by each priority queue, with the policy of the outgoing queue")
+	flag.IntVar(&priority_queue, "priority_queue", default_priority_queue, help)
+
+	help = fmt.Sprintf("WRR weights of the priorities 0 to 7 separated by commas (the priority plus one if empty)")
+	flag.StringVar(&priority_wts, "priority_weights", default_priority_wts, help)
+
+	help = fmt.Sprintf("Time taken by the device to boot after a reboot (in seconds)")
+	flag.IntVar(&boot_delay, "boot_delay", default_boot_delay, help)
+
+	help = fmt.Sprintf("Carry a hash of each frame so that receivers can verify it was delivered unmodified")
+	flag.BoolVar(&frame_hash, "frame_hash", default_frame_hash, help)
+
+	help = fmt.Sprintf("Seed of the random generator driving the simulation (derived from the start time when 0)")
+	flag.Int64Var(&seed, "seed", default_seed, help)
+
+	help = fmt.Sprintf("Size in bytes towards which device information and statistics responses are padded, to stress client message limits (0 to disable)")
+	flag.IntVar(&response_size, "response_size", default_response_size, help)
+
+	help = fmt.Sprintf("Backend exchanging the frames of the network interfaces: pcap, udp or none (auto selects pcap when raw sockets are available)")
+	flag.StringVar(&packet_io, "packet_io", default_packet_io, help)
+
+	help = fmt.Sprintf("UDP tunnels replacing network interfaces with the udp backend, as interface=local_address/peer_address entries separated by commas")
+	flag.StringVar(&tunnels, "tunnels", default_tunnels, help)
+
+	help = fmt.Sprintf("Host tap or veth device to which the NNI of the OLT is bridged through an AF_PACKET socket, e.g. to reach a real BNG")
+	flag.StringVar(&nni_bridge, "nni_bridge", default_nni_bridge, help)
+
+	help = fmt.Sprintf("Host devices to which the UNIs of the simulated ONUs are bridged through AF_PACKET sockets, as onu=device entries separated by commas (ONUs numbered from 1)")
+	flag.StringVar(&uni_bridges, "uni_bridges", default_uni_bridges, help)
+
+	help = fmt.Sprintf("Sockets of the bridged host devices (afpacket, or afxdp when built with the afxdp tag, falling back to afpacket)")
+	flag.StringVar(&bridge_io, "bridge_io", default_bridge_io, help)
+
+	help = fmt.Sprintf("MTU of the ports, as port:mtu entries separated by commas (up to %d for jumbo frames)", core.MAX_MTU)
+	flag.StringVar(&mtu, "mtu", default_mtu, help)
+
+	help = fmt.Sprintf("Window within which exact duplicates of a frame received on the same port are dropped (in milliseconds, 0 to disable)")
+	flag.IntVar(&dedup_window, "dedup_window", default_dedup_window, help)
+
+	help = fmt.Sprintf("Inventory metadata of the device, as clli, rack, shelf, slot and gps (latitude:longitude) key=value entries separated by commas")
+	flag.StringVar(&inventory, "inventory", default_inventory, help)
+
+	help = fmt.Sprintf("Identity reported by the device, as vendor, model, hw (hardware version), fw (firmware version) and serial key=value entries separated by commas")
+	flag.StringVar(&identity, "identity", default_identity, help)
+
+	help = fmt.Sprintf("Rate at which the device time drifts from the real time until resynchronized (in parts per million, up to %d)", core.MAX_CLOCK_DRIFT)
+	flag.Float64Var(&clock_drift, "clock_drift", default_clock_drift, help)
+
+	help = fmt.Sprintf("Number of workers processing frames in parallel while preserving the order of each flow (0 to process frames as they are received)")
+	flag.IntVar(&workers, "workers", default_workers, help)
+
+	help = fmt.Sprintf("Address on which the metrics of the devices are exposed to Prometheus under /metrics, e.g. :9101 (disabled if empty)")
+	flag.StringVar(&metrics_addr, "metrics_addr", default_metrics_addr, help)
+
+	help = fmt.Sprintf("sFlow collector to which the forwarded frames are sampled, as host[:port] (port %d by default, disabled if empty)", core.DEFAULT_SFLOW_PORT)
+	flag.StringVar(&sflow_addr, "sflow_addr", default_sflow_addr, help)
+
+	help = fmt.Sprintf("Average number of forwarded frames per sFlow sample")
+	flag.IntVar(&sflow_rate, "sflow_rate", default_sflow_rate, help)
+
+	help = fmt.Sprintf("Interval at which the interface counters are exported to the sFlow collector (in seconds, 0 to disable)")
+	flag.IntVar(&sflow_interval, "sflow_interval", default_sflow_interval, help)
+
+	help = fmt.Sprintf("Collector to which the IP flows crossing the NNI of the OLT are exported, as host[:port] (port %d for IPFIX and %d for NetFlow v9 by default, disabled if empty)", core.DEFAULT_IPFIX_PORT, core.DEFAULT_NETFLOW_V9_PORT)
+	flag.StringVar(&ipfix_addr, "ipfix_addr", default_ipfix_addr, help)
+
+	help = fmt.Sprintf("Version of the exported flow records (%d for IPFIX, %d for NetFlow v9)", core.IPFIX_VERSION, core.NETFLOW_V9_VERSION)
+	flag.IntVar(&ipfix_version, "ipfix_version", default_ipfix_version, help)
+
+	help = fmt.Sprintf("Time after which an active flow is exported and accounted anew (in seconds)")
+	flag.IntVar(&ipfix_active, "ipfix_active", default_ipfix_active, help)
+
+	help = fmt.Sprintf("Time without frames after which a flow is exported (in seconds)")
+	flag.IntVar(&ipfix_idle, "ipfix_idle", default_ipfix_idle, help)
+
+	help = fmt.Sprintf("UDP address on which an SNMP agent exposes the interfaces and statistics of the devices, e.g. :1161 (disabled if empty)")
+	flag.StringVar(&snmp_addr, "snmp_addr", default_snmp_addr, help)
+
+	help = fmt.Sprintf("Community of the SNMP requests, followed by @ and the name of a simulated ONU to select it")
+	flag.StringVar(&snmp_community, "snmp_community", default_snmp_community, help)
+
+	help = fmt.Sprintf("Address on which the CPU, heap, goroutine and block profiles are exposed under /debug/pprof, e.g. localhost:6060 (disabled if empty)")
+	flag.StringVar(&debug_addr, "debug_addr", default_debug_addr, help)
+
+	help = fmt.Sprintf("OTLP/HTTP endpoint to which the spans of the forwarded frames are exported, e.g. http://jaeger:4318/v1/traces (disabled if empty)")
+	flag.StringVar(&trace_endpoint, "trace_endpoint", default_trace_endpoint, help)
+
+	help = fmt.Sprintf("Ratio of the frames entering the simulator whose forwarding is traced (between 0 and 1)")
+	flag.Float64Var(&trace_sampling, "trace_sampling", default_trace_sampling, help)
+
+	help = fmt.Sprintf("Print a Grafana dashboard charting the metrics exposed to Prometheus and exit")
+	flag.BoolVar(&grafana, "grafana_dashboard", default_grafana, help)
+
+	help = fmt.Sprintf("RPCs whose calls are published as audit events on the event bus, separated by commas (all for every RPC changing the state of the simulator)")
+	flag.StringVar(&audit, "audit", default_audit, help)
+
+	help = fmt.Sprintf("Rates at which the management RPCs are accepted before calls are rejected, as rpc=calls_per_second[:burst] entries separated by commas (all for every RPC changing the state of the simulator)")
+	flag.StringVar(&rate_limit, "rate_limit", default_rate_limit, help)
+
+	help = fmt.Sprintf("Catalog of faults randomly injected into the device, as fault=probability[:duration] entries separated by commas (onu_drop, link_flap, rpc_delay or flow_failure; disabled if empty)")
+	flag.StringVar(&chaos, "chaos", default_chaos, help)
+
+	help = fmt.Sprintf("Interval in seconds at which each fault of the chaos catalog is injected with its probability")
+	flag.IntVar(&chaos_interval, "chaos_interval", default_chaos_interval, help)
+
+	help = fmt.Sprintf("Directory holding the capture files replayed into the device and written by the port mirrors (disabled if empty)")
+	flag.StringVar(&pcap_dir, "pcap_dir", default_pcap_dir, help)
+
+	help = fmt.Sprintf("Addresses of the subscriber hosts for which the ONU answers the ARP requests received on its UNI, as ip=mac entries separated by commas (ONU only)")
+	flag.StringVar(&arp_bindings, "arp_bindings", default_arp_bindings, help)
+
+	help = fmt.Sprintf("Percentage of the ICMP echo requests for the bound subscriber addresses left unanswered (ONU only)")
+	flag.Float64Var(&echo_loss, "echo_loss", default_echo_loss, help)
+
+	help = fmt.Sprintf("Latency of the ICMP echo replies of the bound subscriber addresses in milliseconds (ONU only)")
+	flag.IntVar(&echo_latency, "echo_latency", default_echo_latency, help)
+
+	help = fmt.Sprintf("What the flows do with the frames matching none of them (drop, flood or controller)")
+	flag.StringVar(&table_miss, "table_miss", default_table_miss, help)
+
+	help = fmt.Sprintf("Token which callers must present as \"authorization: Bearer <token>\" metadata to call the RPCs changing the state of the simulator (disabled if empty)")
+	flag.StringVar(&api_token, "api_token", default_api_token, help)
+
+	help = fmt.Sprintf("Secret with which the JSON Web Tokens (HS256) presented by the callers of the RPCs changing the state of the simulator are signed (disabled if empty)")
+	flag.StringVar(&api_jwt_secret, "api_jwt_secret", default_api_jwt_secret, help)
+
+	help = fmt.Sprintf("Compression of the frames streamed to the parent OLT or the child ONUs (none, gzip or snappy); any of them is accepted from the peers")
+	flag.StringVar(&compression, "compression", default_compression, help)
+
+	help = fmt.Sprintf("Largest GRPC message received by the simulator (in bytes, 0 for the GRPC default of 4MB)")
+	flag.IntVar(&max_recv_msg_size, "max_recv_msg_size", default_max_recv_msg_size, help)
+
+	help = fmt.Sprintf("Largest GRPC message sent by the simulator (in bytes, 0 for the GRPC default)")
+	flag.IntVar(&max_send_msg_size, "max_send_msg_size", default_max_send_msg_size, help)
+
+	help = fmt.Sprintf("Initial flow control window of the GRPC streams (in bytes, at least %d, 0 for the GRPC default)", common.MIN_GRPC_WINDOW_SIZE)
+	flag.IntVar(&initial_window_size, "initial_window_size", default_initial_window_size, help)
+
+	help = fmt.Sprintf("Initial flow control window of the GRPC connections (in bytes, at least %d, 0 for the GRPC default)", common.MIN_GRPC_WINDOW_SIZE)
+	flag.IntVar(&initial_conn_window_size, "initial_conn_window_size", default_initial_conn_window_size, help)
+
+	help = fmt.Sprintf("Kafka brokers on which the simulator publishes its events, as host:port entries separated by commas (disabled if empty)")
+	flag.StringVar(&kafka_brokers, "kafka_brokers", default_kafka_brokers, help)
+
+	help = fmt.Sprintf("Kafka topic on which the metrics of the devices are published as KPI events")
+	flag.StringVar(&kpi_topic, "kpi_topic", default_kpi_topic, help)
+
+	help = fmt.Sprintf("Interval at which the metrics of the devices are published on Kafka (in seconds, 0 to disable)")
+	flag.IntVar(&kpi_interval, "kpi_interval", default_kpi_interval, help)
+
+	help = fmt.Sprintf("Kafka topic on which the simulated alarms are published as alarm events (disabled if empty)")
+	flag.StringVar(&alarm_topic, "alarm_topic", default_alarm_topic, help)
+
+	help = fmt.Sprintf("Key/value store in which flow tables are saved so they are restored by a new instance, as consul://host:port or etcd://host:port (disabled if empty)")
+	flag.StringVar(&flow_store, "flow_store", default_flow_store, help)
+
+	help = fmt.Sprintf("File in which the ONU registrations, port states and counters are saved so they are restored after a restart (disabled if empty)")
+	flag.StringVar(&checkpoint, "checkpoint", default_checkpoint, help)
+
+	help = fmt.Sprintf("Interval at which the state of the devices is saved to the checkpoint file (in seconds)")
+	flag.IntVar(&checkpoint_interval, "checkpoint_interval", default_checkpoint_interval, help)
+
+	help = fmt.Sprintf("Port used by the OLT role of a DUAL device to serve its child ONUs")
+	flag.IntVar(&child_grpc_port, "child_grpc_port", default_child_grpc_port, help)
+
+	help = fmt.Sprintf("Port on which the OLT role of a DUAL device exposes its GRPC services over REST/JSON (disabled if 0)")
+	flag.IntVar(&child_rest_port, "child_rest_port", default_child_rest_port, help)
+
+	help = fmt.Sprintf("NNI interface of the OLT role of a DUAL device, connected to the UNI of the ONU role")
+	flag.StringVar(&child_internal_if, "child_internal_if", default_child_internal_if, help)
+
+	help = fmt.Sprintf("External interface of the OLT role of a DUAL device")
+	flag.StringVar(&child_external_if, "child_external_if", default_child_external_if, help)
+
 	flag.Parse()
 }
 
@@ -172,6 +802,9 @@ func printBanner() {
 		printOltBanner()
 	case "ONU":
 		printOnuBanner()
+	case "DUAL":
+		printOnuBanner()
+		printOltBanner()
 	}
 
 	log.Println("(to stop: press Ctrl-C)")
@@ -195,24 +828,40 @@ func printOnuBanner() {
 -----------------------------------------------------------------
 */
 type PonSimService struct {
-	device core.PonSimInterface
-	server *grpc.GrpcServer
+	device  core.PonSimInterface
+	server  *grpc.GrpcServer
+	gateway *grpc.RestGateway
 }
 
 func (s *PonSimService) Start(ctx context.Context) {
 	// GRPC server needs to be secure.
 	// Otherwise communication between adapter and simulator does not occur
 	s.server = grpc.NewGrpcServer(s.device.GetAddress(), s.device.GetPort(), certs, true)
+	s.server.SetKeepalive(time.Duration(keepalive)*time.Second, time.Duration(keepalive_wait)*time.Second)
+	if socket := s.device.GetSocket(); socket != "" {
+		s.server.SetSocket(socket)
+	}
 
 	// Add GRPC services
 	s.server.AddCommonService(s.device)
 	s.server.AddPonSimService(s.device)
+	s.server.AddAdminService(s.device)
+	s.server.AddRequestIdInterceptor()
+	s.server.AddAuditInterceptor(s.device)
+	s.server.AddAuthInterceptor(s.device)
+	s.server.AddRateLimitInterceptor(s.device)
+	s.server.AddChaosInterceptor(s.device)
 
 	// Add OLT specific services
-	if device_type == core.OLT.String() {
+	if _, ok := s.device.(*core.PonSimOltDevice); ok {
 		s.server.AddOltService(s.device)
 	}
 
+	// Add ONU specific services
+	if _, ok := s.device.(*core.PonSimOnuDevice); ok {
+		s.server.AddOnuService(s.device)
+	}
+
 	// Add XPON services unless using BAL
 	if api_type == core.PONSIM.String() {
 		s.server.AddXPonService()
@@ -223,6 +872,12 @@ func (s *PonSimService) Start(ctx context.Context) {
 	// Start the GRPC server
 	go s.server.Start(ctx)
 
+	// Expose the GRPC services over REST/JSON
+	if port := s.device.GetRestPort(); port > 0 {
+		s.gateway = grpc.NewRestGateway(s.device.GetAddress(), port, s.device.GetPort())
+		go s.gateway.Start(ctx)
+	}
+
 	// Start the PON device
 	go s.device.Start(ctx)
 }
@@ -231,12 +886,492 @@ func (s *PonSimService) Stop(ctx context.Context) {
 	// Stop PON device
 	s.device.Stop(ctx)
 
+	// Stop REST gateway
+	if s.gateway != nil {
+		s.gateway.Stop()
+	}
+
 	// Stop GRPC server
 	s.server.Stop()
 }
 
+/*
+newOltDevice constructs an OLT device from the common parameters
+*/
+func newOltDevice(pon core.PonSimDevice) core.PonSimInterface {
+	device := core.NewPonSimOltDevice(pon)
+	device.MaxOnuCount = onus
+	device.VCoreEndpoint = vcore_endpoint
+	device.OnuQueueDepth = onu_queue
+	device.OnuOperationDelay = time.Duration(onu_op_delay) * time.Millisecond
+	device.TrapQueueDepth = trap_queue
+	device.FlowShadow = core.NewPonSimFlowShadow()
+
+	if ports, err := core.NewPonSimPonPorts(pon_ports, onus); err != nil {
+		log.Fatalf("Invalid PON port configuration: %s", err.Error())
+	} else {
+		device.PonPorts = ports
+	}
+
+	if dba_rate > 0 {
+		profiles, err := core.ParseTcontProfiles(tcont_profiles)
+		if err != nil {
+			log.Fatalf("Invalid T-CONT profile configuration: %s", err.Error())
+		}
+		for _, pon := range device.PonPorts {
+			if pon.Dba, err = core.NewPonSimDba(dba_rate, profiles); err != nil {
+				log.Fatalf("Invalid DBA configuration: %s", err.Error())
+			}
+		}
+	}
+
+	if _, err := core.NewPonSimFec(fec, pre_fec_ber); err != nil {
+		log.Fatalf("Invalid FEC configuration: %s", err.Error())
+	} else {
+		device.FecEnabled = fec
+		device.FecBitErrorRate = pre_fec_ber
+	}
+
+	if _, err := core.NewPonSimOptics(onu_tx_power, 0, optical_drift); err != nil {
+		log.Fatalf("Invalid optics configuration: %s", err.Error())
+	} else {
+		device.OnuTxPower = onu_tx_power
+		device.OpticalDrift = optical_drift
+		for _, pon := range device.PonPorts {
+			pon.TxPower = olt_tx_power
+		}
+	}
+
+	if lag, err := core.NewPonSimLag(nni_lag); err != nil {
+		log.Fatalf("Invalid NNI LAG configuration: %s", err.Error())
+	} else {
+		device.Lag = lag
+	}
+
+	chassisId := lldp_chassis
+	if chassisId == "" {
+		chassisId = pon.Name
+	}
+	if lldp, err := core.NewPonSimLldp(chassisId, lldp_port, time.Duration(lldp_interval)*time.Second); err != nil {
+		log.Fatalf("Invalid LLDP configuration: %s", err.Error())
+	} else {
+		device.Lldp = lldp
+	}
+
+	if auth, err := core.ParseOnuAuth(onu_auth); err != nil {
+		log.Fatalf("Invalid ONU authentication configuration: %s", err.Error())
+	} else {
+		device.OnuAuth = auth
+	}
+
+	if activation, err := core.NewPonSimOnuActivation(onu_activation); err != nil {
+		log.Fatalf("Invalid ONU activation configuration: %s", err.Error())
+	} else {
+		device.OnuActivation = activation
+	}
+
+	if outgoing, err := core.NewPonSimFrameQueue(outgoing_queue, outgoing_drop); err != nil {
+		log.Fatalf("Invalid outgoing queue configuration: %s", err.Error())
+	} else {
+		device.Outgoing = outgoing
+	}
+
+	if priority_sched != "" {
+		weights, err := core.ParsePriorityWeights(priority_wts)
+		if err != nil {
+			log.Fatalf("Invalid priority weight configuration: %s", err.Error())
+		}
+		if device.Priorities, err = core.NewPonSimPriorityQueues(priority_sched, weights, priority_queue, outgoing_drop); err != nil {
+			log.Fatalf("Invalid priority queue configuration: %s", err.Error())
+		}
+	}
+
+	return device
+}
+
+/*
+newOnuDevice constructs an ONU device from the common parameters
+*/
+func newOnuDevice(pon core.PonSimDevice) core.PonSimInterface {
+	device := core.NewPonSimOnuDevice(pon)
+	device.ParentAddress = parent_addr
+	device.ParentPort = int32(parent_port)
+	device.VendorId = vendor_id
+	device.SerialNumber = serial_number
+	if device.SerialNumber == "" {
+		device.SerialNumber = core.DefaultOnuSerialNumber(vendor_id, pon.InternalIf)
+	}
+	device.RegistrationId = reg_id
+	device.Loid = loid
+	device.LoidPassword = loid_password
+	device.PonPort = int32(pon_port)
+
+	if bindings, err := core.ParseArpBindings(arp_bindings); err != nil {
+		log.Fatalf("Invalid ARP binding configuration: %s", err.Error())
+	} else {
+		for _, binding := range bindings {
+			device.SetArpBinding(binding)
+		}
+	}
+
+	if err := device.GetEchoResponder().Set(float32(echo_loss), time.Duration(echo_latency)*time.Millisecond); err != nil {
+		log.Fatalf("Invalid ICMP echo configuration: %s", err.Error())
+	}
+
+	if _, err := core.NewPonSimRanging(uint32(distance)); err != nil {
+		log.Fatalf("Invalid distance configuration: %s", err.Error())
+	} else {
+		device.Distance = uint32(distance)
+	}
+
+	return device
+}
+
+/*
+newChildDevice derives the parameters of the OLT role of a dual mode device, which serves
+its child ONUs on a separate GRPC port and whose NNI faces the UNI of the ONU role
+*/
+func newChildDevice(pon core.PonSimDevice) core.PonSimDevice {
+	childName := pon.Name + "_CHILD"
+
+	child := core.PonSimDevice{
+		Name:        childName,
+		ExternalIf:  child_external_if,
+		InternalIf:  child_internal_if,
+		Promiscuous: pon.Promiscuous,
+		SnapshotLen: pon.SnapshotLen,
+		Address:     pon.Address,
+		Port:        int32(child_grpc_port),
+		RestPort:    int32(child_rest_port),
+		AlarmsOn:    pon.AlarmsOn,
+		AlarmsFreq:  pon.AlarmsFreq,
+		AlarmSink:   pon.AlarmSink,
+		Counter:     core.NewPonSimMetricCounter(childName),
+		PortStates:  core.NewPonSimPortStates(),
+		Events:      core.NewPonSimEventBus(),
+		FlowStats:   core.NewPonSimFlowStats(),
+		Delays:      core.NewPonSimPortDelays(),
+		Faults:      core.NewPonSimPortFaults(),
+		Mtus:        core.NewPonSimPortMtus(),
+		BootDelay:   pon.BootDelay,
+		FrameHash:   pon.FrameHash,
+		RunInfo:     pon.RunInfo,
+		Jobs:        pon.Jobs,
+		PacketIO:    pon.PacketIO,
+		Inventory:   pon.Inventory,
+		Diagnostics: pon.Diagnostics.Copy(),
+		Clock:       pon.Clock,
+		Audit:       pon.Audit,
+		ApiAuth:     pon.ApiAuth,
+		Compression: pon.Compression,
+		PcapDir:     pon.PcapDir,
+		Mirror:      core.NewPonSimMirror(),
+		Cfm:         core.NewPonSimCfm(),
+		TableMiss:   pon.TableMiss.Copy(),
+	}
+
+	child.ResponseSize = pon.ResponseSize
+
+	if pon.FlowJournal != nil {
+		child.FlowJournal = core.NewPonSimFlowJournal(pon.FlowJournal.Path + ".child")
+	}
+
+	if pon.Socket != "" {
+		child.Socket = pon.Socket + ".child"
+	}
+
+	if pon.Checkpoint != nil {
+		child.Checkpoint = core.NewPonSimCheckpoint(pon.Checkpoint.Path+".child", pon.Checkpoint.Interval)
+	}
+
+	if pon.Pm != nil {
+		child.Pm, _ = core.NewPonSimPm(pon.Pm.Interval, pon.Pm.History)
+		for _, threshold := range pon.Pm.GetThresholds() {
+			child.Pm.SetThreshold(threshold)
+		}
+	}
+
+	if pon.FlowStore != nil {
+		child.FlowStore = core.NewPonSimFlowStore(pon.FlowStore.Store, childName)
+	}
+
+	if pon.RateLimit != nil {
+		child.RateLimit = core.NewPonSimRateLimit(pon.RateLimit.Limits)
+	}
+
+	if pon.Dedup != nil {
+		child.Dedup = core.NewPonSimDedup(pon.Dedup.Window)
+	}
+
+	if pon.Workers != nil {
+		child.Workers = core.NewPonSimWorkerPool(pon.Workers.Size, pon.Workers.QueueDepth)
+	}
+
+	return child
+}
+
+/*
+newSimOnuDevice constructs an ONU simulated in-process by the OLT, which has no network interfaces
+and serves its GRPC services on the port following the ports of the OLT and of the previous
+simulated ONUs.  The simulated ONUs are spread evenly over the PON ports.
+*/
+func newSimOnuDevice(pon core.PonSimDevice, index int, uniBridge string) core.PonSimInterface {
+	onuName := fmt.Sprintf("%s_ONU_%d", pon.Name, index+1)
+
+	// The ONUs and the OLT reach each other locally unless the OLT is bound to an address
+	address := pon.Address
+	if address == "" {
+		address = SIM_ONU_ADDRESS
+	}
+
+	// The UNI carries no frames unless it is bridged to a host device, e.g. the veth of a container
+	packetIO, _ := core.NewPonSimPacketIO(core.PACKET_IO_NONE, "")
+	packetIO.BridgeIO = pon.PacketIO.BridgeIO
+	externalIf := ""
+	if uniBridge != "" {
+		externalIf = SIM_ONU_UNI
+		if err := packetIO.Bridge(externalIf, uniBridge); err != nil {
+			log.Fatalf("Invalid UNI bridge configuration: %s", err.Error())
+		}
+	}
+
+	device := core.NewPonSimOnuDevice(core.PonSimDevice{
+		Name:        onuName,
+		Address:     address,
+		ExternalIf:  externalIf,
+		Port:        pon.Port + int32(index+1),
+		AlarmsFreq:  pon.AlarmsFreq,
+		Counter:     core.NewPonSimMetricCounter(onuName),
+		PortStates:  core.NewPonSimPortStates(),
+		Events:      core.NewPonSimEventBus(),
+		FlowStats:   core.NewPonSimFlowStats(),
+		Delays:      core.NewPonSimPortDelays(),
+		Faults:      core.NewPonSimPortFaults(),
+		Mtus:        core.NewPonSimPortMtus(),
+		BootDelay:   pon.BootDelay,
+		FrameHash:   pon.FrameHash,
+		RunInfo:     pon.RunInfo,
+		Jobs:        core.NewPonSimJobs(),
+		PacketIO:    packetIO,
+		Inventory:   pon.Inventory,
+		Diagnostics: pon.Diagnostics.Copy(),
+		Clock:       pon.Clock,
+		Audit:       pon.Audit,
+		ApiAuth:     pon.ApiAuth,
+		Compression: pon.Compression,
+		PcapDir:     pon.PcapDir,
+		Mirror:      core.NewPonSimMirror(),
+		Cfm:         core.NewPonSimCfm(),
+		TableMiss:   pon.TableMiss.Copy(),
+	})
+	device.ParentAddress = address
+	device.ParentPort = pon.Port
+	device.VendorId = vendor_id
+	device.SerialNumber = fmt.Sprintf("%s%08X", vendor_id, index+1)
+	device.PonPort = int32(core.PonPortNumber(index % pon_ports))
+	device.Distance = uint32(distance)
+
+	// Every simulated ONU accumulates its own PM intervals
+	if pon.Pm != nil {
+		device.Pm, _ = core.NewPonSimPm(pon.Pm.Interval, pon.Pm.History)
+	}
+
+	return device
+}
+
+/*
+metricCounters returns the metric counters of the devices
+*/
+func metricCounters(devices []core.PonSimInterface) []*core.PonSimMetricCounter {
+	var counters []*core.PonSimMetricCounter
+	for _, device := range devices {
+		switch d := device.(type) {
+		case *core.PonSimOltDevice:
+			counters = append(counters, d.Counter)
+		case *core.PonSimOnuDevice:
+			counters = append(counters, d.Counter)
+		}
+	}
+
+	return counters
+}
+
+/*
+serveMetrics exposes the metrics of the devices to Prometheus
+*/
+func serveMetrics(addr string, devices []core.PonSimInterface) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", core.NewPonSimMetricsHandler(metricCounters(devices)...))
+
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Fatalf("Unable to expose metrics on %s: %s", addr, err.Error())
+	}
+}
+
+/*
+serveDebug exposes the runtime profiles of the simulator to pprof, e.g.
+go tool pprof http://localhost:6060/debug/pprof/profile
+*/
+func serveDebug(addr string) {
+	// Blocking events and mutex contention are not sampled unless requested
+	runtime.SetBlockProfileRate(DEBUG_BLOCK_PROFILE_RATE)
+	runtime.SetMutexProfileFraction(DEBUG_MUTEX_PROFILE_FRACTION)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Fatalf("Unable to expose debug endpoints on %s: %s", addr, err.Error())
+	}
+}
+
+/*
+publishKpis periodically publishes the metrics of the devices as KPI events on Kafka
+*/
+func publishKpis(ctx context.Context, brokers []string, devices []core.PonSimInterface, clock *core.PonSimClock) {
+	producer, err := common.NewKafkaProducer(brokers, kpi_topic)
+	if err != nil {
+		log.Fatalf("Invalid Kafka configuration: %s", err.Error())
+	}
+
+	core.NewPonSimKpiPublisher(
+		producer,
+		time.Duration(kpi_interval)*time.Second,
+		clock,
+		metricCounters(devices)...,
+	).Start(ctx)
+}
+
+/*
+exportSflow samples the frames forwarded by the devices to an sFlow collector
+*/
+func exportSflow(ctx context.Context, devices []core.PonSimInterface) {
+	agent, err := core.NewPonSimSflowAgent(sflow_addr, sflow_rate, time.Duration(sflow_interval)*time.Second)
+	if err != nil {
+		log.Fatalf("Invalid sFlow configuration: %s", err.Error())
+	}
+
+	for _, device := range devices {
+		switch d := device.(type) {
+		case *core.PonSimOltDevice:
+			agent.AddDevice(&d.PonSimDevice)
+		case *core.PonSimOnuDevice:
+			agent.AddDevice(&d.PonSimDevice)
+		}
+	}
+
+	agent.Start(ctx)
+}
+
+/*
+serveSnmp answers the SNMP requests about the devices
+*/
+func serveSnmp(ctx context.Context, devices []core.PonSimInterface) {
+	agent, err := core.NewPonSimSnmpAgent(snmp_addr, snmp_community, devices...)
+	if err != nil {
+		log.Fatalf("Invalid SNMP configuration: %s", err.Error())
+	}
+
+	agent.Start(ctx)
+}
+
+/*
+newRunInfo records the build, configuration and optional features of this simulator instance
+*/
+func newRunInfo() *core.PonSimRunInfo {
+	config := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		config[f.Name] = f.Value.String()
+	})
+
+	// Secrets are not disclosed
+	for _, secret := range []string{"api_token", "api_jwt_secret"} {
+		if config[secret] != "" {
+			config[secret] = "*"
+		}
+	}
+
+	info := core.NewPonSimRunInfo(version, commit, seed, config)
+
+	features := map[string]bool{
+		"afxdp":          bridge_io == core.BRIDGE_IO_AF_XDP,
+		"alarms":         alarm_sim,
+		"alarm_kafka":    alarm_sim && kafka_brokers != "" && alarm_topic != "",
+		"api_auth":       api_token != "" || api_jwt_secret != "",
+		"arp":            arp_bindings != "",
+		"audit":          audit != "",
+		"chaos":          chaos != "",
+		"checkpoint":     checkpoint != "",
+		"clock_drift":    clock_drift != 0,
+		"compression":    compression != common.COMPRESSION_NONE,
+		"debug":          debug_addr != "",
+		"dba":            dba_rate > 0,
+		"dedup":          dedup_window > 0,
+		"delay":          delay != "",
+		"dual":           device_type == core.DUAL.String(),
+		"faults":         faults != "",
+		"fec":            fec || pre_fec_ber > 0,
+		"flow_journal":   flow_journal != "",
+		"flow_store":     flow_store != "",
+		"frame_hash":     frame_hash,
+		"frame_logs":     frame_log_rate > 1 || frame_log_limit > 0,
+		"identity":       identity != "",
+		"inventory":      inventory != "",
+		"ipfix":          ipfix_addr != "",
+		"kpi":            kafka_brokers != "" && kpi_interval > 0,
+		"lag":            nni_lag != "",
+		"lldp":           lldp_interval > 0,
+		"metrics":        metrics_addr != "",
+		"nni_bridge":     nni_bridge != "",
+		"onu_activation": onu_activation != core.ONU_ACTIVATION_AUTO,
+		"onu_auth":       onu_auth != "",
+		"mtu":            mtu != "",
+		"onu_op_delay":   onu_op_delay > 0,
+		"optical_drift":  optical_drift > 0,
+		"padding":        response_size > 0,
+		"pcap_replay":    pcap_dir != "",
+		"pm":             pm_history > 0,
+		"pm_thresholds":  pm_thresholds != "",
+		"pon_ports":      pon_ports > 1,
+		"priorities":     priority_sched != "",
+		"rate_limit":     rate_limit != "",
+		"rest":           rest_port > 0 || child_rest_port > 0,
+		"sflow":          sflow_addr != "",
+		"snmp":           snmp_addr != "",
+		"shaping":        cir > 0 || pir > 0,
+		"sim_onus":       sim_onus > 0,
+		"socket":         grpc_socket != "",
+		"syslog":         syslog_addr != "",
+		"table_miss":     table_miss != core.TABLE_MISS_DROP,
+		"tracing":        trace_endpoint != "",
+		"uni_bridges":    uni_bridges != "",
+		"workers":        workers > 0,
+	}
+	for feature, enabled := range features {
+		if enabled {
+			info.EnableFeature(feature)
+		}
+	}
+
+	return info
+}
+
 func main() {
-	var device core.PonSimInterface
+	var devices []core.PonSimInterface
+
+	if grafana {
+		dashboard, err := core.NewGrafanaDashboard()
+		if err != nil {
+			log.Fatalf("Unable to generate the Grafana dashboard: %s", err.Error())
+		}
+		fmt.Println(string(dashboard))
+		return
+	}
 
 	// Init based on type of device
 	// Construct OLT/ONU object and pass it down
@@ -246,6 +1381,12 @@ func main() {
 		CaFile:   path.Join(voltha_base, voltha_ca),
 	}
 
+	// Seed the simulation so that a run can be reproduced
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rand.Seed(seed)
+
 	// Initialize device with common parameters
 	pon := core.PonSimDevice{
 		Name:        name,
@@ -255,50 +1396,292 @@ func main() {
 		SnapshotLen: snapshot_len,
 		Address:     grpc_addr,
 		Port:        int32(grpc_port),
+		RestPort:    int32(rest_port),
+		Socket:      grpc_socket,
 		AlarmsOn:    alarm_sim,
 		AlarmsFreq:  alarm_freq,
 		Counter:     core.NewPonSimMetricCounter(name),
+		PortStates:  core.NewPonSimPortStates(),
+		Events:      core.NewPonSimEventBus(),
+		FlowStats:   core.NewPonSimFlowStats(),
+		BootDelay:   time.Duration(boot_delay) * time.Second,
+		FrameHash:   frame_hash,
+		RunInfo:     newRunInfo(),
+		Jobs:        core.NewPonSimJobs(),
+		PcapDir:     pcap_dir,
+		Mirror:      core.NewPonSimMirror(),
+		Cfm:         core.NewPonSimCfm(),
 
 		// TODO: pass certificates
 		//GrpcSecurity: certs,
 	}
 
+	if delays, err := core.ParsePortDelays(delay); err != nil {
+		log.Fatalf("Invalid delay configuration: %s", err.Error())
+	} else {
+		pon.Delays = delays
+	}
+
+	if port_faults, err := core.ParsePortFaults(faults); err != nil {
+		log.Fatalf("Invalid fault configuration: %s", err.Error())
+	} else {
+		pon.Faults = port_faults
+	}
+
+	if port_mtus, err := core.ParsePortMtus(mtu); err != nil {
+		log.Fatalf("Invalid MTU configuration: %s", err.Error())
+	} else {
+		pon.Mtus = port_mtus
+	}
+
+	if device_inventory, err := core.ParseInventory(inventory); err != nil {
+		log.Fatalf("Invalid inventory configuration: %s", err.Error())
+	} else {
+		pon.Inventory = device_inventory
+	}
+
+	if device_identity, err := core.ParseIdentity(identity); err != nil {
+		log.Fatalf("Invalid identity configuration: %s", err.Error())
+	} else {
+		pon.Identity = device_identity
+	}
+
+	if clock, err := core.NewPonSimClock(clock_drift); err != nil {
+		log.Fatalf("Invalid clock configuration: %s", err.Error())
+	} else {
+		pon.Clock = clock
+	}
+
+	if device_audit, err := core.ParseAudit(audit); err != nil {
+		log.Fatalf("Invalid audit configuration: %s", err.Error())
+	} else {
+		pon.Audit = device_audit
+	}
+
+	pon.ApiAuth = core.NewPonSimApiAuth(api_token, api_jwt_secret)
+
+	if limits, err := common.NewGrpcLimits(
+		max_recv_msg_size, max_send_msg_size, initial_window_size, initial_conn_window_size,
+	); err != nil {
+		log.Fatalf("Invalid GRPC configuration: %s", err.Error())
+	} else {
+		common.SetGrpcLimits(limits)
+	}
+
+	if stream_compression, err := common.ParseCompression(compression); err != nil {
+		log.Fatalf("Invalid compression configuration: %s", err.Error())
+	} else {
+		pon.Compression = stream_compression
+	}
+
+	if device_table_miss, err := core.NewPonSimTableMiss(table_miss); err != nil {
+		log.Fatalf("Invalid table miss configuration: %s", err.Error())
+	} else {
+		pon.TableMiss = device_table_miss
+	}
+
+	if device_rate_limit, err := core.ParseRateLimit(rate_limit); err != nil {
+		log.Fatalf("Invalid rate limit configuration: %s", err.Error())
+	} else {
+		pon.RateLimit = device_rate_limit
+	}
+
+	pon.Chaos = core.NewPonSimChaos(time.Duration(chaos_interval) * time.Second)
+	if catalog, err := core.ParseChaosCatalog(chaos); err != nil {
+		log.Fatalf("Invalid chaos configuration: %s", err.Error())
+	} else {
+		pon.Chaos.Set(len(catalog) > 0, catalog)
+	}
+
+	brokers, err := common.ParseKafkaBrokers(kafka_brokers)
+	if err != nil {
+		log.Fatalf("Invalid Kafka configuration: %s", err.Error())
+	}
+
+	if len(brokers) > 0 && alarm_sim && alarm_topic != "" {
+		if producer, err := common.NewKafkaProducer(brokers, alarm_topic); err != nil {
+			log.Fatalf("Invalid Kafka configuration: %s", err.Error())
+		} else {
+			pon.AlarmSink = producer
+		}
+	}
+
+	if response_size > 0 {
+		pon.ResponseSize = response_size
+	}
+
+	if packet_io_config, err := core.NewPonSimPacketIO(packet_io, tunnels); err != nil {
+		log.Fatalf("Invalid packet I/O configuration: %s", err.Error())
+	} else {
+		pon.PacketIO = packet_io_config
+	}
+	if err := pon.PacketIO.SetBridgeIO(bridge_io); err != nil {
+		log.Fatalf("Invalid bridge I/O configuration: %s", err.Error())
+	}
+
+	if nni_bridge != "" {
+		if device_type != core.OLT.String() {
+			log.Fatalf("Invalid NNI bridge configuration: only an OLT has an NNI")
+		} else if err := pon.PacketIO.Bridge(internal_if, nni_bridge); err != nil {
+			log.Fatalf("Invalid NNI bridge configuration: %s", err.Error())
+		}
+	}
+	if uni_bridges != "" && device_type != core.OLT.String() {
+		log.Fatalf("Invalid UNI bridge configuration: only the ONUs simulated by an OLT have bridged UNIs")
+	}
+
+	if ipfix_addr != "" {
+		if device_type != core.OLT.String() {
+			log.Fatalf("Invalid flow export configuration: only an OLT has an NNI")
+		} else if exporter, err := core.NewPonSimIpfixExporter(
+			ipfix_addr,
+			ipfix_version,
+			time.Duration(ipfix_active)*time.Second,
+			time.Duration(ipfix_idle)*time.Second,
+		); err != nil {
+			log.Fatalf("Invalid flow export configuration: %s", err.Error())
+		} else {
+			pon.Ipfix = exporter
+		}
+	}
+
+	if flow_journal != "" {
+		pon.FlowJournal = core.NewPonSimFlowJournal(flow_journal)
+	}
+
+	if checkpoint != "" {
+		pon.Checkpoint = core.NewPonSimCheckpoint(checkpoint, time.Duration(checkpoint_interval)*time.Second)
+	}
+
+	baseline := core.PonSimDdmValues{Temperature: ddm_temp, Voltage: ddm_voltage, BiasCurrent: ddm_bias}
+	if diagnostics, err := core.NewPonSimDiagnostics(baseline, ddm_noise); err != nil {
+		log.Fatalf("Invalid diagnostics configuration: %s", err.Error())
+	} else {
+		pon.Diagnostics = diagnostics
+	}
+
+	if pm, err := core.NewPonSimPm(time.Duration(pm_interval)*time.Second, pm_history); err != nil {
+		log.Fatalf("Invalid PM configuration: %s", err.Error())
+	} else {
+		pon.Pm = pm
+	}
+
+	if thresholds, err := core.ParsePmThresholds(pm_thresholds); err != nil {
+		log.Fatalf("Invalid PM threshold configuration: %s", err.Error())
+	} else if len(thresholds) > 0 && pon.Pm == nil {
+		log.Fatalf("Invalid PM threshold configuration: thresholds require PM collection (pm_history)")
+	} else {
+		for _, threshold := range thresholds {
+			pon.Pm.SetThreshold(threshold)
+		}
+	}
+
+	if flow_store != "" {
+		if store, err := common.NewKVStore(flow_store); err != nil {
+			log.Fatalf("Invalid flow store configuration: %s", err.Error())
+		} else {
+			pon.FlowStore = core.NewPonSimFlowStore(store, pon.Name)
+		}
+	}
+
+	if dedup_window > 0 {
+		pon.Dedup = core.NewPonSimDedup(time.Duration(dedup_window) * time.Millisecond)
+	}
+
+	if workers > 0 {
+		pon.Workers = core.NewPonSimWorkerPool(workers, core.DEFAULT_WORKER_QUEUE_DEPTH)
+	}
+
+	if cir > 0 || pir > 0 {
+		pon.BandwidthProfile = &core.PonSimBandwidthProfile{Cir: cir, Pir: pir, Cbs: cbs, Pbs: pbs}
+	}
+
 	switch device_type {
 	case core.OLT.String():
-		device = core.NewPonSimOltDevice(pon)
-		device.(*core.PonSimOltDevice).MaxOnuCount = onus
-		device.(*core.PonSimOltDevice).VCoreEndpoint = vcore_endpoint
+		devices = append(devices, newOltDevice(pon))
+
+		if sim_onus > onus {
+			log.Fatalf("Invalid ONU simulation configuration: %d simulated ONUs exceed the %d ONUs of a PON port", sim_onus, onus)
+		}
+		bridges, err := core.ParseUniBridges(uni_bridges)
+		if err != nil {
+			log.Fatalf("Invalid UNI bridge configuration: %s", err.Error())
+		}
+		for onu := range bridges {
+			if onu > sim_onus*pon_ports {
+				log.Fatalf("Invalid UNI bridge configuration: there is no simulated ONU %d", onu)
+			}
+		}
+		for i := 0; i < sim_onus*pon_ports; i++ {
+			devices = append(devices, newSimOnuDevice(pon, i, bridges[i+1]))
+		}
 
 	case core.ONU.String():
-		device = core.NewPonSimOnuDevice(pon)
-		device.(*core.PonSimOnuDevice).ParentAddress = parent_addr
-		device.(*core.PonSimOnuDevice).ParentPort = int32(parent_port)
+		devices = append(devices, newOnuDevice(pon))
+
+	case core.DUAL.String():
+		// The ONU role registers with the parent OLT while the OLT role serves the child ONUs
+		olt := newOltDevice(newChildDevice(pon))
+		olt.(*core.PonSimOltDevice).Cascaded = true
+
+		devices = append(devices, newOnuDevice(pon), olt)
 
 	default:
 		log.Println("Unknown device type")
 	}
 
-	ps := PonSimService{device: device}
+	if metrics_addr != "" {
+		go serveMetrics(metrics_addr, devices)
+	}
+
+	if debug_addr != "" {
+		go serveDebug(debug_addr)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	ps.Start(ctx)
+	if len(brokers) > 0 && kpi_interval > 0 {
+		publishKpis(ctx, brokers, devices, pon.Clock)
+	}
 
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt)
+	if sflow_addr != "" {
+		exportSflow(ctx, devices)
+	}
 
-	doneCh := make(chan struct{})
+	if snmp_addr != "" {
+		serveSnmp(ctx, devices)
+	}
 
-	go func() {
-		for {
-			select {
-			case <-signals:
-				log.Println("Interrupt was detected")
-				doneCh <- struct{}{}
-			}
+	if pon.Ipfix != nil {
+		pon.Ipfix.Start(ctx)
+		defer pon.Ipfix.Stop()
+	}
+
+	if trace_endpoint != "" {
+		if tracer, err := common.NewTracer(trace_endpoint, trace_sampling); err != nil {
+			log.Fatalf("Invalid tracing configuration: %s", err.Error())
+		} else {
+			common.SetTracer(tracer)
+			tracer.Start(ctx)
 		}
-	}()
+	}
 
-	<-doneCh
+	var services []*PonSimService
+	for _, device := range devices {
+		ps := &PonSimService{device: device}
+		ps.Start(ctx)
+		services = append(services, ps)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	log.Printf("%s was detected", <-signals)
+
+	// The devices close their flow journals and capture files and save their last checkpoint
+	cancel()
+	for _, ps := range services {
+		ps.Stop(context.Background())
+	}
 }
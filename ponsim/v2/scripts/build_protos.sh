@@ -36,7 +36,7 @@ export COMMON_PB="\
     $SRC_DIR/meta.proto \
     $SRC_DIR/yang_options.proto"
 
-export PONSIM_PB="$SRC_DIR/ponsim_common.proto $SRC_DIR/ponsim_olt.proto"
+export PONSIM_PB="$SRC_DIR/ponsim_common.proto $SRC_DIR/ponsim_olt.proto $SRC_DIR/ponsim_onu.proto $SRC_DIR/ponsim_admin.proto"
 export SCHEMA_PB="$SRC_DIR/schema.proto"
 export IETF_PB="$SRC_DIR/ietf_interfaces.proto"
 export OF_PB="$SRC_DIR/openflow_13.proto"
@@ -61,3 +61,12 @@ do
     echo "Compiling $pbs"
     protoc --go_out=$MAPS,plugins=grpc:$GOPATH/src $INCS $pbs
 done
+
+# Reverse proxies exposing the PonSim services over REST/JSON
+export GATEWAY_PB="$SRC_DIR/ponsim.proto $SRC_DIR/ponsim_admin.proto"
+
+for pb in $GATEWAY_PB
+do
+    echo "Compiling gateway for $pb"
+    protoc --grpc-gateway_out=logtostderr=true:$GOPATH/src $INCS $pb
+done
@@ -432,9 +432,11 @@ class PonSimOltHandler(object):
         log.info('got-info', info=info)
 
         device.root = True
-        device.vendor = 'ponsim'
-        device.model = 'n/a'
-        device.serial_number = device.host_and_port
+        device.vendor = info.vendor or 'ponsim'
+        device.model = info.model or 'n/a'
+        device.hardware_version = info.hardware_version
+        device.firmware_version = info.firmware_version
+        device.serial_number = info.serial_number or device.host_and_port
         device.connect_status = ConnectStatus.REACHABLE
         self.adapter_agent.update_device(device)
 
@@ -632,6 +634,20 @@ class PonSimOltHandler(object):
                 raw_data = json.loads(pkt.getlayer(Raw).load)
                 self.alarms.send_alarm(self, raw_data)
 
+    def _rcv_packet_in(self, frame):
+        # The device already identified the logical port and the flow of
+        # the frames trapped or output to the controller by its flows
+        packet_in = frame.packet_in
+        kw = dict(
+            logical_device_id=self.logical_device_id,
+            logical_port_no=packet_in.logical_port,
+        )
+        self.log.info('sending-packet-in', in_port=packet_in.in_port,
+                      flow_id=packet_in.flow_id, cookie=packet_in.cookie,
+                      table_id=packet_in.table_id, reason=packet_in.reason,
+                      **kw)
+        self.adapter_agent.send_packet_in(packet=frame.payload, **kw)
+
     @inlineCallbacks
     def rcv_grpc(self):
         """
@@ -648,7 +664,10 @@ class PonSimOltHandler(object):
             for frame in self.frames:
                 self.log.info('received-grpc-frame',
                               frame_len=len(frame.payload))
-                self._rcv_frame(frame.payload)
+                if frame.HasField('packet_in'):
+                    self._rcv_packet_in(frame)
+                else:
+                    self._rcv_frame(frame.payload)
 
         except _Rendezvous, e:
             log.warn('grpc-connection-lost', message=e.message)